@@ -4,8 +4,16 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/Arceliar/phony"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
+
+	"burst_actors/simtest"
 )
 
 func TestActorSystem(t *testing.T) {
@@ -15,28 +23,891 @@ func TestActorSystem(t *testing.T) {
 	}
 }
 
-func TestProcessor(t *testing.T) {
-	actor := &Processor{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+// runUntil runs sys to until and stops the test if the run fails
+func runUntil(t *testing.T, sys *System, until time.Duration) Report {
+	t.Helper()
+	report, err := sys.RunUntil(until)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return report
 }
 
+func TestProcessor(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	h.AssertSendCount(sys.processor, 0)
+}
 
 func TestBurstGenerator(t *testing.T) {
-	actor := &BurstGenerator{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	h.AssertSendCount(sys.burstGenerator, 10)
+}
+
+func TestMessagesAreConserved(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	if err := sys.CheckConservation(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A buggy drop path that discards a message without counting it
+	sys.produce(sys.processor, "processor", func() {})
+	if err := sys.CheckConservation(); err == nil {
+		t.Fatal("expected an uncounted drop to fail the conservation check")
+	}
+}
+
+func TestFairQueueSharesByWeight(t *testing.T) {
+	q := NewFairQueue(3, 1)
+	for i := 0; i < 400; i++ {
+		q.Push(0, func() {})
+		q.Push(1, func() {})
+	}
+
+	served := make([]int, 2)
+	for i := 0; i < 400; i++ {
+		class, _, _ := q.Pop()
+		served[class]++
+	}
+
+	if served[0] != 300 || served[1] != 100 {
+		t.Fatalf("expected a 3:1 share under saturation, got %d:%d", served[0], served[1])
+	}
+}
+
+func TestMiddlewareWrapsHandlers(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	handled := map[string]int{}
+	sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		handled[ctx.Actor]++
+		next()
+	}))
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	if handled["burst_generator"] == 0 {
+		t.Fatal("expected middleware around burst_generator handlers")
+	}
+	for _, a := range sys.Report().Actors {
+		if a.Queue.Count > 0 && handled[a.Name] == 0 {
+			t.Errorf("expected middleware around the %d messages %s started on", a.Queue.Count, a.Name)
+		}
 	}
 }
 
+func TestActForwardsEnvelopes(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	handled := map[string]int{}
+	sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		if ctx.Payload == "interop" {
+			if ctx.Trace != 99 || ctx.Priority != 3 {
+				t.Errorf("expected %s to see the envelope's headers, got %+v", ctx.Actor, ctx.Headers)
+			}
+			handled[ctx.Actor]++
+		}
+		next()
+	}))
+	h := simtest.NewHarness(t, sys, clock)
+
+	env := Envelope[string]{Headers: Headers{Trace: 99, Priority: 3}, Kind: "batch", Payload: "interop"}
+	if err := Act(sys, "burst_generator", env); err != nil {
+		t.Fatal(err)
+	}
+	if err := Act(sys, "burst_generator", Envelope[string]{}); err == nil {
+		t.Fatal("expected burst_generator to refuse an envelope of no kind")
+	}
+	h.Advance(1000 * time.Millisecond)
+	if handled["burst_generator"] != 1 {
+		t.Fatalf("expected burst_generator to handle the envelope once, got %d", handled["burst_generator"])
+	}
+}
+
+func TestReconfigureLive(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+	// The report and its totals cover the actors there are at the time
+	totals := func() {
+		t.Helper()
+		var sent, received int
+		for _, a := range sys.actors {
+			m := a.Metrics()
+			sent, received = sent+m.SendCount, received+m.RecvCount
+		}
+		if n := len(sys.Report().Actors); n != len(sys.actors) {
+			t.Fatalf("expected a row for each of the %d actors, got %d", len(sys.actors), n)
+		}
+		if m := sys.SystemMetrics(); m.Sent != sent || m.Received != received {
+			t.Fatalf("expected %d sent and %d received in all, got %+v", sent, received, m)
+		}
+	}
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	before := sys.burstGenerator.SendCount()
+	err := sys.Reconfigure(&Spec{
+		Spawn:     map[string]string{"processor_spawned": "processor"},
+		Connect:   []Edge{{From: "burst_generator", To: "processor_spawned"}},
+		Intervals: map[string]time.Duration{"burst_generator": 500 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	// One more target, sent to at twice the rate
+	after := sys.burstGenerator.SendCount() - before
+	if after <= before {
+		t.Fatalf("burst_generator sent %d messages after reconfiguring, %d before", after, before)
+	}
+	totals()
+	if err := sys.Reconfigure(&Spec{Connect: []Edge{{From: "burst_generator", To: "missing"}}}); err == nil {
+		t.Fatal("expected an error connecting to an unknown actor")
+	}
+}
+
+func TestWriteDOTShowsWiring(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	var b strings.Builder
+	if err := sys.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+
+	names := map[any]string{}
+	for name, a := range sys.actors {
+		names[a] = name
+		if !strings.Contains(dot, fmt.Sprintf("\t%q\n", name)) {
+			t.Errorf("expected a node for %s", name)
+		}
+	}
+	var wired []string
+	for _, to := range sys.burstGenerator.targets {
+		wired = append(wired, fmt.Sprintf("%q -> %q [", "burst_generator", names[to]))
+	}
+	for _, edge := range wired {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("expected the graph to show %s]", edge)
+		}
+	}
+	if n := strings.Count(dot, " -> "); n != len(wired) {
+		t.Fatalf("expected the %d edges NewSystem wires, got %d", len(wired), n)
+	}
+}
+
+func TestForkBranchesRun(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+	runUntil(t, sys, 500*time.Millisecond)
+	snap := sys.Snapshot()
+	want := runUntil(t, sys, 2000*time.Millisecond).String()
+
+	// A fork replays the run up to the snapshot, so it goes on the same way
+	same := Fork(snap)
+	if got := runUntil(t, same, 2000*time.Millisecond).String(); got != want {
+		t.Fatalf("expected the fork to go on like the original run, got\n%s\nwant\n%s", got, want)
+	}
+	faster := Fork(snap)
+	spec := &Spec{Intervals: map[string]time.Duration{"burst_generator": 500 * time.Millisecond}}
+	if err := faster.Reconfigure(spec); err != nil {
+		t.Fatal(err)
+	}
+	reconfigured := faster.Snapshot()
+	// Reconfigure keeps its own copy of the spec for forks to replay
+	spec.Intervals["burst_generator"] = 2000 * time.Millisecond
+
+	got := runUntil(t, faster, 2000*time.Millisecond)
+	t.Log("\n" + Compare(map[string]Report{"same": same.Report(), "faster": got}))
+	if faster.burstGenerator.SendCount() <= same.burstGenerator.SendCount() {
+		t.Fatalf("expected burst_generator to send more once it sends faster, got %d, before %d", faster.burstGenerator.SendCount(), same.burstGenerator.SendCount())
+	}
+	if again := runUntil(t, Fork(reconfigured), 2000*time.Millisecond).String(); again != got.String() {
+		t.Fatalf("expected a fork to replay the spec as applied, got\n%s\nwant\n%s", again, got)
+	}
+}
+
+func TestDiffRunsFindsChangedInterval(t *testing.T) {
+	if d := DiffRuns(1, 3000*time.Millisecond, nil, nil); d.Diverged() {
+		t.Fatalf("expected two runs of one seed to be alike, got\n%s", d)
+	}
+	slower := func(s *System) {
+		if err := s.Reconfigure(&Spec{Intervals: map[string]time.Duration{"burst_generator": 2000 * time.Millisecond}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := DiffRuns(1, 3000*time.Millisecond, nil, slower)
+	t.Log("\n" + d.String())
+	if !d.Diverged() || d.Left == nil || d.Left.Actor != "burst_generator" || d.Left.At != 2000*time.Millisecond {
+		t.Fatalf("expected the runs to diverge at the 2000ms tick of burst_generator, got\n%s", d)
+	}
+	for _, c := range d.Counts {
+		if c.Actor == "burst_generator" && c.Message == "batch" && c.Right >= c.Left {
+			t.Fatalf("expected burst_generator to produce fewer messages once it sends slower, got %d, before %d", c.Right, c.Left)
+		}
+	}
+}
+
+func TestPartitionHeals(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+	plan := []PartitionStep{{At: 0, Heal: 1000 * time.Millisecond, Groups: [][]string{{"burst_generator"}}}}
+	if err := sys.SchedulePartitions(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Advance(1000 * time.Millisecond)
+	cut := sys.PartitionedCount()
+	if cut == 0 {
+		t.Fatal("expected the partition to drop what burst_generator sends")
+	}
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	if n := sys.PartitionedCount(); n != cut {
+		t.Fatalf("expected no drops once healed, got %d more", n-cut)
+	}
+	if err := sys.CheckConservation(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBurstGeneratorRaisesAlarms(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+	runUntil(t, sys, 1100*time.Millisecond)
+
+	alarms := sys.burstGenerator.Alarms()
+	t.Logf("burst_generator raised %v", alarms)
+	if len(alarms) == 0 {
+		t.Fatal("expected a burst of 10 sends within 100ms to cross 50/s")
+	}
+	for _, alarm := range alarms {
+		if alarm.Metric != "send_rate" || !(alarm.Value > 50) {
+			t.Errorf("expected alarms on a send rate above 50/s, got %v", alarm)
+		}
+		if alarm.At%(100*time.Millisecond) != 0 {
+			t.Errorf("expected alarms as 100ms windows close, got one at %v", alarm.At)
+		}
+	}
+}
+
+func TestTraceSampling(t *testing.T) {
+	run := func() (int64, uint64, map[uint64]int) {
+		seen := map[uint64]int{}
+		sys := NewSystem(1, NewVirtualClock())
+		sys.SetTraceSample(0.01)
+		sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+			if ctx.Trace != 0 {
+				seen[ctx.Trace]++
+			}
+			next()
+		}))
+		sys.Start()
+		sys.Advance(1000000 * time.Millisecond)
+		return sys.ledger.produced.Load(), sys.traces.Load(), seen
+	}
+
+	produced, traces, seen := run()
+	if _, again, _ := run(); again != traces {
+		t.Fatalf("expected the same seed to sample the same messages, got %d and %d traces", traces, again)
+	}
+	want := float64(produced) * 0.01
+	if diff := float64(traces) - want; diff < -0.3*want || diff > 0.3*want {
+		t.Fatalf("expected about %.0f of %d messages traced, got %d", want, produced, traces)
+	}
+	if len(seen) != int(traces) {
+		t.Fatalf("expected handlers to see all %d traces, saw %d", traces, len(seen))
+	}
+
+	runs := 0
+	for _, n := range seen {
+		runs += n
+	}
+	if runs <= len(seen) {
+		t.Fatal("expected traces to follow messages to their targets")
+	}
+}
+
+func TestMessageIDsAreReproducible(t *testing.T) {
+	run := func() (string, uint64, int64) {
+		clock := NewVirtualClock()
+		sys := NewSystem(1, clock)
+		var ids []string
+		sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+			ids = append(ids, ctx.ID.String())
+			next()
+		}))
+		h := simtest.NewHarness(t, sys, clock)
+
+		h.Advance(1000 * time.Millisecond)
+		h.DrainQuiescent()
+		return strings.Join(ids, " "), sys.NextID(), sys.ledger.produced.Load()
+	}
+
+	ids, next, produced := run()
+	if again, _, _ := run(); again != ids {
+		t.Fatal("expected the same seed to allocate the same message IDs")
+	}
+	// One ID per produced message, none skipped
+	if next != uint64(produced)+1 {
+		t.Fatalf("expected ID %d after %d messages, got %d", produced+1, produced, next)
+	}
+}
+
+// sleepingBurstGeneratorCallbacks models a 10ms query in every callback
+type sleepingBurstGeneratorCallbacks struct {
+	*DefaultBurstGeneratorCallbacks
+}
+
+func (c sleepingBurstGeneratorCallbacks) OnBatch() error {
+	c.Ctx.SleepVirtual(10 * time.Millisecond)
+	return nil
+}
+
+func TestCallbacksSleepInVirtualTime(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+	phony.Block(sys.burstGenerator, func() {
+		defaults := sys.burstGenerator.callbacks.(*DefaultBurstGeneratorCallbacks)
+		sys.burstGenerator.callbacks = sleepingBurstGeneratorCallbacks{defaults}
+	})
+
+	// The first message is produced now but sent on only after its query
+	h.Advance(1000 * time.Millisecond)
+	if n := sys.burstGenerator.SendCount(); n != 0 {
+		t.Fatalf("expected sends to wait for the query, got %d", n)
+	}
+	h.Advance(10 * time.Millisecond)
+	if n := sys.burstGenerator.SendCount(); n != 10 {
+		t.Fatalf("expected a send count of 10 after the 10ms query, got %d", n)
+	}
+}
+
+func TestLogsAreSampled(t *testing.T) {
+	suppressed := func(every int) int {
+		sys := NewSystem(1, NewVirtualClock())
+		if err := sys.SetLogEvery(every); err != nil {
+			t.Fatal(err)
+		}
+		sys.Start()
+		runUntil(t, sys, 1000*time.Millisecond)
+		return sys.SuppressedLogs()
+	}
+
+	if n := suppressed(1); n != 0 {
+		t.Fatalf("expected every line logged, got %d suppressed", n)
+	}
+	// Every actor still logs its first line
+	if n := suppressed(1 << 30); n == 0 {
+		t.Fatal("expected sampling to leave out all but the first line of each actor")
+	}
+	if err := NewSystem(1, NewVirtualClock()).SetLogEvery(0); err == nil {
+		t.Fatal("expected an error logging one in every 0 lines")
+	}
+}
+
+func TestReportSumsUpRun(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+
+	report := runUntil(t, sys, 1000*time.Millisecond)
+	t.Log("\n" + report.String())
+	if len(report.Actors) != 2 {
+		t.Fatalf("expected a row for each of the 2 actors, got %d", len(report.Actors))
+	}
+	want := map[string]int{"processor": 10, "burst_generator": 0}
+	for _, a := range report.Actors {
+		if n, ok := want[a.Name]; ok && a.Received != n {
+			t.Errorf("expected %s to receive %d messages, got %d", a.Name, n, a.Received)
+		}
+		if a.P50 > a.P99 {
+			t.Errorf("expected %s's p50 latency %v within its p99 %v", a.Name, a.P50, a.P99)
+		}
+		if m := sys.actors[a.Name].Metrics(); m.SendCount != a.Sent || m.RecvCount != a.Received {
+			t.Errorf("expected %s's metrics to match its %d sent and %d received, got %+v", a.Name, a.Sent, a.Received, m)
+		}
+	}
+}
+
+func TestSystemMetricsAddUpActors(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+
+	report := runUntil(t, sys, 1000*time.Millisecond)
+	m := sys.SystemMetrics()
+	t.Logf("%+v", m)
+	if m.At != 1000*time.Millisecond {
+		t.Errorf("expected the metrics at 1000ms, got %v", m.At)
+	}
+	var want SystemMetrics
+	want.At = m.At
+	for _, a := range report.Actors {
+		want.Sent += a.Sent
+		want.Received += a.Received
+		want.Dropped += a.Dropped
+		want.Expired += a.Expired
+		if a.PeakQueue > want.PeakQueue {
+			want.PeakQueue = a.PeakQueue
+		}
+	}
+	if m != want {
+		t.Errorf("expected the totals of the report's rows %+v, got %+v", want, m)
+	}
+	if m.Received != 10 {
+		t.Errorf("expected 10 messages received in all, got %d", m.Received)
+	}
+}
+
+func TestDebuggerStepsAndBreaks(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	d := NewDebugger(sys)
+	sys.Start()
+
+	if _, ok := d.Step(); !ok {
+		t.Fatal("expected a timer to step over")
+	}
+	d.Break("processor", "", func(a ActorReport) bool { return a.Received >= 5 })
+	hit, ok := d.Continue(1000 * time.Millisecond)
+	if !ok {
+		t.Fatal("expected to break once processor received 5 messages by 1000ms")
+	}
+	if n := d.Inspect("processor").Received; n < 5 {
+		t.Fatalf("expected processor to have received 5 messages at the breakpoint, got %d", n)
+	}
+
+	// Stepping back to the start forks the run afresh, which goes on the same way
+	d.Back(int(d.System().clock.(*VirtualClock).Steps()))
+	if n := d.Inspect("processor").Received; n >= 5 {
+		t.Fatalf("expected stepping back to undo what processor received, got %d messages", n)
+	}
+	if again, ok := d.Continue(1000 * time.Millisecond); !ok || again != hit {
+		t.Fatalf("expected the fork to break on %v again, got %v", hit, again)
+	}
+}
+
+func TestQueueDepthPlotted(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	sys.SampleQueueDepth(20 * time.Millisecond)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	var samples, plot strings.Builder
+	if err := sys.WriteQueueDepthCSV(&samples); err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Count(samples.String(), "\n"); rows != 1+50 {
+		t.Fatalf("expected a header and 50 samples, got %d rows", rows)
+	}
+	if err := sys.PlotQueueDepth(&plot); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range sys.Select(nil) {
+		if !strings.Contains(plot.String(), "\n"+name+" ") {
+			t.Fatalf("expected a row for %s in\n%s", name, plot.String())
+		}
+	}
+}
+
+func TestProgressReportedEveryInterval(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	var progress []ProgressInfo
+	sys.OnProgress(100*time.Millisecond, func(p ProgressInfo) {
+		progress = append(progress, p)
+	})
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	if len(progress) != 10 {
+		t.Fatalf("expected 10 snapshots, one every 100ms, got %d", len(progress))
+	}
+	var last int64
+	for i, p := range progress {
+		if want := time.Duration(i+1) * 100 * time.Millisecond; p.At != want {
+			t.Fatalf("expected snapshot %d at %v, got %v", i, want, p.At)
+		}
+		if p.Processed < last {
+			t.Fatalf("expected the messages processed to grow, went from %d to %d at %v", last, p.Processed, p.At)
+		}
+		if rate := float64(p.Processed-last) / (100 * time.Millisecond).Seconds(); p.Rate != rate {
+			t.Fatalf("expected a rate of %v at %v, got %v", rate, p.At, p.Rate)
+		}
+		last = p.Processed
+	}
+}
+
+func TestReportCodecsRoundTrip(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+	want := runUntil(t, sys, 1000*time.Millisecond).String()
+
+	for name, codec := range Codecs {
+		var saved strings.Builder
+		if err := sys.SaveReport(&saved, codec); err != nil {
+			t.Fatal(err)
+		}
+		report, err := LoadReport(strings.NewReader(saved.String()), codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := report.String(); got != want {
+			t.Errorf("expected the %s codec to load the report it saved, got\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestReportJSONFlagsLatencyRegressions(t *testing.T) {
+	run := func() []byte {
+		sys := NewSystem(1, NewVirtualClock())
+		sys.Start()
+		runUntil(t, sys, 1000*time.Millisecond)
+		return sys.ReportJSON()
+	}
+	data := run()
+	if again := run(); string(again) != string(data) {
+		t.Fatalf("expected runs from the same seed to report the same JSON, got\n%s\nwant\n%s", again, data)
+	}
+	base, err := ParseReportJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Seed != 1 || len(base.Actors) != 2 || !base.Invariants.Conserved {
+		t.Fatalf("expected a report on all 2 actors from seed 1, conserving messages, got\n%s", data)
+	}
+	if regressions := LatencyRegressions(base, base, 0.05); len(regressions) != 0 {
+		t.Fatalf("expected no regressions of a report on itself, got %v", regressions)
+	}
+
+	base.Actors[0].LatencyP99Ns = int64(100 * time.Millisecond)
+	head, err := ParseReportJSON(base.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.Actors[0].LatencyP99Ns = int64(104 * time.Millisecond)
+	if regressions := LatencyRegressions(base, head, 0.05); len(regressions) != 0 {
+		t.Fatalf("expected a 4%% slower p99 to stay within 5%%, got %v", regressions)
+	}
+	head.Actors[0].LatencyP99Ns = int64(106 * time.Millisecond)
+	regressions := LatencyRegressions(base, head, 0.05)
+	if len(regressions) != 1 || regressions[0].Quantile != "p99" || regressions[0].Actor != base.Actors[0].Name {
+		t.Fatalf("expected a 6%% slower p99 of %s to regress, got %v", base.Actors[0].Name, regressions)
+	}
+	if _, err := ParseReportJSON([]byte(`{"actors": []}`)); err == nil {
+		t.Fatal("expected a report without a schema version to be refused")
+	}
+}
+
+func TestMetricsPublished(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.PublishMetrics()
+
+	v := metrics.Get("processor")
+	if v == nil || !strings.Contains(v.String(), "sendCount") {
+		t.Fatalf("expected processor counters in expvar, got %v", v)
+	}
+}
+
+func TestMetricSinkRecordsActorMetrics(t *testing.T) {
+	recorder := NewMetricRecorder()
+	sys := NewSystem(1, NewVirtualClock(), WithMetricSink(recorder))
+	sys.Start()
+	report := runUntil(t, sys, 1000*time.Millisecond)
+
+	for _, a := range report.Actors {
+		labels := map[string]string{"actor": a.Name}
+		if n := recorder.CounterValue("actor_messages_received_total", labels); int(n) != a.Received {
+			t.Errorf("%s: recorded %v messages received, report has %d", a.Name, n, a.Received)
+		}
+		if n := len(recorder.Observations("actor_message_latency_seconds", labels)); n != a.Received {
+			t.Errorf("%s: recorded %d latencies, report has %d messages received", a.Name, n, a.Received)
+		}
+		if n := len(recorder.Observations("actor_service_time_seconds", labels)); n != a.Service.Count {
+			t.Errorf("%s: recorded %d service times, report has %d", a.Name, n, a.Service.Count)
+		}
+	}
+}
+
+func TestPrometheusSinkWritesText(t *testing.T) {
+	prometheus := NewPrometheusSink()
+	sys := NewSystem(1, NewVirtualClock(), WithMetricSink(prometheus))
+	sys.Start()
+	report := runUntil(t, sys, 1000*time.Millisecond)
+
+	var out strings.Builder
+	if err := prometheus.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	// A system fed only from outside, e.g. by Kafka sources, receives nothing
+	received := 0
+	for _, a := range report.Actors {
+		received += a.Received
+	}
+	if received > 0 && !strings.Contains(out.String(), "# TYPE actor_messages_received_total counter") {
+		t.Fatalf("expected the received counter type, got:\n%s", out.String())
+	}
+	for _, a := range report.Actors {
+		series := fmt.Sprintf("actor_messages_received_total{actor=%q} %d\n", a.Name, a.Received)
+		if a.Received > 0 && !strings.Contains(out.String(), series) {
+			t.Errorf("expected %q, got:\n%s", series, out.String())
+		}
+	}
+}
+
+func TestTraceSinkRecordsDeliveries(t *testing.T) {
+	recorder := NewTraceRecorder()
+	var lines strings.Builder
+	jsonl := NewJSONLSink(&lines)
+	sys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder), WithTraceSink(jsonl))
+	sys.Start()
+	runUntil(t, sys, 3000*time.Millisecond)
+
+	names := map[any]string{}
+	for name, a := range sys.actors {
+		names[a] = name
+	}
+	wired := map[string]bool{}
+	for _, to := range sys.burstGenerator.targets {
+		wired["burst_generator -> "+names[to]] = true
+	}
+	events := recorder.Events()
+	var last time.Duration
+	for _, e := range events {
+		if e.Time < last {
+			t.Fatalf("expected events in time order, got %v after %v", e.Time, last)
+		}
+		last = e.Time
+		switch edge := e.From + " -> " + e.To; {
+		case e.From == "" && e.ID.Source != e.To:
+			t.Fatalf("recorded %s %s at %s without the actor that sent it", e.Message, e.ID, e.To)
+		case e.From != "" && !wired[edge]:
+			t.Fatalf("recorded %s %s along %s, which NewSystem doesn't wire", e.Message, e.ID, edge)
+		}
+	}
+	if len(events) == 0 {
+		t.Fatal("expected a trace of the messages the sources sent")
+	}
+	burstGeneratorBursts := map[time.Duration]int{}
+	for _, e := range events {
+		if e.From == "" && e.To == "burst_generator" && e.Message == "batch" {
+			burstGeneratorBursts[e.Time]++
+		}
+	}
+	if len(burstGeneratorBursts) != 3 {
+		t.Fatalf("expected burst_generator to burst 3 times, got %v", burstGeneratorBursts)
+	}
+	for at, n := range burstGeneratorBursts {
+		if n != 10 {
+			t.Fatalf("expected 10 batch messages from burst_generator at %v, got %d", at, n)
+		}
+	}
+
+	if err := jsonl.Err(); err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(strings.NewReader(lines.String()))
+	n := 0
+	for ; decoder.More(); n++ {
+		var e TraceEvent
+		if err := decoder.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if n >= len(events) || e != events[n] {
+			t.Fatalf("expected line %d to read back as the event recorded, got %+v", n+1, e)
+		}
+	}
+	if n != len(events) {
+		t.Fatalf("expected a line per event recorded, %d, got %d", len(events), n)
+	}
+}
+
+func TestRenderMermaidListsTracedActors(t *testing.T) {
+	recorder := NewTraceRecorder()
+	sys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder))
+	sys.Start()
+	runUntil(t, sys, 3000*time.Millisecond)
+	events := recorder.Events()
+	if len(events) == 0 {
+		t.Fatal("expected a trace of the messages the sources sent")
+	}
+
+	var b strings.Builder
+	if err := RenderMermaid(events, &b, MermaidWindow{Max: 20}); err != nil {
+		t.Fatal(err)
+	}
+	diagram := b.String()
+	if !strings.HasPrefix(diagram, "sequenceDiagram\n") {
+		t.Fatalf("expected a sequence diagram, got:\n%s", diagram)
+	}
+	traced := map[string]bool{}
+	sent := 0
+	for _, e := range events {
+		traced[e.To] = true
+		if e.From != "" {
+			traced[e.From] = true
+			sent++
+		}
+	}
+	listed := map[string]bool{}
+	arrows := 0
+	for _, line := range strings.Split(diagram, "\n") {
+		if name, ok := strings.CutPrefix(line, "    participant "); ok {
+			listed[name] = true
+		}
+		if strings.Contains(line, "->>") {
+			arrows++
+		}
+	}
+	if fmt.Sprint(listed) != fmt.Sprint(traced) {
+		t.Fatalf("expected a participant per actor in the trace, %v, got %v", traced, listed)
+	}
+	if arrows != min(sent, 20) {
+		t.Fatalf("expected %d arrows of the %d messages sent, got %d", min(sent, 20), sent, arrows)
+	}
+	if sent > 20 && !strings.Contains(diagram, fmt.Sprintf(": %d more messages left out", sent-20)) {
+		t.Fatalf("expected a note on the %d arrows the cap left out, got:\n%s", sent-20, diagram)
+	}
+}
+
+func TestRealClockSpeed(t *testing.T) {
+	clock := NewRealClockWithSpeed(100)
+	fired := make(chan struct{})
+	clock.AfterFunc(time.Second, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("expected a 1s timer to fire within 1s of real time at 100x speed")
+	}
+	if now := clock.Now(); now < time.Second {
+		t.Fatalf("expected the clock to read at least 1s once the timer fired, got %v", now)
+	}
+}
+
+func TestRealClockRunIsRaceFree(t *testing.T) {
+	sys := NewSystem(1, NewRealClockWithSpeed(100))
+	sys.Start()
+	defer sys.Stop()
+
+	reports := make(chan Report)
+	for i := 0; i < 4; i++ {
+		go func() {
+			var report Report
+			for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+				report = sys.Report()
+				sys.Pending()
+			}
+			reports <- report
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if report := <-reports; len(report.Actors) != 2 {
+			t.Fatalf("expected a row for each of the 2 actors, got %d", len(report.Actors))
+		}
+	}
+}
+
+// Stop leaves nothing running once it returns: it cancels the timers still
+// pending and waits for the rest, and an idle Phony inbox holds no goroutine
+func TestStopReleasesGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	sys := NewSystem(1, NewRealClockWithSpeed(100))
+	sys.Start()
+	time.Sleep(50 * time.Millisecond)
+	sys.Stop()
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("expected %d goroutines once stopped, as before Start, got %d", baseline, n)
+	}
+}
+
+// quiet sends what the actors print to os.DevNull until b is done, so
+// their lines don't break up the benchmark results
+func quiet(b *testing.B) {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = null
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		null.Close()
+	})
+}
+
+// BenchmarkActorSystem runs b.N ticks of 1000ms, the fastest send
+// pattern's, through the generated actors on a VirtualClock, with their
+// loss, delays and accounting, and reports the messages all actors handle
+// per second
+// Run it with go test -run ^$ -bench ActorSystem -benchmem
+func BenchmarkActorSystem(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	if _, err := sys.RunUntil(time.Duration(b.N) * 1000 * time.Millisecond); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(sys.SystemMetrics().Received)/time.Since(start).Seconds(), "msgs/s")
+}
+
+// BenchmarkProcessor acts b.N batch messages into processor at one
+// virtual instant and reports the messages per second its handler gets
+// through, along with whatever it sets off downstream by then
+func BenchmarkProcessor(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := Act(sys, "processor", Envelope[string]{Kind: "batch"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	sys.Advance(0)
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+// BenchmarkBurstGenerator acts b.N batch messages into burst_generator at one
+// virtual instant and reports the messages per second its handler gets
+// through, along with whatever it sets off downstream by then
+func BenchmarkBurstGenerator(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := Act(sys, "burst_generator", Envelope[string]{Kind: "batch"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	sys.Advance(0)
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
@@ -0,0 +1,50 @@
+// Generated from ActorSimulation DSL
+// Send rate alarms
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"time"
+)
+
+// Alarm is a threshold an actor's send rate crossed: the metric, its value
+// in messages per second over the window that crossed it, and when that
+// window closed
+type Alarm struct {
+	Metric string
+	Value  float64
+	At     time.Duration
+}
+
+// rateAlarm watches how fast an actor sends over consecutive windows of
+// clock time, raising an alarm once each time the rate rises above its
+// upper threshold or falls below its lower one, in messages per second
+// A zero threshold is not checked
+// Only the actor's own inbox touches it
+type rateAlarm struct {
+	window time.Duration
+	above  float64
+	below  float64
+	sent   int
+	high   bool
+	low    bool
+	raised []Alarm
+}
+
+// check closes the window ending at now, by which the actor had sent sent
+// messages in all, and returns the alarm it raised, if any
+func (r *rateAlarm) check(sent int, now time.Duration) (Alarm, bool) {
+	rate := float64(sent-r.sent) / r.window.Seconds()
+	r.sent = sent
+	high := r.above > 0 && rate > r.above
+	low := r.below > 0 && rate < r.below
+	crossed := high && !r.high || low && !r.low
+	r.high, r.low = high, low
+	if !crossed {
+		return Alarm{}, false
+	}
+	alarm := Alarm{Metric: "send_rate", Value: rate, At: now}
+	r.raised = append(r.raised, alarm)
+	return alarm, true
+}
@@ -10,17 +10,28 @@ import (
 )
 
 // BurstGeneratorCallbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type BurstGeneratorCallbacks interface {
-	OnBatch()
+	OnBatch() error
+	OnAlarm(metric string, value float64) error
 }
 
+// BurstGeneratorTarget is implemented by every actor BurstGenerator sends to
+type BurstGeneratorTarget interface {
+	phony.Actor
+	Batch()
+}
 
 type BurstGenerator struct {
 	phony.Inbox
-	targets []*BurstGenerator
-	callbacks BurstGeneratorCallbacks
-	sendCount int
+	sys *System
+	messageContext
+	targets    []BurstGeneratorTarget
+	callbacks  BurstGeneratorCallbacks
+	ctx        Context
+	copiesSent int
+	alarm      rateAlarm
 }
 
 func (a *BurstGenerator) Actor() *phony.Inbox {
@@ -28,24 +39,139 @@ func (a *BurstGenerator) Actor() *phony.Inbox {
 }
 
 func (a *BurstGenerator) Start() {
-	a.callbacks = &DefaultBurstGeneratorCallbacks{}
-	go func() {
-		ticker := time.NewTicker(1000 * time.Millisecond)
-		defer ticker.Stop()
-		for range ticker.C {
-			for i := 0; i < 10; i++ {
-				a.Act(nil, func() { a.Batch() })
-			}
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultBurstGeneratorCallbacks{Ctx: &a.ctx}
+	a.sys.every(a, 1000*time.Millisecond, func() {
+		for i := 0; i < 10; i++ {
+			a.sys.produce(a, "burst_generator", a.Batch)
 		}
-	}()
+	})
+	a.alarm = rateAlarm{window: 100 * time.Millisecond, above: 50}
+	a.sys.watch(a, a.alarm.window, a.checkAlarm)
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *BurstGenerator) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *BurstGenerator) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *BurstGenerator) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *BurstGenerator) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *BurstGenerator) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// restart replaces the callbacks a crash lost with fresh ones, restored
+// from the last state they persisted, if any
+func (a *BurstGenerator) restart() {
+	a.ctx.sleep = 0
+	a.callbacks = &DefaultBurstGeneratorCallbacks{Ctx: &a.ctx}
+	if r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+		r.Restore(a.ctx.persisted)
+	}
+}
+
+// Alarms returns the alarms this actor raised, in the order it raised
+// them
+// Safe to call from outside the actor
+func (a *BurstGenerator) Alarms() []Alarm {
+	var alarms []Alarm
+	phony.Block(a, func() { alarms = append([]Alarm(nil), a.alarm.raised...) })
+	return alarms
+}
+
+// checkAlarm checks the send rate over the window that just closed
+func (a *BurstGenerator) checkAlarm() {
+	if alarm, ok := a.alarm.check(a.copiesSent, a.sys.clock.Now()); ok {
+		if err := a.callbacks.OnAlarm(alarm.Metric, alarm.Value); err != nil {
+			a.sys.halt("burst_generator", err)
+		}
+	}
+}
+
+// accepts reports whether the actor to handles every message BurstGenerator sends
+func (a *BurstGenerator) accepts(to phony.Actor) bool {
+	_, ok := to.(BurstGeneratorTarget)
+	return ok
+}
+
+// connect adds an edge to to
+func (a *BurstGenerator) connect(to phony.Actor) {
+	a.targets = append(a.targets, to.(BurstGeneratorTarget))
+}
+
+// disconnect removes every edge to to
+func (a *BurstGenerator) disconnect(to phony.Actor) {
+	for i := len(a.targets) - 1; i >= 0; i-- {
+		if phony.Actor(a.targets[i]) == to {
+			a.targets = append(a.targets[:i], a.targets[i+1:]...)
+		}
+	}
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *BurstGenerator) handler(kind string) (func(), bool) {
+	switch kind {
+	case "batch":
+		return a.Batch, true
+	}
+	return nil, false
 }
 
 func (a *BurstGenerator) Batch() {
-	a.callbacks.OnBatch()
+	a.sys.middleware.Handle(HandlerContext{Actor: "burst_generator", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "batch", a.handleBatch)
+}
+
+func (a *BurstGenerator) handleBatch() {
+	if err := a.callbacks.OnBatch(); err != nil {
+		a.sys.fail("burst_generator", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishBatch)
+}
+
+func (a *BurstGenerator) finishBatch() {
 	// Send to targets
+	a.sys.forwarded(len(a.targets))
 	for _, target := range a.targets {
-		target.Act(a, func() { target.Batch() })
+		target := target
+		a.sys.send(a, target, func() { target.Batch() })
+		a.copiesSent++
 	}
-	a.sendCount++
 }
-
@@ -8,13 +8,22 @@ import (
 	"fmt"
 )
 
-
 // DefaultBurstGeneratorCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultBurstGeneratorCallbacks struct{}
+type DefaultBurstGeneratorCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
-func (c *DefaultBurstGeneratorCallbacks) OnBatch() {
+func (c *DefaultBurstGeneratorCallbacks) OnBatch() error {
 	// TODO: Implement custom behavior for batch
-	fmt.Printf("BurstGenerator: Sending batch message\n")
+	c.Ctx.Logf("BurstGenerator: Sending batch message\n")
+	return nil
 }
 
+func (c *DefaultBurstGeneratorCallbacks) OnAlarm(metric string, value float64) error {
+	// TODO: Implement custom alerting
+	fmt.Printf("BurstGenerator: Alarm on %s at %.1f/s\n", metric, value)
+	return nil
+}
@@ -0,0 +1,324 @@
+// Generated from ActorSimulation DSL
+// Real and virtual time for the actor system
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Clock schedules work in real or virtual time
+// Now reports the time elapsed since the clock was created
+type Clock interface {
+	Now() time.Duration
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a scheduled AfterFunc call that can be cancelled
+type Timer interface {
+	Stop() bool
+}
+
+// RealClock runs timers on the wall clock, sped up or slowed down by its
+// speed factor
+type RealClock struct {
+	start time.Time
+	speed float64
+}
+
+// NewRealClock creates a clock starting now that keeps wall-clock time
+func NewRealClock() *RealClock {
+	return NewRealClockWithSpeed(1)
+}
+
+// NewRealClockWithSpeed creates a clock starting now that runs speed times
+// as fast as the wall clock: at 2 a 100ms interval takes 50ms
+func NewRealClockWithSpeed(speed float64) *RealClock {
+	return &RealClock{start: time.Now(), speed: speed}
+}
+
+// Now is scaled like the timers, so it agrees with the intervals they run at
+func (c *RealClock) Now() time.Duration {
+	return time.Duration(float64(time.Since(c.start)) * c.speed)
+}
+
+func (c *RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(time.Duration(float64(d)/c.speed), f)
+}
+
+// VirtualClock only moves when Advance is called
+// Due timers run one at a time on the caller's goroutine, ordered by
+// virtual time and then by the clock's Policy
+type VirtualClock struct {
+	mu       sync.Mutex
+	now      time.Duration
+	seq      uint64
+	events   eventQueue
+	policy   Policy
+	priority map[any]int
+	lastRun  map[any]uint64
+	steps    uint64
+	limit    uint64
+	exceeded bool
+	halted   bool
+}
+
+// ErrStepLimitExceeded is what RunUntil returns once a VirtualClock has
+// run as many timers as SetStepLimit allows
+var ErrStepLimitExceeded = errors.New("step limit exceeded")
+
+// Policy orders timers that fall due at the same virtual instant
+type Policy int
+
+const (
+	// FIFO runs them in the order they were scheduled (the default)
+	FIFO Policy = iota
+	// RoundRobin rotates between actors, running first the actor that has
+	// gone longest without running
+	RoundRobin
+	// Priority runs the timers of higher priority actors first, then the
+	// messages of higher Priority headers
+	Priority
+	// SourceOrder runs the messages of sources declared earlier first, and
+	// timers that carry no message, such as ticks, before any message
+	SourceOrder
+)
+
+// NewVirtualClock creates a virtual clock at time zero
+func NewVirtualClock() *VirtualClock {
+	return &VirtualClock{priority: map[any]int{}, lastRun: map[any]uint64{}}
+}
+
+// SetPolicy chooses how timers due at the same instant are ordered
+// Ties within an actor, under equal priority or from the same source keep
+// scheduling order
+func (c *VirtualClock) SetPolicy(p Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = p
+}
+
+// SetStepLimit caps the timers the clock runs in all, so a runaway such as
+// a feedback loop that never drops a message stops instead of hanging;
+// zero, the default, sets no cap
+// Once the clock reaches it with timers still due, Advance and Step run
+// nothing more and leave the clock where it stopped
+func (c *VirtualClock) SetStepLimit(n uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit = n
+}
+
+// Exceeded reports whether the clock stopped at its step limit with timers
+// still due
+func (c *VirtualClock) Exceeded() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exceeded
+}
+
+// Steps returns the number of timers the clock has run
+func (c *VirtualClock) Steps() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.steps
+}
+
+// setPriority ranks the timers of owner under the Priority policy
+func (c *VirtualClock) setPriority(owner any, priority int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.priority[owner] = priority
+}
+
+func (c *VirtualClock) Now() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.schedule(nil, d, f)
+}
+
+// schedule is AfterFunc for a timer that runs on behalf of owner, which
+// RoundRobin and Priority order by
+func (c *VirtualClock) schedule(owner any, d time.Duration, f func()) Timer {
+	return c.scheduleRanked(owner, 0, 0, d, f)
+}
+
+// scheduleRanked schedules a timer that delivers a message from the source
+// of rank, which SourceOrder orders by, under the priority of its header,
+// which Priority orders by among timers of actors of equal priority
+func (c *VirtualClock) scheduleRanked(owner any, rank, priority int, d time.Duration, f func()) Timer {
+	if d < 0 {
+		d = 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	e := &event{at: c.now + d, seq: c.seq, owner: owner, rank: rank, priority: priority, f: f}
+	heap.Push(&c.events, e)
+	return &virtualTimer{clock: c, event: e}
+}
+
+// Advance moves virtual time forward by d, running every timer that
+// falls due on the way, including timers scheduled while advancing
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now + d
+	c.mu.Unlock()
+
+	for c.step(until) {
+	}
+
+	c.mu.Lock()
+	if !c.exceeded && !c.halted {
+		c.now = until
+	}
+	c.mu.Unlock()
+}
+
+// halt stops the clock running timers once a callback halts the system;
+// Advance and Step run nothing more and leave the clock where it stopped
+func (c *VirtualClock) halt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.halted = true
+}
+
+// Pending returns the number of scheduled timers
+func (c *VirtualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events)
+}
+
+// Step runs the next scheduled timer, moving virtual time to it
+// It returns false if no timer is scheduled, the step limit is reached
+// or a callback halted the system
+func (c *VirtualClock) Step() bool {
+	return c.step(math.MaxInt64)
+}
+
+// step runs the next timer due at or before until
+func (c *VirtualClock) step(until time.Duration) bool {
+	c.mu.Lock()
+	if c.halted || len(c.events) == 0 || c.events[0].at > until {
+		c.mu.Unlock()
+		return false
+	}
+	if c.limit > 0 && c.steps >= c.limit {
+		c.exceeded = true
+		c.mu.Unlock()
+		return false
+	}
+	e := heap.Remove(&c.events, c.next()).(*event)
+	c.now = e.at
+	c.steps++
+	c.lastRun[e.owner] = c.steps
+	c.mu.Unlock()
+
+	e.f()
+	return true
+}
+
+// next returns the index of the timer to run among those due first
+func (c *VirtualClock) next() int {
+	if c.policy == FIFO {
+		return 0
+	}
+	best := 0
+	for i, e := range c.events {
+		if e.at == c.events[0].at && c.before(e, c.events[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// before reports whether e runs before other when both are due at once
+func (c *VirtualClock) before(e, other *event) bool {
+	switch c.policy {
+	case RoundRobin:
+		if a, b := c.lastRun[e.owner], c.lastRun[other.owner]; a != b {
+			return a < b
+		}
+	case Priority:
+		if a, b := c.priority[e.owner], c.priority[other.owner]; a != b {
+			return a > b
+		}
+		if e.priority != other.priority {
+			return e.priority > other.priority
+		}
+	case SourceOrder:
+		if e.rank != other.rank {
+			return e.rank < other.rank
+		}
+	}
+	return e.seq < other.seq
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	event *event
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if t.event.index < 0 {
+		return false
+	}
+	heap.Remove(&t.clock.events, t.event.index)
+	return true
+}
+
+type event struct {
+	at       time.Duration
+	seq      uint64
+	owner    any
+	rank     int
+	priority int
+	f        func()
+	index    int
+}
+
+// eventQueue orders events by virtual time, then by scheduling order
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *eventQueue) Push(x any) {
+	e := x.(*event)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *eventQueue) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*q = old[:n-1]
+	return e
+}
@@ -0,0 +1,62 @@
+// Generated from ActorSimulation DSL
+// Serialization of what the system saves
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Codec encodes and decodes the values the system saves, such as reports;
+// implement it to plug in another format, e.g. protobuf
+type Codec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// JSONCodec writes indented JSON, slower than gob but readable, for
+// debugging
+var JSONCodec Codec = jsonCodec{}
+
+// GobCodec writes gob, compact and fast at high volume
+var GobCodec Codec = gobCodec{}
+
+// Codecs are the built-in codecs by name, for choosing one by configuration
+var Codecs = map[string]Codec{"json": JSONCodec, "gob": GobCodec}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v any) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+func (jsonCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(w io.Writer, v any) error {
+	return gob.NewEncoder(w).Encode(v)
+}
+
+func (gobCodec) Decode(r io.Reader, v any) error {
+	return gob.NewDecoder(r).Decode(v)
+}
+
+// SaveReport writes the report as of now to w, encoded with c
+func (s *System) SaveReport(w io.Writer, c Codec) error {
+	return c.Encode(w, s.Report())
+}
+
+// LoadReport reads a report SaveReport wrote with c
+func LoadReport(r io.Reader, c Codec) (Report, error) {
+	var report Report
+	err := c.Decode(r, &report)
+	return report, err
+}
@@ -0,0 +1,214 @@
+// Generated from ActorSimulation DSL
+// Scripted actor crashes and restarts
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"time"
+)
+
+// CrashStep crashes Actor at At and restarts it at Restart, both on the
+// system clock
+type CrashStep struct {
+	Actor   string
+	At      time.Duration
+	Restart time.Duration
+}
+
+// CrashPlan holds the :crashes generator option
+var CrashPlan []CrashStep
+
+// LostMessage is a message a crash lost, queued for the crashed actor, on
+// its way there or sent to it while it was down: its ID, the actor it was
+// meant for and when it was lost
+type LostMessage struct {
+	ID MessageID
+	To string
+	At time.Duration
+}
+
+// SupervisorEvent is what the supervisor did about a crash of Actor at
+// At: restart it once its downtime elapsed, or give up on it, leaving it
+// down for good, once it crashed more often than its restart budget allows
+type SupervisorEvent struct {
+	Actor  string
+	At     time.Duration
+	GaveUp bool
+}
+
+// String describes the event on one line, e.g. for a log
+func (e SupervisorEvent) String() string {
+	if e.GaveUp {
+		return fmt.Sprintf("%v: gave up on %s", e.At, e.Actor)
+	}
+	return fmt.Sprintf("%v: restarted %s", e.At, e.Actor)
+}
+
+// restartBudget allows an actor at most max restarts within any window
+// of clock time, keeping the times it crashed during the last one
+// Only the actor's own inbox touches it
+type restartBudget struct {
+	max     int
+	within  time.Duration
+	crashes []time.Duration
+}
+
+// spend records a crash at now and reports whether the budget still
+// allows a restart; an actor without a budget always restarts
+func (b *restartBudget) spend(now time.Duration) bool {
+	if b == nil {
+		return true
+	}
+	recent := b.crashes[:0]
+	for _, at := range b.crashes {
+		if now-at < b.within {
+			recent = append(recent, at)
+		}
+	}
+	b.crashes = append(recent, now)
+	return len(b.crashes) <= b.max
+}
+
+// restarter is implemented by actors with callbacks, which a restart
+// replaces
+type restarter interface {
+	restart()
+}
+
+// Crash crashes an actor at once, losing its in-memory state along with
+// every message sent to it so far, and restarts it once downtime has
+// elapsed, with fresh callbacks restored from the last state they
+// persisted, if any
+// Messages sent to it while it is down are lost too, and it sends nothing
+// An actor that crashes more often than its restart budget allows is
+// given up on instead, and stays down
+func (s *System) Crash(name string, downtime time.Duration) error {
+	if err := s.crash(name, downtime); err != nil {
+		return err
+	}
+	s.record(func(s *System) { s.Crash(name, downtime) })
+	return nil
+}
+
+// crash is Crash for the steps of a plan, which Fork replays by scheduling
+// the plan again
+func (s *System) crash(name string, downtime time.Duration) error {
+	a, err := s.crashable(name)
+	if err != nil {
+		return err
+	}
+	c := a.(contextual).context()
+	var down bool
+	restart := true
+	phony.Block(a, func() {
+		down = c.down
+		c.down = true
+		c.epoch.Add(1)
+		if !down {
+			restart = c.restarts.spend(s.clock.Now())
+		}
+	})
+	if down {
+		return fmt.Errorf("cannot crash %q: it is down", name)
+	}
+	if !restart {
+		s.supervise(name, true)
+		return nil
+	}
+	s.after(a, downtime, func() {
+		// Reconfigure may have removed it while it was down
+		s.mu.Lock()
+		gone := s.actors[name] != a
+		s.mu.Unlock()
+		if gone {
+			return
+		}
+		if r, ok := a.(restarter); ok {
+			r.restart()
+		}
+		c.down = false
+		s.supervise(name, false)
+	})
+	return nil
+}
+
+// supervise records what the supervisor did about a crash of name
+func (s *System) supervise(name string, gaveUp bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.supervision = append(s.supervision, SupervisorEvent{Actor: name, At: s.clock.Now(), GaveUp: gaveUp})
+}
+
+// ScheduleCrashes crashes actors and restarts them again as each step of
+// plan falls due
+func (s *System) ScheduleCrashes(plan []CrashStep) error {
+	for _, step := range plan {
+		if step.Restart < step.At {
+			return fmt.Errorf("crash of %q at %v restarts before it crashes, at %v", step.Actor, step.At, step.Restart)
+		}
+		if _, err := s.crashable(step.Actor); err != nil {
+			return err
+		}
+	}
+	now := s.clock.Now()
+	for _, step := range plan {
+		step := step
+		s.schedule(nil, step.At-now, func() { s.crash(step.Actor, step.Restart-step.At) })
+	}
+	s.record(func(s *System) { s.ScheduleCrashes(plan) })
+	return nil
+}
+
+// LostMessages returns the messages crashes lost, in the order they were
+// lost
+func (s *System) LostMessages() []LostMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LostMessage(nil), s.lost...)
+}
+
+// SupervisorEvents returns the restarts the supervisor made and the
+// actors it gave up on, in the order it did
+func (s *System) SupervisorEvents() []SupervisorEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SupervisorEvent(nil), s.supervision...)
+}
+
+// crashable looks up an actor that can crash: one that keeps no state a
+// restart would not rebuild, such as a fair queue
+func (s *System) crashable(name string) (actor, error) {
+	s.mu.Lock()
+	a, ok := s.actors[name]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("cannot crash unknown actor %q", name)
+	}
+	switch a.(type) {
+	case *Processor:
+		return nil, fmt.Errorf("cannot crash %q: it serves a fair queue", name)
+	}
+	return a, nil
+}
+
+// lose dead-letters a message a crash lost, counting it as dropped
+func (s *System) lose(to phony.Actor, h header) {
+	s.ledger.dropped.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, a := range s.actors {
+		if a == to {
+			s.lost = append(s.lost, LostMessage{ID: h.id, To: name, At: s.clock.Now()})
+		}
+	}
+}
+
+// crashed reports whether a message sent to the actor at epoch is lost,
+// since the actor crashed after it was sent or is down
+// Only the actor's own inbox may call it
+func (c *messageContext) crashed(epoch uint64) bool {
+	return c.down || c.epoch.Load() != epoch
+}
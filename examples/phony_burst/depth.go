@@ -0,0 +1,156 @@
+// Generated from ActorSimulation DSL
+// Queue depth sampled over time
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// plotWidth is the most columns PlotQueueDepth draws a row in; a longer
+// run folds several samples into each column, keeping their deepest
+const plotWidth = 72
+
+// sparks draws a depth from none up to the deepest sampled
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// depthLog holds the queue depth of every actor sampled at each interval
+type depthLog struct {
+	mu       sync.Mutex
+	interval time.Duration
+	names    []string
+	at       []time.Duration
+	depths   [][]int64
+}
+
+// SampleQueueDepth records the queue depth of every actor from now on,
+// once per interval of the system's clock, for WriteQueueDepthCSV and
+// PlotQueueDepth
+// An actor's depth is the messages on their way to it or in its inbox,
+// plus any its fair queue or join window holds back; actors spawned
+// later by Reconfigure are left out
+func (s *System) SampleQueueDepth(interval time.Duration) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.actors))
+	for name := range s.actors {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+
+	s.depths.mu.Lock()
+	s.depths.interval, s.depths.names = interval, names
+	s.depths.mu.Unlock()
+	var sample func()
+	sample = func() {
+		s.schedule(nil, interval, sample)
+		s.sampleDepth()
+	}
+	s.schedule(nil, interval, sample)
+}
+
+// sampleDepth records every sampled actor's queue depth, summed over
+// its inboxes
+func (s *System) sampleDepth() {
+	s.mu.Lock()
+	actors := make([]actor, len(s.depths.names))
+	for i, name := range s.depths.names {
+		actors[i] = s.actors[name]
+	}
+	s.mu.Unlock()
+
+	depths := make([]int64, len(actors))
+	for i, a := range actors {
+		if a == nil {
+			continue
+		}
+		for _, c := range contexts(a) {
+			depths[i] += c.inbound.Load()
+		}
+		if q, ok := a.(queuer); ok {
+			depths[i] += int64(q.queued())
+		}
+	}
+	s.depths.mu.Lock()
+	s.depths.at = append(s.depths.at, s.clock.Now())
+	s.depths.depths = append(s.depths.depths, depths)
+	s.depths.mu.Unlock()
+}
+
+// WriteQueueDepthCSV writes the sampled queue depths as CSV, one row per
+// sample with its time in milliseconds and a column per actor
+func (s *System) WriteQueueDepthCSV(w io.Writer) error {
+	s.depths.mu.Lock()
+	defer s.depths.mu.Unlock()
+	out := csv.NewWriter(w)
+	out.Write(append([]string{"ms"}, s.depths.names...))
+	for i, at := range s.depths.at {
+		row := []string{strconv.FormatInt(at.Milliseconds(), 10)}
+		for _, depth := range s.depths.depths[i] {
+			row = append(row, strconv.FormatInt(depth, 10))
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// PlotQueueDepth draws the sampled queue depth of every actor as a
+// sparkline on a scale shared by all of them, so the actor where
+// messages pile up stands out, followed by its deepest sample
+func (s *System) PlotQueueDepth(w io.Writer) error {
+	s.depths.mu.Lock()
+	defer s.depths.mu.Unlock()
+	if len(s.depths.at) == 0 {
+		_, err := fmt.Fprintln(w, "No queue depth sampled; call SampleQueueDepth first")
+		return err
+	}
+
+	per := (len(s.depths.at) + plotWidth - 1) / plotWidth
+	columns := (len(s.depths.at) + per - 1) / per
+	deepest := int64(0)
+	width := 0
+	for i, name := range s.depths.names {
+		for _, depths := range s.depths.depths {
+			if depths[i] > deepest {
+				deepest = depths[i]
+			}
+		}
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Queue depth from %v to %v, %v a column\n", s.depths.at[0], s.depths.at[len(s.depths.at)-1], time.Duration(per)*s.depths.interval)
+	for i, name := range s.depths.names {
+		peak := int64(0)
+		line := make([]rune, columns)
+		for col := range line {
+			top := int64(0)
+			for _, depths := range s.depths.depths[col*per : min(len(s.depths.at), (col+1)*per)] {
+				if depths[i] > top {
+					top = depths[i]
+				}
+			}
+			if top > peak {
+				peak = top
+			}
+			line[col] = sparks[0]
+			if top > 0 {
+				line[col] = sparks[1+(top*int64(len(sparks)-1)-1)/deepest]
+			}
+		}
+		fmt.Fprintf(&b, "%-*s %s %d\n", width, name, string(line), peak)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,60 @@
+// Generated from ActorSimulation DSL
+// Message envelopes and their headers
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Headers travel with a message apart from its payload, so middleware
+// reads them the same way whatever the payload's type
+// Trace identifies a sampled message and is zero for the rest; Priority
+// orders messages to actors of equal priority under the Priority policy,
+// higher first; Deadline is when the message is due and TTL how long it
+// may live once produced, zero for neither
+type Headers struct {
+	Trace    uint64
+	Priority int
+	Deadline time.Duration
+	TTL      time.Duration
+}
+
+// Envelope wraps the payload of a message of Kind with its headers
+type Envelope[T any] struct {
+	Headers
+	Kind    string
+	Payload T
+}
+
+// Act delivers env to the named actor from outside the system, as a
+// message of env.Kind produced there and then
+// The message keeps env's headers and payload on every actor it is
+// forwarded to, whose middleware sees them in HandlerContext
+// Safe to call from outside the actors
+func Act[T any](s *System, name string, env Envelope[T]) error {
+	s.mu.Lock()
+	a, ok := s.actors[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("cannot deliver to unknown actor %q", name)
+	}
+	handle, ok := a.handler(env.Kind)
+	if !ok {
+		return fmt.Errorf("%s does not handle %q messages", name, env.Kind)
+	}
+	s.ledger.produced.Add(1)
+	h := header{Headers: env.Headers, id: MessageID{Source: name, Seq: s.NextID()}, born: s.clock.Now(), payload: env.Payload}
+	h.key = h.id.Seq
+	h.enqueued = h.born
+	s.deliver(nil, a, h, handle)
+	s.record(func(s *System) { Act(s, name, env) })
+	return nil
+}
+
+// expired reports whether a message has outlived its TTL by now
+func (h *header) expired(now time.Duration) bool {
+	return h.TTL > 0 && now-h.born > h.TTL
+}
@@ -0,0 +1,23 @@
+// Generated from ActorSimulation DSL
+// Actor metrics published through expvar
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"expvar"
+)
+
+// metrics appears at /debug/vars under gen_server_virtual_time
+var metrics = expvar.NewMap("gen_server_virtual_time")
+
+// PublishMetrics exposes every actor's counters through expvar
+// Counters are read on demand, so they stay current while the system runs
+func (s *System) PublishMetrics() {
+	metrics.Set("processor", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.processor.SendCount()}
+	}))
+	metrics.Set("burst_generator", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.burstGenerator.SendCount()}
+	}))
+}
@@ -0,0 +1,175 @@
+// Generated from ActorSimulation DSL
+// Weighted fair queuing across message kinds
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"time"
+)
+
+// FairQueue holds one FIFO queue per message class and interleaves them
+// by smooth weighted round-robin: while several classes are backlogged,
+// each is served in proportion to its weight, so no class starves
+type FairQueue struct {
+	weights []int
+	credit  []int
+	queues  [][]queueItem
+	pushes  uint64
+}
+
+// queueItem is a queued message, numbered in the order it was pushed,
+// keyed if it was conflated, with the deadline it was pushed with, zero
+// for none, and the priority it ages from since it was pushed
+type queueItem struct {
+	seq      uint64
+	key      string
+	deadline time.Duration
+	priority int
+	pushed   time.Duration
+	f        func()
+}
+
+// NewFairQueue creates a queue with one class per weight
+func NewFairQueue(weights ...int) *FairQueue {
+	return &FairQueue{
+		weights: weights,
+		credit:  make([]int, len(weights)),
+		queues:  make([][]queueItem, len(weights)),
+	}
+}
+
+// Push appends f to the queue of class
+func (q *FairQueue) Push(class int, f func()) {
+	q.queues[class] = append(q.queues[class], queueItem{seq: q.next(), f: f})
+}
+
+// PushDue appends f to the queue of class, to be shed once deadline has
+// passed, unless deadline is zero
+func (q *FairQueue) PushDue(class int, deadline time.Duration, f func()) {
+	q.queues[class] = append(q.queues[class], queueItem{seq: q.next(), deadline: deadline, f: f})
+}
+
+// PushAged appends f to the queue of class with priority as of now, for
+// PopAged to age, and with a deadline as for PushDue
+func (q *FairQueue) PushAged(class, priority int, deadline, now time.Duration, f func()) {
+	q.queues[class] = append(q.queues[class], queueItem{seq: q.next(), deadline: deadline, priority: priority, pushed: now, f: f})
+}
+
+// Shed removes every item whose deadline is before now and returns how
+// many it removed
+func (q *FairQueue) Shed(now time.Duration) int {
+	shed := 0
+	for class, queue := range q.queues {
+		live := queue[:0]
+		for _, item := range queue {
+			if item.deadline > 0 && item.deadline < now {
+				shed++
+				continue
+			}
+			live = append(live, item)
+		}
+		for i := len(live); i < len(queue); i++ {
+			queue[i] = queueItem{}
+		}
+		q.queues[class] = live
+	}
+	return shed
+}
+
+// Conflate queues f in class unless an item of the same key is waiting
+// there, which f then replaces in its place in line, and reports whether
+// it replaced one
+func (q *FairQueue) Conflate(class int, key string, f func()) bool {
+	for i := range q.queues[class] {
+		if q.queues[class][i].key == key {
+			q.queues[class][i].f = f
+			return true
+		}
+	}
+	q.queues[class] = append(q.queues[class], queueItem{seq: q.next(), key: key, f: f})
+	return false
+}
+
+// next numbers the next item pushed
+func (q *FairQueue) next() uint64 {
+	q.pushes++
+	return q.pushes
+}
+
+// DropOldest removes the item pushed first across every class, to make
+// room in a full mailbox, and reports whether there was one
+func (q *FairQueue) DropOldest() bool {
+	best := -1
+	for class, queue := range q.queues {
+		if len(queue) > 0 && (best < 0 || queue[0].seq < q.queues[best][0].seq) {
+			best = class
+		}
+	}
+	if best < 0 {
+		return false
+	}
+	q.queues[best][0] = queueItem{}
+	q.queues[best] = q.queues[best][1:]
+	return true
+}
+
+// Pop removes the next item, choosing among the non-empty classes
+func (q *FairQueue) Pop() (int, func(), bool) {
+	best, total := -1, 0
+	for class, queue := range q.queues {
+		if len(queue) == 0 {
+			continue
+		}
+		q.credit[class] += q.weights[class]
+		total += q.weights[class]
+		if best < 0 || q.credit[class] > q.credit[best] {
+			best = class
+		}
+	}
+	if best < 0 {
+		return 0, nil, false
+	}
+	q.credit[best] -= total
+
+	f := q.queues[best][0].f
+	q.queues[best][0] = queueItem{}
+	q.queues[best] = q.queues[best][1:]
+	return best, f, true
+}
+
+// PopAged removes the item of highest effective priority across every
+// class, ignoring weights: its priority plus step for each interval of
+// every it has waited by now, so an old item overtakes newer ones of
+// higher priority and none starves
+// Ties go to the item pushed first, then to the lower class
+func (q *FairQueue) PopAged(now time.Duration, step int, every time.Duration) (int, func(), bool) {
+	best, index, top := -1, 0, 0
+	for class, queue := range q.queues {
+		for i, item := range queue {
+			p := item.priority + step*int((now-item.pushed)/every)
+			if best < 0 || p > top || (p == top && item.pushed < q.queues[best][index].pushed) {
+				best, index, top = class, i, p
+			}
+		}
+	}
+	if best < 0 {
+		return 0, nil, false
+	}
+
+	queue := q.queues[best]
+	f := queue[index].f
+	copy(queue[index:], queue[index+1:])
+	queue[len(queue)-1] = queueItem{}
+	q.queues[best] = queue[:len(queue)-1]
+	return best, f, true
+}
+
+// Len returns the number of queued items across all classes
+func (q *FairQueue) Len() int {
+	n := 0
+	for _, queue := range q.queues {
+		n += len(queue)
+	}
+	return n
+}
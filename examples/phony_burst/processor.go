@@ -6,20 +6,26 @@ package main
 
 import (
 	"github.com/Arceliar/phony"
+	"time"
 )
 
 // ProcessorCallbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type ProcessorCallbacks interface {
-
+	OnBatch() error
 }
 
-
 type Processor struct {
 	phony.Inbox
-	targets []*Processor
-	callbacks ProcessorCallbacks
-	sendCount int
+	sys *System
+	messageContext
+	callbacks  ProcessorCallbacks
+	ctx        Context
+	copiesSent int
+	queue      *FairQueue
+	busy       bool
+	processed  [1]int
 }
 
 func (a *Processor) Actor() *phony.Inbox {
@@ -27,7 +33,128 @@ func (a *Processor) Actor() *phony.Inbox {
 }
 
 func (a *Processor) Start() {
-	a.callbacks = &DefaultProcessorCallbacks{}
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultProcessorCallbacks{Ctx: &a.ctx}
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Processor) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Processor) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Processor) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Processor) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Processor) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
 }
 
+// ProcessedCounts returns how many messages of each kind were processed
+// Safe to call from outside the actor
+func (a *Processor) ProcessedCounts() map[string]int {
+	counts := map[string]int{}
+	phony.Block(a, func() {
+		counts["batch"] = a.processed[0]
+	})
+	return counts
+}
+
+// queued returns the number of messages waiting for or in service
+// Safe to call from outside the actor
+func (a *Processor) queued() int {
+	var n int
+	phony.Block(a, func() {
+		n = a.queue.Len()
+		if a.busy {
+			n++
+		}
+	})
+	return n
+}
+
+// serveNext processes the next queued message once the previous one
+// has taken its service time
+func (a *Processor) serveNext() {
+	if a.busy {
+		return
+	}
+	class, f, ok := a.queue.Pop()
+	if !ok {
+		return
+	}
+	a.busy = true
+	start := a.sys.clock.Now()
+	a.sys.after(a, 20*time.Millisecond, func() {
+		f()
+		a.handled(a.header.enqueued, start, a.sys.clock.Now())
+		a.processed[class]++
+		a.busy = false
+		a.serveNext()
+	})
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *Processor) handler(kind string) (func(), bool) {
+	switch kind {
+	case "batch":
+		return a.Batch, true
+	}
+	return nil, false
+}
+
+func (a *Processor) Batch() {
+	h := a.header
+	a.queue.Push(0, func() {
+		a.header = h
+		a.sys.middleware.Handle(HandlerContext{Actor: "processor", From: h.from, Now: a.sys.clock.Now(), ID: h.id, Key: h.key, Headers: h.Headers, Payload: h.payload}, "batch", a.handleBatch)
+	})
+	a.sawQueue(a.inbound.Load() + int64(a.queue.Len()))
+	a.serveNext()
+}
 
+func (a *Processor) handleBatch() {
+	if err := a.callbacks.OnBatch(); err != nil {
+		a.sys.fail("processor", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishBatch)
+}
+
+func (a *Processor) finishBatch() {
+	a.sys.forwarded(0)
+}
@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultProcessorCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultProcessorCallbacks struct{}
-
+type DefaultProcessorCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultProcessorCallbacks) OnBatch() error {
+	// TODO: Implement custom behavior for batch
+	c.Ctx.Logf("Processor: Received batch message\n")
+	return nil
+}
@@ -0,0 +1,23 @@
+// Generated from ActorSimulation DSL
+// Seed sweeps for flakiness detection
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+)
+
+// SweepSeeds runs check against a fresh, started system on a VirtualClock
+// for every seed from 0 to n-1 and stops at the first failure
+// The error names the seed, so NewSystem(seed, NewVirtualClock()) replays it
+func SweepSeeds(n int, check func(*System) error) error {
+	for seed := 0; seed < n; seed++ {
+		sys := NewSystem(int64(seed), NewVirtualClock())
+		sys.Start()
+		if err := check(sys); err != nil {
+			return fmt.Errorf("seed %d: %w", seed, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+// Generated from ActorSimulation DSL
+// Actor topology in Graphviz DOT
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"io"
+)
+
+// topologyDOT is the actor graph the DSL declares
+const topologyDOT = `digraph actors {
+	rankdir=LR
+	"processor"
+	"burst_generator"
+	"burst_generator" -> "processor" [label="batch"]
+}
+`
+
+// WriteDOT writes the actor graph in Graphviz DOT, for dot -Tsvg and the
+// like: a node per actor, an edge per target labelled with the messages
+// sent along it and a dashed edge to each fallback
+// The graph is the wiring NewSystem sets up, without the changes
+// Reconfigure or a partition makes to it
+func (s *System) WriteDOT(w io.Writer) error {
+	_, err := io.WriteString(w, topologyDOT)
+	return err
+}
@@ -0,0 +1,35 @@
+// Generated from ActorSimulation DSL
+// Sampled message traces
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+// SetTraceSample traces the given fraction of the messages sources
+// produce, drawn from the "trace" stream so a run samples the same messages
+// every time; sends carry the trace on, so every handler of a sampled
+// message sees it in HandlerContext.Trace
+// Zero, the default, turns tracing off; call it before Start
+func (s *System) SetTraceSample(rate float64) {
+	s.traceSample = rate
+	s.traceDraw = s.Stream("trace")
+	s.record(func(s *System) { s.SetTraceSample(rate) })
+}
+
+// sample returns a new trace ID for a sampled message, or zero
+func (s *System) sample() uint64 {
+	if s.traceSample <= 0 || s.traceDraw() >= s.traceSample {
+		return 0
+	}
+	return s.traces.Add(1)
+}
+
+// TraceLogger returns middleware that logs every handler run for a
+// sampled message
+func TraceLogger(logf func(format string, args ...any)) Middleware {
+	return MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		if ctx.Trace != 0 {
+			logf("trace %d: %s handles %s at %v", ctx.Trace, ctx.Actor, msg, ctx.Now)
+		}
+		next()
+	})
+}
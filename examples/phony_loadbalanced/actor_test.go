@@ -4,8 +4,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Arceliar/phony"
+	"os"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
+
+	"loadbalanced_actors/simtest"
 )
 
 func TestActorSystem(t *testing.T) {
@@ -15,67 +24,919 @@ func TestActorSystem(t *testing.T) {
 	}
 }
 
+// runUntil runs sys to until and stops the test if the run fails
+func runUntil(t *testing.T, sys *System, until time.Duration) Report {
+	t.Helper()
+	report, err := sys.RunUntil(until)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
 func TestLoadBalancer(t *testing.T) {
-	actor := &LoadBalancer{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	h.AssertSendCount(sys.loadBalancer, 100)
+}
+
+func TestServer(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	h.AssertSendCount(sys.server, 100)
+}
+
+func TestDatabase(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	h.AssertSendCount(sys.database, 0)
+}
+
+func TestSystemSettles(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	h.WaitQuiescent()
+	h.AssertQuiescent()
+}
+
+func TestStepLimitStopsRunaways(t *testing.T) {
+	clock := NewVirtualClock()
+	clock.SetStepLimit(1)
+	sys := NewSystem(1, clock)
+	sys.Start()
+
+	if _, err := sys.RunUntil(1000 * time.Millisecond); !errors.Is(err, ErrStepLimitExceeded) {
+		t.Fatalf("expected the step limit to stop the run, got %v", err)
+	}
+	if steps := clock.Steps(); steps != 1 {
+		t.Fatalf("expected the run to stop after 1 timer, got %d", steps)
 	}
 }
 
+func TestMessagesAreConserved(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
 
-func TestServer1(t *testing.T) {
-	actor := &Server1{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+	h.Advance(1000 * time.Millisecond)
+	h.WaitQuiescent()
+	if err := sys.CheckConservation(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A buggy drop path that discards a message without counting it
+	sys.produce(sys.loadBalancer, "load_balancer", func() {})
+	if err := sys.CheckConservation(); err == nil {
+		t.Fatal("expected an uncounted drop to fail the conservation check")
 	}
 }
 
+func TestMiddlewareWrapsHandlers(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	handled := map[string]int{}
+	sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		handled[ctx.Actor]++
+		next()
+	}))
+	h := simtest.NewHarness(t, sys, clock)
 
-func TestServer2(t *testing.T) {
-	actor := &Server2{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	if handled["load_balancer"] == 0 {
+		t.Fatal("expected middleware around load_balancer handlers")
+	}
+	for _, a := range sys.Report().Actors {
+		if a.Queue.Count > 0 && handled[a.Name] == 0 {
+			t.Errorf("expected middleware around the %d messages %s started on", a.Queue.Count, a.Name)
+		}
 	}
 }
 
+func TestActForwardsEnvelopes(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	handled := map[string]int{}
+	sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		if ctx.Payload == "interop" {
+			if ctx.Trace != 99 || ctx.Priority != 3 {
+				t.Errorf("expected %s to see the envelope's headers, got %+v", ctx.Actor, ctx.Headers)
+			}
+			handled[ctx.Actor]++
+		}
+		next()
+	}))
+	h := simtest.NewHarness(t, sys, clock)
 
-func TestServer3(t *testing.T) {
-	actor := &Server3{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+	env := Envelope[string]{Headers: Headers{Trace: 99, Priority: 3}, Kind: "request", Payload: "interop"}
+	if err := Act(sys, "load_balancer", env); err != nil {
+		t.Fatal(err)
+	}
+	if err := Act(sys, "load_balancer", Envelope[string]{}); err == nil {
+		t.Fatal("expected load_balancer to refuse an envelope of no kind")
+	}
+	h.Advance(1000 * time.Millisecond)
+	if handled["load_balancer"] != 1 {
+		t.Fatalf("expected load_balancer to handle the envelope once, got %d", handled["load_balancer"])
 	}
 }
 
+func TestMergeOrderIsStable(t *testing.T) {
+	merge := func() []HandlerContext {
+		clock := NewVirtualClock()
+		clock.SetPolicy(SourceOrder)
+		sys := NewSystem(1, clock)
+		var arrivals []HandlerContext
+		sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+			arrivals = append(arrivals, ctx)
+			next()
+		}))
+		h := simtest.NewHarness(t, sys, clock)
+		for i := 0; i < 3; i++ {
+			h.Advance(333 * time.Millisecond)
+			// The later declared actor gets its message first
+			for _, env := range []struct{ name, kind string }{{"database", "request"}, {"load_balancer", "request"}} {
+				if err := Act(sys, env.name, Envelope[string]{Kind: env.kind, Payload: "tie"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			h.DrainQuiescent()
+		}
+		return arrivals
+	}
 
-func TestDatabase(t *testing.T) {
-	actor := &Database{}
-	actor.Start()
-	
-	// Wait a bit for actor to initialize
-	time.Sleep(10 * time.Millisecond)
-	
-	if actor == nil {
-		t.Fatal("Actor should not be nil")
+	first := merge()
+	var ties []HandlerContext
+	for _, a := range first {
+		if a.Payload == "tie" && a.Actor == a.ID.Source {
+			ties = append(ties, a)
+		}
+	}
+	if len(ties) != 6 {
+		t.Fatalf("expected load_balancer and database to take 3 messages each, got %v", ties)
+	}
+	for i := 0; i < len(ties); i += 2 {
+		if ties[i].Actor != "load_balancer" || ties[i+1].Actor != "database" || ties[i].Now != ties[i+1].Now {
+			t.Errorf("expected load_balancer before database at the same instant, in declaration order, got %v then %v", ties[i], ties[i+1])
+		}
+	}
+	if second := merge(); fmt.Sprint(second) != fmt.Sprint(first) {
+		t.Fatal("load_balancer and database took their messages in a different order across runs")
+	}
+}
+
+func TestReconfigureLive(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+	// The report and its totals cover the actors there are at the time
+	totals := func() {
+		t.Helper()
+		var sent, received int
+		for _, a := range sys.actors {
+			m := a.Metrics()
+			sent, received = sent+m.SendCount, received+m.RecvCount
+		}
+		if n := len(sys.Report().Actors); n != len(sys.actors) {
+			t.Fatalf("expected a row for each of the %d actors, got %d", len(sys.actors), n)
+		}
+		if m := sys.SystemMetrics(); m.Sent != sent || m.Received != received {
+			t.Fatalf("expected %d sent and %d received in all, got %+v", sent, received, m)
+		}
+	}
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	before := sys.loadBalancer.SendCount()
+	err := sys.Reconfigure(&Spec{
+		Spawn:     map[string]string{"server_spawned": "server"},
+		Connect:   []Edge{{From: "load_balancer", To: "server_spawned"}},
+		Intervals: map[string]time.Duration{"load_balancer": 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	// One more target, sent to at twice the rate
+	after := sys.loadBalancer.SendCount() - before
+	if after <= before {
+		t.Fatalf("load_balancer sent %d messages after reconfiguring, %d before", after, before)
+	}
+	totals()
+	if err := sys.Reconfigure(&Spec{Connect: []Edge{{From: "load_balancer", To: "missing"}}}); err == nil {
+		t.Fatal("expected an error connecting to an unknown actor")
+	}
+}
+
+func TestWriteDOTShowsWiring(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	var b strings.Builder
+	if err := sys.WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	dot := b.String()
+
+	names := map[any]string{}
+	for name, a := range sys.actors {
+		names[a] = name
+		if !strings.Contains(dot, fmt.Sprintf("\t%q\n", name)) {
+			t.Errorf("expected a node for %s", name)
+		}
+	}
+	var wired []string
+	for _, to := range sys.loadBalancer.targets {
+		wired = append(wired, fmt.Sprintf("%q -> %q [", "load_balancer", names[to]))
+	}
+	for _, to := range sys.server.targets {
+		wired = append(wired, fmt.Sprintf("%q -> %q [", "server", names[to]))
+	}
+	for _, edge := range wired {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("expected the graph to show %s]", edge)
+		}
+	}
+	if n := strings.Count(dot, " -> "); n != len(wired) {
+		t.Fatalf("expected the %d edges NewSystem wires, got %d", len(wired), n)
+	}
+}
+
+func TestForkBranchesRun(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+	runUntil(t, sys, 500*time.Millisecond)
+	snap := sys.Snapshot()
+	want := runUntil(t, sys, 2000*time.Millisecond).String()
+
+	// A fork replays the run up to the snapshot, so it goes on the same way
+	same := Fork(snap)
+	if got := runUntil(t, same, 2000*time.Millisecond).String(); got != want {
+		t.Fatalf("expected the fork to go on like the original run, got\n%s\nwant\n%s", got, want)
+	}
+	faster := Fork(snap)
+	spec := &Spec{Intervals: map[string]time.Duration{"load_balancer": 5 * time.Millisecond}}
+	if err := faster.Reconfigure(spec); err != nil {
+		t.Fatal(err)
+	}
+	reconfigured := faster.Snapshot()
+	// Reconfigure keeps its own copy of the spec for forks to replay
+	spec.Intervals["load_balancer"] = 2000 * time.Millisecond
+
+	got := runUntil(t, faster, 2000*time.Millisecond)
+	t.Log("\n" + Compare(map[string]Report{"same": same.Report(), "faster": got}))
+	if faster.loadBalancer.SendCount() <= same.loadBalancer.SendCount() {
+		t.Fatalf("expected load_balancer to send more once it sends faster, got %d, before %d", faster.loadBalancer.SendCount(), same.loadBalancer.SendCount())
+	}
+	if again := runUntil(t, Fork(reconfigured), 2000*time.Millisecond).String(); again != got.String() {
+		t.Fatalf("expected a fork to replay the spec as applied, got\n%s\nwant\n%s", again, got)
+	}
+}
+
+func TestDiffRunsFindsChangedInterval(t *testing.T) {
+	if d := DiffRuns(1, 30*time.Millisecond, nil, nil); d.Diverged() {
+		t.Fatalf("expected two runs of one seed to be alike, got\n%s", d)
+	}
+	slower := func(s *System) {
+		if err := s.Reconfigure(&Spec{Intervals: map[string]time.Duration{"load_balancer": 20 * time.Millisecond}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := DiffRuns(1, 30*time.Millisecond, nil, slower)
+	t.Log("\n" + d.String())
+	if !d.Diverged() || d.Left == nil || d.Left.Actor != "load_balancer" || d.Left.At != 20*time.Millisecond {
+		t.Fatalf("expected the runs to diverge at the 20ms tick of load_balancer, got\n%s", d)
+	}
+	for _, c := range d.Counts {
+		if c.Actor == "load_balancer" && c.Message == "request" && c.Right >= c.Left {
+			t.Fatalf("expected load_balancer to produce fewer messages once it sends slower, got %d, before %d", c.Right, c.Left)
+		}
+	}
+}
+
+func TestPartitionHeals(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	h := simtest.NewHarness(t, sys, clock)
+	plan := []PartitionStep{{At: 0, Heal: 1000 * time.Millisecond, Groups: [][]string{{"load_balancer"}}}}
+	if err := sys.SchedulePartitions(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Advance(1000 * time.Millisecond)
+	cut := sys.PartitionedCount()
+	if cut == 0 {
+		t.Fatal("expected the partition to drop what load_balancer sends")
+	}
+	h.Advance(1000 * time.Millisecond)
+	h.DrainQuiescent()
+	if n := sys.PartitionedCount(); n != cut {
+		t.Fatalf("expected no drops once healed, got %d more", n-cut)
+	}
+	if err := sys.CheckConservation(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMessageIDsAreReproducible(t *testing.T) {
+	run := func() (string, uint64, int64) {
+		clock := NewVirtualClock()
+		sys := NewSystem(1, clock)
+		var ids []string
+		sys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+			ids = append(ids, ctx.ID.String())
+			next()
+		}))
+		h := simtest.NewHarness(t, sys, clock)
+
+		h.Advance(1000 * time.Millisecond)
+		h.DrainQuiescent()
+		return strings.Join(ids, " "), sys.NextID(), sys.ledger.produced.Load()
+	}
+
+	ids, next, produced := run()
+	if again, _, _ := run(); again != ids {
+		t.Fatal("expected the same seed to allocate the same message IDs")
+	}
+	// One ID per produced message, none skipped
+	if next != uint64(produced)+1 {
+		t.Fatalf("expected ID %d after %d messages, got %d", produced+1, produced, next)
+	}
+}
+
+// errMalformed is what failingLoadBalancerCallbacks fail with
+var errMalformed = errors.New("malformed message")
+
+// failingLoadBalancerCallbacks fail on every message, as on one they
+// cannot make sense of
+type failingLoadBalancerCallbacks struct {
+	*DefaultLoadBalancerCallbacks
+}
+
+func (c failingLoadBalancerCallbacks) OnRequest() error {
+	return errMalformed
+}
+
+func TestCallbackErrorHaltsRun(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	sys.Start()
+	phony.Block(sys.loadBalancer, func() {
+		defaults := sys.loadBalancer.callbacks.(*DefaultLoadBalancerCallbacks)
+		sys.loadBalancer.callbacks = failingLoadBalancerCallbacks{defaults}
+	})
+
+	_, err := sys.RunUntil(1000 * time.Millisecond)
+	if !errors.Is(err, errMalformed) {
+		t.Fatalf("expected the run to halt with the callback's error, got %v", err)
+	}
+	if err.Error() != "load_balancer: malformed message" {
+		t.Fatalf("expected the error to name load_balancer, got %q", err)
+	}
+	if n := sys.loadBalancer.SendCount(); n != 0 {
+		t.Fatalf("expected load_balancer to send nothing its callback failed on, sent %d", n)
+	}
+	steps := clock.Steps()
+	sys.Advance(time.Second)
+	if n := clock.Steps() - steps; n != 0 {
+		t.Fatalf("expected no timer to run once the run halted, %d ran", n)
+	}
+	if err := sys.CheckConservation(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLogsAreSampled(t *testing.T) {
+	suppressed := func(every int) int {
+		sys := NewSystem(1, NewVirtualClock())
+		if err := sys.SetLogEvery(every); err != nil {
+			t.Fatal(err)
+		}
+		sys.Start()
+		runUntil(t, sys, 1000*time.Millisecond)
+		return sys.SuppressedLogs()
+	}
+
+	if n := suppressed(1); n != 0 {
+		t.Fatalf("expected every line logged, got %d suppressed", n)
+	}
+	// Every actor still logs its first line
+	if n := suppressed(1 << 30); n == 0 {
+		t.Fatal("expected sampling to leave out all but the first line of each actor")
+	}
+	if err := NewSystem(1, NewVirtualClock()).SetLogEvery(0); err == nil {
+		t.Fatal("expected an error logging one in every 0 lines")
+	}
+}
+
+func TestReportSumsUpRun(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+
+	report := runUntil(t, sys, 1000*time.Millisecond)
+	t.Log("\n" + report.String())
+	if len(report.Actors) != 3 {
+		t.Fatalf("expected a row for each of the 3 actors, got %d", len(report.Actors))
+	}
+	want := map[string]int{"load_balancer": 0, "server": 100, "database": 100}
+	for _, a := range report.Actors {
+		if n, ok := want[a.Name]; ok && a.Received != n {
+			t.Errorf("expected %s to receive %d messages, got %d", a.Name, n, a.Received)
+		}
+		if a.P50 > a.P99 {
+			t.Errorf("expected %s's p50 latency %v within its p99 %v", a.Name, a.P50, a.P99)
+		}
+		if m := sys.actors[a.Name].Metrics(); m.SendCount != a.Sent || m.RecvCount != a.Received {
+			t.Errorf("expected %s's metrics to match its %d sent and %d received, got %+v", a.Name, a.Sent, a.Received, m)
+		}
+	}
+}
+
+func TestSystemMetricsAddUpActors(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+
+	report := runUntil(t, sys, 1000*time.Millisecond)
+	m := sys.SystemMetrics()
+	t.Logf("%+v", m)
+	if m.At != 1000*time.Millisecond {
+		t.Errorf("expected the metrics at 1000ms, got %v", m.At)
+	}
+	var want SystemMetrics
+	want.At = m.At
+	for _, a := range report.Actors {
+		want.Sent += a.Sent
+		want.Received += a.Received
+		want.Dropped += a.Dropped
+		want.Expired += a.Expired
+		if a.PeakQueue > want.PeakQueue {
+			want.PeakQueue = a.PeakQueue
+		}
+	}
+	if m != want {
+		t.Errorf("expected the totals of the report's rows %+v, got %+v", want, m)
+	}
+	if m.Received != 200 {
+		t.Errorf("expected 200 messages received in all, got %d", m.Received)
+	}
+}
+
+func TestDebuggerStepsAndBreaks(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	d := NewDebugger(sys)
+	sys.Start()
+
+	if _, ok := d.Step(); !ok {
+		t.Fatal("expected a timer to step over")
+	}
+	d.Break("server", "", func(a ActorReport) bool { return a.Received >= 5 })
+	hit, ok := d.Continue(1000 * time.Millisecond)
+	if !ok {
+		t.Fatal("expected to break once server received 5 messages by 1000ms")
+	}
+	if n := d.Inspect("server").Received; n < 5 {
+		t.Fatalf("expected server to have received 5 messages at the breakpoint, got %d", n)
+	}
+
+	// Stepping back to the start forks the run afresh, which goes on the same way
+	d.Back(int(d.System().clock.(*VirtualClock).Steps()))
+	if n := d.Inspect("server").Received; n >= 5 {
+		t.Fatalf("expected stepping back to undo what server received, got %d messages", n)
+	}
+	if again, ok := d.Continue(1000 * time.Millisecond); !ok || again != hit {
+		t.Fatalf("expected the fork to break on %v again, got %v", hit, again)
+	}
+}
+
+func TestQueueDepthPlotted(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	sys.SampleQueueDepth(20 * time.Millisecond)
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	var samples, plot strings.Builder
+	if err := sys.WriteQueueDepthCSV(&samples); err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Count(samples.String(), "\n"); rows != 1+50 {
+		t.Fatalf("expected a header and 50 samples, got %d rows", rows)
+	}
+	if err := sys.PlotQueueDepth(&plot); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range sys.Select(nil) {
+		if !strings.Contains(plot.String(), "\n"+name+" ") {
+			t.Fatalf("expected a row for %s in\n%s", name, plot.String())
+		}
+	}
+}
+
+func TestProgressReportedEveryInterval(t *testing.T) {
+	clock := NewVirtualClock()
+	sys := NewSystem(1, clock)
+	var progress []ProgressInfo
+	sys.OnProgress(100*time.Millisecond, func(p ProgressInfo) {
+		progress = append(progress, p)
+	})
+	h := simtest.NewHarness(t, sys, clock)
+
+	h.Advance(1000 * time.Millisecond)
+	if len(progress) != 10 {
+		t.Fatalf("expected 10 snapshots, one every 100ms, got %d", len(progress))
+	}
+	var last int64
+	for i, p := range progress {
+		if want := time.Duration(i+1) * 100 * time.Millisecond; p.At != want {
+			t.Fatalf("expected snapshot %d at %v, got %v", i, want, p.At)
+		}
+		if p.Processed < last {
+			t.Fatalf("expected the messages processed to grow, went from %d to %d at %v", last, p.Processed, p.At)
+		}
+		if rate := float64(p.Processed-last) / (100 * time.Millisecond).Seconds(); p.Rate != rate {
+			t.Fatalf("expected a rate of %v at %v, got %v", rate, p.At, p.Rate)
+		}
+		last = p.Processed
+	}
+}
+
+func TestReportCodecsRoundTrip(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.Start()
+	want := runUntil(t, sys, 1000*time.Millisecond).String()
+
+	for name, codec := range Codecs {
+		var saved strings.Builder
+		if err := sys.SaveReport(&saved, codec); err != nil {
+			t.Fatal(err)
+		}
+		report, err := LoadReport(strings.NewReader(saved.String()), codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := report.String(); got != want {
+			t.Errorf("expected the %s codec to load the report it saved, got\n%s\nwant\n%s", name, got, want)
+		}
+	}
+}
+
+func TestReportJSONFlagsLatencyRegressions(t *testing.T) {
+	run := func() []byte {
+		sys := NewSystem(1, NewVirtualClock())
+		sys.Start()
+		runUntil(t, sys, 1000*time.Millisecond)
+		return sys.ReportJSON()
+	}
+	data := run()
+	if again := run(); string(again) != string(data) {
+		t.Fatalf("expected runs from the same seed to report the same JSON, got\n%s\nwant\n%s", again, data)
+	}
+	base, err := ParseReportJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Seed != 1 || len(base.Actors) != 3 || !base.Invariants.Conserved {
+		t.Fatalf("expected a report on all 3 actors from seed 1, conserving messages, got\n%s", data)
+	}
+	if regressions := LatencyRegressions(base, base, 0.05); len(regressions) != 0 {
+		t.Fatalf("expected no regressions of a report on itself, got %v", regressions)
+	}
+
+	base.Actors[0].LatencyP99Ns = int64(100 * time.Millisecond)
+	head, err := ParseReportJSON(base.JSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	head.Actors[0].LatencyP99Ns = int64(104 * time.Millisecond)
+	if regressions := LatencyRegressions(base, head, 0.05); len(regressions) != 0 {
+		t.Fatalf("expected a 4%% slower p99 to stay within 5%%, got %v", regressions)
+	}
+	head.Actors[0].LatencyP99Ns = int64(106 * time.Millisecond)
+	regressions := LatencyRegressions(base, head, 0.05)
+	if len(regressions) != 1 || regressions[0].Quantile != "p99" || regressions[0].Actor != base.Actors[0].Name {
+		t.Fatalf("expected a 6%% slower p99 of %s to regress, got %v", base.Actors[0].Name, regressions)
+	}
+	if _, err := ParseReportJSON([]byte(`{"actors": []}`)); err == nil {
+		t.Fatal("expected a report without a schema version to be refused")
+	}
+}
+
+func TestMetricsPublished(t *testing.T) {
+	sys := NewSystem(1, NewVirtualClock())
+	sys.PublishMetrics()
+
+	v := metrics.Get("load_balancer")
+	if v == nil || !strings.Contains(v.String(), "sendCount") {
+		t.Fatalf("expected load_balancer counters in expvar, got %v", v)
+	}
+}
+
+func TestMetricSinkRecordsActorMetrics(t *testing.T) {
+	recorder := NewMetricRecorder()
+	sys := NewSystem(1, NewVirtualClock(), WithMetricSink(recorder))
+	sys.Start()
+	report := runUntil(t, sys, 1000*time.Millisecond)
+
+	for _, a := range report.Actors {
+		labels := map[string]string{"actor": a.Name}
+		if n := recorder.CounterValue("actor_messages_received_total", labels); int(n) != a.Received {
+			t.Errorf("%s: recorded %v messages received, report has %d", a.Name, n, a.Received)
+		}
+		if n := len(recorder.Observations("actor_message_latency_seconds", labels)); n != a.Received {
+			t.Errorf("%s: recorded %d latencies, report has %d messages received", a.Name, n, a.Received)
+		}
+		if n := len(recorder.Observations("actor_service_time_seconds", labels)); n != a.Service.Count {
+			t.Errorf("%s: recorded %d service times, report has %d", a.Name, n, a.Service.Count)
+		}
+	}
+}
+
+func TestPrometheusSinkWritesText(t *testing.T) {
+	prometheus := NewPrometheusSink()
+	sys := NewSystem(1, NewVirtualClock(), WithMetricSink(prometheus))
+	sys.Start()
+	report := runUntil(t, sys, 1000*time.Millisecond)
+
+	var out strings.Builder
+	if err := prometheus.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	// A system fed only from outside, e.g. by Kafka sources, receives nothing
+	received := 0
+	for _, a := range report.Actors {
+		received += a.Received
+	}
+	if received > 0 && !strings.Contains(out.String(), "# TYPE actor_messages_received_total counter") {
+		t.Fatalf("expected the received counter type, got:\n%s", out.String())
+	}
+	for _, a := range report.Actors {
+		series := fmt.Sprintf("actor_messages_received_total{actor=%q} %d\n", a.Name, a.Received)
+		if a.Received > 0 && !strings.Contains(out.String(), series) {
+			t.Errorf("expected %q, got:\n%s", series, out.String())
+		}
+	}
+}
+
+func TestTraceSinkRecordsDeliveries(t *testing.T) {
+	recorder := NewTraceRecorder()
+	var lines strings.Builder
+	jsonl := NewJSONLSink(&lines)
+	sys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder), WithTraceSink(jsonl))
+	sys.Start()
+	runUntil(t, sys, 1000*time.Millisecond)
+
+	names := map[any]string{}
+	for name, a := range sys.actors {
+		names[a] = name
+	}
+	wired := map[string]bool{}
+	for _, to := range sys.loadBalancer.targets {
+		wired["load_balancer -> "+names[to]] = true
+	}
+	for _, to := range sys.server.targets {
+		wired["server -> "+names[to]] = true
+	}
+	events := recorder.Events()
+	var last time.Duration
+	for _, e := range events {
+		if e.Time < last {
+			t.Fatalf("expected events in time order, got %v after %v", e.Time, last)
+		}
+		last = e.Time
+		switch edge := e.From + " -> " + e.To; {
+		case e.From == "" && e.ID.Source != e.To:
+			t.Fatalf("recorded %s %s at %s without the actor that sent it", e.Message, e.ID, e.To)
+		case e.From != "" && !wired[edge]:
+			t.Fatalf("recorded %s %s along %s, which NewSystem doesn't wire", e.Message, e.ID, edge)
+		}
+	}
+	if len(events) == 0 {
+		t.Fatal("expected a trace of the messages the sources sent")
+	}
+
+	if err := jsonl.Err(); err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(strings.NewReader(lines.String()))
+	n := 0
+	for ; decoder.More(); n++ {
+		var e TraceEvent
+		if err := decoder.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if n >= len(events) || e != events[n] {
+			t.Fatalf("expected line %d to read back as the event recorded, got %+v", n+1, e)
+		}
+	}
+	if n != len(events) {
+		t.Fatalf("expected a line per event recorded, %d, got %d", len(events), n)
+	}
+}
+
+func TestRenderMermaidListsTracedActors(t *testing.T) {
+	recorder := NewTraceRecorder()
+	sys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder))
+	sys.Start()
+	runUntil(t, sys, 1000*time.Millisecond)
+	events := recorder.Events()
+	if len(events) == 0 {
+		t.Fatal("expected a trace of the messages the sources sent")
+	}
+
+	var b strings.Builder
+	if err := RenderMermaid(events, &b, MermaidWindow{Max: 20}); err != nil {
+		t.Fatal(err)
+	}
+	diagram := b.String()
+	if !strings.HasPrefix(diagram, "sequenceDiagram\n") {
+		t.Fatalf("expected a sequence diagram, got:\n%s", diagram)
+	}
+	traced := map[string]bool{}
+	sent := 0
+	for _, e := range events {
+		traced[e.To] = true
+		if e.From != "" {
+			traced[e.From] = true
+			sent++
+		}
+	}
+	listed := map[string]bool{}
+	arrows := 0
+	for _, line := range strings.Split(diagram, "\n") {
+		if name, ok := strings.CutPrefix(line, "    participant "); ok {
+			listed[name] = true
+		}
+		if strings.Contains(line, "->>") {
+			arrows++
+		}
+	}
+	if fmt.Sprint(listed) != fmt.Sprint(traced) {
+		t.Fatalf("expected a participant per actor in the trace, %v, got %v", traced, listed)
+	}
+	if arrows != min(sent, 20) {
+		t.Fatalf("expected %d arrows of the %d messages sent, got %d", min(sent, 20), sent, arrows)
+	}
+	if sent > 20 && !strings.Contains(diagram, fmt.Sprintf(": %d more messages left out", sent-20)) {
+		t.Fatalf("expected a note on the %d arrows the cap left out, got:\n%s", sent-20, diagram)
 	}
 }
 
+func TestRealClockSpeed(t *testing.T) {
+	clock := NewRealClockWithSpeed(100)
+	fired := make(chan struct{})
+	clock.AfterFunc(time.Second, func() { close(fired) })
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("expected a 1s timer to fire within 1s of real time at 100x speed")
+	}
+	if now := clock.Now(); now < time.Second {
+		t.Fatalf("expected the clock to read at least 1s once the timer fired, got %v", now)
+	}
+}
+
+func TestRealClockRunIsRaceFree(t *testing.T) {
+	sys := NewSystem(1, NewRealClockWithSpeed(100))
+	sys.Start()
+	defer sys.Stop()
+
+	reports := make(chan Report)
+	for i := 0; i < 4; i++ {
+		go func() {
+			var report Report
+			for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+				report = sys.Report()
+				sys.Pending()
+			}
+			reports <- report
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if report := <-reports; len(report.Actors) != 3 {
+			t.Fatalf("expected a row for each of the 3 actors, got %d", len(report.Actors))
+		}
+	}
+}
+
+// Stop leaves nothing running once it returns: it cancels the timers still
+// pending and waits for the rest, and an idle Phony inbox holds no goroutine
+func TestStopReleasesGoroutines(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	sys := NewSystem(1, NewRealClockWithSpeed(100))
+	sys.Start()
+	time.Sleep(50 * time.Millisecond)
+	sys.Stop()
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("expected %d goroutines once stopped, as before Start, got %d", baseline, n)
+	}
+}
+
+// quiet sends what the actors print to os.DevNull until b is done, so
+// their lines don't break up the benchmark results
+func quiet(b *testing.B) {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = null
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		null.Close()
+	})
+}
+
+// BenchmarkActorSystem runs b.N ticks of 10ms, the fastest send
+// pattern's, through the generated actors on a VirtualClock, with their
+// loss, delays and accounting, and reports the messages all actors handle
+// per second
+// Run it with go test -run ^$ -bench ActorSystem -benchmem
+func BenchmarkActorSystem(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	if _, err := sys.RunUntil(time.Duration(b.N) * 10 * time.Millisecond); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(sys.SystemMetrics().Received)/time.Since(start).Seconds(), "msgs/s")
+}
+
+// BenchmarkLoadBalancer acts b.N request messages into load_balancer at one
+// virtual instant and reports the messages per second its handler gets
+// through, along with whatever it sets off downstream by then
+func BenchmarkLoadBalancer(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := Act(sys, "load_balancer", Envelope[string]{Kind: "request"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	sys.Advance(0)
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+// BenchmarkServer acts b.N request messages into server at one
+// virtual instant and reports the messages per second its handler gets
+// through, along with whatever it sets off downstream by then
+func BenchmarkServer(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := Act(sys, "server", Envelope[string]{Kind: "request"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	sys.Advance(0)
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
+
+// BenchmarkDatabase acts b.N request messages into database at one
+// virtual instant and reports the messages per second its handler gets
+// through, along with whatever it sets off downstream by then
+func BenchmarkDatabase(b *testing.B) {
+	quiet(b)
+	sys := NewSystem(1, NewVirtualClock())
+	sys.SetLogEvery(1 << 30)
+	sys.Start()
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if err := Act(sys, "database", Envelope[string]{Kind: "request"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	sys.Advance(0)
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+}
@@ -9,17 +9,19 @@ import (
 )
 
 // DatabaseCallbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type DatabaseCallbacks interface {
-
+	OnRequest() error
 }
 
-
 type Database struct {
 	phony.Inbox
-	targets []*Database
-	callbacks DatabaseCallbacks
-	sendCount int
+	sys *System
+	messageContext
+	callbacks  DatabaseCallbacks
+	ctx        Context
+	copiesSent int
 }
 
 func (a *Database) Actor() *phony.Inbox {
@@ -27,7 +29,88 @@ func (a *Database) Actor() *phony.Inbox {
 }
 
 func (a *Database) Start() {
-	a.callbacks = &DefaultDatabaseCallbacks{}
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultDatabaseCallbacks{Ctx: &a.ctx}
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Database) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Database) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Database) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Database) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
 }
 
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Database) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// restart replaces the callbacks a crash lost with fresh ones, restored
+// from the last state they persisted, if any
+func (a *Database) restart() {
+	a.ctx.sleep = 0
+	a.callbacks = &DefaultDatabaseCallbacks{Ctx: &a.ctx}
+	if r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+		r.Restore(a.ctx.persisted)
+	}
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *Database) handler(kind string) (func(), bool) {
+	switch kind {
+	case "request":
+		return a.Request, true
+	}
+	return nil, false
+}
 
+func (a *Database) Request() {
+	a.sys.middleware.Handle(HandlerContext{Actor: "database", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "request", a.handleRequest)
+}
+
+func (a *Database) handleRequest() {
+	if err := a.callbacks.OnRequest(); err != nil {
+		a.sys.fail("database", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishRequest)
+}
+
+func (a *Database) finishRequest() {
+	a.sys.forwarded(0)
+}
@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultDatabaseCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultDatabaseCallbacks struct{}
-
+type DefaultDatabaseCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultDatabaseCallbacks) OnRequest() error {
+	// TODO: Implement custom behavior for request
+	c.Ctx.Logf("Database: Received request message\n")
+	return nil
+}
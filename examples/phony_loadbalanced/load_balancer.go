@@ -10,17 +10,26 @@ import (
 )
 
 // LoadBalancerCallbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type LoadBalancerCallbacks interface {
-	OnRequest()
+	OnRequest() error
 }
 
+// LoadBalancerTarget is implemented by every actor LoadBalancer sends to
+type LoadBalancerTarget interface {
+	phony.Actor
+	Request()
+}
 
 type LoadBalancer struct {
 	phony.Inbox
-	targets []*LoadBalancer
-	callbacks LoadBalancerCallbacks
-	sendCount int
+	sys *System
+	messageContext
+	targets    []LoadBalancerTarget
+	callbacks  LoadBalancerCallbacks
+	ctx        Context
+	copiesSent int
 }
 
 func (a *LoadBalancer) Actor() *phony.Inbox {
@@ -28,22 +37,115 @@ func (a *LoadBalancer) Actor() *phony.Inbox {
 }
 
 func (a *LoadBalancer) Start() {
-	a.callbacks = &DefaultLoadBalancerCallbacks{}
-	go func() {
-		ticker := time.NewTicker(10 * time.Millisecond)
-		defer ticker.Stop()
-		for range ticker.C {
-			a.Act(nil, func() { a.Request() })
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultLoadBalancerCallbacks{Ctx: &a.ctx}
+	a.sys.every(a, 10*time.Millisecond, func() { a.sys.produce(a, "load_balancer", a.Request) })
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *LoadBalancer) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *LoadBalancer) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *LoadBalancer) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *LoadBalancer) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *LoadBalancer) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// restart replaces the callbacks a crash lost with fresh ones, restored
+// from the last state they persisted, if any
+func (a *LoadBalancer) restart() {
+	a.ctx.sleep = 0
+	a.callbacks = &DefaultLoadBalancerCallbacks{Ctx: &a.ctx}
+	if r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+		r.Restore(a.ctx.persisted)
+	}
+}
+
+// accepts reports whether the actor to handles every message LoadBalancer sends
+func (a *LoadBalancer) accepts(to phony.Actor) bool {
+	_, ok := to.(LoadBalancerTarget)
+	return ok
+}
+
+// connect adds an edge to to
+func (a *LoadBalancer) connect(to phony.Actor) {
+	a.targets = append(a.targets, to.(LoadBalancerTarget))
+}
+
+// disconnect removes every edge to to
+func (a *LoadBalancer) disconnect(to phony.Actor) {
+	for i := len(a.targets) - 1; i >= 0; i-- {
+		if phony.Actor(a.targets[i]) == to {
+			a.targets = append(a.targets[:i], a.targets[i+1:]...)
 		}
-	}()
+	}
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *LoadBalancer) handler(kind string) (func(), bool) {
+	switch kind {
+	case "request":
+		return a.Request, true
+	}
+	return nil, false
 }
 
 func (a *LoadBalancer) Request() {
-	a.callbacks.OnRequest()
+	a.sys.middleware.Handle(HandlerContext{Actor: "load_balancer", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "request", a.handleRequest)
+}
+
+func (a *LoadBalancer) handleRequest() {
+	if err := a.callbacks.OnRequest(); err != nil {
+		a.sys.fail("load_balancer", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishRequest)
+}
+
+func (a *LoadBalancer) finishRequest() {
 	// Send to targets
+	a.sys.forwarded(len(a.targets))
 	for _, target := range a.targets {
-		target.Act(a, func() { target.Request() })
+		target := target
+		a.sys.send(a, target, func() { target.Request() })
+		a.copiesSent++
 	}
-	a.sendCount++
 }
-
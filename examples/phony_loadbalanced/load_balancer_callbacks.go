@@ -4,17 +4,16 @@
 
 package main
 
-import (
-	"fmt"
-)
-
-
 // DefaultLoadBalancerCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultLoadBalancerCallbacks struct{}
+type DefaultLoadBalancerCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
-func (c *DefaultLoadBalancerCallbacks) OnRequest() {
+func (c *DefaultLoadBalancerCallbacks) OnRequest() error {
 	// TODO: Implement custom behavior for request
-	fmt.Printf("LoadBalancer: Sending request message\n")
+	c.Ctx.Logf("LoadBalancer: Sending request message\n")
+	return nil
 }
-
@@ -0,0 +1,271 @@
+// Generated from ActorSimulation DSL
+// Pluggable metric sinks: Prometheus, expvar and an in-memory recorder
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricSink receives the metrics actors record as they run: counters
+// that only go up, gauges set to a value, and observations of a
+// distribution, such as latencies in seconds of virtual time
+// Every series is labeled with the actor that records it; a sink must be
+// safe to call from any actor
+type MetricSink interface {
+	Counter(name string, labels map[string]string, delta float64)
+	Gauge(name string, labels map[string]string, value float64)
+	Observe(name string, labels map[string]string, value float64)
+}
+
+// Option configures a system as NewSystem creates it
+type Option func(s *System)
+
+// WithMetricSink has every actor record its metrics on sink:
+// actor_messages_received_total, actor_message_latency_seconds,
+// actor_queue_time_seconds, actor_service_time_seconds and
+// actor_queue_depth
+// Without a sink actors record no metrics; forks and diffs run without one
+func WithMetricSink(sink MetricSink) Option {
+	return func(s *System) { s.metricSink = sink }
+}
+
+// meter records the metrics of an actor, or of one of its inboxes, on the
+// system's sink
+// The actor's own inbox counts arrivals as it hands them on to the
+// inboxes behind it, which time handling them
+type meter struct {
+	sink   MetricSink
+	labels map[string]string
+	inbox  bool
+	front  bool
+}
+
+// instrument names an actor and its inboxes and meters them under the
+// name
+func (s *System) instrument(name string, a actor) {
+	cs := contexts(a)
+	for _, c := range cs {
+		c.name = name
+	}
+	if s.metricSink == nil {
+		return
+	}
+	labels := map[string]string{"actor": name}
+	for i, c := range cs {
+		c.meter = &meter{sink: s.metricSink, labels: labels, inbox: i > 0, front: i == 0 && len(cs) > 1}
+	}
+}
+
+// arrived records a message that reached the actor, latency after it was
+// produced
+func (m *meter) arrived(latency time.Duration) {
+	if m == nil || m.inbox {
+		return
+	}
+	m.sink.Counter("actor_messages_received_total", m.labels, 1)
+	m.sink.Observe("actor_message_latency_seconds", m.labels, latency.Seconds())
+}
+
+// handled records how long a message waited and was worked on
+func (m *meter) handled(queued, service time.Duration) {
+	if m == nil || m.front {
+		return
+	}
+	m.sink.Observe("actor_queue_time_seconds", m.labels, queued.Seconds())
+	m.sink.Observe("actor_service_time_seconds", m.labels, service.Seconds())
+}
+
+// queued records n messages waiting for the actor
+// Safe to call from any actor
+func (m *meter) queued(n int64) {
+	if m == nil {
+		return
+	}
+	m.sink.Gauge("actor_queue_depth", m.labels, float64(n))
+}
+
+// series names a metric's series as Prometheus writes it, with its labels
+// sorted, e.g. actor_queue_depth{actor="sink"}
+func series(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + strconv.Quote(labels[key])
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// MetricRecorder keeps every metric recorded in memory, for tests to
+// check what actors recorded in a run
+type MetricRecorder struct {
+	mu           sync.Mutex
+	counters     map[string]float64
+	gauges       map[string]float64
+	observations map[string][]float64
+}
+
+// NewMetricRecorder creates an empty recorder
+func NewMetricRecorder() *MetricRecorder {
+	return &MetricRecorder{counters: map[string]float64{}, gauges: map[string]float64{}, observations: map[string][]float64{}}
+}
+
+func (r *MetricRecorder) Counter(name string, labels map[string]string, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[series(name, labels)] += delta
+}
+
+func (r *MetricRecorder) Gauge(name string, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[series(name, labels)] = value
+}
+
+func (r *MetricRecorder) Observe(name string, labels map[string]string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := series(name, labels)
+	r.observations[key] = append(r.observations[key], value)
+}
+
+// CounterValue returns the sum of what was added to a counter
+func (r *MetricRecorder) CounterValue(name string, labels map[string]string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[series(name, labels)]
+}
+
+// GaugeValue returns the value a gauge was last set to
+func (r *MetricRecorder) GaugeValue(name string, labels map[string]string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gauges[series(name, labels)]
+}
+
+// Observations returns the values observed of a distribution, in order
+func (r *MetricRecorder) Observations(name string, labels map[string]string) []float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]float64(nil), r.observations[series(name, labels)]...)
+}
+
+// ExpvarSink publishes metrics in an expvar map, one entry per series:
+// counters and gauges as their value, observations as their count and sum
+type ExpvarSink struct {
+	vars *expvar.Map
+}
+
+// NewExpvarSink publishes metrics at /debug/vars under name
+func NewExpvarSink(name string) ExpvarSink {
+	vars, ok := expvar.Get(name).(*expvar.Map)
+	if !ok {
+		vars = expvar.NewMap(name)
+	}
+	return ExpvarSink{vars: vars}
+}
+
+func (s ExpvarSink) Counter(name string, labels map[string]string, delta float64) {
+	s.vars.AddFloat(series(name, labels), delta)
+}
+
+func (s ExpvarSink) Gauge(name string, labels map[string]string, value float64) {
+	v := new(expvar.Float)
+	v.Set(value)
+	s.vars.Set(series(name, labels), v)
+}
+
+func (s ExpvarSink) Observe(name string, labels map[string]string, value float64) {
+	s.vars.AddFloat(series(name+"_count", labels), 1)
+	s.vars.AddFloat(series(name+"_sum", labels), value)
+}
+
+// PrometheusSink keeps metrics for Prometheus to scrape, served in its
+// text format: counters and gauges as their value, observations as a
+// summary of their count and sum
+type PrometheusSink struct {
+	mu     sync.Mutex
+	types  map[string]string
+	values map[string]map[string]float64
+}
+
+// NewPrometheusSink creates a sink to serve, e.g. at /metrics
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{types: map[string]string{}, values: map[string]map[string]float64{}}
+}
+
+func (p *PrometheusSink) Counter(name string, labels map[string]string, delta float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metric(name, "counter")[series(name, labels)] += delta
+}
+
+func (p *PrometheusSink) Gauge(name string, labels map[string]string, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metric(name, "gauge")[series(name, labels)] = value
+}
+
+func (p *PrometheusSink) Observe(name string, labels map[string]string, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	values := p.metric(name, "summary")
+	values[series(name+"_count", labels)]++
+	values[series(name+"_sum", labels)] += value
+}
+
+// metric returns the series of a metric, keyed by name and labels,
+// adding the metric with its type if it is new
+func (p *PrometheusSink) metric(name, kind string) map[string]float64 {
+	values, ok := p.values[name]
+	if !ok {
+		values = map[string]float64{}
+		p.values[name] = values
+		p.types[name] = kind
+	}
+	return values
+}
+
+// WriteText writes every metric in the Prometheus text format, sorted
+func (p *PrometheusSink) WriteText(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.values))
+	for name := range p.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, p.types[name])
+		keys := make([]string, 0, len(p.values[name]))
+		for key := range p.values[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s %v\n", key, p.values[name][key])
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape
+func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteText(w)
+}
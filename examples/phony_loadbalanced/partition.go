@@ -0,0 +1,123 @@
+// Generated from ActorSimulation DSL
+// Network partitions between groups of actors
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"time"
+)
+
+// PartitionStep splits the system into Groups at At and heals it at
+// Heal, both on the system clock
+type PartitionStep struct {
+	At     time.Duration
+	Heal   time.Duration
+	Groups [][]string
+}
+
+// PartitionPlan holds the :partitions generator option
+var PartitionPlan []PartitionStep
+
+// Partition cuts every edge between actors in different groups, dropping
+// the messages sent across it until Heal; actors left out of every group
+// form one more group
+// An actor spread over several inboxes moves with all of them
+func (s *System) Partition(groups ...[]string) error {
+	if err := s.partition(groups...); err != nil {
+		return err
+	}
+	s.record(func(s *System) { s.Partition(groups...) })
+	return nil
+}
+
+// partition is Partition for the steps of a plan, which Fork replays by
+// scheduling the plan again
+func (s *System) partition(groups ...[]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	membership := map[*messageContext]int32{}
+	for i, group := range groups {
+		for _, name := range group {
+			a, ok := s.actors[name]
+			if !ok {
+				return fmt.Errorf("cannot partition unknown actor %q", name)
+			}
+			for _, c := range contexts(a) {
+				membership[c] = int32(i + 1)
+			}
+		}
+	}
+	for _, a := range s.actors {
+		for _, c := range contexts(a) {
+			c.group.Store(membership[c])
+		}
+	}
+	return nil
+}
+
+// Heal joins every group back together
+func (s *System) Heal() {
+	s.Partition()
+}
+
+// SchedulePartitions partitions the system and heals it again as each
+// step of plan falls due
+func (s *System) SchedulePartitions(plan []PartitionStep) error {
+	for _, step := range plan {
+		if step.Heal < step.At {
+			return fmt.Errorf("partition at %v heals before it starts, at %v", step.At, step.Heal)
+		}
+		for _, group := range step.Groups {
+			for _, name := range group {
+				s.mu.Lock()
+				_, ok := s.actors[name]
+				s.mu.Unlock()
+				if !ok {
+					return fmt.Errorf("cannot partition unknown actor %q", name)
+				}
+			}
+		}
+	}
+	now := s.clock.Now()
+	for _, step := range plan {
+		step := step
+		s.schedule(nil, step.At-now, func() { s.partition(step.Groups...) })
+		s.schedule(nil, step.Heal-now, func() { s.partition() })
+	}
+	s.record(func(s *System) { s.SchedulePartitions(plan) })
+	return nil
+}
+
+// PartitionedCount returns the number of messages dropped because a
+// partition separated their sender from their target
+func (s *System) PartitionedCount() int {
+	return int(s.partitioned.Load())
+}
+
+// severed reports whether a partition separates from and to
+func (s *System) severed(from, to phony.Actor) bool {
+	return from.(contextual).context().group.Load() != to.(contextual).context().group.Load()
+}
+
+// cut reports whether a partition separates from and to, counting the
+// message it drops if so
+func (s *System) cut(from, to phony.Actor) bool {
+	if !s.severed(from, to) {
+		return false
+	}
+	s.partitioned.Add(1)
+	s.ledger.dropped.Add(1)
+	return true
+}
+
+// contexts returns the message contexts of an actor and of its inboxes
+func contexts(a actor) []*messageContext {
+	cs := []*messageContext{a.(contextual).context()}
+	if sharded, ok := a.(interface{ inboxes() []*messageContext }); ok {
+		cs = append(cs, sharded.inboxes()...)
+	}
+	return cs
+}
@@ -0,0 +1,93 @@
+// Generated from ActorSimulation DSL
+// Checks the Phony semantics the generated actors rely on
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"github.com/Arceliar/phony"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// inbox is a bare Phony actor recording what it runs
+type inbox struct {
+	phony.Inbox
+	running atomic.Int32
+	seen    []int
+}
+
+func TestPhonyActSerializesPerInbox(t *testing.T) {
+	var a inbox
+	var overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				j := j
+				a.Act(nil, func() {
+					if a.running.Add(1) != 1 {
+						overlaps.Add(1)
+					}
+					a.seen = append(a.seen, j)
+					a.running.Add(-1)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	phony.Block(&a, func() { n = len(a.seen) })
+	if o := overlaps.Load(); o > 0 {
+		t.Fatalf("phony ran %d messages at once on one inbox; generated actors need Act to serialize per inbox", o)
+	}
+	if n != 8000 {
+		t.Fatalf("expected phony to run all 8000 messages, ran %d", n)
+	}
+}
+
+func TestPhonyActKeepsOrderFromOneSender(t *testing.T) {
+	var sender, receiver inbox
+	phony.Block(&sender, func() {
+		for i := 0; i < 1000; i++ {
+			i := i
+			receiver.Act(&sender, func() { receiver.seen = append(receiver.seen, i) })
+		}
+	})
+
+	phony.Block(&receiver, func() {
+		for i, n := range receiver.seen {
+			if n != i {
+				t.Fatalf("phony delivered message %d of one sender as number %d; generated actors need per-sender order", n, i)
+			}
+		}
+		if len(receiver.seen) != 1000 {
+			t.Fatalf("expected phony to deliver all 1000 messages, delivered %d", len(receiver.seen))
+		}
+	})
+}
+
+func TestPhonyBlockWaitsForQueuedMessages(t *testing.T) {
+	var a inbox
+	for i := 0; i < 100; i++ {
+		i := i
+		a.Act(nil, func() { a.seen = append(a.seen, i) })
+	}
+
+	ran := false
+	var n int
+	phony.Block(&a, func() {
+		ran = true
+		n = len(a.seen)
+	})
+	if !ran {
+		t.Fatal("phony.Block returned before running its function; the virtual clock needs it to wait")
+	}
+	if n != 100 {
+		t.Fatalf("phony.Block ran after %d of the 100 messages queued before it; it must wait its turn", n)
+	}
+}
@@ -0,0 +1,183 @@
+// Generated from ActorSimulation DSL
+// Actor: server
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"github.com/Arceliar/phony"
+)
+
+// ServerCallbacks defines the callback interface
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
+type ServerCallbacks interface {
+	OnRequest() error
+}
+
+// ServerTarget is implemented by every actor Server sends to
+type ServerTarget interface {
+	phony.Actor
+	Request()
+}
+
+type Server struct {
+	phony.Inbox
+	sys *System
+	messageContext
+	targets    []ServerTarget
+	callbacks  ServerCallbacks
+	ctx        Context
+	copiesSent int
+	shards     []*Server
+	next       int
+}
+
+func (a *Server) Actor() *phony.Inbox {
+	return &a.Inbox
+}
+
+func (a *Server) Start() {
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultServerCallbacks{Ctx: &a.ctx}
+	for _, shard := range a.shards {
+		shard.Start()
+	}
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Server) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Server) SendCount() int {
+	var n int
+	a.eachShard(func(a *Server) { n += a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Server) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Server) QueueTimeStats() TimeStats {
+	var l latencies
+	a.eachShard(func(a *Server) {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Server) ServiceTimeStats() TimeStats {
+	var l latencies
+	a.eachShard(func(a *Server) {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// nextShard returns the inbox the next message goes to, taking each
+// in turn
+func (a *Server) nextShard() *Server {
+	shard := a.shards[a.next]
+	a.next = (a.next + 1) % len(a.shards)
+	return shard
+}
+
+// eachShard runs f on each of the actor's inboxes in turn, so counters
+// read through it add up over all of them
+// Safe to call from outside the actor
+func (a *Server) eachShard(f func(a *Server)) {
+	for _, shard := range a.shards {
+		phony.Block(shard, func() { f(shard) })
+	}
+}
+
+// inboxes returns the contexts of the actor's inboxes, whose queues
+// the report takes into account
+func (a *Server) inboxes() []*messageContext {
+	contexts := make([]*messageContext, len(a.shards))
+	for i, shard := range a.shards {
+		contexts[i] = shard.context()
+	}
+	return contexts
+}
+
+// accepts reports whether the actor to handles every message Server sends
+func (a *Server) accepts(to phony.Actor) bool {
+	_, ok := to.(ServerTarget)
+	return ok
+}
+
+// connect adds an edge to to
+func (a *Server) connect(to phony.Actor) {
+	a.targets = append(a.targets, to.(ServerTarget))
+	for _, shard := range a.shards {
+		phony.Block(shard, func() { shard.connect(to) })
+	}
+}
+
+// disconnect removes every edge to to
+func (a *Server) disconnect(to phony.Actor) {
+	for i := len(a.targets) - 1; i >= 0; i-- {
+		if phony.Actor(a.targets[i]) == to {
+			a.targets = append(a.targets[:i], a.targets[i+1:]...)
+		}
+	}
+	for _, shard := range a.shards {
+		phony.Block(shard, func() { shard.disconnect(to) })
+	}
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *Server) handler(kind string) (func(), bool) {
+	switch kind {
+	case "request":
+		return a.Request, true
+	}
+	return nil, false
+}
+
+func (a *Server) Request() {
+	if a.shards != nil {
+		// Hand the message on to the next inbox, which handles it
+		shard := a.nextShard()
+		a.sys.send(a, shard, func() { shard.Request() })
+		return
+	}
+	a.sys.middleware.Handle(HandlerContext{Actor: "server", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "request", a.handleRequest)
+}
+
+func (a *Server) handleRequest() {
+	if err := a.callbacks.OnRequest(); err != nil {
+		a.sys.fail("server", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishRequest)
+}
+
+func (a *Server) finishRequest() {
+	// Send to targets
+	a.sys.forwarded(len(a.targets))
+	for _, target := range a.targets {
+		target := target
+		a.sys.send(a, target, func() { target.Request() })
+		a.copiesSent++
+	}
+}
@@ -1,13 +0,0 @@
-// Generated from ActorSimulation DSL
-// Default callback implementation for: server1
-// CUSTOMIZE THIS FILE - This is where you add your custom behavior!
-
-package main
-
-
-
-// DefaultServer1Callbacks provides default implementations
-// CUSTOMIZE THIS to add your own behavior!
-type DefaultServer1Callbacks struct{}
-
-
@@ -1,13 +0,0 @@
-// Generated from ActorSimulation DSL
-// Default callback implementation for: server2
-// CUSTOMIZE THIS FILE - This is where you add your custom behavior!
-
-package main
-
-
-
-// DefaultServer2Callbacks provides default implementations
-// CUSTOMIZE THIS to add your own behavior!
-type DefaultServer2Callbacks struct{}
-
-
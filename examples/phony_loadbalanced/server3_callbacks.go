@@ -1,13 +0,0 @@
-// Generated from ActorSimulation DSL
-// Default callback implementation for: server3
-// CUSTOMIZE THIS FILE - This is where you add your custom behavior!
-
-package main
-
-
-
-// DefaultServer3Callbacks provides default implementations
-// CUSTOMIZE THIS to add your own behavior!
-type DefaultServer3Callbacks struct{}
-
-
@@ -0,0 +1,154 @@
+// Generated from ActorSimulation DSL
+// Trace sinks recording every message delivered: in memory and JSON lines,
+// and Mermaid sequence diagrams of a trace
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceEvent is a message an actor handled: when, on the system's clock,
+// which actor sent it, as HandlerContext.From has it, which handled it,
+// its kind and its ID
+type TraceEvent struct {
+	Time    time.Duration `json:"time"`
+	From    string        `json:"from,omitempty"`
+	To      string        `json:"to"`
+	Message string        `json:"message"`
+	ID      MessageID     `json:"id"`
+}
+
+// TraceSink receives an event for every message an actor handles, as it
+// starts handling it
+// On a RealClock actors record events concurrently, so a sink must be
+// safe to call from any actor
+type TraceSink interface {
+	Record(e TraceEvent)
+}
+
+// WithTraceSink records every message the actors handle on sink, as
+// middleware running outside any registered with Use, so tracing a run
+// takes no change to the actors
+// Forks and diffs run without it
+func WithTraceSink(sink TraceSink) Option {
+	return func(s *System) {
+		s.middleware = append(s.middleware, MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+			sink.Record(TraceEvent{Time: ctx.Now, From: ctx.From, To: ctx.Actor, Message: msg, ID: ctx.ID})
+			next()
+		}))
+	}
+}
+
+// TraceRecorder keeps every event recorded in memory, for tests to check
+// the order messages were handled in
+type TraceRecorder struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+// NewTraceRecorder creates an empty recorder
+func NewTraceRecorder() *TraceRecorder {
+	return &TraceRecorder{}
+}
+
+func (r *TraceRecorder) Record(e TraceEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// Events returns the events recorded so far, in the order they were
+func (r *TraceRecorder) Events() []TraceEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]TraceEvent(nil), r.events...)
+}
+
+// JSONLSink writes every event to a writer as a line of JSON, for tools
+// such as jq to read
+type JSONLSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewJSONLSink writes events to w
+func NewJSONLSink(w io.Writer) *JSONLSink {
+	return &JSONLSink{enc: json.NewEncoder(w)}
+}
+
+// Record writes e on a line of its own; once a write fails the sink
+// writes nothing more
+func (j *JSONLSink) Record(e TraceEvent) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err == nil {
+		j.err = j.enc.Encode(e)
+	}
+}
+
+// Err returns the error the first failed write returned, if any
+func (j *JSONLSink) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// MermaidWindow picks the part of a trace RenderMermaid draws: the events
+// from From on and before Until, zero for the end of the trace, and of
+// their messages at most Max arrows, zero for no cap
+type MermaidWindow struct {
+	From  time.Duration
+	Until time.Duration
+	Max   int
+}
+
+// RenderMermaid writes the events of trace in window as a Mermaid
+// sequenceDiagram: a participant per actor, in the order they appear,
+// and an arrow per message sent from one actor to another, in the order
+// they were handled, labelled with its kind and time
+// A message with no sender adds its actor but no arrow; a note says how
+// many arrows the cap left out
+func RenderMermaid(trace []TraceEvent, w io.Writer, window MermaidWindow) error {
+	var participants, arrows strings.Builder
+	seen := map[string]bool{}
+	var first string
+	appear := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		if first == "" {
+			first = name
+		}
+		seen[name] = true
+		fmt.Fprintf(&participants, "    participant %s\n", name)
+	}
+	drawn, left := 0, 0
+	for _, e := range trace {
+		if e.Time < window.From || window.Until > 0 && e.Time >= window.Until {
+			continue
+		}
+		appear(e.From)
+		appear(e.To)
+		switch {
+		case e.From == "":
+		case window.Max > 0 && drawn == window.Max:
+			left++
+		default:
+			fmt.Fprintf(&arrows, "    %s->>%s: %s at %v\n", e.From, e.To, e.Message, e.Time)
+			drawn++
+		}
+	}
+	if left > 0 {
+		fmt.Fprintf(&arrows, "    Note over %s: %d more messages left out\n", first, left)
+	}
+	_, err := io.WriteString(w, "sequenceDiagram\n"+participants.String()+arrows.String())
+	return err
+}
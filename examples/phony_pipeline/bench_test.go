@@ -0,0 +1,239 @@
+// Generated from ActorSimulation DSL
+// Benchmarks Phony against channel dispatch on the spec's topology
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"github.com/Arceliar/phony"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ActorRuntime runs the spec's actors on one way of dispatching messages,
+// each actor forwarding every message it handles to all of its targets,
+// so the same benchmark measures every runtime on the same topology
+type ActorRuntime interface {
+	// Send hands n copies of message to the named actor
+	Send(name, message string, n int)
+	// Wait blocks until every message sent or forwarded has been handled
+	Wait()
+	// Handled returns the number of messages the actors handled, once Wait returns
+	Handled() int
+	// Stop shuts the actors down
+	Stop()
+}
+
+// benchTopology lists the targets of every actor, as the spec wires them
+var benchTopology = map[string][]string{
+	"source": {"stage1"},
+	"stage1": {"stage2"},
+	"stage2": {"stage3"},
+	"stage3": {"sink"},
+	"sink":   {},
+}
+
+// benchSource originates count messages at a time, as one tick of its
+// send pattern does
+type benchSource struct {
+	name, message string
+	count         int
+}
+
+var benchSources = []benchSource{
+	{"source", "data", 1},
+}
+
+// benchBuffer is the capacity of every channel actor's inbox
+const benchBuffer = 64
+
+// benchRuntimes builds every ActorRuntime the benchmark compares
+var benchRuntimes = []struct {
+	name  string
+	start func() ActorRuntime
+}{
+	{"phony", func() ActorRuntime { return newPhonyRuntime(benchTopology) }},
+	{"channel", func() ActorRuntime { return newChannelRuntime(benchTopology, benchBuffer) }},
+}
+
+// phonyNode is an actor on a Phony inbox
+type phonyNode struct {
+	phony.Inbox
+	targets []*phonyNode
+	handled int
+}
+
+// phonyRuntime dispatches as the generated actors do: a message is a
+// closure run on the receiver's inbox, sent with Act from the sender's
+type phonyRuntime struct {
+	nodes   map[string]*phonyNode
+	pending sync.WaitGroup
+}
+
+func newPhonyRuntime(topology map[string][]string) *phonyRuntime {
+	r := &phonyRuntime{nodes: map[string]*phonyNode{}}
+	for name := range topology {
+		r.nodes[name] = &phonyNode{}
+	}
+	for name, targets := range topology {
+		for _, target := range targets {
+			r.nodes[name].targets = append(r.nodes[name].targets, r.nodes[target])
+		}
+	}
+	return r
+}
+
+func (r *phonyRuntime) Send(name, message string, n int) {
+	node := r.nodes[name]
+	r.pending.Add(n)
+	for i := 0; i < n; i++ {
+		node.Act(nil, func() { r.handle(node, message) })
+	}
+}
+
+// handle runs on node's inbox and forwards message to its targets
+func (r *phonyRuntime) handle(node *phonyNode, message string) {
+	node.handled++
+	r.pending.Add(len(node.targets))
+	for _, target := range node.targets {
+		target := target
+		target.Act(node, func() { r.handle(target, message) })
+	}
+	r.pending.Done()
+}
+
+func (r *phonyRuntime) Wait() {
+	r.pending.Wait()
+}
+
+func (r *phonyRuntime) Handled() int {
+	n := 0
+	for _, node := range r.nodes {
+		n += node.handled
+	}
+	return n
+}
+
+// Stop has nothing to shut down: an idle Phony inbox holds no goroutine
+func (r *phonyRuntime) Stop() {}
+
+// channelNode is an actor on a goroutine of its own
+type channelNode struct {
+	inbox   chan string
+	targets []*channelNode
+	handled int
+}
+
+// channelRuntime dispatches as hand-written Go actors often do: each
+// actor selects over a buffered inbox channel and the runtime's done
+// channel
+// Unlike a Phony inbox, a full channel blocks its sender
+type channelRuntime struct {
+	nodes   map[string]*channelNode
+	pending sync.WaitGroup
+	running sync.WaitGroup
+	done    chan struct{}
+}
+
+func newChannelRuntime(topology map[string][]string, buffer int) *channelRuntime {
+	r := &channelRuntime{nodes: map[string]*channelNode{}, done: make(chan struct{})}
+	for name := range topology {
+		r.nodes[name] = &channelNode{inbox: make(chan string, buffer)}
+	}
+	for name, targets := range topology {
+		for _, target := range targets {
+			r.nodes[name].targets = append(r.nodes[name].targets, r.nodes[target])
+		}
+	}
+	r.running.Add(len(r.nodes))
+	for _, node := range r.nodes {
+		go r.run(node)
+	}
+	return r
+}
+
+// run handles node's messages until the runtime stops
+func (r *channelRuntime) run(node *channelNode) {
+	defer r.running.Done()
+	for {
+		select {
+		case message := <-node.inbox:
+			node.handled++
+			r.pending.Add(len(node.targets))
+			for _, target := range node.targets {
+				target.inbox <- message
+			}
+			r.pending.Done()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *channelRuntime) Send(name, message string, n int) {
+	node := r.nodes[name]
+	r.pending.Add(n)
+	for i := 0; i < n; i++ {
+		node.inbox <- message
+	}
+}
+
+func (r *channelRuntime) Wait() {
+	r.pending.Wait()
+}
+
+func (r *channelRuntime) Handled() int {
+	n := 0
+	for _, node := range r.nodes {
+		n += node.handled
+	}
+	return n
+}
+
+func (r *channelRuntime) Stop() {
+	close(r.done)
+	r.running.Wait()
+}
+
+// sendAll hands every source its messages rounds times
+func sendAll(r ActorRuntime, rounds int) {
+	for i := 0; i < rounds; i++ {
+		for _, source := range benchSources {
+			r.Send(source.name, source.message, source.count)
+		}
+	}
+}
+
+// BenchmarkDispatch sends every source's messages b.N times through each
+// runtime and reports the messages handled per second, across all actors
+// Compare the runtimes with go test -run ^$ -bench Dispatch -benchmem
+func BenchmarkDispatch(b *testing.B) {
+	for _, rt := range benchRuntimes {
+		rt := rt
+		b.Run(rt.name, func(b *testing.B) {
+			r := rt.start()
+			defer r.Stop()
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			sendAll(r, b.N)
+			r.Wait()
+			b.ReportMetric(float64(r.Handled())/time.Since(start).Seconds(), "msgs/s")
+		})
+	}
+}
+
+func TestRuntimesHandleAlike(t *testing.T) {
+	handled := map[string]int{}
+	for _, rt := range benchRuntimes {
+		r := rt.start()
+		sendAll(r, 100)
+		r.Wait()
+		r.Stop()
+		handled[rt.name] = r.Handled()
+	}
+	if handled["phony"] == 0 || handled["phony"] != handled["channel"] {
+		t.Fatalf("expected every runtime to handle the same messages, got %v", handled)
+	}
+}
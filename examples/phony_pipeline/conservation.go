@@ -0,0 +1,87 @@
+// Generated from ActorSimulation DSL
+// Message conservation check
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// ledger accounts for every copy of a message: sources and Act produce
+// messages, fan-out and fallbacks copy them, and each copy is eventually
+// sunk, dropped, expired unmatched in a join window or past its TTL, or
+// still in flight
+type ledger struct {
+	produced atomic.Int64
+	copied   atomic.Int64
+	sunk     atomic.Int64
+	dropped  atomic.Int64
+	expired  atomic.Int64
+	inflight atomic.Int64
+}
+
+// queuer is an actor holding messages back in a fair queue, join window
+// or reorder buffer
+type queuer interface {
+	queued() int
+}
+
+// CheckConservation reports messages created or lost unaccountably:
+// everything produced or copied must have been sunk, dropped, expired or
+// still be in flight, counting messages queued behind busy actors
+// Call it while no handler runs, such as between Advance calls on a
+// VirtualClock or once the system is quiescent
+func (s *System) CheckConservation() error {
+	s.mu.Lock()
+	actors := make([]actor, 0, len(s.actors))
+	for _, a := range s.actors {
+		actors = append(actors, a)
+	}
+	s.mu.Unlock()
+
+	inflight := s.ledger.inflight.Load()
+	for _, a := range actors {
+		if q, ok := a.(queuer); ok {
+			inflight += int64(q.queued())
+		}
+	}
+	produced, copied := s.ledger.produced.Load(), s.ledger.copied.Load()
+	sunk, dropped, expired := s.ledger.sunk.Load(), s.ledger.dropped.Load(), s.ledger.expired.Load()
+	if produced+copied != sunk+dropped+expired+inflight {
+		return fmt.Errorf("%d messages produced and %d copied, but %d sunk, %d dropped, %d expired and %d in flight",
+			produced, copied, sunk, dropped, expired, inflight)
+	}
+	return nil
+}
+
+// produce originates a message at the named source, keyed by its
+// sequence number there, given the next message ID and traced if it is
+// sampled
+// A source down after a crash produces nothing
+func (s *System) produce(source contextual, name string, handle func()) {
+	c := source.context()
+	if c.down {
+		return
+	}
+	s.ledger.produced.Add(1)
+	c.produced++
+	c.header = header{id: MessageID{Source: name, Seq: s.NextID()}, key: c.produced, born: s.clock.Now()}
+	c.header.Trace = s.sample()
+	c.header.enqueued = c.header.born
+	if c.budget > 0 {
+		c.header.Deadline = c.header.born + c.budget
+	}
+	handle()
+}
+
+// forwarded accounts for a handled message sent on to n targets: it
+// carries on as one of the copies, or is sunk when there are no targets
+func (s *System) forwarded(n int) {
+	if n == 0 {
+		s.ledger.sunk.Add(1)
+		return
+	}
+	s.ledger.copied.Add(int64(n - 1))
+}
@@ -0,0 +1,32 @@
+// Generated from ActorSimulation DSL
+// Actor metrics published through expvar
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"expvar"
+)
+
+// metrics appears at /debug/vars under gen_server_virtual_time
+var metrics = expvar.NewMap("gen_server_virtual_time")
+
+// PublishMetrics exposes every actor's counters through expvar
+// Counters are read on demand, so they stay current while the system runs
+func (s *System) PublishMetrics() {
+	metrics.Set("source", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.source.SendCount()}
+	}))
+	metrics.Set("stage1", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.stage1.SendCount()}
+	}))
+	metrics.Set("stage2", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.stage2.SendCount()}
+	}))
+	metrics.Set("stage3", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.stage3.SendCount()}
+	}))
+	metrics.Set("sink", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.sink.SendCount()}
+	}))
+}
@@ -0,0 +1,154 @@
+// Generated from ActorSimulation DSL
+// Forks of a run in virtual time
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Snapshot marks a point in a run on a VirtualClock for Fork to branch
+// from: the seed, every change made to the system on the way and how
+// many timers had run
+type Snapshot struct {
+	At      time.Duration
+	seed    int64
+	policy  Policy
+	steps   uint64
+	history []change
+}
+
+// change is a call that changed the system, made once steps timers had
+// run and virtual time had reached at
+type change struct {
+	at    time.Duration
+	steps uint64
+	apply func(s *System)
+}
+
+// Snapshot captures the run so far; the system must run on a VirtualClock
+// Safe to call between advances of the clock
+func (s *System) Snapshot() Snapshot {
+	clock := s.clock.(*VirtualClock)
+	clock.mu.Lock()
+	snap := Snapshot{At: clock.now, seed: s.seed, policy: clock.policy, steps: clock.steps}
+	clock.mu.Unlock()
+	s.mu.Lock()
+	snap.history = append([]change(nil), s.history...)
+	s.mu.Unlock()
+	return snap
+}
+
+// Fork returns a system in the state snap captured, on a VirtualClock of
+// its own, to run on apart from the original, with different parameters
+// if need be
+// A run in virtual time is reproducible, so Fork replays the seed and the
+// changes to rebuild every actor, queue and pending timer as they were;
+// the fork shares the original's middleware, and changes made to
+// unexported fields are not replayed
+func Fork(snap Snapshot) *System {
+	clock := NewVirtualClock()
+	clock.SetPolicy(snap.policy)
+	s := NewSystem(snap.seed, clock)
+	for _, c := range snap.history {
+		clock.replay(c.steps, c.at)
+		c.apply(s)
+	}
+	clock.replay(snap.steps, snap.At)
+	return s
+}
+
+// record notes a change for Fork to replay, when the system runs on a
+// VirtualClock
+func (s *System) record(apply func(s *System)) {
+	clock, ok := s.clock.(*VirtualClock)
+	if !ok {
+		return
+	}
+	clock.mu.Lock()
+	c := change{at: clock.now, steps: clock.steps, apply: apply}
+	clock.mu.Unlock()
+	s.mu.Lock()
+	s.history = append(s.history, c)
+	s.mu.Unlock()
+}
+
+// replay runs timers until steps of them have run, then moves virtual
+// time to at, retracing a run that got there the same way
+func (c *VirtualClock) replay(steps uint64, at time.Duration) {
+	for {
+		c.mu.Lock()
+		done := c.steps >= steps
+		c.mu.Unlock()
+		if done || !c.Step() {
+			break
+		}
+	}
+	c.mu.Lock()
+	c.now = at
+	c.mu.Unlock()
+}
+
+// Compare lays reports out side by side, such as those of forks of one
+// run given different parameters: one column per named report and one
+// row per actor and counter, with - where a report has no such actor
+func Compare(reports map[string]Report) string {
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var actors []string
+	rows := map[string]map[string]ActorReport{}
+	for _, name := range names {
+		for _, a := range reports[name].Actors {
+			if rows[a.Name] == nil {
+				rows[a.Name] = map[string]ActorReport{}
+				actors = append(actors, a.Name)
+			}
+			rows[a.Name][name] = a
+		}
+	}
+	counters := []struct {
+		name  string
+		value func(a ActorReport) any
+	}{
+		{"sent", func(a ActorReport) any { return a.Sent }},
+		{"received", func(a ActorReport) any { return a.Received }},
+		{"dropped", func(a ActorReport) any { return a.Dropped }},
+		{"p99", func(a ActorReport) any { return a.P99 }},
+		{"peak queue", func(a ActorReport) any { return a.PeakQueue }},
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "ACTOR\tCOUNTER")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s", name)
+	}
+	fmt.Fprintln(w)
+	for _, actor := range actors {
+		for i, c := range counters {
+			label := ""
+			if i == 0 {
+				label = actor
+			}
+			fmt.Fprintf(w, "%s\t%s", label, c.name)
+			for _, name := range names {
+				if a, ok := rows[actor][name]; ok {
+					fmt.Fprintf(w, "\t%v", c.value(a))
+				} else {
+					fmt.Fprint(w, "\t-")
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,57 @@
+// Generated from ActorSimulation DSL
+// Middleware around message handlers
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"time"
+)
+
+// HandlerContext describes the handler a middleware wraps
+// From is the actor that sent the message, empty for one the actor
+// produced as a source or that was delivered with Act; ID identifies it
+// across the system; Key is its sequence number at the source that
+// produced it; Headers are the ones it travels under, whatever its
+// payload, which is nil unless it came in an Envelope
+type HandlerContext struct {
+	Actor string
+	From  string
+	Now   time.Duration
+	ID    MessageID
+	Key   uint64
+	Headers
+	Payload any
+}
+
+// Middleware wraps every message handler with cross-cutting logic such
+// as logging, timing or tracing
+// Handle must call next exactly once to run the handler
+type Middleware interface {
+	Handle(ctx HandlerContext, msg string, next func())
+}
+
+// MiddlewareFunc adapts a function to Middleware
+type MiddlewareFunc func(ctx HandlerContext, msg string, next func())
+
+func (f MiddlewareFunc) Handle(ctx HandlerContext, msg string, next func()) {
+	f(ctx, msg, next)
+}
+
+// Use registers middleware around the handlers of every actor
+// The first middleware registered runs outermost; call Use before Start
+func (s *System) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+	s.record(func(s *System) { s.Use(middleware...) })
+}
+
+// chain runs a handler through every registered middleware
+type chain []Middleware
+
+func (c chain) Handle(ctx HandlerContext, msg string, next func()) {
+	for i := len(c) - 1; i >= 0; i-- {
+		m, inner := c[i], next
+		next = func() { m.Handle(ctx, msg, inner) }
+	}
+	next()
+}
@@ -0,0 +1,47 @@
+// Generated from ActorSimulation DSL
+// Progress of a long run, reported over time
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressInfo is a snapshot of a run in progress: the clock's time, the
+// messages the actors have handled so far and how many a second of clock
+// time they handled since the snapshot before
+type ProgressInfo struct {
+	At        time.Duration
+	Processed int64
+	Rate      float64
+}
+
+// String describes the snapshot on one line, e.g. for a log
+func (p ProgressInfo) String() string {
+	return fmt.Sprintf("%v: %d messages processed, %.1f/s", p.At, p.Processed, p.Rate)
+}
+
+// OnProgress calls f with a snapshot of the run once per interval of the
+// system's clock from now on, so a long run can show a progress bar or log
+// milestones instead of appearing hung; unlike Report it only reads a
+// counter, which keeps it cheap enough for short intervals
+// Call it before Start, as it counts handled messages with middleware
+func (s *System) OnProgress(interval time.Duration, f func(ProgressInfo)) {
+	var processed atomic.Int64
+	s.middleware = append(s.middleware, MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		processed.Add(1)
+		next()
+	}))
+	last, lastAt := int64(0), s.clock.Now()
+	var report func()
+	report = func() {
+		s.schedule(nil, interval, report)
+		n, now := processed.Load(), s.clock.Now()
+		f(ProgressInfo{At: now, Processed: n, Rate: float64(n-last) / (now - lastAt).Seconds()})
+		last, lastAt = n, now
+	}
+	s.schedule(nil, interval, report)
+}
@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultSinkCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultSinkCallbacks struct{}
-
+type DefaultSinkCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultSinkCallbacks) OnData() error {
+	// TODO: Implement custom behavior for data
+	c.Ctx.Logf("Sink: Received data message\n")
+	return nil
+}
@@ -0,0 +1,81 @@
+// Generated from ActorSimulation DSL
+// Message sizes and the bytes each edge carries
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// links counts the bytes an actor has sent on each of its edges, keyed
+// by target
+type links map[phony.Actor]int64
+
+// add counts n bytes sent to to
+func (l *links) add(to phony.Actor, n int64) {
+	if *l == nil {
+		*l = links{}
+	}
+	(*l)[to] += n
+}
+
+// named keys the counts of links by the names of their targets
+func (s *System) named(l links) map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bytes := map[string]int64{}
+	for name, a := range s.actors {
+		if n := l[a]; n > 0 {
+			bytes[name] = n
+		}
+	}
+	return bytes
+}
+
+// LinkReport sums up an edge: the bytes its actor sent on it and their
+// rate over the run
+type LinkReport struct {
+	From      string
+	To        string
+	Bytes     int64
+	PerSecond float64
+}
+
+// link appends a row for each edge an actor has sent bytes on, sorted by
+// target
+func (r *Report) link(from string, bytes map[string]int64) {
+	targets := make([]string, 0, len(bytes))
+	for to := range bytes {
+		targets = append(targets, to)
+	}
+	sort.Strings(targets)
+	for _, to := range targets {
+		perSecond := quotient(float64(bytes[to]), r.At.Seconds())
+		r.Links = append(r.Links, LinkReport{From: from, To: to, Bytes: bytes[to], PerSecond: perSecond})
+	}
+}
+
+// Bytes returns the data moved over every link
+func (r Report) Bytes() int64 {
+	var total int64
+	for _, l := range r.Links {
+		total += l.Bytes
+	}
+	return total
+}
+
+// writeLinks lays the links out as a table, followed by the data moved
+func (r Report) writeLinks(b *strings.Builder) {
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tBYTES\tBYTES/S")
+	for _, l := range r.Links {
+		fmt.Fprintf(w, "%s -> %s\t%d\t%.0f\n", l.From, l.To, l.Bytes, l.PerSecond)
+	}
+	w.Flush()
+	fmt.Fprintf(b, "Total data moved: %d bytes\n", r.Bytes())
+}
@@ -0,0 +1,74 @@
+// Generated from ActorSimulation DSL
+// Virtual-time sleeps for callbacks
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"github.com/Arceliar/phony"
+	"time"
+)
+
+// Context is what an actor hands its callbacks: the system clock, a way
+// to make the message being handled take time, a snapshot of their state
+// that survives a crash and sampled logging
+// Only the actor's own callbacks may use it
+type Context struct {
+	clock     Clock
+	sleep     time.Duration
+	persisted []byte
+	logs      *logSampler
+	logged    int64
+}
+
+// Restorer is implemented by callbacks that pick up the state they
+// persisted when their actor restarts after a crash
+type Restorer interface {
+	Restore(state []byte)
+}
+
+// Now returns the time on the system clock, virtual under a VirtualClock
+func (c *Context) Now() time.Duration {
+	return c.clock.Now()
+}
+
+// SleepVirtual makes the message being handled take d longer, e.g. to
+// model a database query, without blocking a thread: the actor handles
+// other messages meanwhile and sends this one on once d has elapsed on
+// the clock
+// Sleeps in one callback add up
+func (c *Context) SleepVirtual(d time.Duration) {
+	c.sleep += d
+}
+
+// Persist replaces the snapshot of the callbacks' state, which a crash
+// keeps for their successors to restore
+func (c *Context) Persist(state []byte) {
+	c.persisted = append([]byte(nil), state...)
+}
+
+// resume finishes handling the message an actor is on, at once or, if
+// its callback slept, once the sleep has elapsed
+// Until then the message counts as in flight, and a crash loses it
+func (s *System) resume(to phony.Actor, ctx *Context, finish func()) {
+	d := ctx.sleep
+	ctx.sleep = 0
+	if d <= 0 {
+		finish()
+		return
+	}
+	c := to.(contextual).context()
+	c.slept = d
+	h := c.header
+	epoch := c.epoch.Load()
+	s.ledger.inflight.Add(1)
+	s.after(to, d, func() {
+		s.ledger.inflight.Add(-1)
+		if c.crashed(epoch) {
+			s.lose(to, h)
+			return
+		}
+		c.header = h
+		finish()
+	})
+}
@@ -10,17 +10,27 @@ import (
 )
 
 // SourceCallbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type SourceCallbacks interface {
-	OnData()
+	OnData() error
 }
 
+// SourceTarget is implemented by every actor Source sends to
+type SourceTarget interface {
+	phony.Actor
+	Data()
+}
 
 type Source struct {
 	phony.Inbox
-	targets []*Source
-	callbacks SourceCallbacks
-	sendCount int
+	sys *System
+	messageContext
+	targets    []SourceTarget
+	bytes      links
+	callbacks  SourceCallbacks
+	ctx        Context
+	copiesSent int
 }
 
 func (a *Source) Actor() *phony.Inbox {
@@ -28,22 +38,129 @@ func (a *Source) Actor() *phony.Inbox {
 }
 
 func (a *Source) Start() {
-	a.callbacks = &DefaultSourceCallbacks{}
-	go func() {
-		ticker := time.NewTicker(20 * time.Millisecond)
-		defer ticker.Stop()
-		for range ticker.C {
-			a.Act(nil, func() { a.Data() })
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultSourceCallbacks{Ctx: &a.ctx}
+	a.sys.every(a, 20*time.Millisecond, func() { a.sys.produce(a, "source", a.Data) })
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Source) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Source) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Source) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Source) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Source) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// restart replaces the callbacks a crash lost with fresh ones, restored
+// from the last state they persisted, if any
+func (a *Source) restart() {
+	a.ctx.sleep = 0
+	a.callbacks = &DefaultSourceCallbacks{Ctx: &a.ctx}
+	if r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+		r.Restore(a.ctx.persisted)
+	}
+}
+
+// BytesSent returns how many bytes this actor has sent each target, by
+// name, counting messages lost on the way
+// Safe to call from outside the actor
+func (a *Source) BytesSent() map[string]int64 {
+	bytes := links{}
+	phony.Block(a, func() {
+		for to, n := range a.bytes {
+			bytes[to] += n
 		}
-	}()
+	})
+	return a.sys.named(bytes)
+}
+
+// accepts reports whether the actor to handles every message Source sends
+func (a *Source) accepts(to phony.Actor) bool {
+	_, ok := to.(SourceTarget)
+	return ok
+}
+
+// connect adds an edge to to
+func (a *Source) connect(to phony.Actor) {
+	a.targets = append(a.targets, to.(SourceTarget))
+}
+
+// disconnect removes every edge to to
+func (a *Source) disconnect(to phony.Actor) {
+	for i := len(a.targets) - 1; i >= 0; i-- {
+		if phony.Actor(a.targets[i]) == to {
+			a.targets = append(a.targets[:i], a.targets[i+1:]...)
+		}
+	}
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *Source) handler(kind string) (func(), bool) {
+	switch kind {
+	case "data":
+		return a.Data, true
+	}
+	return nil, false
 }
 
 func (a *Source) Data() {
-	a.callbacks.OnData()
+	a.sys.middleware.Handle(HandlerContext{Actor: "source", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "data", a.handleData)
+}
+
+func (a *Source) handleData() {
+	if err := a.callbacks.OnData(); err != nil {
+		a.sys.fail("source", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishData)
+}
+
+func (a *Source) finishData() {
 	// Send to targets
+	a.sys.forwarded(len(a.targets))
 	for _, target := range a.targets {
-		target.Act(a, func() { target.Data() })
+		a.bytes.add(target, 1500)
+		target := target
+		a.sys.send(a, target, func() { target.Data() })
+		a.copiesSent++
 	}
-	a.sendCount++
 }
-
@@ -4,17 +4,16 @@
 
 package main
 
-import (
-	"fmt"
-)
-
-
 // DefaultSourceCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultSourceCallbacks struct{}
+type DefaultSourceCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
-func (c *DefaultSourceCallbacks) OnData() {
+func (c *DefaultSourceCallbacks) OnData() error {
 	// TODO: Implement custom behavior for data
-	fmt.Printf("Source: Sending data message\n")
+	c.Ctx.Logf("Source: Sending data message\n")
+	return nil
 }
-
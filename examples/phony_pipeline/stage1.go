@@ -9,17 +9,27 @@ import (
 )
 
 // Stage1Callbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type Stage1Callbacks interface {
-
+	OnData() error
 }
 
+// Stage1Target is implemented by every actor Stage1 sends to
+type Stage1Target interface {
+	phony.Actor
+	Data()
+}
 
 type Stage1 struct {
 	phony.Inbox
-	targets []*Stage1
-	callbacks Stage1Callbacks
-	sendCount int
+	sys *System
+	messageContext
+	targets    []Stage1Target
+	bytes      links
+	callbacks  Stage1Callbacks
+	ctx        Context
+	copiesSent int
 }
 
 func (a *Stage1) Actor() *phony.Inbox {
@@ -27,7 +37,128 @@ func (a *Stage1) Actor() *phony.Inbox {
 }
 
 func (a *Stage1) Start() {
-	a.callbacks = &DefaultStage1Callbacks{}
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultStage1Callbacks{Ctx: &a.ctx}
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Stage1) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Stage1) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Stage1) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Stage1) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Stage1) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// restart replaces the callbacks a crash lost with fresh ones, restored
+// from the last state they persisted, if any
+func (a *Stage1) restart() {
+	a.ctx.sleep = 0
+	a.callbacks = &DefaultStage1Callbacks{Ctx: &a.ctx}
+	if r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+		r.Restore(a.ctx.persisted)
+	}
 }
 
+// BytesSent returns how many bytes this actor has sent each target, by
+// name, counting messages lost on the way
+// Safe to call from outside the actor
+func (a *Stage1) BytesSent() map[string]int64 {
+	bytes := links{}
+	phony.Block(a, func() {
+		for to, n := range a.bytes {
+			bytes[to] += n
+		}
+	})
+	return a.sys.named(bytes)
+}
+
+// accepts reports whether the actor to handles every message Stage1 sends
+func (a *Stage1) accepts(to phony.Actor) bool {
+	_, ok := to.(Stage1Target)
+	return ok
+}
+
+// connect adds an edge to to
+func (a *Stage1) connect(to phony.Actor) {
+	a.targets = append(a.targets, to.(Stage1Target))
+}
+
+// disconnect removes every edge to to
+func (a *Stage1) disconnect(to phony.Actor) {
+	for i := len(a.targets) - 1; i >= 0; i-- {
+		if phony.Actor(a.targets[i]) == to {
+			a.targets = append(a.targets[:i], a.targets[i+1:]...)
+		}
+	}
+}
 
+// handler returns the method that handles messages of kind, for Act
+func (a *Stage1) handler(kind string) (func(), bool) {
+	switch kind {
+	case "data":
+		return a.Data, true
+	}
+	return nil, false
+}
+
+func (a *Stage1) Data() {
+	a.sys.middleware.Handle(HandlerContext{Actor: "stage1", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "data", a.handleData)
+}
+
+func (a *Stage1) handleData() {
+	if err := a.callbacks.OnData(); err != nil {
+		a.sys.fail("stage1", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishData)
+}
+
+func (a *Stage1) finishData() {
+	// Send to targets
+	a.sys.forwarded(len(a.targets))
+	for _, target := range a.targets {
+		a.bytes.add(target, 1500)
+		target := target
+		a.sys.send(a, target, func() { target.Data() })
+		a.copiesSent++
+	}
+}
@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultStage2Callbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultStage2Callbacks struct{}
-
+type DefaultStage2Callbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultStage2Callbacks) OnData() error {
+	// TODO: Implement custom behavior for data
+	c.Ctx.Logf("Stage2: Received data message\n")
+	return nil
+}
@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultStage3Callbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultStage3Callbacks struct{}
-
+type DefaultStage3Callbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultStage3Callbacks) OnData() error {
+	// TODO: Implement custom behavior for data
+	c.Ctx.Logf("Stage3: Received data message\n")
+	return nil
+}
@@ -0,0 +1,175 @@
+// Generated from ActorSimulation DSL
+// Diffs of the event logs of two runs in virtual time
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Event is a message an actor handled, as an event log records it
+type Event struct {
+	At      time.Duration
+	Actor   string
+	Message string
+	ID      MessageID
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%v %s %s %s", e.At, e.Actor, e.Message, e.ID)
+}
+
+// EventLog records every message the actors of a system handle, in the
+// order they handle it
+type EventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Record registers the log as middleware of s; call it before Start
+func (l *EventLog) Record(s *System) {
+	s.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		l.mu.Lock()
+		l.events = append(l.events, Event{At: ctx.Now, Actor: ctx.Actor, Message: msg, ID: ctx.ID})
+		l.mu.Unlock()
+		next()
+	}))
+}
+
+// Events returns the events recorded so far
+func (l *EventLog) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
+
+// EventCount is how many times an actor handled a message in each of
+// two runs
+type EventCount struct {
+	Actor   string
+	Message string
+	Left    int
+	Right   int
+}
+
+// Diff compares the event logs of two runs to the same virtual time
+// Index is the position of the first event the logs disagree on, or -1
+// when they are alike, and Left and Right are the events each log has
+// there, nil where it has ended; Counts holds every actor and message
+// either run handled, sorted by actor, then message
+type Diff struct {
+	At     time.Duration
+	Index  int
+	Left   *Event
+	Right  *Event
+	Counts []EventCount
+}
+
+// Diverged reports whether the logs differ
+func (d Diff) Diverged() bool {
+	return d.Index >= 0
+}
+
+// DiffLogs compares the event logs of two runs to at
+func DiffLogs(at time.Duration, left, right []Event) Diff {
+	d := Diff{At: at, Index: -1}
+	for i := 0; i < len(left) || i < len(right); i++ {
+		l, r := eventAt(left, i), eventAt(right, i)
+		if l == nil || r == nil || *l != *r {
+			d.Index, d.Left, d.Right = i, l, r
+			break
+		}
+	}
+
+	counts := map[[2]string]*EventCount{}
+	count := func(e Event) *EventCount {
+		key := [2]string{e.Actor, e.Message}
+		if counts[key] == nil {
+			counts[key] = &EventCount{Actor: e.Actor, Message: e.Message}
+		}
+		return counts[key]
+	}
+	for _, e := range left {
+		count(e).Left++
+	}
+	for _, e := range right {
+		count(e).Right++
+	}
+	for _, c := range counts {
+		d.Counts = append(d.Counts, *c)
+	}
+	sort.Slice(d.Counts, func(i, j int) bool {
+		a, b := d.Counts[i], d.Counts[j]
+		if a.Actor != b.Actor {
+			return a.Actor < b.Actor
+		}
+		return a.Message < b.Message
+	})
+	return d
+}
+
+// eventAt returns the event at i, or nil past the end of the log
+func eventAt(events []Event, i int) *Event {
+	if i >= len(events) {
+		return nil
+	}
+	return &events[i]
+}
+
+// DiffRuns runs two systems from seed to until, each on a VirtualClock of
+// its own, and diffs their event logs
+// left and right configure their system once it has started, before
+// virtual time moves, such as with Reconfigure; either may be nil
+func DiffRuns(seed int64, until time.Duration, left, right func(s *System)) Diff {
+	return DiffLogs(until, runLog(seed, until, left), runLog(seed, until, right))
+}
+
+// runLog runs a system configured by setup from seed to until and returns
+// its event log
+func runLog(seed int64, until time.Duration, setup func(s *System)) []Event {
+	s := NewSystem(seed, NewVirtualClock())
+	var log EventLog
+	log.Record(s)
+	s.Start()
+	if setup != nil {
+		setup(s)
+	}
+	s.RunUntil(until)
+	return log.Events()
+}
+
+// String lays out where the runs first diverge and the counts that differ
+func (d Diff) String() string {
+	var b strings.Builder
+	if !d.Diverged() {
+		fmt.Fprintf(&b, "Runs alike up to %v\n", d.At)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Runs diverge at event %d, up to %v\n", d.Index, d.At)
+	for _, side := range []struct {
+		name  string
+		event *Event
+	}{{"left", d.Left}, {"right", d.Right}} {
+		if side.event == nil {
+			fmt.Fprintf(&b, "  %s: no more events\n", side.name)
+		} else {
+			fmt.Fprintf(&b, "  %s: %s\n", side.name, side.event)
+		}
+	}
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTOR\tMESSAGE\tLEFT\tRIGHT")
+	for _, c := range d.Counts {
+		if c.Left != c.Right {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", c.Actor, c.Message, c.Left, c.Right)
+		}
+	}
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,29 @@
+// Generated from ActorSimulation DSL
+// Actor metrics published through expvar
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"expvar"
+)
+
+// metrics appears at /debug/vars under gen_server_virtual_time
+var metrics = expvar.NewMap("gen_server_virtual_time")
+
+// PublishMetrics exposes every actor's counters through expvar
+// Counters are read on demand, so they stay current while the system runs
+func (s *System) PublishMetrics() {
+	metrics.Set("publisher", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.publisher.SendCount()}
+	}))
+	metrics.Set("subscriber1", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.subscriber1.SendCount()}
+	}))
+	metrics.Set("subscriber2", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.subscriber2.SendCount()}
+	}))
+	metrics.Set("subscriber3", expvar.Func(func() any {
+		return map[string]int{"sendCount": s.subscriber3.SendCount()}
+	}))
+}
@@ -0,0 +1,29 @@
+// Generated from ActorSimulation DSL
+// Reproducible message IDs
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+)
+
+// MessageID identifies a message across the system: the actor that
+// originated it and a number from the system's ID sequence
+// Sends carry it on, so every handler of the message sees the same ID
+type MessageID struct {
+	Source string
+	Seq    uint64
+}
+
+func (id MessageID) String() string {
+	return fmt.Sprintf("%s/%d", id.Source, id.Seq)
+}
+
+// NextID returns the next number in the system's ID sequence, starting
+// at 1; no randomness or wall-clock time goes into it, so two runs with
+// the same seed on a VirtualClock allocate the same IDs
+// Safe to call from any actor
+func (s *System) NextID() uint64 {
+	return s.idGen.Add(1)
+}
@@ -10,17 +10,26 @@ import (
 )
 
 // PublisherCallbacks defines the callback interface
-// Implement this interface to customize actor behavior
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
 type PublisherCallbacks interface {
-	OnEvent()
+	OnEvent() error
 }
 
+// PublisherTarget is implemented by every actor Publisher sends to
+type PublisherTarget interface {
+	phony.Actor
+	Event()
+}
 
 type Publisher struct {
 	phony.Inbox
-	targets []*Publisher
-	callbacks PublisherCallbacks
-	sendCount int
+	sys *System
+	messageContext
+	targets    []PublisherTarget
+	callbacks  PublisherCallbacks
+	ctx        Context
+	copiesSent int
 }
 
 func (a *Publisher) Actor() *phony.Inbox {
@@ -28,22 +37,115 @@ func (a *Publisher) Actor() *phony.Inbox {
 }
 
 func (a *Publisher) Start() {
-	a.callbacks = &DefaultPublisherCallbacks{}
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-		for range ticker.C {
-			a.Act(nil, func() { a.Event() })
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultPublisherCallbacks{Ctx: &a.ctx}
+	a.sys.every(a, 100*time.Millisecond, func() { a.sys.produce(a, "publisher", a.Event) })
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Publisher) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Publisher) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Publisher) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Publisher) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Publisher) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// restart replaces the callbacks a crash lost with fresh ones, restored
+// from the last state they persisted, if any
+func (a *Publisher) restart() {
+	a.ctx.sleep = 0
+	a.callbacks = &DefaultPublisherCallbacks{Ctx: &a.ctx}
+	if r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+		r.Restore(a.ctx.persisted)
+	}
+}
+
+// accepts reports whether the actor to handles every message Publisher sends
+func (a *Publisher) accepts(to phony.Actor) bool {
+	_, ok := to.(PublisherTarget)
+	return ok
+}
+
+// connect adds an edge to to
+func (a *Publisher) connect(to phony.Actor) {
+	a.targets = append(a.targets, to.(PublisherTarget))
+}
+
+// disconnect removes every edge to to
+func (a *Publisher) disconnect(to phony.Actor) {
+	for i := len(a.targets) - 1; i >= 0; i-- {
+		if phony.Actor(a.targets[i]) == to {
+			a.targets = append(a.targets[:i], a.targets[i+1:]...)
 		}
-	}()
+	}
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *Publisher) handler(kind string) (func(), bool) {
+	switch kind {
+	case "event":
+		return a.Event, true
+	}
+	return nil, false
 }
 
 func (a *Publisher) Event() {
-	a.callbacks.OnEvent()
+	a.sys.middleware.Handle(HandlerContext{Actor: "publisher", From: a.header.from, Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "event", a.handleEvent)
+}
+
+func (a *Publisher) handleEvent() {
+	if err := a.callbacks.OnEvent(); err != nil {
+		a.sys.fail("publisher", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishEvent)
+}
+
+func (a *Publisher) finishEvent() {
 	// Send to targets
+	a.sys.forwarded(len(a.targets))
 	for _, target := range a.targets {
-		target.Act(a, func() { target.Event() })
+		target := target
+		a.sys.send(a, target, func() { target.Event() })
+		a.copiesSent++
 	}
-	a.sendCount++
 }
-
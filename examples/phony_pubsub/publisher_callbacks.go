@@ -4,17 +4,16 @@
 
 package main
 
-import (
-	"fmt"
-)
-
-
 // DefaultPublisherCallbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultPublisherCallbacks struct{}
+type DefaultPublisherCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
-func (c *DefaultPublisherCallbacks) OnEvent() {
+func (c *DefaultPublisherCallbacks) OnEvent() error {
 	// TODO: Implement custom behavior for event
-	fmt.Printf("Publisher: Sending event message\n")
+	c.Ctx.Logf("Publisher: Sending event message\n")
+	return nil
 }
-
@@ -0,0 +1,224 @@
+// Generated from ActorSimulation DSL
+// Runtime reconfiguration of the actor system
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"time"
+)
+
+// Spec describes changes to a running system
+type Spec struct {
+	// Spawn starts new actors, keyed by name, each of the same kind as the
+	// existing actor it names; spawned actors start without targets
+	Spawn map[string]string
+	// Connect adds edges; the target must handle every message the
+	// sender sends
+	Connect []Edge
+	// Disconnect removes edges
+	Disconnect []Edge
+	// Remove stops actors for good and drops every edge to and from them;
+	// messages on their way to them are dropped
+	Remove []string
+	// Intervals changes how often running periodic actors send, from
+	// their next tick on
+	Intervals map[string]time.Duration
+}
+
+// Edge connects a sending actor to a target by name
+type Edge struct {
+	From string
+	To   string
+}
+
+// clone returns a copy of spec that shares nothing with it
+func (spec *Spec) clone() *Spec {
+	c := &Spec{
+		Connect:    append([]Edge(nil), spec.Connect...),
+		Disconnect: append([]Edge(nil), spec.Disconnect...),
+		Remove:     append([]string(nil), spec.Remove...),
+	}
+	if spec.Spawn != nil {
+		c.Spawn = make(map[string]string, len(spec.Spawn))
+		for name, like := range spec.Spawn {
+			c.Spawn[name] = like
+		}
+	}
+	if spec.Intervals != nil {
+		c.Intervals = make(map[string]time.Duration, len(spec.Intervals))
+		for name, interval := range spec.Intervals {
+			c.Intervals[name] = interval
+		}
+	}
+	return c
+}
+
+// actor is any generated actor
+type actor interface {
+	phony.Actor
+	Start()
+	Labels() map[string]string
+	Metrics() ActorMetrics
+	handler(kind string) (func(), bool)
+	report(r *Report, name string)
+}
+
+// connector is implemented by actors with outgoing edges
+type connector interface {
+	actor
+	accepts(to phony.Actor) bool
+	connect(to phony.Actor)
+	disconnect(to phony.Actor)
+}
+
+// Reconfigure applies spec to the running system without restarting it
+// Edges change on the sending actor's inbox, so a handler sees either the
+// old or the new targets, never a mix; call it from outside the actors
+// Nothing changes if any part of spec is invalid
+func (s *System) Reconfigure(spec *Spec) error {
+	// Fork replays a copy, so changing spec afterwards changes no fork
+	spec = spec.clone()
+	s.mu.Lock()
+	actors := make(map[string]actor, len(s.actors)+len(spec.Spawn))
+	for name, a := range s.actors {
+		actors[name] = a
+	}
+	s.mu.Unlock()
+
+	spawned := map[string]actor{}
+	for name, like := range spec.Spawn {
+		if _, ok := actors[name]; ok {
+			return fmt.Errorf("cannot spawn %q: actor exists", name)
+		}
+		kind, ok := actors[like]
+		if !ok {
+			return fmt.Errorf("cannot spawn %q like unknown actor %q", name, like)
+		}
+		spawned[name] = s.spawn(kind)
+	}
+	removed := map[string]actor{}
+	for _, name := range spec.Remove {
+		a, ok := actors[name]
+		if !ok {
+			return fmt.Errorf("cannot remove unknown actor %q", name)
+		}
+		if _, err := s.crashable(name); err != nil {
+			return fmt.Errorf("cannot remove %q: %w", name, err)
+		}
+		removed[name] = a
+		delete(actors, name)
+	}
+	for name, interval := range spec.Intervals {
+		a, ok := actors[name]
+		s.mu.Lock()
+		t := s.tickers[a]
+		s.mu.Unlock()
+		if !ok || t == nil {
+			return fmt.Errorf("cannot change interval of %q: no running periodic actor", name)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("cannot change interval of %q to %v", name, interval)
+		}
+	}
+	for name, a := range spawned {
+		actors[name] = a
+	}
+	for _, e := range spec.Connect {
+		from, to, err := resolve(actors, e)
+		if err != nil {
+			return err
+		}
+		if !from.accepts(to) {
+			return fmt.Errorf("cannot connect %q to %q: it does not handle every message %[1]q sends", e.From, e.To)
+		}
+	}
+	for _, e := range spec.Disconnect {
+		if _, _, err := resolve(actors, e); err != nil {
+			return err
+		}
+	}
+
+	// A removed actor goes down like a crash that never restarts, losing
+	// what is on its way to it
+	for _, a := range removed {
+		c := a.(contextual).context()
+		phony.Block(a, func() {
+			c.down = true
+			c.epoch.Add(1)
+		})
+	}
+	s.mu.Lock()
+	for name, a := range spawned {
+		s.actors[name] = a
+		s.instrument(name, a)
+	}
+	for name, a := range removed {
+		delete(s.actors, name)
+		delete(s.tickers, a)
+	}
+	s.mu.Unlock()
+	for _, gone := range removed {
+		for _, a := range actors {
+			if from, ok := a.(connector); ok {
+				phony.Block(from, func() { from.disconnect(gone) })
+			}
+			if from, ok := gone.(connector); ok {
+				phony.Block(from, func() { from.disconnect(a) })
+			}
+		}
+	}
+	for _, a := range spawned {
+		a.Start()
+	}
+	for _, e := range spec.Disconnect {
+		from, to, _ := resolve(actors, e)
+		phony.Block(from, func() { from.disconnect(to) })
+	}
+	for _, e := range spec.Connect {
+		from, to, _ := resolve(actors, e)
+		phony.Block(from, func() { from.connect(to) })
+	}
+	for name, interval := range spec.Intervals {
+		s.mu.Lock()
+		s.tickers[actors[name]].interval.Store(int64(interval))
+		s.mu.Unlock()
+	}
+	s.record(func(s *System) { s.Reconfigure(spec) })
+	return nil
+}
+
+// resolve looks up the actors of an edge by name
+func resolve(actors map[string]actor, e Edge) (connector, actor, error) {
+	from, ok := actors[e.From]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown actor %q", e.From)
+	}
+	to, ok := actors[e.To]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown actor %q", e.To)
+	}
+	c, ok := from.(connector)
+	if !ok {
+		return nil, nil, fmt.Errorf("actor %q has no outgoing edges", e.From)
+	}
+	return c, to, nil
+}
+
+// spawn creates an unstarted actor of the same kind as like through the
+// constructor NewSystem uses, so it has the same settings but no targets
+func (s *System) spawn(like actor) actor {
+	switch like.(type) {
+	case *Publisher:
+		return newPublisher(s)
+	case *Subscriber1:
+		return newSubscriber1(s)
+	case *Subscriber2:
+		return newSubscriber2(s)
+	case *Subscriber3:
+		return newSubscriber3(s)
+	}
+	return nil
+}
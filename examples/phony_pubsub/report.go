@@ -0,0 +1,370 @@
+// Generated from ActorSimulation DSL
+// Summary report of a run
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ActorReport sums up an actor: the messages it sent, received, dropped
+// and let expire unmatched in a join, the latency from production to
+// arrival of the messages it received, the most messages it has had
+// waiting at once, in its inbox or fair queue, how long the messages it
+// handled waited and were worked on, and the metrics its metrics: option
+// derives from these
+type ActorReport struct {
+	Name      string
+	Sent      int
+	Received  int
+	Dropped   int
+	Expired   int
+	P50       time.Duration
+	P99       time.Duration
+	PeakQueue int
+	Queue     TimeStats
+	Service   TimeStats
+	Metrics   map[string]float64
+}
+
+// TimeStats sums up how long an actor's messages took at one stage: how
+// many it timed and the median and 99th percentile
+type TimeStats struct {
+	Count int
+	P50   time.Duration
+	P99   time.Duration
+}
+
+// ActorMetrics is what an actor has sent and received so far
+type ActorMetrics struct {
+	SendCount int
+	RecvCount int
+}
+
+// Report sums up every actor at a point in time
+type Report struct {
+	At     time.Duration
+	Actors []ActorReport
+}
+
+// Report reads the counters of every actor the system has now, one row
+// per actor: those NewSystem spawned in declaration order, then those
+// Reconfigure spawned by name
+// Safe to call while the system runs
+func (s *System) Report() Report {
+	r := Report{At: s.clock.Now()}
+	s.mu.Lock()
+	names := make([]string, 0, len(s.actors))
+	actors := make(map[string]actor, len(s.actors))
+	for name, a := range s.actors {
+		names = append(names, name)
+		actors[name] = a
+	}
+	s.mu.Unlock()
+	sort.Slice(names, func(i, j int) bool { return s.declaredBefore(names[i], names[j]) })
+	// Read after unlocking, as a handler waiting in an inbox may lock it
+	for _, name := range names {
+		actors[name].report(&r, name)
+	}
+	return r
+}
+
+// declaredBefore orders actors the way Report lists them
+func (s *System) declaredBefore(x, y string) bool {
+	if rx, ry := s.ranks[x], s.ranks[y]; rx != ry {
+		return ry == 0 || rx != 0 && rx < ry
+	}
+	return x < y
+}
+
+// report adds the actor's row to r under name, reading its counters
+// through accessors safe to call from outside the actor
+func (a *Publisher) report(r *Report, name string) {
+	r.add(name, a, a.SendCount(), 0, 0, a.QueueTimeStats(), a.ServiceTimeStats())
+}
+
+// report adds the actor's row to r under name, reading its counters
+// through accessors safe to call from outside the actor
+func (a *Subscriber1) report(r *Report, name string) {
+	r.add(name, a, a.SendCount(), 0, 0, a.QueueTimeStats(), a.ServiceTimeStats())
+}
+
+// report adds the actor's row to r under name, reading its counters
+// through accessors safe to call from outside the actor
+func (a *Subscriber2) report(r *Report, name string) {
+	r.add(name, a, a.SendCount(), 0, 0, a.QueueTimeStats(), a.ServiceTimeStats())
+}
+
+// report adds the actor's row to r under name, reading its counters
+// through accessors safe to call from outside the actor
+func (a *Subscriber3) report(r *Report, name string) {
+	r.add(name, a, a.SendCount(), 0, 0, a.QueueTimeStats(), a.ServiceTimeStats())
+}
+
+// SystemMetrics sums up every actor's counters, read the same way
+// Report reads them
+// Safe to call while the system runs
+func (s *System) SystemMetrics() SystemMetrics {
+	return s.Report().SystemMetrics()
+}
+
+// RunUntil advances a system running on a VirtualClock to t, running
+// everything that falls due on the way, and reports on the run
+// A run the clock's step limit cuts short stops where it got to and
+// returns the report so far, with an error wrapping ErrStepLimitExceeded;
+// so does a run a callback halts, with the error the callback returned
+func (s *System) RunUntil(t time.Duration) (Report, error) {
+	clock := s.clock.(*VirtualClock)
+	if d := t - clock.Now(); d > 0 {
+		clock.Advance(d)
+	} else {
+		clock.Advance(0)
+	}
+	r := s.Report()
+	if err := s.Err(); err != nil {
+		return r, err
+	}
+	if clock.Exceeded() {
+		return r, fmt.Errorf("%w: %d timers run by %v, %d still pending", ErrStepLimitExceeded, clock.Steps(), r.At, s.Pending())
+	}
+	return r, nil
+}
+
+// String lays the report out as a table
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Report at %v\n", r.At)
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	derived := false
+	for _, a := range r.Actors {
+		derived = derived || len(a.Metrics) > 0
+	}
+	fmt.Fprint(w, "ACTOR\tSENT\tRECEIVED\tDROPPED\tEXPIRED\tP50\tP99\tPEAK QUEUE\tQUEUE P50\tSERVICE P50")
+	if derived {
+		fmt.Fprint(w, "\tMETRICS")
+	}
+	fmt.Fprintln(w)
+	for _, a := range r.Actors {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%v\t%v\t%d\t%v\t%v", a.Name, a.Sent, a.Received, a.Dropped, a.Expired, a.P50, a.P99, a.PeakQueue, a.Queue.P50, a.Service.P50)
+		if derived {
+			fmt.Fprintf(w, "\t%s", a.metrics())
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// Metrics returns the metrics derived for the named actor, or nil if it
+// declares none
+func (r Report) Metrics(name string) map[string]float64 {
+	for _, a := range r.Actors {
+		if a.Name == name {
+			return a.Metrics
+		}
+	}
+	return nil
+}
+
+// SystemMetrics sums up a report across its actors: the messages they
+// sent, received, dropped and let expire in all, the most any of them
+// had waiting at once, and the virtual time of the report
+type SystemMetrics struct {
+	At        time.Duration
+	Sent      int
+	Received  int
+	Dropped   int
+	Expired   int
+	PeakQueue int
+}
+
+// SystemMetrics adds up the report's rows
+func (r Report) SystemMetrics() SystemMetrics {
+	m := SystemMetrics{At: r.At}
+	for _, a := range r.Actors {
+		m.Sent += a.Sent
+		m.Received += a.Received
+		m.Dropped += a.Dropped
+		m.Expired += a.Expired
+		if a.PeakQueue > m.PeakQueue {
+			m.PeakQueue = a.PeakQueue
+		}
+	}
+	return m
+}
+
+// derive sets a metric of the named actor's row, computed from the row
+// once its counters are in
+func (r *Report) derive(name, metric string, f func(a ActorReport) float64) {
+	for i := range r.Actors {
+		a := &r.Actors[i]
+		if a.Name != name {
+			continue
+		}
+		if a.Metrics == nil {
+			a.Metrics = map[string]float64{}
+		}
+		a.Metrics[metric] = f(*a)
+	}
+}
+
+// metrics lists the derived metrics as name=value, sorted by name
+func (a ActorReport) metrics() string {
+	names := make([]string, 0, len(a.Metrics))
+	for name := range a.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s=%.3g", name, a.Metrics[name])
+	}
+	return strings.Join(names, " ")
+}
+
+// quotient divides x by y, or returns zero when y is zero, such as the
+// capacity of an actor before any time has passed
+func quotient(x, y float64) float64 {
+	if y == 0 {
+		return 0
+	}
+	return x / y
+}
+
+// add appends an actor's row, reading its context on its inbox; an
+// actor spread over several inboxes peaks at its deepest
+func (r *Report) add(name string, a phony.Actor, sent, dropped, expired int, queue, service TimeStats, inboxes ...*messageContext) {
+	row := ActorReport{Name: name, Sent: sent, Dropped: dropped, Expired: expired, Queue: queue, Service: service}
+	c := a.(contextual).context()
+	phony.Block(a, func() {
+		row.Received = c.delivered
+		row.P50 = c.latency.quantile(0.5)
+		row.P99 = c.latency.quantile(0.99)
+	})
+	peak := c.peak.Load()
+	for _, inbox := range inboxes {
+		if n := inbox.peak.Load(); n > peak {
+			peak = n
+		}
+	}
+	row.PeakQueue = int(peak)
+	r.Actors = append(r.Actors, row)
+}
+
+// arrived notes a message delivered to the actor at now
+func (c *messageContext) arrived(now time.Duration) {
+	c.delivered++
+	c.latency.add(now - c.header.born)
+	c.meter.arrived(now - c.header.born)
+}
+
+// handled notes a message that reached the actor's inbox at enqueued,
+// which the actor started on at start and finished at end, plus however
+// long its callback slept
+func (c *messageContext) handled(enqueued, start, end time.Duration) {
+	c.queueTime.add(start - enqueued)
+	c.serviceTime.add(end - start + c.slept)
+	c.meter.handled(start-enqueued, end-start+c.slept)
+	c.slept = 0
+}
+
+// expect counts n more messages on their way to the actor
+// Safe to call from any actor
+func (c *messageContext) expect(n int64) {
+	c.sawQueue(c.inbound.Add(n))
+}
+
+// sawQueue notes n messages waiting for the actor at once
+// Safe to call from any actor
+func (c *messageContext) sawQueue(n int64) {
+	c.meter.queued(n)
+	for {
+		peak := c.peak.Load()
+		if n <= peak || c.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// latencies counts latencies in buckets that widen by an eighth of a
+// power of two, keeping the largest latency in each, so quantiles are
+// exact for constant latencies and within 9% otherwise
+type latencies struct {
+	buckets map[int]*latencyBucket
+	n       int
+}
+
+type latencyBucket struct {
+	n   int
+	max time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	i := -1
+	if d > 0 {
+		i = int(math.Ceil(8 * math.Log2(float64(d))))
+	}
+	b := l.bucket(i)
+	b.n++
+	if d > b.max {
+		b.max = d
+	}
+	l.n++
+}
+
+// merge adds the latencies counted in o, such as another shard's
+func (l *latencies) merge(o *latencies) {
+	for i, ob := range o.buckets {
+		b := l.bucket(i)
+		b.n += ob.n
+		if ob.max > b.max {
+			b.max = ob.max
+		}
+	}
+	l.n += o.n
+}
+
+// bucket returns bucket i, adding it if it is empty
+func (l *latencies) bucket(i int) *latencyBucket {
+	if l.buckets == nil {
+		l.buckets = map[int]*latencyBucket{}
+	}
+	b, ok := l.buckets[i]
+	if !ok {
+		b = &latencyBucket{}
+		l.buckets[i] = b
+	}
+	return b
+}
+
+// stats returns the count, median and 99th percentile
+func (l *latencies) stats() TimeStats {
+	return TimeStats{Count: l.n, P50: l.quantile(0.5), P99: l.quantile(0.99)}
+}
+
+// quantile returns the q-quantile, or zero before any latency is added
+func (l *latencies) quantile(q float64) time.Duration {
+	if l.n == 0 {
+		return 0
+	}
+	indexes := make([]int, 0, len(l.buckets))
+	for i := range l.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	rank := int(math.Ceil(q * float64(l.n)))
+	seen := 0
+	for _, i := range indexes {
+		seen += l.buckets[i].n
+		if seen >= rank {
+			return l.buckets[i].max
+		}
+	}
+	return l.buckets[indexes[len(indexes)-1]].max
+}
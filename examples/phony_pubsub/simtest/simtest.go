@@ -0,0 +1,106 @@
+// Generated from ActorSimulation DSL
+// Test harness for the actor system
+// DO NOT EDIT - This file is auto-generated
+
+// Package simtest drives a generated actor system in virtual time and
+// asserts on its counters, so tests read as a few lines of intent
+package simtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// System is a generated actor system
+type System interface {
+	Start()
+	Pending() int
+	Err() error
+}
+
+// Clock is the virtual clock the system was created with
+type Clock interface {
+	Advance(d time.Duration)
+	Step() bool
+}
+
+// Actor is any generated actor
+type Actor interface {
+	SendCount() int
+}
+
+// Harness runs a system under test against its virtual clock
+type Harness struct {
+	t      testing.TB
+	system System
+	clock  Clock
+}
+
+// NewHarness starts system and returns a harness driving clock
+func NewHarness(t testing.TB, system System, clock Clock) *Harness {
+	t.Helper()
+	system.Start()
+	return &Harness{t: t, system: system, clock: clock}
+}
+
+// Advance moves virtual time forward, running every timer and
+// delivering every message that falls due
+func (h *Harness) Advance(d time.Duration) {
+	h.clock.Advance(d)
+}
+
+// DrainQuiescent delivers every message still in flight at the
+// current virtual time without moving the clock
+func (h *Harness) DrainQuiescent() {
+	h.clock.Advance(0)
+}
+
+// maxQuiescentSteps bounds WaitQuiescent on systems that never settle,
+// such as periodic requests that always have a timeout armed
+const maxQuiescentSteps = 100000
+
+// WaitQuiescent runs timers one at a time, moving virtual time forward,
+// until system has nothing pending
+// It fails on a deadlock, where work is pending but no timer is left to
+// make progress, and with the error of a callback that halts the system
+func WaitQuiescent(system System, clock Clock) error {
+	for i := 0; i < maxQuiescentSteps; i++ {
+		n := system.Pending()
+		if n == 0 {
+			return nil
+		}
+		if !clock.Step() {
+			if err := system.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("deadlock: %d pending with no timers scheduled", n)
+		}
+	}
+	return fmt.Errorf("%d still pending after %d timers", system.Pending(), maxQuiescentSteps)
+}
+
+// WaitQuiescent stops the test unless the system settles
+func (h *Harness) WaitQuiescent() {
+	h.t.Helper()
+	if err := WaitQuiescent(h.system, h.clock); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// AssertQuiescent fails the test if any message is in flight, queued
+// or waiting on a one-shot timer
+func (h *Harness) AssertQuiescent() {
+	h.t.Helper()
+	if n := h.system.Pending(); n != 0 {
+		h.t.Errorf("%d messages or timers still pending", n)
+	}
+}
+
+// AssertSendCount fails the test unless actor sent exactly n messages
+func (h *Harness) AssertSendCount(actor Actor, n int) {
+	h.t.Helper()
+	if got := actor.SendCount(); got != n {
+		h.t.Errorf("%T sent %d messages, want %d", actor, got, n)
+	}
+}
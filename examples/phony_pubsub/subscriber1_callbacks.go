@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultSubscriber1Callbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultSubscriber1Callbacks struct{}
-
+type DefaultSubscriber1Callbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultSubscriber1Callbacks) OnEvent() error {
+	// TODO: Implement custom behavior for event
+	c.Ctx.Logf("Subscriber1: Received event message\n")
+	return nil
+}
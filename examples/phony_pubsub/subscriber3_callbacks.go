@@ -4,10 +4,16 @@
 
 package main
 
-
-
 // DefaultSubscriber3Callbacks provides default implementations
 // CUSTOMIZE THIS to add your own behavior!
-type DefaultSubscriber3Callbacks struct{}
-
+type DefaultSubscriber3Callbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
 
+func (c *DefaultSubscriber3Callbacks) OnEvent() error {
+	// TODO: Implement custom behavior for event
+	c.Ctx.Logf("Subscriber3: Received event message\n")
+	return nil
+}
@@ -0,0 +1,160 @@
+// Generated from ActorSimulation DSL
+// Actor: database
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"github.com/Arceliar/phony"
+	"time"
+)
+
+// DatabaseCallbacks defines the callback interface
+// Implement this interface to customize actor behavior; an error a
+// callback returns halts the system, and RunUntil returns it
+type DatabaseCallbacks interface {
+	OnRequest() error
+}
+
+type Database struct {
+	phony.Inbox
+	sys *System
+	messageContext
+	callbacks  DatabaseCallbacks
+	ctx        Context
+	copiesSent int
+	queue      *FairQueue
+	busy       bool
+	processed  [1]int
+}
+
+func (a *Database) Actor() *phony.Inbox {
+	return &a.Inbox
+}
+
+func (a *Database) Start() {
+	a.ctx.clock = a.sys.clock
+	a.ctx.logs = &a.sys.logs
+	a.callbacks = &DefaultDatabaseCallbacks{Ctx: &a.ctx}
+}
+
+// Labels returns the labels attached to this actor in the DSL
+func (a *Database) Labels() map[string]string {
+	return map[string]string{}
+}
+
+// SendCount returns the number of copies this actor put on its edges: a
+// message counts once for each target it goes to, but not for one an
+// edge loses on the way
+// Safe to call from outside the actor
+func (a *Database) SendCount() int {
+	var n int
+	phony.Block(a, func() { n = a.copiesSent })
+	return n
+}
+
+// Metrics returns what this actor has sent, counted like SendCount, and
+// received so far, as the Sent and Received of its report row
+// Safe to call from outside the actor
+func (a *Database) Metrics() ActorMetrics {
+	m := ActorMetrics{SendCount: a.SendCount()}
+	phony.Block(a, func() { m.RecvCount = a.delivered })
+	return m
+}
+
+// QueueTimeStats sums up how long the messages this actor handled waited
+// in its inbox or fair queue before it started on them
+// Safe to call from outside the actor
+func (a *Database) QueueTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.queueTime)
+	})
+	return l.stats()
+}
+
+// ServiceTimeStats sums up how long this actor took over the messages it
+// handled, from starting on one to finishing it
+// Safe to call from outside the actor
+func (a *Database) ServiceTimeStats() TimeStats {
+	var l latencies
+	phony.Block(a, func() {
+		l.merge(&a.serviceTime)
+	})
+	return l.stats()
+}
+
+// ProcessedCounts returns how many messages of each kind were processed
+// Safe to call from outside the actor
+func (a *Database) ProcessedCounts() map[string]int {
+	counts := map[string]int{}
+	phony.Block(a, func() {
+		counts["request"] = a.processed[0]
+	})
+	return counts
+}
+
+// queued returns the number of messages waiting for or in service
+// Safe to call from outside the actor
+func (a *Database) queued() int {
+	var n int
+	phony.Block(a, func() {
+		n = a.queue.Len()
+		if a.busy {
+			n++
+		}
+	})
+	return n
+}
+
+// serveNext processes the next queued message once the previous one
+// has taken its service time
+func (a *Database) serveNext() {
+	if a.busy {
+		return
+	}
+	class, f, ok := a.queue.Pop()
+	if !ok {
+		return
+	}
+	a.busy = true
+	start := a.sys.clock.Now()
+	a.sys.after(a, 2*time.Millisecond, func() {
+		f()
+		a.handled(a.header.enqueued, start, a.sys.clock.Now())
+		a.processed[class]++
+		a.busy = false
+		a.serveNext()
+	})
+}
+
+// handler returns the method that handles messages of kind, for Act
+func (a *Database) handler(kind string) (func(), bool) {
+	switch kind {
+	case "request":
+		return a.Request, true
+	}
+	return nil, false
+}
+
+func (a *Database) Request() {
+	h := a.header
+	a.queue.Push(0, func() {
+		a.header = h
+		a.sys.middleware.Handle(HandlerContext{Actor: "database", From: h.from, Now: a.sys.clock.Now(), ID: h.id, Key: h.key, Headers: h.Headers, Payload: h.payload}, "request", a.handleRequest)
+	})
+	a.sawQueue(a.inbound.Load() + int64(a.queue.Len()))
+	a.serveNext()
+}
+
+func (a *Database) handleRequest() {
+	if err := a.callbacks.OnRequest(); err != nil {
+		a.sys.fail("database", err)
+		return
+	}
+	a.sys.resume(a, &a.ctx, a.finishRequest)
+}
+
+func (a *Database) finishRequest() {
+	a.sys.forwarded(0)
+}
@@ -0,0 +1,19 @@
+// Generated from ActorSimulation DSL
+// Default callback implementation for: database
+// CUSTOMIZE THIS FILE - This is where you add your custom behavior!
+
+package main
+
+// DefaultDatabaseCallbacks provides default implementations
+// CUSTOMIZE THIS to add your own behavior!
+type DefaultDatabaseCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
+
+func (c *DefaultDatabaseCallbacks) OnRequest() error {
+	// TODO: Implement custom behavior for request
+	c.Ctx.Logf("Database: Received request message\n")
+	return nil
+}
@@ -0,0 +1,169 @@
+// Generated from ActorSimulation DSL
+// Step-by-step debugging of a run in virtual time
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Debugger steps a system on a VirtualClock one timer at a time, so every
+// actor can be inspected between events, runs on to breakpoints and steps
+// back by forking the run at an earlier event
+// Drive the system through the debugger alone: advancing its clock past
+// the debugger leaves it unable to step back over those timers
+type Debugger struct {
+	sys         *System
+	clock       *VirtualClock
+	mu          sync.Mutex
+	handled     *Event
+	trail       []mark
+	breakpoints []breakpoint
+}
+
+// mark is how far a run had got when the debugger stopped at it
+type mark struct {
+	steps uint64
+	at    time.Duration
+}
+
+// breakpoint stops Continue once when holds of an actor's report row
+type breakpoint struct {
+	actor string
+	msg   string
+	when  func(a ActorReport) bool
+}
+
+// NewDebugger attaches a debugger to a system on a VirtualClock; it sees
+// the handlers that run as middleware, so attach it before Start
+func NewDebugger(s *System) *Debugger {
+	d := &Debugger{}
+	d.attach(s)
+	d.trail = []mark{{steps: d.clock.Steps(), at: d.clock.Now()}}
+	return d
+}
+
+// attach makes s the system the debugger steps
+func (d *Debugger) attach(s *System) {
+	d.sys = s
+	d.clock = s.clock.(*VirtualClock)
+	s.middleware = append(s.middleware, MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+		d.mu.Lock()
+		if d.handled == nil {
+			d.handled = &Event{At: ctx.Now, Actor: ctx.Actor, Message: msg, ID: ctx.ID}
+		}
+		d.mu.Unlock()
+		next()
+	}))
+}
+
+// System returns the system the debugger steps, which Back replaces with
+// a fork
+func (d *Debugger) System() *System {
+	return d.sys
+}
+
+// Step runs the next timer, moving virtual time to it, and returns the
+// message it had an actor handle; a timer that handles none, such as a
+// tick that only sends, returns an Event with just its time
+// It returns false if no timer is scheduled or the step limit is reached
+func (d *Debugger) Step() (Event, bool) {
+	return d.step(math.MaxInt64)
+}
+
+// step runs the next timer due at or before until
+func (d *Debugger) step(until time.Duration) (Event, bool) {
+	d.mu.Lock()
+	d.handled = nil
+	d.mu.Unlock()
+	if !d.clock.step(until) {
+		return Event{}, false
+	}
+	d.trail = append(d.trail, mark{steps: d.clock.Steps(), at: d.clock.Now()})
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.handled == nil {
+		return Event{At: d.clock.Now()}, true
+	}
+	return *d.handled, true
+}
+
+// Break has Continue stop after the first event once when holds of the
+// named actor's report row, such as a.Received == 5; a msg other than ""
+// only stops it after events in which the actor handled msg, and a nil
+// when always holds
+func (d *Debugger) Break(actor, msg string, when func(a ActorReport) bool) {
+	d.breakpoints = append(d.breakpoints, breakpoint{actor: actor, msg: msg, when: when})
+}
+
+// Continue steps until an event hits a breakpoint, and returns that event
+// Without a hit by until it moves the clock there like Advance and returns
+// false
+func (d *Debugger) Continue(until time.Duration) (Event, bool) {
+	for {
+		e, ok := d.step(until)
+		if !ok {
+			break
+		}
+		if d.hit(e) {
+			return e, true
+		}
+	}
+	d.clock.mu.Lock()
+	if !d.clock.exceeded && d.clock.now < until {
+		d.clock.now = until
+	}
+	d.clock.mu.Unlock()
+	return Event{}, false
+}
+
+// hit reports whether e hits a breakpoint
+func (d *Debugger) hit(e Event) bool {
+	for _, b := range d.breakpoints {
+		if b.msg != "" && (e.Actor != b.actor || e.Message != b.msg) {
+			continue
+		}
+		if b.when == nil || b.when(d.Inspect(b.actor)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Inspect returns the report row of the named actor as it stands between
+// events, or a zero row for an unknown actor
+func (d *Debugger) Inspect(name string) ActorReport {
+	for _, a := range d.sys.Report().Actors {
+		if a.Name == name {
+			return a
+		}
+	}
+	return ActorReport{}
+}
+
+// Back steps n events back, to no earlier than where the debugger was
+// attached: a run in virtual time is reproducible, so it forks the run
+// just after the event it returns to and steps the fork from then on
+// The fork starts off without the original's step limit
+func (d *Debugger) Back(n int) {
+	if n > len(d.trail)-1 {
+		n = len(d.trail) - 1
+	}
+	if n <= 0 {
+		return
+	}
+	d.trail = d.trail[:len(d.trail)-n]
+	m := d.trail[len(d.trail)-1]
+	snap := d.sys.Snapshot()
+	history := snap.history[:0]
+	for _, c := range snap.history {
+		if c.steps < m.steps || c.steps == m.steps && c.at <= m.at {
+			history = append(history, c)
+		}
+	}
+	snap.At, snap.steps, snap.history = m.at, m.steps, history
+	d.attach(Fork(snap))
+}
@@ -0,0 +1,5 @@
+module storm_actors
+
+go 1.21
+
+require github.com/Arceliar/phony v0.0.0-20220903101357-530938a4b13d
@@ -0,0 +1,45 @@
+// Generated from ActorSimulation DSL
+// Sampled logging for callbacks
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// logSampler holds how many of their lines callbacks log, one in every
+// every, and how many lines it has left out
+type logSampler struct {
+	every      atomic.Int64
+	suppressed atomic.Int64
+}
+
+// Logf prints a line like fmt.Printf, or leaves it out to keep one in every
+// few, as SetLogEvery sets
+// Each actor counts its own lines and logs its first, so a busy actor
+// doesn't crowd out a quiet one
+func (c *Context) Logf(format string, args ...any) {
+	c.logged++
+	if every := c.logs.every.Load(); every > 1 && (c.logged-1)%every != 0 {
+		c.logs.suppressed.Add(1)
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// SetLogEvery makes callbacks log one in every n of their lines from now on,
+// or all of them for n of 1
+func (s *System) SetLogEvery(n int) error {
+	if n < 1 {
+		return fmt.Errorf("cannot log one in every %d lines", n)
+	}
+	s.logs.every.Store(int64(n))
+	return nil
+}
+
+// SuppressedLogs returns the number of lines Logf has left out so far
+func (s *System) SuppressedLogs() int {
+	return int(s.logs.suppressed.Load())
+}
@@ -0,0 +1,110 @@
+// Generated from ActorSimulation DSL
+// Main entry point for storm_actors
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func main() {
+	fmt.Println("Starting actor system...")
+
+	// SPEED scales real time, e.g. SPEED=10 runs ten times as fast
+	clock := NewRealClock()
+	if speed := os.Getenv("SPEED"); speed != "" {
+		factor, err := strconv.ParseFloat(speed, 64)
+		if err != nil || factor <= 0 {
+			fmt.Printf("Ignoring SPEED=%s: expected a positive factor\n", speed)
+		} else {
+			clock = NewRealClockWithSpeed(factor)
+			fmt.Printf("Running at %gx speed\n", factor)
+		}
+	}
+
+	// On Ctrl+C, REPORT=path saves the run's report encoded as CODEC: json,
+	// the default, or gob
+	codec := JSONCodec
+	if name := os.Getenv("CODEC"); name != "" {
+		if c, ok := Codecs[name]; ok {
+			codec = c
+		} else {
+			fmt.Printf("Ignoring CODEC=%s: expected json or gob\n", name)
+		}
+	}
+
+	// Spawn and wire all actors, recording their metrics for Prometheus
+	prometheus := NewPrometheusSink()
+	sys := NewSystem(42, clock, WithMetricSink(prometheus))
+
+	// PROGRESS=10s prints how far the run got every 10s of clock time
+	if every := os.Getenv("PROGRESS"); every != "" {
+		interval, err := time.ParseDuration(every)
+		if err != nil || interval <= 0 {
+			fmt.Printf("Ignoring PROGRESS=%s: expected a positive duration\n", every)
+		} else {
+			sys.OnProgress(interval, func(p ProgressInfo) { fmt.Println(p) })
+		}
+	}
+
+	// LOG_EVERY=n makes callbacks log one in every n of their lines
+	if every := os.Getenv("LOG_EVERY"); every != "" {
+		n, err := strconv.Atoi(every)
+		if err != nil || sys.SetLogEvery(n) != nil {
+			fmt.Printf("Ignoring LOG_EVERY=%s: expected a positive count\n", every)
+		}
+	}
+	sys.Start()
+
+	// METRICS=1 serves actor counters at http://localhost:8080/debug/vars
+	// and metrics at http://localhost:8080/metrics
+	if os.Getenv("METRICS") != "" {
+		sys.PublishMetrics()
+		http.Handle("/metrics", prometheus)
+		go func() {
+			if err := http.ListenAndServe("localhost:8080", nil); err != nil {
+				fmt.Printf("Not serving metrics: %v\n", err)
+			}
+		}()
+	}
+
+	fmt.Println("Actor system started. Press Ctrl+C to exit.")
+
+	// Run until interrupted or a callback fails, then stop the timers
+	// before reporting
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-interrupt:
+	case <-sys.Halted():
+		fmt.Printf("Halted: %v\n", sys.Err())
+	}
+	sys.Stop()
+	if n := sys.SuppressedLogs(); n > 0 {
+		fmt.Printf("Suppressed %d log lines\n", n)
+	}
+	if path := os.Getenv("REPORT"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		if err := sys.SaveReport(f, codec); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Saved the report to %s\n", path)
+	}
+	// REPORT_JSON=path saves it in the stable schema of ReportJSON, for CI
+	if path := os.Getenv("REPORT_JSON"); path != "" {
+		if err := os.WriteFile(path, sys.ReportJSON(), 0o644); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Saved the JSON report to %s\n", path)
+	}
+}
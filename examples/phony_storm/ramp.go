@@ -0,0 +1,128 @@
+// Generated from ActorSimulation DSL
+// Send rates that ramp over time
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"math"
+	"time"
+)
+
+// Ramp moves a send rate from From to To messages a second over Over,
+// in a straight line or, when Exponential, by the same factor in equal
+// times, then holds it at To
+// Breakpoint is how many messages waiting at one actor mark where the
+// system falls behind
+type Ramp struct {
+	From        float64
+	To          float64
+	Over        time.Duration
+	Exponential bool
+	Breakpoint  int
+}
+
+// Rate returns the send rate elapsed into the ramp
+func (r Ramp) Rate(elapsed time.Duration) float64 {
+	if elapsed >= r.Over {
+		return r.To
+	}
+	if r.Exponential {
+		return r.From * math.Pow(r.To/r.From, float64(elapsed)/float64(r.Over))
+	}
+	return r.From + (r.To-r.From)*float64(elapsed)/float64(r.Over)
+}
+
+// RampReport sums up a ramping actor: its ramp, the rate it has reached
+// and its breakpoint, once it has found one
+type RampReport struct {
+	Actor      string
+	Ramp       Ramp
+	Rate       float64
+	Breakpoint *Breakpoint
+}
+
+// Breakpoint is where a ramp first found Queue messages waiting at an
+// actor, at least Ramp.Breakpoint: when, at what rate and which actor,
+// the one with the most waiting
+type Breakpoint struct {
+	At    time.Duration
+	Rate  float64
+	Actor string
+	Queue int
+}
+
+// String describes the ramp and where it broke
+func (r RampReport) String() string {
+	ramp := fmt.Sprintf("%s ramps from %.4g/s to %.4g/s over %v, now at %.4g/s", r.Actor, r.Ramp.From, r.Ramp.To, r.Ramp.Over, r.Rate)
+	if b := r.Breakpoint; b != nil {
+		return fmt.Sprintf("%s; breakpoint at %v and %.4g/s, with %d messages waiting at %s", ramp, b.At, b.Rate, b.Queue, b.Actor)
+	}
+	return ramp + "; no breakpoint"
+}
+
+// ramping is a ramp under way: the actor it drives, when it started and
+// the breakpoint it has found, if any
+type ramping struct {
+	actor      string
+	ramp       Ramp
+	start      time.Duration
+	breakpoint *Breakpoint
+}
+
+// ramp runs f on an actor once first has elapsed, then each time the next
+// send falls due at the rate the ramp has reached, looking for the
+// breakpoint before each
+// Reconfigure can't change the interval of a ramping actor
+func (s *System) ramp(to phony.Actor, name string, first time.Duration, r Ramp, f func()) {
+	rp := &ramping{actor: name, ramp: r, start: s.clock.Now()}
+	s.mu.Lock()
+	s.ramps = append(s.ramps, rp)
+	s.mu.Unlock()
+
+	var tick func()
+	tick = func() {
+		elapsed := s.clock.Now() - rp.start
+		s.findBreakpoint(rp, elapsed)
+		s.schedule(to, time.Duration(float64(time.Second)/r.Rate(elapsed)), tick)
+		s.run(to, f)
+	}
+	s.schedule(to, first, tick)
+}
+
+// findBreakpoint notes the breakpoint of a ramp once the most messages
+// ever waiting at an actor reach its threshold
+func (s *System) findBreakpoint(rp *ramping, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rp.breakpoint != nil {
+		return
+	}
+	var b *Breakpoint
+	for name, a := range s.actors {
+		peak := int(a.(contextual).context().peak.Load())
+		if peak < rp.ramp.Breakpoint || (b != nil && (peak < b.Queue || peak == b.Queue && name > b.Actor)) {
+			continue
+		}
+		b = &Breakpoint{At: s.clock.Now(), Rate: rp.ramp.Rate(elapsed), Actor: name, Queue: peak}
+	}
+	rp.breakpoint = b
+}
+
+// rampReports reports on every ramp in the order the ramps started
+func (s *System) rampReports() []RampReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reports := make([]RampReport, 0, len(s.ramps))
+	for _, rp := range s.ramps {
+		r := RampReport{Actor: rp.actor, Ramp: rp.ramp, Rate: rp.ramp.Rate(s.clock.Now() - rp.start)}
+		if rp.breakpoint != nil {
+			b := *rp.breakpoint
+			r.Breakpoint = &b
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
@@ -0,0 +1,214 @@
+// Generated from ActorSimulation DSL
+// Run reports in a stable JSON schema for tooling
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ReportSchemaVersion is the version of the schema ReportJSON writes
+// Fields may be added without bumping it; it changes once a field changes
+// meaning or goes away, so tools can tell the reports they can't read
+const ReportSchemaVersion = 1
+
+// JSONReport is a report in the schema ReportJSON writes, with snake_case
+// names and durations in nanoseconds, so it stays the same however the Go
+// types behind Report change
+type JSONReport struct {
+	SchemaVersion int            `json:"schema_version"`
+	Seed          int64          `json:"seed"`
+	AtNs          int64          `json:"at_ns"`
+	Config        JSONConfig     `json:"config"`
+	Actors        []JSONActor    `json:"actors"`
+	Edges         []JSONEdge     `json:"edges"`
+	Invariants    JSONInvariants `json:"invariants"`
+}
+
+// JSONConfig echoes what the system was generated from and runs on
+type JSONConfig struct {
+	Virtual bool              `json:"virtual"`
+	Actors  []JSONActorConfig `json:"actors"`
+}
+
+// JSONActorConfig is an actor as the DSL declares it: its targets and its
+// send pattern, if any
+type JSONActorConfig struct {
+	Name        string   `json:"name"`
+	Targets     []string `json:"targets"`
+	SendPattern string   `json:"send_pattern,omitempty"`
+}
+
+// JSONActor is an actor's row of the report
+type JSONActor struct {
+	Name         string             `json:"name"`
+	Sent         int                `json:"sent"`
+	Received     int                `json:"received"`
+	Dropped      int                `json:"dropped"`
+	Expired      int                `json:"expired"`
+	LatencyP50Ns int64              `json:"latency_p50_ns"`
+	LatencyP99Ns int64              `json:"latency_p99_ns"`
+	PeakQueue    int                `json:"peak_queue"`
+	Queue        JSONTimeStats      `json:"queue"`
+	Service      JSONTimeStats      `json:"service"`
+	Metrics      map[string]float64 `json:"metrics,omitempty"`
+}
+
+// JSONTimeStats is TimeStats with durations in nanoseconds
+type JSONTimeStats struct {
+	Count int   `json:"count"`
+	P50Ns int64 `json:"p50_ns"`
+	P99Ns int64 `json:"p99_ns"`
+}
+
+// JSONEdge is an edge the DSL declares, with the bytes sent on it and
+// their rate over the run when its messages have sizes
+type JSONEdge struct {
+	From           string  `json:"from"`
+	To             string  `json:"to"`
+	Bytes          int64   `json:"bytes"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// JSONInvariants are the totals of the conservation ledger, in flight
+// leaving out messages queued behind busy actors, and whether
+// CheckConservation found them to balance
+type JSONInvariants struct {
+	Conserved bool   `json:"conserved"`
+	Violation string `json:"violation,omitempty"`
+	Produced  int64  `json:"produced"`
+	Copied    int64  `json:"copied"`
+	Sunk      int64  `json:"sunk"`
+	Dropped   int64  `json:"dropped"`
+	Expired   int64  `json:"expired"`
+	InFlight  int64  `json:"in_flight"`
+}
+
+// reportConfig holds the actors the system was generated from
+var reportConfig = []JSONActorConfig{
+	{Name: "load_balancer", Targets: []string{"server"}, SendPattern: "{:rate, 10, :request}"},
+	{Name: "server", Targets: []string{"database"}},
+	{Name: "database", Targets: []string{}},
+}
+
+// ReportJSON encodes the report as of now in a stable schema, along with
+// the seed, the configuration and the conservation invariant, for CI or
+// dashboards to parse and compare across runs
+// Call it while no handler runs, as CheckConservation
+func (s *System) ReportJSON() []byte {
+	report := s.Report()
+	r := JSONReport{
+		SchemaVersion: ReportSchemaVersion,
+		Seed:          s.seed,
+		AtNs:          int64(report.At),
+		Config:        JSONConfig{Virtual: s.virtual, Actors: reportConfig},
+		Actors:        []JSONActor{},
+		Edges:         []JSONEdge{},
+	}
+	for _, a := range report.Actors {
+		r.Actors = append(r.Actors, JSONActor{
+			Name:         a.Name,
+			Sent:         a.Sent,
+			Received:     a.Received,
+			Dropped:      a.Dropped,
+			Expired:      a.Expired,
+			LatencyP50Ns: int64(a.P50),
+			LatencyP99Ns: int64(a.P99),
+			PeakQueue:    a.PeakQueue,
+			Queue:        JSONTimeStats{Count: a.Queue.Count, P50Ns: int64(a.Queue.P50), P99Ns: int64(a.Queue.P99)},
+			Service:      JSONTimeStats{Count: a.Service.Count, P50Ns: int64(a.Service.P50), P99Ns: int64(a.Service.P99)},
+			Metrics:      a.Metrics,
+		})
+	}
+	for _, a := range reportConfig {
+		for _, to := range a.Targets {
+			r.Edges = append(r.Edges, JSONEdge{From: a.Name, To: to})
+		}
+	}
+	r.Invariants = JSONInvariants{
+		Conserved: true,
+		Produced:  s.ledger.produced.Load(),
+		Copied:    s.ledger.copied.Load(),
+		Sunk:      s.ledger.sunk.Load(),
+		Dropped:   s.ledger.dropped.Load(),
+		Expired:   s.ledger.expired.Load(),
+		InFlight:  s.ledger.inflight.Load(),
+	}
+	if err := s.CheckConservation(); err != nil {
+		r.Invariants.Conserved = false
+		r.Invariants.Violation = err.Error()
+	}
+	return r.JSON()
+}
+
+// JSON encodes the report as indented JSON
+func (r JSONReport) JSON() []byte {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("encoding a report: %v", err))
+	}
+	return data
+}
+
+// ParseReportJSON reads a report ReportJSON encoded, refusing one in
+// another version of the schema
+func ParseReportJSON(data []byte) (JSONReport, error) {
+	var r JSONReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, err
+	}
+	if r.SchemaVersion != ReportSchemaVersion {
+		return r, fmt.Errorf("report has schema version %d, expected %d", r.SchemaVersion, ReportSchemaVersion)
+	}
+	return r, nil
+}
+
+// LatencyRegression is a latency quantile of an actor that grew from one
+// report to the next by more than a tolerance
+type LatencyRegression struct {
+	Actor    string
+	Quantile string
+	Base     time.Duration
+	Head     time.Duration
+}
+
+// Change is how much the latency grew, as a fraction of the base
+func (l LatencyRegression) Change() float64 {
+	return float64(l.Head-l.Base) / float64(l.Base)
+}
+
+// String describes the regression on one line, e.g. for a CI log
+func (l LatencyRegression) String() string {
+	return fmt.Sprintf("%s %s latency grew %.1f%%, from %v to %v", l.Actor, l.Quantile, 100*l.Change(), l.Base, l.Head)
+}
+
+// LatencyRegressions compares the latency quantiles of each actor in head
+// with base, returning those that grew by more than tolerance, e.g. 0.05
+// for 5%
+// Actors missing from base, or without latency there, are left out
+func LatencyRegressions(base, head JSONReport, tolerance float64) []LatencyRegression {
+	baseline := map[string]JSONActor{}
+	for _, a := range base.Actors {
+		baseline[a.Name] = a
+	}
+	var regressions []LatencyRegression
+	for _, a := range head.Actors {
+		b, ok := baseline[a.Name]
+		if !ok {
+			continue
+		}
+		quantiles := []struct {
+			name       string
+			base, head int64
+		}{{"p50", b.LatencyP50Ns, a.LatencyP50Ns}, {"p99", b.LatencyP99Ns, a.LatencyP99Ns}}
+		for _, q := range quantiles {
+			if q.base > 0 && float64(q.head-q.base) > tolerance*float64(q.base) {
+				regressions = append(regressions, LatencyRegression{Actor: a.Name, Quantile: q.name, Base: time.Duration(q.base), Head: time.Duration(q.head)})
+			}
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,19 @@
+// Generated from ActorSimulation DSL
+// Default callback implementation for: server
+// CUSTOMIZE THIS FILE - This is where you add your custom behavior!
+
+package main
+
+// DefaultServerCallbacks provides default implementations
+// CUSTOMIZE THIS to add your own behavior!
+type DefaultServerCallbacks struct {
+	// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+	// and Ctx.Logf logs a sampled line
+	Ctx *Context
+}
+
+func (c *DefaultServerCallbacks) OnRequest() error {
+	// TODO: Implement custom behavior for request
+	c.Ctx.Logf("Server: Received request message\n")
+	return nil
+}
@@ -0,0 +1,587 @@
+// Generated from ActorSimulation DSL
+// Actor system for storm_actors
+// DO NOT EDIT - This file is auto-generated
+
+package main
+
+import (
+	"fmt"
+	"github.com/Arceliar/phony"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// System owns every actor, the clock that drives them and the seed of the
+// RNG streams behind stochastic behavior such as message loss, so a run is
+// reproducible from its seed and the changes made to it, which it keeps
+// for Fork
+type System struct {
+	mu           sync.Mutex
+	seed         int64
+	rng          *rand.Rand
+	history      []change
+	clock        Clock
+	virtual      bool
+	started      chan struct{}
+	stopped      atomic.Bool
+	stopper      stopper
+	halted       chan struct{}
+	err          error
+	middleware   chain
+	inflight     atomic.Int64
+	ledger       ledger
+	traceSample  float64
+	traceDraw    func() float64
+	traces       atomic.Uint64
+	idGen        atomic.Uint64
+	partitioned  atomic.Int64
+	lost         []LostMessage
+	supervision  []SupervisorEvent
+	depths       depthLog
+	actors       map[string]actor
+	tickers      map[phony.Actor]*ticker
+	ranks        map[string]int
+	metricSink   MetricSink
+	logs         logSampler
+	ramps        []*ramping
+	loadBalancer *LoadBalancer
+	server       *Server
+	database     *Database
+}
+
+// NewSystem spawns all actors and wires them to their targets
+// Pass a VirtualClock to run the system deterministically in virtual time,
+// and options such as WithMetricSink to configure it
+func NewSystem(seed int64, clock Clock, opts ...Option) *System {
+	s := &System{seed: seed, rng: rand.New(rand.NewSource(seed)), clock: clock}
+	for _, opt := range opts {
+		opt(s)
+	}
+	_, s.virtual = clock.(*VirtualClock)
+	s.started = make(chan struct{})
+	s.halted = make(chan struct{})
+	s.tickers = map[phony.Actor]*ticker{}
+	s.logs.every.Store(1)
+	s.loadBalancer = newLoadBalancer(s)
+	s.server = newServer(s)
+	s.database = newDatabase(s)
+	s.actors = map[string]actor{"load_balancer": s.loadBalancer, "server": s.server, "database": s.database}
+	s.ranks = map[string]int{"load_balancer": 1, "server": 2, "database": 3}
+
+	s.loadBalancer.targets = []LoadBalancerTarget{s.server}
+	s.server.targets = []ServerTarget{s.database}
+	for _, shard := range s.server.shards {
+		shard.targets = []ServerTarget{s.database}
+	}
+	for name, a := range s.actors {
+		s.instrument(name, a)
+	}
+	return s
+}
+
+// newLoadBalancer creates a LoadBalancer with its DSL settings but no edges
+func newLoadBalancer(s *System) *LoadBalancer {
+	a := &LoadBalancer{sys: s}
+	return a
+}
+
+// newServer creates a Server with its DSL settings but no edges
+func newServer(s *System) *Server {
+	a := &Server{sys: s}
+	a.shards = make([]*Server, 3)
+	for i := range a.shards {
+		shard := &Server{sys: s}
+		a.shards[i] = shard
+	}
+	return a
+}
+
+// newDatabase creates a Database with its DSL settings but no edges
+func newDatabase(s *System) *Database {
+	a := &Database{sys: s}
+	a.queue = NewFairQueue(1)
+	return a
+}
+
+// Start starts every actor
+// Call it once: on a RealClock no timer fires before it returns, since
+// otherwise one due at once could reach an actor that isn't started yet
+func (s *System) Start() {
+	s.loadBalancer.Start()
+	s.server.Start()
+	s.database.Start()
+	s.record((*System).Start)
+	close(s.started)
+}
+
+// Stop stops a system running on a RealClock: it cancels the timers still
+// pending and returns once the timers firing and the messages in flight are
+// handled, so nothing of the system's is left running
+// Call it once Start has returned, from outside the actors
+func (s *System) Stop() {
+	s.stopped.Store(true)
+	s.stopper.stop()
+}
+
+// halt stops the system on the first error a callback of the named actor
+// returns, so no timer runs after it: on a RealClock as Stop does but
+// without waiting, since a callback calls it, and on a VirtualClock by
+// running no timer after it
+func (s *System) halt(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = fmt.Errorf("%s: %w", name, err)
+	s.stopped.Store(true)
+	if s.virtual {
+		s.clock.(*VirtualClock).halt()
+	}
+	close(s.halted)
+}
+
+// fail halts the system on an error the callback of a message returned;
+// the message goes no further, so it counts as dropped
+func (s *System) fail(name string, err error) {
+	s.ledger.dropped.Add(1)
+	s.halt(name, err)
+}
+
+// Err returns the error a callback halted the system with, prefixed with
+// the name of its actor, or nil if none has
+// Safe to call from outside the actors
+func (s *System) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Halted is closed once a callback halts the system
+func (s *System) Halted() <-chan struct{} {
+	return s.halted
+}
+
+// Advance moves a system running on a VirtualClock forward by d
+func (s *System) Advance(d time.Duration) {
+	s.clock.(*VirtualClock).Advance(d)
+}
+
+// SetPriority ranks an actor's messages and timers under the Priority
+// policy of a VirtualClock; higher runs first and actors default to zero
+func (s *System) SetPriority(name string, priority int) error {
+	s.mu.Lock()
+	a, ok := s.actors[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown actor %q", name)
+	}
+	clock, ok := s.clock.(*VirtualClock)
+	if !ok {
+		return fmt.Errorf("priorities need a VirtualClock")
+	}
+	clock.setPriority(a, priority)
+	s.record(func(s *System) { s.SetPriority(name, priority) })
+	return nil
+}
+
+// Select returns the sorted names of the actors carrying every label in
+// selector, so control-plane operations can target a group of actors
+// An empty selector selects every actor
+func (s *System) Select(selector map[string]string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name, a := range s.actors {
+		if hasLabels(a.Labels(), selector) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Float64 returns the next number from the seeded RNG
+// Safe to call from any actor
+func (s *System) Float64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64()
+}
+
+// Stream returns the draws of an RNG of its own, seeded with a hash of the
+// system's seed and name, such as "loss/client/server" for the loss on
+// that edge: a stream is as reproducible as the seed, yet drawing more or
+// fewer numbers from one never shifts what another draws, so tuning one
+// actor's loss leaves every other actor's losses and delays as they were
+// Safe to call from any actor
+func (s *System) Stream(name string) func() float64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s", s.seed, name)
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	var mu sync.Mutex
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return rng.Float64()
+	}
+}
+
+// Pending returns the number of messages in flight or queued behind a
+// busy actor, plus one-shot timers that have not fired yet
+// Periodic timers are left out since they never run out
+func (s *System) Pending() int {
+	n := int(s.inflight.Load())
+	phony.Block(s.database, func() { n += s.database.queue.Len() })
+	return n
+}
+
+// send delivers a message from one actor to another, along with the
+// header of the message the sender is handling
+func (s *System) send(from, to phony.Actor, f func()) {
+	if s.cut(from, to) {
+		return
+	}
+	h := from.(contextual).context().header
+	h.from = sentBy(from, to, h)
+	h.enqueued = s.clock.Now()
+	s.deliver(from, to, h, f)
+}
+
+// sentBy names the actor a message from one actor to another comes from:
+// an actor handing a message on to one of its inboxes passes on the name
+// of the one that sent it
+func sentBy(from, to phony.Actor, h header) string {
+	name := from.(contextual).context().name
+	if from != to && name == to.(contextual).context().name {
+		return h.from
+	}
+	return name
+}
+
+// deliver hands a message under h from one actor to another, which
+// handles it with f, unless it outlives its TTL on the way
+// Under a VirtualClock each delivery becomes an event, so the whole run
+// is ordered by virtual time instead of goroutine scheduling
+func (s *System) deliver(from, to phony.Actor, h header, f func()) {
+	s.inflight.Add(1)
+	s.ledger.inflight.Add(1)
+	c := to.(contextual).context()
+	c.expect(1)
+	epoch := c.epoch.Load()
+	deliver := func() {
+		s.ledger.inflight.Add(-1)
+		c.expect(-1)
+		if c.crashed(epoch) {
+			s.lose(to, h)
+			s.inflight.Add(-1)
+			return
+		}
+		if h.expired(s.clock.Now()) {
+			s.ledger.expired.Add(1)
+			s.inflight.Add(-1)
+			return
+		}
+		c.header = h
+		start := s.clock.Now()
+		c.arrived(start)
+		f()
+		if _, ok := to.(serving); !ok {
+			c.handled(h.enqueued, start, s.clock.Now())
+		}
+		s.inflight.Add(-1)
+	}
+	if s.virtual {
+		s.scheduleRanked(to, s.ranks[h.id.Source], h.Priority, 0, func() { phony.Block(to, deliver) })
+		return
+	}
+	to.Act(from, s.stopper.track(deliver))
+}
+
+// run executes f on an actor's inbox on behalf of a timer
+func (s *System) run(to phony.Actor, f func()) {
+	if s.virtual {
+		phony.Block(to, f)
+		return
+	}
+	to.Act(nil, s.stopper.track(f))
+}
+
+// after runs f on an actor once d has elapsed
+func (s *System) after(to phony.Actor, d time.Duration, f func()) Timer {
+	return s.afterRanked(to, 0, 0, d, f)
+}
+
+// afterRanked is after for a message from the source of rank, under the
+// priority its header gives it
+func (s *System) afterRanked(to phony.Actor, rank, priority int, d time.Duration, f func()) Timer {
+	s.inflight.Add(1)
+	timer := s.scheduleRanked(to, rank, priority, d, func() {
+		s.run(to, func() {
+			f()
+			s.inflight.Add(-1)
+		})
+	})
+	return &pendingTimer{Timer: timer, sys: s}
+}
+
+// sendAfter delivers a message from one actor to another once d has
+// elapsed
+func (s *System) sendAfter(from, to phony.Actor, d time.Duration, f func()) {
+	if s.cut(from, to) {
+		return
+	}
+	s.ledger.inflight.Add(1)
+	h := from.(contextual).context().header
+	h.from = sentBy(from, to, h)
+	h.enqueued = s.clock.Now() + d
+	c := to.(contextual).context()
+	epoch := c.epoch.Load()
+	s.afterRanked(to, s.ranks[h.id.Source], h.Priority, d, func() {
+		s.ledger.inflight.Add(-1)
+		if c.crashed(epoch) {
+			s.lose(to, h)
+			return
+		}
+		if h.expired(s.clock.Now()) {
+			s.ledger.expired.Add(1)
+			return
+		}
+		c.header = h
+		start := s.clock.Now()
+		c.arrived(start)
+		f()
+		if _, ok := to.(serving); !ok {
+			c.handled(h.enqueued, start, s.clock.Now())
+		}
+	})
+}
+
+// every runs f on an actor each time interval elapses
+// Reconfigure can change the interval, which applies from the next tick
+func (s *System) every(to phony.Actor, interval time.Duration, f func()) {
+	s.everyAfter(to, interval, interval, f)
+}
+
+// everyAfter runs f on an actor once first has elapsed, then each time
+// interval elapses, so actors sharing an interval can fire out of step
+func (s *System) everyAfter(to phony.Actor, first, interval time.Duration, f func()) {
+	t := &ticker{}
+	t.interval.Store(int64(interval))
+	s.mu.Lock()
+	s.tickers[to] = t
+	s.mu.Unlock()
+
+	var tick func()
+	tick = func() {
+		s.schedule(to, time.Duration(t.interval.Load()), tick)
+		s.run(to, f)
+	}
+	s.schedule(to, first, tick)
+}
+
+// watch runs f on an actor at the end of every window of clock time
+// Unlike every it leaves the actor's ticker alone, and unlike after it
+// isn't pending work, so a watched system still settles
+func (s *System) watch(to phony.Actor, window time.Duration, f func()) {
+	var tick func()
+	tick = func() {
+		s.schedule(to, window, tick)
+		s.run(to, f)
+	}
+	s.schedule(to, window, tick)
+}
+
+// schedule runs f once d has elapsed on behalf of owner, so a VirtualClock
+// can order it against other timers due at the same instant
+func (s *System) schedule(owner phony.Actor, d time.Duration, f func()) Timer {
+	return s.scheduleRanked(owner, 0, 0, d, f)
+}
+
+// scheduleRanked is schedule for a message from the source of rank, the
+// position of the actor that originated it in the declaration order, and
+// of the priority its header gives it
+func (s *System) scheduleRanked(owner phony.Actor, rank, priority int, d time.Duration, f func()) Timer {
+	if s.virtual {
+		return s.clock.(*VirtualClock).scheduleRanked(owner, rank, priority, d, f)
+	}
+	return s.stopper.after(s.clock, d, func() {
+		<-s.started
+		if !s.stopped.Load() {
+			f()
+		}
+	})
+}
+
+// hasLabels reports whether labels include every key and value in selector
+func hasLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// header is what a message carries from actor to actor besides its kind:
+// the Headers it travels under, its ID, its key, which is its sequence
+// number at the source that produced it, when it was produced, when it
+// reached the inbox of the actor handling it, the actor that sent it
+// there, empty for one the actor produced or that came from outside, and
+// the payload of the Envelope it came in, if any
+type header struct {
+	Headers
+	id       MessageID
+	key      uint64
+	born     time.Duration
+	enqueued time.Duration
+	from     string
+	payload  any
+}
+
+// messageContext holds the name of an actor, the header of the message
+// it is handling, the time the messages it originates have until their
+// deadline, what the report sums up of the messages that have arrived and
+// been handled, how long the callback of the current one slept, whether
+// the actor is down after a crash, how many times it has crashed, the
+// partition group it is in, the meter recording its metrics and the budget
+// of restarts the supervisor allows it, if any
+// Only the actor's own inbox touches it, apart from the atomic fields
+type messageContext struct {
+	name        string
+	header      header
+	budget      time.Duration
+	produced    uint64
+	delivered   int
+	latency     latencies
+	queueTime   latencies
+	serviceTime latencies
+	slept       time.Duration
+	down        bool
+	inbound     atomic.Int64
+	peak        atomic.Int64
+	group       atomic.Int32
+	epoch       atomic.Uint64
+	meter       *meter
+	restarts    *restartBudget
+}
+
+func (c *messageContext) context() *messageContext {
+	return c
+}
+
+// contextual is implemented by every generated actor
+type contextual interface {
+	context() *messageContext
+}
+
+// serving is implemented by actors with a fair queue, which time the
+// messages they serve themselves
+type serving interface {
+	serveNext()
+}
+
+// ticker holds the interval of a periodic timer, read each time it fires
+type ticker struct {
+	interval atomic.Int64
+}
+
+// pendingTimer stops counting a one-shot timer as pending once it is
+// cancelled
+type pendingTimer struct {
+	Timer
+	sys *System
+}
+
+func (t *pendingTimer) Stop() bool {
+	if !t.Timer.Stop() {
+		return false
+	}
+	t.sys.inflight.Add(-1)
+	return true
+}
+
+// stopper keeps what Stop cancels and waits for on a RealClock: the timers
+// pending on the clock, and the timers firing and messages in flight
+type stopper struct {
+	mu      sync.Mutex
+	stopped bool
+	pending map[*realTimer]struct{}
+	running sync.WaitGroup
+}
+
+// after runs f once d has elapsed on clock, unless stop comes first
+func (st *stopper) after(clock Clock, d time.Duration, f func()) Timer {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.stopped {
+		return stoppedTimer{}
+	}
+	if st.pending == nil {
+		st.pending = map[*realTimer]struct{}{}
+	}
+	t := &realTimer{stopper: st}
+	st.pending[t] = struct{}{}
+	st.running.Add(1)
+	t.Timer = clock.AfterFunc(d, func() {
+		defer st.running.Done()
+		st.forget(t)
+		f()
+	})
+	return t
+}
+
+// track counts f as under way until it has run
+func (st *stopper) track(f func()) func() {
+	st.running.Add(1)
+	return func() {
+		defer st.running.Done()
+		f()
+	}
+}
+
+// forget stops counting t as pending
+func (st *stopper) forget(t *realTimer) {
+	st.mu.Lock()
+	delete(st.pending, t)
+	st.mu.Unlock()
+}
+
+// stop cancels the timers still pending, lets no timer start after it and
+// waits for whatever is under way, including what that sets off in turn
+func (st *stopper) stop() {
+	st.mu.Lock()
+	st.stopped = true
+	pending := st.pending
+	st.pending = nil
+	st.mu.Unlock()
+	for t := range pending {
+		t.Stop()
+	}
+	st.running.Wait()
+}
+
+// realTimer is a timer on a RealClock that Stop can cancel
+type realTimer struct {
+	Timer
+	stopper *stopper
+}
+
+func (t *realTimer) Stop() bool {
+	if !t.Timer.Stop() {
+		return false
+	}
+	t.stopper.forget(t)
+	t.stopper.running.Done()
+	return true
+}
+
+// stoppedTimer is the timer a RealClock gives once Stop has run: it never
+// fires
+type stoppedTimer struct{}
+
+func (stoppedTimer) Stop() bool { return false }
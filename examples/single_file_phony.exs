@@ -27,15 +27,22 @@ simulation =
   ActorSimulation.new()
   |> ActorSimulation.add_actor(:burst_generator,
     send_pattern: {:burst, 10, 1000, :batch},
-    targets: [:processor]
+    targets: [:processor],
+    # Raise an alarm when a burst sends faster than 50 messages a second
+    alarm: [{:rate, :>, 50}, window: 100]
   )
-  |> ActorSimulation.add_actor(:processor)
+  # Each batch takes 20ms, so every burst queues up at the processor
+  |> ActorSimulation.add_actor(:processor, fair_queue: [batch: 1], service_time: 20)
 
 # Generate Phony (Go) code with callback interfaces
 {:ok, files} =
   ActorSimulation.PhonyGenerator.generate(simulation,
     project_name: "burst_actors",
-    enable_callbacks: true
+    enable_callbacks: true,
+    # Trace 1% of the flood
+    trace_sample: 0.01,
+    # Log one in every 100 callback lines
+    log_every: 100
   )
 
 # Write to output directory
@@ -113,10 +113,154 @@ defmodule ActorSimulation do
     - `{:periodic, interval, message}` - Send message every interval ms
     - `{:rate, messages_per_second, message}` - Send at a specific rate
     - `{:burst, count, interval, message}` - Send count messages every interval
-  - `:targets` - List of actor names to send messages to
+    - `{:self_message, delay, message}` - Send message once, after delay ms
+    - An interval may also be a duration string such as `"250ms"`, `"1.5s"` or
+      `"2m"`; any other string raises an `ArgumentError` naming the actor
+  - `:targets` - List of actor names to send messages to; a target given as
+    `{name, weight: n}` routes each message to one target by static weights,
+    as a `:weight_schedule` that never changes (used by code generators)
   - `:on_receive` - Function called when receiving a message: `fn msg, state -> {:ok, new_state} | {:send, msgs, new_state} end`
   - `:on_match` - Pattern matching responses: `[{pattern, response_fn}]`
   - `:initial_state` - Initial state for the actor (default: %{})
+  - `:loss` - Message loss on outgoing edges (used by code generators):
+    - `{:gilbert_elliott, p_good_to_bad: p, p_bad_to_good: r}` - Burst loss on every edge
+    - `[target: {:gilbert_elliott, ...}]` - Burst loss on selected edges only
+  - `:delay` - Latency on outgoing edges in ms, drawn per message (used by code
+    generators): `{:constant, ms}`, `{:uniform, min, max}`, `{:exponential, mean}`
+    or `{:normal, mean, stddev}` (clamped at zero); a keyword list of these
+    delays selected edges only
+  - `:delivery` - `:at_least_once` resends each message on every outgoing edge,
+    every 100ms or every `ms` with `{:at_least_once, retry: ms}`, until the
+    target acknowledges it; a keyword list of these selects edges only (used
+    by code generators)
+  - `:timeout` / `:fallback` - Send to `:fallback` when a target has not replied
+    within `:timeout` ms (used by code generators)
+  - `:service_time` - Time in ms this actor takes to reply, and to process each
+    message when fair queuing (used by code generators)
+  - `:fair_queue` - Per-message weights, e.g. `[data: 3, control: 1]`; queued
+    messages are processed in proportion to their weights so no message kind
+    starves (used by code generators)
+  - `:conflate_by` - `:message` or `:source` keeps only the newest queued
+    message of each kind, or of each kind from each source, replacing older
+    ones in line while the actor is busy for its `:service_time` (used by
+    code generators)
+  - `:deadline` - Time in ms after an actor originates a message by which it
+    must be processed; the deadline travels with the message (used by code
+    generators)
+  - `:deadline_aware` - `true` drops queued messages whose deadline has passed
+    on the virtual clock by the time they are next in line, processing only
+    those still on time (used by code generators)
+  - `:dlq_retry` - `[interval: ms, max: attempts]` holds messages an outgoing
+    edge drops, to loss or a partition, as dead letters and resends them every
+    `ms` until they get through or have been resent `attempts` times, when
+    they move to a failure list (used by code generators)
+  - `:join_by` - `{:key, within: ms}` joins the actor's two incoming message
+    kinds by key (each message's sequence number at its source) within a
+    window of `ms`, sending each pair on as one `:joined` message (or
+    `emit: name`) and dropping unmatched messages when the window closes
+    (used by code generators)
+  - `:start_delay` - Milliseconds before the actor first sends, instead of
+    one interval of its send pattern, so actors with the same interval do
+    not all send at the same instant
+  - `:circuit_breaker` - `[threshold: n, cooldown: ms]` opens the circuit on
+    an edge after `n` consecutive timed-out requests, sending to the fallback
+    instead until a trial request after `ms` succeeds; needs `:timeout` (used
+    by code generators)
+  - `:schedule_file` - Path of a CSV file of `at_ms,message` lines, e.g. a
+    recording of production traffic, that an actor without a send pattern
+    replays from the start; the file is copied into the generated project
+    (used by code generators)
+  - `:parallelism` - Number of inboxes an actor without a send pattern is
+    spread over, taking messages in turn, while keeping one name and one set
+    of counters (used by code generators)
+  - `:observe` - Buffer capacity for recording the messages the actor
+    receives, which tests read from `Received()`, or with Go 1.23 range
+    over `Messages()` (used by code generators)
+  - `:metrics` - Metrics derived from the actor's report counters, e.g.
+    `[utilization: {:received, :/, :capacity}]`, where `:capacity` is the
+    number of messages the actor could have served in its `:service_time`
+    (used by code generators)
+  - `:labels` - Arbitrary labels, e.g. `[region: "eu", tier: "cache"]`, exposed
+    at runtime for metrics and selecting groups of actors (used by code
+    generators)
+  - `:alarm` - Thresholds on the actor's send rate in messages per second,
+    e.g. `[{:rate, :>, 500}, {:rate, :<, 10}, window: 100]`, checked over
+    consecutive windows of `ms` (default 1000); crossing one raises an alarm
+    (used by code generators)
+  - `:ramp` - Moves a `{:rate, from, message}` send pattern to `to` messages
+    per second over `over` ms, then holds it, e.g. `[to: 1000, over: 30_000]`;
+    linear, or `curve: :exponential`; `breakpoint: n` (default 100) messages
+    waiting at one actor mark where the system falls behind (used by code
+    generators)
+  - `:weight_schedule` - Routes each message to one target instead of all,
+    by weights that change over virtual time, e.g.
+    `[server3: [%{at: 0, w: 5}, %{at: 10_000, w: 0}]]` drains `:server3`
+    from 10s on; targets left out keep weight 1 (used by code generators)
+  - `:size` - Bytes each message the actor sends takes on an edge, either
+    one size for all, e.g. `1500`, or per message, e.g. `[data: 1500, ack: 64]`;
+    edges count the bytes they carry (used by code generators)
+  - `:ack_path` - `:reverse` has the sinks the actor's messages reach ack each
+    one back the way it came, so the actor's latency is the round trip; the
+    actor originates messages and receives none (used by code generators)
+  - `:idempotent_by` - `:id` applies each message once by its message ID:
+    copies of one the actor already applied, such as retries of an
+    at-least-once edge, are acked but deduped (used by code generators)
+  - `:affinity_by` - `{:session, within: ms}` routes each message to one
+    target, keeping messages of a session, their key modulo `sessions:`
+    (default 100), on the same target until the session is idle for `ms`,
+    then rebalancing it onto the target with the fewest sessions (used by
+    code generators)
+  - `:reorder_by` - `:seq` releases the messages of each source in the order
+    it sent them, holding those that arrive ahead of an earlier one until it
+    does and dropping stale copies (used by code generators)
+  - `:transforms` - Filters and maps on the edges to some of the actor's
+    targets, e.g. `[sink: [filter: :even, map: :double]]` for
+    `source -> (filter even) -> (map double) -> sink`, applied in order before
+    each message goes out, with the callbacks supplying them (used by code
+    generators)
+  - `:restart` - The actor's restart budget, e.g. `[max: 3, within: 10_000]`
+    for at most 3 restarts within 10 seconds: a crash past it leaves the actor
+    down for good (used by code generators)
+  - `:idle_timeout` - Fires the actor's `OnIdle()` callback once it receives
+    no message for that many ms, and again every as many ms it stays idle
+    (used by code generators)
+  - `:priority_aging` - `[step: 1, every: 100]` queues what the actor
+    receives and serves the message of highest `Priority` header first, raising
+    a queued message's priority by 1 for every 100 ms it waits so none starves
+    (used by code generators)
+  - `:kafka_source` - `[topic: "orders", brokers: ["localhost:9092"]]`
+    originates a message, of the kind in `:message` (default `:record`), for
+    each record consumed from the topic once the system is connected to Kafka,
+    instead of following a send pattern (used by code generators)
+  - `:kafka_sink` - `[topic: "results", brokers: ["localhost:9092"]]` produces
+    each message the actor handles to the topic (used by code generators)
+  - `:probe` - `true` makes the actor a probe on the edge from its one sender:
+    it passes every message on to its targets unchanged, with no callbacks,
+    counting them, their bytes and latency (used by code generators)
+  - `:timers` - Named periodic tasks beside the send pattern, each firing a
+    method of its own at its interval in ms, e.g. `[flush: 1000, compact: 10_000]`
+    (used by code generators)
+  - `:message_types` - Payload fields of message kinds, e.g.
+    `[request: [id: :int, body: :string]]`, with types `:int`, `:float`,
+    `:string`, `:bool` or `:bytes`: every actor handling the kind gets its
+    payload in its callback, as it travels on with the message (used by code
+    generators)
+  - `:routing` - How each message picks its targets: `:broadcast` to all of
+    them (the default), `:round_robin` to each in turn or `:random` to one
+    drawn from the seed (used by code generators)
+  - `:mailbox` - Bounds the queue of messages waiting for the actor, e.g.
+    `[capacity: 1000, overflow: :drop_oldest]`: a message that finds it full
+    is dropped (`:drop_newest`, the default), drops the oldest waiting one
+    (`:drop_oldest`) or waits for room (`:block`) (used by code generators)
+  - `:replies` - Replies the actor sends back to whoever sent it a request,
+    e.g. `[query: :result]` answers each `:query` with a `:result`, which the
+    sender takes in its own callback and times as a round trip instead of
+    forwarding it (used by code generators)
+  - `:payload` - Fills in the typed payload of each message the actor's
+    send pattern originates, a generator per field, e.g.
+    `[id: :sequential, body: {:random_bytes, 256}]`; `{:random_int, max}`
+    and `{:random_string, n}` draw from the seed too (used by code
+    generators)
   """
   def add_actor(simulation, name, opts \\ []) do
     actor_def = Definition.new(name, opts)
@@ -132,7 +276,12 @@ defmodule ActorSimulation do
     {:ok, pid} = Actor.start_link(actor_def, simulation.clock, actor_opts)
 
     actors =
-      Map.put(simulation.actors, name, %{pid: pid, definition: actor_def, type: :simulated})
+      Map.put(simulation.actors, name, %{
+        pid: pid,
+        definition: actor_def,
+        type: :simulated,
+        location: declared_at()
+      })
 
     %{simulation | actors: actors}
   end
@@ -184,12 +333,82 @@ defmodule ActorSimulation do
         pid: pid,
         type: :real_process,
         module: module,
-        targets: targets
+        targets: targets,
+        location: declared_at()
       })
 
     %{simulation | actors: actors}
   end
 
+  @doc """
+  Includes shared actor definitions in the simulation.
+
+  `source` is a keyword list of `name: opts` definitions, as passed to
+  `add_actor/3`, or the path of an `.exs` file that evaluates to one, so
+  common actors such as a database can be defined once and included in
+  many simulations. Raises `ArgumentError` if a name is already in the
+  simulation or defined twice, before adding any actor.
+
+  ## Example
+
+      # common.exs
+      [database: [on_match: [{:query, fn s -> {:reply, :result, s} end}]]]
+
+      ActorSimulation.new()
+      |> ActorSimulation.include("common.exs")
+      |> ActorSimulation.add_actor(:app,
+          send_pattern: {:periodic, 100, :query}, targets: [:database])
+  """
+  def include(simulation, source) do
+    definitions = load_definitions(source)
+    names = Keyword.keys(definitions)
+
+    case Enum.filter(names, &(Map.has_key?(simulation.actors, &1) or &1 in (names -- [&1]))) do
+      [] ->
+        :ok
+
+      collisions ->
+        raise ArgumentError,
+              "cannot include #{inspect(Enum.uniq(collisions))}: actor names collide"
+    end
+
+    Enum.reduce(definitions, simulation, fn {name, opts}, acc -> add_actor(acc, name, opts) end)
+  end
+
+  defp load_definitions(path) when is_binary(path) do
+    {definitions, _binding} = Code.eval_file(path)
+
+    if Keyword.keyword?(definitions) do
+      definitions
+    else
+      raise ArgumentError, "expected #{path} to evaluate to a keyword list of actor definitions"
+    end
+  end
+
+  defp load_definitions(definitions) when is_list(definitions), do: definitions
+
+  # Where the caller declares an actor, as the first frame outside this
+  # library, for errors found later, such as a target no actor answers to
+  defp declared_at do
+    {:current_stacktrace, stacktrace} = Process.info(self(), :current_stacktrace)
+
+    caller =
+      stacktrace
+      |> Enum.reverse()
+      |> Enum.take_while(fn {module, _function, _arity, _location} ->
+        :application.get_application(module) != {:ok, :gen_server_virtual_time}
+      end)
+      |> List.last()
+
+    case caller do
+      {_module, _function, _arity, location} ->
+        if line = location[:line], do: [file: to_string(location[:file]), line: line], else: []
+
+      nil ->
+        []
+    end
+  end
+
   @doc """
   Runs the simulation for the specified duration (in milliseconds).
 
@@ -198,6 +417,8 @@ defmodule ActorSimulation do
   - `:terminate_when` - Function that takes simulation and returns true to stop
   - `:check_interval` - How often to check termination condition in ms (default: 100)
   - `:expected_messages` - Integer count of expected messages to receive before terminating
+  - `:seed` - Seeds `:rand` in every simulated actor, so callbacks that draw
+    random numbers draw the same ones on every run
 
   ## Boundary Condition Handling
 
@@ -233,6 +454,7 @@ defmodule ActorSimulation do
     terminate_when = Keyword.get(opts, :terminate_when)
     expected_messages = Keyword.get(opts, :expected_messages)
     check_interval = Keyword.get(opts, :check_interval, 100)
+    seed = Keyword.get(opts, :seed)
 
     # If expected_messages is provided, create a terminate_when function for it
     terminate_when =
@@ -256,7 +478,7 @@ defmodule ActorSimulation do
     Enum.each(simulation.actors, fn {_name, actor_info} ->
       case actor_info.type do
         :simulated ->
-          Actor.start_sending(actor_info.pid, simulation.actors)
+          Actor.start_sending(actor_info.pid, simulation.actors, seed)
 
         :real_process ->
           # Real processes are already started and don't need actor map
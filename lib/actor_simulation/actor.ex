@@ -34,8 +34,8 @@ defmodule ActorSimulation.Actor do
     )
   end
 
-  def start_sending(actor, actors_map) do
-    VirtualTimeGenServer.call(actor, {:start_sending, actors_map})
+  def start_sending(actor, actors_map, seed \\ nil) do
+    VirtualTimeGenServer.call(actor, {:start_sending, actors_map, seed})
   end
 
   def get_stats(actor) do
@@ -57,14 +57,17 @@ defmodule ActorSimulation.Actor do
   end
 
   @impl true
-  def handle_call({:start_sending, actors_map}, _from, state) do
+  def handle_call({:start_sending, actors_map, seed}, _from, state) do
     new_state = %{state | actors_map: actors_map}
 
+    # Each actor draws from a sequence of its own, the same on every run
+    if seed, do: :rand.seed(:exsss, {seed, :erlang.phash2(state.definition.name), 0})
+
     # Schedule first send if this actor has a send pattern
     new_state =
       if state.definition.send_pattern do
-        interval = Definition.interval_for_pattern(state.definition.send_pattern)
-        VirtualTimeGenServer.send_after(self(), :send_tick, interval)
+        delay = Definition.first_send_delay(state.definition)
+        VirtualTimeGenServer.send_after(self(), :send_tick, delay)
         new_state
       else
         new_state
@@ -51,7 +51,7 @@ defmodule ActorSimulation.CAFGenerator do
     enable_callbacks = Keyword.get(opts, :enable_callbacks, true)
     caf_version = Keyword.get(opts, :caf_version, "1.0.2")
 
-    actors = simulation.actors
+    actors = ActorSimulation.GeneratorUtils.validate_targets!(simulation.actors)
 
     files =
       []
@@ -20,20 +20,187 @@ defmodule ActorSimulation.Definition do
     :targets,
     :on_receive,
     :on_match,
-    :initial_state
+    :initial_state,
+    :loss,
+    :delay,
+    :delivery,
+    :timeout,
+    :fallback,
+    :service_time,
+    :fair_queue,
+    :conflate_by,
+    :deadline,
+    :deadline_aware,
+    :dlq_retry,
+    :join_by,
+    :labels,
+    :start_delay,
+    :observe,
+    :circuit_breaker,
+    :parallelism,
+    :schedule_file,
+    :metrics,
+    :alarm,
+    :ramp,
+    :weight_schedule,
+    :size,
+    :ack_path,
+    :idempotent_by,
+    :probe,
+    :affinity_by,
+    :reorder_by,
+    :transforms,
+    :restart,
+    :idle_timeout,
+    :priority_aging,
+    :kafka_source,
+    :kafka_sink,
+    :timers,
+    :message_types,
+    :routing,
+    :mailbox,
+    :replies,
+    :payload
   ]
 
   def new(name, opts) do
+    {targets, weights} = targets(name, Keyword.get(opts, :targets, []))
+
     %__MODULE__{
       name: name,
-      send_pattern: Keyword.get(opts, :send_pattern),
-      targets: Keyword.get(opts, :targets, []),
+      send_pattern: send_pattern(name, Keyword.get(opts, :send_pattern)),
+      targets: targets,
       on_receive: Keyword.get(opts, :on_receive),
       on_match: Keyword.get(opts, :on_match, []),
-      initial_state: Keyword.get(opts, :initial_state, %{})
+      initial_state: Keyword.get(opts, :initial_state, %{}),
+      loss: Keyword.get(opts, :loss),
+      delay: Keyword.get(opts, :delay),
+      delivery: Keyword.get(opts, :delivery),
+      timeout: Keyword.get(opts, :timeout),
+      fallback: Keyword.get(opts, :fallback),
+      service_time: Keyword.get(opts, :service_time),
+      fair_queue: Keyword.get(opts, :fair_queue),
+      conflate_by: Keyword.get(opts, :conflate_by),
+      deadline: Keyword.get(opts, :deadline),
+      deadline_aware: Keyword.get(opts, :deadline_aware, false),
+      dlq_retry: Keyword.get(opts, :dlq_retry),
+      join_by: Keyword.get(opts, :join_by),
+      start_delay: Keyword.get(opts, :start_delay),
+      observe: Keyword.get(opts, :observe),
+      circuit_breaker: Keyword.get(opts, :circuit_breaker),
+      parallelism: Keyword.get(opts, :parallelism),
+      schedule_file: Keyword.get(opts, :schedule_file),
+      metrics: Keyword.get(opts, :metrics, []),
+      labels: Keyword.get(opts, :labels, []),
+      alarm: Keyword.get(opts, :alarm),
+      ramp: Keyword.get(opts, :ramp),
+      weight_schedule: weight_schedule(name, Keyword.get(opts, :weight_schedule), weights),
+      size: Keyword.get(opts, :size),
+      ack_path: Keyword.get(opts, :ack_path),
+      idempotent_by: Keyword.get(opts, :idempotent_by),
+      probe: Keyword.get(opts, :probe, false),
+      affinity_by: Keyword.get(opts, :affinity_by),
+      reorder_by: Keyword.get(opts, :reorder_by),
+      transforms: Keyword.get(opts, :transforms),
+      restart: Keyword.get(opts, :restart),
+      idle_timeout: Keyword.get(opts, :idle_timeout),
+      priority_aging: Keyword.get(opts, :priority_aging),
+      kafka_source: Keyword.get(opts, :kafka_source),
+      kafka_sink: Keyword.get(opts, :kafka_sink),
+      timers: Keyword.get(opts, :timers),
+      message_types: Keyword.get(opts, :message_types),
+      routing: Keyword.get(opts, :routing),
+      mailbox: Keyword.get(opts, :mailbox),
+      replies: Keyword.get(opts, :replies),
+      payload: Keyword.get(opts, :payload)
     }
   end
 
+  # A target may carry a static weight, such as {:server1, weight: 5}; the
+  # weights route like a weight_schedule that never changes
+  defp targets(name, targets) do
+    targets
+    |> Enum.map(fn
+      {target, [weight: weight]} when is_integer(weight) and weight >= 0 ->
+        {target, {target, weight}}
+
+      {_target, [weight: _weight]} = target ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid target #{inspect(target)}, " <>
+                "expected a weight of 0 or more"
+
+      target ->
+        {target, nil}
+    end)
+    |> Enum.unzip()
+    |> then(fn {targets, weights} -> {targets, Enum.reject(weights, &is_nil/1)} end)
+  end
+
+  defp weight_schedule(_name, schedules, []), do: schedules
+
+  defp weight_schedule(_name, nil, weights),
+    do: Enum.map(weights, fn {target, weight} -> {target, [%{at: 0, w: weight}]} end)
+
+  defp weight_schedule(name, _schedules, _weights) do
+    raise ArgumentError,
+          "actor #{inspect(name)} weights its targets and has a weight_schedule, " <>
+            "expected one or the other"
+  end
+
+  # Intervals may be given as duration strings, such as "250ms" or "1.5s"
+  defp send_pattern(name, {:periodic, interval, message}),
+    do: {:periodic, interval_ms(name, interval), message}
+
+  defp send_pattern(name, {:burst, count, interval, message}),
+    do: {:burst, count, interval_ms(name, interval), message}
+
+  defp send_pattern(name, {:self_message, delay, message}),
+    do: {:self_message, interval_ms(name, delay), message}
+
+  defp send_pattern(_name, pattern), do: pattern
+
+  defp interval_ms(name, interval) when is_binary(interval) do
+    case duration_ms(interval) do
+      {:ok, ms} ->
+        ms
+
+      :error ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid interval #{inspect(interval)}, expected " <>
+                "ms or a duration such as \"250ms\", \"1.5s\" or \"2m\""
+    end
+  end
+
+  defp interval_ms(_name, interval), do: interval
+
+  @duration_units %{"ms" => 1, "s" => 1000, "m" => 60_000, "h" => 3_600_000}
+
+  @doc """
+  Parses a duration string of whole milliseconds, in `ms`, `s`, `m` or `h`.
+
+  ## Examples
+
+      iex> ActorSimulation.Definition.duration_ms("250ms")
+      {:ok, 250}
+
+      iex> ActorSimulation.Definition.duration_ms("1.5s")
+      {:ok, 1500}
+
+      iex> ActorSimulation.Definition.duration_ms("250xyz")
+      :error
+
+  """
+  def duration_ms(duration) when is_binary(duration) do
+    with [_, amount, unit] <- Regex.run(~r/^(\d+(?:\.\d+)?)(ms|s|m|h)$/, duration),
+         {value, ""} <- Float.parse(amount),
+         ms = value * Map.fetch!(@duration_units, unit),
+         true <- ms > 0 and abs(ms - round(ms)) < 1.0e-6 do
+      {:ok, round(ms)}
+    else
+      _ -> :error
+    end
+  end
+
   @doc """
   Matches a message against the on_match patterns and returns the response.
   Returns nil if no match.
@@ -77,6 +244,25 @@ defmodule ActorSimulation.Definition do
   def interval_for_pattern({:self_message, delay, _message}), do: delay
   def interval_for_pattern(nil), do: nil
 
+  @doc """
+  Calculates the delay in milliseconds before an actor first sends: its
+  `start_delay` if it has one, otherwise one interval of its send pattern.
+
+  ## Examples
+
+      iex> ActorSimulation.Definition.new(:a, send_pattern: {:periodic, 100, :msg})
+      ...> |> ActorSimulation.Definition.first_send_delay()
+      100
+
+      iex> ActorSimulation.Definition.new(:a, send_pattern: {:rate, 10, :msg}, start_delay: 5)
+      ...> |> ActorSimulation.Definition.first_send_delay()
+      5
+
+  """
+  def first_send_delay(%__MODULE__{send_pattern: nil}), do: nil
+  def first_send_delay(%__MODULE__{start_delay: delay}) when is_integer(delay), do: delay
+  def first_send_delay(%__MODULE__{send_pattern: pattern}), do: interval_for_pattern(pattern)
+
   @doc """
   Gets the message(s) to send for a send pattern.
 
@@ -148,6 +148,50 @@ defmodule ActorSimulation.GeneratorUtils do
     |> Enum.map(fn {name, info} -> {name, info.definition} end)
   end
 
+  @doc """
+  Checks that every target and fallback of an actor names an actor in the
+  simulation, so a generator rejects a dangling one before writing any file.
+
+  Raises `ArgumentError` listing each dangling reference, prefixed with the
+  file and line the actor is declared at when known.
+
+  ## Example
+
+      # spec.exs
+      ActorSimulation.new()
+      |> ActorSimulation.add_actor(:publisher, targets: [:subscriber9])
+      |> Map.fetch!(:actors)
+      |> GeneratorUtils.validate_targets!()
+      # ** (ArgumentError) spec.exs:3: actor :publisher targets unknown :subscriber9
+  """
+  def validate_targets!(actors) do
+    dangling =
+      for {name, info} <- Enum.sort(actors),
+          target <- Enum.uniq(references(info)),
+          not Map.has_key?(actors, target) do
+        declared_at(info) <> "actor #{inspect(name)} targets unknown #{inspect(target)}"
+      end
+
+    if dangling != [] do
+      raise ArgumentError, Enum.join(dangling, "\n")
+    end
+
+    actors
+  end
+
+  defp references(%{type: :simulated, definition: definition}) do
+    (definition.targets || []) ++ List.wrap(definition.fallback)
+  end
+
+  defp references(info), do: Map.get(info, :targets) || []
+
+  defp declared_at(info) do
+    case Map.get(info, :location, []) do
+      [file: file, line: line] -> "#{Path.relative_to_cwd(file)}:#{line}: "
+      _ -> ""
+    end
+  end
+
   @doc """
   Generates a basic README template.
   """
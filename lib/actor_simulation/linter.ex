@@ -0,0 +1,122 @@
+defmodule ActorSimulation.Linter do
+  @moduledoc """
+  Checks the topology of a simulation for anti-patterns before it is run
+  or generated.
+
+  The edges are the `:targets` and `:fallback` of each actor, the same ones
+  the code generators wire up; sources are actors with a `:send_pattern`,
+  and real processes with targets, which may send on their own.
+
+  Checks:
+  - `:orphan` - an actor no one sends to that sends to no one
+  - `:source_sink` - a source without targets, whose messages go nowhere
+  - `:unreachable` - an actor no source's messages can reach
+  - `:fan_out` - an actor with more targets than `:max_fan_out`
+
+  ## Example
+
+      iex> simulation =
+      ...>   ActorSimulation.new()
+      ...>   |> ActorSimulation.add_actor(:publisher,
+      ...>     send_pattern: {:periodic, 100, :event},
+      ...>     targets: [:subscriber1])
+      ...>   |> ActorSimulation.add_actor(:subscriber1)
+      ...>   |> ActorSimulation.add_actor(:subscriber2)
+      iex> ActorSimulation.Linter.lint(simulation)
+      [{:orphan, :subscriber2, "actor :subscriber2 has no incoming or outgoing edges"}]
+
+  """
+
+  @default_max_fan_out 10
+
+  @doc """
+  Returns a `{check, actor, message}` warning for every anti-pattern in
+  `simulation`, sorted by actor name, or `[]` when there are none.
+
+  Also takes a keyword list of actor definitions, as `ActorSimulation.include/2`
+  does, to check a spec without starting it.
+
+  Options:
+  - `:max_fan_out` - Most targets an actor may have (default: #{@default_max_fan_out})
+  """
+  def lint(simulation, opts \\ []) do
+    max_fan_out = Keyword.get(opts, :max_fan_out, @default_max_fan_out)
+    actors = actors(simulation)
+    names = Map.keys(actors)
+
+    edges =
+      Map.new(actors, fn {name, actor} ->
+        {name, actor.targets |> Enum.filter(&(&1 in names)) |> Enum.uniq()}
+      end)
+
+    incoming = edges |> Map.values() |> List.flatten() |> MapSet.new()
+    sources = for {name, %{source?: true}} <- actors, do: name
+    reachable = reach(sources, edges, MapSet.new(sources))
+
+    names
+    |> Enum.sort()
+    |> Enum.flat_map(fn name ->
+      targets = Map.fetch!(edges, name)
+
+      [
+        topology_warning(name, targets, name in sources, name in incoming, name in reachable),
+        fan_out_warning(name, targets, max_fan_out)
+      ]
+    end)
+    |> Enum.reject(&is_nil/1)
+  end
+
+  defp actors(%ActorSimulation{actors: actors}) do
+    Map.new(actors, fn
+      {name, %{type: :simulated, definition: definition}} ->
+        {name, definition_actor(definition)}
+
+      {name, info} ->
+        targets = Map.get(info, :targets) || []
+        {name, %{targets: targets, source?: targets != []}}
+    end)
+  end
+
+  defp actors(definitions) when is_list(definitions) do
+    Map.new(definitions, fn {name, opts} ->
+      {name, definition_actor(ActorSimulation.Definition.new(name, opts))}
+    end)
+  end
+
+  defp definition_actor(definition) do
+    %{
+      targets: (definition.targets || []) ++ List.wrap(definition.fallback),
+      source?: definition.send_pattern != nil
+    }
+  end
+
+  defp reach([], _edges, seen), do: seen
+
+  defp reach([name | rest], edges, seen) do
+    next = Enum.reject(Map.fetch!(edges, name), &MapSet.member?(seen, &1))
+    reach(next ++ rest, edges, MapSet.union(seen, MapSet.new(next)))
+  end
+
+  # An actor gets at most one of these, the first that applies
+  defp topology_warning(name, [], true, _incoming?, _reachable?) do
+    {:source_sink, name, "actor #{inspect(name)} is a source without targets"}
+  end
+
+  defp topology_warning(name, [], false, false, _reachable?) do
+    {:orphan, name, "actor #{inspect(name)} has no incoming or outgoing edges"}
+  end
+
+  defp topology_warning(name, _targets, _source?, _incoming?, false) do
+    {:unreachable, name, "actor #{inspect(name)} is unreachable from any source"}
+  end
+
+  defp topology_warning(_name, _targets, _source?, _incoming?, true), do: nil
+
+  defp fan_out_warning(name, targets, max_fan_out) when length(targets) > max_fan_out do
+    {:fan_out, name,
+     "actor #{inspect(name)} sends to #{length(targets)} targets, " <>
+       "more than the limit of #{max_fan_out}"}
+  end
+
+  defp fan_out_warning(_name, _targets, _max_fan_out), do: nil
+end
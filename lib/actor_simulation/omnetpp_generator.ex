@@ -47,7 +47,7 @@ defmodule ActorSimulation.OMNeTPPGenerator do
     expected_messages = Keyword.get(opts, :expected_messages)
     suppress_output = Keyword.get(opts, :suppress_output, high_frequency)
 
-    actors = simulation.actors
+    actors = ActorSimulation.GeneratorUtils.validate_targets!(simulation.actors)
 
     files =
       []
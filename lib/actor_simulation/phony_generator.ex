@@ -30,7 +30,7 @@ defmodule ActorSimulation.PhonyGenerator do
       PhonyGenerator.write_to_directory(files, "phony_output/")
   """
 
-  alias ActorSimulation.GeneratorUtils
+  alias ActorSimulation.{Definition, GeneratorUtils}
 
   @doc """
   Generates complete Phony (Go) project files from an ActorSimulation.
@@ -40,6 +40,28 @@ defmodule ActorSimulation.PhonyGenerator do
   - `:project_name` (required) - Name of the Go module (snake_case)
   - `:enable_callbacks` (default: true) - Generate callback interfaces
   - `:go_version` (default: "1.21") - Go version for go.mod
+  - `:seed` (default: 42) - Seed for the RNG behind stochastic features in `main.go`
+  - `:metrics_addr` (default: "localhost:8080") - Address `main.go` serves the
+    expvar metrics on, at `/debug/vars`, and Prometheus metrics at `/metrics`,
+    when run with `METRICS` set
+  - `:allow_duplicate` (default: false) - Keep targets an actor lists more than
+    once instead of warning and generating a single edge
+  - `:trace_sample` (default: 0) - Fraction of produced messages `main.go`
+    traces and logs, drawn from the seeded RNG
+  - `:log_every` (default: 1) - Default callbacks log one in every this many
+    of their lines, so a flood of messages stays readable
+  - `:partitions` (default: []) - Partitions `main.go` schedules, each
+    `[at: ms, heal: ms, groups: [[actor, ...], ...]]`
+  - `:crashes` (default: []) - Crashes `main.go` schedules, each
+    `[actor: name, at: ms, restart: ms]`
+  - `:interfaces` (default: false) - Generate an `<Actor>Iface` interface of
+    every actor's messages, so callers and tests can depend on it instead of
+    the concrete type
+  - `:package` (default: "main") - Go package of the actors; any other package
+    makes them an importable library, with `main.go` moved to
+    `cmd/<project_name>/`
+  - `:module` (default: the project name) - Go module path in `go.mod`, which
+    `main.go` and the tests import the project's packages by
 
   ## Returns
 
@@ -49,17 +71,94 @@ defmodule ActorSimulation.PhonyGenerator do
     project_name = Keyword.fetch!(opts, :project_name)
     enable_callbacks = Keyword.get(opts, :enable_callbacks, true)
     go_version = Keyword.get(opts, :go_version, "1.21")
+    seed = Keyword.get(opts, :seed, 42)
+    allow_duplicate = Keyword.get(opts, :allow_duplicate, false)
+    metrics_addr = Keyword.get(opts, :metrics_addr, "localhost:8080")
+    trace_sample = validate_trace_sample(Keyword.get(opts, :trace_sample, 0))
+    log_every = validate_log_every(Keyword.get(opts, :log_every, 1))
+    # Only callbacks log
+    logs = if enable_callbacks, do: log_every
+    partitions = Keyword.get(opts, :partitions, [])
+    crashes = Keyword.get(opts, :crashes, [])
+    interfaces = Keyword.get(opts, :interfaces, false)
+    package = validate_package(Keyword.get(opts, :package, "main"))
+    module = Keyword.get(opts, :module, project_name)
 
-    actors = simulation.actors
+    actors =
+      simulation.actors
+      |> GeneratorUtils.validate_targets!()
+      |> implied_queues()
+      |> probed_edges()
+    topology = build_topology(actors, allow_duplicate)
 
     files =
       []
-      |> add_actor_files(actors, enable_callbacks)
-      |> add_main_file(actors, project_name)
-      |> add_test_file(actors)
-      |> add_go_mod(project_name, go_version)
-      |> add_ci_pipeline(project_name)
-      |> add_readme(project_name)
+      |> add_actor_files(actors, topology, enable_callbacks, interfaces)
+      |> add_system_file(actors, topology, project_name, logs)
+      |> add_clock_file()
+      |> add_middleware_file()
+      |> add_loss_file(actors)
+      |> add_delay_file(actors)
+      |> add_fair_queue_file(actors)
+      |> add_join_file(actors)
+      |> add_breaker_file(actors)
+      |> add_observe_file(actors)
+      |> add_messages_files(actors, topology)
+      |> add_dead_letter_file(actors)
+      |> add_alarm_file(actors)
+      |> add_ramp_file(actors)
+      |> add_routing_file(actors)
+      |> add_affinity_file(actors)
+      |> add_size_file(actors)
+      |> add_ack_file(actors)
+      |> add_reply_file(actors)
+      |> add_probe_file(actors)
+      |> add_reorder_file(actors)
+      |> add_transform_file(actors)
+      |> add_schedule_files(actors)
+      |> add_kafka_file(actors)
+      |> add_payloads_file(actors, topology)
+      |> add_metrics_file(actors, topology)
+      |> add_metric_sink_file()
+      |> add_report_file(actors, topology)
+      |> add_depth_file()
+      |> add_progress_file()
+      |> add_partition_file(actors, partitions)
+      |> add_crash_file(actors, crashes)
+      |> add_main_file(
+        project_name,
+        package,
+        module,
+        seed,
+        metrics_addr,
+        trace_sample,
+        partitions: partitions,
+        crashes: crashes,
+        logs: logs
+      )
+      |> add_simtest_file()
+      |> add_sweep_file()
+      |> add_fork_file()
+      |> add_debugger_file()
+      |> add_diff_file()
+      |> add_codec_file()
+      |> add_report_json_file(actors, topology)
+      |> add_reconfigure_file(actors)
+      |> add_topology_file(actors, topology)
+      |> add_trace_sink_file()
+      |> add_conservation_file(actors)
+      |> add_trace_file()
+      |> add_id_file()
+      |> add_envelope_file()
+      |> add_sleep_file(enable_callbacks)
+      |> add_log_file(enable_callbacks)
+      |> add_phony_test_file()
+      |> add_bench_file(actors, topology)
+      |> add_test_file(actors, topology, module, trace_sample, enable_callbacks, interfaces)
+      |> add_go_mod(module, go_version)
+      |> add_ci_pipeline(project_name, package)
+      |> add_readme(project_name, package, module)
+      |> in_package(package)
 
     {:ok, files}
   end
@@ -73,21 +172,327 @@ defmodule ActorSimulation.PhonyGenerator do
 
   # Private functions
 
-  defp add_actor_files(files, actors, enable_callbacks) do
+  # Resolves which simulated actors each actor is wired to and which messages
+  # each actor handles: the one it originates plus everything forwarded to it.
+  # A join forwards the message it combines its two streams into instead, and
+  # an actor sending requests to a replier handles the replies as well.
+  defp build_topology(actors, allow_duplicate) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+    names = Enum.map(simulated, fn {name, _def} -> name end)
+
+    targets =
+      Map.new(simulated, fn {name, definition} ->
+        actor_targets = Enum.filter(definition.targets, &(&1 in names))
+        {name, dedup_targets(name, actor_targets, allow_duplicate)}
+      end)
+
+    own =
+      Map.new(simulated, fn {name, definition} ->
+        {name, originated_messages(definition)}
+      end)
+
+    fallbacks =
+      Map.new(simulated, fn {name, definition} ->
+        {name, validate_fallback(name, definition, names)}
+      end)
+
+    # Messages fall back along the same route as they are sent
+    edges =
+      Map.new(targets, fn {name, actor_targets} ->
+        {name, actor_targets ++ List.wrap(Map.fetch!(fallbacks, name))}
+      end)
+
+    joins =
+      for {name, %{join_by: join_by} = definition} <- simulated, join_by != nil, into: %{} do
+        {name, [join_window(definition).emit]}
+      end
+
+    messages = propagate_messages(own, edges, joins)
+    requests = reply_requests(simulated, edges, messages, joins)
+
+    messages =
+      Enum.reduce(requests, messages, fn {from, _to, _kind, reply}, acc ->
+        Map.update!(acc, from, &Enum.uniq(&1 ++ [reply]))
+      end)
+
+    acked = acked_actors(simulated, edges)
+    types = payload_types(simulated)
+
+    Enum.each(joins, fn {name, _emit} ->
+      unless length(Map.fetch!(messages, name)) == 2 do
+        raise ArgumentError,
+              "actor #{inspect(name)} joins two streams but receives " <>
+                inspect(Map.fetch!(messages, name))
+      end
+    end)
+
+    %{
+      targets: targets,
+      fallbacks: fallbacks,
+      edges: edges,
+      messages: messages,
+      requests: requests,
+      acked: acked,
+      types: types
+    }
+  end
+
+  # The requests repliers answer, as {sender, replier, kind, reply}: every
+  # actor wired to send a replier a kind it replies to takes those replies
+  # back, in place of forwarding them
+  defp reply_requests(simulated, edges, messages, joins) do
+    definitions = Map.new(simulated)
+
+    requests =
+      for {name, definition} <- simulated,
+          {kind, reply} <- replies(definition),
+          {from, to} <- edges,
+          name in to,
+          kind in Map.get_lazy(joins, from, fn -> Map.fetch!(messages, from) end) do
+        {from, name, kind, reply}
+      end
+
+    Enum.each(simulated, fn {name, definition} ->
+      Enum.each(replies(definition), fn {kind, _reply} ->
+        cond do
+          kind in originated_messages(definition) ->
+            raise ArgumentError,
+                  "actor #{inspect(name)} replies to #{inspect(kind)}, which it originates"
+
+          kind not in Map.fetch!(messages, name) ->
+            raise ArgumentError,
+                  "actor #{inspect(name)} replies to #{inspect(kind)}, which it never receives"
+
+          true ->
+            :ok
+        end
+      end)
+    end)
+
+    Enum.each(requests, fn {from, to, _kind, reply} ->
+      definition = Map.fetch!(definitions, from)
+
+      cond do
+        reply in Map.fetch!(messages, from) ->
+          raise ArgumentError,
+                "actor #{inspect(from)} already receives #{inspect(reply)}, " <>
+                  "so it can't take it as a reply from #{inspect(to)}"
+
+        probe?(definition) or parallelism(definition) ->
+          raise ArgumentError,
+                "actor #{inspect(from)} can't time replies from #{inspect(to)} " <>
+                  "as a probe or over several inboxes"
+
+        true ->
+          :ok
+      end
+    end)
+
+    requests
+  end
+
+  # What an actor sends and takes in request-reply: the kinds it sends to a
+  # replier, timing their round trips, and the replies it gets back
+  defp reply_roles(topology, name) do
+    requests = for {^name, _to, kind, reply} <- topology.requests, do: {kind, reply}
+
+    %{
+      asks: requests |> Enum.map(&elem(&1, 0)) |> Enum.uniq(),
+      answers: requests |> Enum.map(&elem(&1, 1)) |> Enum.uniq()
+    }
+  end
+
+  # The payload types declared for message kinds, by kind name; each kind
+  # gets one type, whichever actors declare it
+  defp payload_types(simulated) do
+    type_names =
+      MapSet.new(simulated, fn {name, _definition} -> GeneratorUtils.to_pascal_case(name) end)
+
+    Enum.reduce(simulated, %{}, fn {name, definition}, acc ->
+      Enum.reduce(message_types(definition), acc, fn {kind, fields}, acc ->
+        cond do
+          Map.get(acc, kind, fields) != fields ->
+            raise ArgumentError,
+                  "actor #{inspect(name)} declares message_types for #{kind} unlike another actor"
+
+          GeneratorUtils.to_pascal_case(kind) in type_names ->
+            raise ArgumentError,
+                  "actor #{inspect(name)} has message_types for #{kind}, named like an actor"
+
+          true ->
+            Map.put(acc, kind, fields)
+        end
+      end)
+    end)
+  end
+
+  # The actors on the ack path of a source: the source and everything its
+  # messages reach. The source times round trips as its latency, so it
+  # receives no messages of its own.
+  defp acked_actors(simulated, edges) do
+    sources = for {name, definition} <- simulated, ack_path(definition), do: {name, definition}
+
+    Enum.each(sources, fn {name, definition} ->
+      cond do
+        (definition.send_pattern == nil and definition.schedule_file == nil) or
+            Map.fetch!(edges, name) == [] ->
+          raise ArgumentError,
+                "actor #{inspect(name)} acks the messages it originates, " <>
+                  "so it needs targets and a send_pattern or schedule_file"
+
+        Enum.any?(edges, fn {_from, to} -> name in to end) ->
+          raise ArgumentError,
+                "actor #{inspect(name)} times round trips on its ack path, " <>
+                  "so it can't receive messages"
+
+        true ->
+          :ok
+      end
+    end)
+
+    sources |> Enum.map(&elem(&1, 0)) |> reach(edges) |> MapSet.new()
+  end
+
+  defp reach(names, edges) do
+    next = Enum.uniq(names ++ Enum.flat_map(names, &Map.fetch!(edges, &1)))
+    if length(next) == length(names), do: names, else: reach(next, edges)
+  end
+
+  # What an actor sends: a join sends the message it combines its streams
+  # into, everyone else forwards what they receive
+  defp outgoing_messages(%{join_by: nil}, messages), do: messages
+  defp outgoing_messages(definition, _messages), do: [join_window(definition).emit]
+
+  defp join_window(%{name: name, join_by: {:key, opts}} = definition) when is_list(opts) do
+    within = Keyword.get(opts, :within)
+
+    unless is_integer(within) and within > 0 do
+      raise ArgumentError,
+            "actor #{inspect(name)} needs a positive join window in ms, got #{inspect(within)}"
+    end
+
+    if definition.send_pattern || definition.schedule_file || definition.fair_queue do
+      raise ArgumentError, "actor #{inspect(name)} can't join streams and send or queue its own"
+    end
+
+    %{within: within, emit: Keyword.get(opts, :emit, :joined)}
+  end
+
+  defp join_window(%{name: name, join_by: join_by}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has unsupported join #{inspect(join_by)}, " <>
+            "expected {:key, within: ms}"
+  end
+
+  defp validate_package(package) when is_binary(package) do
+    if package =~ ~r/^[a-z][a-z0-9_]*$/ do
+      package
+    else
+      raise ArgumentError,
+            "package must be a lowercase Go package name, got #{inspect(package)}"
+    end
+  end
+
+  defp validate_package(package) do
+    raise ArgumentError, "package must be a lowercase Go package name, got #{inspect(package)}"
+  end
+
+  defp validate_trace_sample(p) when is_number(p) and p >= 0 and p <= 1, do: p
+
+  defp validate_trace_sample(p) do
+    raise ArgumentError, "trace_sample must be a fraction between 0 and 1, got #{inspect(p)}"
+  end
+
+  defp validate_log_every(n) when is_integer(n) and n >= 1, do: n
+
+  defp validate_log_every(n) do
+    raise ArgumentError, "log_every must be a positive integer, got #{inspect(n)}"
+  end
+
+  defp validate_fallback(_name, %{timeout: nil, fallback: nil}, _names), do: nil
+
+  defp validate_fallback(name, %{timeout: timeout, fallback: fallback}, names)
+       when is_integer(timeout) and timeout > 0 and not is_nil(fallback) do
+    if fallback in names do
+      fallback
+    else
+      raise ArgumentError,
+            "actor #{inspect(name)} falls back to #{inspect(fallback)}, " <>
+              "which is not a simulated actor"
+    end
+  end
+
+  defp validate_fallback(name, _definition, _names) do
+    raise ArgumentError,
+          "actor #{inspect(name)} needs both a positive :timeout (ms) and a :fallback target"
+  end
+
+  # A target listed twice would receive every message twice.
+  defp dedup_targets(_name, targets, true), do: targets
+
+  defp dedup_targets(name, targets, false) do
+    case Enum.uniq(targets -- Enum.uniq(targets)) do
+      [] ->
+        targets
+
+      duplicates ->
+        IO.warn(
+          "actor #{inspect(name)} lists #{Enum.map_join(duplicates, ", ", &inspect/1)} " <>
+            "more than once; generating a single edge (pass allow_duplicate: true to keep them)"
+        )
+
+        Enum.uniq(targets)
+    end
+  end
+
+  defp propagate_messages(messages, targets, joins) do
+    next =
+      Enum.reduce(targets, messages, fn {name, actor_targets}, acc ->
+        outgoing = Map.get_lazy(joins, name, fn -> Map.fetch!(acc, name) end)
+
+        Enum.reduce(actor_targets, acc, fn target, acc ->
+          Map.update!(acc, target, &Enum.uniq(&1 ++ outgoing))
+        end)
+      end)
+
+    if next == messages, do: messages, else: propagate_messages(next, targets, joins)
+  end
+
+  defp add_actor_files(files, actors, topology, enable_callbacks, interfaces) do
     Enum.reduce(actors, files, fn {name, actor_info}, acc ->
       case actor_info.type do
         :simulated ->
           definition = actor_info.definition
           snake_name = GeneratorUtils.to_snake_case(name)
+          messages = Map.fetch!(topology.messages, name)
+          targets = Map.fetch!(topology.targets, name)
+          acked? = MapSet.member?(topology.acked, name)
+          callbacks? = callbacks?(definition, enable_callbacks)
+
+          if transforms(definition) != [] and not callbacks? do
+            raise ArgumentError,
+                  "actor #{inspect(name)} has transforms, which need callbacks to supply them"
+          end
 
           # Generate actor interface file (generated code, do not edit)
-          actor_file = generate_actor_file(name, definition, enable_callbacks)
+          actor_file =
+            generate_actor_file(
+              name,
+              definition,
+              messages,
+              targets,
+              callbacks?,
+              interfaces,
+              acked?,
+              reply_roles(topology, name),
+              topology.types
+            )
           new_files = [{"#{snake_name}.go", actor_file}]
 
           # Generate callbacks file (custom code, meant to be edited)
           new_files =
-            if enable_callbacks do
-              callback_file = generate_callbacks_file(name, definition)
+            if callbacks? do
+              callback_file = generate_callbacks_file(name, definition, messages, topology.types)
               new_files ++ [{"#{snake_name}_callbacks.go", callback_file}]
             else
               new_files
@@ -101,331 +506,15610 @@ defmodule ActorSimulation.PhonyGenerator do
     end)
   end
 
-  defp add_main_file(files, actors, project_name) do
-    content = generate_main(actors, project_name)
-    [{"main.go", content} | files]
+  defp add_system_file(files, actors, topology, project_name, logs) do
+    content = generate_system_file(actors, topology, project_name, logs)
+    [{"system.go", content} | files]
   end
 
-  defp add_test_file(files, actors) do
-    content = generate_test_file(actors)
-    [{"actor_test.go", content} | files]
+  defp add_clock_file(files) do
+    [{"clock.go", generate_clock_file()} | files]
   end
 
-  defp add_go_mod(files, project_name, go_version) do
-    content = generate_go_mod(project_name, go_version)
-    [{"go.mod", content} | files]
+  defp add_middleware_file(files) do
+    [{"middleware.go", generate_middleware_file()} | files]
   end
 
-  defp add_ci_pipeline(files, project_name) do
-    content = generate_ci_pipeline(project_name)
-    [{".github/workflows/ci.yml", content} | files]
+  defp add_loss_file(files, actors) do
+    if uses_loss?(actors) do
+      [{"loss.go", generate_loss_file()} | files]
+    else
+      files
+    end
   end
 
-  defp add_readme(files, project_name) do
-    content = generate_readme(project_name)
-    [{"README.md", content} | files]
+  defp add_delay_file(files, actors) do
+    if uses_delay?(actors) do
+      [{"delay.go", generate_delay_file()} | files]
+    else
+      files
+    end
   end
 
-  defp generate_actor_file(name, definition, enable_callbacks) do
-    type_name = GeneratorUtils.to_pascal_case(name)
+  # An actor that conflates, sheds by deadline, ages priorities or bounds its
+  # mailbox queues what it receives, with equal weights unless it declares
+  # fair_queue: too
+  defp implied_queues(actors) do
+    Map.new(actors, fn
+      {name, %{definition: %{fair_queue: nil} = definition} = info} = actor ->
+        if conflation(definition) || deadline_aware?(definition) ||
+             definition.priority_aging != nil || mailbox(definition) != nil,
+           do: {name, %{info | definition: %{definition | fair_queue: []}}},
+           else: actor
 
-    callback_interface =
-      if enable_callbacks do
-        generate_callback_interface(name, definition)
-      else
-        ""
-      end
+      actor ->
+        actor
+    end)
+  end
 
-    callback_field =
-      if enable_callbacks do
-        """
-        \tcallbacks #{type_name}Callbacks
-        """
+  # A probe passes on what its one sender sends it, taking the sender's
+  # size so the edge on either side of it carries the same bytes
+  defp probed_edges(actors) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+
+    Map.new(actors, fn
+      {name, %{type: :simulated, definition: definition} = info} = actor ->
+        senders = for {_from, sender} <- simulated, name in sender.targets, do: sender
+
+        cond do
+          not probe?(definition) ->
+            actor
+
+          length(senders) != 1 or definition.targets == [] or definition.send_pattern != nil or
+              definition.schedule_file != nil ->
+            raise ArgumentError,
+                  "actor #{inspect(name)} probes an edge, so it needs one sender, " <>
+                    "targets and no send_pattern or schedule_file"
+
+          true ->
+            [sender] = senders
+            {name, %{info | definition: %{definition | size: definition.size || sender.size}}}
+        end
+
+      actor ->
+        actor
+    end)
+  end
+
+  defp deadline_aware?(%{deadline_aware: aware}) when aware in [nil, false], do: false
+
+  defp deadline_aware?(%{name: name, deadline_aware: true} = definition) do
+    if conflation(definition) do
+      raise ArgumentError,
+            "actor #{inspect(name)} can't both conflate and shed messages by deadline"
+    end
+
+    true
+  end
+
+  defp deadline_aware?(%{name: name, deadline_aware: aware}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid deadline_aware #{inspect(aware)}, " <>
+            "expected a boolean"
+  end
+
+  # Aging serves by priority instead of weights, and a conflated message
+  # would take the place of one that has aged longer
+  defp priority_aging(%{priority_aging: nil}), do: nil
+
+  defp priority_aging(%{name: name, priority_aging: aging} = definition) do
+    with true <- Keyword.keyword?(aging),
+         step when is_integer(step) and step > 0 <- Keyword.get(aging, :step),
+         every when is_integer(every) and every > 0 <- Keyword.get(aging, :every),
+         [] <- definition.fair_queue,
+         nil <- conflation(definition) do
+      {step, every}
+    else
+      _invalid ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid priority_aging #{inspect(aging)}, " <>
+                "expected [step: priority, every: ms] on an actor without fair queue " <>
+                "weights or conflation"
+    end
+  end
+
+  # A Kafka source originates a message from each record it consumes, so it
+  # has no send pattern or schedule of its own, nor inboxes to shard them
+  defp kafka_source(%{kafka_source: nil}), do: nil
+
+  defp kafka_source(%{name: name, kafka_source: source} = definition) do
+    with %{} = endpoint <- kafka_endpoint(source),
+         message when is_atom(message) and message != nil <-
+           Keyword.get(source, :message, :record),
+         true <- definition.targets != [] and definition.send_pattern == nil,
+         true <- definition.schedule_file == nil and definition.join_by == nil,
+         nil <- definition.parallelism do
+      Map.put(endpoint, :message, message)
+    else
+      _invalid ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid kafka_source #{inspect(source)}, " <>
+                "expected [topic: name, brokers: [address], message: kind] on an actor " <>
+                "with targets and no send pattern, schedule_file, join or parallelism"
+    end
+  end
+
+  defp kafka_sink(%{kafka_sink: nil}), do: nil
+
+  defp kafka_sink(%{name: name, kafka_sink: sink}) do
+    case kafka_endpoint(sink) do
+      nil ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid kafka_sink #{inspect(sink)}, " <>
+                "expected [topic: name, brokers: [address]]"
+
+      endpoint ->
+        endpoint
+    end
+  end
+
+  defp kafka_endpoint(opts) do
+    with true <- Keyword.keyword?(opts),
+         topic when is_binary(topic) and topic != "" <- Keyword.get(opts, :topic),
+         [_ | _] = brokers <- Keyword.get(opts, :brokers),
+         true <- Enum.all?(brokers, &(is_binary(&1) and &1 != "")) do
+      %{topic: topic, brokers: brokers}
+    else
+      _invalid -> nil
+    end
+  end
+
+  @payload_field_types %{
+    int: "int",
+    float: "float64",
+    string: "string",
+    bool: "bool",
+    bytes: "[]byte"
+  }
+
+  # Typed payloads as {kind name, [{Go field, Go type}]}
+  defp message_types(%{message_types: nil}), do: []
+
+  defp message_types(%{name: name, message_types: types}) do
+    valid? =
+      Keyword.keyword?(types) and types != [] and
+        Enum.all?(types, fn {_kind, fields} ->
+          Keyword.keyword?(fields) and fields != [] and
+            Enum.all?(fields, fn {_field, type} -> Map.has_key?(@payload_field_types, type) end)
+        end)
+
+    unless valid? do
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid message_types #{inspect(types)}, expected " <>
+              "[kind: [field: :int | :float | :string | :bool | :bytes]]"
+    end
+
+    Enum.map(types, fn {kind, fields} ->
+      {to_string(kind),
+       Enum.map(fields, fn {field, type} ->
+         {GeneratorUtils.to_pascal_case(field), Map.fetch!(@payload_field_types, type)}
+       end)}
+    end)
+  end
+
+  # The payload a source hands each message it originates, as the kind it
+  # sends and the Go expression for each field it fills in, or nil when it
+  # declares none
+  defp payload(%{payload: nil}, _types), do: nil
+
+  defp payload(%{name: name, payload: generators} = definition, types) do
+    kinds = GeneratorUtils.extract_messages(definition.send_pattern)
+    kind = if length(kinds) == 1, do: GeneratorUtils.message_name(hd(kinds))
+    fields = Map.get(types, kind, [])
+
+    exprs =
+      if Keyword.keyword?(generators) and generators != [] do
+        Enum.map(generators, fn {field, generator} ->
+          go_field = GeneratorUtils.to_pascal_case(field)
+
+          case List.keyfind(fields, go_field, 0) do
+            {^go_field, type} -> {go_field, payload_expr(generator, type)}
+            nil -> {go_field, nil}
+          end
+        end)
       else
-        ""
+        [nil]
       end
 
-    callback_init =
-      if enable_callbacks do
+    if kind == nil or Enum.any?(exprs, &(&1 == nil or elem(&1, 1) == nil)) do
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid payload #{inspect(generators)}, expected a " <>
+              "send pattern of a kind with message_types and [field: generator] for its " <>
+              "fields: :sequential or {:random_int, max} for :int, {:random_bytes, n} " <>
+              "for :bytes, {:random_string, n} for :string"
+    end
+
+    %{kind: kind, fields: exprs}
+  end
+
+  defp payload_expr(:sequential, "int"), do: "int(a.header.key)"
+
+  defp payload_expr({:random_int, max}, "int") when is_integer(max) and max > 0,
+    do: "drawInt(a.payloadDraw, #{max})"
+
+  defp payload_expr({:random_bytes, n}, "[]byte") when is_integer(n) and n >= 0,
+    do: "drawBytes(a.payloadDraw, #{n})"
+
+  defp payload_expr({:random_string, n}, "string") when is_integer(n) and n >= 0,
+    do: "drawString(a.payloadDraw, #{n})"
+
+  defp payload_expr(_generator, _type), do: nil
+
+  # Whether a source draws any of its payload from the seeded RNG
+  defp payload_draws?(%{payload: generators}) when is_list(generators),
+    do: Enum.any?(generators, fn {_field, generator} -> generator != :sequential end)
+
+  defp payload_draws?(_definition), do: false
+
+  # The method a source's send pattern hands each message it originates
+  defp originated_handler(definition, message) do
+    msg_name = GeneratorUtils.message_name(message) |> GeneratorUtils.to_pascal_case()
+    if definition.payload, do: "originate#{msg_name}", else: msg_name
+  end
+
+  # The parameter a message's callback takes, and the argument its handler
+  # passes, when the message has a payload type
+  defp callback_param(msg, types) do
+    kind = GeneratorUtils.message_name(msg)
+    if Map.has_key?(types, kind), do: "msg #{GeneratorUtils.to_pascal_case(kind)}", else: ""
+  end
+
+  defp callback_arg(msg, types) do
+    kind = GeneratorUtils.message_name(msg)
+
+    if Map.has_key?(types, kind),
+      do: "payloadAs[#{GeneratorUtils.to_pascal_case(kind)}](a.header.payload)",
+      else: ""
+  end
+
+  # The deadline a source gives the messages it originates, in ms after
+  # producing them
+  defp deadline(%{deadline: nil}), do: nil
+
+  defp deadline(%{deadline: ms} = definition) when is_integer(ms) and ms > 0 do
+    if originated_messages(definition) == [] and definition.schedule_file == nil do
+      raise ArgumentError,
+            "actor #{inspect(definition.name)} has a deadline but originates no messages"
+    end
+
+    ms
+  end
+
+  defp deadline(%{name: name, deadline: ms}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid deadline #{inspect(ms)}, expected a positive ms"
+  end
+
+  # How often the reaper resends an actor's dead letters and how many times
+  # it resends each before giving up
+  defp dlq_retry(%{dlq_retry: nil}), do: nil
+
+  defp dlq_retry(%{name: name, dlq_retry: retry} = definition) when is_list(retry) do
+    interval = Keyword.get(retry, :interval)
+    max = Keyword.get(retry, :max)
+
+    cond do
+      not (is_integer(interval) and interval > 0 and is_integer(max) and max > 0) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid dlq_retry #{inspect(retry)}, " <>
+                "expected [interval: ms, max: attempts]"
+
+      definition.timeout != nil or at_least_once?(definition) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} already recovers dropped messages through " <>
+                "its timeout or at-least-once delivery and can't use dlq_retry"
+
+      parallelism(definition) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} can't reprocess dead letters across parallel inboxes"
+
+      true ->
+        %{interval: interval, max: max}
+    end
+  end
+
+  defp dlq_retry(%{name: name, dlq_retry: retry}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid dlq_retry #{inspect(retry)}, " <>
+            "expected [interval: ms, max: attempts]"
+  end
+
+  # The send rate thresholds an actor's alarm checks, in messages per
+  # second, and the window in ms it checks them over
+  defp alarm(%{alarm: nil}), do: nil
+
+  defp alarm(%{name: name, alarm: alarm} = definition) do
+    {rules, opts} = alarm |> List.wrap() |> Enum.split_with(&match?({:rate, _, _}, &1))
+    above = for {:rate, :>, n} <- rules, do: n
+    below = for {:rate, :<, n} <- rules, do: n
+    window = Keyword.get(opts, :window, 1000)
+
+    cond do
+      not valid_alarm?(rules, above, below, opts, window) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid alarm #{inspect(alarm)}, expected " <>
+                "[{:rate, :>, per_second}, {:rate, :<, per_second}, window: ms]"
+
+      above != [] and below != [] and hd(below) >= hd(above) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has an alarm below #{hd(below)}/s that is not " <>
+                "under its alarm above #{hd(above)}/s"
+
+      parallelism(definition) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} can't watch its send rate across parallel inboxes"
+
+      true ->
+        %{window: window, above: List.first(above), below: List.first(below)}
+    end
+  end
+
+  # An actor's named timers and their intervals in ms, in the order it
+  # declares them
+  defp timers(%{timers: nil}), do: []
+
+  defp timers(%{name: name, timers: timers}) do
+    if Keyword.keyword?(timers) and timers != [] and
+         Enum.all?(timers, fn {_timer, ms} -> is_integer(ms) and ms > 0 end) and
+         Enum.uniq(Keyword.keys(timers)) == Keyword.keys(timers) do
+      timers
+    else
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid timers #{inspect(timers)}, " <>
+              "expected a keyword list of names to intervals in ms"
+    end
+  end
+
+  # Messages an actor originates would keep resetting its idle timeout, and
+  # parallel inboxes each see only some of its messages
+  defp idle_timeout(%{idle_timeout: nil}), do: nil
+
+  defp idle_timeout(%{name: name, idle_timeout: ms} = definition) do
+    if is_integer(ms) and ms > 0 and definition.send_pattern == nil and
+         parallelism(definition) == nil and not Keyword.has_key?(timers(definition), :idle) do
+      ms
+    else
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid idle_timeout #{inspect(ms)}, expected a " <>
+              "positive number of ms on an actor without a send pattern, parallelism or " <>
+              "an :idle timer"
+    end
+  end
+
+  # At most one threshold each way, and only a window besides
+  defp valid_alarm?(rules, above, below, opts, window) do
+    rules != [] and length(above) <= 1 and length(below) <= 1 and
+      length(above) + length(below) == length(rules) and
+      Enum.all?(above ++ below, &(is_number(&1) and &1 > 0)) and
+      Keyword.keyword?(opts) and Keyword.keys(opts) -- [:window] == [] and
+      is_integer(window) and window > 0
+  end
+
+  defp ramp(%{ramp: nil}), do: nil
+
+  defp ramp(%{name: name, ramp: ramp} = definition) do
+    cond do
+      not valid_ramp?(ramp) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid ramp #{inspect(ramp)}, expected " <>
+                "[to: per_second, over: ms, curve: :linear | :exponential, breakpoint: messages]"
+
+      not match?({:rate, _, _}, definition.send_pattern) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} can only ramp a {:rate, per_second, message} send pattern"
+
+      true ->
+        {:rate, from, _message} = definition.send_pattern
+
+        %{
+          from: from,
+          to: Keyword.fetch!(ramp, :to),
+          over: Keyword.fetch!(ramp, :over),
+          curve: Keyword.get(ramp, :curve, :linear),
+          breakpoint: Keyword.get(ramp, :breakpoint, 100)
+        }
+    end
+  end
+
+  defp valid_ramp?(ramp) do
+    Keyword.keyword?(ramp) and Keyword.keys(ramp) -- [:to, :over, :curve, :breakpoint] == [] and
+      is_number(ramp[:to]) and ramp[:to] > 0 and is_integer(ramp[:over]) and ramp[:over] > 0 and
+      Keyword.get(ramp, :curve, :linear) in [:linear, :exponential] and
+      is_integer(Keyword.get(ramp, :breakpoint, 100)) and Keyword.get(ramp, :breakpoint, 100) > 0
+  end
+
+  # How long a session stays pinned to its target while idle, in ms, and
+  # how many sessions the keys of messages fall into
+  defp affinity(%{affinity_by: nil}), do: nil
+
+  defp affinity(
+         %{affinity_by: {:session, opts}, weight_schedule: nil, parallelism: nil} = definition
+       )
+       when is_list(opts) do
+    within = Keyword.get(opts, :within)
+    sessions = Keyword.get(opts, :sessions, 100)
+
+    if Keyword.keyword?(opts) and Keyword.keys(opts) -- [:within, :sessions] == [] and
+         is_integer(within) and within > 0 and is_integer(sessions) and sessions > 0 do
+      {within, sessions}
+    else
+      invalid_affinity(definition)
+    end
+  end
+
+  defp affinity(definition), do: invalid_affinity(definition)
+
+  defp invalid_affinity(%{name: name, affinity_by: affinity_by}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid affinity_by #{inspect(affinity_by)}, " <>
+            "expected {:session, within: ms} or {:session, within: ms, sessions: n} " <>
+            "on an actor without weight_schedule or parallelism"
+  end
+
+  # How an actor picks the targets of each message: all of them, or just
+  # one, by edge weights, in turn or at random
+  defp routing(%{routing: routing, weight_schedule: nil, affinity_by: nil, parallelism: nil})
+       when routing in [:round_robin, :random],
+       do: routing
+
+  defp routing(%{routing: routing, weight_schedule: nil}) when routing in [nil, :broadcast],
+    do: :broadcast
+
+  defp routing(%{routing: nil} = definition) do
+    weight_schedule(definition)
+    :weighted
+  end
+
+  defp routing(%{name: name, routing: routing}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid routing #{inspect(routing)}, expected " <>
+            ":broadcast, or :round_robin or :random on an actor without weight_schedule, " <>
+            "affinity_by or parallelism"
+  end
+
+  # The steps each scheduled edge of a routing actor goes through, as
+  # {at_ms, weight} from time 0
+  defp weight_schedule(%{weight_schedule: nil}), do: nil
+
+  defp weight_schedule(%{name: name, weight_schedule: schedules} = definition) do
+    valid? =
+      Keyword.keyword?(schedules) and schedules != [] and
+        Keyword.keys(schedules) == Enum.uniq(Keyword.keys(schedules)) and
+        Enum.all?(schedules, fn {_target, steps} -> valid_weight_steps?(steps) end)
+
+    unknown = if valid?, do: Keyword.keys(schedules) -- definition.targets, else: []
+
+    cond do
+      not valid? ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid weight_schedule #{inspect(schedules)}, " <>
+                "expected [target: [%{at: 0, w: weight}, %{at: ms, w: weight}, ...]]"
+
+      unknown != [] ->
+        raise ArgumentError,
+              "actor #{inspect(name)} schedules weights for #{inspect(unknown)}, " <>
+                "which it doesn't target"
+
+      parallelism(definition) ->
+        raise ArgumentError,
+              "actor #{inspect(name)} can't route across parallel inboxes"
+
+      true ->
+        Enum.map(schedules, fn {target, steps} ->
+          {target, Enum.map(steps, &{weight_step(&1, :at), weight_step(&1, :w)})}
+        end)
+    end
+  end
+
+  # From time 0, each step later than the one before
+  defp valid_weight_steps?(steps) do
+    with true <- is_list(steps) and steps != [],
+         true <- Enum.all?(steps, &valid_weight_step?/1) do
+      ats = Enum.map(steps, &weight_step(&1, :at))
+      hd(ats) == 0 and ats == Enum.uniq(Enum.sort(ats))
+    end
+  end
+
+  defp valid_weight_step?(step) do
+    (is_map(step) or Keyword.keyword?(step)) and
+      Enum.sort(Map.keys(Map.new(step))) == [:at, :w] and
+      is_integer(weight_step(step, :at)) and weight_step(step, :at) >= 0 and
+      is_integer(weight_step(step, :w)) and weight_step(step, :w) >= 0
+  end
+
+  # A step is a map or keyword list
+  defp weight_step(step, key), do: step |> Map.new() |> Map.fetch!(key)
+
+  # Bytes the messages an actor sends take on an edge: one size for every
+  # message, or a map by message name
+  defp size(%{size: nil}), do: nil
+  defp size(%{size: bytes}) when is_integer(bytes) and bytes > 0, do: bytes
+
+  defp size(%{name: name, size: sizes}) do
+    if Keyword.keyword?(sizes) and sizes != [] and
+         Enum.all?(sizes, fn {_message, bytes} -> is_integer(bytes) and bytes > 0 end) do
+      Map.new(sizes, fn {message, bytes} -> {GeneratorUtils.message_name(message), bytes} end)
+    else
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid size #{inspect(sizes)}, " <>
+              "expected bytes or [message: bytes, ...]"
+    end
+  end
+
+  # Bytes of one message by name, nil when the actor doesn't size it
+  defp message_size(definition, message) do
+    case size(definition) do
+      nil -> nil
+      bytes when is_integer(bytes) -> bytes
+      sizes -> Map.get(sizes, message)
+    end
+  end
+
+  # Whose sinks ack its messages back along the way they came
+  defp ack_path(%{ack_path: nil}), do: nil
+  defp ack_path(%{ack_path: :reverse}), do: :reverse
+
+  defp ack_path(%{name: name, ack_path: path}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has unsupported ack_path #{inspect(path)}, expected :reverse"
+  end
+
+  defp conflation(%{conflate_by: nil}), do: nil
+  defp conflation(%{conflate_by: key}) when key in [:message, :source], do: key
+
+  defp conflation(%{name: name, conflate_by: key}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid conflate_by #{inspect(key)}, " <>
+            "expected :message or :source"
+  end
+
+  # The capacity of a bounded mailbox and what happens to a message that
+  # finds it full
+  defp mailbox(%{mailbox: nil}), do: nil
+
+  defp mailbox(%{mailbox: opts, conflate_by: nil} = definition) when is_list(opts) do
+    capacity = Keyword.get(opts, :capacity)
+    overflow = Keyword.get(opts, :overflow, :drop_newest)
+
+    if Keyword.keyword?(opts) and Keyword.keys(opts) -- [:capacity, :overflow] == [] and
+         is_integer(capacity) and capacity > 0 and
+         overflow in [:drop_oldest, :drop_newest, :block] do
+      {capacity, overflow}
+    else
+      invalid_mailbox(definition)
+    end
+  end
+
+  defp mailbox(definition), do: invalid_mailbox(definition)
+
+  # A blocking mailbox drops what finds as many messages waiting for room as
+  # it holds
+  defp dropping?(definition), do: mailbox(definition) != nil
+
+  defp invalid_mailbox(%{name: name, mailbox: mailbox}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid mailbox #{inspect(mailbox)}, expected " <>
+            "[capacity: n, overflow: :drop_oldest | :drop_newest | :block] " <>
+            "on an actor without conflate_by"
+  end
+
+  defp add_fair_queue_file(files, actors) do
+    if uses_fair_queue?(actors) do
+      [{"fairqueue.go", generate_fair_queue_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_join_file(files, actors) do
+    if uses_join?(actors) do
+      [{"join.go", generate_join_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_breaker_file(files, actors) do
+    if uses_breaker?(actors) do
+      [{"breaker.go", generate_breaker_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_observe_file(files, actors) do
+    if uses_observe?(actors) do
+      [{"observe.go", generate_observe_file()} | files]
+    else
+      files
+    end
+  end
+
+  # Range-over-func needs Go 1.23, so the iterators and their test only
+  # build on a toolchain that has it, whatever go.mod asks for
+  defp add_messages_files(files, actors, topology) do
+    if uses_observe?(actors) do
+      [
+        {"messages.go", generate_messages_file(actors)},
+        {"messages_test.go", generate_messages_test(actors, topology)} | files
+      ]
+    else
+      files
+    end
+  end
+
+  defp add_dead_letter_file(files, actors) do
+    if uses_dead_letters?(actors) do
+      [{"deadletter.go", generate_dead_letter_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_alarm_file(files, actors) do
+    if uses_alarms?(actors) do
+      [{"alarm.go", generate_alarm_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_ramp_file(files, actors) do
+    if uses_ramps?(actors) do
+      [{"ramp.go", generate_ramp_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_routing_file(files, actors) do
+    if uses_routing?(actors) do
+      [{"routing.go", generate_routing_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_affinity_file(files, actors) do
+    if uses_affinity?(actors) do
+      [{"affinity.go", generate_affinity_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_size_file(files, actors) do
+    if uses_sizes?(actors) do
+      [{"size.go", generate_size_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_probe_file(files, actors) do
+    if Enum.any?(GeneratorUtils.simulated_actors(actors), fn {_name, d} -> probe?(d) end) do
+      [{"probe.go", generate_probe_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_reorder_file(files, actors) do
+    if uses_reorder?(actors) do
+      [{"reorder.go", generate_reorder_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_transform_file(files, actors) do
+    if uses_transforms?(actors) do
+      [{"transform.go", generate_transform_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_kafka_file(files, actors) do
+    if uses_kafka?(actors) do
+      [{"kafka.go", generate_kafka_file(actors)} | files]
+    else
+      files
+    end
+  end
+
+  defp add_payloads_file(files, _actors, %{types: types}) when types == %{}, do: files
+
+  defp add_payloads_file(files, actors, %{types: types}) do
+    draws? =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.any?(fn {_name, definition} -> payload_draws?(definition) end)
+
+    [{"payloads.go", generate_payloads_file(types, draws?)} | files]
+  end
+
+  defp add_ack_file(files, actors) do
+    if uses_acks?(actors) do
+      [{"ack.go", generate_ack_file()} | files]
+    else
+      files
+    end
+  end
+
+  defp add_reply_file(files, actors) do
+    if uses_replies?(actors) do
+      [{"reply.go", generate_reply_file()} | files]
+    else
+      files
+    end
+  end
+
+  # Each schedule is copied next to the actor that embeds it
+  defp add_schedule_files(files, actors) do
+    schedules =
+      for {name, definition} <- GeneratorUtils.simulated_actors(actors),
+          %{csv: csv} <- [schedule(definition)],
+          do: {"#{GeneratorUtils.to_snake_case(name)}_schedule.csv", csv}
+
+    if schedules == [],
+      do: files,
+      else: [{"schedule.go", generate_schedule_file()} | schedules ++ files]
+  end
+
+  defp add_report_file(files, actors, topology) do
+    [{"report.go", generate_report_file(actors, topology)} | files]
+  end
+
+  defp add_depth_file(files) do
+    [{"depth.go", generate_depth_file()} | files]
+  end
+
+  defp add_progress_file(files) do
+    [{"progress.go", generate_progress_file()} | files]
+  end
+
+  defp add_metrics_file(files, actors, topology) do
+    [{"expvar.go", generate_metrics_file(actors, topology)} | files]
+  end
+
+  defp add_metric_sink_file(files) do
+    [{"metricsink.go", generate_metric_sink_file()} | files]
+  end
+
+  defp add_partition_file(files, actors, partitions) do
+    [{"partition.go", generate_partition_file(partition_plan(actors, partitions))} | files]
+  end
+
+  defp add_crash_file(files, actors, crashes) do
+    plan = crash_plan(actors, crashes)
+    [{"crash.go", generate_crash_file(plan, crash_blockers(actors))} | files]
+  end
+
+  defp add_phony_test_file(files) do
+    [{"phony_test.go", generate_phony_test_file()} | files]
+  end
+
+  # A run ends once every message comes to rest, so a topology with a
+  # cycle, or with no source, gets no benchmark
+  defp add_bench_file(files, actors, topology) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+
+    sources =
+      for {name, definition} <- simulated,
+          [message | _] <- [originated_messages(definition)] do
+        case definition.send_pattern do
+          {:burst, count, _interval, _message} -> {name, message, count}
+          _pattern -> {name, message, 1}
+        end
+      end
+
+    cyclic? =
+      Enum.any?(topology.targets, fn {name, targets} ->
+        name in reach(targets, topology.targets)
+      end)
+
+    if sources == [] or cyclic?,
+      do: files,
+      else: [{"bench_test.go", generate_bench_file(simulated, topology, sources)} | files]
+  end
+
+  defp add_main_file(
+         files,
+         project_name,
+         package,
+         module,
+         seed,
+         metrics_addr,
+         trace_sample,
+         features
+       ) do
+    library = if package != "main", do: {package, module}
+    partitions? = Keyword.fetch!(features, :partitions) != []
+    crashes? = Keyword.fetch!(features, :crashes) != []
+    logs? = Keyword.fetch!(features, :logs) != nil
+
+    content =
+      generate_main(
+        project_name,
+        library,
+        seed,
+        metrics_addr,
+        trace_sample,
+        partitions?,
+        crashes?,
+        logs?
+      )
+
+    [{main_path(project_name, package), content} | files]
+  end
+
+  # A library leaves main.go to a command of its own
+  defp main_path(_project_name, "main"), do: "main.go"
+  defp main_path(project_name, _package), do: "cmd/#{project_name}/main.go"
+
+  # Go files are generated in package main; a library renames their
+  # package clause, apart from the command's
+  defp in_package(files, "main"), do: files
+
+  defp in_package(files, package) do
+    Enum.map(files, fn {name, content} ->
+      if String.ends_with?(name, ".go") and not String.starts_with?(name, "cmd/") do
+        {name, String.replace(content, "\npackage main\n", "\npackage #{package}\n")}
+      else
+        {name, content}
+      end
+    end)
+  end
+
+  # Imports a library by its package name, naming it when the module path
+  # ends differently
+  defp import_spec(package, module) do
+    if Path.basename(module) == package,
+      do: go_string(module),
+      else: "#{package} #{go_string(module)}"
+  end
+
+  defp add_simtest_file(files) do
+    [{"simtest/simtest.go", generate_simtest_file()} | files]
+  end
+
+  defp add_sweep_file(files) do
+    [{"sweep.go", generate_sweep_file()} | files]
+  end
+
+  defp add_fork_file(files) do
+    [{"fork.go", generate_fork_file()} | files]
+  end
+
+  defp add_debugger_file(files) do
+    [{"debugger.go", generate_debugger_file()} | files]
+  end
+
+  defp add_diff_file(files) do
+    [{"diff.go", generate_diff_file()} | files]
+  end
+
+  defp add_codec_file(files) do
+    [{"codec.go", generate_codec_file()} | files]
+  end
+
+  defp add_report_json_file(files, actors, topology) do
+    [{"reportjson.go", generate_report_json_file(actors, topology)} | files]
+  end
+
+  defp add_reconfigure_file(files, actors) do
+    [{"reconfigure.go", generate_reconfigure_file(actors)} | files]
+  end
+
+  defp add_topology_file(files, actors, topology) do
+    [{"topology.go", generate_topology_file(actors, topology)} | files]
+  end
+
+  defp add_trace_sink_file(files) do
+    [{"tracesink.go", generate_trace_sink_file()} | files]
+  end
+
+  defp add_conservation_file(files, actors) do
+    [{"conservation.go", generate_conservation_file(actors)} | files]
+  end
+
+  defp add_trace_file(files) do
+    [{"trace.go", generate_trace_file()} | files]
+  end
+
+  defp add_id_file(files) do
+    [{"id.go", generate_id_file()} | files]
+  end
+
+  defp add_envelope_file(files) do
+    [{"envelope.go", generate_envelope_file()} | files]
+  end
+
+  # Only callbacks can sleep
+  defp add_sleep_file(files, true), do: [{"sleep.go", generate_sleep_file()} | files]
+  defp add_sleep_file(files, false), do: files
+
+  defp add_log_file(files, true), do: [{"log.go", generate_log_file()} | files]
+  defp add_log_file(files, false), do: files
+
+  defp add_test_file(
+         files,
+         actors,
+         topology,
+         module,
+         trace_sample,
+         enable_callbacks,
+         interfaces
+       ) do
+    content =
+      generate_test_file(
+        actors,
+        topology,
+        module,
+        trace_sample,
+        enable_callbacks,
+        interfaces
+      )
+
+    [{"actor_test.go", content} | files]
+  end
+
+  defp add_go_mod(files, module, go_version) do
+    content = generate_go_mod(module, go_version)
+    [{"go.mod", content} | files]
+  end
+
+  defp add_ci_pipeline(files, project_name, package) do
+    content = generate_ci_pipeline(project_name, package)
+    [{".github/workflows/ci.yml", content} | files]
+  end
+
+  defp add_readme(files, project_name, package, module) do
+    content = generate_readme(project_name, package, module)
+    [{"README.md", content} | files]
+  end
+
+  defp generate_actor_file(
+         name,
+         definition,
+         messages,
+         targets,
+         enable_callbacks,
+         interfaces,
+         acked?,
+         roles,
+         types
+       ) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    # Replies end at the actor that takes them
+    outgoing = outgoing_messages(definition, messages) -- roles.answers
+
+    callback_interface =
+      if enable_callbacks do
+        generate_callback_interface(name, definition, messages, types)
+      else
+        ""
+      end
+
+    target_interface = generate_target_interface(name, outgoing, targets)
+    actor_interface = if interfaces, do: generate_actor_interface(name, messages), else: ""
+
+    callback_field =
+      if enable_callbacks do
+        """
+        \tcallbacks #{type_name}Callbacks
+        \tctx Context
+        """
+      else
+        ""
+      end
+
+    callback_init =
+      if enable_callbacks do
+        """
+        \ta.ctx.clock = a.sys.clock
+        \ta.ctx.logs = &a.sys.logs
+        \ta.callbacks = &Default#{type_name}Callbacks{Ctx: &a.ctx}
+        """
+      else
+        ""
+      end
+
+    target_fields = generate_target_fields(name, definition, targets)
+    counter_fields = generate_counter_fields(definition, targets)
+    queue_fields = generate_queue_fields(definition, messages)
+    join_field = if definition.join_by, do: "\tjoin *joinWindow\n", else: ""
+    observe_fields =
+      if observe(definition), do: "\treceived chan Msg\n\tunrecorded int\n", else: ""
+    alarm_field = if alarm(definition), do: "\talarm rateAlarm\n", else: ""
+    timer_field =
+      if(timers(definition) != [], do: "\tfired map[string]int\n", else: "") <>
+        if(one_shot?(definition), do: "\toneShot Timer\n\toneShotSeq uint64\n", else: "")
+
+    idle_fields =
+      if idle_timeout(definition),
+        do: "\tidleTimer Timer\n\tidleSeq uint64\n\tidleCount int\n",
+        else: ""
+
+    shard_fields = if parallelism(definition), do: "\tshards []*#{type_name}\n\tnext int\n", else: ""
+
+    reply_fields =
+      if roles.asks != [],
+        do: "\tasked map[MessageID]time.Duration\n\treplyTime latencies\n",
+        else: ""
+    timer_setup = generate_timer_setup(definition)
+
+    shard_start =
+      if parallelism(definition),
+        do: "\tfor _, shard := range a.shards {\n\t\tshard.Start()\n\t}\n",
+        else: ""
+
+    schedule_start = generate_schedule_start(name, definition)
+    alarm_start = generate_alarm_start(definition)
+    timers_start = generate_timers_start(definition)
+    idle_start = if idle_timeout(definition), do: "\ta.resetIdle()\n", else: ""
+
+    {payload_field, payload_start} =
+      if payload_draws?(definition) do
+        {"\tpayloadDraw func() float64\n",
+         "\ta.payloadDraw = a.sys.Stream(\"payload/#{name}\")\n"}
+      else
+        {"", ""}
+      end
+    schedule_methods = generate_schedule_methods(name, definition, messages)
+
+    restart_method =
+      if enable_callbacks and crash_blocker(definition) == nil do
+        """
+        // restart replaces the callbacks a crash lost with fresh ones, restored
+        // from the last state they persisted, if any
+        func (a *#{type_name}) restart() {
+        \ta.ctx.sleep = 0
+        \ta.callbacks = &Default#{type_name}Callbacks{Ctx: &a.ctx}
+        \tif r, ok := a.callbacks.(Restorer); ok && a.ctx.persisted != nil {
+        \t\tr.Restore(a.ctx.persisted)
+        \t}
+        }
+
+        """
+      else
+        ""
+      end
+    loss_methods = generate_loss_methods(name, definition, targets)
+    dead_letter_methods = generate_dead_letter_methods(name, definition, targets)
+    timeout_methods = generate_timeout_methods(name, definition, targets)
+    delivery_methods = generate_delivery_methods(name, definition, targets)
+    routing_methods =
+      generate_routing_methods(name, definition, targets) <>
+        generate_affinity_methods(name, definition, targets) <>
+        generate_transform_methods(name, definition)
+    size_methods = generate_size_methods(name, definition, targets)
+    ack_methods = generate_ack_methods(name, definition) <> generate_reply_methods(name, roles)
+    idempotency_methods = generate_idempotency_methods(name, definition)
+    probe_methods =
+      generate_probe_methods(name, definition) <> generate_reorder_methods(name, definition)
+
+    label_pairs =
+      Enum.map_join(labels(definition), ", ", fn {key, value} ->
+        "#{go_string(key)}: #{go_string(value)}"
+      end)
+
+    queue_methods = generate_queue_methods(name, definition, messages)
+    join_methods = generate_join_methods(name, definition, outgoing, targets)
+    observe_methods = generate_observe_methods(name, definition)
+    alarm_methods = generate_alarm_methods(name, definition, targets, enable_callbacks)
+    timer_methods =
+      generate_timer_methods(name, definition, messages, enable_callbacks) <>
+        generate_one_shot_methods(name, definition, messages) <>
+        generate_payload_methods(name, definition, types) <>
+        generate_idle_methods(name, definition, enable_callbacks) <>
+        generate_kafka_methods(name, definition)
+    shard_methods = generate_shard_methods(name, definition)
+    edge_methods = generate_edge_methods(name, definition, targets)
+    handler_method = generate_handler_method(name, messages)
+    message_handlers =
+      generate_message_handlers(
+        name,
+        definition,
+        messages,
+        targets,
+        enable_callbacks,
+        acked?,
+        roles,
+        types
+      )
+
+    # Determine which imports are needed
+    reliable? = at_least_once?(definition) and targets != []
+
+    needs_time =
+      definition.send_pattern != nil or definition.fair_queue != nil or
+        (definition.timeout != nil and targets != []) or reliable? or
+        (dlq_retry(definition) != nil and targets != []) or alarm(definition) != nil or
+        ack_path(definition) != nil or timers(definition) != [] or
+        idle_timeout(definition) != nil or roles.asks != []
+
+    # A schedule is embedded in the actor and parsed when it starts
+    replays? = schedule_start != ""
+
+    import_list =
+      [
+        {replays?, "_ \"embed\""},
+        {replays?, "\"fmt\""},
+        {true, "\"github.com/Arceliar/phony\""},
+        {replays?, "\"strings\""},
+        {reliable?, "\"sync/atomic\""},
+        {needs_time, "\"time\""}
+      ]
+      |> Enum.filter(&elem(&1, 0))
+      |> Enum.map_join("\n", &"\t#{elem(&1, 1)}")
+
+    """
+    // Generated from ActorSimulation DSL
+    // Actor: #{name}
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    #{import_list}
+    )
+
+    #{callback_interface}#{target_interface}#{actor_interface}
+    type #{type_name} struct {
+    \tphony.Inbox
+    \tsys *System
+    \tmessageContext
+    #{target_fields}#{callback_field}#{counter_fields}#{queue_fields}#{join_field}#{observe_fields}#{alarm_field}#{timer_field}#{idle_fields}#{shard_fields}#{reply_fields}#{payload_field}}
+
+    func (a *#{type_name}) Actor() *phony.Inbox {
+    \treturn &a.Inbox
+    }
+
+    func (a *#{type_name}) Start() {
+    #{callback_init}#{payload_start}#{timer_setup}#{alarm_start}#{timers_start}#{idle_start}#{schedule_start}#{shard_start}}
+
+    // Labels returns the labels attached to this actor in the DSL
+    func (a *#{type_name}) Labels() map[string]string {
+    \treturn map[string]string{#{label_pairs}}
+    }
+
+    // SendCount returns the number of copies this actor put on its edges: a
+    // message counts once for each target it goes to, but not for one an
+    // edge loses on the way
+    // Safe to call from outside the actor
+    func (a *#{type_name}) SendCount() int {
+    \tvar n int
+    #{read_counter(definition, type_name, "a.copiesSent")}
+    \treturn n
+    }
+
+    // Metrics returns what this actor has sent, counted like SendCount, and
+    // received so far, as the Sent and Received of its report row
+    // Safe to call from outside the actor
+    func (a *#{type_name}) Metrics() ActorMetrics {
+    \tm := ActorMetrics{SendCount: a.SendCount()}
+    \tphony.Block(a, func() { m.RecvCount = a.delivered })
+    \treturn m
+    }
+
+    // QueueTimeStats sums up how long the messages this actor handled waited
+    // in its inbox or fair queue before it started on them
+    // Safe to call from outside the actor
+    func (a *#{type_name}) QueueTimeStats() TimeStats {
+    \tvar l latencies
+    \t#{on_inboxes(definition, type_name)}
+    \t\tl.merge(&a.queueTime)
+    \t})
+    \treturn l.stats()
+    }
+
+    // ServiceTimeStats sums up how long this actor took over the messages it
+    // handled, from starting on one to finishing it
+    // Safe to call from outside the actor
+    func (a *#{type_name}) ServiceTimeStats() TimeStats {
+    \tvar l latencies
+    \t#{on_inboxes(definition, type_name)}
+    \t\tl.merge(&a.serviceTime)
+    \t})
+    \treturn l.stats()
+    }
+
+    #{restart_method}#{schedule_methods}#{loss_methods}#{dead_letter_methods}#{timeout_methods}#{delivery_methods}#{routing_methods}#{size_methods}#{ack_methods}#{idempotency_methods}#{probe_methods}#{queue_methods}#{join_methods}#{observe_methods}#{alarm_methods}#{timer_methods}#{shard_methods}#{edge_methods}#{handler_method}#{message_handlers}
+    """
+  end
+
+  defp generate_callback_interface(name, definition, messages, types) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    alarm_method =
+      if alarm(definition), do: ["\tOnAlarm(metric string, value float64) error"], else: []
+
+    timer_methods =
+      Enum.map(timers(definition), fn {timer, _ms} ->
+        "\tOn#{GeneratorUtils.to_pascal_case(timer)}() error"
+      end)
+
+    idle_method = if idle_timeout(definition), do: ["\tOnIdle() error"], else: []
+
+    transform_methods =
+      Enum.map(transform_steps(definition), fn
+        {:filter, _name} = step -> "\t#{transform_method(step)}(m EdgeMessage) bool"
+        {:map, _name} = step -> "\t#{transform_method(step)}(m EdgeMessage) any"
+      end)
+
+    methods =
+      messages
+      |> Enum.map(fn msg ->
+        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+        result = callback_result(definition, msg, types)
+        "\tOn#{msg_name}(#{callback_param(msg, types)}) #{result}"
+      end)
+      |> Kernel.++(alarm_method)
+      |> Kernel.++(timer_methods)
+      |> Kernel.++(idle_method)
+      |> Kernel.++(transform_methods)
+      |> Enum.join("\n")
+
+    """
+    // #{type_name}Callbacks defines the callback interface
+    // Implement this interface to customize actor behavior; an error a
+    // callback returns halts the system, and RunUntil returns it
+    type #{type_name}Callbacks interface {
+    #{methods}
+    }
+
+    """
+  end
+
+  # Every actor a sender is wired to handles all messages the sender forwards,
+  # so the targets of one actor share a single interface.
+  defp generate_target_interface(_name, _messages, []), do: ""
+
+  defp generate_target_interface(name, messages, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    methods =
+      Enum.map_join(messages, "\n", fn msg ->
+        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+        "\t#{msg_name}()"
+      end)
+
+    """
+    // #{type_name}Target is implemented by every actor #{type_name} sends to
+    type #{type_name}Target interface {
+    \tphony.Actor
+    #{methods}
+    }
+
+    """
+  end
+
+  # A stand-in for an actor, such as a test mock, embeds phony.Inbox and
+  # messageContext to get the system's message headers
+  defp generate_actor_interface(name, messages) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    methods =
+      Enum.map_join(messages, "\n", fn msg ->
+        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+        "\t#{msg_name}()"
+      end)
+
+    """
+    // #{type_name}Iface is the interface of every message #{type_name} handles
+    // Depend on it instead of *#{type_name} to substitute a stand-in, such as a
+    // test mock embedding phony.Inbox and messageContext
+    type #{type_name}Iface interface {
+    \tphony.Actor
+    \tcontextual
+    #{methods}
+    }
+
+    var _ #{type_name}Iface = (*#{type_name})(nil)
+
+    """
+  end
+
+  defp generate_target_fields(_name, _definition, []), do: ""
+
+  defp generate_target_fields(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    loss_field =
+      if definition.loss do
+        """
+        \tloss []*GilbertElliott
+        """
+      else
+        ""
+      end
+
+    delay_field =
+      if definition.delay do
+        """
+        \tdelay []Delay
+        """
+      else
+        ""
+      end
+
+    fallback_fields =
+      if definition.timeout do
+        """
+        \tfallback #{type_name}Target
+        \tpending map[uint64]Timer
+        """
+      else
+        ""
+      end
+
+    breaker_field = if circuit_breaker(definition), do: "\tbreakers []*Breaker\n", else: ""
+
+    delivery_fields =
+      if at_least_once?(definition) do
+        """
+        \tretry []time.Duration
+        \tunacked map[uint64]Timer
+        """
+      else
+        ""
+      end
+
+    router_field =
+      cond do
+        routing(definition) != :broadcast -> "\trouter router\n"
+        affinity(definition) -> "\taffinity affinity\n"
+        true -> ""
+      end
+
+    bytes_field = if size(definition), do: "\tbytes links\n", else: ""
+
+    "\ttargets []#{type_name}Target\n" <>
+      loss_field <> delay_field <> fallback_fields <> breaker_field <> delivery_fields <>
+      router_field <> bytes_field
+  end
+
+  defp generate_counter_fields(definition, targets) do
+    lost_field =
+      if definition.loss && targets != [] do
+        """
+        \tlostCount int
+        """
+      else
+        ""
+      end
+
+    timeout_fields =
+      if definition.timeout && targets != [] do
+        """
+        \trequestCount uint64
+        \ttimeoutCount int
+        """
+      else
+        ""
+      end
+
+    breaker_fields =
+      if circuit_breaker(definition) && targets != [], do: "\tshortCircuitCount int\n", else: ""
+
+    dead_letter_fields =
+      if dlq_retry(definition) && targets != [] do
+        """
+        \tdead deadLetters
+        \treaping bool
+        """
+      else
+        ""
+      end
+
+    delivery_fields =
+      if at_least_once?(definition) && targets != [] do
+        """
+        \tdeliverySeq uint64
+        \tretransmitCount int
+        \tduplicateCount atomic.Int64
+        """
+      else
+        ""
+      end
+
+    ack_fields =
+      if ack_path(definition),
+        do: "\toutstanding map[MessageID]time.Duration\n\tackCount int\n",
+        else: ""
+
+    idempotency_fields =
+      if idempotency(definition),
+        do: "\tseen map[MessageID]bool\n\tappliedCount int\n\tdedupedCount int\n",
+        else: ""
+
+    probe_field = if probe?(definition), do: "\tprobe probeLog\n", else: ""
+    reorder_field = if reorder(definition), do: "\treorder reorderBuffer\n", else: ""
+    filter_field = if transforms(definition) != [], do: "\tfilteredCount int\n", else: ""
+
+    "\tcopiesSent int\n" <>
+      lost_field <> timeout_fields <> breaker_fields <> delivery_fields <> dead_letter_fields <>
+      ack_fields <> idempotency_fields <> probe_field <> reorder_field <> filter_field
+  end
+
+  defp generate_queue_fields(%{fair_queue: nil}, _messages), do: ""
+
+  defp generate_queue_fields(definition, messages) do
+    conflated = if conflation(definition), do: "\tconflatedCount int\n", else: ""
+    shed = if deadline_aware?(definition), do: "\tshedCount int\n", else: ""
+
+    bounded =
+      case mailbox(definition) do
+        nil -> ""
+        {_capacity, :block} -> "\tblocked []func()\n\tdropCount int\n"
+        {_capacity, _drop} -> "\tdropCount int\n"
+      end
+
+    """
+    \tqueue *FairQueue
+    \tbusy bool
+    \tprocessed [#{length(messages)}]int
+    """ <> conflated <> shed <> bounded
+  end
+
+  defp generate_queue_methods(_name, %{fair_queue: nil}, _messages), do: ""
+
+  defp generate_queue_methods(name, definition, messages) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    # Counts add up over the inboxes of a sharded actor
+    op = if parallelism(definition), do: "+=", else: "="
+
+    counts =
+      messages
+      |> Enum.with_index()
+      |> Enum.map_join(fn {msg, index} ->
+        "\t\tcounts[\"#{GeneratorUtils.message_name(msg)}\"] #{op} a.processed[#{index}]\n"
+      end)
+
+    conflated_method =
+      if conflation(definition) do
+        """
+        // ConflatedCount returns the number of queued messages replaced by a
+        // newer one of the same key before they were processed
+        // Safe to call from outside the actor
+        func (a *#{type_name}) ConflatedCount() int {
+        \tvar n int
+        #{read_counter(definition, type_name, "a.conflatedCount")}
+        \treturn n
+        }
+
+        """
+      else
+        ""
+      end
+
+    {shed_method, shed} =
+      if deadline_aware?(definition) do
+        {"""
+         // ShedCount returns the number of queued messages dropped because
+         // their deadline had passed by the time they were next in line
+         // Safe to call from outside the actor
+         func (a *#{type_name}) ShedCount() int {
+         \tvar n int
+         #{read_counter(definition, type_name, "a.shedCount")}
+         \treturn n
+         }
+
+         """,
+         """
+         \tif n := a.queue.Shed(a.sys.clock.Now()); n > 0 {
+         \t\ta.shedCount += n
+         \t\ta.sys.ledger.dropped.Add(int64(n))
+         \t}
+         """}
+      else
+        {"", ""}
+      end
+
+    dropped_method =
+      case mailbox(definition) do
+        nil ->
+          ""
+
+        {_capacity, overflow} ->
+          full =
+            if overflow == :block,
+              do: "found the mailbox full and as many\n// messages waiting for room as it holds",
+              else: "found the mailbox full"
+
+          """
+          // DropCount returns the number of messages dropped because they
+          // #{full}
+          // Safe to call from outside the actor
+          func (a *#{type_name}) DropCount() int {
+          \tvar n int
+          #{read_counter(definition, type_name, "a.dropCount")}
+          \treturn n
+          }
+
+          """
+      end
+
+    {blocked, unblock, unblock_method} =
+      case mailbox(definition) do
+        {_capacity, :block} ->
+          {"\t\tn += len(a.blocked)\n", "\ta.unblock()\n",
+           """
+           // unblock lets in the message that has waited longest for room in
+           // the full mailbox, now that the next one has left it for service
+           func (a *#{type_name}) unblock() {
+           \tif len(a.blocked) == 0 {
+           \t\treturn
+           \t}
+           \tf := a.blocked[0]
+           \ta.blocked[0] = nil
+           \ta.blocked = a.blocked[1:]
+           \tf()
+           }
+
+           """}
+
+        _no_block ->
+          {"", "", ""}
+      end
+
+    pop =
+      case priority_aging(definition) do
+        nil -> "a.queue.Pop()"
+        {step, every} ->
+          "a.queue.PopAged(a.sys.clock.Now(), #{step}, #{every} * time.Millisecond)"
+      end
+
+    counter_methods = conflated_method <> shed_method <> dropped_method
+
+    """
+    #{counter_methods}// ProcessedCounts returns how many messages of each kind were processed
+    // Safe to call from outside the actor
+    func (a *#{type_name}) ProcessedCounts() map[string]int {
+    \tcounts := map[string]int{}
+    \t#{on_inboxes(definition, type_name)}
+    #{counts}\t})
+    \treturn counts
+    }
+
+    // queued returns the number of messages waiting for or in service
+    // Safe to call from outside the actor
+    func (a *#{type_name}) queued() int {
+    \tvar n int
+    \t#{on_inboxes(definition, type_name)}
+    \t\tn #{op} a.queue.Len()
+    #{blocked}\t\tif a.busy {
+    \t\t\tn++
+    \t\t}
+    \t})
+    \treturn n
+    }
+
+    // serveNext processes the next queued message once the previous one
+    // has taken its service time
+    func (a *#{type_name}) serveNext() {
+    \tif a.busy {
+    \t\treturn
+    \t}
+    #{shed}\tclass, f, ok := #{pop}
+    \tif !ok {
+    \t\treturn
+    \t}
+    \ta.busy = true
+    #{unblock}\tstart := a.sys.clock.Now()
+    \ta.sys.after(a, #{definition.service_time || 0} * time.Millisecond, func() {
+    \t\tf()
+    \t\ta.handled(a.header.enqueued, start, a.sys.clock.Now())
+    \t\ta.processed[class]++
+    \t\ta.busy = false
+    \t\ta.serveNext()
+    \t})
+    }
+
+    #{unblock_method}"""
+  end
+
+  defp generate_join_methods(_name, %{join_by: nil}, _outgoing, _targets), do: ""
+
+  defp generate_join_methods(name, definition, [emit], targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    emit_name = GeneratorUtils.message_name(emit) |> GeneratorUtils.to_pascal_case()
+
+    """
+    // JoinedCount returns the number of message pairs joined within the window
+    // Safe to call from outside the actor
+    func (a *#{type_name}) JoinedCount() int {
+    \tvar n int
+    \tphony.Block(a, func() { n = a.join.joined })
+    \treturn n
+    }
+
+    // ExpiredCount returns the number of messages dropped unmatched when
+    // their window closed
+    // Safe to call from outside the actor
+    func (a *#{type_name}) ExpiredCount() int {
+    \tvar n int
+    \tphony.Block(a, func() { n = a.join.expired })
+    \treturn n
+    }
+
+    // queued returns the number of messages waiting for their match
+    // Safe to call from outside the actor
+    func (a *#{type_name}) queued() int {
+    \tvar n int
+    \tphony.Block(a, func() { n = a.join.buffered() })
+    \treturn n
+    }
+
+    // forwardJoined sends a joined pair on as one #{GeneratorUtils.message_name(emit)} message
+    func (a *#{type_name}) forwardJoined() {
+    #{generate_forward(emit_name, definition, targets)}}
+
+    """
+  end
+
+  defp generate_alarm_start(definition) do
+    case alarm(definition) do
+      nil ->
+        ""
+
+      alarm ->
+        thresholds =
+          Enum.map_join([:above, :below], fn key ->
+            if alarm[key], do: ", #{key}: #{alarm[key]}", else: ""
+          end)
+
+        """
+        \ta.alarm = rateAlarm{window: #{alarm.window} * time.Millisecond#{thresholds}}
+        \ta.sys.watch(a, a.alarm.window, a.checkAlarm)
+        """
+    end
+  end
+
+  defp generate_alarm_methods(name, definition, targets, enable_callbacks) do
+    case alarm(definition) do
+      nil ->
+        ""
+
+      _alarm when targets == [] ->
+        raise ArgumentError, "actor #{inspect(name)} has an alarm on its send rate but no targets"
+
+      _alarm ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        raise_alarm =
+          if enable_callbacks do
+            """
+            \tif alarm, ok := a.alarm.check(a.copiesSent, a.sys.clock.Now()); ok {
+            \t\tif err := a.callbacks.OnAlarm(alarm.Metric, alarm.Value); err != nil {
+            \t\t\ta.sys.halt("#{name}", err)
+            \t\t}
+            \t}
+            """
+          else
+            "\ta.alarm.check(a.copiesSent, a.sys.clock.Now())\n"
+          end
+
+        """
+        // Alarms returns the alarms this actor raised, in the order it raised
+        // them
+        // Safe to call from outside the actor
+        func (a *#{type_name}) Alarms() []Alarm {
+        \tvar alarms []Alarm
+        \tphony.Block(a, func() { alarms = append([]Alarm(nil), a.alarm.raised...) })
+        \treturn alarms
+        }
+
+        // checkAlarm checks the send rate over the window that just closed
+        func (a *#{type_name}) checkAlarm() {
+        #{raise_alarm}}
+
+        """
+    end
+  end
+
+  defp generate_timers_start(definition) do
+    case timers(definition) do
+      [] ->
+        ""
+
+      timers ->
+        watches =
+          Enum.map_join(timers, fn {timer, ms} ->
+            "\ta.sys.watch(a, #{ms} * time.Millisecond, a.#{GeneratorUtils.to_pascal_case(timer)})\n"
+          end)
+
+        "\ta.fired = map[string]int{}\n" <> watches
+    end
+  end
+
+  # One method per timer, named after it, so a timer can't share its name
+  # with a message the actor handles
+  defp generate_timer_methods(name, definition, messages, enable_callbacks) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    handlers =
+      Enum.map(messages, &(GeneratorUtils.message_name(&1) |> GeneratorUtils.to_pascal_case()))
+
+    case timers(definition) do
+      [] ->
+        ""
+
+      timers ->
+        fire_methods =
+          Enum.map_join(timers, "\n", fn {timer, ms} ->
+            method = GeneratorUtils.to_pascal_case(timer)
+
+            if method in handlers do
+              raise ArgumentError,
+                    "actor #{inspect(name)} has a timer #{inspect(timer)} " <>
+                      "named like a message it handles"
+            end
+
+            callback =
+              if enable_callbacks do
+                """
+                \tif err := a.callbacks.On#{method}(); err != nil {
+                \t\ta.sys.halt("#{name}", err)
+                \t}
+                \t// A timer holds back no message for its callback to sleep on
+                \ta.ctx.sleep = 0
+                """
+              else
+                ""
+              end
+
+            """
+            // #{method} fires the #{timer} timer, every #{ms}ms, unless the actor is
+            // down after a crash
+            func (a *#{type_name}) #{method}() {
+            \tif a.down {
+            \t\treturn
+            \t}
+            \ta.fired["#{timer}"]++
+            #{callback}}
+            """
+          end)
+
+        """
+        // TimerCount returns how many times the named timer has fired
+        // Safe to call from outside the actor
+        func (a *#{type_name}) TimerCount(name string) int {
+        \tvar n int
+        \tphony.Block(a, func() { n = a.fired[name] })
+        \treturn n
+        }
+
+        #{fire_methods}
+        """
+    end
+  end
+
+  # A reset leaves a timer that already fired behind, so each arming gets a
+  # sequence number and only the latest one fires
+  defp one_shot?(definition),
+    do: match?({:self_message, _delay, _message}, definition.send_pattern)
+
+  # A one-shot send pattern can be armed afresh or called off from outside,
+  # so the actor can't handle a message or run a timer named like either
+  defp generate_one_shot_methods(name, definition, messages) do
+    case definition.send_pattern do
+      {:self_message, delay_ms, message} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+        field = GeneratorUtils.to_camel_case(name)
+        msg_name = originated_handler(definition, message)
+
+        taken =
+          Enum.map(
+            messages ++ Keyword.keys(timers(definition)),
+            &(GeneratorUtils.message_name(&1) |> GeneratorUtils.to_pascal_case())
+          )
+
+        if "Reset" in taken or "Cancel" in taken do
+          raise ArgumentError,
+                "actor #{inspect(name)} sends one-shot, so it can't have a message " <>
+                  "or timer named :reset or :cancel"
+        end
+
+        """
+        // Reset arms the one-shot #{message} afresh, for #{delay_ms}ms from now, in
+        // place of one still pending, so it fires again even once it has fired
+        // Safe to call from outside the actor
+        func (a *#{type_name}) Reset() {
+        \tphony.Block(a, func() { a.armOneShot(#{delay_ms} * time.Millisecond) })
+        \ta.sys.record(func(s *System) { s.#{field}.Reset() })
+        }
+
+        // Cancel calls off the one-shot #{message} and reports whether it was
+        // still to come
+        // Safe to call from outside the actor
+        func (a *#{type_name}) Cancel() bool {
+        \tvar pending bool
+        \tphony.Block(a, func() {
+        \t\tpending = a.oneShot != nil
+        \t\tif pending {
+        \t\t\ta.oneShot.Stop()
+        \t\t}
+        \t\ta.oneShot = nil
+        \t\ta.oneShotSeq++
+        \t})
+        \ta.sys.record(func(s *System) { s.#{field}.Cancel() })
+        \treturn pending
+        }
+
+        // armOneShot schedules the one-shot #{message} d from now, stopping one
+        // still pending; one that fell due but hasn't run yet sends nothing
+        func (a *#{type_name}) armOneShot(d time.Duration) {
+        \tif a.oneShot != nil {
+        \t\ta.oneShot.Stop()
+        \t}
+        \ta.oneShotSeq++
+        \tseq := a.oneShotSeq
+        \ta.oneShot = a.sys.after(a, d, func() {
+        \t\tif seq == a.oneShotSeq {
+        \t\t\ta.oneShot = nil
+        \t\t\ta.sys.produce(a, "#{name}", a.#{msg_name})
+        \t\t}
+        \t})
+        }
+
+        """
+
+      _ ->
+        ""
+    end
+  end
+
+  defp generate_payload_methods(name, definition, types) do
+    case payload(definition, types) do
+      nil ->
+        ""
+
+      %{kind: kind, fields: fields} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+        msg_name = GeneratorUtils.to_pascal_case(kind)
+        values = Enum.map_join(fields, ", ", fn {field, expr} -> "#{field}: #{expr}" end)
+
+        """
+        // originate#{msg_name} fills in the payload of a #{kind} this actor
+        // originates, from its sequence number and draws of the seeded payload
+        // stream, and handles it
+        func (a *#{type_name}) originate#{msg_name}() {
+        \ta.header.payload = #{msg_name}{#{values}}
+        \ta.#{msg_name}()
+        }
+
+        """
+    end
+  end
+
+  defp generate_idle_methods(name, definition, enable_callbacks) do
+    case idle_timeout(definition) do
+      nil ->
+        ""
+
+      ms ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        callback =
+          if enable_callbacks do
+            """
+            \tif err := a.callbacks.OnIdle(); err != nil {
+            \t\ta.sys.halt("#{name}", err)
+            \t}
+            \t// An idle timeout holds back no message for its callback to sleep on
+            \ta.ctx.sleep = 0
+            """
+          else
+            ""
+          end
+
+        """
+        // IdleCount returns how many times the idle timeout has fired
+        // Safe to call from outside the actor
+        func (a *#{type_name}) IdleCount() int {
+        \tvar n int
+        \tphony.Block(a, func() { n = a.idleCount })
+        \treturn n
+        }
+
+        // resetIdle arms the idle timeout afresh, for #{ms}ms from now
+        func (a *#{type_name}) resetIdle() {
+        \tif a.idleTimer != nil {
+        \t\ta.idleTimer.Stop()
+        \t}
+        \ta.idleSeq++
+        \tseq := a.idleSeq
+        \ta.idleTimer = a.sys.schedule(a, #{ms} * time.Millisecond, func() {
+        \t\ta.sys.run(a, func() {
+        \t\t\tif seq == a.idleSeq {
+        \t\t\t\ta.fireIdle()
+        \t\t\t}
+        \t\t})
+        \t})
+        }
+
+        // fireIdle fires the idle timeout once the actor received nothing for
+        // #{ms}ms, and again every #{ms}ms it stays idle, unless it is down
+        // after a crash
+        func (a *#{type_name}) fireIdle() {
+        \ta.resetIdle()
+        \tif a.down {
+        \t\treturn
+        \t}
+        \ta.idleCount++
+        #{callback}}
+
+        """
+    end
+  end
+
+  defp generate_kafka_methods(name, definition) do
+    case kafka_source(definition) do
+      nil ->
+        ""
+
+      %{topic: topic, message: message} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+        handler = GeneratorUtils.message_name(message) |> GeneratorUtils.to_pascal_case()
+
+        """
+        // ingestKafka originates a message from a record consumed from
+        // #{topic}, with the record's value as its payload
+        // Safe to call from outside the actor
+        func (a *#{type_name}) ingestKafka(r KafkaRecord) {
+        \ta.sys.run(a, func() {
+        \t\ta.sys.produce(a, "#{name}", func() {
+        \t\t\ta.header.payload = r.Value
+        \t\t\ta.#{handler}()
+        \t\t})
+        \t})
+        }
+
+        """
+    end
+  end
+
+  defp generate_observe_methods(name, definition) do
+    case observe(definition) do
+      nil ->
+        ""
+
+      capacity ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        """
+        // Received returns the messages this actor received, in the order it
+        // handled them, buffered up to #{capacity}
+        // Messages arriving while the buffer is full are not recorded;
+        // Unrecorded counts them
+        func (a *#{type_name}) Received() <-chan Msg {
+        \treturn a.received
+        }
+
+        // Unrecorded returns the number of messages left out of Received
+        // Safe to call from outside the actor
+        func (a *#{type_name}) Unrecorded() int {
+        \tvar n int
+        #{read_counter(definition, type_name, "a.unrecorded")}
+        \treturn n
+        }
+
+        // record hands the message being handled to Received without blocking
+        func (a *#{type_name}) record(kind string) {
+        \tselect {
+        \tcase a.received <- Msg{Kind: kind, ID: a.header.id, Key: a.header.key, At: a.sys.clock.Now()}:
+        \tdefault:
+        \t\ta.unrecorded++
+        \t}
+        }
+
+        """
+    end
+  end
+
+  defp generate_shard_methods(name, definition) do
+    case parallelism(definition) do
+      nil ->
+        ""
+
+      _inboxes ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        """
+        // nextShard returns the inbox the next message goes to, taking each
+        // in turn
+        func (a *#{type_name}) nextShard() *#{type_name} {
+        \tshard := a.shards[a.next]
+        \ta.next = (a.next + 1) % len(a.shards)
+        \treturn shard
+        }
+
+        // eachShard runs f on each of the actor's inboxes in turn, so counters
+        // read through it add up over all of them
+        // Safe to call from outside the actor
+        func (a *#{type_name}) eachShard(f func(a *#{type_name})) {
+        \tfor _, shard := range a.shards {
+        \t\tphony.Block(shard, func() { f(shard) })
+        \t}
+        }
+
+        // inboxes returns the contexts of the actor's inboxes, whose queues
+        // the report takes into account
+        func (a *#{type_name}) inboxes() []*messageContext {
+        \tcontexts := make([]*messageContext, len(a.shards))
+        \tfor i, shard := range a.shards {
+        \t\tcontexts[i] = shard.context()
+        \t}
+        \treturn contexts
+        }
+
+        """
+    end
+  end
+
+  # Reads a counter on the actor's inbox, or adds it up over the inboxes of
+  # an actor spread over several
+  defp read_counter(definition, type_name, counter) do
+    case parallelism(definition) do
+      nil -> "\tphony.Block(a, func() { n = #{counter} })"
+      _inboxes -> "\ta.eachShard(func(a *#{type_name}) { n += #{counter} })"
+    end
+  end
+
+  defp on_inboxes(definition, type_name) do
+    case parallelism(definition) do
+      nil -> "phony.Block(a, func() {"
+      _inboxes -> "a.eachShard(func(a *#{type_name}) {"
+    end
+  end
+
+  defp generate_edge_methods(_name, _definition, []), do: ""
+
+  # Edges added at runtime start without loss, delay or acknowledgements
+  defp generate_edge_methods(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    {shard_connect, shard_disconnect} =
+      if parallelism(definition) do
+        {"\tfor _, shard := range a.shards {\n" <>
+           "\t\tphony.Block(shard, func() { shard.connect(to) })\n\t}\n",
+         "\tfor _, shard := range a.shards {\n" <>
+           "\t\tphony.Block(shard, func() { shard.disconnect(to) })\n\t}\n"}
+      else
+        {"", ""}
+      end
+
+    {append_models, remove_models} =
+      [
+        loss: {definition.loss, "nil"},
+        delay: {definition.delay, "ConstantDelay(0)"},
+        breakers: {circuit_breaker(definition), new_breaker(definition, "a.sys")},
+        retry: {at_least_once?(definition), "0"}
+      ]
+      |> Enum.filter(fn {_field, {model, _default}} -> model end)
+      |> Enum.map(fn {field, {_model, default}} ->
+        {"\ta.#{field} = append(a.#{field}, #{default})\n",
+         "\t\t\ta.#{field} = append(a.#{field}[:i], a.#{field}[i+1:]...)\n"}
+      end)
+      |> Enum.unzip()
+
+    """
+    // accepts reports whether the actor to handles every message #{type_name} sends
+    func (a *#{type_name}) accepts(to phony.Actor) bool {
+    \t_, ok := to.(#{type_name}Target)
+    \treturn ok
+    }
+
+    // connect adds an edge to to
+    func (a *#{type_name}) connect(to phony.Actor) {
+    \ta.targets = append(a.targets, to.(#{type_name}Target))
+    #{Enum.join(append_models)}#{shard_connect}}
+
+    // disconnect removes every edge to to
+    func (a *#{type_name}) disconnect(to phony.Actor) {
+    \tfor i := len(a.targets) - 1; i >= 0; i-- {
+    \t\tif phony.Actor(a.targets[i]) == to {
+    \t\t\ta.targets = append(a.targets[:i], a.targets[i+1:]...)
+    #{Enum.join(remove_models)}\t\t}
+    \t}
+    #{shard_disconnect}}
+
+    """
+  end
+
+  defp generate_loss_methods(_name, %{loss: nil}, _targets), do: ""
+  defp generate_loss_methods(_name, _definition, []), do: ""
+
+  defp generate_loss_methods(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+    // LostCount returns the number of messages lost on outgoing edges
+    // Safe to call from outside the actor
+    func (a *#{type_name}) LostCount() int {
+    \tvar n int
+    #{read_counter(definition, type_name, "a.lostCount")}
+    \treturn n
+    }
+
+    """
+  end
+
+  # An edge without transforms passes every message on as it is
+  defp generate_transform_methods(name, definition) do
+    case transforms(definition) do
+      [] ->
+        ""
+
+      transforms ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        cases =
+          Enum.map_join(transforms, fn {target, steps} ->
+            "\tcase a.sys.#{GeneratorUtils.to_camel_case(target)}:\n" <>
+              Enum.map_join(steps, &transform_call/1)
+          end)
+
+        """
+        // transform runs the message in hand through the transforms on its
+        // edge to target in order, starting from payload: it reports false
+        // once a filter drops the message, and otherwise leaves what the maps
+        // made of the payload in the header the message is sent under
+        func (a *#{type_name}) transform(target #{type_name}Target, kind string, payload any) bool {
+        \ta.header.payload = payload
+        \tm := EdgeMessage{Kind: kind, Key: a.header.key, Payload: payload}
+        \tswitch target {
+        #{cases}\t}
+        \ta.header.payload = m.Payload
+        \treturn true
+        }
+
+        // FilteredCount returns the number of messages the filters on this
+        // actor's edges dropped
+        // Safe to call from outside the actor
+        func (a *#{type_name}) FilteredCount() int {
+        \tvar n int
+        #{read_counter(definition, type_name, "a.filteredCount")}
+        \treturn n
+        }
+
+        """
+    end
+  end
+
+  defp transform_call({:filter, _step} = step) do
+    """
+    \t\tif !a.callbacks.#{transform_method(step)}(m) {
+    \t\t\ta.filteredCount++
+    \t\t\ta.sys.ledger.dropped.Add(1)
+    \t\t\treturn false
+    \t\t}
+    """
+  end
+
+  defp transform_call({:map, _step} = step),
+    do: "\t\tm.Payload = a.callbacks.#{transform_method(step)}(m)\n"
+
+  defp generate_routing_methods(_name, _definition, []), do: ""
+
+  defp generate_routing_methods(name, definition, _targets) do
+    if routing(definition) == :broadcast do
+      ""
+    else
+      generate_router_methods(name)
+    end
+  end
+
+  defp generate_router_methods(name) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+    // RoutedCounts returns how many messages this actor has routed to each
+    // target, by name
+    // Safe to call from outside the actor
+    func (a *#{type_name}) RoutedCounts() map[string]int {
+    \treturn a.sys.routedCounts(a, &a.router)
+    }
+
+    """
+  end
+
+  defp generate_affinity_methods(_name, %{affinity_by: nil}, _targets), do: ""
+  defp generate_affinity_methods(_name, _definition, []), do: ""
+
+  defp generate_affinity_methods(name, _definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+    // AffinityCounts returns how many messages this actor has routed to each
+    // target, by name, and how many of them found their session pinned there
+    // Safe to call from outside the actor
+    func (a *#{type_name}) AffinityCounts() map[string]AffinityCount {
+    \treturn a.sys.affinityCounts(a, &a.affinity)
+    }
+
+    """
+  end
+
+  defp generate_size_methods(_name, %{size: nil}, _targets), do: ""
+  defp generate_size_methods(_name, _definition, []), do: ""
+
+  defp generate_size_methods(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+    // BytesSent returns how many bytes this actor has sent each target, by
+    // name, counting messages lost on the way
+    // Safe to call from outside the actor
+    func (a *#{type_name}) BytesSent() map[string]int64 {
+    \tbytes := links{}
+    \t#{on_inboxes(definition, type_name)}
+    \t\tfor to, n := range a.bytes {
+    \t\t\tbytes[to] += n
+    \t\t}
+    \t})
+    \treturn a.sys.named(bytes)
+    }
+
+    """
+  end
+
+  defp generate_reorder_methods(name, definition) do
+    if reorder(definition) do
+      type_name = GeneratorUtils.to_pascal_case(name)
+
+      """
+      // ReorderStats sums up the buffer in which messages that arrive ahead
+      // of an earlier one from their source wait for it
+      // Safe to call from outside the actor
+      func (a *#{type_name}) ReorderStats() ReorderStats {
+      \tvar stats ReorderStats
+      \tphony.Block(a, func() { stats = a.reorder.stats() })
+      \treturn stats
+      }
+
+      // queued returns the number of messages waiting in the reorder buffer
+      // Safe to call from outside the actor
+      func (a *#{type_name}) queued() int {
+      \tvar n int
+      \tphony.Block(a, func() { n = a.reorder.held })
+      \treturn n
+      }
+
+      """
+    else
+      ""
+    end
+  end
+
+  defp generate_probe_methods(name, definition) do
+    if probe?(definition) do
+      type_name = GeneratorUtils.to_pascal_case(name)
+
+      """
+      // ProbeStats returns what this probe has passed on so far, timing each
+      // message from when it was originated to when it reached the probe
+      // Safe to call from outside the actor
+      func (a *#{type_name}) ProbeStats() ProbeStats {
+      \tvar stats ProbeStats
+      \tphony.Block(a, func() { stats = a.probe.stats(a.latency.stats()) })
+      \treturn stats
+      }
+
+      """
+    else
+      ""
+    end
+  end
+
+  # An idempotent actor applies the first copy of each message it receives
+  defp generate_idempotency_methods(name, definition) do
+    if idempotency(definition) do
+      type_name = GeneratorUtils.to_pascal_case(name)
+
+      """
+      // firstCopy reports whether the message in hand is the first with its
+      // ID to arrive, remembering the ID; a repeat, such as a retry whose
+      // ack was lost, is acked as usual but deduped instead of applied again
+      func (a *#{type_name}) firstCopy() bool {
+      \tif a.seen == nil {
+      \t\ta.seen = map[MessageID]bool{}
+      \t}
+      \tif a.seen[a.header.id] {
+      \t\ta.dedupedCount++
+      \t\ta.sys.ledger.dropped.Add(1)
+      \t\treturn false
+      \t}
+      \ta.seen[a.header.id] = true
+      \ta.appliedCount++
+      \treturn true
+      }
+
+      // AppliedCount returns the number of messages this actor applied, once
+      // per message ID
+      // Safe to call from outside the actor
+      func (a *#{type_name}) AppliedCount() int {
+      \tvar n int
+      \tphony.Block(a, func() { n = a.appliedCount })
+      \treturn n
+      }
+
+      // DedupedCount returns the number of copies this actor dropped because
+      // it had applied their message ID already
+      // Safe to call from outside the actor
+      func (a *#{type_name}) DedupedCount() int {
+      \tvar n int
+      \tphony.Block(a, func() { n = a.dedupedCount })
+      \treturn n
+      }
+
+      """
+    else
+      ""
+    end
+  end
+
+  # An actor sending requests to a replier notes when it sent each one, until
+  # the first reply to it comes back
+  defp generate_reply_methods(_name, %{asks: []}), do: ""
+
+  defp generate_reply_methods(name, _roles) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+    // ask notes when this actor sent on the request it is handling, to time
+    // its round trip once the first reply comes back
+    func (a *#{type_name}) ask() {
+    \tif a.asked == nil {
+    \t\ta.asked = map[MessageID]time.Duration{}
+    \t}
+    \ta.asked[a.header.id] = a.sys.clock.Now()
+    }
+
+    // answered completes the round trip of request id, adding it to this
+    // actor's reply times; replies to copies of it that come back later, from
+    // other repliers it reached, are duplicates
+    func (a *#{type_name}) answered(id MessageID) {
+    \tasked, ok := a.asked[id]
+    \tif !ok {
+    \t\treturn
+    \t}
+    \tdelete(a.asked, id)
+    \ta.replyTime.add(a.sys.clock.Now() - asked)
+    }
+
+    // ReplyTimeStats sums up the round trips of the requests this actor sent,
+    // from sending one to the first reply to it
+    // Safe to call from outside the actor
+    func (a *#{type_name}) ReplyTimeStats() TimeStats {
+    \tvar stats TimeStats
+    \tphony.Block(a, func() { stats = a.replyTime.stats() })
+    \treturn stats
+    }
+
+    // Awaiting returns the number of requests this actor sent that no reply
+    // has come back for yet, lost or still on their round trip, or sent to
+    // a target that doesn't reply
+    // Safe to call from outside the actor
+    func (a *#{type_name}) Awaiting() int {
+    \tvar n int
+    \tphony.Block(a, func() { n = len(a.asked) })
+    \treturn n
+    }
+
+    """
+  end
+
+  # A source on an ack path holds each message it produces until a sink
+  # acks it, timing the round trip
+  defp generate_ack_methods(name, definition) do
+    if ack_path(definition) do
+      type_name = GeneratorUtils.to_pascal_case(name)
+
+      """
+      // track holds a message this actor produced as outstanding until its
+      // first ack comes back, and starts the path the ack retraces
+      func (a *#{type_name}) track(h *header) {
+      \tif a.outstanding == nil {
+      \t\ta.outstanding = map[MessageID]time.Duration{}
+      \t}
+      \ta.outstanding[h.id] = h.born
+      \th.path = hops{}
+      }
+
+      // acked completes the round trip of message id, adding it to this
+      // actor's latencies; acks for copies of it that come back later, from
+      // other sinks it reached, are duplicates
+      func (a *#{type_name}) acked(id MessageID) {
+      \tborn, ok := a.outstanding[id]
+      \tif !ok {
+      \t\treturn
+      \t}
+      \tdelete(a.outstanding, id)
+      \ta.ackCount++
+      \ta.latency.add(a.sys.clock.Now() - born)
+      }
+
+      // AckCount returns the number of messages acked end to end
+      // Safe to call from outside the actor
+      func (a *#{type_name}) AckCount() int {
+      \tvar n int
+      \tphony.Block(a, func() { n = a.ackCount })
+      \treturn n
+      }
+
+      // Outstanding returns the number of messages this actor produced that
+      // no ack has come back for yet, lost or still on their round trip, the
+      // window end-to-end flow control bounds
+      // Safe to call from outside the actor
+      func (a *#{type_name}) Outstanding() int {
+      \tvar n int
+      \tphony.Block(a, func() { n = len(a.outstanding) })
+      \treturn n
+      }
+
+      """
+    else
+      ""
+    end
+  end
+
+  defp generate_dead_letter_methods(_name, %{dlq_retry: nil}, _targets), do: ""
+
+  defp generate_dead_letter_methods(name, _definition, []) do
+    raise ArgumentError, "actor #{inspect(name)} has dlq_retry but no targets"
+  end
+
+  # Dead letters count as in flight until they get through or fail for good
+  defp generate_dead_letter_methods(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    %{interval: interval, max: max} = dlq_retry(definition)
+    waiting = read_counter(definition, type_name, "len(a.dead.waiting)")
+    failed = read_counter(definition, type_name, "len(a.dead.failed)")
+    send = deliver(definition)
+
+    loss =
+      if definition.loss do
+        """
+        \tif a.loss[i] != nil && a.loss[i].Drop() {
+        \t\ta.lostCount++
+        \t\treturn false
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+    // DeadLetterCount returns the number of dropped messages waiting to be
+    // resent
+    // Safe to call from outside the actor
+    func (a *#{type_name}) DeadLetterCount() int {
+    \tvar n int
+    #{waiting}
+    \treturn n
+    }
+
+    // FailedCount returns the number of dropped messages that failed every
+    // resend
+    // Safe to call from outside the actor
+    func (a *#{type_name}) FailedCount() int {
+    \tvar n int
+    #{failed}
+    \treturn n
+    }
+
+    // FailedLetters returns the dropped messages that failed every resend, in
+    // the order they failed
+    // Safe to call from outside the actor
+    func (a *#{type_name}) FailedLetters() []DeadLetter {
+    \tvar failed []DeadLetter
+    \tphony.Block(a, func() { failed = append([]DeadLetter(nil), a.dead.failed...) })
+    \treturn failed
+    }
+
+    // tryEdge sends f to target over edge i unless the edge loses it or a
+    // partition separates them, and reports whether it was sent
+    func (a *#{type_name}) tryEdge(i int, target phony.Actor, f func()) bool {
+    #{loss}\tif a.sys.severed(a, target) {
+    \t\treturn false
+    \t}
+    \t#{send}f)
+    \treturn true
+    }
+
+    // edge returns the index of the first edge to target, -1 if there is none
+    func (a *#{type_name}) edge(target phony.Actor) int {
+    \tfor i, t := range a.targets {
+    \t\tif phony.Actor(t) == target {
+    \t\t\treturn i
+    \t\t}
+    \t}
+    \treturn -1
+    }
+
+    // deadLetter holds a message an edge dropped for the reaper to resend
+    func (a *#{type_name}) deadLetter(kind string, target phony.Actor, f func()) {
+    \ta.dead.hold(kind, target, a.header, f)
+    \ta.sys.ledger.inflight.Add(1)
+    \ta.armReaper()
+    }
+
+    // armReaper resends the dead letters #{interval}ms from now unless a resend is
+    // due already
+    func (a *#{type_name}) armReaper() {
+    \tif !a.reaping {
+    \t\ta.reaping = true
+    \t\ta.sys.after(a, #{interval} * time.Millisecond, a.reap)
+    \t}
+    }
+
+    // reap resends every dead letter over its edge, holding on to those
+    // dropped again until they have been resent #{max} times
+    // A letter whose edge was removed can't be resent
+    func (a *#{type_name}) reap() {
+    \ta.reaping = false
+    \tfor _, l := range a.dead.take() {
+    \t\ta.sys.ledger.inflight.Add(-1)
+    \t\tl.Attempts++
+    \t\ta.header = l.header
+    \t\tif i := a.edge(l.Target); i >= 0 && a.tryEdge(i, l.Target, l.f) {
+    \t\t\ta.copiesSent++
+    \t\t\tcontinue
+    \t\t}
+    \t\tif a.dead.retry(l, #{max}, a.sys.clock.Now()) {
+    \t\t\ta.sys.ledger.inflight.Add(1)
+    \t\t} else {
+    \t\t\ta.sys.ledger.dropped.Add(1)
+    \t\t}
+    \t}
+    \tif len(a.dead.waiting) > 0 {
+    \t\ta.armReaper()
+    \t}
+    }
+
+    """
+  end
+
+  defp generate_delivery_methods(_name, %{delivery: nil}, _targets), do: ""
+  defp generate_delivery_methods(_name, _definition, []), do: ""
+
+  # A message keeps a retry timer armed until its acknowledgement comes back
+  # over the edge, which can lose or delay it like the message itself
+  defp generate_delivery_methods(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    {loss, drop, ack_drop} =
+      if definition.loss do
+        {"\tloss := a.loss[i]\n",
+         """
+         \t\tif loss != nil && loss.Drop() {
+         \t\t\ta.lostCount++
+         \t\t\ta.sys.ledger.dropped.Add(1)
+         \t\t\treturn
+         \t\t}
+         """,
+         """
+         \t\t\t\tif loss != nil && loss.Drop() {
+         \t\t\t\t\treturn
+         \t\t\t\t}
+         """}
+      else
+        {"", "", ""}
+      end
+
+    {delay, send, back} =
+      if definition.delay do
+        {"\tdelay := a.delay[i]\n",
+         "\t\tthere, back := delay.Sample(), delay.Sample()\n" <>
+           "\t\ta.sys.sendAfter(a, target, there, func() {\n", "back"}
+      else
+        {"", "\t\ta.sys.send(a, target, func() {\n", "0"}
+      end
+
+    """
+    // RetransmitCount returns the number of copies sent on at-least-once
+    // edges because no acknowledgement arrived in time
+    // Safe to call from outside the actor
+    func (a *#{type_name}) RetransmitCount() int {
+    \tvar n int
+    #{read_counter(definition, type_name, "a.retransmitCount")}
+    \treturn n
+    }
+
+    // DuplicateCount returns the number of copies that reached a target
+    // which already had the message
+    // Safe to call from outside the actor
+    func (a *#{type_name}) DuplicateCount() int {
+    \tvar n int
+    #{read_counter(definition, type_name, "int(a.duplicateCount.Load())")}
+    \treturn n
+    }
+
+    // sendReliably sends a message over edge i, then a copy each time the
+    // edge's retry interval passes, until the target acknowledges it
+    func (a *#{type_name}) sendReliably(i int, target phony.Actor, receive func()) {
+    \ta.deliverySeq++
+    \tseq := a.deliverySeq
+    \th := a.header
+    \tretry := a.retry[i]
+    #{loss}#{delay}\treceived := false // only touched on the target's inbox
+    \tvar attempt func()
+    \tattempt = func() {
+    \t\ta.unacked[seq] = a.sys.after(a, retry, func() {
+    \t\t\t// No acknowledgement in time: send a copy
+    \t\t\ta.header = h
+    \t\t\ta.retransmitCount++
+    \t\t\ta.sys.ledger.copied.Add(1)
+    \t\t\tattempt()
+    \t\t})
+    #{drop}#{send}\t\t\tif received {
+    \t\t\t\ta.duplicateCount.Add(1)
+    \t\t\t}
+    \t\t\treceived = true
+    \t\t\treceive()
+    \t\t\t// Acknowledge over the same edge
+    \t\t\ta.sys.after(a, #{back}, func() {
+    #{ack_drop}\t\t\t\tif timer, ok := a.unacked[seq]; ok {
+    \t\t\t\t\ttimer.Stop()
+    \t\t\t\t\tdelete(a.unacked, seq)
+    \t\t\t\t}
+    \t\t\t})
+    \t\t})
+    \t}
+    \tattempt()
+    }
+
+    """
+  end
+
+  defp generate_timeout_methods(_name, %{timeout: nil}, _targets), do: ""
+  defp generate_timeout_methods(_name, _definition, []), do: ""
+
+  defp generate_timeout_methods(name, definition, _targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    breaker_methods =
+      if circuit_breaker(definition) do
+        """
+        // ShortCircuitCount returns the number of messages sent straight to the
+        // fallback because the circuit to their target was open
+        // Safe to call from outside the actor
+        func (a *#{type_name}) ShortCircuitCount() int {
+        \tvar n int
+        #{read_counter(definition, type_name, "a.shortCircuitCount")}
+        \treturn n
+        }
+
+        // BreakerStates returns the state of the circuit breaker on each edge
+        // Safe to call from outside the actor
+        func (a *#{type_name}) BreakerStates() []BreakerState {
+        \tvar states []BreakerState
+        \t#{on_inboxes(definition, type_name)}
+        \t\tfor _, b := range a.breakers {
+        \t\t\tstates = append(states, b.State())
+        \t\t}
+        \t})
+        \treturn states
+        }
+
+        """
+      else
+        ""
+      end
+
+    """
+    // TimeoutCount returns the number of requests resent to the fallback
+    // Safe to call from outside the actor
+    func (a *#{type_name}) TimeoutCount() int {
+    \tvar n int
+    #{read_counter(definition, type_name, "a.timeoutCount")}
+    \treturn n
+    }
+
+    #{breaker_methods}// replied cancels the fallback of a request answered in time and
+    // reports whether it was
+    func (a *#{type_name}) replied(id uint64) bool {
+    \ttimer, ok := a.pending[id]
+    \tif ok {
+    \t\ttimer.Stop()
+    \t\tdelete(a.pending, id)
+    \t}
+    \treturn ok
+    }
+
+    """
+  end
+
+  defp generate_callbacks_file(name, definition, messages, types) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    originated = originated_messages(definition)
+
+    alarm_method =
+      if alarm(definition) do
+        [
+          """
+          func (c *Default#{type_name}Callbacks) OnAlarm(metric string, value float64) error {
+          \t// TODO: Implement custom alerting
+          \tfmt.Printf("#{type_name}: Alarm on %s at %.1f/s\\n", metric, value)
+          \treturn nil
+          }
+          """
+        ]
+      else
+        []
+      end
+
+    timer_methods =
+      Enum.map(timers(definition), fn {timer, _ms} ->
+        """
+        func (c *Default#{type_name}Callbacks) On#{GeneratorUtils.to_pascal_case(timer)}() error {
+        \t// TODO: Implement custom behavior for the #{timer} timer
+        \tc.Ctx.Logf("#{type_name}: #{timer} timer fired\\n")
+        \treturn nil
+        }
+        """
+      end)
+
+    # Transforms start out letting every message through unchanged
+    transform_methods =
+      Enum.map(transform_steps(definition), fn
+        {:filter, step} = transform ->
+          """
+          func (c *Default#{type_name}Callbacks) #{transform_method(transform)}(m EdgeMessage) bool {
+          \t// TODO: Implement the #{step} filter, reporting whether the message goes on
+          \treturn true
+          }
+          """
+
+        {:map, step} = transform ->
+          """
+          func (c *Default#{type_name}Callbacks) #{transform_method(transform)}(m EdgeMessage) any {
+          \t// TODO: Implement the #{step} map, returning the message's new payload
+          \treturn m.Payload
+          }
+          """
+      end)
+
+    idle_method =
+      case idle_timeout(definition) do
+        nil ->
+          []
+
+        ms ->
+          [
+            """
+            func (c *Default#{type_name}Callbacks) OnIdle() error {
+            \t// TODO: Implement custom behavior once nothing arrived for #{ms}ms
+            \tc.Ctx.Logf("#{type_name}: idle for #{ms}ms\\n")
+            \treturn nil
+            }
+            """
+          ]
+      end
+
+    impl_methods =
+      messages
+      |> Enum.map(fn msg ->
+        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+        # Messages from the actor's own send pattern are sent, the rest received
+        action = if msg in originated, do: "Sending", else: "Received"
+        result = callback_result(definition, msg, types)
+
+        """
+        func (c *Default#{type_name}Callbacks) On#{msg_name}(#{callback_param(msg, types)}) #{result} {
+        \t// TODO: Implement custom behavior for #{msg}
+        \tc.Ctx.Logf("#{type_name}: #{action} #{msg} message\\n")
+        \t#{callback_return(definition, msg, types)}
+        }
+        """
+      end)
+      |> Kernel.++(alarm_method)
+      |> Kernel.++(timer_methods)
+      |> Kernel.++(idle_method)
+      |> Kernel.++(transform_methods)
+      |> Enum.join("\n\n")
+
+    imports_section =
+      if alarm_method != [] do
+        "import (\n\t\"fmt\"\n)\n"
+      else
+        ""
+      end
+
+    """
+    // Generated from ActorSimulation DSL
+    // Default callback implementation for: #{name}
+    // CUSTOMIZE THIS FILE - This is where you add your custom behavior!
+
+    package main
+
+    #{imports_section}
+
+    // Default#{type_name}Callbacks provides default implementations
+    // CUSTOMIZE THIS to add your own behavior!
+    type Default#{type_name}Callbacks struct {
+    \t// Ctx carries the actor's clock; Ctx.SleepVirtual makes a message take time
+    \t// and Ctx.Logf logs a sampled line
+    \tCtx *Context
+    }
+
+    #{impl_methods}
+    """
+  end
+
+  defp generate_timer_setup(definition) do
+    every = generate_every(definition)
+
+    case definition.send_pattern do
+      nil ->
+        ""
+
+      {:periodic, interval_ms, message} ->
+        msg_name = originated_handler(definition, message)
+
+        """
+        \t#{every}#{interval_ms} * time.Millisecond, func() { a.sys.produce(a, "#{definition.name}", a.#{msg_name}) })
+        """
+
+      {:rate, per_second, message} ->
+        interval_ms = div(1000, per_second)
+        msg_name = originated_handler(definition, message)
+
+        case ramp(definition) do
+          nil ->
+            """
+            \t#{every}#{interval_ms} * time.Millisecond, func() { a.sys.produce(a, "#{definition.name}", a.#{msg_name}) })
+            """
+
+          ramp ->
+            first = first_send(definition) || interval_ms
+
+            """
+            \ta.sys.ramp(a, "#{definition.name}", #{first} * time.Millisecond, #{go_ramp(ramp)}, func() { a.sys.produce(a, "#{definition.name}", a.#{msg_name}) })
+            """
+        end
+
+      {:burst, count, interval_ms, message} ->
+        msg_name = originated_handler(definition, message)
+
+        """
+        \t#{every}#{interval_ms} * time.Millisecond, func() {
+        \t\tfor i := 0; i < #{count}; i++ {
+        \t\t\ta.sys.produce(a, "#{definition.name}", a.#{msg_name})
+        \t\t}
+        \t})
+        """
+
+      {:self_message, delay_ms, _message} ->
+        """
+        \t// One-shot delayed self-message
+        \ta.armOneShot(#{first_send(definition) || delay_ms} * time.Millisecond)
+        """
+    end
+  end
+
+  defp go_ramp(ramp) do
+    exponential = if ramp.curve == :exponential, do: ", Exponential: true", else: ""
+
+    "Ramp{From: #{ramp.from}, To: #{ramp.to}, Over: #{ramp.over} * time.Millisecond" <>
+      "#{exponential}, Breakpoint: #{ramp.breakpoint}}"
+  end
+
+  # Periodic actors with a start delay fire first after the delay, then on
+  # their interval
+  defp generate_every(definition) do
+    case first_send(definition) do
+      nil -> "a.sys.every(a, "
+      delay -> "a.sys.everyAfter(a, #{delay} * time.Millisecond, "
+    end
+  end
+
+  # The start delay of an actor, or nil when it first sends after one interval
+  defp first_send(%{start_delay: nil}), do: nil
+
+  defp first_send(%{start_delay: delay, send_pattern: pattern})
+       when is_integer(delay) and delay >= 0 and pattern != nil,
+       do: delay
+
+  defp first_send(%{name: name, start_delay: delay}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid start_delay #{inspect(delay)}, " <>
+            "expected milliseconds on an actor with a send pattern"
+  end
+
+  defp generate_schedule_start(name, definition) do
+    if schedule(definition) do
+      field = GeneratorUtils.to_camel_case(name)
+
+      """
+      \t// Replay the schedule copied from #{Path.basename(definition.schedule_file)}
+      \tentries, err := ParseSchedule(strings.NewReader(#{field}Schedule))
+      \tif err == nil {
+      \t\terr = a.Replay(entries)
+      \t}
+      \tif err != nil {
+      \t\tpanic(fmt.Sprintf("#{name}: replaying #{schedule_csv(name)}: %v", err))
+      \t}
+      """
+    else
+      ""
+    end
+  end
+
+  # Any message the actor handles can be replayed, not only the ones in its
+  # own schedule
+  defp generate_schedule_methods(name, definition, messages) do
+    if schedule(definition) do
+      type_name = GeneratorUtils.to_pascal_case(name)
+      field = GeneratorUtils.to_camel_case(name)
+
+      cases =
+        Enum.map_join(messages, fn msg ->
+          msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+          "\t\tcase \"#{GeneratorUtils.message_name(msg)}\":\n\t\t\tsends[i] = a.#{msg_name}\n"
+        end)
+
+      """
+      //go:embed #{schedule_csv(name)}
+      var #{field}Schedule string
+
+      // Replay originates each entry's message once its time has elapsed from
+      // now, alongside anything already scheduled, e.g. another trace loaded
+      // with LoadSchedule
+      // Safe to call from outside the actor
+      func (a *#{type_name}) Replay(entries []ScheduleEntry) error {
+      \tsends := make([]func(), len(entries))
+      \tfor i, e := range entries {
+      \t\tswitch e.Kind {
+      #{cases}\t\tdefault:
+      \t\t\treturn fmt.Errorf("#{name} does not handle %q messages", e.Kind)
+      \t\t}
+      \t}
+      \tfor i, e := range entries {
+      \t\tsend := sends[i]
+      \t\ta.sys.after(a, e.At, func() { a.sys.produce(a, "#{name}", send) })
+      \t}
+      \treturn nil
+      }
+
+      """
+    else
+      ""
+    end
+  end
+
+  defp schedule_csv(name), do: "#{GeneratorUtils.to_snake_case(name)}_schedule.csv"
+
+  # Act looks up the handler of the message kind an envelope names
+  defp generate_handler_method(name, messages) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    cases =
+      Enum.map_join(messages, fn msg ->
+        msg_name = GeneratorUtils.message_name(msg)
+
+        "\tcase \"#{msg_name}\":\n" <>
+          "\t\treturn a.#{GeneratorUtils.to_pascal_case(msg_name)}, true\n"
+      end)
+
+    """
+    // handler returns the method that handles messages of kind, for Act
+    func (a *#{type_name}) handler(kind string) (func(), bool) {
+    \tswitch kind {
+    #{cases}\t}
+    \treturn nil, false
+    }
+
+    """
+  end
+
+  defp generate_message_handlers(
+         name,
+         definition,
+         messages,
+         targets,
+         enable_callbacks,
+         acked?,
+         roles,
+         types
+       ) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    Enum.map_join(messages, "\n\n", fn msg ->
+      msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+      typed_reply = enable_callbacks && typed_reply(definition, msg, types)
+
+      callback_call =
+        cond do
+          typed_reply ->
+            """
+            \treply, err := a.callbacks.On#{msg_name}(#{callback_arg(msg, types)})
+            \tif err != nil {
+            \t\ta.sys.fail("#{name}", err)
+            \t\treturn
+            \t}
+            """
+
+          enable_callbacks ->
+            """
+            \tif err := a.callbacks.On#{msg_name}(#{callback_arg(msg, types)}); err != nil {
+            \t\ta.sys.fail("#{name}", err)
+            \t\treturn
+            \t}
+            """
+
+          true ->
+            """
+            \t// Handle #{msg}
+            """
+        end
+
+      # A request is answered before it goes on, with the reply its
+      # callback computed, if typed
+      respond =
+        case List.keyfind(replies(definition), msg, 0) do
+          {_kind, reply} ->
+            payload = if typed_reply, do: "reply", else: "nil"
+            "\ta.sys.respond(a, \"#{GeneratorUtils.message_name(reply)}\", #{payload})\n"
+
+          nil ->
+            ""
+        end
+
+      ask = if msg in roles.asks, do: "\ta.ask()\n", else: ""
+
+      forward =
+        cond do
+          definition.join_by ->
+            """
+            \tif a.join.add(#{Enum.find_index(messages, &(&1 == msg))}, a.header.key) {
+            \t\ta.forwardJoined()
+            \t}
+            """
+
+          # A reply ends its request's round trip here
+          msg in roles.answers ->
+            "\ta.answered(a.header.id)\n\ta.sys.forwarded(0)\n"
+
+          # A sink on an ack path acks what it handles back to the source
+          acked? and targets == [] ->
+            generate_forward(msg_name, definition, targets) <> "\ta.sys.ack(a, a.header)\n"
+
+          true ->
+            generate_forward(msg_name, definition, targets)
+        end
+
+      forward = respond <> ask <> forward
+
+      record =
+        cond do
+          observe(definition) ->
+            "\ta.record(\"#{GeneratorUtils.message_name(msg)}\")\n"
+
+          probe?(definition) ->
+            kind = GeneratorUtils.message_name(msg)
+            "\ta.probe.add(\"#{kind}\", #{message_size(definition, kind) || 0})\n"
+
+          true ->
+            ""
+        end
+
+      # Middleware sees every copy; only the first is applied
+      dedupe =
+        if idempotency(definition),
+          do: "\tif !a.firstCopy() {\n\t\treturn\n\t}\n",
+          else: ""
+
+      # A queued or held message keeps the header it arrived with until it is
+      # served or released
+      header = if definition.fair_queue || reorder(definition), do: "h", else: "a.header"
+
+      handle =
+        "a.sys.middleware.Handle(HandlerContext{Actor: \"#{name}\", From: #{header}.from, " <>
+          "Now: a.sys.clock.Now(), ID: #{header}.id, Key: #{header}.key, " <>
+          "Headers: #{header}.Headers, Payload: #{header}.payload}, " <>
+          "\"#{GeneratorUtils.message_name(msg)}\", a.handle#{msg_name})"
+
+      class = Enum.find_index(messages, &(&1 == msg))
+      bound = bound_mailbox(definition, msg_name)
+
+      # A conflated message is dropped, as the one replacing it is newer, and
+      # so is a copy of one the reorder buffer has released or holds already
+      entry =
+        cond do
+          conflation(definition) ->
+            key = if conflation(definition) == :source, do: "h.id.Source", else: "\"\""
+
+            """
+            \th := a.header
+            \tif a.queue.Conflate(#{class}, #{key}, func() {
+            \t\ta.header = h
+            \t\t#{handle}
+            \t}) {
+            \t\ta.conflatedCount++
+            \t\ta.sys.ledger.dropped.Add(1)
+            \t}
+            \ta.sawQueue(a.inbound.Load() + int64(a.queue.Len()))
+            \ta.serveNext()
+            """
+
+          priority_aging(definition) ->
+            deadline = if deadline_aware?(definition), do: "h.Deadline", else: "0"
+
+            """
+            \th := a.header
+            #{bound}\ta.queue.PushAged(#{class}, h.Priority, #{deadline}, a.sys.clock.Now(), func() {
+            \t\ta.header = h
+            \t\t#{handle}
+            \t})
+            \ta.sawQueue(a.inbound.Load() + int64(a.queue.Len()))
+            \ta.serveNext()
+            """
+
+          deadline_aware?(definition) ->
+            """
+            \th := a.header
+            #{bound}\ta.queue.PushDue(#{class}, h.Deadline, func() {
+            \t\ta.header = h
+            \t\t#{handle}
+            \t})
+            \ta.sawQueue(a.inbound.Load() + int64(a.queue.Len()))
+            \ta.serveNext()
+            """
+
+          definition.fair_queue ->
+            """
+            \th := a.header
+            #{bound}\ta.queue.Push(#{class}, func() {
+            \t\ta.header = h
+            \t\t#{handle}
+            \t})
+            \ta.sawQueue(a.inbound.Load() + int64(a.queue.Len()))
+            \ta.serveNext()
+            """
+
+          reorder(definition) ->
+            """
+            \th := a.header
+            \tif !a.reorder.admit(h.id.Source, h.key, func() {
+            \t\ta.header = h
+            \t\t#{handle}
+            \t}) {
+            \t\ta.sys.ledger.dropped.Add(1)
+            \t}
+            """
+
+          true ->
+            """
+            \t#{handle}
+            """
+        end
+
+      route =
+        if parallelism(definition) do
+          """
+          \tif a.shards != nil {
+          \t\t// Hand the message on to the next inbox, which handles it
+          \t\tshard := a.nextShard()
+          \t\ta.sys.send(a, shard, func() { shard.#{msg_name}() })
+          \t\treturn
+          \t}
+          """
+        else
+          ""
+        end
+
+      # A callback that sleeps leaves the rest of the message for later
+      handle =
+        cond do
+          typed_reply ->
+            reply_type = GeneratorUtils.to_pascal_case(typed_reply)
+
+            """
+            func (a *#{type_name}) handle#{msg_name}() {
+            #{dedupe}#{callback_call}#{record}\ta.sys.resume(a, &a.ctx, func() { a.finish#{msg_name}(reply) })
+            }
+
+            func (a *#{type_name}) finish#{msg_name}(reply #{reply_type}) {
+            #{forward}}
+            """
+
+          enable_callbacks ->
+            """
+            func (a *#{type_name}) handle#{msg_name}() {
+            #{dedupe}#{callback_call}#{record}\ta.sys.resume(a, &a.ctx, a.finish#{msg_name})
+            }
+
+            func (a *#{type_name}) finish#{msg_name}() {
+            #{forward}}
+            """
+
+          true ->
+          """
+          func (a *#{type_name}) handle#{msg_name}() {
+          #{dedupe}#{callback_call}#{record}#{forward}}
+          """
+        end
+
+      idle_reset = if idle_timeout(definition), do: "\ta.resetIdle()\n", else: ""
+
+      """
+      func (a *#{type_name}) #{msg_name}() {
+      #{route}#{idle_reset}#{entry}}
+
+      #{handle}"""
+    end)
+  end
+
+  # Actors without targets are sinks
+  defp generate_forward(_msg_name, _definition, []), do: "\ta.sys.forwarded(0)\n"
+
+  # Each request arms a timer that resends to the fallback unless the target
+  # replies first; a request lost on the way falls back the same way. A
+  # circuit breaker counts both outcomes and, while open, sends straight to
+  # the fallback.
+  defp generate_forward(msg_name, %{timeout: timeout} = definition, _targets)
+       when timeout != nil do
+    breaker? = circuit_breaker(definition) != nil
+    index = if definition.loss || definition.delay || breaker?, do: "i", else: "_"
+
+    breaker_check =
+      if breaker? do
+        """
+        \t\tbreaker := a.breakers[i]
+        \t\tif !breaker.Allow() {
+        \t\t\t// The circuit is open: go straight to the fallback
+        \t\t\ta.shortCircuitCount++
+        \t\t\tfallback := a.fallback
+        \t\t\ta.sys.send(a, fallback, func() { fallback.#{msg_name}() })
+        \t\t\ta.copiesSent++
+        \t\t\tcontinue
+        \t\t}
+        """
+      else
+        ""
+      end
+
+    {failure, reply} =
+      if breaker? do
+        {"\t\t\tbreaker.Failure()\n",
+         "a.sys.reply(target, a, func() {\n" <>
+           "\t\t\t\tif a.replied(id) {\n\t\t\t\t\tbreaker.Success()\n\t\t\t\t}\n\t\t\t})"}
+      else
+        {"", "a.sys.reply(target, a, func() { a.replied(id) })"}
+      end
+
+    loss_check =
+      if definition.loss do
+        """
+        \t\tif a.loss[i] != nil && a.loss[i].Drop() {
+        \t\t\ta.lostCount++
+        \t\t\ta.sys.ledger.dropped.Add(1)
+        \t\t\tcontinue
+        \t\t}
+        """
+      else
+        ""
+      end
+
+    """
+    \t// Send to targets, falling back if no reply arrives in time
+    #{fan_out(definition, index, msg_name)}\t\ttarget := target
+    #{breaker_check}#{count_bytes(definition, msg_name)}\t\ta.requestCount++
+    \t\tid := a.requestCount
+    \t\th := a.header
+    \t\ta.pending[id] = a.sys.after(a, #{timeout} * time.Millisecond, func() {
+    \t\t\tdelete(a.pending, id)
+    \t\t\ta.timeoutCount++
+    #{failure}\t\t\ta.header = h
+    \t\t\tfallback := a.fallback
+    \t\t\ta.sys.ledger.copied.Add(1)
+    \t\t\ta.sys.send(a, fallback, func() { fallback.#{msg_name}() })
+    \t\t\ta.copiesSent++
+    \t\t})
+    #{loss_check}\t\t#{deliver(definition)}func() {
+    \t\t\ttarget.#{msg_name}()
+    \t\t\t#{reply}
+    \t\t})
+    \t\ta.copiesSent++
+    \t}
+    """
+  end
+
+  # Messages an edge drops wait for the reaper instead of being lost
+  defp generate_forward(msg_name, %{dlq_retry: retry} = definition, _targets)
+       when retry != nil do
+    """
+    \t// Send to targets, holding messages an edge drops to resend them later
+    #{fan_out(definition, "i", msg_name)}\t\ttarget := target
+    #{count_bytes(definition, msg_name)}\t\tf := func() { target.#{msg_name}() }
+    \t\tif a.tryEdge(i, target, f) {
+    \t\t\ta.copiesSent++
+    \t\t\tcontinue
+    \t\t}
+    \t\ta.deadLetter("#{Macro.underscore(msg_name)}", target, f)
+    \t}
+    """
+  end
+
+  defp generate_forward(msg_name, definition, _targets) do
+    reliable? = at_least_once?(definition)
+    index = if definition.loss || definition.delay || reliable?, do: "i", else: "_"
+
+    loss_check =
+      if definition.loss do
+        """
+        \t\tif a.loss[i] != nil && a.loss[i].Drop() {
+        \t\t\ta.lostCount++
+        \t\t\ta.sys.ledger.dropped.Add(1)
+        \t\t\tcontinue
+        \t\t}
+        """
+      else
+        ""
+      end
+
+    # At-least-once edges hand the message to sendReliably, which does its
+    # own loss and delay
+    {intro, reliable_send, capture} =
+      if reliable? do
+        {"Send to targets, resending on at-least-once edges until acknowledged",
+         """
+         \t\ttarget := target
+         \t\tif a.retry[i] > 0 {
+         \t\t\ta.sendReliably(i, target, func() { target.#{msg_name}() })
+         \t\t\ta.copiesSent++
+         \t\t\tcontinue
+         \t\t}
+         """, ""}
+      else
+        {"Send to targets", "", "\t\ttarget := target\n"}
+      end
+
+    """
+    \t// #{intro}
+    #{fan_out(definition, index, msg_name)}#{count_bytes(definition, msg_name)}#{reliable_send}#{loss_check}#{capture}\t\t#{deliver(definition)}func() { target.#{msg_name}() })
+    \t\ta.copiesSent++
+    \t}
+    """
+  end
+
+  # A routing actor sends each message on to just the target its router or
+  # its session affinity picks, rather than to every target
+  defp fan_out(definition, index, msg_name) do
+    fan_out(definition, index) <> transform_check(definition, msg_name)
+  end
+
+  defp fan_out(definition, index) do
+    cond do
+      routing(definition) != :broadcast ->
+        """
+        \t// #{route_intro(routing(definition))}
+        \tpicked := route(&a.router, a.sys.clock.Now(), a.targets)
+        \ta.sys.forwarded(len(picked))
+        #{transform_payload(definition)}\tfor #{index}, target := range picked {
+        """
+
+      affinity(definition) ->
+        """
+        \t// Only to the target the message's session is pinned to
+        \tpicked := stick(&a.affinity, a.header.key, a.sys.clock.Now(), a.targets)
+        \ta.sys.forwarded(len(picked))
+        #{transform_payload(definition)}\tfor #{index}, target := range picked {
+        """
+
+      true ->
+        """
+        \ta.sys.forwarded(len(a.targets))
+        #{transform_payload(definition)}\tfor #{index}, target := range a.targets {
+        """
+    end
+  end
+
+  defp route_intro(:weighted), do: "Only to the target the edge weights pick now"
+  defp route_intro(:round_robin), do: "Only to the next target in turn"
+  defp route_intro(:random), do: "Only to a target drawn at random"
+
+  # A message that finds the mailbox full is dropped, drops the oldest one
+  # queued there or waits for room, as its sender would; as many wait as the
+  # mailbox holds, so a burst can't grow what is held back without bound
+  defp bound_mailbox(definition, msg_name) do
+    case mailbox(definition) do
+      nil ->
+        ""
+
+      {capacity, :drop_newest} ->
+        """
+        \tif a.queue.Len() >= #{capacity} {
+        \t\t// The mailbox is full, so the message is dropped
+        \t\ta.dropCount++
+        \t\ta.sys.ledger.dropped.Add(1)
+        \t\treturn
+        \t}
+        """
+
+      {capacity, :drop_oldest} ->
+        """
+        \tif a.queue.Len() >= #{capacity} && a.queue.DropOldest() {
+        \t\t// The mailbox is full, so its oldest message makes room
+        \t\ta.dropCount++
+        \t\ta.sys.ledger.dropped.Add(1)
+        \t}
+        """
+
+      {capacity, :block} ->
+        """
+        \tif a.queue.Len() >= #{capacity} {
+        \t\tif len(a.blocked) >= #{capacity} {
+        \t\t\t// The mailbox is full and so is the line waiting for room,
+        \t\t\t// so the message is dropped
+        \t\t\ta.dropCount++
+        \t\t\ta.sys.ledger.dropped.Add(1)
+        \t\t\treturn
+        \t\t}
+        \t\t// The mailbox is full, so the message waits for room
+        \t\ta.blocked = append(a.blocked, func() {
+        \t\t\ta.header = h
+        \t\t\ta.#{msg_name}()
+        \t\t})
+        \t\treturn
+        \t}
+        """
+    end
+  end
+
+  defp transform_payload(definition),
+    do: if(transforms(definition) == [], do: "", else: "\tpayload := a.header.payload\n")
+
+  # Each copy of a message goes through the transforms on its own edge,
+  # from the payload the actor handled
+  defp transform_check(definition, msg_name) do
+    if transforms(definition) == [] do
+      ""
+    else
+      """
+      \t\tif !a.transform(target, "#{Macro.underscore(msg_name)}", payload) {
+      \t\t\tcontinue
+      \t\t}
+      """
+    end
+  end
+
+  # A sized message counts on its edge as it goes out, whether it gets
+  # through or not
+  defp count_bytes(definition, msg_name) do
+    case message_size(definition, Macro.underscore(msg_name)) do
+      nil -> ""
+      bytes -> "\t\ta.bytes.add(target, #{bytes})\n"
+    end
+  end
+
+  # Delayed edges hand the message to the clock instead of sending it now;
+  # returns the call up to its message closure
+  defp deliver(%{delay: nil}), do: "a.sys.send(a, target, "
+  defp deliver(_definition), do: "a.sys.sendAfter(a, target, a.delay[i].Sample(), "
+
+  defp generate_system_file(actors, topology, project_name, logs) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+    log_field = if logs, do: "\tlogs logSampler\n", else: ""
+    log_setup = if logs, do: "\ts.logs.every.Store(#{logs})\n", else: ""
+    ramp_field = if uses_ramps?(actors), do: "\tramps []*ramping\n", else: ""
+
+    {kafka_field, kafka_setup} =
+      if uses_kafka?(actors) do
+        {"\tkafka atomic.Pointer[kafkaLink]\n",
+         "\ts.middleware = append(s.middleware, kafkaSink{s})\n"}
+      else
+        {"", ""}
+      end
+
+    fields =
+      Enum.map_join(simulated, "\n", fn {name, _def} ->
+        "\t#{GeneratorUtils.to_camel_case(name)} *#{GeneratorUtils.to_pascal_case(name)}"
+      end)
+
+    spawn_code =
+      Enum.map_join(simulated, "\n", fn {name, _def} ->
+        "\ts.#{GeneratorUtils.to_camel_case(name)} = new#{GeneratorUtils.to_pascal_case(name)}(s)"
+      end)
+
+    wiring_code =
+      Enum.map_join(simulated, "", fn {name, definition} ->
+        field = "s.#{GeneratorUtils.to_camel_case(name)}"
+        targets = Map.fetch!(topology.targets, name)
+
+        generate_wiring(field, name, definition, targets) <>
+          generate_shard_wiring(field, name, definition, targets)
+      end)
+
+    constructors =
+      Enum.map_join(simulated, fn {name, definition} ->
+        generate_constructor(name, definition, Map.fetch!(topology.messages, name))
+      end)
+
+    registry =
+      Enum.map_join(simulated, ", ", fn {name, _def} ->
+        "\"#{name}\": s.#{GeneratorUtils.to_camel_case(name)}"
+      end)
+
+    # SourceOrder ranks the messages of each source by declaration order
+    ranks =
+      simulated
+      |> Enum.with_index(1)
+      |> Enum.map_join(", ", fn {{name, _def}, rank} -> "\"#{name}\": #{rank}" end)
+
+    start_code =
+      Enum.map_join(simulated, "\n", fn {name, _def} ->
+        "\ts.#{GeneratorUtils.to_camel_case(name)}.Start()"
+      end)
+
+    # Messages of a source on an ack path note the hops they take
+    {path_field, step, delayed_step} =
+      if uses_acks?(actors) do
+        {"\tpath hops\n", "\th.path = h.path.through(from, 0)\n",
+         "\th.path = h.path.through(from, d)\n"}
+      else
+        {"", "", ""}
+      end
+
+    # Replies are only needed by actors with a timeout and fallback
+    timeouts? = uses_timeout?(actors)
+    service_time_field =
+      if timeouts?, do: "\tserviceTime map[phony.Actor]time.Duration\n", else: ""
+    service_time_code = if timeouts?, do: generate_service_times(actors), else: ""
+    reply_method = if timeouts?, do: generate_reply_method(), else: ""
+
+    queue_depths =
+      simulated
+      |> Enum.filter(fn {_name, definition} -> definition.fair_queue end)
+      |> Enum.map_join(fn {name, definition} ->
+        field = "s.#{GeneratorUtils.to_camel_case(name)}"
+
+        if parallelism(definition) do
+          "\tfor _, shard := range #{field}.shards {\n" <>
+            "\t\tphony.Block(shard, func() { n += shard.queue.Len() })\n\t}\n"
+        else
+          "\tphony.Block(#{field}, func() { n += #{field}.queue.Len() })\n"
+        end
+      end)
+
+    """
+    // Generated from ActorSimulation DSL
+    // Actor system for #{project_name}
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"hash/fnv"
+    \t"math/rand"
+    \t"sort"
+    \t"sync"
+    \t"sync/atomic"
+    \t"time"
+    )
+
+    // System owns every actor, the clock that drives them and the seed of the
+    // RNG streams behind stochastic behavior such as message loss, so a run is
+    // reproducible from its seed and the changes made to it, which it keeps
+    // for Fork
+    type System struct {
+    \tmu sync.Mutex
+    \tseed int64
+    \trng *rand.Rand
+    \thistory []change
+    \tclock Clock
+    \tvirtual bool
+    \tstarted chan struct{}
+    \tstopped atomic.Bool
+    \tstopper stopper
+    \thalted chan struct{}
+    \terr error
+    \tmiddleware chain
+    \tinflight atomic.Int64
+    \tledger ledger
+    \ttraceSample float64
+    \ttraceDraw func() float64
+    \ttraces atomic.Uint64
+    \tidGen atomic.Uint64
+    \tpartitioned atomic.Int64
+    \tlost []LostMessage
+    \tsupervision []SupervisorEvent
+    \tdepths depthLog
+    \tactors map[string]actor
+    \ttickers map[phony.Actor]*ticker
+    \tranks map[string]int
+    \tmetricSink MetricSink
+    #{log_field}#{kafka_field}#{ramp_field}#{service_time_field}#{fields}
+    }
+
+    // NewSystem spawns all actors and wires them to their targets
+    // Pass a VirtualClock to run the system deterministically in virtual time,
+    // and options such as WithMetricSink to configure it
+    func NewSystem(seed int64, clock Clock, opts ...Option) *System {
+    \ts := &System{seed: seed, rng: rand.New(rand.NewSource(seed)), clock: clock}
+    \tfor _, opt := range opts {
+    \t\topt(s)
+    \t}
+    \t_, s.virtual = clock.(*VirtualClock)
+    \ts.started = make(chan struct{})
+    \ts.halted = make(chan struct{})
+    \ts.tickers = map[phony.Actor]*ticker{}
+    #{log_setup}#{kafka_setup}#{spawn_code}
+    \ts.actors = map[string]actor{#{registry}}
+    \ts.ranks = map[string]int{#{ranks}}
+    \t
+    #{wiring_code}#{service_time_code}\tfor name, a := range s.actors {
+    \t\ts.instrument(name, a)
+    \t}
+    \treturn s
+    }
+    #{constructors}
+    // Start starts every actor
+    // Call it once: on a RealClock no timer fires before it returns, since
+    // otherwise one due at once could reach an actor that isn't started yet
+    func (s *System) Start() {
+    #{start_code}
+    \ts.record((*System).Start)
+    \tclose(s.started)
+    }
+
+    // Stop stops a system running on a RealClock: it cancels the timers still
+    // pending and returns once the timers firing and the messages in flight are
+    // handled, so nothing of the system's is left running
+    // Call it once Start has returned, from outside the actors
+    func (s *System) Stop() {
+    \ts.stopped.Store(true)
+    \ts.stopper.stop()
+    }
+
+    // halt stops the system on the first error a callback of the named actor
+    // returns, so no timer runs after it: on a RealClock as Stop does but
+    // without waiting, since a callback calls it, and on a VirtualClock by
+    // running no timer after it
+    func (s *System) halt(name string, err error) {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \tif s.err != nil {
+    \t\treturn
+    \t}
+    \ts.err = fmt.Errorf("%s: %w", name, err)
+    \ts.stopped.Store(true)
+    \tif s.virtual {
+    \t\ts.clock.(*VirtualClock).halt()
+    \t}
+    \tclose(s.halted)
+    }
+
+    // fail halts the system on an error the callback of a message returned;
+    // the message goes no further, so it counts as dropped
+    func (s *System) fail(name string, err error) {
+    \ts.ledger.dropped.Add(1)
+    \ts.halt(name, err)
+    }
+
+    // Err returns the error a callback halted the system with, prefixed with
+    // the name of its actor, or nil if none has
+    // Safe to call from outside the actors
+    func (s *System) Err() error {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \treturn s.err
+    }
+
+    // Halted is closed once a callback halts the system
+    func (s *System) Halted() <-chan struct{} {
+    \treturn s.halted
+    }
+
+    // Advance moves a system running on a VirtualClock forward by d
+    func (s *System) Advance(d time.Duration) {
+    \ts.clock.(*VirtualClock).Advance(d)
+    }
+
+    // SetPriority ranks an actor's messages and timers under the Priority
+    // policy of a VirtualClock; higher runs first and actors default to zero
+    func (s *System) SetPriority(name string, priority int) error {
+    \ts.mu.Lock()
+    \ta, ok := s.actors[name]
+    \ts.mu.Unlock()
+    \tif !ok {
+    \t\treturn fmt.Errorf("unknown actor %q", name)
+    \t}
+    \tclock, ok := s.clock.(*VirtualClock)
+    \tif !ok {
+    \t\treturn fmt.Errorf("priorities need a VirtualClock")
+    \t}
+    \tclock.setPriority(a, priority)
+    \ts.record(func(s *System) { s.SetPriority(name, priority) })
+    \treturn nil
+    }
+
+    // Select returns the sorted names of the actors carrying every label in
+    // selector, so control-plane operations can target a group of actors
+    // An empty selector selects every actor
+    func (s *System) Select(selector map[string]string) []string {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \tnames := []string{}
+    \tfor name, a := range s.actors {
+    \t\tif hasLabels(a.Labels(), selector) {
+    \t\t\tnames = append(names, name)
+    \t\t}
+    \t}
+    \tsort.Strings(names)
+    \treturn names
+    }
+
+    // Float64 returns the next number from the seeded RNG
+    // Safe to call from any actor
+    func (s *System) Float64() float64 {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \treturn s.rng.Float64()
+    }
+
+    // Stream returns the draws of an RNG of its own, seeded with a hash of the
+    // system's seed and name, such as "loss/client/server" for the loss on
+    // that edge: a stream is as reproducible as the seed, yet drawing more or
+    // fewer numbers from one never shifts what another draws, so tuning one
+    // actor's loss leaves every other actor's losses and delays as they were
+    // Safe to call from any actor
+    func (s *System) Stream(name string) func() float64 {
+    \th := fnv.New64a()
+    \tfmt.Fprintf(h, "%d/%s", s.seed, name)
+    \trng := rand.New(rand.NewSource(int64(h.Sum64())))
+    \tvar mu sync.Mutex
+    \treturn func() float64 {
+    \t\tmu.Lock()
+    \t\tdefer mu.Unlock()
+    \t\treturn rng.Float64()
+    \t}
+    }
+
+    // Pending returns the number of messages in flight or queued behind a
+    // busy actor, plus one-shot timers that have not fired yet
+    // Periodic timers are left out since they never run out
+    func (s *System) Pending() int {
+    \tn := int(s.inflight.Load())
+    #{queue_depths}\treturn n
+    }
+
+    // send delivers a message from one actor to another, along with the
+    // header of the message the sender is handling
+    func (s *System) send(from, to phony.Actor, f func()) {
+    \tif s.cut(from, to) {
+    \t\treturn
+    \t}
+    \th := from.(contextual).context().header
+    \th.from = sentBy(from, to, h)
+    \th.enqueued = s.clock.Now()
+    #{step}\ts.deliver(from, to, h, f)
+    }
+
+    // sentBy names the actor a message from one actor to another comes from:
+    // an actor handing a message on to one of its inboxes passes on the name
+    // of the one that sent it
+    func sentBy(from, to phony.Actor, h header) string {
+    \tname := from.(contextual).context().name
+    \tif from != to && name == to.(contextual).context().name {
+    \t\treturn h.from
+    \t}
+    \treturn name
+    }
+
+    // deliver hands a message under h from one actor to another, which
+    // handles it with f, unless it outlives its TTL on the way
+    // Under a VirtualClock each delivery becomes an event, so the whole run
+    // is ordered by virtual time instead of goroutine scheduling
+    func (s *System) deliver(from, to phony.Actor, h header, f func()) {
+    \ts.inflight.Add(1)
+    \ts.ledger.inflight.Add(1)
+    \tc := to.(contextual).context()
+    \tc.expect(1)
+    \tepoch := c.epoch.Load()
+    \tdeliver := func() {
+    \t\ts.ledger.inflight.Add(-1)
+    \t\tc.expect(-1)
+    \t\tif c.crashed(epoch) {
+    \t\t\ts.lose(to, h)
+    \t\t\ts.inflight.Add(-1)
+    \t\t\treturn
+    \t\t}
+    \t\tif h.expired(s.clock.Now()) {
+    \t\t\ts.ledger.expired.Add(1)
+    \t\t\ts.inflight.Add(-1)
+    \t\t\treturn
+    \t\t}
+    \t\tc.header = h
+    \t\tstart := s.clock.Now()
+    \t\tc.arrived(start)
+    \t\tf()
+    \t\tif _, ok := to.(serving); !ok {
+    \t\t\tc.handled(h.enqueued, start, s.clock.Now())
+    \t\t}
+    \t\ts.inflight.Add(-1)
+    \t}
+    \tif s.virtual {
+    \t\ts.scheduleRanked(to, s.ranks[h.id.Source], h.Priority, 0, func() { phony.Block(to, deliver) })
+    \t\treturn
+    \t}
+    \tto.Act(from, s.stopper.track(deliver))
+    }
+
+    // run executes f on an actor's inbox on behalf of a timer
+    func (s *System) run(to phony.Actor, f func()) {
+    \tif s.virtual {
+    \t\tphony.Block(to, f)
+    \t\treturn
+    \t}
+    \tto.Act(nil, s.stopper.track(f))
+    }
+
+    // after runs f on an actor once d has elapsed
+    func (s *System) after(to phony.Actor, d time.Duration, f func()) Timer {
+    \treturn s.afterRanked(to, 0, 0, d, f)
+    }
+
+    // afterRanked is after for a message from the source of rank, under the
+    // priority its header gives it
+    func (s *System) afterRanked(to phony.Actor, rank, priority int, d time.Duration, f func()) Timer {
+    \ts.inflight.Add(1)
+    \ttimer := s.scheduleRanked(to, rank, priority, d, func() {
+    \t\ts.run(to, func() {
+    \t\t\tf()
+    \t\t\ts.inflight.Add(-1)
+    \t\t})
+    \t})
+    \treturn &pendingTimer{Timer: timer, sys: s}
+    }
+
+    // sendAfter delivers a message from one actor to another once d has
+    // elapsed
+    func (s *System) sendAfter(from, to phony.Actor, d time.Duration, f func()) {
+    \tif s.cut(from, to) {
+    \t\treturn
+    \t}
+    \ts.ledger.inflight.Add(1)
+    \th := from.(contextual).context().header
+    \th.from = sentBy(from, to, h)
+    \th.enqueued = s.clock.Now() + d
+    #{delayed_step}\tc := to.(contextual).context()
+    \tepoch := c.epoch.Load()
+    \ts.afterRanked(to, s.ranks[h.id.Source], h.Priority, d, func() {
+    \t\ts.ledger.inflight.Add(-1)
+    \t\tif c.crashed(epoch) {
+    \t\t\ts.lose(to, h)
+    \t\t\treturn
+    \t\t}
+    \t\tif h.expired(s.clock.Now()) {
+    \t\t\ts.ledger.expired.Add(1)
+    \t\t\treturn
+    \t\t}
+    \t\tc.header = h
+    \t\tstart := s.clock.Now()
+    \t\tc.arrived(start)
+    \t\tf()
+    \t\tif _, ok := to.(serving); !ok {
+    \t\t\tc.handled(h.enqueued, start, s.clock.Now())
+    \t\t}
+    \t})
+    }
+
+    // every runs f on an actor each time interval elapses
+    // Reconfigure can change the interval, which applies from the next tick
+    func (s *System) every(to phony.Actor, interval time.Duration, f func()) {
+    \ts.everyAfter(to, interval, interval, f)
+    }
+
+    // everyAfter runs f on an actor once first has elapsed, then each time
+    // interval elapses, so actors sharing an interval can fire out of step
+    func (s *System) everyAfter(to phony.Actor, first, interval time.Duration, f func()) {
+    \tt := &ticker{}
+    \tt.interval.Store(int64(interval))
+    \ts.mu.Lock()
+    \ts.tickers[to] = t
+    \ts.mu.Unlock()
+    \t
+    \tvar tick func()
+    \ttick = func() {
+    \t\ts.schedule(to, time.Duration(t.interval.Load()), tick)
+    \t\ts.run(to, f)
+    \t}
+    \ts.schedule(to, first, tick)
+    }
+
+    // watch runs f on an actor at the end of every window of clock time
+    // Unlike every it leaves the actor's ticker alone, and unlike after it
+    // isn't pending work, so a watched system still settles
+    func (s *System) watch(to phony.Actor, window time.Duration, f func()) {
+    \tvar tick func()
+    \ttick = func() {
+    \t\ts.schedule(to, window, tick)
+    \t\ts.run(to, f)
+    \t}
+    \ts.schedule(to, window, tick)
+    }
+
+    // schedule runs f once d has elapsed on behalf of owner, so a VirtualClock
+    // can order it against other timers due at the same instant
+    func (s *System) schedule(owner phony.Actor, d time.Duration, f func()) Timer {
+    \treturn s.scheduleRanked(owner, 0, 0, d, f)
+    }
+
+    // scheduleRanked is schedule for a message from the source of rank, the
+    // position of the actor that originated it in the declaration order, and
+    // of the priority its header gives it
+    func (s *System) scheduleRanked(owner phony.Actor, rank, priority int, d time.Duration, f func()) Timer {
+    \tif s.virtual {
+    \t\treturn s.clock.(*VirtualClock).scheduleRanked(owner, rank, priority, d, f)
+    \t}
+    \treturn s.stopper.after(s.clock, d, func() {
+    \t\t<-s.started
+    \t\tif !s.stopped.Load() {
+    \t\t\tf()
+    \t\t}
+    \t})
+    }
+
+    // hasLabels reports whether labels include every key and value in selector
+    func hasLabels(labels, selector map[string]string) bool {
+    \tfor key, value := range selector {
+    \t\tif labels[key] != value {
+    \t\t\treturn false
+    \t\t}
+    \t}
+    \treturn true
+    }
+
+    // header is what a message carries from actor to actor besides its kind:
+    // the Headers it travels under, its ID, its key, which is its sequence
+    // number at the source that produced it, when it was produced, when it
+    // reached the inbox of the actor handling it, the actor that sent it
+    // there, empty for one the actor produced or that came from outside, and
+    // the payload of the Envelope it came in, if any
+    type header struct {
+    \tHeaders
+    \tid MessageID
+    \tkey uint64
+    \tborn time.Duration
+    \tenqueued time.Duration
+    \tfrom string
+    \tpayload any
+    #{path_field}}
+
+    // messageContext holds the name of an actor, the header of the message
+    // it is handling, the time the messages it originates have until their
+    // deadline, what the report sums up of the messages that have arrived and
+    // been handled, how long the callback of the current one slept, whether
+    // the actor is down after a crash, how many times it has crashed, the
+    // partition group it is in, the meter recording its metrics and the budget
+    // of restarts the supervisor allows it, if any
+    // Only the actor's own inbox touches it, apart from the atomic fields
+    type messageContext struct {
+    \tname string
+    \theader header
+    \tbudget time.Duration
+    \tproduced uint64
+    \tdelivered int
+    \tlatency latencies
+    \tqueueTime latencies
+    \tserviceTime latencies
+    \tslept time.Duration
+    \tdown bool
+    \tinbound atomic.Int64
+    \tpeak atomic.Int64
+    \tgroup atomic.Int32
+    \tepoch atomic.Uint64
+    \tmeter *meter
+    \trestarts *restartBudget
+    }
+
+    func (c *messageContext) context() *messageContext {
+    \treturn c
+    }
+
+    // contextual is implemented by every generated actor
+    type contextual interface {
+    \tcontext() *messageContext
+    }
+
+    // serving is implemented by actors with a fair queue, which time the
+    // messages they serve themselves
+    type serving interface {
+    \tserveNext()
+    }
+
+    // ticker holds the interval of a periodic timer, read each time it fires
+    type ticker struct {
+    \tinterval atomic.Int64
+    }
+
+    // pendingTimer stops counting a one-shot timer as pending once it is
+    // cancelled
+    type pendingTimer struct {
+    \tTimer
+    \tsys *System
+    }
+
+    func (t *pendingTimer) Stop() bool {
+    \tif !t.Timer.Stop() {
+    \t\treturn false
+    \t}
+    \tt.sys.inflight.Add(-1)
+    \treturn true
+    }
+
+    // stopper keeps what Stop cancels and waits for on a RealClock: the timers
+    // pending on the clock, and the timers firing and messages in flight
+    type stopper struct {
+    \tmu sync.Mutex
+    \tstopped bool
+    \tpending map[*realTimer]struct{}
+    \trunning sync.WaitGroup
+    }
+
+    // after runs f once d has elapsed on clock, unless stop comes first
+    func (st *stopper) after(clock Clock, d time.Duration, f func()) Timer {
+    \tst.mu.Lock()
+    \tdefer st.mu.Unlock()
+    \tif st.stopped {
+    \t\treturn stoppedTimer{}
+    \t}
+    \tif st.pending == nil {
+    \t\tst.pending = map[*realTimer]struct{}{}
+    \t}
+    \tt := &realTimer{stopper: st}
+    \tst.pending[t] = struct{}{}
+    \tst.running.Add(1)
+    \tt.Timer = clock.AfterFunc(d, func() {
+    \t\tdefer st.running.Done()
+    \t\tst.forget(t)
+    \t\tf()
+    \t})
+    \treturn t
+    }
+
+    // track counts f as under way until it has run
+    func (st *stopper) track(f func()) func() {
+    \tst.running.Add(1)
+    \treturn func() {
+    \t\tdefer st.running.Done()
+    \t\tf()
+    \t}
+    }
+
+    // forget stops counting t as pending
+    func (st *stopper) forget(t *realTimer) {
+    \tst.mu.Lock()
+    \tdelete(st.pending, t)
+    \tst.mu.Unlock()
+    }
+
+    // stop cancels the timers still pending, lets no timer start after it and
+    // waits for whatever is under way, including what that sets off in turn
+    func (st *stopper) stop() {
+    \tst.mu.Lock()
+    \tst.stopped = true
+    \tpending := st.pending
+    \tst.pending = nil
+    \tst.mu.Unlock()
+    \tfor t := range pending {
+    \t\tt.Stop()
+    \t}
+    \tst.running.Wait()
+    }
+
+    // realTimer is a timer on a RealClock that Stop can cancel
+    type realTimer struct {
+    \tTimer
+    \tstopper *stopper
+    }
+
+    func (t *realTimer) Stop() bool {
+    \tif !t.Timer.Stop() {
+    \t\treturn false
+    \t}
+    \tt.stopper.forget(t)
+    \tt.stopper.running.Done()
+    \treturn true
+    }
+
+    // stoppedTimer is the timer a RealClock gives once Stop has run: it never
+    // fires
+    type stoppedTimer struct{}
+
+    func (stoppedTimer) Stop() bool { return false }
+    """ <> reply_method
+  end
+
+  defp generate_wiring(_field, _name, _definition, []), do: ""
+
+  defp generate_wiring(field, name, definition, targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    target_list = Enum.map_join(targets, ", ", &"s.#{GeneratorUtils.to_camel_case(&1)}")
+
+    loss_code =
+      if definition.loss do
+        models = Enum.map_join(targets, ", ", &loss_model(definition, &1))
+        "\t#{field}.loss = []*GilbertElliott{#{models}}\n"
+      else
+        ""
+      end
+
+    delay_code =
+      if definition.delay do
+        delays = Enum.map_join(targets, ", ", &delay_model(definition, &1))
+        "\t#{field}.delay = []Delay{#{delays}}\n"
+      else
+        ""
+      end
+
+    fallback_code = generate_fallback_setup(field, definition)
+
+    breaker_code =
+      if circuit_breaker(definition) do
+        breakers = Enum.map_join(targets, ", ", fn _target -> new_breaker(definition, "s") end)
+        "\t#{field}.breakers = []*Breaker{#{breakers}}\n"
+      else
+        ""
+      end
+
+    delivery_code =
+      if at_least_once?(definition) do
+        retries = Enum.map_join(targets, ", ", &retry_interval(definition, &1))
+        "\t#{field}.retry = []time.Duration{#{retries}}\n"
+      else
+        ""
+      end
+
+    # A weight schedule names the targets it weighs, so it goes with them
+    router_code =
+      case routing(definition) do
+        :weighted ->
+          schedules = weight_schedule(definition)
+
+          entries =
+            Enum.map_join(schedules, ", ", fn {target, steps} ->
+              steps =
+                Enum.map_join(steps, ", ", fn {at, w} ->
+                  "{At: #{at} * time.Millisecond, Weight: #{w}}"
+                end)
+
+              "s.#{GeneratorUtils.to_camel_case(target)}: {#{steps}}"
+            end)
+
+          "\t#{field}.router.schedules = map[phony.Actor]WeightSchedule{#{entries}}\n"
+
+        _strategy ->
+          ""
+      end
+
+    "\t#{field}.targets = []#{type_name}Target{#{target_list}}\n" <>
+      loss_code <> delay_code <> fallback_code <> breaker_code <> delivery_code <> router_code
+  end
+
+  # The actor a request falls back to is wired like a target, once every
+  # actor exists
+  defp generate_fallback_setup(field, %{timeout: timeout, fallback: fallback})
+       when timeout != nil,
+       do: "\t#{field}.fallback = s.#{GeneratorUtils.to_camel_case(fallback)}\n"
+
+  defp generate_fallback_setup(_field, _definition), do: ""
+
+  # What an actor keeps about its edges, apart from the edges themselves,
+  # which it starts without
+  defp generate_edge_settings(field, definition) do
+    timeout_code =
+      if definition.timeout, do: "\t#{field}.pending = map[uint64]Timer{}\n", else: ""
+
+    delivery_code =
+      if at_least_once?(definition), do: "\t#{field}.unacked = map[uint64]Timer{}\n", else: ""
+
+    router_code =
+      case routing(definition) do
+        :round_robin ->
+          "\t#{field}.router.strategy = RouteRoundRobin\n"
+
+        :random ->
+          "\t#{field}.router.strategy = RouteRandom\n" <>
+            "\t#{field}.router.draw = s.Stream(\"route/#{definition.name}\")\n"
+
+        _strategy ->
+          ""
+      end
+
+    affinity_code =
+      case affinity(definition) do
+        nil ->
+          ""
+
+        {within, sessions} ->
+          "\t#{field}.affinity = affinity{window: #{within} * time.Millisecond, " <>
+            "sessions: #{sessions}}\n"
+      end
+
+    timeout_code <> delivery_code <> router_code <> affinity_code
+  end
+
+  # NewSystem and Reconfigure both create actors through their kind's
+  # constructor, which sets up everything the DSL gives an actor but its
+  # edges, so a spawned actor is set up like the one it is spawned like
+  defp generate_constructor(name, definition, messages) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    settings =
+      generate_edge_settings("a", definition) <>
+        generate_deadline_setup("a", definition) <>
+        generate_restart_setup("a", definition) <>
+        generate_queue_setup("a", name, definition, messages) <>
+        generate_join_setup("a", definition) <>
+        generate_observe_setup("a", definition) <>
+        generate_shard_setup("a", name, definition, messages)
+
+    """
+
+    // new#{type_name} creates a #{type_name} with its DSL settings but no edges
+    func new#{type_name}(s *System) *#{type_name} {
+    \ta := &#{type_name}{sys: s}
+    #{settings}\treturn a
+    }
+    """
+  end
+
+  defp new_breaker(definition, sys) do
+    case circuit_breaker(definition) do
+      nil ->
+        nil
+
+      %{threshold: threshold, cooldown: cooldown} ->
+        "NewBreaker(#{threshold}, #{cooldown} * time.Millisecond, #{sys}.clock.Now)"
+    end
+  end
+
+  # Failures are requests that time out, so a breaker needs a timeout
+  defp circuit_breaker(%{circuit_breaker: nil}), do: nil
+
+  defp circuit_breaker(%{name: name, circuit_breaker: opts, timeout: timeout})
+       when is_list(opts) and timeout != nil do
+    threshold = Keyword.get(opts, :threshold)
+    cooldown = Keyword.get(opts, :cooldown)
+
+    unless is_integer(threshold) and threshold > 0 and is_integer(cooldown) and cooldown > 0 do
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid circuit_breaker #{inspect(opts)}, " <>
+              "expected a positive threshold and cooldown in milliseconds"
+    end
+
+    %{threshold: threshold, cooldown: cooldown}
+  end
+
+  defp circuit_breaker(%{name: name, circuit_breaker: opts}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has circuit_breaker #{inspect(opts)} but no timeout; " <>
+            "expected [threshold: n, cooldown: ms] on an actor with timeout: and fallback:"
+  end
+
+  defp generate_queue_setup(_field, _name, %{fair_queue: nil}, _messages), do: ""
+
+  # Message kinds without a weight get weight 1
+  defp generate_queue_setup(field, name, definition, messages) do
+    Enum.each(definition.fair_queue, fn {msg, weight} ->
+      unless msg in messages do
+        raise ArgumentError,
+              "actor #{inspect(name)} weights #{inspect(msg)}, which it never receives"
+      end
+
+      unless is_integer(weight) and weight > 0 do
+        raise ArgumentError,
+              "actor #{inspect(name)} has fair queue weight #{inspect(weight)} for " <>
+                "#{inspect(msg)}; weights must be positive integers"
+      end
+    end)
+
+    "\t#{field}.queue = #{new_fair_queue(definition, messages)}\n"
+  end
+
+  defp generate_deadline_setup(field, definition) do
+    case deadline(definition) do
+      nil -> ""
+      ms -> "\t#{field}.budget = #{ms} * time.Millisecond\n"
+    end
+  end
+
+  defp generate_restart_setup(field, definition) do
+    case restart_budget(definition) do
+      nil ->
+        ""
+
+      {max, within} ->
+        "\t#{field}.restarts = &restartBudget{max: #{max}, within: #{within} * time.Millisecond}\n"
+    end
+  end
+
+  defp generate_join_setup(_field, %{join_by: nil}), do: ""
+
+  defp generate_join_setup(field, definition) do
+    "\t#{field}.join = #{new_join_window(definition, field)}\n"
+  end
+
+  defp new_join_window(definition, field) do
+    "newJoinWindow(s, #{field}, #{join_window(definition).within} * time.Millisecond)"
+  end
+
+  defp generate_observe_setup(field, definition) do
+    case observe(definition) do
+      nil -> ""
+      capacity -> "\t#{field}.received = make(chan Msg, #{capacity})\n"
+    end
+  end
+
+  # Each inbox of a sharded actor has its own edges and queue, set up like
+  # the actor's, and records into the actor's channel
+  defp generate_shard_setup(field, name, definition, messages) do
+    case parallelism(definition) do
+      nil ->
+        ""
+
+      inboxes ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+        received = if observe(definition), do: "\tshard.received = #{field}.received\n", else: ""
+
+        setup =
+          (generate_edge_settings("shard", definition) <>
+             generate_queue_setup("shard", name, definition, messages) <> received)
+          |> indent()
+
+        """
+        \t#{field}.shards = make([]*#{type_name}, #{inboxes})
+        \tfor i := range #{field}.shards {
+        \t\tshard := &#{type_name}{sys: s}
+        #{setup}\t\t#{field}.shards[i] = shard
+        \t}
+        """
+    end
+  end
+
+  # Each inbox of a sharded actor has the actor's edges
+  defp generate_shard_wiring(field, name, definition, targets) do
+    case {parallelism(definition), generate_wiring("shard", name, definition, targets)} do
+      {nil, _wiring} ->
+        ""
+
+      {_inboxes, ""} ->
+        ""
+
+      {_inboxes, wiring} ->
+        "\tfor _, shard := range #{field}.shards {\n#{indent(wiring)}\t}\n"
+    end
+  end
+
+  # Nests lines of Go code one block deeper
+  defp indent(code) do
+    code
+    |> String.split("\n", trim: true)
+    |> Enum.map_join(&"\t#{&1}\n")
+  end
+
+  # A source's messages start on its own timers and a join needs both
+  # streams in one inbox, so neither can be spread over several
+  defp parallelism(%{parallelism: nil}), do: nil
+
+  defp parallelism(%{parallelism: inboxes, send_pattern: nil, schedule_file: nil, join_by: nil})
+       when is_integer(inboxes) and inboxes > 0,
+       do: inboxes
+
+  defp parallelism(%{name: name, parallelism: inboxes}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid parallelism #{inspect(inboxes)}, " <>
+            "expected a positive number of inboxes on an actor without a send pattern, " <>
+              "schedule_file or join_by"
+  end
+
+  defp idempotency(%{idempotent_by: nil}), do: nil
+  defp idempotency(%{idempotent_by: :id, parallelism: nil}), do: :id
+
+  defp idempotency(%{name: name, idempotent_by: key}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid idempotent_by #{inspect(key)}, " <>
+            "expected :id on an actor without parallelism"
+  end
+
+  defp probe?(%{probe: probe}) when probe in [nil, false], do: false
+  defp probe?(%{probe: true, parallelism: nil}), do: true
+
+  defp probe?(%{name: name, probe: probe}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid probe #{inspect(probe)}, " <>
+            "expected a boolean on an actor without parallelism"
+  end
+
+  defp transforms(%{transforms: nil}), do: []
+
+  defp transforms(%{name: name, transforms: transforms, targets: targets} = definition) do
+    valid? =
+      Keyword.keyword?(transforms) and transforms != [] and not probe?(definition) and
+        Enum.all?(transforms, fn {target, steps} ->
+          target in targets and is_list(steps) and steps != [] and
+            Enum.all?(steps, &transform_step?/1)
+        end)
+
+    if valid? do
+      transforms
+    else
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid transforms #{inspect(transforms)}, " <>
+              "expected [target: [filter: name, map: name, ...]] on edges to its targets"
+    end
+  end
+
+  defp transform_step?({kind, step}) when kind in [:filter, :map], do: is_atom(step)
+  defp transform_step?(_step), do: false
+
+  # Every filter and map an actor's edges apply, each named once
+  defp transform_steps(definition) do
+    definition
+    |> transforms()
+    |> Enum.flat_map(fn {_target, steps} -> steps end)
+    |> Enum.uniq()
+  end
+
+  # The replies an actor sends back to the senders of its requests, as
+  # [request kind: reply kind]
+  defp replies(%{replies: nil}), do: []
+
+  defp replies(%{name: name, replies: replies} = definition) do
+    valid? =
+      Keyword.keyword?(replies) and replies != [] and not probe?(definition) and
+        definition.join_by == nil and Enum.all?(replies, fn {_kind, reply} -> is_atom(reply) end)
+
+    if valid? do
+      replies
+    else
+      raise ArgumentError,
+            "actor #{inspect(name)} has invalid replies #{inspect(replies)}, " <>
+              "expected [request: reply] on an actor that is no probe or join"
+    end
+  end
+
+  # The reply a message's callback computes, when the actor replies to it
+  # with a typed one
+  defp typed_reply(definition, msg, types) do
+    case List.keyfind(replies(definition), msg, 0) do
+      {_kind, reply} -> if Map.has_key?(types, to_string(reply)), do: reply
+      nil -> nil
+    end
+  end
+
+  # What a message's callback returns: its error, and the payload of a typed
+  # reply along with it
+  defp callback_result(definition, msg, types) do
+    case typed_reply(definition, msg, types) do
+      nil -> "error"
+      reply -> "(#{GeneratorUtils.to_pascal_case(reply)}, error)"
+    end
+  end
+
+  defp callback_return(definition, msg, types) do
+    case typed_reply(definition, msg, types) do
+      nil -> "return nil"
+      reply -> "return #{GeneratorUtils.to_pascal_case(reply)}{}, nil"
+    end
+  end
+
+  defp transform_method({:filter, step}), do: "Filter#{GeneratorUtils.to_pascal_case(step)}"
+  defp transform_method({:map, step}), do: "Map#{GeneratorUtils.to_pascal_case(step)}"
+
+  defp reorder(%{reorder_by: nil}), do: nil
+
+  defp reorder(%{reorder_by: :seq, fair_queue: nil, join_by: nil, parallelism: nil}),
+    do: :seq
+
+  defp reorder(%{name: name, reorder_by: key}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid reorder_by #{inspect(key)}, " <>
+            "expected :seq on an actor without fair_queue, join_by or parallelism"
+  end
+
+  # A probe records what passes it instead of calling back
+  defp callbacks?(definition, enable_callbacks), do: enable_callbacks and not probe?(definition)
+
+  defp observe(%{observe: nil}), do: nil
+  defp observe(%{observe: capacity}) when is_integer(capacity) and capacity > 0, do: capacity
+
+  defp observe(%{name: name, observe: observe}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid observe #{inspect(observe)}, " <>
+            "expected the capacity of its Received buffer"
+  end
+
+  defp new_fair_queue(definition, messages) do
+    weights = Enum.map_join(messages, ", ", &Keyword.get(definition.fair_queue, &1, 1))
+    "NewFairQueue(#{weights})"
+  end
+
+  defp uses_breaker?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.circuit_breaker != nil end)
+  end
+
+  # A schedule takes the place of a send pattern. The file is read when
+  # generating, to learn the messages the actor originates.
+  defp schedule(%{schedule_file: nil}), do: nil
+
+  defp schedule(%{name: name, schedule_file: path, send_pattern: nil}) when is_binary(path) do
+    case File.read(path) do
+      {:ok, csv} ->
+        %{csv: csv, entries: parse_schedule(name, path, csv)}
+
+      {:error, reason} ->
+        raise ArgumentError,
+              "actor #{inspect(name)} can't read schedule_file #{inspect(path)}: " <>
+                List.to_string(:file.format_error(reason))
+    end
+  end
+
+  defp schedule(%{name: name, schedule_file: path}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has invalid schedule_file #{inspect(path)}, " <>
+            "expected the path of a CSV file on an actor without a send pattern"
+  end
+
+  # One at_ms,message entry per line, as ParseSchedule reads them; a first
+  # line that is not an entry is a header
+  defp parse_schedule(name, path, csv) do
+    csv
+    |> String.split(["\r\n", "\n"])
+    |> Enum.with_index(1)
+    |> Enum.reject(fn {line, _number} -> String.trim(line) == "" end)
+    |> Enum.map(fn {line, number} -> {schedule_entry(line), line, number} end)
+    |> case do
+      [{nil, _line, _number} | entries] -> entries
+      entries -> entries
+    end
+    |> Enum.map(fn
+      {nil, line, number} ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has an invalid entry on line #{number} of #{path}: " <>
+                "expected at_ms,message, got #{inspect(line)}"
+
+      {entry, _line, _number} ->
+        entry
+    end)
+  end
+
+  defp schedule_entry(line) do
+    with [at, message] <- line |> String.split(",") |> Enum.map(&String.trim/1),
+         {at_ms, ""} when at_ms >= 0 <- Float.parse(at),
+         true <- message =~ ~r/^[A-Za-z_][A-Za-z0-9_]*$/ do
+      {at_ms, message}
+    else
+      _ -> nil
+    end
+  end
+
+  # The messages an actor originates, from its send pattern or schedule
+  defp originated_messages(%{kafka_source: source} = definition) when source != nil,
+    do: [kafka_source(definition).message]
+
+  defp originated_messages(definition) do
+    case schedule(definition) do
+      nil -> GeneratorUtils.extract_messages(definition.send_pattern)
+      %{entries: entries} -> entries |> Enum.map(&String.to_atom(elem(&1, 1))) |> Enum.uniq()
+    end
+  end
+
+  defp uses_schedule?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.schedule_file != nil end)
+  end
+
+  defp uses_observe?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.observe != nil end)
+  end
+
+  defp uses_dead_letters?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> dlq_retry(definition) != nil end)
+  end
+
+  defp uses_alarms?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> alarm(definition) != nil end)
+  end
+
+  defp uses_ramps?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> ramp(definition) != nil end)
+  end
+
+  defp uses_affinity?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> affinity(definition) != nil end)
+  end
+
+  defp uses_routing?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> routing(definition) != :broadcast end)
+  end
+
+  defp uses_sizes?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> size(definition) != nil end)
+  end
+
+  defp uses_reorder?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> reorder(definition) != nil end)
+  end
+
+  defp uses_transforms?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> transforms(definition) != [] end)
+  end
+
+  defp uses_kafka?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} ->
+      kafka_source(definition) != nil or kafka_sink(definition) != nil
+    end)
+  end
+
+  defp uses_acks?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> ack_path(definition) != nil end)
+  end
+
+  defp uses_replies?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> replies(definition) != [] end)
+  end
+
+  defp uses_join?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.join_by != nil end)
+  end
+
+  defp uses_fair_queue?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.fair_queue != nil end)
+  end
+
+  defp uses_timeout?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.timeout != nil end)
+  end
+
+  defp generate_reply_method do
+    """
+
+    // reply answers a request once the replying actor's service time
+    // has elapsed
+    // Safe to call from any actor, even while Reconfigure spawns one
+    func (s *System) reply(from, to phony.Actor, f func()) {
+    \ts.mu.Lock()
+    \td := s.serviceTime[from]
+    \ts.mu.Unlock()
+    \ts.after(to, d, f)
+    }
+    """
+  end
+
+  defp generate_service_times(actors) do
+    service_times =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.filter(fn {_name, definition} -> definition.service_time end)
+      |> Enum.map_join(", ", fn {name, definition} ->
+        "s.#{GeneratorUtils.to_camel_case(name)}: #{definition.service_time} * time.Millisecond"
+      end)
+
+    "\ts.serviceTime = map[phony.Actor]time.Duration{#{service_times}}\n"
+  end
+
+  # A single model applies to every outgoing edge (each edge keeps its own
+  # state); a keyword list configures selected edges only.
+  defp loss_model(definition, target) do
+    case edge_loss(definition, target) do
+      nil ->
+        "nil"
+
+      {:gilbert_elliott, params} ->
+        args =
+          [
+            Keyword.fetch!(params, :p_good_to_bad),
+            Keyword.fetch!(params, :p_bad_to_good),
+            Keyword.get(params, :loss_good, 0.0),
+            Keyword.get(params, :loss_bad, 1.0)
+          ]
+          |> Enum.map(&validate_probability(&1, definition.name))
+          |> Enum.map_join(", ", &to_string/1)
+
+        "NewGilbertElliott(s.Stream(\"loss/#{definition.name}/#{target}\"), #{args})"
+    end
+  end
+
+  defp edge_loss(%{loss: nil}, _target), do: nil
+  defp edge_loss(%{loss: {:gilbert_elliott, _params} = model}, _target), do: model
+  defp edge_loss(%{loss: per_target}, target) when is_list(per_target),
+    do: Keyword.get(per_target, target)
+
+  defp lossy_edge?(definition, target), do: edge_loss(definition, target) != nil
+
+  # An at-least-once edge waits for acknowledgements, which would race the
+  # replies a timeout waits for
+  defp at_least_once?(%{delivery: nil}), do: false
+  defp at_least_once?(%{timeout: nil}), do: true
+
+  defp at_least_once?(%{name: name}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} has both delivery: and timeout:; an at-least-once edge " <>
+            "resends to its target instead of falling back"
+  end
+
+  # Like loss, one delivery applies to every outgoing edge and a keyword list
+  # to selected edges; best-effort edges retry after 0ms, meaning never
+  defp edge_retry(%{delivery: per_target} = definition, target) when is_list(per_target),
+    do: retry_ms(definition.name, Keyword.get(per_target, target))
+
+  defp edge_retry(definition, _target), do: retry_ms(definition.name, definition.delivery)
+
+  defp retry_ms(_actor, nil), do: 0
+  defp retry_ms(_actor, :at_least_once), do: 100
+  defp retry_ms(_actor, {:at_least_once, [retry: ms]}) when is_integer(ms) and ms > 0, do: ms
+
+  defp retry_ms(actor, delivery) do
+    raise ArgumentError,
+          "actor #{inspect(actor)} has invalid delivery #{inspect(delivery)}, expected " <>
+            ":at_least_once or {:at_least_once, retry: ms}"
+  end
+
+  defp retry_interval(definition, target) do
+    case edge_retry(definition, target) do
+      0 -> "0"
+      ms -> "#{ms} * time.Millisecond"
+    end
+  end
+
+  defp validate_probability(p, _actor) when is_number(p) and p >= 0 and p <= 1, do: p
+
+  defp validate_probability(p, actor) do
+    raise ArgumentError,
+          "actor #{inspect(actor)} has loss probability #{inspect(p)} outside 0.0..1.0"
+  end
+
+  # Like loss, a single distribution applies to every outgoing edge and a
+  # keyword list to selected edges; the others get no delay.
+  defp delay_model(definition, target) do
+    ms = &"#{validate_delay_ms(&1, definition.name)} * time.Millisecond"
+    draw = "s.Stream(\"delay/#{definition.name}/#{target}\")"
+
+    case edge_delay(definition, target) do
+      nil ->
+        "ConstantDelay(0)"
+
+      {:constant, delay} ->
+        "ConstantDelay(#{ms.(delay)})"
+
+      {:uniform, low, high} when low > high ->
+        raise ArgumentError,
+              "actor #{inspect(definition.name)} has uniform delay from #{low} to #{high}ms; " <>
+                "the minimum must not exceed the maximum"
+
+      {:uniform, low, high} ->
+        "NewUniformDelay(#{draw}, #{ms.(low)}, #{ms.(high)})"
+
+      {:exponential, mean} ->
+        "NewExponentialDelay(#{draw}, #{ms.(mean)})"
+
+      {:normal, mean, stddev} ->
+        "NewNormalDelay(#{draw}, #{ms.(mean)}, #{ms.(stddev)})"
+
+      other ->
+        raise ArgumentError,
+              "actor #{inspect(definition.name)} has unsupported delay #{inspect(other)}; " <>
+                "expected {:constant, ms}, {:uniform, min_ms, max_ms}, " <>
+                "{:exponential, mean_ms} or {:normal, mean_ms, stddev_ms}"
+    end
+  end
+
+  defp edge_delay(%{delay: nil}, _target), do: nil
+  defp edge_delay(%{delay: per_target}, target) when is_list(per_target),
+    do: Keyword.get(per_target, target)
+
+  defp edge_delay(%{delay: delay}, _target), do: delay
+
+  defp delayed_edge?(definition, target), do: edge_delay(definition, target) != nil
+
+  defp validate_delay_ms(ms, _actor) when is_integer(ms) and ms >= 0, do: ms
+
+  defp validate_delay_ms(ms, actor) do
+    raise ArgumentError,
+          "actor #{inspect(actor)} has delay #{inspect(ms)}; delays must be " <>
+            "non-negative integer milliseconds"
+  end
+
+  # Labels sorted by key, with keys and values as strings
+  defp labels(%{labels: nil}), do: []
+
+  defp labels(%{name: name, labels: labels}) when is_map(labels) or is_list(labels) do
+    labels
+    |> Enum.map(fn
+      {key, value}
+      when (is_atom(key) or is_binary(key)) and
+             (is_atom(value) or is_binary(value) or is_number(value)) ->
+        {to_string(key), to_string(value)}
+
+      label ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid label #{inspect(label)}, expected key: value"
+    end)
+    |> Enum.sort()
+  end
+
+  defp labels(%{name: name, labels: labels}) do
+    raise ArgumentError,
+          "actor #{inspect(name)} labels must be a map or keyword list, got #{inspect(labels)}"
+  end
+
+  defp go_string(value), do: "\"" <> String.replace(value, ["\\", "\""], &("\\" <> &1)) <> "\""
+
+  defp uses_delay?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.delay != nil end)
+  end
+
+  defp uses_loss?(actors) do
+    actors
+    |> GeneratorUtils.simulated_actors()
+    |> Enum.any?(fn {_name, definition} -> definition.loss != nil end)
+  end
+
+  defp generate_loss_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Gilbert-Elliott burst loss model
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    // GilbertElliott is a two-state Markov loss model for a single edge
+    // The edge alternates between a good and a bad state, each with its own
+    // loss probability, so messages are lost in bursts rather than independently
+    type GilbertElliott struct {
+    \tdraw func() float64
+    \tbad bool
+    \tpGoodToBad float64
+    \tpBadToGood float64
+    \tlossGood float64
+    \tlossBad float64
+    }
+
+    // NewGilbertElliott creates a loss model starting in the good state
+    // draw must return uniformly distributed numbers in [0, 1)
+    func NewGilbertElliott(draw func() float64, pGoodToBad, pBadToGood, lossGood, lossBad float64) *GilbertElliott {
+    \treturn &GilbertElliott{
+    \t\tdraw: draw,
+    \t\tpGoodToBad: pGoodToBad,
+    \t\tpBadToGood: pBadToGood,
+    \t\tlossGood: lossGood,
+    \t\tlossBad: lossBad,
+    \t}
+    }
+
+    // Drop decides whether the next message is lost in the current state,
+    // then moves the chain to its next state
+    func (g *GilbertElliott) Drop() bool {
+    \tloss := g.lossGood
+    \tif g.bad {
+    \t\tloss = g.lossBad
+    \t}
+    \tdropped := g.draw() < loss
+
+    \tif g.bad {
+    \t\tg.bad = g.draw() >= g.pBadToGood
+    \t} else {
+    \t\tg.bad = g.draw() < g.pGoodToBad
+    \t}
+    \treturn dropped
+    }
+
+    // Bad reports whether the edge is currently in the bad state
+    func (g *GilbertElliott) Bad() bool {
+    \treturn g.bad
+    }
+    """
+  end
+
+  defp generate_delay_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Latency distributions for message edges
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"math"
+    \t"time"
+    )
+
+    // Delay draws the latency of each message sent over an edge
+    type Delay interface {
+    \tSample() time.Duration
+    }
+
+    // ConstantDelay delays every message by the same amount
+    type ConstantDelay time.Duration
+
+    func (d ConstantDelay) Sample() time.Duration {
+    \treturn time.Duration(d)
+    }
+
+    // UniformDelay draws latencies uniformly between low and high
+    type UniformDelay struct {
+    \tdraw func() float64
+    \tlow time.Duration
+    \thigh time.Duration
+    }
+
+    // NewUniformDelay creates a uniform latency distribution
+    // draw must return uniformly distributed numbers in [0, 1)
+    func NewUniformDelay(draw func() float64, low, high time.Duration) *UniformDelay {
+    \treturn &UniformDelay{draw: draw, low: low, high: high}
+    }
+
+    func (d *UniformDelay) Sample() time.Duration {
+    \treturn d.low + time.Duration(d.draw()*float64(d.high-d.low))
+    }
+
+    // ExponentialDelay draws memoryless latencies around a mean, with the
+    // long tail of a congested link
+    type ExponentialDelay struct {
+    \tdraw func() float64
+    \tmean time.Duration
+    }
+
+    // NewExponentialDelay creates an exponential latency distribution
+    // draw must return uniformly distributed numbers in [0, 1)
+    func NewExponentialDelay(draw func() float64, mean time.Duration) *ExponentialDelay {
+    \treturn &ExponentialDelay{draw: draw, mean: mean}
+    }
+
+    func (d *ExponentialDelay) Sample() time.Duration {
+    \treturn time.Duration(-math.Log(1-d.draw()) * float64(d.mean))
+    }
+
+    // NormalDelay draws latencies from a normal distribution, clamped at
+    // zero since a message cannot arrive before it was sent
+    type NormalDelay struct {
+    \tdraw func() float64
+    \tmean time.Duration
+    \tstddev time.Duration
+    }
+
+    // NewNormalDelay creates a clamped normal latency distribution
+    // draw must return uniformly distributed numbers in [0, 1)
+    func NewNormalDelay(draw func() float64, mean, stddev time.Duration) *NormalDelay {
+    \treturn &NormalDelay{draw: draw, mean: mean, stddev: stddev}
+    }
+
+    func (d *NormalDelay) Sample() time.Duration {
+    \t// Box-Muller transform of two uniform draws
+    \tu1, u2 := 1-d.draw(), d.draw()
+    \tz := math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
+    \tif sample := float64(d.mean) + z*float64(d.stddev); sample > 0 {
+    \t\treturn time.Duration(sample)
+    \t}
+    \treturn 0
+    }
+    """
+  end
+
+  defp generate_clock_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Real and virtual time for the actor system
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"container/heap"
+    \t"errors"
+    \t"math"
+    \t"sync"
+    \t"time"
+    )
+
+    // Clock schedules work in real or virtual time
+    // Now reports the time elapsed since the clock was created
+    type Clock interface {
+    \tNow() time.Duration
+    \tAfterFunc(d time.Duration, f func()) Timer
+    }
+
+    // Timer is a scheduled AfterFunc call that can be cancelled
+    type Timer interface {
+    \tStop() bool
+    }
+
+    // RealClock runs timers on the wall clock, sped up or slowed down by its
+    // speed factor
+    type RealClock struct {
+    \tstart time.Time
+    \tspeed float64
+    }
+
+    // NewRealClock creates a clock starting now that keeps wall-clock time
+    func NewRealClock() *RealClock {
+    \treturn NewRealClockWithSpeed(1)
+    }
+
+    // NewRealClockWithSpeed creates a clock starting now that runs speed times
+    // as fast as the wall clock: at 2 a 100ms interval takes 50ms
+    func NewRealClockWithSpeed(speed float64) *RealClock {
+    \treturn &RealClock{start: time.Now(), speed: speed}
+    }
+
+    // Now is scaled like the timers, so it agrees with the intervals they run at
+    func (c *RealClock) Now() time.Duration {
+    \treturn time.Duration(float64(time.Since(c.start)) * c.speed)
+    }
+
+    func (c *RealClock) AfterFunc(d time.Duration, f func()) Timer {
+    \treturn time.AfterFunc(time.Duration(float64(d)/c.speed), f)
+    }
+
+    // VirtualClock only moves when Advance is called
+    // Due timers run one at a time on the caller's goroutine, ordered by
+    // virtual time and then by the clock's Policy
+    type VirtualClock struct {
+    \tmu sync.Mutex
+    \tnow time.Duration
+    \tseq uint64
+    \tevents eventQueue
+    \tpolicy Policy
+    \tpriority map[any]int
+    \tlastRun map[any]uint64
+    \tsteps uint64
+    \tlimit uint64
+    \texceeded bool
+    \thalted bool
+    }
+
+    // ErrStepLimitExceeded is what RunUntil returns once a VirtualClock has
+    // run as many timers as SetStepLimit allows
+    var ErrStepLimitExceeded = errors.New("step limit exceeded")
+
+    // Policy orders timers that fall due at the same virtual instant
+    type Policy int
+
+    const (
+    \t// FIFO runs them in the order they were scheduled (the default)
+    \tFIFO Policy = iota
+    \t// RoundRobin rotates between actors, running first the actor that has
+    \t// gone longest without running
+    \tRoundRobin
+    \t// Priority runs the timers of higher priority actors first, then the
+    \t// messages of higher Priority headers
+    \tPriority
+    \t// SourceOrder runs the messages of sources declared earlier first, and
+    \t// timers that carry no message, such as ticks, before any message
+    \tSourceOrder
+    )
+
+    // NewVirtualClock creates a virtual clock at time zero
+    func NewVirtualClock() *VirtualClock {
+    \treturn &VirtualClock{priority: map[any]int{}, lastRun: map[any]uint64{}}
+    }
+
+    // SetPolicy chooses how timers due at the same instant are ordered
+    // Ties within an actor, under equal priority or from the same source keep
+    // scheduling order
+    func (c *VirtualClock) SetPolicy(p Policy) {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \tc.policy = p
+    }
+
+    // SetStepLimit caps the timers the clock runs in all, so a runaway such as
+    // a feedback loop that never drops a message stops instead of hanging;
+    // zero, the default, sets no cap
+    // Once the clock reaches it with timers still due, Advance and Step run
+    // nothing more and leave the clock where it stopped
+    func (c *VirtualClock) SetStepLimit(n uint64) {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \tc.limit = n
+    }
+
+    // Exceeded reports whether the clock stopped at its step limit with timers
+    // still due
+    func (c *VirtualClock) Exceeded() bool {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \treturn c.exceeded
+    }
+
+    // Steps returns the number of timers the clock has run
+    func (c *VirtualClock) Steps() uint64 {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \treturn c.steps
+    }
+
+    // setPriority ranks the timers of owner under the Priority policy
+    func (c *VirtualClock) setPriority(owner any, priority int) {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \tc.priority[owner] = priority
+    }
+
+    func (c *VirtualClock) Now() time.Duration {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \treturn c.now
+    }
+
+    func (c *VirtualClock) AfterFunc(d time.Duration, f func()) Timer {
+    \treturn c.schedule(nil, d, f)
+    }
+
+    // schedule is AfterFunc for a timer that runs on behalf of owner, which
+    // RoundRobin and Priority order by
+    func (c *VirtualClock) schedule(owner any, d time.Duration, f func()) Timer {
+    \treturn c.scheduleRanked(owner, 0, 0, d, f)
+    }
+
+    // scheduleRanked schedules a timer that delivers a message from the source
+    // of rank, which SourceOrder orders by, under the priority of its header,
+    // which Priority orders by among timers of actors of equal priority
+    func (c *VirtualClock) scheduleRanked(owner any, rank, priority int, d time.Duration, f func()) Timer {
+    \tif d < 0 {
+    \t\td = 0
+    \t}
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \tc.seq++
+    \te := &event{at: c.now + d, seq: c.seq, owner: owner, rank: rank, priority: priority, f: f}
+    \theap.Push(&c.events, e)
+    \treturn &virtualTimer{clock: c, event: e}
+    }
+
+    // Advance moves virtual time forward by d, running every timer that
+    // falls due on the way, including timers scheduled while advancing
+    func (c *VirtualClock) Advance(d time.Duration) {
+    \tc.mu.Lock()
+    \tuntil := c.now + d
+    \tc.mu.Unlock()
+
+    \tfor c.step(until) {
+    \t}
+
+    \tc.mu.Lock()
+    \tif !c.exceeded && !c.halted {
+    \t\tc.now = until
+    \t}
+    \tc.mu.Unlock()
+    }
+
+    // halt stops the clock running timers once a callback halts the system;
+    // Advance and Step run nothing more and leave the clock where it stopped
+    func (c *VirtualClock) halt() {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \tc.halted = true
+    }
+
+    // Pending returns the number of scheduled timers
+    func (c *VirtualClock) Pending() int {
+    \tc.mu.Lock()
+    \tdefer c.mu.Unlock()
+    \treturn len(c.events)
+    }
+
+    // Step runs the next scheduled timer, moving virtual time to it
+    // It returns false if no timer is scheduled, the step limit is reached
+    // or a callback halted the system
+    func (c *VirtualClock) Step() bool {
+    \treturn c.step(math.MaxInt64)
+    }
+
+    // step runs the next timer due at or before until
+    func (c *VirtualClock) step(until time.Duration) bool {
+    \tc.mu.Lock()
+    \tif c.halted || len(c.events) == 0 || c.events[0].at > until {
+    \t\tc.mu.Unlock()
+    \t\treturn false
+    \t}
+    \tif c.limit > 0 && c.steps >= c.limit {
+    \t\tc.exceeded = true
+    \t\tc.mu.Unlock()
+    \t\treturn false
+    \t}
+    \te := heap.Remove(&c.events, c.next()).(*event)
+    \tc.now = e.at
+    \tc.steps++
+    \tc.lastRun[e.owner] = c.steps
+    \tc.mu.Unlock()
+
+    \te.f()
+    \treturn true
+    }
+
+    // next returns the index of the timer to run among those due first
+    func (c *VirtualClock) next() int {
+    \tif c.policy == FIFO {
+    \t\treturn 0
+    \t}
+    \tbest := 0
+    \tfor i, e := range c.events {
+    \t\tif e.at == c.events[0].at && c.before(e, c.events[best]) {
+    \t\t\tbest = i
+    \t\t}
+    \t}
+    \treturn best
+    }
+
+    // before reports whether e runs before other when both are due at once
+    func (c *VirtualClock) before(e, other *event) bool {
+    \tswitch c.policy {
+    \tcase RoundRobin:
+    \t\tif a, b := c.lastRun[e.owner], c.lastRun[other.owner]; a != b {
+    \t\t\treturn a < b
+    \t\t}
+    \tcase Priority:
+    \t\tif a, b := c.priority[e.owner], c.priority[other.owner]; a != b {
+    \t\t\treturn a > b
+    \t\t}
+    \t\tif e.priority != other.priority {
+    \t\t\treturn e.priority > other.priority
+    \t\t}
+    \tcase SourceOrder:
+    \t\tif e.rank != other.rank {
+    \t\t\treturn e.rank < other.rank
+    \t\t}
+    \t}
+    \treturn e.seq < other.seq
+    }
+
+    type virtualTimer struct {
+    \tclock *VirtualClock
+    \tevent *event
+    }
+
+    func (t *virtualTimer) Stop() bool {
+    \tt.clock.mu.Lock()
+    \tdefer t.clock.mu.Unlock()
+    \tif t.event.index < 0 {
+    \t\treturn false
+    \t}
+    \theap.Remove(&t.clock.events, t.event.index)
+    \treturn true
+    }
+
+    type event struct {
+    \tat time.Duration
+    \tseq uint64
+    \towner any
+    \trank int
+    \tpriority int
+    \tf func()
+    \tindex int
+    }
+
+    // eventQueue orders events by virtual time, then by scheduling order
+    type eventQueue []*event
+
+    func (q eventQueue) Len() int { return len(q) }
+
+    func (q eventQueue) Less(i, j int) bool {
+    \tif q[i].at != q[j].at {
+    \t\treturn q[i].at < q[j].at
+    \t}
+    \treturn q[i].seq < q[j].seq
+    }
+
+    func (q eventQueue) Swap(i, j int) {
+    \tq[i], q[j] = q[j], q[i]
+    \tq[i].index = i
+    \tq[j].index = j
+    }
+
+    func (q *eventQueue) Push(x any) {
+    \te := x.(*event)
+    \te.index = len(*q)
+    \t*q = append(*q, e)
+    }
+
+    func (q *eventQueue) Pop() any {
+    \told := *q
+    \tn := len(old)
+    \te := old[n-1]
+    \told[n-1] = nil
+    \te.index = -1
+    \t*q = old[:n-1]
+    \treturn e
+    }
+    """
+  end
+
+  defp generate_simtest_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Test harness for the actor system
+    // DO NOT EDIT - This file is auto-generated
+
+    // Package simtest drives a generated actor system in virtual time and
+    // asserts on its counters, so tests read as a few lines of intent
+    package simtest
+
+    import (
+    \t"fmt"
+    \t"testing"
+    \t"time"
+    )
+
+    // System is a generated actor system
+    type System interface {
+    \tStart()
+    \tPending() int
+    \tErr() error
+    }
+
+    // Clock is the virtual clock the system was created with
+    type Clock interface {
+    \tAdvance(d time.Duration)
+    \tStep() bool
+    }
+
+    // Actor is any generated actor
+    type Actor interface {
+    \tSendCount() int
+    }
+
+    // Harness runs a system under test against its virtual clock
+    type Harness struct {
+    \tt testing.TB
+    \tsystem System
+    \tclock Clock
+    }
+
+    // NewHarness starts system and returns a harness driving clock
+    func NewHarness(t testing.TB, system System, clock Clock) *Harness {
+    \tt.Helper()
+    \tsystem.Start()
+    \treturn &Harness{t: t, system: system, clock: clock}
+    }
+
+    // Advance moves virtual time forward, running every timer and
+    // delivering every message that falls due
+    func (h *Harness) Advance(d time.Duration) {
+    \th.clock.Advance(d)
+    }
+
+    // DrainQuiescent delivers every message still in flight at the
+    // current virtual time without moving the clock
+    func (h *Harness) DrainQuiescent() {
+    \th.clock.Advance(0)
+    }
+
+    // maxQuiescentSteps bounds WaitQuiescent on systems that never settle,
+    // such as periodic requests that always have a timeout armed
+    const maxQuiescentSteps = 100000
+
+    // WaitQuiescent runs timers one at a time, moving virtual time forward,
+    // until system has nothing pending
+    // It fails on a deadlock, where work is pending but no timer is left to
+    // make progress, and with the error of a callback that halts the system
+    func WaitQuiescent(system System, clock Clock) error {
+    \tfor i := 0; i < maxQuiescentSteps; i++ {
+    \t\tn := system.Pending()
+    \t\tif n == 0 {
+    \t\t\treturn nil
+    \t\t}
+    \t\tif !clock.Step() {
+    \t\t\tif err := system.Err(); err != nil {
+    \t\t\t\treturn err
+    \t\t\t}
+    \t\t\treturn fmt.Errorf("deadlock: %d pending with no timers scheduled", n)
+    \t\t}
+    \t}
+    \treturn fmt.Errorf("%d still pending after %d timers", system.Pending(), maxQuiescentSteps)
+    }
+
+    // WaitQuiescent stops the test unless the system settles
+    func (h *Harness) WaitQuiescent() {
+    \th.t.Helper()
+    \tif err := WaitQuiescent(h.system, h.clock); err != nil {
+    \t\th.t.Fatal(err)
+    \t}
+    }
+
+    // AssertQuiescent fails the test if any message is in flight, queued
+    // or waiting on a one-shot timer
+    func (h *Harness) AssertQuiescent() {
+    \th.t.Helper()
+    \tif n := h.system.Pending(); n != 0 {
+    \t\th.t.Errorf("%d messages or timers still pending", n)
+    \t}
+    }
+
+    // AssertSendCount fails the test unless actor sent exactly n messages
+    func (h *Harness) AssertSendCount(actor Actor, n int) {
+    \th.t.Helper()
+    \tif got := actor.SendCount(); got != n {
+    \t\th.t.Errorf("%T sent %d messages, want %d", actor, got, n)
+    \t}
+    }
+    """
+  end
+
+  defp generate_kafka_file(actors) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+
+    endpoint = fn %{topic: topic, brokers: brokers} ->
+      "kafkaEndpoint{topic: #{inspect(topic)}, " <>
+        "brokers: []string{#{Enum.map_join(brokers, ", ", &inspect/1)}}}"
+    end
+
+    sources =
+      for {name, definition} <- simulated,
+          source <- [kafka_source(definition)],
+          source != nil,
+          into: "" do
+        "\t\t{actor: \"#{name}\", endpoint: #{endpoint.(source)}, " <>
+          "ingest: s.#{GeneratorUtils.to_camel_case(name)}.ingestKafka},\n"
+      end
+
+    sinks =
+      for {name, definition} <- simulated,
+          sink <- [kafka_sink(definition)],
+          sink != nil,
+          into: "" do
+        "\t\"#{name}\": #{endpoint.(sink)},\n"
+      end
+
+    """
+    // Generated from ActorSimulation DSL
+    // Kafka adapters for sources and sinks
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"context"
+    \t"encoding/json"
+    \t"fmt"
+    \t"sync"
+    )
+
+    // KafkaRecord is a record consumed from or produced to a Kafka topic
+    type KafkaRecord struct {
+    \tTopic string
+    \tKey []byte
+    \tValue []byte
+    }
+
+    // KafkaClient consumes and produces Kafka records: implement it over a
+    // Kafka client library to process a live stream, or use MemoryKafka in
+    // tests
+    type KafkaClient interface {
+    \t// Consume calls handle with each record of topic in turn until ctx is
+    \t// done or consuming fails
+    \tConsume(ctx context.Context, brokers []string, topic string, handle func(KafkaRecord)) error
+    \t// Produce appends record to its topic
+    \tProduce(ctx context.Context, brokers []string, record KafkaRecord) error
+    }
+
+    // kafkaEndpoint is a topic on the cluster its brokers belong to
+    type kafkaEndpoint struct {
+    \ttopic string
+    \tbrokers []string
+    }
+
+    // kafkaSource is an actor originating a message from each record it
+    // consumes from a topic
+    type kafkaSource struct {
+    \tactor string
+    \tendpoint kafkaEndpoint
+    \tingest func(KafkaRecord)
+    }
+
+    // kafkaSinks are the topics actors produce the messages they handle to
+    var kafkaSinks = map[string]kafkaEndpoint{
+    #{sinks}}
+
+    // kafkaSources lists the actors consuming from a topic, in declaration
+    // order
+    func (s *System) kafkaSources() []kafkaSource {
+    \treturn []kafkaSource{
+    #{sources}\t}
+    }
+
+    // kafkaLink is the client the system is connected to, with the context
+    // its sinks produce under and the first error producing to it
+    type kafkaLink struct {
+    \tctx context.Context
+    \tclient KafkaClient
+    \tmu sync.Mutex
+    \terr error
+    }
+
+    func (l *kafkaLink) fail(err error) {
+    \tl.mu.Lock()
+    \tdefer l.mu.Unlock()
+    \tif l.err == nil {
+    \t\tl.err = err
+    \t}
+    }
+
+    // ConnectKafka connects the Kafka sources and sinks to client: each
+    // record a source consumes from its topic becomes a message it
+    // originates, with the record's value as its payload, and each message a
+    // sink handles is produced to its topic, keyed by the message's ID
+    // It returns once every source has stopped consuming, so on a live stream
+    // it blocks until ctx is done, with the first error consuming. Sinks go
+    // on producing under ctx after it returns, e.g. as a VirtualClock
+    // advances through what was consumed, until the next ConnectKafka
+    // On a VirtualClock the sources consume one after another, in declaration
+    // order, so the run stays reproducible
+    // Call it after Start
+    func (s *System) ConnectKafka(ctx context.Context, client KafkaClient) error {
+    \ts.kafka.Store(&kafkaLink{ctx: ctx, client: client})
+    \tctx, cancel := context.WithCancel(ctx)
+    \tdefer cancel()
+    \tvar mu sync.Mutex
+    \tvar failed error
+    \tconsume := func(source kafkaSource) {
+    \t\terr := client.Consume(ctx, source.endpoint.brokers, source.endpoint.topic, source.ingest)
+    \t\tif err == nil || ctx.Err() != nil {
+    \t\t\treturn
+    \t\t}
+    \t\tmu.Lock()
+    \t\tif failed == nil {
+    \t\t\tfailed = fmt.Errorf("%s consuming %s: %w", source.actor, source.endpoint.topic, err)
+    \t\t}
+    \t\tmu.Unlock()
+    \t\tcancel()
+    \t}
+    \tif s.virtual {
+    \t\tfor _, source := range s.kafkaSources() {
+    \t\t\tconsume(source)
+    \t\t}
+    \t\treturn failed
+    \t}
+    \t
+    \tvar wg sync.WaitGroup
+    \tfor _, source := range s.kafkaSources() {
+    \t\twg.Add(1)
+    \t\tgo func(source kafkaSource) {
+    \t\t\tdefer wg.Done()
+    \t\t\tconsume(source)
+    \t\t}(source)
+    \t}
+    \twg.Wait()
+    \treturn failed
+    }
+
+    // KafkaError returns the first error producing to the client the system
+    // is connected to, if any
+    func (s *System) KafkaError() error {
+    \tlink := s.kafka.Load()
+    \tif link == nil {
+    \t\treturn nil
+    \t}
+    \tlink.mu.Lock()
+    \tdefer link.mu.Unlock()
+    \treturn link.err
+    }
+
+    // kafkaSink is the middleware producing what the Kafka sinks handle to
+    // their topic, once their handlers return
+    type kafkaSink struct {
+    \tsys *System
+    }
+
+    func (k kafkaSink) Handle(ctx HandlerContext, msg string, next func()) {
+    \tnext()
+    \tsink, ok := kafkaSinks[ctx.Actor]
+    \tlink := k.sys.kafka.Load()
+    \tif !ok || link == nil {
+    \t\treturn
+    \t}
+    \trecord := KafkaRecord{Topic: sink.topic, Key: []byte(ctx.ID.String()), Value: kafkaValue(ctx.Payload)}
+    \tif err := link.client.Produce(link.ctx, sink.brokers, record); err != nil {
+    \t\tlink.fail(fmt.Errorf("%s producing to %s: %w", ctx.Actor, sink.topic, err))
+    \t}
+    }
+
+    // kafkaValue encodes a payload as a record's value: bytes and strings as
+    // they are, no payload as no value and anything else as JSON
+    func kafkaValue(payload any) []byte {
+    \tswitch v := payload.(type) {
+    \tcase nil:
+    \t\treturn nil
+    \tcase []byte:
+    \t\treturn v
+    \tcase string:
+    \t\treturn []byte(v)
+    \t}
+    \tb, err := json.Marshal(payload)
+    \tif err != nil {
+    \t\treturn []byte(fmt.Sprint(payload))
+    \t}
+    \treturn b
+    }
+
+    // MemoryKafka is a KafkaClient in memory, for tests: Produce appends to
+    // a topic, and Consume hands over the records a topic holds when it is
+    // called, then returns
+    type MemoryKafka struct {
+    \tmu sync.Mutex
+    \ttopics map[string][]KafkaRecord
+    }
+
+    func (k *MemoryKafka) Consume(ctx context.Context, brokers []string, topic string, handle func(KafkaRecord)) error {
+    \tfor _, record := range k.Records(topic) {
+    \t\tif err := ctx.Err(); err != nil {
+    \t\t\treturn err
+    \t\t}
+    \t\thandle(record)
+    \t}
+    \treturn nil
+    }
+
+    func (k *MemoryKafka) Produce(ctx context.Context, brokers []string, record KafkaRecord) error {
+    \tk.mu.Lock()
+    \tdefer k.mu.Unlock()
+    \tif k.topics == nil {
+    \t\tk.topics = map[string][]KafkaRecord{}
+    \t}
+    \tk.topics[record.Topic] = append(k.topics[record.Topic], record)
+    \treturn nil
+    }
+
+    // Records returns the records a topic holds, oldest first
+    func (k *MemoryKafka) Records(topic string) []KafkaRecord {
+    \tk.mu.Lock()
+    \tdefer k.mu.Unlock()
+    \treturn append([]KafkaRecord(nil), k.topics[topic]...)
+    }
+    """
+  end
+
+  defp generate_payloads_file(types, draws?) do
+    structs =
+      types
+      |> Enum.sort()
+      |> Enum.map_join("\n", fn {kind, fields} ->
+        type_name = GeneratorUtils.to_pascal_case(kind)
+        field_lines = Enum.map_join(fields, "", fn {field, type} -> "\t#{field} #{type}\n" end)
+
+        """
+        // #{type_name} is the payload of #{kind} messages
+        type #{type_name} struct {
+        #{field_lines}}
+        """
+      end)
+
+    """
+    // Generated from ActorSimulation DSL
+    // Typed message payloads
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    #{structs}
+    // payloadAs returns a message's payload as a T, or the zero T when the
+    // message carries none of that type, such as one a source originates
+    // without a payload
+    func payloadAs[T any](payload any) T {
+    \tv, _ := payload.(T)
+    \treturn v
+    }
+    """ <> if(draws?, do: generate_payload_draws(), else: "")
+  end
+
+  defp generate_payload_draws do
+    """
+
+    // drawInt returns an int from 0 up to max, from one draw
+    func drawInt(draw func() float64, max int) int {
+    \treturn int(draw() * float64(max))
+    }
+
+    // drawBytes returns n bytes, one draw each
+    func drawBytes(draw func() float64, n int) []byte {
+    \tb := make([]byte, n)
+    \tfor i := range b {
+    \t\tb[i] = byte(draw() * 256)
+    \t}
+    \treturn b
+    }
+
+    // drawString returns n lowercase letters, one draw each
+    func drawString(draw func() float64, n int) string {
+    \tb := make([]byte, n)
+    \tfor i := range b {
+    \t\tb[i] = 'a' + byte(draw()*26)
+    \t}
+    \treturn string(b)
+    }
+    """
+  end
+
+  defp generate_fair_queue_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Weighted fair queuing across message kinds
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"time"
+    )
+
+    // FairQueue holds one FIFO queue per message class and interleaves them
+    // by smooth weighted round-robin: while several classes are backlogged,
+    // each is served in proportion to its weight, so no class starves
+    type FairQueue struct {
+    \tweights []int
+    \tcredit []int
+    \tqueues [][]queueItem
+    \tpushes uint64
+    }
+
+    // queueItem is a queued message, numbered in the order it was pushed,
+    // keyed if it was conflated, with the deadline it was pushed with, zero
+    // for none, and the priority it ages from since it was pushed
+    type queueItem struct {
+    \tseq uint64
+    \tkey string
+    \tdeadline time.Duration
+    \tpriority int
+    \tpushed time.Duration
+    \tf func()
+    }
+
+    // NewFairQueue creates a queue with one class per weight
+    func NewFairQueue(weights ...int) *FairQueue {
+    \treturn &FairQueue{
+    \t\tweights: weights,
+    \t\tcredit: make([]int, len(weights)),
+    \t\tqueues: make([][]queueItem, len(weights)),
+    \t}
+    }
+
+    // Push appends f to the queue of class
+    func (q *FairQueue) Push(class int, f func()) {
+    \tq.queues[class] = append(q.queues[class], queueItem{seq: q.next(), f: f})
+    }
+
+    // PushDue appends f to the queue of class, to be shed once deadline has
+    // passed, unless deadline is zero
+    func (q *FairQueue) PushDue(class int, deadline time.Duration, f func()) {
+    \tq.queues[class] = append(q.queues[class], queueItem{seq: q.next(), deadline: deadline, f: f})
+    }
+
+    // PushAged appends f to the queue of class with priority as of now, for
+    // PopAged to age, and with a deadline as for PushDue
+    func (q *FairQueue) PushAged(class, priority int, deadline, now time.Duration, f func()) {
+    \tq.queues[class] = append(q.queues[class], queueItem{seq: q.next(), deadline: deadline, priority: priority, pushed: now, f: f})
+    }
+
+    // Shed removes every item whose deadline is before now and returns how
+    // many it removed
+    func (q *FairQueue) Shed(now time.Duration) int {
+    \tshed := 0
+    \tfor class, queue := range q.queues {
+    \t\tlive := queue[:0]
+    \t\tfor _, item := range queue {
+    \t\t\tif item.deadline > 0 && item.deadline < now {
+    \t\t\t\tshed++
+    \t\t\t\tcontinue
+    \t\t\t}
+    \t\t\tlive = append(live, item)
+    \t\t}
+    \t\tfor i := len(live); i < len(queue); i++ {
+    \t\t\tqueue[i] = queueItem{}
+    \t\t}
+    \t\tq.queues[class] = live
+    \t}
+    \treturn shed
+    }
+
+    // Conflate queues f in class unless an item of the same key is waiting
+    // there, which f then replaces in its place in line, and reports whether
+    // it replaced one
+    func (q *FairQueue) Conflate(class int, key string, f func()) bool {
+    \tfor i := range q.queues[class] {
+    \t\tif q.queues[class][i].key == key {
+    \t\t\tq.queues[class][i].f = f
+    \t\t\treturn true
+    \t\t}
+    \t}
+    \tq.queues[class] = append(q.queues[class], queueItem{seq: q.next(), key: key, f: f})
+    \treturn false
+    }
+
+    // next numbers the next item pushed
+    func (q *FairQueue) next() uint64 {
+    \tq.pushes++
+    \treturn q.pushes
+    }
+
+    // DropOldest removes the item pushed first across every class, to make
+    // room in a full mailbox, and reports whether there was one
+    func (q *FairQueue) DropOldest() bool {
+    \tbest := -1
+    \tfor class, queue := range q.queues {
+    \t\tif len(queue) > 0 && (best < 0 || queue[0].seq < q.queues[best][0].seq) {
+    \t\t\tbest = class
+    \t\t}
+    \t}
+    \tif best < 0 {
+    \t\treturn false
+    \t}
+    \tq.queues[best][0] = queueItem{}
+    \tq.queues[best] = q.queues[best][1:]
+    \treturn true
+    }
+
+    // Pop removes the next item, choosing among the non-empty classes
+    func (q *FairQueue) Pop() (int, func(), bool) {
+    \tbest, total := -1, 0
+    \tfor class, queue := range q.queues {
+    \t\tif len(queue) == 0 {
+    \t\t\tcontinue
+    \t\t}
+    \t\tq.credit[class] += q.weights[class]
+    \t\ttotal += q.weights[class]
+    \t\tif best < 0 || q.credit[class] > q.credit[best] {
+    \t\t\tbest = class
+    \t\t}
+    \t}
+    \tif best < 0 {
+    \t\treturn 0, nil, false
+    \t}
+    \tq.credit[best] -= total
+
+    \tf := q.queues[best][0].f
+    \tq.queues[best][0] = queueItem{}
+    \tq.queues[best] = q.queues[best][1:]
+    \treturn best, f, true
+    }
+
+    // PopAged removes the item of highest effective priority across every
+    // class, ignoring weights: its priority plus step for each interval of
+    // every it has waited by now, so an old item overtakes newer ones of
+    // higher priority and none starves
+    // Ties go to the item pushed first, then to the lower class
+    func (q *FairQueue) PopAged(now time.Duration, step int, every time.Duration) (int, func(), bool) {
+    \tbest, index, top := -1, 0, 0
+    \tfor class, queue := range q.queues {
+    \t\tfor i, item := range queue {
+    \t\t\tp := item.priority + step*int((now-item.pushed)/every)
+    \t\t\tif best < 0 || p > top || (p == top && item.pushed < q.queues[best][index].pushed) {
+    \t\t\t\tbest, index, top = class, i, p
+    \t\t\t}
+    \t\t}
+    \t}
+    \tif best < 0 {
+    \t\treturn 0, nil, false
+    \t}
+
+    \tqueue := q.queues[best]
+    \tf := queue[index].f
+    \tcopy(queue[index:], queue[index+1:])
+    \tqueue[len(queue)-1] = queueItem{}
+    \tq.queues[best] = queue[:len(queue)-1]
+    \treturn best, f, true
+    }
+
+    // Len returns the number of queued items across all classes
+    func (q *FairQueue) Len() int {
+    \tn := 0
+    \tfor _, queue := range q.queues {
+    \t\tn += len(queue)
+    \t}
+    \treturn n
+    }
+    """
+  end
+
+  defp generate_middleware_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Middleware around message handlers
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"time"
+    )
+
+    // HandlerContext describes the handler a middleware wraps
+    // From is the actor that sent the message, empty for one the actor
+    // produced as a source or that was delivered with Act; ID identifies it
+    // across the system; Key is its sequence number at the source that
+    // produced it; Headers are the ones it travels under, whatever its
+    // payload, which is nil unless it came in an Envelope
+    type HandlerContext struct {
+    \tActor string
+    \tFrom string
+    \tNow time.Duration
+    \tID MessageID
+    \tKey uint64
+    \tHeaders
+    \tPayload any
+    }
+
+    // Middleware wraps every message handler with cross-cutting logic such
+    // as logging, timing or tracing
+    // Handle must call next exactly once to run the handler
+    type Middleware interface {
+    \tHandle(ctx HandlerContext, msg string, next func())
+    }
+
+    // MiddlewareFunc adapts a function to Middleware
+    type MiddlewareFunc func(ctx HandlerContext, msg string, next func())
+
+    func (f MiddlewareFunc) Handle(ctx HandlerContext, msg string, next func()) {
+    \tf(ctx, msg, next)
+    }
+
+    // Use registers middleware around the handlers of every actor
+    // The first middleware registered runs outermost; call Use before Start
+    func (s *System) Use(middleware ...Middleware) {
+    \ts.middleware = append(s.middleware, middleware...)
+    \ts.record(func(s *System) { s.Use(middleware...) })
+    }
+
+    // chain runs a handler through every registered middleware
+    type chain []Middleware
+
+    func (c chain) Handle(ctx HandlerContext, msg string, next func()) {
+    \tfor i := len(c) - 1; i >= 0; i-- {
+    \t\tm, inner := c[i], next
+    \t\tnext = func() { m.Handle(ctx, msg, inner) }
+    \t}
+    \tnext()
+    }
+    """
+  end
+
+  defp generate_codec_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Serialization of what the system saves
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"encoding/gob"
+    \t"encoding/json"
+    \t"io"
+    )
+
+    // Codec encodes and decodes the values the system saves, such as reports;
+    // implement it to plug in another format, e.g. protobuf
+    type Codec interface {
+    \tEncode(w io.Writer, v any) error
+    \tDecode(r io.Reader, v any) error
+    }
+
+    // JSONCodec writes indented JSON, slower than gob but readable, for
+    // debugging
+    var JSONCodec Codec = jsonCodec{}
+
+    // GobCodec writes gob, compact and fast at high volume
+    var GobCodec Codec = gobCodec{}
+
+    // Codecs are the built-in codecs by name, for choosing one by configuration
+    var Codecs = map[string]Codec{"json": JSONCodec, "gob": GobCodec}
+
+    type jsonCodec struct{}
+
+    func (jsonCodec) Encode(w io.Writer, v any) error {
+    \te := json.NewEncoder(w)
+    \te.SetIndent("", "  ")
+    \treturn e.Encode(v)
+    }
+
+    func (jsonCodec) Decode(r io.Reader, v any) error {
+    \treturn json.NewDecoder(r).Decode(v)
+    }
+
+    type gobCodec struct{}
+
+    func (gobCodec) Encode(w io.Writer, v any) error {
+    \treturn gob.NewEncoder(w).Encode(v)
+    }
+
+    func (gobCodec) Decode(r io.Reader, v any) error {
+    \treturn gob.NewDecoder(r).Decode(v)
+    }
+
+    // SaveReport writes the report as of now to w, encoded with c
+    func (s *System) SaveReport(w io.Writer, c Codec) error {
+    \treturn c.Encode(w, s.Report())
+    }
+
+    // LoadReport reads a report SaveReport wrote with c
+    func LoadReport(r io.Reader, c Codec) (Report, error) {
+    \tvar report Report
+    \terr := c.Decode(r, &report)
+    \treturn report, err
+    }
+    """
+  end
+
+  defp generate_diff_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Diffs of the event logs of two runs in virtual time
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"sort"
+    \t"strings"
+    \t"sync"
+    \t"text/tabwriter"
+    \t"time"
+    )
+
+    // Event is a message an actor handled, as an event log records it
+    type Event struct {
+    \tAt time.Duration
+    \tActor string
+    \tMessage string
+    \tID MessageID
+    }
+
+    func (e Event) String() string {
+    \treturn fmt.Sprintf("%v %s %s %s", e.At, e.Actor, e.Message, e.ID)
+    }
+
+    // EventLog records every message the actors of a system handle, in the
+    // order they handle it
+    type EventLog struct {
+    \tmu sync.Mutex
+    \tevents []Event
+    }
+
+    // Record registers the log as middleware of s; call it before Start
+    func (l *EventLog) Record(s *System) {
+    \ts.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tl.mu.Lock()
+    \t\tl.events = append(l.events, Event{At: ctx.Now, Actor: ctx.Actor, Message: msg, ID: ctx.ID})
+    \t\tl.mu.Unlock()
+    \t\tnext()
+    \t}))
+    }
+
+    // Events returns the events recorded so far
+    func (l *EventLog) Events() []Event {
+    \tl.mu.Lock()
+    \tdefer l.mu.Unlock()
+    \treturn append([]Event(nil), l.events...)
+    }
+
+    // EventCount is how many times an actor handled a message in each of
+    // two runs
+    type EventCount struct {
+    \tActor string
+    \tMessage string
+    \tLeft int
+    \tRight int
+    }
+
+    // Diff compares the event logs of two runs to the same virtual time
+    // Index is the position of the first event the logs disagree on, or -1
+    // when they are alike, and Left and Right are the events each log has
+    // there, nil where it has ended; Counts holds every actor and message
+    // either run handled, sorted by actor, then message
+    type Diff struct {
+    \tAt time.Duration
+    \tIndex int
+    \tLeft *Event
+    \tRight *Event
+    \tCounts []EventCount
+    }
+
+    // Diverged reports whether the logs differ
+    func (d Diff) Diverged() bool {
+    \treturn d.Index >= 0
+    }
+
+    // DiffLogs compares the event logs of two runs to at
+    func DiffLogs(at time.Duration, left, right []Event) Diff {
+    \td := Diff{At: at, Index: -1}
+    \tfor i := 0; i < len(left) || i < len(right); i++ {
+    \t\tl, r := eventAt(left, i), eventAt(right, i)
+    \t\tif l == nil || r == nil || *l != *r {
+    \t\t\td.Index, d.Left, d.Right = i, l, r
+    \t\t\tbreak
+    \t\t}
+    \t}
+    \t
+    \tcounts := map[[2]string]*EventCount{}
+    \tcount := func(e Event) *EventCount {
+    \t\tkey := [2]string{e.Actor, e.Message}
+    \t\tif counts[key] == nil {
+    \t\t\tcounts[key] = &EventCount{Actor: e.Actor, Message: e.Message}
+    \t\t}
+    \t\treturn counts[key]
+    \t}
+    \tfor _, e := range left {
+    \t\tcount(e).Left++
+    \t}
+    \tfor _, e := range right {
+    \t\tcount(e).Right++
+    \t}
+    \tfor _, c := range counts {
+    \t\td.Counts = append(d.Counts, *c)
+    \t}
+    \tsort.Slice(d.Counts, func(i, j int) bool {
+    \t\ta, b := d.Counts[i], d.Counts[j]
+    \t\tif a.Actor != b.Actor {
+    \t\t\treturn a.Actor < b.Actor
+    \t\t}
+    \t\treturn a.Message < b.Message
+    \t})
+    \treturn d
+    }
+
+    // eventAt returns the event at i, or nil past the end of the log
+    func eventAt(events []Event, i int) *Event {
+    \tif i >= len(events) {
+    \t\treturn nil
+    \t}
+    \treturn &events[i]
+    }
+
+    // DiffRuns runs two systems from seed to until, each on a VirtualClock of
+    // its own, and diffs their event logs
+    // left and right configure their system once it has started, before
+    // virtual time moves, such as with Reconfigure; either may be nil
+    func DiffRuns(seed int64, until time.Duration, left, right func(s *System)) Diff {
+    \treturn DiffLogs(until, runLog(seed, until, left), runLog(seed, until, right))
+    }
+
+    // runLog runs a system configured by setup from seed to until and returns
+    // its event log
+    func runLog(seed int64, until time.Duration, setup func(s *System)) []Event {
+    \ts := NewSystem(seed, NewVirtualClock())
+    \tvar log EventLog
+    \tlog.Record(s)
+    \ts.Start()
+    \tif setup != nil {
+    \t\tsetup(s)
+    \t}
+    \ts.RunUntil(until)
+    \treturn log.Events()
+    }
+
+    // String lays out where the runs first diverge and the counts that differ
+    func (d Diff) String() string {
+    \tvar b strings.Builder
+    \tif !d.Diverged() {
+    \t\tfmt.Fprintf(&b, "Runs alike up to %v\\n", d.At)
+    \t\treturn b.String()
+    \t}
+    \tfmt.Fprintf(&b, "Runs diverge at event %d, up to %v\\n", d.Index, d.At)
+    \tfor _, side := range []struct {
+    \t\tname string
+    \t\tevent *Event
+    \t}{{"left", d.Left}, {"right", d.Right}} {
+    \t\tif side.event == nil {
+    \t\t\tfmt.Fprintf(&b, "  %s: no more events\\n", side.name)
+    \t\t} else {
+    \t\t\tfmt.Fprintf(&b, "  %s: %s\\n", side.name, side.event)
+    \t\t}
+    \t}
+    \t
+    \tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+    \tfmt.Fprintln(w, "ACTOR\\tMESSAGE\\tLEFT\\tRIGHT")
+    \tfor _, c := range d.Counts {
+    \t\tif c.Left != c.Right {
+    \t\t\tfmt.Fprintf(w, "%s\\t%s\\t%d\\t%d\\n", c.Actor, c.Message, c.Left, c.Right)
+    \t\t}
+    \t}
+    \tw.Flush()
+    \treturn b.String()
+    }
+    """
+  end
+
+  defp generate_fork_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Forks of a run in virtual time
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"sort"
+    \t"strings"
+    \t"text/tabwriter"
+    \t"time"
+    )
+
+    // Snapshot marks a point in a run on a VirtualClock for Fork to branch
+    // from: the seed, every change made to the system on the way and how
+    // many timers had run
+    type Snapshot struct {
+    \tAt time.Duration
+    \tseed int64
+    \tpolicy Policy
+    \tsteps uint64
+    \thistory []change
+    }
+
+    // change is a call that changed the system, made once steps timers had
+    // run and virtual time had reached at
+    type change struct {
+    \tat time.Duration
+    \tsteps uint64
+    \tapply func(s *System)
+    }
+
+    // Snapshot captures the run so far; the system must run on a VirtualClock
+    // Safe to call between advances of the clock
+    func (s *System) Snapshot() Snapshot {
+    \tclock := s.clock.(*VirtualClock)
+    \tclock.mu.Lock()
+    \tsnap := Snapshot{At: clock.now, seed: s.seed, policy: clock.policy, steps: clock.steps}
+    \tclock.mu.Unlock()
+    \ts.mu.Lock()
+    \tsnap.history = append([]change(nil), s.history...)
+    \ts.mu.Unlock()
+    \treturn snap
+    }
+
+    // Fork returns a system in the state snap captured, on a VirtualClock of
+    // its own, to run on apart from the original, with different parameters
+    // if need be
+    // A run in virtual time is reproducible, so Fork replays the seed and the
+    // changes to rebuild every actor, queue and pending timer as they were;
+    // the fork shares the original's middleware, and changes made to
+    // unexported fields are not replayed
+    func Fork(snap Snapshot) *System {
+    \tclock := NewVirtualClock()
+    \tclock.SetPolicy(snap.policy)
+    \ts := NewSystem(snap.seed, clock)
+    \tfor _, c := range snap.history {
+    \t\tclock.replay(c.steps, c.at)
+    \t\tc.apply(s)
+    \t}
+    \tclock.replay(snap.steps, snap.At)
+    \treturn s
+    }
+
+    // record notes a change for Fork to replay, when the system runs on a
+    // VirtualClock
+    func (s *System) record(apply func(s *System)) {
+    \tclock, ok := s.clock.(*VirtualClock)
+    \tif !ok {
+    \t\treturn
+    \t}
+    \tclock.mu.Lock()
+    \tc := change{at: clock.now, steps: clock.steps, apply: apply}
+    \tclock.mu.Unlock()
+    \ts.mu.Lock()
+    \ts.history = append(s.history, c)
+    \ts.mu.Unlock()
+    }
+
+    // replay runs timers until steps of them have run, then moves virtual
+    // time to at, retracing a run that got there the same way
+    func (c *VirtualClock) replay(steps uint64, at time.Duration) {
+    \tfor {
+    \t\tc.mu.Lock()
+    \t\tdone := c.steps >= steps
+    \t\tc.mu.Unlock()
+    \t\tif done || !c.Step() {
+    \t\t\tbreak
+    \t\t}
+    \t}
+    \tc.mu.Lock()
+    \tc.now = at
+    \tc.mu.Unlock()
+    }
+
+    // Compare lays reports out side by side, such as those of forks of one
+    // run given different parameters: one column per named report and one
+    // row per actor and counter, with - where a report has no such actor
+    func Compare(reports map[string]Report) string {
+    \tnames := make([]string, 0, len(reports))
+    \tfor name := range reports {
+    \t\tnames = append(names, name)
+    \t}
+    \tsort.Strings(names)
+    \tvar actors []string
+    \trows := map[string]map[string]ActorReport{}
+    \tfor _, name := range names {
+    \t\tfor _, a := range reports[name].Actors {
+    \t\t\tif rows[a.Name] == nil {
+    \t\t\t\trows[a.Name] = map[string]ActorReport{}
+    \t\t\t\tactors = append(actors, a.Name)
+    \t\t\t}
+    \t\t\trows[a.Name][name] = a
+    \t\t}
+    \t}
+    \tcounters := []struct {
+    \t\tname string
+    \t\tvalue func(a ActorReport) any
+    \t}{
+    \t\t{"sent", func(a ActorReport) any { return a.Sent }},
+    \t\t{"received", func(a ActorReport) any { return a.Received }},
+    \t\t{"dropped", func(a ActorReport) any { return a.Dropped }},
+    \t\t{"p99", func(a ActorReport) any { return a.P99 }},
+    \t\t{"peak queue", func(a ActorReport) any { return a.PeakQueue }},
+    \t}
+    \t
+    \tvar b strings.Builder
+    \tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+    \tfmt.Fprint(w, "ACTOR\\tCOUNTER")
+    \tfor _, name := range names {
+    \t\tfmt.Fprintf(w, "\\t%s", name)
+    \t}
+    \tfmt.Fprintln(w)
+    \tfor _, actor := range actors {
+    \t\tfor i, c := range counters {
+    \t\t\tlabel := ""
+    \t\t\tif i == 0 {
+    \t\t\t\tlabel = actor
+    \t\t\t}
+    \t\t\tfmt.Fprintf(w, "%s\\t%s", label, c.name)
+    \t\t\tfor _, name := range names {
+    \t\t\t\tif a, ok := rows[actor][name]; ok {
+    \t\t\t\t\tfmt.Fprintf(w, "\\t%v", c.value(a))
+    \t\t\t\t} else {
+    \t\t\t\t\tfmt.Fprint(w, "\\t-")
+    \t\t\t\t}
+    \t\t\t}
+    \t\t\tfmt.Fprintln(w)
+    \t\t}
+    \t}
+    \tw.Flush()
+    \treturn b.String()
+    }
+    """
+  end
+
+  defp generate_debugger_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Step-by-step debugging of a run in virtual time
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"math"
+    \t"sync"
+    \t"time"
+    )
+
+    // Debugger steps a system on a VirtualClock one timer at a time, so every
+    // actor can be inspected between events, runs on to breakpoints and steps
+    // back by forking the run at an earlier event
+    // Drive the system through the debugger alone: advancing its clock past
+    // the debugger leaves it unable to step back over those timers
+    type Debugger struct {
+    \tsys *System
+    \tclock *VirtualClock
+    \tmu sync.Mutex
+    \thandled *Event
+    \ttrail []mark
+    \tbreakpoints []breakpoint
+    }
+
+    // mark is how far a run had got when the debugger stopped at it
+    type mark struct {
+    \tsteps uint64
+    \tat time.Duration
+    }
+
+    // breakpoint stops Continue once when holds of an actor's report row
+    type breakpoint struct {
+    \tactor string
+    \tmsg string
+    \twhen func(a ActorReport) bool
+    }
+
+    // NewDebugger attaches a debugger to a system on a VirtualClock; it sees
+    // the handlers that run as middleware, so attach it before Start
+    func NewDebugger(s *System) *Debugger {
+    \td := &Debugger{}
+    \td.attach(s)
+    \td.trail = []mark{{steps: d.clock.Steps(), at: d.clock.Now()}}
+    \treturn d
+    }
+
+    // attach makes s the system the debugger steps
+    func (d *Debugger) attach(s *System) {
+    \td.sys = s
+    \td.clock = s.clock.(*VirtualClock)
+    \ts.middleware = append(s.middleware, MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\td.mu.Lock()
+    \t\tif d.handled == nil {
+    \t\t\td.handled = &Event{At: ctx.Now, Actor: ctx.Actor, Message: msg, ID: ctx.ID}
+    \t\t}
+    \t\td.mu.Unlock()
+    \t\tnext()
+    \t}))
+    }
+
+    // System returns the system the debugger steps, which Back replaces with
+    // a fork
+    func (d *Debugger) System() *System {
+    \treturn d.sys
+    }
+
+    // Step runs the next timer, moving virtual time to it, and returns the
+    // message it had an actor handle; a timer that handles none, such as a
+    // tick that only sends, returns an Event with just its time
+    // It returns false if no timer is scheduled or the step limit is reached
+    func (d *Debugger) Step() (Event, bool) {
+    \treturn d.step(math.MaxInt64)
+    }
+
+    // step runs the next timer due at or before until
+    func (d *Debugger) step(until time.Duration) (Event, bool) {
+    \td.mu.Lock()
+    \td.handled = nil
+    \td.mu.Unlock()
+    \tif !d.clock.step(until) {
+    \t\treturn Event{}, false
+    \t}
+    \td.trail = append(d.trail, mark{steps: d.clock.Steps(), at: d.clock.Now()})
+    \td.mu.Lock()
+    \tdefer d.mu.Unlock()
+    \tif d.handled == nil {
+    \t\treturn Event{At: d.clock.Now()}, true
+    \t}
+    \treturn *d.handled, true
+    }
+
+    // Break has Continue stop after the first event once when holds of the
+    // named actor's report row, such as a.Received == 5; a msg other than ""
+    // only stops it after events in which the actor handled msg, and a nil
+    // when always holds
+    func (d *Debugger) Break(actor, msg string, when func(a ActorReport) bool) {
+    \td.breakpoints = append(d.breakpoints, breakpoint{actor: actor, msg: msg, when: when})
+    }
+
+    // Continue steps until an event hits a breakpoint, and returns that event
+    // Without a hit by until it moves the clock there like Advance and returns
+    // false
+    func (d *Debugger) Continue(until time.Duration) (Event, bool) {
+    \tfor {
+    \t\te, ok := d.step(until)
+    \t\tif !ok {
+    \t\t\tbreak
+    \t\t}
+    \t\tif d.hit(e) {
+    \t\t\treturn e, true
+    \t\t}
+    \t}
+    \td.clock.mu.Lock()
+    \tif !d.clock.exceeded && d.clock.now < until {
+    \t\td.clock.now = until
+    \t}
+    \td.clock.mu.Unlock()
+    \treturn Event{}, false
+    }
+
+    // hit reports whether e hits a breakpoint
+    func (d *Debugger) hit(e Event) bool {
+    \tfor _, b := range d.breakpoints {
+    \t\tif b.msg != "" && (e.Actor != b.actor || e.Message != b.msg) {
+    \t\t\tcontinue
+    \t\t}
+    \t\tif b.when == nil || b.when(d.Inspect(b.actor)) {
+    \t\t\treturn true
+    \t\t}
+    \t}
+    \treturn false
+    }
+
+    // Inspect returns the report row of the named actor as it stands between
+    // events, or a zero row for an unknown actor
+    func (d *Debugger) Inspect(name string) ActorReport {
+    \tfor _, a := range d.sys.Report().Actors {
+    \t\tif a.Name == name {
+    \t\t\treturn a
+    \t\t}
+    \t}
+    \treturn ActorReport{}
+    }
+
+    // Back steps n events back, to no earlier than where the debugger was
+    // attached: a run in virtual time is reproducible, so it forks the run
+    // just after the event it returns to and steps the fork from then on
+    // The fork starts off without the original's step limit
+    func (d *Debugger) Back(n int) {
+    \tif n > len(d.trail)-1 {
+    \t\tn = len(d.trail) - 1
+    \t}
+    \tif n <= 0 {
+    \t\treturn
+    \t}
+    \td.trail = d.trail[:len(d.trail)-n]
+    \tm := d.trail[len(d.trail)-1]
+    \tsnap := d.sys.Snapshot()
+    \thistory := snap.history[:0]
+    \tfor _, c := range snap.history {
+    \t\tif c.steps < m.steps || c.steps == m.steps && c.at <= m.at {
+    \t\t\thistory = append(history, c)
+    \t\t}
+    \t}
+    \tsnap.At, snap.steps, snap.history = m.at, m.steps, history
+    \td.attach(Fork(snap))
+    }
+    """
+  end
+
+  defp generate_sweep_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Seed sweeps for flakiness detection
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    )
+
+    // SweepSeeds runs check against a fresh, started system on a VirtualClock
+    // for every seed from 0 to n-1 and stops at the first failure
+    // The error names the seed, so NewSystem(seed, NewVirtualClock()) replays it
+    func SweepSeeds(n int, check func(*System) error) error {
+    \tfor seed := 0; seed < n; seed++ {
+    \t\tsys := NewSystem(int64(seed), NewVirtualClock())
+    \t\tsys.Start()
+    \t\tif err := check(sys); err != nil {
+    \t\t\treturn fmt.Errorf("seed %d: %w", seed, err)
+    \t\t}
+    \t}
+    \treturn nil
+    }
+    """
+  end
+
+  defp generate_breaker_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Circuit breakers on edges
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"time"
+    )
+
+    // BreakerState is the state of a circuit breaker
+    type BreakerState int
+
+    const (
+    \t// Closed lets every send through
+    \tClosed BreakerState = iota
+    \t// Open sends to the fallback until the cooldown has elapsed
+    \tOpen
+    \t// HalfOpen has let one trial send through and waits for its outcome
+    \tHalfOpen
+    )
+
+    func (s BreakerState) String() string {
+    \tswitch s {
+    \tcase Open:
+    \t\treturn "open"
+    \tcase HalfOpen:
+    \t\treturn "half-open"
+    \t}
+    \treturn "closed"
+    }
+
+    // Breaker is a circuit breaker on one edge: it opens after threshold
+    // consecutive failed sends, then once cooldown has elapsed on the clock
+    // lets a trial send through, which closes it again on success and
+    // reopens it on failure
+    // Only the sending actor's inbox touches it
+    type Breaker struct {
+    \tthreshold int
+    \tcooldown time.Duration
+    \tnow func() time.Duration
+    \tstate BreakerState
+    \tfailures int
+    \topened time.Duration
+    }
+
+    func NewBreaker(threshold int, cooldown time.Duration, now func() time.Duration) *Breaker {
+    \treturn &Breaker{threshold: threshold, cooldown: cooldown, now: now}
+    }
+
+    // Allow reports whether a send may go to the target
+    func (b *Breaker) Allow() bool {
+    \tswitch b.state {
+    \tcase Open:
+    \t\tif b.now()-b.opened < b.cooldown {
+    \t\t\treturn false
+    \t\t}
+    \t\tb.state = HalfOpen
+    \t\treturn true
+    \tcase HalfOpen:
+    \t\t// One trial at a time
+    \t\treturn false
+    \t}
+    \treturn true
+    }
+
+    // Success records a send the target answered in time
+    // Outcomes of sends made before the circuit opened are ignored
+    func (b *Breaker) Success() {
+    \tif b.state != Open {
+    \t\tb.state = Closed
+    \t\tb.failures = 0
+    \t}
+    }
+
+    // Failure records a send the target did not answer in time
+    func (b *Breaker) Failure() {
+    \tswitch b.state {
+    \tcase Closed:
+    \t\tb.failures++
+    \t\tif b.failures >= b.threshold {
+    \t\t\tb.open()
+    \t\t}
+    \tcase HalfOpen:
+    \t\tb.open()
+    \t}
+    }
+
+    func (b *Breaker) open() {
+    \tb.state = Open
+    \tb.failures = 0
+    \tb.opened = b.now()
+    }
+
+    // State returns the breaker's state
+    func (b *Breaker) State() BreakerState {
+    \treturn b.state
+    }
+    """
+  end
+
+  defp generate_schedule_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Schedules of messages to replay
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"encoding/csv"
+    \t"fmt"
+    \t"io"
+    \t"math"
+    \t"os"
+    \t"strconv"
+    \t"strings"
+    \t"time"
+    )
+
+    // ScheduleEntry is a message an actor originates at a given time after
+    // its replay starts
+    type ScheduleEntry struct {
+    \tAt time.Duration
+    \tKind string
+    }
+
+    // ParseSchedule reads a schedule from CSV, one at_ms,message entry per
+    // line, e.g. 12.5,request; a first line that is not an entry is a
+    // header and skipped
+    // Entries need not be in time order
+    func ParseSchedule(r io.Reader) ([]ScheduleEntry, error) {
+    \treader := csv.NewReader(r)
+    \treader.FieldsPerRecord = -1
+    \treader.TrimLeadingSpace = true
+    \tentries := []ScheduleEntry{}
+    \tfor first := true; ; first = false {
+    \t\trecord, err := reader.Read()
+    \t\tif err == io.EOF {
+    \t\t\treturn entries, nil
+    \t\t}
+    \t\tif err != nil {
+    \t\t\treturn nil, err
+    \t\t}
+    \t\tentry, ok := scheduleEntry(record)
+    \t\tif !ok && first {
+    \t\t\tcontinue
+    \t\t}
+    \t\tif !ok {
+    \t\t\tline, _ := reader.FieldPos(0)
+    \t\t\treturn nil, fmt.Errorf("schedule line %d: expected at_ms,message, got %q", line, strings.Join(record, ","))
+    \t\t}
+    \t\tentries = append(entries, entry)
+    \t}
+    }
+
+    // LoadSchedule reads a schedule from a CSV file, as ParseSchedule does
+    func LoadSchedule(path string) ([]ScheduleEntry, error) {
+    \tf, err := os.Open(path)
+    \tif err != nil {
+    \t\treturn nil, err
+    \t}
+    \tdefer f.Close()
+    \treturn ParseSchedule(f)
+    }
+
+    func scheduleEntry(record []string) (ScheduleEntry, bool) {
+    \tif len(record) != 2 {
+    \t\treturn ScheduleEntry{}, false
+    \t}
+    \tms, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+    \tkind := strings.TrimSpace(record[1])
+    \tif err != nil || ms < 0 || math.IsNaN(ms) || math.IsInf(ms, 0) || kind == "" {
+    \t\treturn ScheduleEntry{}, false
+    \t}
+    \treturn ScheduleEntry{At: time.Duration(ms * float64(time.Millisecond)), Kind: kind}, true
+    }
+    """
+  end
+
+  defp generate_sleep_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Virtual-time sleeps for callbacks
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // Context is what an actor hands its callbacks: the system clock, a way
+    // to make the message being handled take time, a snapshot of their state
+    // that survives a crash and sampled logging
+    // Only the actor's own callbacks may use it
+    type Context struct {
+    \tclock Clock
+    \tsleep time.Duration
+    \tpersisted []byte
+    \tlogs *logSampler
+    \tlogged int64
+    }
+
+    // Restorer is implemented by callbacks that pick up the state they
+    // persisted when their actor restarts after a crash
+    type Restorer interface {
+    \tRestore(state []byte)
+    }
+
+    // Now returns the time on the system clock, virtual under a VirtualClock
+    func (c *Context) Now() time.Duration {
+    \treturn c.clock.Now()
+    }
+
+    // SleepVirtual makes the message being handled take d longer, e.g. to
+    // model a database query, without blocking a thread: the actor handles
+    // other messages meanwhile and sends this one on once d has elapsed on
+    // the clock
+    // Sleeps in one callback add up
+    func (c *Context) SleepVirtual(d time.Duration) {
+    \tc.sleep += d
+    }
+
+    // Persist replaces the snapshot of the callbacks' state, which a crash
+    // keeps for their successors to restore
+    func (c *Context) Persist(state []byte) {
+    \tc.persisted = append([]byte(nil), state...)
+    }
+
+    // resume finishes handling the message an actor is on, at once or, if
+    // its callback slept, once the sleep has elapsed
+    // Until then the message counts as in flight, and a crash loses it
+    func (s *System) resume(to phony.Actor, ctx *Context, finish func()) {
+    \td := ctx.sleep
+    \tctx.sleep = 0
+    \tif d <= 0 {
+    \t\tfinish()
+    \t\treturn
+    \t}
+    \tc := to.(contextual).context()
+    \tc.slept = d
+    \th := c.header
+    \tepoch := c.epoch.Load()
+    \ts.ledger.inflight.Add(1)
+    \ts.after(to, d, func() {
+    \t\ts.ledger.inflight.Add(-1)
+    \t\tif c.crashed(epoch) {
+    \t\t\ts.lose(to, h)
+    \t\t\treturn
+    \t\t}
+    \t\tc.header = h
+    \t\tfinish()
+    \t})
+    }
+    """
+  end
+
+  defp generate_log_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Sampled logging for callbacks
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"sync/atomic"
+    )
+
+    // logSampler holds how many of their lines callbacks log, one in every
+    // every, and how many lines it has left out
+    type logSampler struct {
+    \tevery atomic.Int64
+    \tsuppressed atomic.Int64
+    }
+
+    // Logf prints a line like fmt.Printf, or leaves it out to keep one in every
+    // few, as SetLogEvery sets
+    // Each actor counts its own lines and logs its first, so a busy actor
+    // doesn't crowd out a quiet one
+    func (c *Context) Logf(format string, args ...any) {
+    \tc.logged++
+    \tif every := c.logs.every.Load(); every > 1 && (c.logged-1)%every != 0 {
+    \t\tc.logs.suppressed.Add(1)
+    \t\treturn
+    \t}
+    \tfmt.Printf(format, args...)
+    }
+
+    // SetLogEvery makes callbacks log one in every n of their lines from now on,
+    // or all of them for n of 1
+    func (s *System) SetLogEvery(n int) error {
+    \tif n < 1 {
+    \t\treturn fmt.Errorf("cannot log one in every %d lines", n)
+    \t}
+    \ts.logs.every.Store(int64(n))
+    \treturn nil
+    }
+
+    // SuppressedLogs returns the number of lines Logf has left out so far
+    func (s *System) SuppressedLogs() int {
+    \treturn int(s.logs.suppressed.Load())
+    }
+    """
+  end
+
+  defp generate_observe_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Messages recorded by observed actors
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"time"
+    )
+
+    // Msg is a message an observed actor received: its kind, its ID and key,
+    // and when the actor handled it
+    type Msg struct {
+    \tKind string
+    \tID MessageID
+    \tKey uint64
+    \tAt time.Duration
+    }
+    """
+  end
+
+  defp generate_messages_file(actors) do
+    methods =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.filter(fn {_name, definition} -> observe(definition) end)
+      |> Enum.map_join("\n", fn {name, _definition} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        """
+        // Messages yields the messages this actor receives, in the order it
+        // handles them, for a range loop to take one by one
+        func (a *#{type_name}) Messages() iter.Seq[Msg] {
+        \treturn a.sys.messages(a.received)
+        }
+        """
+      end)
+
+    """
+    //go:build go1.23
+
+    // Generated from ActorSimulation DSL
+    // Iterators over the messages observed actors receive
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"iter"
+    )
+
+    // messages yields what an observed actor records in received, waiting
+    // for each message: under a VirtualClock it runs due timers one at a
+    // time until the next one arrives, and stops once no timer is left or
+    // the clock reaches its step limit; on a RealClock it blocks until the
+    // actor handles the next one
+    func (s *System) messages(received <-chan Msg) iter.Seq[Msg] {
+    \treturn func(yield func(Msg) bool) {
+    \t\tclock, virtual := s.clock.(*VirtualClock)
+    \t\tfor {
+    \t\t\tvar msg Msg
+    \t\t\tif virtual {
+    \t\t\t\tselect {
+    \t\t\t\tcase msg = <-received:
+    \t\t\t\tdefault:
+    \t\t\t\t\tif !clock.Step() {
+    \t\t\t\t\t\treturn
+    \t\t\t\t\t}
+    \t\t\t\t\tcontinue
+    \t\t\t\t}
+    \t\t\t} else {
+    \t\t\t\tmsg = <-received
+    \t\t\t}
+    \t\t\tif !yield(msg) {
+    \t\t\t\treturn
+    \t\t\t}
+    \t\t}
+    \t}
+    }
+
+    #{methods}
+    """
+  end
+
+  # Takes an observed actor's messages up to the horizon off its iterator,
+  # which runs the clock itself; the count is exact when the messages
+  # reaching it are predictable
+  defp generate_messages_test(actors, topology) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+    definitions = Map.new(simulated)
+    horizon = test_horizon(simulated)
+    {name, definition} = Enum.find(simulated, fn {_name, definition} -> observe(definition) end)
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    handled =
+      if definition.fair_queue == nil and definition.reorder_by == nil,
+        do: expected_handled(name, definitions, topology, horizon, [])
+
+    count_check =
+      if handled do
+        """
+        \tif yielded != #{handled} {
+        \t\tt.Fatalf("expected #{name} to yield #{handled} messages by #{horizon}ms, got %d", yielded)
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+    //go:build go1.23
+
+    // Generated from ActorSimulation DSL
+    // Go tests for message iterators
+
+    package main
+
+    import (
+    \t"testing"
+    \t"time"
+    )
+
+    func Test#{type_name}MessagesIterate(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \t// Ends the loop should #{name} receive nothing past the horizon
+    \tclock.SetStepLimit(100000)
+    \tsys := NewSystem(1, clock)
+    \tsys.Start()
+    \t
+    \tvar last time.Duration
+    \tyielded := 0
+    \tfor msg := range sys.#{field}.Messages() {
+    \t\tif msg.At > #{horizon}*time.Millisecond {
+    \t\t\tbreak
+    \t\t}
+    \t\tif msg.ID.Seq == 0 || msg.At < last {
+    \t\t\tt.Fatalf("expected messages in order with IDs, got %v at %v after %v", msg.ID, msg.At, last)
+    \t\t}
+    \t\tlast = msg.At
+    \t\tyielded++
+    \t}
+    #{count_check}}
+    """
+  end
+
+  defp generate_alarm_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Send rate alarms
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"time"
+    )
+
+    // Alarm is a threshold an actor's send rate crossed: the metric, its value
+    // in messages per second over the window that crossed it, and when that
+    // window closed
+    type Alarm struct {
+    \tMetric string
+    \tValue float64
+    \tAt time.Duration
+    }
+
+    // rateAlarm watches how fast an actor sends over consecutive windows of
+    // clock time, raising an alarm once each time the rate rises above its
+    // upper threshold or falls below its lower one, in messages per second
+    // A zero threshold is not checked
+    // Only the actor's own inbox touches it
+    type rateAlarm struct {
+    \twindow time.Duration
+    \tabove float64
+    \tbelow float64
+    \tsent int
+    \thigh bool
+    \tlow bool
+    \traised []Alarm
+    }
+
+    // check closes the window ending at now, by which the actor had sent sent
+    // messages in all, and returns the alarm it raised, if any
+    func (r *rateAlarm) check(sent int, now time.Duration) (Alarm, bool) {
+    \trate := float64(sent-r.sent) / r.window.Seconds()
+    \tr.sent = sent
+    \thigh := r.above > 0 && rate > r.above
+    \tlow := r.below > 0 && rate < r.below
+    \tcrossed := high && !r.high || low && !r.low
+    \tr.high, r.low = high, low
+    \tif !crossed {
+    \t\treturn Alarm{}, false
+    \t}
+    \talarm := Alarm{Metric: "send_rate", Value: rate, At: now}
+    \tr.raised = append(r.raised, alarm)
+    \treturn alarm, true
+    }
+    """
+  end
+
+  defp generate_ramp_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Send rates that ramp over time
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"math"
+    \t"time"
+    )
+
+    // Ramp moves a send rate from From to To messages a second over Over,
+    // in a straight line or, when Exponential, by the same factor in equal
+    // times, then holds it at To
+    // Breakpoint is how many messages waiting at one actor mark where the
+    // system falls behind
+    type Ramp struct {
+    \tFrom float64
+    \tTo float64
+    \tOver time.Duration
+    \tExponential bool
+    \tBreakpoint int
+    }
+
+    // Rate returns the send rate elapsed into the ramp
+    func (r Ramp) Rate(elapsed time.Duration) float64 {
+    \tif elapsed >= r.Over {
+    \t\treturn r.To
+    \t}
+    \tif r.Exponential {
+    \t\treturn r.From * math.Pow(r.To/r.From, float64(elapsed)/float64(r.Over))
+    \t}
+    \treturn r.From + (r.To-r.From)*float64(elapsed)/float64(r.Over)
+    }
+
+    // RampReport sums up a ramping actor: its ramp, the rate it has reached
+    // and its breakpoint, once it has found one
+    type RampReport struct {
+    \tActor string
+    \tRamp Ramp
+    \tRate float64
+    \tBreakpoint *Breakpoint
+    }
+
+    // Breakpoint is where a ramp first found Queue messages waiting at an
+    // actor, at least Ramp.Breakpoint: when, at what rate and which actor,
+    // the one with the most waiting
+    type Breakpoint struct {
+    \tAt time.Duration
+    \tRate float64
+    \tActor string
+    \tQueue int
+    }
+
+    // String describes the ramp and where it broke
+    func (r RampReport) String() string {
+    \tramp := fmt.Sprintf("%s ramps from %.4g/s to %.4g/s over %v, now at %.4g/s", r.Actor, r.Ramp.From, r.Ramp.To, r.Ramp.Over, r.Rate)
+    \tif b := r.Breakpoint; b != nil {
+    \t\treturn fmt.Sprintf("%s; breakpoint at %v and %.4g/s, with %d messages waiting at %s", ramp, b.At, b.Rate, b.Queue, b.Actor)
+    \t}
+    \treturn ramp + "; no breakpoint"
+    }
+
+    // ramping is a ramp under way: the actor it drives, when it started and
+    // the breakpoint it has found, if any
+    type ramping struct {
+    \tactor string
+    \tramp Ramp
+    \tstart time.Duration
+    \tbreakpoint *Breakpoint
+    }
+
+    // ramp runs f on an actor once first has elapsed, then each time the next
+    // send falls due at the rate the ramp has reached, looking for the
+    // breakpoint before each
+    // Reconfigure can't change the interval of a ramping actor
+    func (s *System) ramp(to phony.Actor, name string, first time.Duration, r Ramp, f func()) {
+    \trp := &ramping{actor: name, ramp: r, start: s.clock.Now()}
+    \ts.mu.Lock()
+    \ts.ramps = append(s.ramps, rp)
+    \ts.mu.Unlock()
+    \t
+    \tvar tick func()
+    \ttick = func() {
+    \t\telapsed := s.clock.Now() - rp.start
+    \t\ts.findBreakpoint(rp, elapsed)
+    \t\ts.schedule(to, time.Duration(float64(time.Second)/r.Rate(elapsed)), tick)
+    \t\ts.run(to, f)
+    \t}
+    \ts.schedule(to, first, tick)
+    }
+
+    // findBreakpoint notes the breakpoint of a ramp once the most messages
+    // ever waiting at an actor reach its threshold
+    func (s *System) findBreakpoint(rp *ramping, elapsed time.Duration) {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \tif rp.breakpoint != nil {
+    \t\treturn
+    \t}
+    \tvar b *Breakpoint
+    \tfor name, a := range s.actors {
+    \t\tpeak := int(a.(contextual).context().peak.Load())
+    \t\tif peak < rp.ramp.Breakpoint || (b != nil && (peak < b.Queue || peak == b.Queue && name > b.Actor)) {
+    \t\t\tcontinue
+    \t\t}
+    \t\tb = &Breakpoint{At: s.clock.Now(), Rate: rp.ramp.Rate(elapsed), Actor: name, Queue: peak}
+    \t}
+    \trp.breakpoint = b
+    }
+
+    // rampReports reports on every ramp in the order the ramps started
+    func (s *System) rampReports() []RampReport {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \treports := make([]RampReport, 0, len(s.ramps))
+    \tfor _, rp := range s.ramps {
+    \t\tr := RampReport{Actor: rp.actor, Ramp: rp.ramp, Rate: rp.ramp.Rate(s.clock.Now() - rp.start)}
+    \t\tif rp.breakpoint != nil {
+    \t\t\tb := *rp.breakpoint
+    \t\t\tr.Breakpoint = &b
+    \t\t}
+    \t\treports = append(reports, r)
+    \t}
+    \treturn reports
+    }
+    """
+  end
+
+  defp generate_routing_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Routing each message to one target: by edge weights that change over
+    // time, in turn or at random
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // WeightStep gives an edge Weight from At on
+    type WeightStep struct {
+    \tAt time.Duration
+    \tWeight int
+    }
+
+    // WeightSchedule is the steps an edge's weight goes through, in order
+    type WeightSchedule []WeightStep
+
+    // Weight returns the weight of the last step due by now
+    func (w WeightSchedule) Weight(now time.Duration) int {
+    \tweight := 1
+    \tfor _, step := range w {
+    \t\tif step.At > now {
+    \t\t\tbreak
+    \t\t}
+    \t\tweight = step.Weight
+    \t}
+    \treturn weight
+    }
+
+    // Routing is how a router picks the one target each message goes to
+    type Routing int
+
+    const (
+    \t// RouteWeighted picks by smooth weighted round-robin over edge weights
+    \tRouteWeighted Routing = iota
+    \t// RouteRoundRobin picks each target in turn
+    \tRouteRoundRobin
+    \t// RouteRandom picks a target drawn from the actor's RNG stream
+    \tRouteRandom
+    )
+
+    // router holds how an actor routes, the weight schedules of its edges,
+    // keyed by target, the credit of each target, the cursor of a turn or
+    // the draw of a random pick, and how many messages went to each target
+    // Edges without a schedule keep weight 1
+    type router struct {
+    \tstrategy Routing
+    \tschedules map[phony.Actor]WeightSchedule
+    \tcredit map[phony.Actor]int
+    \tcursor int
+    \tdraw func() float64
+    \tcounts map[phony.Actor]int
+    }
+
+    // weight returns the weight of the edge to to at now
+    func (r *router) weight(to phony.Actor, now time.Duration) int {
+    \tif schedule, ok := r.schedules[to]; ok {
+    \t\treturn schedule.Weight(now)
+    \t}
+    \treturn 1
+    }
+
+    // route picks the target of the next message as the router's strategy
+    // has it, the same way on a rerun
+    // It returns the pick under its index, or nothing without a pick
+    func route[T phony.Actor](r *router, now time.Duration, targets []T) map[int]T {
+    \tif r.credit == nil {
+    \t\tr.credit = map[phony.Actor]int{}
+    \t\tr.counts = map[phony.Actor]int{}
+    \t}
+    \tif len(targets) == 0 {
+    \t\treturn nil
+    \t}
+    \tvar best int
+    \tswitch r.strategy {
+    \tcase RouteRoundRobin:
+    \t\tbest = r.cursor % len(targets)
+    \t\tr.cursor = best + 1
+    \tcase RouteRandom:
+    \t\tbest = int(r.draw() * float64(len(targets)))
+    \tdefault:
+    \t\tbest = weighted(r, now, targets)
+    \t}
+    \tif best < 0 {
+    \t\treturn nil
+    \t}
+    \tr.counts[targets[best]]++
+    \treturn map[int]T{best: targets[best]}
+    }
+
+    // weighted picks by smooth weighted round-robin over the weights the
+    // edges have at now, so traffic shifts as they change
+    // It returns -1 while every weight is zero; a target at zero weight loses
+    // its credit
+    func weighted[T phony.Actor](r *router, now time.Duration, targets []T) int {
+    \tbest, total := -1, 0
+    \tfor i, target := range targets {
+    \t\tw := r.weight(target, now)
+    \t\tif w == 0 {
+    \t\t\tr.credit[target] = 0
+    \t\t\tcontinue
+    \t\t}
+    \t\tr.credit[target] += w
+    \t\ttotal += w
+    \t\tif best < 0 || r.credit[target] > r.credit[targets[best]] {
+    \t\t\tbest = i
+    \t\t}
+    \t}
+    \tif best >= 0 {
+    \t\tr.credit[targets[best]] -= total
+    \t}
+    \treturn best
+    }
+
+    // routedCounts names the counts of a router, read on the inbox of the
+    // actor that owns it
+    func (s *System) routedCounts(owner phony.Actor, r *router) map[string]int {
+    \ts.mu.Lock()
+    \tactors := make(map[string]actor, len(s.actors))
+    \tfor name, a := range s.actors {
+    \t\tactors[name] = a
+    \t}
+    \ts.mu.Unlock()
+    \t
+    \tcounts := map[string]int{}
+    \tphony.Block(owner, func() {
+    \t\tfor name, a := range actors {
+    \t\t\tif n := r.counts[a]; n > 0 {
+    \t\t\t\tcounts[name] = n
+    \t\t\t}
+    \t\t}
+    \t})
+    \treturn counts
+    }
+    """
+  end
+
+  defp generate_affinity_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Session affinity that keeps a session's messages on one target
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // AffinityCount is how many messages an actor routed to one target, and
+    // how many of them found their session already pinned there, as a cache
+    // on the target would find it warm
+    type AffinityCount struct {
+    \tRouted int
+    \tSticky int
+    }
+
+    // HitRate returns the share of messages routed to the target that found
+    // their session pinned there
+    func (c AffinityCount) HitRate() float64 {
+    \tif c.Routed == 0 {
+    \t\treturn 0
+    \t}
+    \treturn float64(c.Sticky) / float64(c.Routed)
+    }
+
+    // pin is the target a session is pinned to and when it last sent
+    type pin struct {
+    \tto phony.Actor
+    \tlast time.Duration
+    }
+
+    // affinity pins each session to the target its first message went to,
+    // until the session has been idle for longer than window
+    // A message's session is its key modulo sessions
+    type affinity struct {
+    \twindow time.Duration
+    \tsessions uint64
+    \tpinned map[uint64]pin
+    \tcounts map[phony.Actor]*AffinityCount
+    }
+
+    // stick picks the target of the message with key at now: the one its
+    // session is pinned to, or, for a new session, one idle past the window
+    // or pinned to a target since removed, the target with the fewest live
+    // sessions, the first of them on a tie, so a rerun picks alike
+    // It returns the pick under its index, or nothing without targets
+    func stick[T phony.Actor](f *affinity, key uint64, now time.Duration, targets []T) map[int]T {
+    \tif len(targets) == 0 {
+    \t\treturn nil
+    \t}
+    \tif f.pinned == nil {
+    \t\tf.pinned = map[uint64]pin{}
+    \t\tf.counts = map[phony.Actor]*AffinityCount{}
+    \t}
+    \tsession := key % f.sessions
+    \tif p, ok := f.pinned[session]; ok && now-p.last <= f.window {
+    \t\tfor i, target := range targets {
+    \t\t\tif phony.Actor(target) == p.to {
+    \t\t\t\tf.pinned[session] = pin{to: p.to, last: now}
+    \t\t\t\tf.count(p.to, true)
+    \t\t\t\treturn map[int]T{i: target}
+    \t\t\t}
+    \t\t}
+    \t}
+    \t// Rebalance onto the target holding the fewest live sessions
+    \tlive := map[phony.Actor]int{}
+    \tfor s, p := range f.pinned {
+    \t\tif now-p.last > f.window {
+    \t\t\tdelete(f.pinned, s)
+    \t\t\tcontinue
+    \t\t}
+    \t\tlive[p.to]++
+    \t}
+    \tbest := 0
+    \tfor i, target := range targets {
+    \t\tif live[target] < live[targets[best]] {
+    \t\t\tbest = i
+    \t\t}
+    \t}
+    \tf.pinned[session] = pin{to: targets[best], last: now}
+    \tf.count(targets[best], false)
+    \treturn map[int]T{best: targets[best]}
+    }
+
+    // count notes a message routed to to, sticky if its session was pinned there
+    func (f *affinity) count(to phony.Actor, sticky bool) {
+    \tc := f.counts[to]
+    \tif c == nil {
+    \t\tc = &AffinityCount{}
+    \t\tf.counts[to] = c
+    \t}
+    \tc.Routed++
+    \tif sticky {
+    \t\tc.Sticky++
+    \t}
+    }
+
+    // affinityCounts names the counts of an affinity table, read on the inbox
+    // of the actor that owns it
+    func (s *System) affinityCounts(owner phony.Actor, f *affinity) map[string]AffinityCount {
+    \ts.mu.Lock()
+    \tactors := make(map[string]actor, len(s.actors))
+    \tfor name, a := range s.actors {
+    \t\tactors[name] = a
+    \t}
+    \ts.mu.Unlock()
+    \t
+    \tcounts := map[string]AffinityCount{}
+    \tphony.Block(owner, func() {
+    \t\tfor name, a := range actors {
+    \t\t\tif c := f.counts[a]; c != nil {
+    \t\t\t\tcounts[name] = *c
+    \t\t\t}
+    \t\t}
+    \t})
+    \treturn counts
+    }
+    """
+  end
+
+  defp generate_transform_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Filters and maps applied to messages on their way along an edge
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    // EdgeMessage is a message on its way along an edge as the edge's
+    // transforms see it: its kind, its key, which is its sequence number at
+    // the source that produced it, and its payload, which a map replaces
+    type EdgeMessage struct {
+    \tKind string
+    \tKey uint64
+    \tPayload any
+    }
+    """
+  end
+
+  # Each actor's entry echoes its targets and send pattern as the DSL
+  # declares them; edges with sizes carry the bytes the report links give
+  defp generate_report_json_file(actors, topology) do
+    config =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.map_join(fn {name, definition} ->
+        targets =
+          topology.targets
+          |> Map.fetch!(name)
+          |> Enum.map_join(", ", &go_string(to_string(&1)))
+
+        pattern =
+          case definition.send_pattern do
+            nil -> ""
+            send_pattern -> ", SendPattern: #{go_string(inspect(send_pattern))}"
+          end
+
+        "\t{Name: #{go_string(to_string(name))}, Targets: []string{#{targets}}#{pattern}},\n"
+      end)
+
+    links =
+      if uses_sizes?(actors) do
+        """
+        \tfor _, l := range report.Links {
+        \t\tfor i := range r.Edges {
+        \t\t\tif r.Edges[i].From == l.From && r.Edges[i].To == l.To {
+        \t\t\t\tr.Edges[i].Bytes, r.Edges[i].BytesPerSecond = l.Bytes, l.PerSecond
+        \t\t\t}
+        \t\t}
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+    // Generated from ActorSimulation DSL
+    // Run reports in a stable JSON schema for tooling
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"encoding/json"
+    \t"fmt"
+    \t"time"
+    )
+
+    // ReportSchemaVersion is the version of the schema ReportJSON writes
+    // Fields may be added without bumping it; it changes once a field changes
+    // meaning or goes away, so tools can tell the reports they can't read
+    const ReportSchemaVersion = 1
+
+    // JSONReport is a report in the schema ReportJSON writes, with snake_case
+    // names and durations in nanoseconds, so it stays the same however the Go
+    // types behind Report change
+    type JSONReport struct {
+    \tSchemaVersion int `json:"schema_version"`
+    \tSeed int64 `json:"seed"`
+    \tAtNs int64 `json:"at_ns"`
+    \tConfig JSONConfig `json:"config"`
+    \tActors []JSONActor `json:"actors"`
+    \tEdges []JSONEdge `json:"edges"`
+    \tInvariants JSONInvariants `json:"invariants"`
+    }
+
+    // JSONConfig echoes what the system was generated from and runs on
+    type JSONConfig struct {
+    \tVirtual bool `json:"virtual"`
+    \tActors []JSONActorConfig `json:"actors"`
+    }
+
+    // JSONActorConfig is an actor as the DSL declares it: its targets and its
+    // send pattern, if any
+    type JSONActorConfig struct {
+    \tName string `json:"name"`
+    \tTargets []string `json:"targets"`
+    \tSendPattern string `json:"send_pattern,omitempty"`
+    }
+
+    // JSONActor is an actor's row of the report
+    type JSONActor struct {
+    \tName string `json:"name"`
+    \tSent int `json:"sent"`
+    \tReceived int `json:"received"`
+    \tDropped int `json:"dropped"`
+    \tExpired int `json:"expired"`
+    \tLatencyP50Ns int64 `json:"latency_p50_ns"`
+    \tLatencyP99Ns int64 `json:"latency_p99_ns"`
+    \tPeakQueue int `json:"peak_queue"`
+    \tQueue JSONTimeStats `json:"queue"`
+    \tService JSONTimeStats `json:"service"`
+    \tMetrics map[string]float64 `json:"metrics,omitempty"`
+    }
+
+    // JSONTimeStats is TimeStats with durations in nanoseconds
+    type JSONTimeStats struct {
+    \tCount int `json:"count"`
+    \tP50Ns int64 `json:"p50_ns"`
+    \tP99Ns int64 `json:"p99_ns"`
+    }
+
+    // JSONEdge is an edge the DSL declares, with the bytes sent on it and
+    // their rate over the run when its messages have sizes
+    type JSONEdge struct {
+    \tFrom string `json:"from"`
+    \tTo string `json:"to"`
+    \tBytes int64 `json:"bytes"`
+    \tBytesPerSecond float64 `json:"bytes_per_second"`
+    }
+
+    // JSONInvariants are the totals of the conservation ledger, in flight
+    // leaving out messages queued behind busy actors, and whether
+    // CheckConservation found them to balance
+    type JSONInvariants struct {
+    \tConserved bool `json:"conserved"`
+    \tViolation string `json:"violation,omitempty"`
+    \tProduced int64 `json:"produced"`
+    \tCopied int64 `json:"copied"`
+    \tSunk int64 `json:"sunk"`
+    \tDropped int64 `json:"dropped"`
+    \tExpired int64 `json:"expired"`
+    \tInFlight int64 `json:"in_flight"`
+    }
+
+    // reportConfig holds the actors the system was generated from
+    var reportConfig = []JSONActorConfig{
+    #{config}}
+
+    // ReportJSON encodes the report as of now in a stable schema, along with
+    // the seed, the configuration and the conservation invariant, for CI or
+    // dashboards to parse and compare across runs
+    // Call it while no handler runs, as CheckConservation
+    func (s *System) ReportJSON() []byte {
+    \treport := s.Report()
+    \tr := JSONReport{
+    \t\tSchemaVersion: ReportSchemaVersion,
+    \t\tSeed: s.seed,
+    \t\tAtNs: int64(report.At),
+    \t\tConfig: JSONConfig{Virtual: s.virtual, Actors: reportConfig},
+    \t\tActors: []JSONActor{},
+    \t\tEdges: []JSONEdge{},
+    \t}
+    \tfor _, a := range report.Actors {
+    \t\tr.Actors = append(r.Actors, JSONActor{
+    \t\t\tName: a.Name,
+    \t\t\tSent: a.Sent,
+    \t\t\tReceived: a.Received,
+    \t\t\tDropped: a.Dropped,
+    \t\t\tExpired: a.Expired,
+    \t\t\tLatencyP50Ns: int64(a.P50),
+    \t\t\tLatencyP99Ns: int64(a.P99),
+    \t\t\tPeakQueue: a.PeakQueue,
+    \t\t\tQueue: JSONTimeStats{Count: a.Queue.Count, P50Ns: int64(a.Queue.P50), P99Ns: int64(a.Queue.P99)},
+    \t\t\tService: JSONTimeStats{Count: a.Service.Count, P50Ns: int64(a.Service.P50), P99Ns: int64(a.Service.P99)},
+    \t\t\tMetrics: a.Metrics,
+    \t\t})
+    \t}
+    \tfor _, a := range reportConfig {
+    \t\tfor _, to := range a.Targets {
+    \t\t\tr.Edges = append(r.Edges, JSONEdge{From: a.Name, To: to})
+    \t\t}
+    \t}
+    #{links}\tr.Invariants = JSONInvariants{
+    \t\tConserved: true,
+    \t\tProduced: s.ledger.produced.Load(),
+    \t\tCopied: s.ledger.copied.Load(),
+    \t\tSunk: s.ledger.sunk.Load(),
+    \t\tDropped: s.ledger.dropped.Load(),
+    \t\tExpired: s.ledger.expired.Load(),
+    \t\tInFlight: s.ledger.inflight.Load(),
+    \t}
+    \tif err := s.CheckConservation(); err != nil {
+    \t\tr.Invariants.Conserved = false
+    \t\tr.Invariants.Violation = err.Error()
+    \t}
+    \treturn r.JSON()
+    }
+
+    // JSON encodes the report as indented JSON
+    func (r JSONReport) JSON() []byte {
+    \tdata, err := json.MarshalIndent(r, "", "  ")
+    \tif err != nil {
+    \t\tpanic(fmt.Sprintf("encoding a report: %v", err))
+    \t}
+    \treturn data
+    }
+
+    // ParseReportJSON reads a report ReportJSON encoded, refusing one in
+    // another version of the schema
+    func ParseReportJSON(data []byte) (JSONReport, error) {
+    \tvar r JSONReport
+    \tif err := json.Unmarshal(data, &r); err != nil {
+    \t\treturn r, err
+    \t}
+    \tif r.SchemaVersion != ReportSchemaVersion {
+    \t\treturn r, fmt.Errorf("report has schema version %d, expected %d", r.SchemaVersion, ReportSchemaVersion)
+    \t}
+    \treturn r, nil
+    }
+
+    // LatencyRegression is a latency quantile of an actor that grew from one
+    // report to the next by more than a tolerance
+    type LatencyRegression struct {
+    \tActor string
+    \tQuantile string
+    \tBase time.Duration
+    \tHead time.Duration
+    }
+
+    // Change is how much the latency grew, as a fraction of the base
+    func (l LatencyRegression) Change() float64 {
+    \treturn float64(l.Head-l.Base) / float64(l.Base)
+    }
+
+    // String describes the regression on one line, e.g. for a CI log
+    func (l LatencyRegression) String() string {
+    \treturn fmt.Sprintf("%s %s latency grew %.1f%%, from %v to %v", l.Actor, l.Quantile, 100*l.Change(), l.Base, l.Head)
+    }
+
+    // LatencyRegressions compares the latency quantiles of each actor in head
+    // with base, returning those that grew by more than tolerance, e.g. 0.05
+    // for 5%
+    // Actors missing from base, or without latency there, are left out
+    func LatencyRegressions(base, head JSONReport, tolerance float64) []LatencyRegression {
+    \tbaseline := map[string]JSONActor{}
+    \tfor _, a := range base.Actors {
+    \t\tbaseline[a.Name] = a
+    \t}
+    \tvar regressions []LatencyRegression
+    \tfor _, a := range head.Actors {
+    \t\tb, ok := baseline[a.Name]
+    \t\tif !ok {
+    \t\t\tcontinue
+    \t\t}
+    \t\tquantiles := []struct {
+    \t\t\tname string
+    \t\t\tbase, head int64
+    \t\t}{{"p50", b.LatencyP50Ns, a.LatencyP50Ns}, {"p99", b.LatencyP99Ns, a.LatencyP99Ns}}
+    \t\tfor _, q := range quantiles {
+    \t\t\tif q.base > 0 && float64(q.head-q.base) > tolerance*float64(q.base) {
+    \t\t\t\tregressions = append(regressions, LatencyRegression{Actor: a.Name, Quantile: q.name, Base: time.Duration(q.base), Head: time.Duration(q.head)})
+    \t\t\t}
+    \t\t}
+    \t}
+    \treturn regressions
+    }
+    """
+  end
+
+  defp generate_reorder_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Reorder buffers that release each source's messages in sequence
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    )
+
+    // ReorderStats sums up a reorder buffer: the messages it holds for an
+    // earlier one from their source, the most it has held at once and the
+    // copies it dropped of messages it had already released or held
+    type ReorderStats struct {
+    \tHeld int
+    \tPeak int
+    \tStale int
+    }
+
+    // ReorderReport is the reorder buffer of an actor in a Report
+    type ReorderReport struct {
+    \tActor string
+    \tReorderStats
+    }
+
+    // String describes how deep the buffer got
+    func (r ReorderReport) String() string {
+    \treturn fmt.Sprintf("%s reorders by sequence: %d held, at most %d at once, %d stale copies dropped",
+    \t\tr.Actor, r.Held, r.Peak, r.Stale)
+    }
+
+    // reorderBuffer releases the messages of each source in the order of their
+    // keys, their sequence numbers at that source, holding every message that
+    // arrives ahead of an earlier one until it has been released
+    // Only the actor's own inbox touches it
+    type reorderBuffer struct {
+    \treleased map[string]uint64
+    \tpending map[string]map[uint64]func()
+    \theld int
+    \tpeak int
+    \tstale int
+    }
+
+    // admit handles the key-th message of source with handle once every earlier
+    // one has been, holding it until then, then releases the held messages that
+    // were only waiting for it
+    // It reports false for a copy of a message released or held already, which
+    // it drops
+    func (r *reorderBuffer) admit(source string, key uint64, handle func()) bool {
+    \tif r.released == nil {
+    \t\tr.released = map[string]uint64{}
+    \t\tr.pending = map[string]map[uint64]func(){}
+    \t}
+    \tnext := r.released[source] + 1
+    \tif key < next {
+    \t\tr.stale++
+    \t\treturn false
+    \t}
+    \tif key > next {
+    \t\tpending := r.pending[source]
+    \t\tif pending == nil {
+    \t\t\tpending = map[uint64]func(){}
+    \t\t\tr.pending[source] = pending
+    \t\t}
+    \t\tif _, ok := pending[key]; ok {
+    \t\t\tr.stale++
+    \t\t\treturn false
+    \t\t}
+    \t\tpending[key] = handle
+    \t\tr.held++
+    \t\tr.peak = max(r.peak, r.held)
+    \t\treturn true
+    \t}
+    \tfor {
+    \t\tr.released[source] = key
+    \t\thandle()
+    \t\tkey++
+    \t\tnext, ok := r.pending[source][key]
+    \t\tif !ok {
+    \t\t\treturn true
+    \t\t}
+    \t\tdelete(r.pending[source], key)
+    \t\tr.held--
+    \t\thandle = next
+    \t}
+    }
+
+    // stats sums up the buffer
+    func (r *reorderBuffer) stats() ReorderStats {
+    \treturn ReorderStats{Held: r.held, Peak: r.peak, Stale: r.stale}
+    }
+    """
+  end
+
+  defp generate_probe_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Probes that record the messages passing on an edge
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    // ProbeStats sums up the messages a probe has passed on: how many, of
+    // each kind, the bytes they carried and how long after being originated
+    // they reached the probe
+    type ProbeStats struct {
+    \tCount int
+    \tKinds map[string]int
+    \tBytes int64
+    \tLatency TimeStats
+    }
+
+    // probeLog counts the messages a probe has passed on, by kind, and their
+    // bytes
+    type probeLog struct {
+    \tkinds map[string]int
+    \tbytes int64
+    }
+
+    // add counts a message of kind, of size bytes
+    func (p *probeLog) add(kind string, bytes int64) {
+    \tif p.kinds == nil {
+    \t\tp.kinds = map[string]int{}
+    \t}
+    \tp.kinds[kind]++
+    \tp.bytes += bytes
+    }
+
+    // stats sums up the counts with the latency of the messages timed
+    func (p *probeLog) stats(latency TimeStats) ProbeStats {
+    \tstats := ProbeStats{Kinds: map[string]int{}, Bytes: p.bytes, Latency: latency}
+    \tfor kind, n := range p.kinds {
+    \t\tstats.Kinds[kind] = n
+    \t\tstats.Count += n
+    \t}
+    \treturn stats
+    }
+    """
+  end
+
+  defp generate_size_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Message sizes and the bytes each edge carries
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"sort"
+    \t"strings"
+    \t"text/tabwriter"
+    )
+
+    // links counts the bytes an actor has sent on each of its edges, keyed
+    // by target
+    type links map[phony.Actor]int64
+
+    // add counts n bytes sent to to
+    func (l *links) add(to phony.Actor, n int64) {
+    \tif *l == nil {
+    \t\t*l = links{}
+    \t}
+    \t(*l)[to] += n
+    }
+
+    // named keys the counts of links by the names of their targets
+    func (s *System) named(l links) map[string]int64 {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \tbytes := map[string]int64{}
+    \tfor name, a := range s.actors {
+    \t\tif n := l[a]; n > 0 {
+    \t\t\tbytes[name] = n
+    \t\t}
+    \t}
+    \treturn bytes
+    }
+
+    // LinkReport sums up an edge: the bytes its actor sent on it and their
+    // rate over the run
+    type LinkReport struct {
+    \tFrom string
+    \tTo string
+    \tBytes int64
+    \tPerSecond float64
+    }
+
+    // link appends a row for each edge an actor has sent bytes on, sorted by
+    // target
+    func (r *Report) link(from string, bytes map[string]int64) {
+    \ttargets := make([]string, 0, len(bytes))
+    \tfor to := range bytes {
+    \t\ttargets = append(targets, to)
+    \t}
+    \tsort.Strings(targets)
+    \tfor _, to := range targets {
+    \t\tperSecond := quotient(float64(bytes[to]), r.At.Seconds())
+    \t\tr.Links = append(r.Links, LinkReport{From: from, To: to, Bytes: bytes[to], PerSecond: perSecond})
+    \t}
+    }
+
+    // Bytes returns the data moved over every link
+    func (r Report) Bytes() int64 {
+    \tvar total int64
+    \tfor _, l := range r.Links {
+    \t\ttotal += l.Bytes
+    \t}
+    \treturn total
+    }
+
+    // writeLinks lays the links out as a table, followed by the data moved
+    func (r Report) writeLinks(b *strings.Builder) {
+    \tw := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+    \tfmt.Fprintln(w, "LINK\\tBYTES\\tBYTES/S")
+    \tfor _, l := range r.Links {
+    \t\tfmt.Fprintf(w, "%s -> %s\\t%d\\t%.0f\\n", l.From, l.To, l.Bytes, l.PerSecond)
+    \t}
+    \tw.Flush()
+    \tfmt.Fprintf(b, "Total data moved: %d bytes\\n", r.Bytes())
+    }
+    """
+  end
+
+  defp generate_reply_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Replies to requests, back to the actor that sent them
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import "github.com/Arceliar/phony"
+
+    // respond sends a reply of kind, carrying payload, back to the actor that
+    // sent the request from is handling, under the request's header, so the
+    // reply keeps its ID for the sender to match it up by
+    // A request produced by a source or delivered with Act has no sender to
+    // reply to, and one from an actor that takes no replies of kind gets none
+    func (s *System) respond(from phony.Actor, kind string, payload any) {
+    \tc := from.(contextual).context()
+    \ts.mu.Lock()
+    \tto, ok := s.actors[c.header.from]
+    \ts.mu.Unlock()
+    \tif !ok {
+    \t\treturn
+    \t}
+    \thandle, ok := to.handler(kind)
+    \tif !ok {
+    \t\treturn
+    \t}
+    \th := c.header
+    \tc.header.payload = payload
+    \ts.ledger.copied.Add(1)
+    \ts.send(from, to, handle)
+    \tc.header = h
+    }
+    """
+  end
+
+  defp generate_ack_file do
+    """
+    // Generated from ActorSimulation DSL
+    // End-to-end acks along the reverse path of a message
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // hop is an actor a message passed through and how long the message
+    // took from there to the next actor on its way
+    type hop struct {
+    \tactor phony.Actor
+    \ttransit time.Duration
+    }
+
+    // hops is the way a message has come from a source on an ack path,
+    // which its header carries; other messages carry none
+    type hops []hop
+
+    // through returns the hops extended by one from from, copied so other
+    // copies of the message keep their own
+    func (p hops) through(from phony.Actor, transit time.Duration) hops {
+    \tif p == nil {
+    \t\treturn nil
+    \t}
+    \treturn append(p[:len(p):len(p)], hop{actor: from, transit: transit})
+    }
+
+    // acking is implemented by sources on an ack path
+    type acking interface {
+    \ttrack(h *header)
+    \tacked(id MessageID)
+    }
+
+    // ack sends the ack for a message a sink has handled back the way the
+    // message came, to the source that produced it
+    func (s *System) ack(from phony.Actor, h header) {
+    \tif len(h.path) == 0 {
+    \t\treturn
+    \t}
+    \ts.relay(from, h.id, h.path)
+    }
+
+    // relay hands an ack on to the last of its hops, taking as long as the
+    // message took over it; an ack a partition or crash stops is lost, which
+    // leaves its message outstanding
+    func (s *System) relay(from phony.Actor, id MessageID, p hops) {
+    \tnext, rest := p[len(p)-1], p[:len(p)-1]
+    \tif s.severed(from, next.actor) {
+    \t\treturn
+    \t}
+    \ts.after(next.actor, next.transit, func() {
+    \t\tif next.actor.(contextual).context().down {
+    \t\t\treturn
+    \t\t}
+    \t\tif len(rest) == 0 {
+    \t\t\tnext.actor.(acking).acked(id)
+    \t\t\treturn
+    \t\t}
+    \t\ts.relay(next.actor, id, rest)
+    \t})
+    }
+    """
+  end
+
+  defp generate_dead_letter_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Dead letters and their reprocessing
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // DeadLetter is a message an edge dropped, to loss or a partition, that
+    // failed every resend: its ID and kind, the target it was meant for, how
+    // many times it was resent and when the system gave up on it
+    type DeadLetter struct {
+    \tID MessageID
+    \tKind string
+    \tTarget phony.Actor
+    \tAttempts int
+    \tAt time.Duration
+    }
+
+    // deadLetter is a dropped message waiting to be resent, with the header it
+    // was first sent under
+    type deadLetter struct {
+    \tDeadLetter
+    \theader header
+    \tf func()
+    }
+
+    // deadLetters holds an actor's dropped messages until its reaper resends
+    // them, and those that failed every resend for inspection
+    // Only the actor's own inbox touches it
+    type deadLetters struct {
+    \twaiting []deadLetter
+    \tfailed []DeadLetter
+    }
+
+    // hold keeps a dropped message to be resent
+    func (d *deadLetters) hold(kind string, target phony.Actor, h header, f func()) {
+    \tl := DeadLetter{ID: h.id, Kind: kind, Target: target}
+    \td.waiting = append(d.waiting, deadLetter{DeadLetter: l, header: h, f: f})
+    }
+
+    // take removes and returns every waiting message
+    func (d *deadLetters) take() []deadLetter {
+    \twaiting := d.waiting
+    \td.waiting = nil
+    \treturn waiting
+    }
+
+    // retry puts back a message dropped again, unless it has been resent max
+    // times, which moves it to the failure list, and reports whether it waits
+    // for another resend
+    func (d *deadLetters) retry(l deadLetter, max int, now time.Duration) bool {
+    \tif l.Attempts >= max {
+    \t\tl.At = now
+    \t\td.failed = append(d.failed, l.DeadLetter)
+    \t\treturn false
+    \t}
+    \td.waiting = append(d.waiting, l)
+    \treturn true
+    }
+    """
+  end
+
+  defp generate_join_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Windowed joins of two streams
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // joinWindow buffers the messages of a join's two streams by key until a
+    // message with the same key arrives on the other stream, or drops them
+    // unmatched once they have waited longer than the window in virtual time
+    // Only the join's own inbox touches it
+    type joinWindow struct {
+    \tsys *System
+    \towner phony.Actor
+    \twithin time.Duration
+    \tsides [2]map[uint64]*joinEntry
+    \tjoined int
+    \texpired int
+    }
+
+    // joinEntry is a buffered message and the timer that closes its window
+    type joinEntry struct {
+    \texpiry Timer
+    }
+
+    func newJoinWindow(sys *System, owner phony.Actor, within time.Duration) *joinWindow {
+    \treturn &joinWindow{sys: sys, owner: owner, within: within, sides: [2]map[uint64]*joinEntry{{}, {}}}
+    }
+
+    // add takes a message with key from one side and reports whether it
+    // completes a pair, which carries on as one message; otherwise the
+    // message waits for its match
+    // A newer message with the same key on the same side replaces the one
+    // waiting, which expires
+    func (w *joinWindow) add(side int, key uint64) bool {
+    \tother := w.sides[1-side]
+    \tif e, ok := other[key]; ok {
+    \t\te.expiry.Stop()
+    \t\tdelete(other, key)
+    \t\tw.joined++
+    \t\tw.sys.ledger.sunk.Add(1)
+    \t\treturn true
+    \t}
+    \tif e, ok := w.sides[side][key]; ok {
+    \t\te.expiry.Stop()
+    \t\tw.expire()
+    \t}
+    \te := &joinEntry{}
+    \te.expiry = w.sys.after(w.owner, w.within, func() {
+    \t\t// A timer that fired as its entry was matched or replaced is stale
+    \t\tif w.sides[side][key] == e {
+    \t\t\tdelete(w.sides[side], key)
+    \t\t\tw.expire()
+    \t\t}
+    \t})
+    \tw.sides[side][key] = e
+    \treturn false
+    }
+
+    func (w *joinWindow) expire() {
+    \tw.expired++
+    \tw.sys.ledger.expired.Add(1)
+    }
+
+    // buffered returns the number of messages waiting for their match
+    func (w *joinWindow) buffered() int {
+    \treturn len(w.sides[0]) + len(w.sides[1])
+    }
+    """
+  end
+
+  defp generate_id_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Reproducible message IDs
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    )
+
+    // MessageID identifies a message across the system: the actor that
+    // originated it and a number from the system's ID sequence
+    // Sends carry it on, so every handler of the message sees the same ID
+    type MessageID struct {
+    \tSource string
+    \tSeq uint64
+    }
+
+    func (id MessageID) String() string {
+    \treturn fmt.Sprintf("%s/%d", id.Source, id.Seq)
+    }
+
+    // NextID returns the next number in the system's ID sequence, starting
+    // at 1; no randomness or wall-clock time goes into it, so two runs with
+    // the same seed on a VirtualClock allocate the same IDs
+    // Safe to call from any actor
+    func (s *System) NextID() uint64 {
+    \treturn s.idGen.Add(1)
+    }
+    """
+  end
+
+  defp generate_envelope_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Message envelopes and their headers
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"time"
+    )
+
+    // Headers travel with a message apart from its payload, so middleware
+    // reads them the same way whatever the payload's type
+    // Trace identifies a sampled message and is zero for the rest; Priority
+    // orders messages to actors of equal priority under the Priority policy,
+    // higher first; Deadline is when the message is due and TTL how long it
+    // may live once produced, zero for neither
+    type Headers struct {
+    \tTrace uint64
+    \tPriority int
+    \tDeadline time.Duration
+    \tTTL time.Duration
+    }
+
+    // Envelope wraps the payload of a message of Kind with its headers
+    type Envelope[T any] struct {
+    \tHeaders
+    \tKind string
+    \tPayload T
+    }
+
+    // Act delivers env to the named actor from outside the system, as a
+    // message of env.Kind produced there and then
+    // The message keeps env's headers and payload on every actor it is
+    // forwarded to, whose middleware sees them in HandlerContext
+    // Safe to call from outside the actors
+    func Act[T any](s *System, name string, env Envelope[T]) error {
+    \ts.mu.Lock()
+    \ta, ok := s.actors[name]
+    \ts.mu.Unlock()
+    \tif !ok {
+    \t\treturn fmt.Errorf("cannot deliver to unknown actor %q", name)
+    \t}
+    \thandle, ok := a.handler(env.Kind)
+    \tif !ok {
+    \t\treturn fmt.Errorf("%s does not handle %q messages", name, env.Kind)
+    \t}
+    \ts.ledger.produced.Add(1)
+    \th := header{Headers: env.Headers, id: MessageID{Source: name, Seq: s.NextID()}, born: s.clock.Now(), payload: env.Payload}
+    \th.key = h.id.Seq
+    \th.enqueued = h.born
+    \ts.deliver(nil, a, h, handle)
+    \ts.record(func(s *System) { Act(s, name, env) })
+    \treturn nil
+    }
+
+    // expired reports whether a message has outlived its TTL by now
+    func (h *header) expired(now time.Duration) bool {
+    \treturn h.TTL > 0 && now-h.born > h.TTL
+    }
+    """
+  end
+
+  defp generate_trace_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Sampled message traces
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    // SetTraceSample traces the given fraction of the messages sources
+    // produce, drawn from the "trace" stream so a run samples the same messages
+    // every time; sends carry the trace on, so every handler of a sampled
+    // message sees it in HandlerContext.Trace
+    // Zero, the default, turns tracing off; call it before Start
+    func (s *System) SetTraceSample(rate float64) {
+    \ts.traceSample = rate
+    \ts.traceDraw = s.Stream("trace")
+    \ts.record(func(s *System) { s.SetTraceSample(rate) })
+    }
+
+    // sample returns a new trace ID for a sampled message, or zero
+    func (s *System) sample() uint64 {
+    \tif s.traceSample <= 0 || s.traceDraw() >= s.traceSample {
+    \t\treturn 0
+    \t}
+    \treturn s.traces.Add(1)
+    }
+
+    // TraceLogger returns middleware that logs every handler run for a
+    // sampled message
+    func TraceLogger(logf func(format string, args ...any)) Middleware {
+    \treturn MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Trace != 0 {
+    \t\t\tlogf("trace %d: %s handles %s at %v", ctx.Trace, ctx.Actor, msg, ctx.Now)
+    \t\t}
+    \t\tnext()
+    \t})
+    }
+    """
+  end
+
+  defp generate_conservation_file(actors) do
+    # A source on an ack path tracks what it produces until it is acked
+    track =
+      if uses_acks?(actors),
+        do: "\tif t, ok := source.(acking); ok {\n\t\tt.track(&c.header)\n\t}\n",
+        else: ""
+
+    """
+    // Generated from ActorSimulation DSL
+    // Message conservation check
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"sync/atomic"
+    )
+
+    // ledger accounts for every copy of a message: sources and Act produce
+    // messages, fan-out and fallbacks copy them, and each copy is eventually
+    // sunk, dropped, expired unmatched in a join window or past its TTL, or
+    // still in flight
+    type ledger struct {
+    \tproduced atomic.Int64
+    \tcopied atomic.Int64
+    \tsunk atomic.Int64
+    \tdropped atomic.Int64
+    \texpired atomic.Int64
+    \tinflight atomic.Int64
+    }
+
+    // queuer is an actor holding messages back in a fair queue, join window
+    // or reorder buffer
+    type queuer interface {
+    \tqueued() int
+    }
+
+    // CheckConservation reports messages created or lost unaccountably:
+    // everything produced or copied must have been sunk, dropped, expired or
+    // still be in flight, counting messages queued behind busy actors
+    // Call it while no handler runs, such as between Advance calls on a
+    // VirtualClock or once the system is quiescent
+    func (s *System) CheckConservation() error {
+    \ts.mu.Lock()
+    \tactors := make([]actor, 0, len(s.actors))
+    \tfor _, a := range s.actors {
+    \t\tactors = append(actors, a)
+    \t}
+    \ts.mu.Unlock()
+    \t
+    \tinflight := s.ledger.inflight.Load()
+    \tfor _, a := range actors {
+    \t\tif q, ok := a.(queuer); ok {
+    \t\t\tinflight += int64(q.queued())
+    \t\t}
+    \t}
+    \tproduced, copied := s.ledger.produced.Load(), s.ledger.copied.Load()
+    \tsunk, dropped, expired := s.ledger.sunk.Load(), s.ledger.dropped.Load(), s.ledger.expired.Load()
+    \tif produced+copied != sunk+dropped+expired+inflight {
+    \t\treturn fmt.Errorf("%d messages produced and %d copied, but %d sunk, %d dropped, %d expired and %d in flight",
+    \t\t\tproduced, copied, sunk, dropped, expired, inflight)
+    \t}
+    \treturn nil
+    }
+
+    // produce originates a message at the named source, keyed by its
+    // sequence number there, given the next message ID and traced if it is
+    // sampled
+    // A source down after a crash produces nothing
+    func (s *System) produce(source contextual, name string, handle func()) {
+    \tc := source.context()
+    \tif c.down {
+    \t\treturn
+    \t}
+    \ts.ledger.produced.Add(1)
+    \tc.produced++
+    \tc.header = header{id: MessageID{Source: name, Seq: s.NextID()}, key: c.produced, born: s.clock.Now()}
+    \tc.header.Trace = s.sample()
+    \tc.header.enqueued = c.header.born
+    \tif c.budget > 0 {
+    \t\tc.header.Deadline = c.header.born + c.budget
+    \t}
+    #{track}\thandle()
+    }
+
+    // forwarded accounts for a handled message sent on to n targets: it
+    // carries on as one of the copies, or is sunk when there are no targets
+    func (s *System) forwarded(n int) {
+    \tif n == 0 {
+    \t\ts.ledger.sunk.Add(1)
+    \t\treturn
+    \t}
+    \ts.ledger.copied.Add(int64(n - 1))
+    }
+    """
+  end
+
+  defp generate_reconfigure_file(actors) do
+    spawn_cases =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.map_join(fn {name, definition} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        shard_fallback =
+          case {parallelism(definition), generate_fallback_setup("shard", definition)} do
+            {nil, _fallback} -> ""
+            {_inboxes, ""} -> ""
+            {_inboxes, fallback} -> "\tfor _, shard := range a.shards {\n#{indent(fallback)}\t}\n"
+          end
+
+        case generate_fallback_setup("a", definition) <> shard_fallback do
+          "" ->
+            "\tcase *#{type_name}:\n\t\treturn new#{type_name}(s)\n"
+
+          fallback_code ->
+            """
+            \tcase *#{type_name}:
+            \t\ta := new#{type_name}(s)
+            #{indent(fallback_code)}\t\treturn a
+            """
+        end
+      end)
+
+    # A spawned actor replies as slowly as the one it is spawned like
+    service_time_code =
+      if uses_timeout?(actors),
+        do: "\t\ts.serviceTime[a] = s.serviceTime[s.actors[spec.Spawn[name]]]\n",
+        else: ""
+
+    """
+    // Generated from ActorSimulation DSL
+    // Runtime reconfiguration of the actor system
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // Spec describes changes to a running system
+    type Spec struct {
+    \t// Spawn starts new actors, keyed by name, each of the same kind as the
+    \t// existing actor it names; spawned actors start without targets
+    \tSpawn map[string]string
+    \t// Connect adds edges; the target must handle every message the
+    \t// sender sends
+    \tConnect []Edge
+    \t// Disconnect removes edges
+    \tDisconnect []Edge
+    \t// Remove stops actors for good and drops every edge to and from them;
+    \t// messages on their way to them are dropped
+    \tRemove []string
+    \t// Intervals changes how often running periodic actors send, from
+    \t// their next tick on
+    \tIntervals map[string]time.Duration
+    }
+
+    // Edge connects a sending actor to a target by name
+    type Edge struct {
+    \tFrom string
+    \tTo string
+    }
+
+    // clone returns a copy of spec that shares nothing with it
+    func (spec *Spec) clone() *Spec {
+    \tc := &Spec{
+    \t\tConnect: append([]Edge(nil), spec.Connect...),
+    \t\tDisconnect: append([]Edge(nil), spec.Disconnect...),
+    \t\tRemove: append([]string(nil), spec.Remove...),
+    \t}
+    \tif spec.Spawn != nil {
+    \t\tc.Spawn = make(map[string]string, len(spec.Spawn))
+    \t\tfor name, like := range spec.Spawn {
+    \t\t\tc.Spawn[name] = like
+    \t\t}
+    \t}
+    \tif spec.Intervals != nil {
+    \t\tc.Intervals = make(map[string]time.Duration, len(spec.Intervals))
+    \t\tfor name, interval := range spec.Intervals {
+    \t\t\tc.Intervals[name] = interval
+    \t\t}
+    \t}
+    \treturn c
+    }
+
+    // actor is any generated actor
+    type actor interface {
+    \tphony.Actor
+    \tStart()
+    \tLabels() map[string]string
+    \tMetrics() ActorMetrics
+    \thandler(kind string) (func(), bool)
+    \treport(r *Report, name string)
+    }
+
+    // connector is implemented by actors with outgoing edges
+    type connector interface {
+    \tactor
+    \taccepts(to phony.Actor) bool
+    \tconnect(to phony.Actor)
+    \tdisconnect(to phony.Actor)
+    }
+
+    // Reconfigure applies spec to the running system without restarting it
+    // Edges change on the sending actor's inbox, so a handler sees either the
+    // old or the new targets, never a mix; call it from outside the actors
+    // Nothing changes if any part of spec is invalid
+    func (s *System) Reconfigure(spec *Spec) error {
+    \t// Fork replays a copy, so changing spec afterwards changes no fork
+    \tspec = spec.clone()
+    \ts.mu.Lock()
+    \tactors := make(map[string]actor, len(s.actors)+len(spec.Spawn))
+    \tfor name, a := range s.actors {
+    \t\tactors[name] = a
+    \t}
+    \ts.mu.Unlock()
+    \t
+    \tspawned := map[string]actor{}
+    \tfor name, like := range spec.Spawn {
+    \t\tif _, ok := actors[name]; ok {
+    \t\t\treturn fmt.Errorf("cannot spawn %q: actor exists", name)
+    \t\t}
+    \t\tkind, ok := actors[like]
+    \t\tif !ok {
+    \t\t\treturn fmt.Errorf("cannot spawn %q like unknown actor %q", name, like)
+    \t\t}
+    \t\tspawned[name] = s.spawn(kind)
+    \t}
+    \tremoved := map[string]actor{}
+    \tfor _, name := range spec.Remove {
+    \t\ta, ok := actors[name]
+    \t\tif !ok {
+    \t\t\treturn fmt.Errorf("cannot remove unknown actor %q", name)
+    \t\t}
+    \t\tif _, err := s.crashable(name); err != nil {
+    \t\t\treturn fmt.Errorf("cannot remove %q: %w", name, err)
+    \t\t}
+    \t\tremoved[name] = a
+    \t\tdelete(actors, name)
+    \t}
+    \tfor name, interval := range spec.Intervals {
+    \t\ta, ok := actors[name]
+    \t\ts.mu.Lock()
+    \t\tt := s.tickers[a]
+    \t\ts.mu.Unlock()
+    \t\tif !ok || t == nil {
+    \t\t\treturn fmt.Errorf("cannot change interval of %q: no running periodic actor", name)
+    \t\t}
+    \t\tif interval <= 0 {
+    \t\t\treturn fmt.Errorf("cannot change interval of %q to %v", name, interval)
+    \t\t}
+    \t}
+    \tfor name, a := range spawned {
+    \t\tactors[name] = a
+    \t}
+    \tfor _, e := range spec.Connect {
+    \t\tfrom, to, err := resolve(actors, e)
+    \t\tif err != nil {
+    \t\t\treturn err
+    \t\t}
+    \t\tif !from.accepts(to) {
+    \t\t\treturn fmt.Errorf("cannot connect %q to %q: it does not handle every message %[1]q sends", e.From, e.To)
+    \t\t}
+    \t}
+    \tfor _, e := range spec.Disconnect {
+    \t\tif _, _, err := resolve(actors, e); err != nil {
+    \t\t\treturn err
+    \t\t}
+    \t}
+    \t
+    \t// A removed actor goes down like a crash that never restarts, losing
+    \t// what is on its way to it
+    \tfor _, a := range removed {
+    \t\tc := a.(contextual).context()
+    \t\tphony.Block(a, func() {
+    \t\t\tc.down = true
+    \t\t\tc.epoch.Add(1)
+    \t\t})
+    \t}
+    \ts.mu.Lock()
+    \tfor name, a := range spawned {
+    \t\ts.actors[name] = a
+    #{service_time_code}\t\ts.instrument(name, a)
+    \t}
+    \tfor name, a := range removed {
+    \t\tdelete(s.actors, name)
+    \t\tdelete(s.tickers, a)
+    \t}
+    \ts.mu.Unlock()
+    \tfor _, gone := range removed {
+    \t\tfor _, a := range actors {
+    \t\t\tif from, ok := a.(connector); ok {
+    \t\t\t\tphony.Block(from, func() { from.disconnect(gone) })
+    \t\t\t}
+    \t\t\tif from, ok := gone.(connector); ok {
+    \t\t\t\tphony.Block(from, func() { from.disconnect(a) })
+    \t\t\t}
+    \t\t}
+    \t}
+    \tfor _, a := range spawned {
+    \t\ta.Start()
+    \t}
+    \tfor _, e := range spec.Disconnect {
+    \t\tfrom, to, _ := resolve(actors, e)
+    \t\tphony.Block(from, func() { from.disconnect(to) })
+    \t}
+    \tfor _, e := range spec.Connect {
+    \t\tfrom, to, _ := resolve(actors, e)
+    \t\tphony.Block(from, func() { from.connect(to) })
+    \t}
+    \tfor name, interval := range spec.Intervals {
+    \t\ts.mu.Lock()
+    \t\ts.tickers[actors[name]].interval.Store(int64(interval))
+    \t\ts.mu.Unlock()
+    \t}
+    \ts.record(func(s *System) { s.Reconfigure(spec) })
+    \treturn nil
+    }
+
+    // resolve looks up the actors of an edge by name
+    func resolve(actors map[string]actor, e Edge) (connector, actor, error) {
+    \tfrom, ok := actors[e.From]
+    \tif !ok {
+    \t\treturn nil, nil, fmt.Errorf("unknown actor %q", e.From)
+    \t}
+    \tto, ok := actors[e.To]
+    \tif !ok {
+    \t\treturn nil, nil, fmt.Errorf("unknown actor %q", e.To)
+    \t}
+    \tc, ok := from.(connector)
+    \tif !ok {
+    \t\treturn nil, nil, fmt.Errorf("actor %q has no outgoing edges", e.From)
+    \t}
+    \treturn c, to, nil
+    }
+
+    // spawn creates an unstarted actor of the same kind as like through the
+    // constructor NewSystem uses, so it has the same settings but no targets
+    func (s *System) spawn(like actor) actor {
+    \tswitch like.(type) {
+    #{spawn_cases}\t}
+    \treturn nil
+    }
+    """
+  end
+
+  # The graph comes from the topology the actors are wired by, so it can't
+  # drift from the targets and fallbacks NewSystem sets up
+  defp generate_topology_file(actors, topology) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+    nodes = Enum.map_join(simulated, fn {name, _definition} -> "\t\"#{name}\"\n" end)
+
+    edges =
+      Enum.map_join(simulated, fn {name, definition} ->
+        label =
+          definition
+          |> outgoing_messages(Map.fetch!(topology.messages, name))
+          |> Kernel.--(reply_roles(topology, name).answers)
+          |> Enum.map_join(", ", &GeneratorUtils.message_name/1)
+
+        targets =
+          Enum.map_join(Map.fetch!(topology.targets, name), fn target ->
+            "\t\"#{name}\" -> \"#{target}\" [label=\"#{label}\"]\n"
+          end)
+
+        case Map.fetch!(topology.fallbacks, name) do
+          nil ->
+            targets
+
+          fallback ->
+            targets <> "\t\"#{name}\" -> \"#{fallback}\" [label=\"#{label}\", style=dashed]\n"
+        end
+      end)
+
+    """
+    // Generated from ActorSimulation DSL
+    // Actor topology in Graphviz DOT
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"io"
+    )
+
+    // topologyDOT is the actor graph the DSL declares
+    const topologyDOT = `digraph actors {
+    \trankdir=LR
+    #{nodes}#{edges}}
+    `
+
+    // WriteDOT writes the actor graph in Graphviz DOT, for dot -Tsvg and the
+    // like: a node per actor, an edge per target labelled with the messages
+    // sent along it and a dashed edge to each fallback
+    // The graph is the wiring NewSystem sets up, without the changes
+    // Reconfigure or a partition makes to it
+    func (s *System) WriteDOT(w io.Writer) error {
+    \t_, err := io.WriteString(w, topologyDOT)
+    \treturn err
+    }
+    """
+  end
+
+  defp generate_trace_sink_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Trace sinks recording every message delivered: in memory and JSON lines,
+    // and Mermaid sequence diagrams of a trace
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"encoding/json"
+    \t"fmt"
+    \t"io"
+    \t"strings"
+    \t"sync"
+    \t"time"
+    )
+
+    // TraceEvent is a message an actor handled: when, on the system's clock,
+    // which actor sent it, as HandlerContext.From has it, which handled it,
+    // its kind and its ID
+    type TraceEvent struct {
+    \tTime time.Duration `json:"time"`
+    \tFrom string `json:"from,omitempty"`
+    \tTo string `json:"to"`
+    \tMessage string `json:"message"`
+    \tID MessageID `json:"id"`
+    }
+
+    // TraceSink receives an event for every message an actor handles, as it
+    // starts handling it
+    // On a RealClock actors record events concurrently, so a sink must be
+    // safe to call from any actor
+    type TraceSink interface {
+    \tRecord(e TraceEvent)
+    }
+
+    // WithTraceSink records every message the actors handle on sink, as
+    // middleware running outside any registered with Use, so tracing a run
+    // takes no change to the actors
+    // Forks and diffs run without it
+    func WithTraceSink(sink TraceSink) Option {
+    \treturn func(s *System) {
+    \t\ts.middleware = append(s.middleware, MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\t\tsink.Record(TraceEvent{Time: ctx.Now, From: ctx.From, To: ctx.Actor, Message: msg, ID: ctx.ID})
+    \t\t\tnext()
+    \t\t}))
+    \t}
+    }
+
+    // TraceRecorder keeps every event recorded in memory, for tests to check
+    // the order messages were handled in
+    type TraceRecorder struct {
+    \tmu sync.Mutex
+    \tevents []TraceEvent
+    }
+
+    // NewTraceRecorder creates an empty recorder
+    func NewTraceRecorder() *TraceRecorder {
+    \treturn &TraceRecorder{}
+    }
+
+    func (r *TraceRecorder) Record(e TraceEvent) {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \tr.events = append(r.events, e)
+    }
+
+    // Events returns the events recorded so far, in the order they were
+    func (r *TraceRecorder) Events() []TraceEvent {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \treturn append([]TraceEvent(nil), r.events...)
+    }
+
+    // JSONLSink writes every event to a writer as a line of JSON, for tools
+    // such as jq to read
+    type JSONLSink struct {
+    \tmu sync.Mutex
+    \tenc *json.Encoder
+    \terr error
+    }
+
+    // NewJSONLSink writes events to w
+    func NewJSONLSink(w io.Writer) *JSONLSink {
+    \treturn &JSONLSink{enc: json.NewEncoder(w)}
+    }
+
+    // Record writes e on a line of its own; once a write fails the sink
+    // writes nothing more
+    func (j *JSONLSink) Record(e TraceEvent) {
+    \tj.mu.Lock()
+    \tdefer j.mu.Unlock()
+    \tif j.err == nil {
+    \t\tj.err = j.enc.Encode(e)
+    \t}
+    }
+
+    // Err returns the error the first failed write returned, if any
+    func (j *JSONLSink) Err() error {
+    \tj.mu.Lock()
+    \tdefer j.mu.Unlock()
+    \treturn j.err
+    }
+
+    // MermaidWindow picks the part of a trace RenderMermaid draws: the events
+    // from From on and before Until, zero for the end of the trace, and of
+    // their messages at most Max arrows, zero for no cap
+    type MermaidWindow struct {
+    \tFrom time.Duration
+    \tUntil time.Duration
+    \tMax int
+    }
+
+    // RenderMermaid writes the events of trace in window as a Mermaid
+    // sequenceDiagram: a participant per actor, in the order they appear,
+    // and an arrow per message sent from one actor to another, in the order
+    // they were handled, labelled with its kind and time
+    // A message with no sender adds its actor but no arrow; a note says how
+    // many arrows the cap left out
+    func RenderMermaid(trace []TraceEvent, w io.Writer, window MermaidWindow) error {
+    \tvar participants, arrows strings.Builder
+    \tseen := map[string]bool{}
+    \tvar first string
+    \tappear := func(name string) {
+    \t\tif name == "" || seen[name] {
+    \t\t\treturn
+    \t\t}
+    \t\tif first == "" {
+    \t\t\tfirst = name
+    \t\t}
+    \t\tseen[name] = true
+    \t\tfmt.Fprintf(&participants, "    participant %s\\n", name)
+    \t}
+    \tdrawn, left := 0, 0
+    \tfor _, e := range trace {
+    \t\tif e.Time < window.From || window.Until > 0 && e.Time >= window.Until {
+    \t\t\tcontinue
+    \t\t}
+    \t\tappear(e.From)
+    \t\tappear(e.To)
+    \t\tswitch {
+    \t\tcase e.From == "":
+    \t\tcase window.Max > 0 && drawn == window.Max:
+    \t\t\tleft++
+    \t\tdefault:
+    \t\t\tfmt.Fprintf(&arrows, "    %s->>%s: %s at %v\\n", e.From, e.To, e.Message, e.Time)
+    \t\t\tdrawn++
+    \t\t}
+    \t}
+    \tif left > 0 {
+    \t\tfmt.Fprintf(&arrows, "    Note over %s: %d more messages left out\\n", first, left)
+    \t}
+    \t_, err := io.WriteString(w, "sequenceDiagram\\n"+participants.String()+arrows.String())
+    \treturn err
+    }
+    """
+  end
+
+  defp generate_metric_sink_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Pluggable metric sinks: Prometheus, expvar and an in-memory recorder
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"expvar"
+    \t"fmt"
+    \t"io"
+    \t"net/http"
+    \t"sort"
+    \t"strconv"
+    \t"strings"
+    \t"sync"
+    \t"time"
+    )
+
+    // MetricSink receives the metrics actors record as they run: counters
+    // that only go up, gauges set to a value, and observations of a
+    // distribution, such as latencies in seconds of virtual time
+    // Every series is labeled with the actor that records it; a sink must be
+    // safe to call from any actor
+    type MetricSink interface {
+    \tCounter(name string, labels map[string]string, delta float64)
+    \tGauge(name string, labels map[string]string, value float64)
+    \tObserve(name string, labels map[string]string, value float64)
+    }
+
+    // Option configures a system as NewSystem creates it
+    type Option func(s *System)
+
+    // WithMetricSink has every actor record its metrics on sink:
+    // actor_messages_received_total, actor_message_latency_seconds,
+    // actor_queue_time_seconds, actor_service_time_seconds and
+    // actor_queue_depth
+    // Without a sink actors record no metrics; forks and diffs run without one
+    func WithMetricSink(sink MetricSink) Option {
+    \treturn func(s *System) { s.metricSink = sink }
+    }
+
+    // meter records the metrics of an actor, or of one of its inboxes, on the
+    // system's sink
+    // The actor's own inbox counts arrivals as it hands them on to the
+    // inboxes behind it, which time handling them
+    type meter struct {
+    \tsink MetricSink
+    \tlabels map[string]string
+    \tinbox bool
+    \tfront bool
+    }
+
+    // instrument names an actor and its inboxes and meters them under the
+    // name
+    func (s *System) instrument(name string, a actor) {
+    \tcs := contexts(a)
+    \tfor _, c := range cs {
+    \t\tc.name = name
+    \t}
+    \tif s.metricSink == nil {
+    \t\treturn
+    \t}
+    \tlabels := map[string]string{"actor": name}
+    \tfor i, c := range cs {
+    \t\tc.meter = &meter{sink: s.metricSink, labels: labels, inbox: i > 0, front: i == 0 && len(cs) > 1}
+    \t}
+    }
+
+    // arrived records a message that reached the actor, latency after it was
+    // produced
+    func (m *meter) arrived(latency time.Duration) {
+    \tif m == nil || m.inbox {
+    \t\treturn
+    \t}
+    \tm.sink.Counter("actor_messages_received_total", m.labels, 1)
+    \tm.sink.Observe("actor_message_latency_seconds", m.labels, latency.Seconds())
+    }
+
+    // handled records how long a message waited and was worked on
+    func (m *meter) handled(queued, service time.Duration) {
+    \tif m == nil || m.front {
+    \t\treturn
+    \t}
+    \tm.sink.Observe("actor_queue_time_seconds", m.labels, queued.Seconds())
+    \tm.sink.Observe("actor_service_time_seconds", m.labels, service.Seconds())
+    }
+
+    // queued records n messages waiting for the actor
+    // Safe to call from any actor
+    func (m *meter) queued(n int64) {
+    \tif m == nil {
+    \t\treturn
+    \t}
+    \tm.sink.Gauge("actor_queue_depth", m.labels, float64(n))
+    }
+
+    // series names a metric's series as Prometheus writes it, with its labels
+    // sorted, e.g. actor_queue_depth{actor="sink"}
+    func series(name string, labels map[string]string) string {
+    \tkeys := make([]string, 0, len(labels))
+    \tfor key := range labels {
+    \t\tkeys = append(keys, key)
+    \t}
+    \tsort.Strings(keys)
+    \tpairs := make([]string, len(keys))
+    \tfor i, key := range keys {
+    \t\tpairs[i] = key + "=" + strconv.Quote(labels[key])
+    \t}
+    \treturn name + "{" + strings.Join(pairs, ",") + "}"
+    }
+
+    // MetricRecorder keeps every metric recorded in memory, for tests to
+    // check what actors recorded in a run
+    type MetricRecorder struct {
+    \tmu sync.Mutex
+    \tcounters map[string]float64
+    \tgauges map[string]float64
+    \tobservations map[string][]float64
+    }
+
+    // NewMetricRecorder creates an empty recorder
+    func NewMetricRecorder() *MetricRecorder {
+    \treturn &MetricRecorder{counters: map[string]float64{}, gauges: map[string]float64{}, observations: map[string][]float64{}}
+    }
+
+    func (r *MetricRecorder) Counter(name string, labels map[string]string, delta float64) {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \tr.counters[series(name, labels)] += delta
+    }
+
+    func (r *MetricRecorder) Gauge(name string, labels map[string]string, value float64) {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \tr.gauges[series(name, labels)] = value
+    }
+
+    func (r *MetricRecorder) Observe(name string, labels map[string]string, value float64) {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \tkey := series(name, labels)
+    \tr.observations[key] = append(r.observations[key], value)
+    }
+
+    // CounterValue returns the sum of what was added to a counter
+    func (r *MetricRecorder) CounterValue(name string, labels map[string]string) float64 {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \treturn r.counters[series(name, labels)]
+    }
+
+    // GaugeValue returns the value a gauge was last set to
+    func (r *MetricRecorder) GaugeValue(name string, labels map[string]string) float64 {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \treturn r.gauges[series(name, labels)]
+    }
+
+    // Observations returns the values observed of a distribution, in order
+    func (r *MetricRecorder) Observations(name string, labels map[string]string) []float64 {
+    \tr.mu.Lock()
+    \tdefer r.mu.Unlock()
+    \treturn append([]float64(nil), r.observations[series(name, labels)]...)
+    }
+
+    // ExpvarSink publishes metrics in an expvar map, one entry per series:
+    // counters and gauges as their value, observations as their count and sum
+    type ExpvarSink struct {
+    \tvars *expvar.Map
+    }
+
+    // NewExpvarSink publishes metrics at /debug/vars under name
+    func NewExpvarSink(name string) ExpvarSink {
+    \tvars, ok := expvar.Get(name).(*expvar.Map)
+    \tif !ok {
+    \t\tvars = expvar.NewMap(name)
+    \t}
+    \treturn ExpvarSink{vars: vars}
+    }
+
+    func (s ExpvarSink) Counter(name string, labels map[string]string, delta float64) {
+    \ts.vars.AddFloat(series(name, labels), delta)
+    }
+
+    func (s ExpvarSink) Gauge(name string, labels map[string]string, value float64) {
+    \tv := new(expvar.Float)
+    \tv.Set(value)
+    \ts.vars.Set(series(name, labels), v)
+    }
+
+    func (s ExpvarSink) Observe(name string, labels map[string]string, value float64) {
+    \ts.vars.AddFloat(series(name+"_count", labels), 1)
+    \ts.vars.AddFloat(series(name+"_sum", labels), value)
+    }
+
+    // PrometheusSink keeps metrics for Prometheus to scrape, served in its
+    // text format: counters and gauges as their value, observations as a
+    // summary of their count and sum
+    type PrometheusSink struct {
+    \tmu sync.Mutex
+    \ttypes map[string]string
+    \tvalues map[string]map[string]float64
+    }
+
+    // NewPrometheusSink creates a sink to serve, e.g. at /metrics
+    func NewPrometheusSink() *PrometheusSink {
+    \treturn &PrometheusSink{types: map[string]string{}, values: map[string]map[string]float64{}}
+    }
+
+    func (p *PrometheusSink) Counter(name string, labels map[string]string, delta float64) {
+    \tp.mu.Lock()
+    \tdefer p.mu.Unlock()
+    \tp.metric(name, "counter")[series(name, labels)] += delta
+    }
+
+    func (p *PrometheusSink) Gauge(name string, labels map[string]string, value float64) {
+    \tp.mu.Lock()
+    \tdefer p.mu.Unlock()
+    \tp.metric(name, "gauge")[series(name, labels)] = value
+    }
+
+    func (p *PrometheusSink) Observe(name string, labels map[string]string, value float64) {
+    \tp.mu.Lock()
+    \tdefer p.mu.Unlock()
+    \tvalues := p.metric(name, "summary")
+    \tvalues[series(name+"_count", labels)]++
+    \tvalues[series(name+"_sum", labels)] += value
+    }
+
+    // metric returns the series of a metric, keyed by name and labels,
+    // adding the metric with its type if it is new
+    func (p *PrometheusSink) metric(name, kind string) map[string]float64 {
+    \tvalues, ok := p.values[name]
+    \tif !ok {
+    \t\tvalues = map[string]float64{}
+    \t\tp.values[name] = values
+    \t\tp.types[name] = kind
+    \t}
+    \treturn values
+    }
+
+    // WriteText writes every metric in the Prometheus text format, sorted
+    func (p *PrometheusSink) WriteText(w io.Writer) error {
+    \tp.mu.Lock()
+    \tdefer p.mu.Unlock()
+    \tnames := make([]string, 0, len(p.values))
+    \tfor name := range p.values {
+    \t\tnames = append(names, name)
+    \t}
+    \tsort.Strings(names)
+    \tvar b strings.Builder
+    \tfor _, name := range names {
+    \t\tfmt.Fprintf(&b, "# TYPE %s %s\\n", name, p.types[name])
+    \t\tkeys := make([]string, 0, len(p.values[name]))
+    \t\tfor key := range p.values[name] {
+    \t\t\tkeys = append(keys, key)
+    \t\t}
+    \t\tsort.Strings(keys)
+    \t\tfor _, key := range keys {
+    \t\t\tfmt.Fprintf(&b, "%s %v\\n", key, p.values[name][key])
+    \t\t}
+    \t}
+    \t_, err := io.WriteString(w, b.String())
+    \treturn err
+    }
+
+    // ServeHTTP serves the metrics for Prometheus to scrape
+    func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+    \tw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    \tp.WriteText(w)
+    }
+    """
+  end
+
+  defp generate_metrics_file(actors, topology) do
+    publish_code =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.map_join(fn {name, definition} ->
+        field = GeneratorUtils.to_camel_case(name)
+        has_targets = Map.fetch!(topology.targets, name) != []
+
+        counters =
+          [
+            {"sendCount", "SendCount"},
+            definition.loss && has_targets && {"lostCount", "LostCount"},
+            definition.timeout && has_targets && {"timeoutCount", "TimeoutCount"},
+            circuit_breaker(definition) && has_targets &&
+              {"shortCircuitCount", "ShortCircuitCount"},
+            at_least_once?(definition) && has_targets && {"retransmitCount", "RetransmitCount"},
+            at_least_once?(definition) && has_targets && {"duplicateCount", "DuplicateCount"},
+            definition.join_by && {"joinedCount", "JoinedCount"},
+            definition.join_by && {"expiredCount", "ExpiredCount"},
+            conflation(definition) && {"conflatedCount", "ConflatedCount"},
+            idempotency(definition) && {"appliedCount", "AppliedCount"},
+            idempotency(definition) && {"dedupedCount", "DedupedCount"},
+            deadline_aware?(definition) && {"shedCount", "ShedCount"},
+            dropping?(definition) && {"dropCount", "DropCount"},
+            dlq_retry(definition) && has_targets && {"deadLetterCount", "DeadLetterCount"},
+            dlq_retry(definition) && has_targets && {"failedCount", "FailedCount"}
+          ]
+          |> Enum.filter(& &1)
+          |> Enum.map_join(", ", fn {key, accessor} ->
+            "\"#{key}\": s.#{field}.#{accessor}()"
+          end)
+
+        # Labeled actors publish their labels and actors deriving metrics
+        # publish those next to the counters
+        extras =
+          [
+            labels(definition) != [] && "\"labels\": s.#{field}.Labels()",
+            derived_metrics(definition) != [] && "\"metrics\": s.Report().Metrics(\"#{name}\")"
+          ]
+          |> Enum.filter(& &1)
+          |> Enum.map_join(fn extra -> ", " <> extra end)
+
+        metric =
+          if extras == "",
+            do: "map[string]int{#{counters}}",
+            else: "map[string]any{#{counters}#{extras}}"
+
+        """
+        \tmetrics.Set("#{name}", expvar.Func(func() any {
+        \t\treturn #{metric}
+        \t}))
+        """
+      end)
+
+    """
+    // Generated from ActorSimulation DSL
+    // Actor metrics published through expvar
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"expvar"
+    )
+
+    // metrics appears at /debug/vars under gen_server_virtual_time
+    var metrics = expvar.NewMap("gen_server_virtual_time")
+
+    // PublishMetrics exposes every actor's counters through expvar
+    // Counters are read on demand, so they stay current while the system runs
+    func (s *System) PublishMetrics() {
+    #{publish_code}}
+    """
+  end
+
+  @report_counters %{
+    sent: "a.Sent",
+    received: "a.Received",
+    dropped: "a.Dropped",
+    expired: "a.Expired",
+    peak_queue: "a.PeakQueue"
+  }
+
+  # Each metrics: entry is an expression of the report's counters, numbers,
+  # :elapsed (seconds) and :capacity (messages the actor could have served
+  # in its service time), e.g. [utilization: {:received, :/, :capacity}]
+  defp derived_metrics(definition) do
+    for {metric, expr} <- definition.metrics || [] do
+      {metric, metric_expr(definition, expr)}
+    end
+  end
+
+  defp metric_expr(definition, {x, :/, y}),
+    do: "quotient(#{metric_expr(definition, x)}, #{metric_expr(definition, y)})"
+
+  defp metric_expr(definition, {x, op, y}) when op in [:+, :-, :*],
+    do: "(#{metric_expr(definition, x)} #{op} #{metric_expr(definition, y)})"
+
+  defp metric_expr(_definition, counter) when is_map_key(@report_counters, counter),
+    do: "float64(#{Map.fetch!(@report_counters, counter)})"
+
+  defp metric_expr(_definition, :elapsed), do: "r.At.Seconds()"
+
+  defp metric_expr(%{service_time: ms} = definition, :capacity) when is_integer(ms) and ms > 0 do
+    inboxes = if parallelism(definition), do: "#{parallelism(definition)} * ", else: ""
+    "#{inboxes}float64(r.At) / float64(#{ms} * time.Millisecond)"
+  end
+
+  defp metric_expr(_definition, n) when is_number(n), do: to_string(n)
+
+  defp metric_expr(definition, expr) do
+    raise ArgumentError,
+          "actor #{inspect(definition.name)} derives a metric from #{inspect(expr)}, expected " <>
+            "#{inspect(Map.keys(@report_counters) ++ [:elapsed, :capacity])}, numbers or " <>
+            "{x, op, y} with op one of :+, :-, :* or :/ (:capacity needs a service_time:)"
+  end
+
+  defp generate_report_file(actors, topology) do
+    # Each actor adds its own row, so a report covers the actors a system
+    # has at the time, whichever kind Reconfigure spawned them as
+    reporters =
+      actors
+      |> GeneratorUtils.simulated_actors()
+      |> Enum.map_join(fn {name, definition} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+        has_targets = Map.fetch!(topology.targets, name) != []
+        # Conflated, shed, overflowing, deduped, stale and filtered messages
+        # are dropped by the actor rather than an edge; with dead letters,
+        # only those that failed every resend are
+        dead_letters? = dlq_retry(definition) && has_targets
+
+        dropped =
+          [
+            definition.loss && has_targets && !dead_letters? && "a.LostCount()",
+            dead_letters? && "a.FailedCount()",
+            conflation(definition) && "a.ConflatedCount()",
+            deadline_aware?(definition) && "a.ShedCount()",
+            dropping?(definition) && "a.DropCount()",
+            idempotency(definition) && "a.DedupedCount()",
+            reorder(definition) && "a.ReorderStats().Stale",
+            transforms(definition) != [] && "a.FilteredCount()"
+          ]
+          |> Enum.filter(& &1)
+          |> Enum.join(" + ")
+          |> case do
+            "" -> "0"
+            counts -> counts
+          end
+
+        expired = if definition.join_by, do: "a.ExpiredCount()", else: "0"
+        inboxes = if parallelism(definition), do: ", a.inboxes()...", else: ""
+
+        derived =
+          Enum.map_join(derived_metrics(definition), fn {metric, expr} ->
+            "\tr.derive(name, \"#{metric}\", func(a ActorReport) float64 { return #{expr} })\n"
+          end)
+
+        reorder_row =
+          if reorder(definition),
+            do:
+              "\tr.Reorders = append(r.Reorders, " <>
+                "ReorderReport{Actor: name, ReorderStats: a.ReorderStats()})\n",
+            else: ""
+
+        link_row =
+          if size(definition) && has_targets, do: "\tr.link(name, a.BytesSent())\n", else: ""
+
+        """
+
+        // report adds the actor's row to r under name, reading its counters
+        // through accessors safe to call from outside the actor
+        func (a *#{type_name}) report(r *Report, name string) {
+        \tr.add(name, a, a.SendCount(), #{dropped}, #{expired}, a.QueueTimeStats(), a.ServiceTimeStats()#{inboxes})
+        #{derived}#{reorder_row}#{link_row}}
+        """
+      end)
+
+    {link_field, link_lines} =
+      if uses_sizes?(actors),
+        do: {"\tLinks []LinkReport\n", "\tr.writeLinks(&b)\n"},
+        else: {"", ""}
+
+    {ramp_field, ramp_rows, ramp_lines} =
+      if uses_ramps?(actors) do
+        {"\tRamps []RampReport\n", "\tr.Ramps = s.rampReports()\n",
+         "\tfor _, ramp := range r.Ramps {\n\t\tfmt.Fprintln(&b, ramp)\n\t}\n"}
+      else
+        {"", "", ""}
+      end
+
+    {reorder_field, reorder_lines} =
+      if uses_reorder?(actors),
+        do:
+          {"\tReorders []ReorderReport\n",
+           "\tfor _, reorder := range r.Reorders {\n\t\tfmt.Fprintln(&b, reorder)\n\t}\n"},
+        else: {"", ""}
+
+    """
+    // Generated from ActorSimulation DSL
+    // Summary report of a run
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"math"
+    \t"sort"
+    \t"strings"
+    \t"text/tabwriter"
+    \t"time"
+    )
+
+    // ActorReport sums up an actor: the messages it sent, received, dropped
+    // and let expire unmatched in a join, the latency from production to
+    // arrival of the messages it received, the most messages it has had
+    // waiting at once, in its inbox or fair queue, how long the messages it
+    // handled waited and were worked on, and the metrics its metrics: option
+    // derives from these
+    type ActorReport struct {
+    \tName string
+    \tSent int
+    \tReceived int
+    \tDropped int
+    \tExpired int
+    \tP50 time.Duration
+    \tP99 time.Duration
+    \tPeakQueue int
+    \tQueue TimeStats
+    \tService TimeStats
+    \tMetrics map[string]float64
+    }
+
+    // TimeStats sums up how long an actor's messages took at one stage: how
+    // many it timed and the median and 99th percentile
+    type TimeStats struct {
+    \tCount int
+    \tP50 time.Duration
+    \tP99 time.Duration
+    }
+
+    // ActorMetrics is what an actor has sent and received so far
+    type ActorMetrics struct {
+    \tSendCount int
+    \tRecvCount int
+    }
+
+    // Report sums up every actor at a point in time
+    type Report struct {
+    \tAt time.Duration
+    \tActors []ActorReport
+    #{ramp_field}#{reorder_field}#{link_field}}
+
+    // Report reads the counters of every actor the system has now, one row
+    // per actor: those NewSystem spawned in declaration order, then those
+    // Reconfigure spawned by name
+    // Safe to call while the system runs
+    func (s *System) Report() Report {
+    \tr := Report{At: s.clock.Now()}
+    \ts.mu.Lock()
+    \tnames := make([]string, 0, len(s.actors))
+    \tactors := make(map[string]actor, len(s.actors))
+    \tfor name, a := range s.actors {
+    \t\tnames = append(names, name)
+    \t\tactors[name] = a
+    \t}
+    \ts.mu.Unlock()
+    \tsort.Slice(names, func(i, j int) bool { return s.declaredBefore(names[i], names[j]) })
+    \t// Read after unlocking, as a handler waiting in an inbox may lock it
+    \tfor _, name := range names {
+    \t\tactors[name].report(&r, name)
+    \t}
+    #{ramp_rows}\treturn r
+    }
+
+    // declaredBefore orders actors the way Report lists them
+    func (s *System) declaredBefore(x, y string) bool {
+    \tif rx, ry := s.ranks[x], s.ranks[y]; rx != ry {
+    \t\treturn ry == 0 || rx != 0 && rx < ry
+    \t}
+    \treturn x < y
+    }
+    #{reporters}
+
+    // SystemMetrics sums up every actor's counters, read the same way
+    // Report reads them
+    // Safe to call while the system runs
+    func (s *System) SystemMetrics() SystemMetrics {
+    \treturn s.Report().SystemMetrics()
+    }
+
+    // RunUntil advances a system running on a VirtualClock to t, running
+    // everything that falls due on the way, and reports on the run
+    // A run the clock's step limit cuts short stops where it got to and
+    // returns the report so far, with an error wrapping ErrStepLimitExceeded;
+    // so does a run a callback halts, with the error the callback returned
+    func (s *System) RunUntil(t time.Duration) (Report, error) {
+    \tclock := s.clock.(*VirtualClock)
+    \tif d := t - clock.Now(); d > 0 {
+    \t\tclock.Advance(d)
+    \t} else {
+    \t\tclock.Advance(0)
+    \t}
+    \tr := s.Report()
+    \tif err := s.Err(); err != nil {
+    \t\treturn r, err
+    \t}
+    \tif clock.Exceeded() {
+    \t\treturn r, fmt.Errorf("%w: %d timers run by %v, %d still pending", ErrStepLimitExceeded, clock.Steps(), r.At, s.Pending())
+    \t}
+    \treturn r, nil
+    }
+
+    // String lays the report out as a table
+    func (r Report) String() string {
+    \tvar b strings.Builder
+    \tfmt.Fprintf(&b, "Report at %v\\n", r.At)
+    \tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+    \tderived := false
+    \tfor _, a := range r.Actors {
+    \t\tderived = derived || len(a.Metrics) > 0
+    \t}
+    \tfmt.Fprint(w, "ACTOR\\tSENT\\tRECEIVED\\tDROPPED\\tEXPIRED\\tP50\\tP99\\tPEAK QUEUE\\tQUEUE P50\\tSERVICE P50")
+    \tif derived {
+    \t\tfmt.Fprint(w, "\\tMETRICS")
+    \t}
+    \tfmt.Fprintln(w)
+    \tfor _, a := range r.Actors {
+    \t\tfmt.Fprintf(w, "%s\\t%d\\t%d\\t%d\\t%d\\t%v\\t%v\\t%d\\t%v\\t%v", a.Name, a.Sent, a.Received, a.Dropped, a.Expired, a.P50, a.P99, a.PeakQueue, a.Queue.P50, a.Service.P50)
+    \t\tif derived {
+    \t\t\tfmt.Fprintf(w, "\\t%s", a.metrics())
+    \t\t}
+    \t\tfmt.Fprintln(w)
+    \t}
+    \tw.Flush()
+    #{ramp_lines}#{reorder_lines}#{link_lines}\treturn b.String()
+    }
+
+    // Metrics returns the metrics derived for the named actor, or nil if it
+    // declares none
+    func (r Report) Metrics(name string) map[string]float64 {
+    \tfor _, a := range r.Actors {
+    \t\tif a.Name == name {
+    \t\t\treturn a.Metrics
+    \t\t}
+    \t}
+    \treturn nil
+    }
+
+    // SystemMetrics sums up a report across its actors: the messages they
+    // sent, received, dropped and let expire in all, the most any of them
+    // had waiting at once, and the virtual time of the report
+    type SystemMetrics struct {
+    \tAt time.Duration
+    \tSent int
+    \tReceived int
+    \tDropped int
+    \tExpired int
+    \tPeakQueue int
+    }
+
+    // SystemMetrics adds up the report's rows
+    func (r Report) SystemMetrics() SystemMetrics {
+    \tm := SystemMetrics{At: r.At}
+    \tfor _, a := range r.Actors {
+    \t\tm.Sent += a.Sent
+    \t\tm.Received += a.Received
+    \t\tm.Dropped += a.Dropped
+    \t\tm.Expired += a.Expired
+    \t\tif a.PeakQueue > m.PeakQueue {
+    \t\t\tm.PeakQueue = a.PeakQueue
+    \t\t}
+    \t}
+    \treturn m
+    }
+
+    // derive sets a metric of the named actor's row, computed from the row
+    // once its counters are in
+    func (r *Report) derive(name, metric string, f func(a ActorReport) float64) {
+    \tfor i := range r.Actors {
+    \t\ta := &r.Actors[i]
+    \t\tif a.Name != name {
+    \t\t\tcontinue
+    \t\t}
+    \t\tif a.Metrics == nil {
+    \t\t\ta.Metrics = map[string]float64{}
+    \t\t}
+    \t\ta.Metrics[metric] = f(*a)
+    \t}
+    }
+
+    // metrics lists the derived metrics as name=value, sorted by name
+    func (a ActorReport) metrics() string {
+    \tnames := make([]string, 0, len(a.Metrics))
+    \tfor name := range a.Metrics {
+    \t\tnames = append(names, name)
+    \t}
+    \tsort.Strings(names)
+    \tfor i, name := range names {
+    \t\tnames[i] = fmt.Sprintf("%s=%.3g", name, a.Metrics[name])
+    \t}
+    \treturn strings.Join(names, " ")
+    }
+
+    // quotient divides x by y, or returns zero when y is zero, such as the
+    // capacity of an actor before any time has passed
+    func quotient(x, y float64) float64 {
+    \tif y == 0 {
+    \t\treturn 0
+    \t}
+    \treturn x / y
+    }
+
+    // add appends an actor's row, reading its context on its inbox; an
+    // actor spread over several inboxes peaks at its deepest
+    func (r *Report) add(name string, a phony.Actor, sent, dropped, expired int, queue, service TimeStats, inboxes ...*messageContext) {
+    \trow := ActorReport{Name: name, Sent: sent, Dropped: dropped, Expired: expired, Queue: queue, Service: service}
+    \tc := a.(contextual).context()
+    \tphony.Block(a, func() {
+    \t\trow.Received = c.delivered
+    \t\trow.P50 = c.latency.quantile(0.5)
+    \t\trow.P99 = c.latency.quantile(0.99)
+    \t})
+    \tpeak := c.peak.Load()
+    \tfor _, inbox := range inboxes {
+    \t\tif n := inbox.peak.Load(); n > peak {
+    \t\t\tpeak = n
+    \t\t}
+    \t}
+    \trow.PeakQueue = int(peak)
+    \tr.Actors = append(r.Actors, row)
+    }
+
+    // arrived notes a message delivered to the actor at now
+    func (c *messageContext) arrived(now time.Duration) {
+    \tc.delivered++
+    \tc.latency.add(now - c.header.born)
+    \tc.meter.arrived(now - c.header.born)
+    }
+
+    // handled notes a message that reached the actor's inbox at enqueued,
+    // which the actor started on at start and finished at end, plus however
+    // long its callback slept
+    func (c *messageContext) handled(enqueued, start, end time.Duration) {
+    \tc.queueTime.add(start - enqueued)
+    \tc.serviceTime.add(end - start + c.slept)
+    \tc.meter.handled(start-enqueued, end-start+c.slept)
+    \tc.slept = 0
+    }
+
+    // expect counts n more messages on their way to the actor
+    // Safe to call from any actor
+    func (c *messageContext) expect(n int64) {
+    \tc.sawQueue(c.inbound.Add(n))
+    }
+
+    // sawQueue notes n messages waiting for the actor at once
+    // Safe to call from any actor
+    func (c *messageContext) sawQueue(n int64) {
+    \tc.meter.queued(n)
+    \tfor {
+    \t\tpeak := c.peak.Load()
+    \t\tif n <= peak || c.peak.CompareAndSwap(peak, n) {
+    \t\t\treturn
+    \t\t}
+    \t}
+    }
+
+    // latencies counts latencies in buckets that widen by an eighth of a
+    // power of two, keeping the largest latency in each, so quantiles are
+    // exact for constant latencies and within 9% otherwise
+    type latencies struct {
+    \tbuckets map[int]*latencyBucket
+    \tn int
+    }
+
+    type latencyBucket struct {
+    \tn int
+    \tmax time.Duration
+    }
+
+    func (l *latencies) add(d time.Duration) {
+    \ti := -1
+    \tif d > 0 {
+    \t\ti = int(math.Ceil(8 * math.Log2(float64(d))))
+    \t}
+    \tb := l.bucket(i)
+    \tb.n++
+    \tif d > b.max {
+    \t\tb.max = d
+    \t}
+    \tl.n++
+    }
+
+    // merge adds the latencies counted in o, such as another shard's
+    func (l *latencies) merge(o *latencies) {
+    \tfor i, ob := range o.buckets {
+    \t\tb := l.bucket(i)
+    \t\tb.n += ob.n
+    \t\tif ob.max > b.max {
+    \t\t\tb.max = ob.max
+    \t\t}
+    \t}
+    \tl.n += o.n
+    }
+
+    // bucket returns bucket i, adding it if it is empty
+    func (l *latencies) bucket(i int) *latencyBucket {
+    \tif l.buckets == nil {
+    \t\tl.buckets = map[int]*latencyBucket{}
+    \t}
+    \tb, ok := l.buckets[i]
+    \tif !ok {
+    \t\tb = &latencyBucket{}
+    \t\tl.buckets[i] = b
+    \t}
+    \treturn b
+    }
+
+    // stats returns the count, median and 99th percentile
+    func (l *latencies) stats() TimeStats {
+    \treturn TimeStats{Count: l.n, P50: l.quantile(0.5), P99: l.quantile(0.99)}
+    }
+
+    // quantile returns the q-quantile, or zero before any latency is added
+    func (l *latencies) quantile(q float64) time.Duration {
+    \tif l.n == 0 {
+    \t\treturn 0
+    \t}
+    \tindexes := make([]int, 0, len(l.buckets))
+    \tfor i := range l.buckets {
+    \t\tindexes = append(indexes, i)
+    \t}
+    \tsort.Ints(indexes)
+    \trank := int(math.Ceil(q * float64(l.n)))
+    \tseen := 0
+    \tfor _, i := range indexes {
+    \t\tseen += l.buckets[i].n
+    \t\tif seen >= rank {
+    \t\t\treturn l.buckets[i].max
+    \t\t}
+    \t}
+    \treturn l.buckets[indexes[len(indexes)-1]].max
+    }
+    """
+  end
+
+  defp generate_partition_file(plan) do
+    """
+    // Generated from ActorSimulation DSL
+    // Network partitions between groups of actors
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // PartitionStep splits the system into Groups at At and heals it at
+    // Heal, both on the system clock
+    type PartitionStep struct {
+    \tAt time.Duration
+    \tHeal time.Duration
+    \tGroups [][]string
+    }
+
+    #{plan}
+
+    // Partition cuts every edge between actors in different groups, dropping
+    // the messages sent across it until Heal; actors left out of every group
+    // form one more group
+    // An actor spread over several inboxes moves with all of them
+    func (s *System) Partition(groups ...[]string) error {
+    \tif err := s.partition(groups...); err != nil {
+    \t\treturn err
+    \t}
+    \ts.record(func(s *System) { s.Partition(groups...) })
+    \treturn nil
+    }
+
+    // partition is Partition for the steps of a plan, which Fork replays by
+    // scheduling the plan again
+    func (s *System) partition(groups ...[]string) error {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \tmembership := map[*messageContext]int32{}
+    \tfor i, group := range groups {
+    \t\tfor _, name := range group {
+    \t\t\ta, ok := s.actors[name]
+    \t\t\tif !ok {
+    \t\t\t\treturn fmt.Errorf("cannot partition unknown actor %q", name)
+    \t\t\t}
+    \t\t\tfor _, c := range contexts(a) {
+    \t\t\t\tmembership[c] = int32(i + 1)
+    \t\t\t}
+    \t\t}
+    \t}
+    \tfor _, a := range s.actors {
+    \t\tfor _, c := range contexts(a) {
+    \t\t\tc.group.Store(membership[c])
+    \t\t}
+    \t}
+    \treturn nil
+    }
+
+    // Heal joins every group back together
+    func (s *System) Heal() {
+    \ts.Partition()
+    }
+
+    // SchedulePartitions partitions the system and heals it again as each
+    // step of plan falls due
+    func (s *System) SchedulePartitions(plan []PartitionStep) error {
+    \tfor _, step := range plan {
+    \t\tif step.Heal < step.At {
+    \t\t\treturn fmt.Errorf("partition at %v heals before it starts, at %v", step.At, step.Heal)
+    \t\t}
+    \t\tfor _, group := range step.Groups {
+    \t\t\tfor _, name := range group {
+    \t\t\t\ts.mu.Lock()
+    \t\t\t\t_, ok := s.actors[name]
+    \t\t\t\ts.mu.Unlock()
+    \t\t\t\tif !ok {
+    \t\t\t\t\treturn fmt.Errorf("cannot partition unknown actor %q", name)
+    \t\t\t\t}
+    \t\t\t}
+    \t\t}
+    \t}
+    \tnow := s.clock.Now()
+    \tfor _, step := range plan {
+    \t\tstep := step
+    \t\ts.schedule(nil, step.At-now, func() { s.partition(step.Groups...) })
+    \t\ts.schedule(nil, step.Heal-now, func() { s.partition() })
+    \t}
+    \ts.record(func(s *System) { s.SchedulePartitions(plan) })
+    \treturn nil
+    }
+
+    // PartitionedCount returns the number of messages dropped because a
+    // partition separated their sender from their target
+    func (s *System) PartitionedCount() int {
+    \treturn int(s.partitioned.Load())
+    }
+
+    // severed reports whether a partition separates from and to
+    func (s *System) severed(from, to phony.Actor) bool {
+    \treturn from.(contextual).context().group.Load() != to.(contextual).context().group.Load()
+    }
+
+    // cut reports whether a partition separates from and to, counting the
+    // message it drops if so
+    func (s *System) cut(from, to phony.Actor) bool {
+    \tif !s.severed(from, to) {
+    \t\treturn false
+    \t}
+    \ts.partitioned.Add(1)
+    \ts.ledger.dropped.Add(1)
+    \treturn true
+    }
+
+    // contexts returns the message contexts of an actor and of its inboxes
+    func contexts(a actor) []*messageContext {
+    \tcs := []*messageContext{a.(contextual).context()}
+    \tif sharded, ok := a.(interface{ inboxes() []*messageContext }); ok {
+    \t\tcs = append(cs, sharded.inboxes()...)
+    \t}
+    \treturn cs
+    }
+    """
+  end
+
+  defp generate_crash_file(plan, blockers) do
+    """
+    // Generated from ActorSimulation DSL
+    // Scripted actor crashes and restarts
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"time"
+    )
+
+    // CrashStep crashes Actor at At and restarts it at Restart, both on the
+    // system clock
+    type CrashStep struct {
+    \tActor string
+    \tAt time.Duration
+    \tRestart time.Duration
+    }
+
+    #{plan}
+
+    // LostMessage is a message a crash lost, queued for the crashed actor, on
+    // its way there or sent to it while it was down: its ID, the actor it was
+    // meant for and when it was lost
+    type LostMessage struct {
+    \tID MessageID
+    \tTo string
+    \tAt time.Duration
+    }
+
+    // SupervisorEvent is what the supervisor did about a crash of Actor at
+    // At: restart it once its downtime elapsed, or give up on it, leaving it
+    // down for good, once it crashed more often than its restart budget allows
+    type SupervisorEvent struct {
+    \tActor string
+    \tAt time.Duration
+    \tGaveUp bool
+    }
+
+    // String describes the event on one line, e.g. for a log
+    func (e SupervisorEvent) String() string {
+    \tif e.GaveUp {
+    \t\treturn fmt.Sprintf("%v: gave up on %s", e.At, e.Actor)
+    \t}
+    \treturn fmt.Sprintf("%v: restarted %s", e.At, e.Actor)
+    }
+
+    // restartBudget allows an actor at most max restarts within any window
+    // of clock time, keeping the times it crashed during the last one
+    // Only the actor's own inbox touches it
+    type restartBudget struct {
+    \tmax int
+    \twithin time.Duration
+    \tcrashes []time.Duration
+    }
+
+    // spend records a crash at now and reports whether the budget still
+    // allows a restart; an actor without a budget always restarts
+    func (b *restartBudget) spend(now time.Duration) bool {
+    \tif b == nil {
+    \t\treturn true
+    \t}
+    \trecent := b.crashes[:0]
+    \tfor _, at := range b.crashes {
+    \t\tif now-at < b.within {
+    \t\t\trecent = append(recent, at)
+    \t\t}
+    \t}
+    \tb.crashes = append(recent, now)
+    \treturn len(b.crashes) <= b.max
+    }
+
+    // restarter is implemented by actors with callbacks, which a restart
+    // replaces
+    type restarter interface {
+    \trestart()
+    }
+
+    // Crash crashes an actor at once, losing its in-memory state along with
+    // every message sent to it so far, and restarts it once downtime has
+    // elapsed, with fresh callbacks restored from the last state they
+    // persisted, if any
+    // Messages sent to it while it is down are lost too, and it sends nothing
+    // An actor that crashes more often than its restart budget allows is
+    // given up on instead, and stays down
+    func (s *System) Crash(name string, downtime time.Duration) error {
+    \tif err := s.crash(name, downtime); err != nil {
+    \t\treturn err
+    \t}
+    \ts.record(func(s *System) { s.Crash(name, downtime) })
+    \treturn nil
+    }
+
+    // crash is Crash for the steps of a plan, which Fork replays by scheduling
+    // the plan again
+    func (s *System) crash(name string, downtime time.Duration) error {
+    \ta, err := s.crashable(name)
+    \tif err != nil {
+    \t\treturn err
+    \t}
+    \tc := a.(contextual).context()
+    \tvar down bool
+    \trestart := true
+    \tphony.Block(a, func() {
+    \t\tdown = c.down
+    \t\tc.down = true
+    \t\tc.epoch.Add(1)
+    \t\tif !down {
+    \t\t\trestart = c.restarts.spend(s.clock.Now())
+    \t\t}
+    \t})
+    \tif down {
+    \t\treturn fmt.Errorf("cannot crash %q: it is down", name)
+    \t}
+    \tif !restart {
+    \t\ts.supervise(name, true)
+    \t\treturn nil
+    \t}
+    \ts.after(a, downtime, func() {
+    \t\t// Reconfigure may have removed it while it was down
+    \t\ts.mu.Lock()
+    \t\tgone := s.actors[name] != a
+    \t\ts.mu.Unlock()
+    \t\tif gone {
+    \t\t\treturn
+    \t\t}
+    \t\tif r, ok := a.(restarter); ok {
+    \t\t\tr.restart()
+    \t\t}
+    \t\tc.down = false
+    \t\ts.supervise(name, false)
+    \t})
+    \treturn nil
+    }
+
+    // supervise records what the supervisor did about a crash of name
+    func (s *System) supervise(name string, gaveUp bool) {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \ts.supervision = append(s.supervision, SupervisorEvent{Actor: name, At: s.clock.Now(), GaveUp: gaveUp})
+    }
+
+    // ScheduleCrashes crashes actors and restarts them again as each step of
+    // plan falls due
+    func (s *System) ScheduleCrashes(plan []CrashStep) error {
+    \tfor _, step := range plan {
+    \t\tif step.Restart < step.At {
+    \t\t\treturn fmt.Errorf("crash of %q at %v restarts before it crashes, at %v", step.Actor, step.At, step.Restart)
+    \t\t}
+    \t\tif _, err := s.crashable(step.Actor); err != nil {
+    \t\t\treturn err
+    \t\t}
+    \t}
+    \tnow := s.clock.Now()
+    \tfor _, step := range plan {
+    \t\tstep := step
+    \t\ts.schedule(nil, step.At-now, func() { s.crash(step.Actor, step.Restart-step.At) })
+    \t}
+    \ts.record(func(s *System) { s.ScheduleCrashes(plan) })
+    \treturn nil
+    }
+
+    // LostMessages returns the messages crashes lost, in the order they were
+    // lost
+    func (s *System) LostMessages() []LostMessage {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \treturn append([]LostMessage(nil), s.lost...)
+    }
+
+    // SupervisorEvents returns the restarts the supervisor made and the
+    // actors it gave up on, in the order it did
+    func (s *System) SupervisorEvents() []SupervisorEvent {
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \treturn append([]SupervisorEvent(nil), s.supervision...)
+    }
+
+    // crashable looks up an actor that can crash: one that keeps no state a
+    // restart would not rebuild, such as a fair queue
+    func (s *System) crashable(name string) (actor, error) {
+    \ts.mu.Lock()
+    \ta, ok := s.actors[name]
+    \ts.mu.Unlock()
+    \tif !ok {
+    \t\treturn nil, fmt.Errorf("cannot crash unknown actor %q", name)
+    \t}
+    #{blockers}\treturn a, nil
+    }
+
+    // lose dead-letters a message a crash lost, counting it as dropped
+    func (s *System) lose(to phony.Actor, h header) {
+    \ts.ledger.dropped.Add(1)
+    \ts.mu.Lock()
+    \tdefer s.mu.Unlock()
+    \tfor name, a := range s.actors {
+    \t\tif a == to {
+    \t\t\ts.lost = append(s.lost, LostMessage{ID: h.id, To: name, At: s.clock.Now()})
+    \t\t}
+    \t}
+    }
+
+    // crashed reports whether a message sent to the actor at epoch is lost,
+    // since the actor crashed after it was sent or is down
+    // Only the actor's own inbox may call it
+    func (c *messageContext) crashed(epoch uint64) bool {
+    \treturn c.down || c.epoch.Load() != epoch
+    }
+    """
+  end
+
+  # Each step of the :crashes option is [actor: name, at: ms, restart: ms]
+  defp crash_plan(actors, crashes) do
+    definitions = actors |> GeneratorUtils.simulated_actors() |> Map.new()
+
+    steps =
+      Enum.map_join(crashes, fn step ->
+        actor = Keyword.get(step, :actor)
+        at = Keyword.get(step, :at)
+        restart = Keyword.get(step, :restart)
+
+        unless Map.has_key?(definitions, actor) and is_integer(at) and at >= 0 and
+                 is_integer(restart) and restart >= at do
+          raise ArgumentError,
+                "invalid crash #{inspect(step)}, expected [actor: name, at: ms, restart: ms] " <>
+                  "restarting no earlier than it crashes"
+        end
+
+        case crash_blocker(Map.fetch!(definitions, actor)) do
+          nil -> :ok
+          reason -> raise ArgumentError, "actor #{inspect(actor)} can't crash: #{reason}"
+        end
+
+        "\t{Actor: #{go_string(to_string(actor))}, At: #{at} * time.Millisecond, " <>
+          "Restart: #{restart} * time.Millisecond},\n"
+      end)
+
+    if steps == "" do
+      "// CrashPlan holds the :crashes generator option\nvar CrashPlan []CrashStep"
+    else
+      "// CrashPlan holds the :crashes generator option\nvar CrashPlan = []CrashStep{\n#{steps}}"
+    end
+  end
+
+  # Actors that can't crash, by type, since a restart would not rebuild
+  # state they keep besides their callbacks
+  defp crash_blockers(actors) do
+    cases =
+      for {name, definition} <- GeneratorUtils.simulated_actors(actors),
+          reason <- [crash_blocker(definition)],
+          reason != nil,
+          into: "" do
+        "\tcase *#{GeneratorUtils.to_pascal_case(name)}:\n" <>
+          "\t\treturn nil, fmt.Errorf(\"cannot crash %q: #{reason}\", name)\n"
+      end
+
+    if cases == "", do: "", else: "\tswitch a.(type) {\n#{cases}\t}\n"
+  end
+
+  defp crash_blocker(definition) do
+    cond do
+      definition.fair_queue -> "it serves a fair queue"
+      definition.join_by -> "it holds a join window"
+      reorder(definition) -> "it holds a reorder buffer"
+      parallelism(definition) -> "its state spans parallel inboxes"
+      true -> nil
+    end
+  end
+
+  defp restart_budget(%{restart: nil}), do: nil
+
+  defp restart_budget(%{name: name, restart: budget} = definition) do
+    with true <- Keyword.keyword?(budget),
+         max when is_integer(max) and max > 0 <- Keyword.get(budget, :max),
+         within when is_integer(within) and within > 0 <- Keyword.get(budget, :within),
+         nil <- crash_blocker(definition) do
+      {max, within}
+    else
+      _invalid ->
+        raise ArgumentError,
+              "actor #{inspect(name)} has invalid restart #{inspect(budget)}, " <>
+                "expected [max: restarts, within: ms] on an actor that can crash"
+    end
+  end
+
+  # Each step of the :partitions option is [at: ms, heal: ms, groups: [[name]]]
+  defp partition_plan(actors, partitions) do
+    names = actors |> GeneratorUtils.simulated_actors() |> Enum.map(fn {name, _def} -> name end)
+
+    steps =
+      Enum.map_join(partitions, fn step ->
+        at = Keyword.get(step, :at)
+        heal = Keyword.get(step, :heal)
+        groups = Keyword.get(step, :groups, [])
+
+        unless is_integer(at) and at >= 0 and is_integer(heal) and heal >= at and
+                 is_list(groups) do
+          raise ArgumentError,
+                "invalid partition #{inspect(step)}, expected [at: ms, heal: ms, groups: " <>
+                  "[[actor, ...], ...]] healing no earlier than it starts"
+        end
+
+        groups_code =
+          Enum.map_join(groups, ", ", fn group ->
+            "{" <> Enum.map_join(group, ", ", &partition_member(&1, names)) <> "}"
+          end)
+
+        "\t{At: #{at} * time.Millisecond, Heal: #{heal} * time.Millisecond, " <>
+          "Groups: [][]string{#{groups_code}}},\n"
+      end)
+
+    if steps == "" do
+      "// PartitionPlan holds the :partitions generator option\nvar PartitionPlan []PartitionStep"
+    else
+      "// PartitionPlan holds the :partitions generator option\n" <>
+        "var PartitionPlan = []PartitionStep{\n#{steps}}"
+    end
+  end
+
+  defp partition_member(name, names) do
+    unless name in names do
+      raise ArgumentError, "partition names unknown actor #{inspect(name)}"
+    end
+
+    go_string(to_string(name))
+  end
+
+  defp generate_main(
+         project_name,
+         library,
+         seed,
+         metrics_addr,
+         trace_sample,
+         partitions?,
+         crashes?,
+         logs?
+       ) do
+    # A library's command qualifies what it uses of the library
+    {pkg, library_import} =
+      case library do
+        nil -> {"", ""}
+        {package, module} -> {"#{package}.", "\n\t#{import_spec(package, module)}\n"}
+      end
+
+    {log_import, trace_code} =
+      if trace_sample > 0 do
+        {"\t\"log\"\n",
+         "\t\n\t// Log the handlers of sampled messages\n" <>
+           "\tsys.SetTraceSample(#{trace_sample})\n" <>
+           "\tsys.Use(#{pkg}TraceLogger(log.Printf))\n\t\n"}
+      else
+        {"", ""}
+      end
+
+    partition_code =
+      if partitions? do
+        """
+        \t
+        \t// Partition and heal groups of actors as planned
+        \tif err := sys.SchedulePartitions(#{pkg}PartitionPlan); err != nil {
+        \t\tpanic(err)
+        \t}
+        """
+      else
+        ""
+      end
+
+    crash_code =
+      if crashes? do
+        """
+        \t
+        \t// Crash and restart actors as planned
+        \tif err := sys.ScheduleCrashes(#{pkg}CrashPlan); err != nil {
+        \t\tpanic(err)
+        \t}
+        """
+      else
+        ""
+      end
+
+    log_code =
+      if logs? do
+        """
+        \t
+        \t// LOG_EVERY=n makes callbacks log one in every n of their lines
+        \tif every := os.Getenv("LOG_EVERY"); every != "" {
+        \t\tn, err := strconv.Atoi(every)
+        \t\tif err != nil || sys.SetLogEvery(n) != nil {
+        \t\t\tfmt.Printf("Ignoring LOG_EVERY=%s: expected a positive count\\n", every)
+        \t\t}
+        \t}
+        """
+      else
+        ""
+      end
+
+    log_summary =
+      if logs? do
+        """
+        \tif n := sys.SuppressedLogs(); n > 0 {
+        \t\tfmt.Printf("Suppressed %d log lines\\n", n)
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+    // Generated from ActorSimulation DSL
+    // Main entry point for #{project_name}
+
+    package main
+
+    import (
+    \t"fmt"
+    #{log_import}\t"net/http"
+    \t"os"
+    \t"os/signal"
+    \t"strconv"
+    \t"syscall"
+    \t"time"
+    #{library_import})
+
+    func main() {
+    \tfmt.Println("Starting actor system...")
+    \t
+    \t// SPEED scales real time, e.g. SPEED=10 runs ten times as fast
+    \tclock := #{pkg}NewRealClock()
+    \tif speed := os.Getenv("SPEED"); speed != "" {
+    \t\tfactor, err := strconv.ParseFloat(speed, 64)
+    \t\tif err != nil || factor <= 0 {
+    \t\t\tfmt.Printf("Ignoring SPEED=%s: expected a positive factor\\n", speed)
+    \t\t} else {
+    \t\t\tclock = #{pkg}NewRealClockWithSpeed(factor)
+    \t\t\tfmt.Printf("Running at %gx speed\\n", factor)
+    \t\t}
+    \t}
+    \t
+    \t// On Ctrl+C, REPORT=path saves the run's report encoded as CODEC: json,
+    \t// the default, or gob
+    \tcodec := #{pkg}JSONCodec
+    \tif name := os.Getenv("CODEC"); name != "" {
+    \t\tif c, ok := #{pkg}Codecs[name]; ok {
+    \t\t\tcodec = c
+    \t\t} else {
+    \t\t\tfmt.Printf("Ignoring CODEC=%s: expected json or gob\\n", name)
+    \t\t}
+    \t}
+    \t
+    \t// Spawn and wire all actors, recording their metrics for Prometheus
+    \tprometheus := #{pkg}NewPrometheusSink()
+    \tsys := #{pkg}NewSystem(#{seed}, clock, #{pkg}WithMetricSink(prometheus))
+    \t
+    \t// PROGRESS=10s prints how far the run got every 10s of clock time
+    \tif every := os.Getenv("PROGRESS"); every != "" {
+    \t\tinterval, err := time.ParseDuration(every)
+    \t\tif err != nil || interval <= 0 {
+    \t\t\tfmt.Printf("Ignoring PROGRESS=%s: expected a positive duration\\n", every)
+    \t\t} else {
+    \t\t\tsys.OnProgress(interval, func(p #{pkg}ProgressInfo) { fmt.Println(p) })
+    \t\t}
+    \t}
+    #{log_code}#{trace_code}#{partition_code}#{crash_code}\tsys.Start()
+    \t
+    \t// METRICS=1 serves actor counters at http://#{metrics_addr}/debug/vars
+    \t// and metrics at http://#{metrics_addr}/metrics
+    \tif os.Getenv("METRICS") != "" {
+    \t\tsys.PublishMetrics()
+    \t\thttp.Handle("/metrics", prometheus)
+    \t\tgo func() {
+    \t\t\tif err := http.ListenAndServe("#{metrics_addr}", nil); err != nil {
+    \t\t\t\tfmt.Printf("Not serving metrics: %v\\n", err)
+    \t\t\t}
+    \t\t}()
+    \t}
+    \t
+    \tfmt.Println("Actor system started. Press Ctrl+C to exit.")
+    \t
+    \t// Run until interrupted or a callback fails, then stop the timers
+    \t// before reporting
+    \tinterrupt := make(chan os.Signal, 1)
+    \tsignal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+    \tselect {
+    \tcase <-interrupt:
+    \tcase <-sys.Halted():
+    \t\tfmt.Printf("Halted: %v\\n", sys.Err())
+    \t}
+    \tsys.Stop()
+    #{log_summary}\tif path := os.Getenv("REPORT"); path != "" {
+    \t\tf, err := os.Create(path)
+    \t\tif err != nil {
+    \t\t\tpanic(err)
+    \t\t}
+    \t\tdefer f.Close()
+    \t\tif err := sys.SaveReport(f, codec); err != nil {
+    \t\t\tpanic(err)
+    \t\t}
+    \t\tfmt.Printf("Saved the report to %s\\n", path)
+    \t}
+    \t// REPORT_JSON=path saves it in the stable schema of ReportJSON, for CI
+    \tif path := os.Getenv("REPORT_JSON"); path != "" {
+    \t\tif err := os.WriteFile(path, sys.ReportJSON(), 0o644); err != nil {
+    \t\t\tpanic(err)
+    \t\t}
+    \t\tfmt.Printf("Saved the JSON report to %s\\n", path)
+    \t}
+    }
+    """
+  end
+
+  defp generate_test_file(
+         actors,
+         topology,
+         module,
+         trace_sample,
+         enable_callbacks,
+         interfaces
+       ) do
+    simulated = GeneratorUtils.simulated_actors(actors)
+    definitions = Map.new(simulated)
+    horizon = test_horizon(simulated)
+
+    test_cases =
+      Enum.map_join(simulated, "\n\n", fn {name, definition} ->
+        type_name = GeneratorUtils.to_pascal_case(name)
+        targets = Map.fetch!(topology.targets, name)
+        sent = expected_sent(name, definition, targets, definitions, topology, horizon)
+
+        assertion =
+          if sent do
+            """
+            \th.AssertSendCount(sys.#{GeneratorUtils.to_camel_case(name)}, #{sent})
+            """
+          else
+            """
+            \t// Send count depends on loss, delays, timeouts, queuing, joins or a feedback cycle
+            """
+          end
+
+        """
+        func Test#{type_name}(t *testing.T) {
+        \tclock := NewVirtualClock()
+        \tsys := NewSystem(1, clock)
+        \th := simtest.NewHarness(t, sys, clock)
+        \t
+        \th.Advance(#{horizon} * time.Millisecond)
+        \th.DrainQuiescent()
+        #{assertion}}
+        """
+      end)
+
+    fallback_tests =
+      simulated
+      |> Enum.filter(fn {name, definition} ->
+        definition.timeout != nil and Map.fetch!(topology.targets, name) != []
+      end)
+      |> Enum.map_join(fn {name, definition} ->
+        generate_fallback_test(name, definition, Map.fetch!(topology.targets, name), horizon)
+      end)
+
+    # The trip needs the actor's own sends, whose times are known
+    breaker_tests =
+      simulated
+      |> Enum.filter(fn {name, definition} ->
+        circuit_breaker(definition) && steady?(definition) &&
+          Map.fetch!(topology.targets, name) != []
+      end)
+      |> Enum.map_join(fn {name, definition} ->
+        generate_breaker_test(name, definition, Map.fetch!(topology.targets, name), horizon)
+      end)
+
+    # Copies resent on at-least-once edges can be lost as well, so sent and
+    # lost only add up to the messages handled on best-effort edges
+    sweep_tests =
+      Enum.map_join(simulated, fn {name, definition} ->
+        targets = Map.fetch!(topology.targets, name)
+        handled = expected_handled(name, definitions, topology, horizon, [])
+
+        if definition.loss && targets != [] && handled && immediate?(definition) &&
+             not at_least_once?(definition) && dlq_retry(definition) == nil do
+          generate_sweep_test(name, handled * length(targets), horizon)
+        else
+          ""
+        end
+      end)
+
+    # Timeouts and queues can keep work pending for as long as requests arrive
+    # Timeouts, queues and acknowledgements keep timers armed under steady
+    # traffic
+    settles? =
+      Enum.all?(simulated, fn {_name, definition} ->
+        immediate?(definition) and not at_least_once?(definition)
+      end)
+
+    # Needs two timers by the horizon, which a limit of one cuts short
+    step_limit_test =
+      if Enum.any?(simulated, fn {_name, definition} ->
+           originated_count(definition, horizon) > 1 and
+             not match?({:burst, _count, _interval, _message}, definition.send_pattern)
+         end),
+         do: generate_step_limit_test(horizon),
+         else: ""
+
+    quiescent_test =
+      if simulated != [] and settles? do
+        generate_quiescent_test(horizon)
+      else
+        ""
+      end
+
+    conservation_test =
+      case simulated do
+        [] ->
+          ""
+
+        [{name, _definition} | _] ->
+          generate_conservation_test(name, horizon, settles?)
+      end
+
+    {middleware_test, id_test} =
+      case Enum.find(simulated, fn {_name, definition} -> definition.send_pattern end) do
+        nil ->
+          {"", ""}
+
+        {name, _definition} ->
+          {generate_middleware_test(name, horizon), generate_id_test(horizon)}
+      end
+
+    # Needs an actor that handles a message as it arrives, and only once
+    # since it sits on no cycle, nor gets replies back
+    envelope_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        Map.fetch!(topology.messages, name) != [] and immediate?(definition) and
+          name not in reach(Map.fetch!(topology.edges, name), topology.edges) and
+          reply_roles(topology, name).answers == []
+      end)
+      |> case do
+        nil ->
+          ""
+
+        {name, _definition} ->
+          kind = GeneratorUtils.message_name(hd(Map.fetch!(topology.messages, name)))
+          generate_envelope_test(name, kind, horizon)
+      end
+
+    # Needs an actor whose callbacks see a typed message as soon as Act
+    # hands it over
+    typed_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        kind =
+          topology.messages
+          |> Map.fetch!(name)
+          |> Kernel.--(Keyword.keys(replies(definition)))
+          |> Enum.map(&GeneratorUtils.message_name/1)
+          |> Enum.find(&Map.has_key?(topology.types, &1))
+
+        if kind && callbacks?(definition, enable_callbacks) && immediate?(definition) &&
+             definition.parallelism == nil do
+          generate_typed_test(name, kind, Map.fetch!(topology.types, kind))
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Needs one message to reach two targets at the same instant, handled
+    # as it arrives rather than after a turn in a fair queue or a hop to
+    # another inbox
+    policy_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        definition.send_pattern != nil and length(Map.fetch!(topology.targets, name)) > 1 and
+          definition.loss == nil and definition.delay == nil and immediate?(definition) and
+          sends_first?(name, definition, simulated) and
+          Enum.all?(Map.fetch!(topology.targets, name), fn target ->
+            definitions[target].fair_queue == nil and definitions[target].parallelism == nil
+          end)
+      end)
+      |> case do
+        nil -> ""
+        {name, _definition} -> generate_policy_test(Map.fetch!(topology.targets, name), horizon)
+      end
+
+    # Needs an actor that the messages of two sources reach
+    merge_test =
+      simulated
+      |> Enum.find_value(fn {name, _definition} ->
+        sources =
+          for {source, definition} <- simulated,
+              source != name and definition.loss == nil and
+                originated_count(definition, horizon) > 0 and
+                name in reach([source], topology.edges),
+              do: source
+
+        if length(sources) > 1, do: {name, length(sources)}
+      end)
+      |> case do
+        nil -> generate_tie_test(simulated, topology, horizon)
+        {name, sources} -> generate_merge_test(name, sources, horizon)
+      end
+
+    reconfigure_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        steady?(definition) and Map.fetch!(topology.targets, name) != [] and
+          definition.loss == nil and immediate?(definition)
+      end)
+      |> case do
+        nil ->
+          ""
+
+        {name, definition} ->
+          [target | _] = targets = Map.fetch!(topology.targets, name)
+
+          removable =
+            case List.keyfind(simulated, target, 0) do
+              {_target, target_definition} -> crash_blocker(target_definition) == nil
+              nil -> false
+            end
+
+          generate_reconfigure_test(name, definition, targets, horizon, removable)
+      end
+
+    diff_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        steady?(definition) and Map.fetch!(topology.targets, name) != [] and
+          definition.loss == nil and immediate?(definition)
+      end)
+      |> case do
+        nil -> ""
+        {name, definition} -> generate_diff_test(name, definition)
+      end
+
+    fork_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        steady?(definition) and Map.fetch!(topology.targets, name) != [] and
+          definition.loss == nil and immediate?(definition)
+      end)
+      |> case do
+        nil -> ""
+        {name, definition} -> generate_fork_test(name, definition, horizon)
+      end
+
+    labels_test =
+      case Enum.find(simulated, fn {_name, definition} -> labels(definition) != [] end) do
+        nil -> ""
+        {_name, definition} -> generate_labels_test(simulated, hd(labels(definition)))
+      end
+
+    ramp_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        if ramp(definition) && Map.fetch!(topology.targets, name) != [] do
+          bottleneck = ramp_bottleneck(name, ramp(definition), definitions, topology)
+          generate_ramp_test(name, ramp(definition), bottleneck)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Needs a source that routes only its own sends, each the moment it
+    # makes it
+    routing_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        weight_schedule(definition) != nil and periodic?(definition.send_pattern) and
+          ramp(definition) == nil and definition.fair_queue == nil and
+          definition.join_by == nil and Map.fetch!(topology.targets, name) != [] and
+          not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end)
+      end)
+      |> case do
+        nil ->
+          ""
+
+        {name, definition} ->
+          targets = Map.fetch!(topology.targets, name)
+
+          if static_weights(definition) != nil and targets == Enum.uniq(targets),
+            do: generate_weight_split_test(name, definition, targets),
+            else: generate_routing_test(name, definition, targets, horizon)
+      end
+
+    # Needs an actor routing in turn to distinct targets from its own sends
+    round_robin_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        targets = Map.fetch!(topology.targets, name)
+
+        if routing(definition) == :round_robin and periodic?(definition.send_pattern) and
+             targets != [] and targets == Enum.uniq(targets) do
+          generate_round_robin_test(name, targets, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Needs a source whose keys are its own steady sends, spaced evenly, so
+    # each session sends again after the same gap
+    affinity_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        affinity(definition) != nil and
+          match?({kind, _, _} when kind in [:periodic, :rate], definition.send_pattern) and
+          ramp(definition) == nil and definition.loss == nil and definition.timeout == nil and
+          definition.fair_queue == nil and definition.join_by == nil and
+          Map.fetch!(topology.targets, name) != [] and
+          not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end)
+      end)
+      |> case do
+        nil -> ""
+        {name, definition} -> generate_affinity_test(name, definition, horizon)
+      end
+
+    # Needs a source that sizes its own messages and sends only those, to
+    # every target the moment it makes them
+    size_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        [message | _] = Definition.messages_for_pattern(definition.send_pattern)
+        bytes = message_size(definition, GeneratorUtils.message_name(message))
+
+        if bytes && steady?(definition) && immediate?(definition) &&
+             definition.service_time == nil && Map.fetch!(topology.targets, name) != [] &&
+             not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end) do
+          generate_size_test(name, originated_count(definition, horizon) * bytes, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    ack_test =
+      case Enum.find(simulated, fn {_name, definition} -> ack_path(definition) end) do
+        nil -> ""
+        {name, definition} ->
+          generate_ack_test(name, originated_count(definition, horizon), horizon)
+      end
+
+    # Needs a source sending requests, so no two of them share an ID
+    reply_test =
+      topology.requests
+      |> Enum.find(fn {from, _to, _kind, _reply} ->
+        not Enum.any?(topology.edges, fn {_from, edges} -> from in edges end)
+      end)
+      |> case do
+        nil -> ""
+        {from, to, _kind, _reply} -> generate_reply_test(from, to, topology, horizon)
+      end
+
+    trace_test =
+      if trace_sample > 0, do: generate_trace_test(simulated, topology, trace_sample), else: ""
+
+    observe_test =
+      case Enum.find(simulated, fn {_name, definition} -> definition.observe end) do
+        nil ->
+          ""
+
+        # A queued or held message is recorded once served or released, which
+        # may be after the run
+        {name, %{fair_queue: nil, reorder_by: nil}} ->
+          handled = expected_handled(name, definitions, topology, horizon, [])
+          generate_observe_test(name, handled, horizon)
+
+        {name, _definition} ->
+          generate_observe_test(name, nil, horizon)
+      end
+
+    alarm_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        targets = Map.fetch!(topology.targets, name)
+
+        if alarm(definition) && targets != [] do
+          generate_alarm_test(name, definition, length(targets), horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    timer_test =
+      case Enum.find(simulated, fn {_name, definition} -> timers(definition) != [] end) do
+        nil -> ""
+        {name, definition} -> generate_timer_test(name, timers(definition), horizon)
+      end
+
+    # Only the one-shot moves the send count of an actor nothing sends to
+    one_shot_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        one_shot?(definition) and Map.fetch!(topology.edges, name) != [] and
+          immediate?(definition) and
+          not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end)
+      end)
+      |> case do
+        nil ->
+          ""
+
+        {name, %{send_pattern: {:self_message, delay_ms, _message}} = definition} ->
+          generate_one_shot_test(name, Definition.first_send_delay(definition), delay_ms)
+      end
+
+    payload_test =
+      case Enum.find(simulated, fn {_name, definition} -> definition.payload end) do
+        nil ->
+          ""
+
+        {name, definition} ->
+          until = max(horizon, Definition.first_send_delay(definition))
+          generate_payload_test(name, definition, payload(definition, topology.types).kind, until)
+      end
+
+    shard_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        handled = expected_handled(name, definitions, topology, horizon, [])
+
+        if saturates?(definition, handled, horizon),
+          do: generate_shard_test(name, definition, horizon)
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    conflation_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        handled = expected_handled(name, definitions, topology, horizon, [])
+
+        if conflation(definition) && parallelism(definition) == nil &&
+             backlogs?(definition, handled, horizon) do
+          messages = Map.fetch!(topology.messages, name)
+          generate_conflation_test(name, definition, messages, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Only a backlog makes messages wait past their deadline
+    deadlines_set = Enum.any?(simulated, fn {_name, definition} -> deadline(definition) end)
+
+    # Needs an actor whose mailbox fills from what Act hands it at time 0,
+    # with nothing else sent to it by then
+    mailbox_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        sends_at_zero? =
+          Enum.any?(simulated, fn {_name, sender} -> Definition.first_send_delay(sender) == 0 end)
+
+        with {capacity, overflow} <- mailbox(definition),
+             true <- parallelism(definition) == nil and definition.join_by == nil,
+             true <- (definition.service_time || 0) > 0 and not sends_at_zero? do
+          [kind | _kinds] = Map.fetch!(topology.messages, name)
+          generate_mailbox_test(name, definition, kind, capacity, overflow)
+        else
+          _no_test -> nil
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Only a backlog makes messages wait in a fair queue
+    timing_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        handled = expected_handled(name, definitions, topology, horizon, [])
+
+        if definition.fair_queue != nil && parallelism(definition) == nil &&
+             backlogs?(definition, handled, horizon) do
+          generate_timing_test(name, definition, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    shed_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        handled = expected_handled(name, definitions, topology, horizon, [])
+
+        if deadlines_set && deadline_aware?(definition) && parallelism(definition) == nil &&
+             backlogs?(definition, handled, horizon) do
+          generate_shed_test(name, definition, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    join_test =
+      case Enum.find(simulated, fn {_name, definition} -> definition.join_by end) do
+        nil -> ""
+        {name, definition} -> generate_join_test(name, definition, definitions, topology, horizon)
+      end
+
+    metrics_test =
+      case simulated do
+        [] -> ""
+        [{name, _definition} | _] -> generate_metrics_test(name)
+      end
+
+    # Needs a source whose sends all wait on its own callback, and the next
+    # tick to come after the 10ms sleep
+    sleep_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        enable_callbacks and steady?(definition) and
+          Map.fetch!(topology.targets, name) != [] and definition.loss == nil and
+          immediate?(definition) and
+          Definition.interval_for_pattern(definition.send_pattern) > 10 and
+          not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end) and
+          reply_roles(topology, name).answers == []
+      end)
+      |> case do
+        nil ->
+          ""
+
+        {name, definition} ->
+          targets = Map.fetch!(topology.targets, name)
+          messages = Map.fetch!(topology.messages, name)
+          generate_sleep_test(name, definition, messages, targets, topology.types)
+      end
+
+    # Needs a source whose first tick falls within the horizon, so its first
+    # message's callback fails before anything else it handles
+    halt_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        enable_callbacks and periodic?(definition.send_pattern) and immediate?(definition) and
+          not probe?(definition) and Definition.first_send_delay(definition) < horizon and
+          not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end)
+      end)
+      |> case do
+        nil ->
+          ""
+
+        {name, _definition} ->
+          messages = Map.fetch!(topology.messages, name)
+          generate_halt_test(name, messages, topology.types, horizon)
+      end
+
+    # Needs an actor with one at-least-once edge, to a target nothing else
+    # sends to, so every duplicate the target gets comes from that edge
+    delivery_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        targets = Map.fetch!(topology.targets, name)
+
+        case Enum.filter(targets, &(edge_retry(definition, &1) > 0)) do
+          [target] when target != name and definition.join_by == nil ->
+            senders = for {from, edges} <- topology.edges, target in edges, do: from
+
+            if senders == [name] do
+              retry = edge_retry(definition, target)
+
+              idempotency_test =
+                if idempotency(Map.fetch!(definitions, target)),
+                  do: generate_idempotency_test(name, target, retry, horizon),
+                  else: ""
+
+              generate_delivery_test(name, target, retry, horizon) <> idempotency_test
+            end
+
+          _edges ->
+            nil
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Needs a probe on the edge of a steady sender, so messages keep coming
+    # once the probe is taken off the edge
+    probe_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        senders = for {from, targets} <- topology.targets, name in targets, do: from
+
+        with [sender] <- senders,
+             true <- probe?(definition),
+             %{timeout: nil, affinity_by: nil} <- definition,
+             :broadcast <- routing(definition),
+             %{loss: nil, delivery: nil} = sending <- Map.fetch!(definitions, sender),
+             true <- periodic?(sending.send_pattern) do
+          targets = Map.fetch!(topology.targets, name)
+          generate_probe_test(sender, name, targets, size(definition) != nil, horizon)
+        else
+          _mismatch -> nil
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Dead letters resend what the partition drops
+    partition_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        periodic?(definition.send_pattern) and dlq_retry(definition) == nil and
+          Enum.any?(Map.fetch!(topology.targets, name), &(&1 != name))
+      end)
+      |> case do
+        nil -> ""
+        {name, _definition} -> generate_partition_test(name, horizon)
+      end
+
+    # The target of a steady sender crashes and restarts while the sender
+    # sends on to it, with nothing delayed past the restart
+    crash_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        target =
+          topology.targets
+          |> Map.fetch!(name)
+          |> Enum.find(fn target ->
+            target != name and Map.has_key?(definitions, target) and
+              crash_blocker(Map.fetch!(definitions, target)) == nil
+          end)
+
+        if target != nil and periodic?(definition.send_pattern) and
+             2 * Definition.interval_for_pattern(definition.send_pattern) <= horizon and
+             definition.loss == nil and definition.delay == nil and definition.delivery == nil and
+             immediate?(definition) do
+          callbacks? = callbacks?(Map.fetch!(definitions, target), enable_callbacks)
+          generate_crash_test(target, horizon, callbacks?)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    restart_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        case restart_budget(definition) do
+          nil -> nil
+          {max, _within} -> generate_restart_test(name, max, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    aging_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        service_time = definition.service_time || 0
+
+        case priority_aging(definition) do
+          {_step, every} when service_time > 0 and 2 * service_time < every ->
+            if parallelism(definition) == nil do
+              kind = GeneratorUtils.message_name(hd(Map.fetch!(topology.messages, name)))
+              generate_aging_test(name, kind, service_time, every)
+            end
+
+          _none ->
+            nil
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    idle_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        case idle_timeout(definition) do
+          nil -> nil
+          ms -> generate_idle_test(name, ms)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Each actor receives what it handles, less what it originates
+    received =
+      for {name, definition} <- simulated,
+          handled <- [expected_handled(name, definitions, topology, horizon, [])],
+          handled != nil,
+          do: {name, handled - originated_count(definition, horizon)}
+
+    derived =
+      for {name, definition} <- simulated, derived_metrics(definition) != [] do
+        {name, Enum.map(derived_metrics(definition), fn {metric, _expr} -> metric end)}
+      end
+
+    # The total is known once every actor's count is
+    total =
+      if length(received) == length(simulated),
+        do: received |> Enum.map(&elem(&1, 1)) |> Enum.sum()
+
+    report_test =
+      generate_report_test(length(simulated), received, derived, horizon) <>
+        generate_system_metrics_test(total, horizon)
+
+    # Breaks on the actor receiving the most, once it has received a few
+    debugger_test =
+      case Enum.filter(received, fn {_name, n} -> n > 0 end) do
+        [] ->
+          ""
+
+        counts ->
+          {name, n} = Enum.max_by(counts, fn {_name, n} -> n end)
+          generate_debugger_test(name, min(n, 5), horizon)
+      end
+
+    # Sampling leaves lines out once an actor logs more than one
+    log_test =
+      if enable_callbacks and
+           Enum.any?(simulated, fn {_name, definition} ->
+             originated_count(definition, horizon) > 1
+           end),
+         do: generate_log_test(horizon),
+         else: ""
+    codec_test = generate_codec_test(horizon)
+
+    report_json_test =
+      if simulated == [] do
+        ""
+      else
+        generate_report_json_test(length(simulated), horizon)
+      end
+
+    # Its first message must be dropped early enough for the partition to
+    # heal before the first resend
+    dead_letter_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        with %{interval: interval} <- dlq_retry(definition),
+             true <- periodic?(definition.send_pattern) and definition.loss == nil,
+             true <- Enum.any?(Map.fetch!(topology.targets, name), &(&1 != name)),
+             first = Definition.first_send_delay(definition),
+             true <- 2 * first < interval do
+          generate_dead_letter_test(name, definition)
+        else
+          _ -> nil
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Any originating actor sends to its first target within the horizon
+    iface_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        targets = Map.fetch!(topology.targets, name)
+
+        if interfaces and targets != [] and definition.send_pattern != nil do
+          target = hd(targets)
+          messages = Map.fetch!(topology.messages, target)
+          generate_iface_test(name, target, messages, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    # Needs a steady sender alone on the edge it transforms, so everything
+    # its target receives came through the transforms
+    transform_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        with [{target, steps} | _] <- transforms(definition),
+             true <- enable_callbacks and parallelism(definition) == nil,
+             true <- periodic?(definition.send_pattern),
+             [^name] <- for({from, edges} <- topology.edges, target in edges, do: from) do
+          generate_transform_test(name, definition, target, steps, horizon)
+        else
+          _mismatch -> nil
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    kafka_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        case kafka_source(definition) do
+          nil ->
+            nil
+
+          source ->
+            sink =
+              Enum.find_value(simulated, fn {other, other_definition} ->
+                with %{topic: topic} <- kafka_sink(other_definition),
+                     true <- other != name,
+                     [message | _] <- Map.fetch!(topology.messages, other) do
+                  {other, topic, GeneratorUtils.message_name(message)}
+                else
+                  _none -> nil
+                end
+              end)
+
+            generate_kafka_test(name, source, sink, horizon)
+        end
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    context_import = if kafka_test != "", do: "\t\"context\"\n", else: ""
+
+    phony_import =
+      if sleep_test != "" or halt_test != "" or iface_test != "" or transform_test != "" or
+           typed_test != "" or (crash_test != "" and enable_callbacks),
+         do: "\t\"github.com/Arceliar/phony\"\n",
+         else: ""
+
+    errors_import =
+      if step_limit_test != "" or halt_test != "", do: "\t\"errors\"\n", else: ""
+
+    benchmarks = generate_benchmarks(simulated, topology, enable_callbacks)
+    os_import = if benchmarks != "", do: "\t\"os\"\n", else: ""
+
+    queue_tests =
+      if uses_fair_queue?(actors) do
+        """
+
+        func TestFairQueueSharesByWeight(t *testing.T) {
+        \tq := NewFairQueue(3, 1)
+        \tfor i := 0; i < 400; i++ {
+        \t\tq.Push(0, func() {})
+        \t\tq.Push(1, func() {})
+        \t}
+        \t
+        \tserved := make([]int, 2)
+        \tfor i := 0; i < 400; i++ {
+        \t\tclass, _, _ := q.Pop()
+        \t\tserved[class]++
+        \t}
+        \t
+        \tif served[0] != 300 || served[1] != 100 {
+        \t\tt.Fatalf("expected a 3:1 share under saturation, got %d:%d", served[0], served[1])
+        \t}
+        }
+        """
+      else
+        ""
+      end
+
+    loss_tests =
+      if uses_loss?(actors) do
+        """
+
+        func TestGilbertElliottIsReproducible(t *testing.T) {
+        \tfirst := NewGilbertElliott(NewSystem(7, NewVirtualClock()).Float64, 0.1, 0.3, 0, 1)
+        \tsecond := NewGilbertElliott(NewSystem(7, NewVirtualClock()).Float64, 0.1, 0.3, 0, 1)
+        \t
+        \tdropped := 0
+        \tfor i := 0; i < 1000; i++ {
+        \t\td := first.Drop()
+        \t\tif d != second.Drop() {
+        \t\t\tt.Fatalf("message %d: same seed produced different losses", i)
+        \t\t}
+        \t\tif d {
+        \t\t\tdropped++
+        \t\t}
+        \t}
+        \t
+        \tif dropped == 0 || dropped == 1000 {
+        \t\tt.Fatalf("expected bursts of loss, got %d of 1000 dropped", dropped)
+        \t}
+        }
+
+        func TestLossStreamsAreIndependent(t *testing.T) {
+        \tquiet := NewSystem(7, NewVirtualClock())
+        \tbusy := NewSystem(7, NewVirtualClock())
+        \tlossy := NewGilbertElliott(busy.Stream("loss/a/b"), 0.5, 0.1, 0, 1)
+        \tfor i := 0; i < 100; i++ {
+        \t\tlossy.Drop()
+        \t}
+        \t
+        \twant, got := quiet.Stream("loss/c/d"), busy.Stream("loss/c/d")
+        \tfor i := 0; i < 100; i++ {
+        \t\tif w, g := want(), got(); g != w {
+        \t\t\tt.Fatalf("draw %d: another edge's loss shifted this edge's stream to %v, want %v", i, g, w)
+        \t\t}
+        \t}
+        \tif quiet.Stream("loss/a/b")() == quiet.Stream("loss/c/d")() {
+        \t\tt.Fatal("expected streams of different names to draw different numbers")
+        \t}
+        }
+        """
+      else
+        ""
+      end
+
+    delay_tests = if uses_delay?(actors), do: generate_delay_test(), else: ""
+
+    # Needs an actor messages reach from one source along a single path of
+    # constant delays, arriving within the horizon; the longest path adds
+    # up the most delays
+    latency_test =
+      simulated
+      |> Enum.flat_map(fn {name, definition} ->
+        with true <- immediate?(definition),
+             {[source | _hops] = path, total} when total > 0 <-
+               delay_path(name, definitions, topology, [name]),
+             first = Definition.first_send_delay(Map.fetch!(definitions, source)),
+             true <- first + total < horizon do
+          [{name, path, total}]
+        else
+          _no_path -> []
+        end
+      end)
+      |> Enum.max_by(fn {_name, path, _total} -> length(path) end, fn -> nil end)
+      |> case do
+        nil -> ""
+        {name, path, total} -> generate_latency_test(name, path, total, horizon)
+      end
+
+    # The replay can be counted when every message it sends comes from the
+    # schedule and goes straight on
+    replay_test =
+      simulated
+      |> Enum.find(fn {name, definition} ->
+        definition.schedule_file != nil and Map.fetch!(topology.targets, name) != [] and
+          definition.loss == nil and immediate?(definition) and
+          not Enum.any?(topology.edges, fn {_from, edges} -> name in edges end)
+      end)
+      |> case do
+        nil -> ""
+        {name, _definition} -> generate_replay_test(name, Map.fetch!(topology.targets, name))
+      end
+
+    schedule_tests =
+      if uses_schedule?(actors), do: generate_schedule_unit_test() <> replay_test, else: ""
+
+    breaker_tests =
+      if uses_breaker?(actors), do: generate_breaker_unit_test() <> breaker_tests, else: ""
+
+    # Needs messages reaching a reorder buffer, none of them lost on the way,
+    # or every later message of their source would wait for them
+    reorder_test =
+      simulated
+      |> Enum.find_value(fn {name, definition} ->
+        sent? = Enum.any?(topology.edges, fn {_from, edges} -> name in edges end)
+        lossless? = not Enum.any?(simulated, fn {_name, sending} -> sending.loss end)
+
+        if reorder(definition) && sent? && lossless?, do: generate_reorder_test(name, horizon)
+      end)
+      |> case do
+        nil -> ""
+        test -> test
+      end
+
+    feature_tests =
+      Enum.join([
+        fallback_tests,
+        breaker_tests,
+        loss_tests,
+        delay_tests,
+        latency_test,
+        delivery_test,
+        probe_test,
+        reorder_test,
+        transform_test,
+        schedule_tests,
+        sweep_tests,
+        quiescent_test,
+        step_limit_test,
+        conservation_test,
+        queue_tests,
+        middleware_test,
+        envelope_test,
+        typed_test,
+        policy_test,
+        merge_test,
+        reconfigure_test,
+        generate_topology_test(simulated, topology),
+        fork_test,
+        diff_test,
+        partition_test,
+        crash_test,
+        restart_test,
+        dead_letter_test,
+        labels_test,
+        shard_test,
+        conflation_test,
+        shed_test,
+        mailbox_test,
+        timing_test,
+        iface_test,
+        join_test,
+        observe_test,
+        alarm_test,
+        timer_test,
+        one_shot_test,
+        payload_test,
+        idle_test,
+        aging_test,
+        kafka_test,
+        ramp_test,
+        routing_test,
+        round_robin_test,
+        affinity_test,
+        size_test,
+        ack_test,
+        reply_test,
+        trace_test,
+        id_test,
+        sleep_test,
+        halt_test,
+        log_test,
+        report_test,
+        debugger_test,
+        generate_depth_test(horizon),
+        generate_progress_test(horizon),
+        codec_test,
+        report_json_test,
+        metrics_test,
+        generate_metric_sink_test(horizon),
+        generate_trace_sink_test(simulated, topology, horizon),
+        generate_clock_speed_test(),
+        generate_race_test(length(simulated)),
+        benchmarks
+      ])
+
+    """
+    // Generated from ActorSimulation DSL
+    // Go tests for actors
+
+    package main
+
+    import (
+    #{context_import}\t"encoding/json"
+    #{errors_import}\t"fmt"
+    #{phony_import}#{os_import}\t"runtime"
+    \t"strings"
+    \t"testing"
+    \t"time"
+
+    \t"#{module}/simtest"
+    )
+
+    func TestActorSystem(t *testing.T) {
+    \t// Basic system test
+    \tif testing.Short() {
+    \t\tt.Skip("Skipping in short mode")
+    \t}
+    }
+
+    // runUntil runs sys to until and stops the test if the run fails
+    func runUntil(t *testing.T, sys *System, until time.Duration) Report {
+    \tt.Helper()
+    \treport, err := sys.RunUntil(until)
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \treturn report
+    }
+
+    #{test_cases}#{feature_tests}
+    """
+  end
+
+  defp generate_fallback_test(name, definition, targets, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    slow_targets =
+      Enum.map_join(targets, fn target ->
+        "\tsys.serviceTime[sys.#{GeneratorUtils.to_camel_case(target)}] = " <>
+          "2 * #{definition.timeout} * time.Millisecond\n"
+      end)
+
+    """
+
+    func Test#{type_name}FallsBack(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \t// Make every target too slow to reply before the timeout
+    #{slow_targets}\th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif sys.#{field}.TimeoutCount() == 0 {
+    \t\tt.Fatal("expected requests to fall back to #{definition.fallback}")
+    \t}
+    }
+    """
+  end
+
+  defp generate_breaker_unit_test do
+    """
+
+    func TestBreakerOpensAndRecovers(t *testing.T) {
+    \tvar now time.Duration
+    \tb := NewBreaker(3, time.Second, func() time.Duration { return now })
+    \tfor i := 0; i < 3; i++ {
+    \t\tif !b.Allow() {
+    \t\t\tt.Fatalf("send %d: expected a closed breaker to let it through", i)
+    \t\t}
+    \t\tb.Failure()
+    \t}
+    \tif b.State() != Open || b.Allow() {
+    \t\tt.Fatalf("expected the breaker to open after 3 failures, got %v", b.State())
+    \t}
+    \t
+    \tnow = time.Second
+    \tif !b.Allow() || b.Allow() {
+    \t\tt.Fatal("expected a single trial send once the cooldown has elapsed")
+    \t}
+    \tb.Success()
+    \tif b.State() != Closed {
+    \t\tt.Fatalf("expected a successful trial to close the breaker, got %v", b.State())
+    \t}
+    }
+    """
+  end
+
+  defp generate_schedule_unit_test do
+    """
+
+    func TestParseSchedule(t *testing.T) {
+    \tentries, err := ParseSchedule(strings.NewReader("at_ms,message\\n12.5,request\\n\\n0, ping\\n"))
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \twant := []ScheduleEntry{{At: 12500 * time.Microsecond, Kind: "request"}, {At: 0, Kind: "ping"}}
+    \tif len(entries) != len(want) || entries[0] != want[0] || entries[1] != want[1] {
+    \t\tt.Fatalf("expected %v, got %v", want, entries)
+    \t}
+    \tif _, err := ParseSchedule(strings.NewReader("10,request\\nsoon,request\\n")); err == nil {
+    \t\tt.Fatal("expected an entry without a time to be rejected")
+    \t}
+    }
+    """
+  end
+
+  # Replays the whole schedule, however long, rather than the test horizon
+  defp generate_replay_test(name, targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}ReplaysSchedule(t *testing.T) {
+    \tentries, err := ParseSchedule(strings.NewReader(#{field}Schedule))
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tvar last time.Duration
+    \tfor _, e := range entries {
+    \t\tif e.At > last {
+    \t\t\tlast = e.At
+    \t\t}
+    \t}
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \t// Every entry has been sent to each target once the last falls due
+    \th.Advance(last)
+    \th.DrainQuiescent()
+    \th.AssertSendCount(sys.#{field}, len(entries)*#{length(targets)})
+    }
+    """
+  end
+
+  # Runs until the actor has sent threshold requests, they have all timed
+  # out and one more request has met the open circuit
+  defp generate_breaker_test(name, definition, targets, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    %{threshold: threshold} = circuit_breaker(definition)
+    interval = Definition.interval_for_pattern(definition.send_pattern)
+
+    trip =
+      Definition.first_send_delay(definition) + (threshold + 1) * interval + definition.timeout
+
+    slow_targets =
+      Enum.map_join(targets, fn target ->
+        "\t\tsys.serviceTime[sys.#{GeneratorUtils.to_camel_case(target)}] = " <>
+          "2 * #{definition.timeout} * time.Millisecond\n"
+      end)
+
+    """
+
+    func Test#{type_name}TripsBreaker(t *testing.T) {
+    \trun := func() (int, []BreakerState) {
+    \t\tclock := NewVirtualClock()
+    \t\tsys := NewSystem(1, clock)
+    \t\t// Make every target too slow to reply before the timeout
+    #{slow_targets}\t\th := simtest.NewHarness(t, sys, clock)
+    \t\th.Advance(#{max(horizon, trip)} * time.Millisecond)
+    \t\th.DrainQuiescent()
+    \t\treturn sys.#{field}.ShortCircuitCount(), sys.#{field}.BreakerStates()
+    \t}
+    \t
+    \tcount, states := run()
+    \tif count == 0 {
+    \t\tt.Fatal("expected open circuits to send straight to #{definition.fallback}")
+    \t}
+    \tfor i, state := range states {
+    \t\tif state == Closed {
+    \t\t\tt.Fatalf("expected the breaker on edge %d to have tripped", i)
+    \t\t}
+    \t}
+    \tif again, _ := run(); again != count {
+    \t\tt.Fatalf("expected the same short circuits on every run, got %d and %d", count, again)
+    \t}
+    }
+    """
+  end
+
+  # One inbox falls behind once more messages arrive by the horizon than it
+  # can serve in one service time more; then every added inbox serves more
+  defp saturates?(definition, handled, horizon) do
+    inboxes = parallelism(definition)
+
+    inboxes != nil and inboxes > 1 and definition.fair_queue != nil and
+      backlogs?(definition, handled, horizon)
+  end
+
+  defp backlogs?(definition, handled, horizon) do
+    (definition.service_time || 0) > 0 and handled != nil and
+      handled > div(horizon, definition.service_time) + 1
+  end
+
+  # Conflating by kind leaves at most one message of each kind waiting
+  defp generate_conflation_test(name, definition, messages, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    bound =
+      if conflation(definition) == :message do
+        """
+        \tif n := sys.#{field}.queued(); n > #{length(messages) + 1} {
+        \t\tt.Fatalf("expected at most one #{name} message of each kind waiting, got %d", n)
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    func Test#{type_name}ConflatesQueuedMessages(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tconflated := sys.#{field}.ConflatedCount()
+    \tif conflated == 0 {
+    \t\tt.Fatal("expected #{name} to conflate messages it is too slow to process")
+    \t}
+    #{bound}\tprocessed := 0
+    \tfor _, n := range sys.#{field}.ProcessedCounts() {
+    \t\tprocessed += n
+    \t}
+    \tfor _, a := range sys.Report().Actors {
+    \t\tif a.Name == "#{name}" && processed+conflated+sys.#{field}.queued() != a.Received {
+    \t\t\tt.Fatalf("expected the %d messages #{name} received to be processed, conflated or queued, got %d, %d and %d",
+    \t\t\t\ta.Received, processed, conflated, sys.#{field}.queued())
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # A recording mock takes the place of a target through its Iface
+  defp generate_iface_test(name, target, messages, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    target_type = GeneratorUtils.to_pascal_case(target)
+    target_field = GeneratorUtils.to_camel_case(target)
+
+    methods =
+      Enum.map_join(messages, fn msg ->
+        msg_name = GeneratorUtils.message_name(msg)
+
+        """
+
+        func (m *recording#{target_type}) #{GeneratorUtils.to_pascal_case(msg_name)}() {
+        \tm.got = append(m.got, "#{msg_name}")
+        }
+        """
+      end)
+
+    """
+
+    // recording#{target_type} stands in for #{target}, recording what it gets
+    type recording#{target_type} struct {
+    \tphony.Inbox
+    \tmessageContext
+    \tgot []string
+    }
+
+    var _ #{target_type}Iface = (*recording#{target_type})(nil)
+    #{methods}
+    func Test#{type_name}SendsToMock#{target_type}(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tmock := &recording#{target_type}{}
+    \tsys.#{field}.disconnect(sys.#{target_field})
+    \tsys.#{field}.connect(mock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tvar got []string
+    \tphony.Block(mock, func() { got = mock.got })
+    \tif len(got) == 0 {
+    \t\tt.Fatal("expected #{name} to send to the mock #{target}")
+    \t}
+    }
+    """
+  end
+
+  # Every message handled was still within its deadline when it was dequeued
+  defp generate_shed_test(name, definition, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}ShedsStaleMessages(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tlate := 0
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tstarted := ctx.Now - #{definition.service_time}*time.Millisecond
+    \t\tif ctx.Actor == "#{name}" && ctx.Deadline > 0 && started > ctx.Deadline {
+    \t\t\tlate++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tif sys.#{field}.ShedCount() == 0 {
+    \t\tt.Fatal("expected #{name} to shed messages past their deadline")
+    \t}
+    \tif late != 0 {
+    \t\tt.Fatalf("expected #{name} to handle only messages within their deadline, %d were late", late)
+    \t}
+    }
+    """
+  end
+
+  # One message goes into service and the mailbox takes capacity more; the
+  # last two overflow. A blocking mailbox has as many more wait for room
+  # under a burst ten times its capacity, and drops the rest
+  defp generate_mailbox_test(name, definition, kind, capacity, overflow) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    kind = GeneratorUtils.message_name(kind)
+    total = if overflow == :block, do: 10 * capacity, else: capacity + 3
+    held = 2 * capacity + 1
+
+    check =
+      if overflow == :block do
+        """
+        \tif n := sys.#{field}.queued(); n != #{held} {
+        \t\tt.Fatalf("expected #{name} to hold #{held} of the #{total} messages, got %d", n)
+        \t}
+        \tif n := sys.#{field}.DropCount(); n != #{total - held} {
+        \t\tt.Fatalf("expected the other #{total - held} messages to be dropped, got %d", n)
+        \t}
+        \th.Advance(#{held * definition.service_time} * time.Millisecond)
+        \tprocessed := 0
+        \tfor _, n := range sys.#{field}.ProcessedCounts() {
+        \t\tprocessed += n
+        \t}
+        \tif processed != #{held} {
+        \t\tt.Fatalf("expected #{name} to process the #{held} messages it held, got %d", processed)
+        \t}
+        """
+      else
+        """
+        \tif n := sys.#{field}.DropCount(); n != 2 {
+        \t\tt.Fatalf("expected 2 messages to find #{name}'s mailbox full, got %d dropped", n)
+        \t}
+        \tif n := sys.#{field}.queued(); n != #{capacity + 1} {
+        \t\tt.Fatalf("expected #{capacity} messages in #{name}'s mailbox and one in service, got %d", n)
+        \t}
+        """
+      end
+
+    """
+
+    func Test#{type_name}BoundsMailbox(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \tfor i := 0; i < #{total}; i++ {
+    \t\tif err := Act(sys, "#{name}", Envelope[string]{Kind: "#{kind}"}); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t}
+    \th.Advance(0)
+    #{check}}
+    """
+  end
+
+  defp generate_timing_test(name, definition, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}SplitsQueueAndServiceTime(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tqueue, service := sys.#{field}.QueueTimeStats(), sys.#{field}.ServiceTimeStats()
+    \tif service.Count == 0 || service.P50 != #{definition.service_time}*time.Millisecond {
+    \t\tt.Fatalf("expected #{name} to take its service time over each message, got %+v", service)
+    \t}
+    \tif queue.Count != service.Count {
+    \t\tt.Fatalf("expected a queue time for each of the %d messages #{name} handled, got %d", service.Count, queue.Count)
+    \t}
+    \tif queue.P99 == 0 {
+    \t\tt.Fatal("expected messages to wait behind the backlog at #{name}")
+    \t}
+    }
+    """
+  end
+
+  defp generate_shard_test(name, definition, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    inboxes = parallelism(definition)
+
+    """
+
+    func Test#{type_name}DrainsAcrossInboxes(t *testing.T) {
+    \tprocessed := func(inboxes int) int {
+    \t\tclock := NewVirtualClock()
+    \t\tsys := NewSystem(1, clock)
+    \t\tsys.#{field}.shards = sys.#{field}.shards[:inboxes]
+    \t\th := simtest.NewHarness(t, sys, clock)
+    \t\th.Advance((#{horizon} + #{definition.service_time}) * time.Millisecond)
+    \t\tn := 0
+    \t\tfor _, count := range sys.#{field}.ProcessedCounts() {
+    \t\t\tn += count
+    \t\t}
+    \t\treturn n
+    \t}
+    \t
+    \tif single, parallel := processed(1), processed(#{inboxes}); parallel <= single {
+    \t\tt.Fatalf("expected #{inboxes} inboxes to process more than one, got %d and %d", parallel, single)
+    \t}
+    }
+    """
+  end
+
+  # Waits out 100 retry intervals past the horizon, so even a bursty link
+  # gets every message sent by then through
+  defp generate_delivery_test(name, target, retry, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}DeliversAtLeastOnce(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tsent := map[MessageID]bool{}
+    \treceived := map[MessageID]int{}
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tswitch {
+    \t\tcase ctx.Actor == "#{name}" && ctx.Now <= #{horizon}*time.Millisecond:
+    \t\t\tsent[ctx.ID] = true
+    \t\tcase ctx.Actor == "#{target}":
+    \t\t\treceived[ctx.ID]++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance((#{horizon} + 100*#{retry}) * time.Millisecond)
+    \th.DrainQuiescent()
+    \tfor id := range sent {
+    \t\tif received[id] == 0 {
+    \t\t\tt.Fatalf("expected #{target} to receive message %s", id)
+    \t\t}
+    \t}
+    \tduplicates := 0
+    \tfor _, n := range received {
+    \t\tduplicates += n - 1
+    \t}
+    \tif n := sys.#{field}.DuplicateCount(); n != duplicates {
+    \t\tt.Fatalf("expected %d duplicates, got %d", duplicates, n)
+    \t}
+    }
+    """
+  end
+
+  # With the sender's at-least-once edge the target's only way in, every
+  # duplicate the sender counts is a copy the target dedupes
+  defp generate_idempotency_test(name, target, retry, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(target)
+    field = GeneratorUtils.to_camel_case(target)
+    sender = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}AppliesRetriesOnce(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \treceived := map[MessageID]int{}
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{target}" {
+    \t\t\treceived[ctx.ID]++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance((#{horizon} + 100*#{retry}) * time.Millisecond)
+    \th.DrainQuiescent()
+    \tcopies := 0
+    \tfor _, n := range received {
+    \t\tcopies += n
+    \t}
+    \tif n := sys.#{field}.AppliedCount(); n != len(received) {
+    \t\tt.Fatalf("expected #{target} to apply each of the %d messages it received once, applied %d", len(received), n)
+    \t}
+    \tdeduped, duplicates := sys.#{field}.DedupedCount(), sys.#{sender}.DuplicateCount()
+    \tif deduped != copies-len(received) || deduped != duplicates {
+    \t\tt.Fatalf("expected #{target} to dedupe the %d duplicates #{name} sent, deduped %d of %d copies", duplicates, deduped, copies)
+    \t}
+    }
+    """
+  end
+
+  defp generate_clock_speed_test do
+    """
+
+    func TestRealClockSpeed(t *testing.T) {
+    \tclock := NewRealClockWithSpeed(100)
+    \tfired := make(chan struct{})
+    \tclock.AfterFunc(time.Second, func() { close(fired) })
+    \tselect {
+    \tcase <-fired:
+    \tcase <-time.After(time.Second):
+    \t\tt.Fatal("expected a 1s timer to fire within 1s of real time at 100x speed")
+    \t}
+    \tif now := clock.Now(); now < time.Second {
+    \t\tt.Fatalf("expected the clock to read at least 1s once the timer fired, got %v", now)
+    \t}
+    }
+    """
+  end
+
+  # On a real clock the actors, their timers and the readers here all run
+  # on goroutines of their own, so go test -race sees any state they share
+  # outside Act
+  # BenchmarkActorSystem runs the spec's repeating send patterns, and a
+  # benchmark per actor acts the first kind it handles straight in; neither
+  # takes requests the actor answers or replies it takes back. Names that
+  # would clash with those benchmarks get none of their own
+  defp generate_benchmarks(simulated, topology, enable_callbacks) do
+    log_setup = if enable_callbacks, do: "\tsys.SetLogEvery(1 << 30)\n", else: ""
+    ticks = for {_name, definition} <- simulated, tick = send_tick(definition), do: tick
+    system = if ticks == [], do: "", else: generate_system_benchmark(Enum.min(ticks), log_setup)
+
+    actors =
+      Enum.map_join(simulated, fn {name, definition} ->
+        kind =
+          topology.messages
+          |> Map.fetch!(name)
+          |> Kernel.--(Keyword.keys(replies(definition)))
+          |> Kernel.--(reply_roles(topology, name).answers)
+          |> Enum.map(&GeneratorUtils.message_name/1)
+          |> List.first()
+
+        type_name = GeneratorUtils.to_pascal_case(name)
+
+        if kind && type_name not in ["ActorSystem", "Dispatch"] do
+          payload =
+            if Map.has_key?(topology.types, kind),
+              do: GeneratorUtils.to_pascal_case(kind),
+              else: "string"
+
+          generate_actor_benchmark(name, kind, payload, log_setup)
+        end
+      end)
+
+    if system <> actors == "", do: "", else: generate_quiet_helper() <> system <> actors
+  end
+
+  defp generate_quiet_helper do
+    """
+
+    // quiet sends what the actors print to os.DevNull until b is done, so
+    // their lines don't break up the benchmark results
+    func quiet(b *testing.B) {
+    \tnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+    \tif err != nil {
+    \t\tb.Fatal(err)
+    \t}
+    \tstdout := os.Stdout
+    \tos.Stdout = null
+    \tb.Cleanup(func() {
+    \t\tos.Stdout = stdout
+    \t\tnull.Close()
+    \t})
+    }
+    """
+  end
+
+  # The virtual time between a repeating send pattern's sends
+  defp send_tick(%{send_pattern: {:periodic, interval_ms, _message}}), do: interval_ms
+  defp send_tick(%{send_pattern: {:rate, per_second, _message}}), do: div(1000, per_second)
+  defp send_tick(%{send_pattern: {:burst, _count, interval_ms, _message}}), do: interval_ms
+  defp send_tick(_definition), do: nil
+
+  defp generate_system_benchmark(tick, log_setup) do
+    """
+
+    // BenchmarkActorSystem runs b.N ticks of #{tick}ms, the fastest send
+    // pattern's, through the generated actors on a VirtualClock, with their
+    // loss, delays and accounting, and reports the messages all actors handle
+    // per second
+    // Run it with go test -run ^$ -bench ActorSystem -benchmem
+    func BenchmarkActorSystem(b *testing.B) {
+    \tquiet(b)
+    \tsys := NewSystem(1, NewVirtualClock())
+    #{log_setup}\tsys.Start()
+    \tb.ReportAllocs()
+    \tb.ResetTimer()
+    \tstart := time.Now()
+    \tif _, err := sys.RunUntil(time.Duration(b.N) * #{tick} * time.Millisecond); err != nil {
+    \t\tb.Fatal(err)
+    \t}
+    \tb.ReportMetric(float64(sys.SystemMetrics().Received)/time.Since(start).Seconds(), "msgs/s")
+    }
+    """
+  end
+
+  defp generate_actor_benchmark(name, kind, payload, log_setup) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+
+    // Benchmark#{type_name} acts b.N #{kind} messages into #{name} at one
+    // virtual instant and reports the messages per second its handler gets
+    // through, along with whatever it sets off downstream by then
+    func Benchmark#{type_name}(b *testing.B) {
+    \tquiet(b)
+    \tsys := NewSystem(1, NewVirtualClock())
+    #{log_setup}\tsys.Start()
+    \tb.ReportAllocs()
+    \tb.ResetTimer()
+    \tstart := time.Now()
+    \tfor i := 0; i < b.N; i++ {
+    \t\tif err := Act(sys, "#{name}", Envelope[#{payload}]{Kind: "#{kind}"}); err != nil {
+    \t\t\tb.Fatal(err)
+    \t\t}
+    \t}
+    \tsys.Advance(0)
+    \tb.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+    }
+    """
+  end
+
+  defp generate_race_test(actor_count) do
+    """
+
+    func TestRealClockRunIsRaceFree(t *testing.T) {
+    \tsys := NewSystem(1, NewRealClockWithSpeed(100))
+    \tsys.Start()
+    \tdefer sys.Stop()
+    \t
+    \treports := make(chan Report)
+    \tfor i := 0; i < 4; i++ {
+    \t\tgo func() {
+    \t\t\tvar report Report
+    \t\t\tfor deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+    \t\t\t\treport = sys.Report()
+    \t\t\t\tsys.Pending()
+    \t\t\t}
+    \t\t\treports <- report
+    \t\t}()
+    \t}
+    \tfor i := 0; i < 4; i++ {
+    \t\tif report := <-reports; len(report.Actors) != #{actor_count} {
+    \t\t\tt.Fatalf("expected a row for each of the #{actor_count} actors, got %d", len(report.Actors))
+    \t\t}
+    \t}
+    }
+
+    // Stop leaves nothing running once it returns: it cancels the timers still
+    // pending and waits for the rest, and an idle Phony inbox holds no goroutine
+    func TestStopReleasesGoroutines(t *testing.T) {
+    \tbaseline := runtime.NumGoroutine()
+    \tsys := NewSystem(1, NewRealClockWithSpeed(100))
+    \tsys.Start()
+    \ttime.Sleep(50 * time.Millisecond)
+    \tsys.Stop()
+    \tif n := runtime.NumGoroutine(); n > baseline {
+    \t\tt.Fatalf("expected %d goroutines once stopped, as before Start, got %d", baseline, n)
+    \t}
+    }
+    """
+  end
+
+  # The probe passes on everything it sees; once taken off its edge, the
+  # sender's messages reach the probe's targets without it
+  defp generate_probe_test(sender, probe, targets, sized?, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(probe)
+    field = GeneratorUtils.to_camel_case(probe)
+    n = length(targets)
+    [target | _] = targets
+    connect = Enum.map_join(targets, ", ", &"{From: \"#{sender}\", To: \"#{&1}\"}")
+
+    bytes =
+      if sized? do
+        """
+        \tvar sent int64
+        \tfor _, n := range sys.#{field}.BytesSent() {
+        \t\tsent += n
+        \t}
+        \tif sent != seen.Bytes*#{n} {
+        \t\tt.Fatalf("expected #{probe} to pass the %d bytes it saw on to each of its #{n} targets, sent %d", seen.Bytes, sent)
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    func Test#{type_name}ProbesEdge(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tseen := sys.#{field}.ProbeStats()
+    \tif seen.Count == 0 || seen.Count*#{n} != sys.#{field}.SendCount() {
+    \t\tt.Fatalf("expected #{probe} to pass each of the %d messages it saw on to its #{n} targets, sent %d", seen.Count, sys.#{field}.SendCount())
+    \t}
+    \tif seen.Latency.Count < seen.Count {
+    \t\tt.Fatalf("expected #{probe} to time the %d messages it saw, timed %d", seen.Count, seen.Latency.Count)
+    \t}
+    #{bytes}\treceived := func() int {
+    \t\tfor _, a := range sys.Report().Actors {
+    \t\t\tif a.Name == "#{target}" {
+    \t\t\t\treturn a.Received
+    \t\t\t}
+    \t\t}
+    \t\treturn 0
+    \t}
+    \t
+    \t// Taking the probe off its edge leaves the messages flowing without it,
+    \t// once it has passed on those it held
+    \tif err := sys.Reconfigure(&Spec{
+    \t\tDisconnect: []Edge{{From: "#{sender}", To: "#{probe}"}},
+    \t\tConnect: []Edge{#{connect}},
+    \t}); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tdrained, before := sys.#{field}.ProbeStats(), received()
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif after := sys.#{field}.ProbeStats(); after.Count != drained.Count {
+    \t\tt.Fatalf("expected #{probe} to see nothing once taken off its edge, saw %d more", after.Count-drained.Count)
+    \t}
+    \tif received() == before {
+    \t\tt.Fatal("expected #{target} to keep receiving once #{probe} was taken off its edge")
+    \t}
+    }
+    """
+  end
+
+  # Filters let through only even keys and maps make twice the key the
+  # payload, which the target's middleware can tell apart
+  defp generate_transform_test(name, definition, target, steps, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    filters? = Enum.any?(steps, &match?({:filter, _step}, &1))
+    maps? = Enum.any?(steps, &match?({:map, _step}, &1))
+
+    overrides =
+      Enum.map_join(transform_steps(definition), "\n", fn
+        {:filter, _step} = step ->
+          """
+          func (c transforming#{type_name}Callbacks) #{transform_method(step)}(m EdgeMessage) bool {
+          \treturn m.Key%2 == 0
+          }
+          """
+
+        {:map, _step} = step ->
+          """
+          func (c transforming#{type_name}Callbacks) #{transform_method(step)}(m EdgeMessage) any {
+          \treturn m.Key * 2
+          }
+          """
+      end)
+
+    filter_check =
+      if filters? do
+        """
+        \t\t\tif ctx.Key%2 != 0 && !t.Failed() {
+        \t\t\t\tt.Errorf("expected the filters to stop message %d on its way to #{target}", ctx.Key)
+        \t\t\t}
+        """
+      else
+        ""
+      end
+
+    map_check =
+      if maps? do
+        """
+        \t\t\tif ctx.Payload != ctx.Key*2 && !t.Failed() {
+        \t\t\t\tt.Errorf("expected the maps to make %d the payload of message %d, got %v", ctx.Key*2, ctx.Key, ctx.Payload)
+        \t\t\t}
+        """
+      else
+        ""
+      end
+
+    filtered_check =
+      if filters? do
+        """
+        \tif sys.#{field}.FilteredCount() == 0 {
+        \t\tt.Fatal("expected the filters on the edge to #{target} to drop messages")
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    // transforming#{type_name}Callbacks lets only messages with an even key
+    // through the filters on the edges of #{name}, and has its maps make
+    // twice the key the payload
+    type transforming#{type_name}Callbacks struct {
+    \t*Default#{type_name}Callbacks
+    }
+
+    #{overrides}
+    func Test#{type_name}TransformsEdge(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \treceived := 0
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{target}" {
+    \t\t\treceived++
+    #{filter_check}#{map_check}\t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \tphony.Block(sys.#{field}, func() {
+    \t\tdefaults := sys.#{field}.callbacks.(*Default#{type_name}Callbacks)
+    \t\tsys.#{field}.callbacks = transforming#{type_name}Callbacks{defaults}
+    \t})
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif received == 0 {
+    \t\tt.Fatal("expected #{target} to receive messages through the transforms")
+    \t}
+    #{filtered_check}}
+    """
+  end
+
+  # Middleware sees a message once the reorder buffer releases it, which
+  # is right after the one before it from the same source
+  defp generate_reorder_test(name, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}ReleasesInOrder(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \treleased := map[string]uint64{}
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{name}" {
+    \t\t\tif want := released[ctx.ID.Source] + 1; ctx.Key != want && !t.Failed() {
+    \t\t\t\tt.Errorf("expected #{name} to release message %d from %s next, got %d", want, ctx.ID.Source, ctx.Key)
+    \t\t\t}
+    \t\t\treleased[ctx.ID.Source] = ctx.Key
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif len(released) == 0 {
+    \t\tt.Fatal("expected #{name} to release messages")
+    \t}
+    \tstats := sys.#{field}.ReorderStats()
+    \tif stats.Peak < stats.Held {
+    \t\tt.Fatalf("expected #{name} to have held at most %d messages at once, holds %d", stats.Peak, stats.Held)
+    \t}
+    \tfor _, reorder := range sys.Report().Reorders {
+    \t\tif reorder.Actor == "#{name}" && reorder.ReorderStats != stats {
+    \t\t\tt.Fatalf("expected the report to show %+v for #{name}, got %+v", stats, reorder.ReorderStats)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # A session sends every sessions-th message; it sticks to its target from
+  # its second message on if it sends again within the window
+  defp generate_affinity_test(name, definition, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    {within, sessions} = affinity(definition)
+    gap = sessions * Definition.interval_for_pattern(definition.send_pattern)
+
+    {comment, want} =
+      if gap <= within,
+        do: {"within its #{within}ms window", "routed - min(routed, #{sessions})"},
+        else: {"after its #{within}ms window closes", "0"}
+
+    """
+
+    func Test#{type_name}KeepsSessionsOnOneTarget(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tvar routed, sticky int
+    \tfor _, c := range sys.#{field}.AffinityCounts() {
+    \t\trouted += c.Routed
+    \t\tsticky += c.Sticky
+    \t}
+    \tif sent := sys.#{field}.SendCount(); routed == 0 || routed != sent {
+    \t\tt.Fatalf("expected #{name} to route each of the %d messages it sent, routed %d", sent, routed)
+    \t}
+    \t// A session sends again every #{gap}ms, #{comment}
+    \tif want := #{want}; sticky != want {
+    \t\tt.Errorf("expected %d of the %d messages to stick to their session's target, got %d", want, routed, sticky)
+    \t}
+    }
+    """
+  end
+
+  # Sends while the actor is down, from its crash until it restarts half a
+  # horizon later, are lost; it handles them again once restarted
+  defp generate_crash_test(name, horizon, enable_callbacks) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    half = div(horizon, 2)
+    restart = horizon + half
+
+    persist =
+      if enable_callbacks do
+        """
+        \t// Default callbacks restore nothing, but what they persisted survives
+        \tvar before #{type_name}Callbacks
+        \tphony.Block(sys.#{field}, func() {
+        \t\tsys.#{field}.ctx.Persist([]byte("checkpoint"))
+        \t\tbefore = sys.#{field}.callbacks
+        \t})
+        """
+      else
+        ""
+      end
+
+    restored =
+      if enable_callbacks do
+        """
+        \tphony.Block(sys.#{field}, func() {
+        \t\tif sys.#{field}.callbacks == before {
+        \t\t\tt.Error("expected #{name} to restart with fresh callbacks")
+        \t\t}
+        \t\tif string(sys.#{field}.ctx.persisted) != "checkpoint" {
+        \t\t\tt.Error("expected the state #{name} persisted to survive the crash")
+        \t\t}
+        \t})
+        """
+      else
+        ""
+      end
+
+    """
+
+    func Test#{type_name}RecoversFromCrash(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \thandled := 0
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{name}" {
+    \t\t\thandled++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    #{persist}\tif err := sys.Crash("#{name}", #{half} * time.Millisecond); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tif err := sys.Crash("#{name}", 0); err == nil {
+    \t\tt.Fatal("expected an error crashing #{name} while it is down")
+    \t}
+    \tdown := handled
+    \th.Advance(#{half} * time.Millisecond)
+    \tlost := sys.LostMessages()
+    \tif len(lost) == 0 {
+    \t\tt.Fatal("expected #{name} to lose what it was sent while down")
+    \t}
+    \tfor _, m := range lost {
+    \t\tif m.To != "#{name}" || m.At < #{horizon}*time.Millisecond || m.At > #{restart}*time.Millisecond {
+    \t\t\tt.Fatalf("expected only messages to #{name} lost while it was down, got %+v", m)
+    \t\t}
+    \t}
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif handled == down {
+    \t\tt.Fatal("expected #{name} to handle messages again once restarted")
+    \t}
+    \tif n := len(sys.LostMessages()); n != len(lost) {
+    \t\tt.Fatalf("expected no losses once restarted, got %d more", n-len(lost))
+    \t}
+    #{restored}\tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
+    """
+  end
+
+  # Each crash comes a microsecond after the restart before it, well within
+  # the budget's window of at least a millisecond
+  defp generate_restart_test(name, max, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+
+    func Test#{type_name}ExhaustsRestartBudget(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \tfor i := 0; i <= #{max}; i++ {
+    \t\tif err := sys.Crash("#{name}", time.Microsecond); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\th.Advance(time.Microsecond)
+    \t}
+    \tevents := sys.SupervisorEvents()
+    \tif len(events) != #{max + 1} || !events[#{max}].GaveUp {
+    \t\tt.Fatalf("expected #{max} restarts of #{name} before the supervisor gives up, got %v", events)
+    \t}
+    \tfor _, e := range events[:#{max}] {
+    \t\tif e.Actor != "#{name}" || e.GaveUp {
+    \t\t\tt.Fatalf("expected a restart of #{name}, got %v", e)
+    \t\t}
+    \t}
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif err := sys.Crash("#{name}", 0); err == nil {
+    \t\tt.Fatal("expected #{name} to stay down once the supervisor gave up on it")
+    \t}
+    \tif n := len(sys.SupervisorEvents()); n != #{max + 1} {
+    \t\tt.Fatalf("expected no restart once the supervisor gave up, got %d more events", n-#{max + 1})
+    \t}
+    }
+    """
+  end
+
+  # Cuts the actor off from the rest for one horizon, then heals
+  defp generate_partition_test(name, horizon) do
+    """
+
+    func TestPartitionHeals(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \tplan := []PartitionStep{{At: 0, Heal: #{horizon} * time.Millisecond, Groups: [][]string{{"#{name}"}}}}
+    \tif err := sys.SchedulePartitions(plan); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tcut := sys.PartitionedCount()
+    \tif cut == 0 {
+    \t\tt.Fatal("expected the partition to drop what #{name} sends")
+    \t}
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif n := sys.PartitionedCount(); n != cut {
+    \t\tt.Fatalf("expected no drops once healed, got %d more", n-cut)
+    \t}
+    \tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
+    """
+  end
+
+  # A partition that heals before the first resend loses nothing; one that
+  # never heals fails every resend
+  defp generate_dead_letter_test(name, definition) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    %{interval: interval, max: max} = dlq_retry(definition)
+    half = div(interval, 2)
+    reaped = half + interval
+    given_up = (max + 1) * interval
+
+    """
+
+    func Test#{type_name}ReprocessesDeadLetters(t *testing.T) {
+    \tpartitioned := func(heal time.Duration) (*System, *simtest.Harness) {
+    \t\tclock := NewVirtualClock()
+    \t\tsys := NewSystem(1, clock)
+    \t\th := simtest.NewHarness(t, sys, clock)
+    \t\tplan := []PartitionStep{{At: 0, Heal: heal, Groups: [][]string{{"#{name}"}}}}
+    \t\tif err := sys.SchedulePartitions(plan); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\treturn sys, h
+    \t}
+    \t
+    \tsys, h := partitioned(#{half} * time.Millisecond)
+    \th.Advance(#{half} * time.Millisecond)
+    \tif sys.#{field}.DeadLetterCount() == 0 {
+    \t\tt.Fatal("expected #{name} to hold what the partition dropped")
+    \t}
+    \th.Advance(#{reaped - half} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif n := sys.#{field}.DeadLetterCount() + sys.#{field}.FailedCount(); n != 0 {
+    \t\tt.Fatalf("expected #{name} to resend every dead letter once healed, %d left", n)
+    \t}
+    \tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t
+    \tsys, h = partitioned(#{given_up + interval} * time.Millisecond)
+    \th.Advance(#{given_up} * time.Millisecond)
+    \tfailed := sys.#{field}.FailedLetters()
+    \tif len(failed) == 0 || failed[0].Attempts != #{max} {
+    \t\tt.Fatalf("expected #{name} to give up on dead letters after #{max} resends, got %v", failed)
+    \t}
+    \tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
+    """
+  end
+
+  defp generate_progress_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Progress of a long run, reported over time
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"fmt"
+    \t"sync/atomic"
+    \t"time"
+    )
+
+    // ProgressInfo is a snapshot of a run in progress: the clock's time, the
+    // messages the actors have handled so far and how many a second of clock
+    // time they handled since the snapshot before
+    type ProgressInfo struct {
+    \tAt time.Duration
+    \tProcessed int64
+    \tRate float64
+    }
+
+    // String describes the snapshot on one line, e.g. for a log
+    func (p ProgressInfo) String() string {
+    \treturn fmt.Sprintf("%v: %d messages processed, %.1f/s", p.At, p.Processed, p.Rate)
+    }
+
+    // OnProgress calls f with a snapshot of the run once per interval of the
+    // system's clock from now on, so a long run can show a progress bar or log
+    // milestones instead of appearing hung; unlike Report it only reads a
+    // counter, which keeps it cheap enough for short intervals
+    // Call it before Start, as it counts handled messages with middleware
+    func (s *System) OnProgress(interval time.Duration, f func(ProgressInfo)) {
+    \tvar processed atomic.Int64
+    \ts.middleware = append(s.middleware, MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tprocessed.Add(1)
+    \t\tnext()
+    \t}))
+    \tlast, lastAt := int64(0), s.clock.Now()
+    \tvar report func()
+    \treport = func() {
+    \t\ts.schedule(nil, interval, report)
+    \t\tn, now := processed.Load(), s.clock.Now()
+    \t\tf(ProgressInfo{At: now, Processed: n, Rate: float64(n-last) / (now - lastAt).Seconds()})
+    \t\tlast, lastAt = n, now
+    \t}
+    \ts.schedule(nil, interval, report)
+    }
+    """
+  end
+
+  defp generate_depth_file do
+    """
+    // Generated from ActorSimulation DSL
+    // Queue depth sampled over time
+    // DO NOT EDIT - This file is auto-generated
+
+    package main
+
+    import (
+    \t"encoding/csv"
+    \t"fmt"
+    \t"io"
+    \t"sort"
+    \t"strconv"
+    \t"strings"
+    \t"sync"
+    \t"time"
+    )
+
+    // plotWidth is the most columns PlotQueueDepth draws a row in; a longer
+    // run folds several samples into each column, keeping their deepest
+    const plotWidth = 72
+
+    // sparks draws a depth from none up to the deepest sampled
+    var sparks = []rune("▁▂▃▄▅▆▇█")
+
+    // depthLog holds the queue depth of every actor sampled at each interval
+    type depthLog struct {
+    \tmu sync.Mutex
+    \tinterval time.Duration
+    \tnames []string
+    \tat []time.Duration
+    \tdepths [][]int64
+    }
+
+    // SampleQueueDepth records the queue depth of every actor from now on,
+    // once per interval of the system's clock, for WriteQueueDepthCSV and
+    // PlotQueueDepth
+    // An actor's depth is the messages on their way to it or in its inbox,
+    // plus any its fair queue or join window holds back; actors spawned
+    // later by Reconfigure are left out
+    func (s *System) SampleQueueDepth(interval time.Duration) {
+    \ts.mu.Lock()
+    \tnames := make([]string, 0, len(s.actors))
+    \tfor name := range s.actors {
+    \t\tnames = append(names, name)
+    \t}
+    \ts.mu.Unlock()
+    \tsort.Strings(names)
+    \t
+    \ts.depths.mu.Lock()
+    \ts.depths.interval, s.depths.names = interval, names
+    \ts.depths.mu.Unlock()
+    \tvar sample func()
+    \tsample = func() {
+    \t\ts.schedule(nil, interval, sample)
+    \t\ts.sampleDepth()
+    \t}
+    \ts.schedule(nil, interval, sample)
+    }
+
+    // sampleDepth records every sampled actor's queue depth, summed over
+    // its inboxes
+    func (s *System) sampleDepth() {
+    \ts.mu.Lock()
+    \tactors := make([]actor, len(s.depths.names))
+    \tfor i, name := range s.depths.names {
+    \t\tactors[i] = s.actors[name]
+    \t}
+    \ts.mu.Unlock()
+    \t
+    \tdepths := make([]int64, len(actors))
+    \tfor i, a := range actors {
+    \t\tif a == nil {
+    \t\t\tcontinue
+    \t\t}
+    \t\tfor _, c := range contexts(a) {
+    \t\t\tdepths[i] += c.inbound.Load()
+    \t\t}
+    \t\tif q, ok := a.(queuer); ok {
+    \t\t\tdepths[i] += int64(q.queued())
+    \t\t}
+    \t}
+    \ts.depths.mu.Lock()
+    \ts.depths.at = append(s.depths.at, s.clock.Now())
+    \ts.depths.depths = append(s.depths.depths, depths)
+    \ts.depths.mu.Unlock()
+    }
+
+    // WriteQueueDepthCSV writes the sampled queue depths as CSV, one row per
+    // sample with its time in milliseconds and a column per actor
+    func (s *System) WriteQueueDepthCSV(w io.Writer) error {
+    \ts.depths.mu.Lock()
+    \tdefer s.depths.mu.Unlock()
+    \tout := csv.NewWriter(w)
+    \tout.Write(append([]string{"ms"}, s.depths.names...))
+    \tfor i, at := range s.depths.at {
+    \t\trow := []string{strconv.FormatInt(at.Milliseconds(), 10)}
+    \t\tfor _, depth := range s.depths.depths[i] {
+    \t\t\trow = append(row, strconv.FormatInt(depth, 10))
+    \t\t}
+    \t\tout.Write(row)
+    \t}
+    \tout.Flush()
+    \treturn out.Error()
+    }
+
+    // PlotQueueDepth draws the sampled queue depth of every actor as a
+    // sparkline on a scale shared by all of them, so the actor where
+    // messages pile up stands out, followed by its deepest sample
+    func (s *System) PlotQueueDepth(w io.Writer) error {
+    \ts.depths.mu.Lock()
+    \tdefer s.depths.mu.Unlock()
+    \tif len(s.depths.at) == 0 {
+    \t\t_, err := fmt.Fprintln(w, "No queue depth sampled; call SampleQueueDepth first")
+    \t\treturn err
+    \t}
+    \t
+    \tper := (len(s.depths.at) + plotWidth - 1) / plotWidth
+    \tcolumns := (len(s.depths.at) + per - 1) / per
+    \tdeepest := int64(0)
+    \twidth := 0
+    \tfor i, name := range s.depths.names {
+    \t\tfor _, depths := range s.depths.depths {
+    \t\t\tif depths[i] > deepest {
+    \t\t\t\tdeepest = depths[i]
+    \t\t\t}
+    \t\t}
+    \t\tif len(name) > width {
+    \t\t\twidth = len(name)
+    \t\t}
+    \t}
+    \t
+    \tvar b strings.Builder
+    \tfmt.Fprintf(&b, "Queue depth from %v to %v, %v a column\\n", s.depths.at[0], s.depths.at[len(s.depths.at)-1], time.Duration(per)*s.depths.interval)
+    \tfor i, name := range s.depths.names {
+    \t\tpeak := int64(0)
+    \t\tline := make([]rune, columns)
+    \t\tfor col := range line {
+    \t\t\ttop := int64(0)
+    \t\t\tfor _, depths := range s.depths.depths[col*per : min(len(s.depths.at), (col+1)*per)] {
+    \t\t\t\tif depths[i] > top {
+    \t\t\t\t\ttop = depths[i]
+    \t\t\t\t}
+    \t\t\t}
+    \t\t\tif top > peak {
+    \t\t\t\tpeak = top
+    \t\t\t}
+    \t\t\tline[col] = sparks[0]
+    \t\t\tif top > 0 {
+    \t\t\t\tline[col] = sparks[1+(top*int64(len(sparks)-1)-1)/deepest]
+    \t\t\t}
+    \t\t}
+    \t\tfmt.Fprintf(&b, "%-*s %s %d\\n", width, name, string(line), peak)
+    \t}
+    \t_, err := io.WriteString(w, b.String())
+    \treturn err
+    }
+    """
+  end
+
+  # Samples 50 times over the horizon
+  defp generate_depth_test(horizon) do
+    interval = max(div(horizon, 50), 1)
+    samples = div(horizon, interval)
+
+    """
+
+    func TestQueueDepthPlotted(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tsys.SampleQueueDepth(#{interval} * time.Millisecond)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tvar samples, plot strings.Builder
+    \tif err := sys.WriteQueueDepthCSV(&samples); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tif rows := strings.Count(samples.String(), "\\n"); rows != 1+#{samples} {
+    \t\tt.Fatalf("expected a header and #{samples} samples, got %d rows", rows)
+    \t}
+    \tif err := sys.PlotQueueDepth(&plot); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tfor _, name := range sys.Select(nil) {
+    \t\tif !strings.Contains(plot.String(), "\\n"+name+" ") {
+    \t\t\tt.Fatalf("expected a row for %s in\\n%s", name, plot.String())
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # Each snapshot's rate covers the interval since the one before
+  defp generate_progress_test(horizon) do
+    interval = max(div(horizon, 10), 1)
+    snapshots = div(horizon, interval)
+
+    """
+
+    func TestProgressReportedEveryInterval(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tvar progress []ProgressInfo
+    \tsys.OnProgress(#{interval} * time.Millisecond, func(p ProgressInfo) {
+    \t\tprogress = append(progress, p)
+    \t})
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tif len(progress) != #{snapshots} {
+    \t\tt.Fatalf("expected #{snapshots} snapshots, one every #{interval}ms, got %d", len(progress))
+    \t}
+    \tvar last int64
+    \tfor i, p := range progress {
+    \t\tif want := time.Duration(i+1) * #{interval} * time.Millisecond; p.At != want {
+    \t\t\tt.Fatalf("expected snapshot %d at %v, got %v", i, want, p.At)
+    \t\t}
+    \t\tif p.Processed < last {
+    \t\t\tt.Fatalf("expected the messages processed to grow, went from %d to %d at %v", last, p.Processed, p.At)
+    \t\t}
+    \t\tif rate := float64(p.Processed-last) / (#{interval} * time.Millisecond).Seconds(); p.Rate != rate {
+    \t\t\tt.Fatalf("expected a rate of %v at %v, got %v", rate, p.At, p.Rate)
+    \t\t}
+    \t\tlast = p.Processed
+    \t}
+    }
+    """
+  end
+
+  defp generate_report_test(actor_count, received, derived, horizon) do
+    want = Enum.map_join(received, ", ", fn {name, n} -> "\"#{name}\": #{n}" end)
+
+    derived_check =
+      if derived == [] do
+        ""
+      else
+        metrics =
+          Enum.map_join(derived, ", ", fn {name, metrics} ->
+            "\"#{name}\": {" <> Enum.map_join(metrics, ", ", &"\"#{&1}\"") <> "}"
+          end)
+
+        """
+        \tfor name, metrics := range map[string][]string{#{metrics}} {
+        \t\tfor _, metric := range metrics {
+        \t\t\tif _, ok := report.Metrics(name)[metric]; !ok {
+        \t\t\t\tt.Errorf("expected %s to derive %s, got %v", name, metric, report.Metrics(name))
+        \t\t\t}
+        \t\t}
+        \t}
+        """
+      end
+
+    """
+
+    func TestReportSumsUpRun(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \t
+    \treport := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \tt.Log("\\n" + report.String())
+    \tif len(report.Actors) != #{actor_count} {
+    \t\tt.Fatalf("expected a row for each of the #{actor_count} actors, got %d", len(report.Actors))
+    \t}
+    \twant := map[string]int{#{want}}
+    \tfor _, a := range report.Actors {
+    \t\tif n, ok := want[a.Name]; ok && a.Received != n {
+    \t\t\tt.Errorf("expected %s to receive %d messages, got %d", a.Name, n, a.Received)
+    \t\t}
+    \t\tif a.P50 > a.P99 {
+    \t\t\tt.Errorf("expected %s's p50 latency %v within its p99 %v", a.Name, a.P50, a.P99)
+    \t\t}
+    \t\tif m := sys.actors[a.Name].Metrics(); m.SendCount != a.Sent || m.RecvCount != a.Received {
+    \t\t\tt.Errorf("expected %s's metrics to match its %d sent and %d received, got %+v", a.Name, a.Sent, a.Received, m)
+    \t\t}
+    \t}
+    #{derived_check}}
+    """
+  end
+
+  defp generate_system_metrics_test(total, horizon) do
+    total_check =
+      if total == nil do
+        ""
+      else
+        """
+        \tif m.Received != #{total} {
+        \t\tt.Errorf("expected #{total} messages received in all, got %d", m.Received)
+        \t}
+        """
+      end
+
+    """
+
+    func TestSystemMetricsAddUpActors(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \t
+    \treport := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \tm := sys.SystemMetrics()
+    \tt.Logf("%+v", m)
+    \tif m.At != #{horizon}*time.Millisecond {
+    \t\tt.Errorf("expected the metrics at #{horizon}ms, got %v", m.At)
+    \t}
+    \tvar want SystemMetrics
+    \twant.At = m.At
+    \tfor _, a := range report.Actors {
+    \t\twant.Sent += a.Sent
+    \t\twant.Received += a.Received
+    \t\twant.Dropped += a.Dropped
+    \t\twant.Expired += a.Expired
+    \t\tif a.PeakQueue > want.PeakQueue {
+    \t\t\twant.PeakQueue = a.PeakQueue
+    \t\t}
+    \t}
+    \tif m != want {
+    \t\tt.Errorf("expected the totals of the report's rows %+v, got %+v", want, m)
+    \t}
+    #{total_check}}
+    """
+  end
+
+  defp generate_debugger_test(name, count, horizon) do
+    """
+
+    func TestDebuggerStepsAndBreaks(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \td := NewDebugger(sys)
+    \tsys.Start()
+    \t
+    \tif _, ok := d.Step(); !ok {
+    \t\tt.Fatal("expected a timer to step over")
+    \t}
+    \td.Break("#{name}", "", func(a ActorReport) bool { return a.Received >= #{count} })
+    \thit, ok := d.Continue(#{horizon} * time.Millisecond)
+    \tif !ok {
+    \t\tt.Fatal("expected to break once #{name} received #{count} messages by #{horizon}ms")
+    \t}
+    \tif n := d.Inspect("#{name}").Received; n < #{count} {
+    \t\tt.Fatalf("expected #{name} to have received #{count} messages at the breakpoint, got %d", n)
+    \t}
+    \t
+    \t// Stepping back to the start forks the run afresh, which goes on the same way
+    \td.Back(int(d.System().clock.(*VirtualClock).Steps()))
+    \tif n := d.Inspect("#{name}").Received; n >= #{count} {
+    \t\tt.Fatalf("expected stepping back to undo what #{name} received, got %d messages", n)
+    \t}
+    \tif again, ok := d.Continue(#{horizon} * time.Millisecond); !ok || again != hit {
+    \t\tt.Fatalf("expected the fork to break on %v again, got %v", hit, again)
+    \t}
+    }
+    """
+  end
+
+  defp generate_log_test(horizon) do
+    """
+
+    func TestLogsAreSampled(t *testing.T) {
+    \tsuppressed := func(every int) int {
+    \t\tsys := NewSystem(1, NewVirtualClock())
+    \t\tif err := sys.SetLogEvery(every); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\tsys.Start()
+    \t\trunUntil(t, sys, #{horizon} * time.Millisecond)
+    \t\treturn sys.SuppressedLogs()
+    \t}
+    \t
+    \tif n := suppressed(1); n != 0 {
+    \t\tt.Fatalf("expected every line logged, got %d suppressed", n)
+    \t}
+    \t// Every actor still logs its first line
+    \tif n := suppressed(1 << 30); n == 0 {
+    \t\tt.Fatal("expected sampling to leave out all but the first line of each actor")
+    \t}
+    \tif err := NewSystem(1, NewVirtualClock()).SetLogEvery(0); err == nil {
+    \t\tt.Fatal("expected an error logging one in every 0 lines")
+    \t}
+    }
+    """
+  end
+
+  defp generate_codec_test(horizon) do
+    """
+
+    func TestReportCodecsRoundTrip(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \twant := runUntil(t, sys, #{horizon} * time.Millisecond).String()
+    \t
+    \tfor name, codec := range Codecs {
+    \t\tvar saved strings.Builder
+    \t\tif err := sys.SaveReport(&saved, codec); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\treport, err := LoadReport(strings.NewReader(saved.String()), codec)
+    \t\tif err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\tif got := report.String(); got != want {
+    \t\t\tt.Errorf("expected the %s codec to load the report it saved, got\\n%s\\nwant\\n%s", name, got, want)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # The quantile a regression is flagged on must grow by more than 5%, so
+  # 4% slips through and 6% doesn't
+  defp generate_report_json_test(actors, horizon) do
+    """
+
+    func TestReportJSONFlagsLatencyRegressions(t *testing.T) {
+    \trun := func() []byte {
+    \t\tsys := NewSystem(1, NewVirtualClock())
+    \t\tsys.Start()
+    \t\trunUntil(t, sys, #{horizon} * time.Millisecond)
+    \t\treturn sys.ReportJSON()
+    \t}
+    \tdata := run()
+    \tif again := run(); string(again) != string(data) {
+    \t\tt.Fatalf("expected runs from the same seed to report the same JSON, got\\n%s\\nwant\\n%s", again, data)
+    \t}
+    \tbase, err := ParseReportJSON(data)
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tif base.Seed != 1 || len(base.Actors) != #{actors} || !base.Invariants.Conserved {
+    \t\tt.Fatalf("expected a report on all #{actors} actors from seed 1, conserving messages, got\\n%s", data)
+    \t}
+    \tif regressions := LatencyRegressions(base, base, 0.05); len(regressions) != 0 {
+    \t\tt.Fatalf("expected no regressions of a report on itself, got %v", regressions)
+    \t}
+    \t
+    \tbase.Actors[0].LatencyP99Ns = int64(100 * time.Millisecond)
+    \thead, err := ParseReportJSON(base.JSON())
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \thead.Actors[0].LatencyP99Ns = int64(104 * time.Millisecond)
+    \tif regressions := LatencyRegressions(base, head, 0.05); len(regressions) != 0 {
+    \t\tt.Fatalf("expected a 4%% slower p99 to stay within 5%%, got %v", regressions)
+    \t}
+    \thead.Actors[0].LatencyP99Ns = int64(106 * time.Millisecond)
+    \tregressions := LatencyRegressions(base, head, 0.05)
+    \tif len(regressions) != 1 || regressions[0].Quantile != "p99" || regressions[0].Actor != base.Actors[0].Name {
+    \t\tt.Fatalf("expected a 6%% slower p99 of %s to regress, got %v", base.Actors[0].Name, regressions)
+    \t}
+    \tif _, err := ParseReportJSON([]byte(`{"actors": []}`)); err == nil {
+    \t\tt.Fatal("expected a report without a schema version to be refused")
+    \t}
+    }
+    """
+  end
+
+  defp generate_halt_test(name, messages, types, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    overrides =
+      Enum.map_join(messages, "\n", fn msg ->
+        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+
+        """
+        func (c failing#{type_name}Callbacks) On#{msg_name}(#{callback_param(msg, types)}) error {
+        \treturn errMalformed
+        }
+        """
+      end)
+
+    """
+
+    // errMalformed is what failing#{type_name}Callbacks fail with
+    var errMalformed = errors.New("malformed message")
+
+    // failing#{type_name}Callbacks fail on every message, as on one they
+    // cannot make sense of
+    type failing#{type_name}Callbacks struct {
+    \t*Default#{type_name}Callbacks
+    }
+
+    #{overrides}
+    func TestCallbackErrorHaltsRun(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tsys.Start()
+    \tphony.Block(sys.#{field}, func() {
+    \t\tdefaults := sys.#{field}.callbacks.(*Default#{type_name}Callbacks)
+    \t\tsys.#{field}.callbacks = failing#{type_name}Callbacks{defaults}
+    \t})
+    \t
+    \t_, err := sys.RunUntil(#{horizon} * time.Millisecond)
+    \tif !errors.Is(err, errMalformed) {
+    \t\tt.Fatalf("expected the run to halt with the callback's error, got %v", err)
+    \t}
+    \tif err.Error() != "#{name}: malformed message" {
+    \t\tt.Fatalf("expected the error to name #{name}, got %q", err)
+    \t}
+    \tif n := sys.#{field}.SendCount(); n != 0 {
+    \t\tt.Fatalf("expected #{name} to send nothing its callback failed on, sent %d", n)
+    \t}
+    \tsteps := clock.Steps()
+    \tsys.Advance(time.Second)
+    \tif n := clock.Steps() - steps; n != 0 {
+    \t\tt.Fatalf("expected no timer to run once the run halted, %d ran", n)
+    \t}
+    \tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
+    """
+  end
+
+  defp generate_sleep_test(name, definition, messages, targets, types) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    per_tick =
+      case definition.send_pattern do
+        {:burst, count, _interval, _message} -> count
+        _pattern -> 1
+      end
+
+    sent = per_tick * length(targets)
+
+    overrides =
+      Enum.map_join(messages, "\n", fn msg ->
+        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+
+        """
+        func (c sleeping#{type_name}Callbacks) On#{msg_name}(#{callback_param(msg, types)}) error {
+        \tc.Ctx.SleepVirtual(10 * time.Millisecond)
+        \treturn nil
+        }
+        """
+      end)
+
+    """
+
+    // sleeping#{type_name}Callbacks models a 10ms query in every callback
+    type sleeping#{type_name}Callbacks struct {
+    \t*Default#{type_name}Callbacks
+    }
+
+    #{overrides}
+    func TestCallbacksSleepInVirtualTime(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \tphony.Block(sys.#{field}, func() {
+    \t\tdefaults := sys.#{field}.callbacks.(*Default#{type_name}Callbacks)
+    \t\tsys.#{field}.callbacks = sleeping#{type_name}Callbacks{defaults}
+    \t})
+    \t
+    \t// The first message is produced now but sent on only after its query
+    \th.Advance(#{Definition.first_send_delay(definition)} * time.Millisecond)
+    \tif n := sys.#{field}.SendCount(); n != 0 {
+    \t\tt.Fatalf("expected sends to wait for the query, got %d", n)
+    \t}
+    \th.Advance(10 * time.Millisecond)
+    \tif n := sys.#{field}.SendCount(); n != #{sent} {
+    \t\tt.Fatalf("expected a send count of #{sent} after the 10ms query, got %d", n)
+    \t}
+    }
+    """
+  end
+
+  defp generate_middleware_test(name, horizon) do
+    """
+
+    func TestMiddlewareWrapsHandlers(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \thandled := map[string]int{}
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\thandled[ctx.Actor]++
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tif handled["#{name}"] == 0 {
+    \t\tt.Fatal("expected middleware around #{name} handlers")
+    \t}
+    \tfor _, a := range sys.Report().Actors {
+    \t\tif a.Queue.Count > 0 && handled[a.Name] == 0 {
+    \t\t\tt.Errorf("expected middleware around the %d messages %s started on", a.Queue.Count, a.Name)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  defp generate_envelope_test(name, kind, horizon) do
+    """
+
+    func TestActForwardsEnvelopes(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \thandled := map[string]int{}
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Payload == "interop" {
+    \t\t\tif ctx.Trace != 99 || ctx.Priority != 3 {
+    \t\t\t\tt.Errorf("expected %s to see the envelope's headers, got %+v", ctx.Actor, ctx.Headers)
+    \t\t\t}
+    \t\t\thandled[ctx.Actor]++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \tenv := Envelope[string]{Headers: Headers{Trace: 99, Priority: 3}, Kind: "#{kind}", Payload: "interop"}
+    \tif err := Act(sys, "#{name}", env); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tif err := Act(sys, "#{name}", Envelope[string]{}); err == nil {
+    \t\tt.Fatal("expected #{name} to refuse an envelope of no kind")
+    \t}
+    \th.Advance(#{horizon} * time.Millisecond)
+    \tif handled["#{name}"] != 1 {
+    \t\tt.Fatalf("expected #{name} to handle the envelope once, got %d", handled["#{name}"])
+    \t}
+    }
+    """
+  end
+
+  defp generate_typed_test(name, kind, [{field_name, type} | _fields]) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    payload = GeneratorUtils.to_pascal_case(kind)
+
+    sample =
+      case type do
+        "int" -> "7"
+        "float64" -> "1.5"
+        "string" -> ~s("typed")
+        "bool" -> "true"
+        "[]byte" -> ~s([]byte("typed"))
+      end
+
+    """
+
+    // recording#{type_name}Callbacks records the #{kind} payloads #{name} handles
+    type recording#{type_name}Callbacks struct {
+    \t*Default#{type_name}Callbacks
+    \tgot []#{payload}
+    }
+
+    func (c *recording#{type_name}Callbacks) On#{payload}(msg #{payload}) error {
+    \tc.got = append(c.got, msg)
+    \treturn nil
+    }
+
+    func Test#{type_name}ReceivesTypedPayloads(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \trec := &recording#{type_name}Callbacks{}
+    \tphony.Block(sys.#{field}, func() {
+    \t\trec.Default#{type_name}Callbacks = sys.#{field}.callbacks.(*Default#{type_name}Callbacks)
+    \t\tsys.#{field}.callbacks = rec
+    \t})
+    \t
+    \twant := #{payload}{#{field_name}: #{sample}}
+    \tif err := Act(sys, "#{name}", Envelope[#{payload}]{Kind: "#{kind}", Payload: want}); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \th.Advance(0)
+    \tvar got []#{payload}
+    \tphony.Block(sys.#{field}, func() { got = rec.got })
+    \tfor _, msg := range got {
+    \t\tif fmt.Sprint(msg.#{field_name}) == fmt.Sprint(want.#{field_name}) {
+    \t\t\treturn
+    \t\t}
+    \t}
+    \tt.Fatalf("expected #{name}'s callback to get the #{kind} payload %+v, got %+v", want, got)
+    }
+    """
+  end
+
+  defp generate_step_limit_test(horizon) do
+    """
+
+    func TestStepLimitStopsRunaways(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tclock.SetStepLimit(1)
+    \tsys := NewSystem(1, clock)
+    \tsys.Start()
+    \t
+    \tif _, err := sys.RunUntil(#{horizon} * time.Millisecond); !errors.Is(err, ErrStepLimitExceeded) {
+    \t\tt.Fatalf("expected the step limit to stop the run, got %v", err)
+    \t}
+    \tif steps := clock.Steps(); steps != 1 {
+    \t\tt.Fatalf("expected the run to stop after 1 timer, got %d", steps)
+    \t}
+    }
+    """
+  end
+
+  defp generate_latency_test(name, [source | _hops] = path, total, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+
+    """
+
+    func Test#{type_name}LatencyAddsUpEdgeDelays(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \t
+    \treport := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \tfor _, a := range report.Actors {
+    \t\tif a.Name != "#{name}" {
+    \t\t\tcontinue
+    \t\t}
+    \t\t// Every message takes #{Enum.join(path, " -> ")}
+    \t\tif a.Received == 0 || a.P50 != #{total}*time.Millisecond || a.P99 != #{total}*time.Millisecond {
+    \t\t\tt.Fatalf("expected the edge delays from #{source} to add up to #{total}ms at #{name}, got p50 %v and p99 %v over %d messages", a.P50, a.P99, a.Received)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  defp generate_delay_test do
+    """
+
+    func TestDelayDistributions(t *testing.T) {
+    \tdraw := NewSystem(7, NewVirtualClock()).Float64
+    \tms := time.Millisecond
+    \tcases := []struct {
+    \t\tname string
+    \t\tdelay Delay
+    \t\tmean float64
+    \t\tvariance float64
+    \t}{
+    \t\t{"constant", ConstantDelay(30 * ms), 30, 0},
+    \t\t{"uniform", NewUniformDelay(draw, 10 * ms, 50 * ms), 30, 40 * 40 / 12.0},
+    \t\t{"exponential", NewExponentialDelay(draw, 30 * ms), 30, 30 * 30},
+    \t\t{"normal", NewNormalDelay(draw, 30 * ms, 5 * ms), 30, 5 * 5},
+    \t}
+    \t
+    \tfor _, c := range cases {
+    \t\tconst n = 10000
+    \t\tvar sum, sumSquares float64
+    \t\tfor i := 0; i < n; i++ {
+    \t\t\tx := float64(c.delay.Sample()) / float64(ms)
+    \t\t\tsum += x
+    \t\t\tsumSquares += x * x
+    \t\t}
+    \t\tmean := sum / n
+    \t\tvariance := sumSquares/n - mean*mean
+    \t\tif mean < c.mean*0.95 || mean > c.mean*1.05 {
+    \t\t\tt.Errorf("%s delay has mean %.2fms, want %.2fms", c.name, mean, c.mean)
+    \t\t}
+    \t\tif variance < c.variance*0.8-0.01 || variance > c.variance*1.2+0.01 {
+    \t\t\tt.Errorf("%s delay has variance %.2f, want %.2f", c.name, variance, c.variance)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  defp generate_quiescent_test(horizon) do
+    """
+
+    func TestSystemSettles(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.WaitQuiescent()
+    \th.AssertQuiescent()
+    }
+    """
+  end
+
+  # Without timeouts, queues or acknowledgements the check runs once the
+  # system has settled
+  defp generate_conservation_test(name, horizon, settles?) do
+    settle = if settles?, do: "\th.WaitQuiescent()\n", else: ""
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func TestMessagesAreConserved(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    #{settle}\tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t
+    \t// A buggy drop path that discards a message without counting it
+    \tsys.produce(sys.#{field}, "#{name}", func() {})
+    \tif err := sys.CheckConservation(); err == nil {
+    \t\tt.Fatal("expected an uncounted drop to fail the conservation check")
+    \t}
+    }
+    """
+  end
+
+  # Whether an actor sends before every other actor, so the first messages
+  # its targets handle are its own
+  defp sends_first?(name, definition, simulated) do
+    first = Definition.first_send_delay(definition)
+
+    Enum.all?(simulated, fn {other, other_definition} ->
+      cond do
+        other == name -> true
+        # A schedule may send at any time
+        other_definition.schedule_file != nil -> false
+        other_definition.send_pattern == nil -> true
+        true -> Definition.first_send_delay(other_definition) > first
+      end
+    end)
+  end
+
+  defp generate_policy_test(targets, horizon) do
+    first = List.first(targets)
+    favored = List.last(targets)
+
+    """
+
+    func TestSchedulingPolicies(t *testing.T) {
+    \tschedule := func(policy Policy) string {
+    \t\tclock := NewVirtualClock()
+    \t\tclock.SetPolicy(policy)
+    \t\tsys := NewSystem(1, clock)
+    \t\tif err := sys.SetPriority("#{favored}", 1); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\tvar handled []string
+    \t\tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\t\thandled = append(handled, ctx.Actor)
+    \t\t\tnext()
+    \t\t}))
+    \t\th := simtest.NewHarness(t, sys, clock)
+    \t\t
+    \t\th.Advance(#{horizon} * time.Millisecond)
+    \t\th.DrainQuiescent()
+    \t\treturn " " + strings.Join(handled, " ") + " "
+    \t}
+    \t
+    \tfair, biased := schedule(FIFO), schedule(Priority)
+    \tif fair != schedule(FIFO) || biased != schedule(Priority) {
+    \t\tt.Fatal("a policy produced different schedules across runs")
+    \t}
+    \tif strings.Index(fair, " #{first} ") > strings.Index(fair, " #{favored} ") {
+    \t\tt.Errorf("FIFO should deliver to #{first} first")
+    \t}
+    \tif strings.Index(biased, " #{favored} ") > strings.Index(biased, " #{first} ") {
+    \t\tt.Errorf("Priority should deliver to #{favored} first")
+    \t}
+    }
+    """
+  end
+
+  # Under SourceOrder an actor takes the messages due at one instant in the
+  # declaration order of their sources, the same way every run.
+  defp generate_merge_test(name, sources, horizon) do
+    """
+
+    func TestMergeOrderIsStable(t *testing.T) {
+    \tmerge := func() (*System, []HandlerContext) {
+    \t\tclock := NewVirtualClock()
+    \t\tclock.SetPolicy(SourceOrder)
+    \t\tsys := NewSystem(1, clock)
+    \t\tvar arrivals []HandlerContext
+    \t\tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\t\tif ctx.Actor == "#{name}" {
+    \t\t\t\tarrivals = append(arrivals, ctx)
+    \t\t\t}
+    \t\t\tnext()
+    \t\t}))
+    \t\tsys.Start()
+    \t\trunUntil(t, sys, #{horizon} * time.Millisecond)
+    \t\treturn sys, arrivals
+    \t}
+    \t
+    \tsys, first := merge()
+    \tsources := map[string]bool{}
+    \tfor i, a := range first {
+    \t\tsources[a.ID.Source] = true
+    \t\tif i > 0 && a.Now == first[i-1].Now && sys.ranks[a.ID.Source] < sys.ranks[first[i-1].ID.Source] {
+    \t\t\tt.Errorf("#{name} took %v before %v at %v, against declaration order", first[i-1].ID, a.ID, a.Now)
+    \t\t}
+    \t}
+    \tif len(sources) != #{sources} {
+    \t\tt.Fatalf("expected #{name} to merge the messages of #{sources} sources, got %v", sources)
+    \t}
+    \tif _, second := merge(); fmt.Sprint(second) != fmt.Sprint(first) {
+    \t\tt.Fatal("#{name} merged its sources in a different order across runs")
+    \t}
+    }
+    """
+  end
+
+  # Without sources that merge, two actors that handle a message as it
+  # arrives each get one from outside at the same instants, the later
+  # declared first, so SourceOrder has a tie to break by declaration order
+  defp generate_tie_test(simulated, topology, horizon) do
+    simulated
+    |> Enum.filter(fn {name, definition} ->
+      Map.fetch!(topology.messages, name) != [] and immediate?(definition) and
+        definition.parallelism == nil and definition.conflate_by == nil and
+        not definition.deadline_aware and
+        name not in reach(Map.fetch!(topology.edges, name), topology.edges) and
+        reply_roles(topology, name).answers == []
+    end)
+    |> Enum.map(fn {name, _definition} ->
+      {name, GeneratorUtils.message_name(hd(Map.fetch!(topology.messages, name)))}
+    end)
+    |> case do
+      [{first, first_kind}, {second, second_kind} | _] ->
+        """
+
+        func TestMergeOrderIsStable(t *testing.T) {
+        \tmerge := func() []HandlerContext {
+        \t\tclock := NewVirtualClock()
+        \t\tclock.SetPolicy(SourceOrder)
+        \t\tsys := NewSystem(1, clock)
+        \t\tvar arrivals []HandlerContext
+        \t\tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+        \t\t\tarrivals = append(arrivals, ctx)
+        \t\t\tnext()
+        \t\t}))
+        \t\th := simtest.NewHarness(t, sys, clock)
+        \t\tfor i := 0; i < 3; i++ {
+        \t\t\th.Advance(#{max(div(horizon, 3), 1)} * time.Millisecond)
+        \t\t\t// The later declared actor gets its message first
+        \t\t\tfor _, env := range []struct{ name, kind string }{{"#{second}", "#{second_kind}"}, {"#{first}", "#{first_kind}"}} {
+        \t\t\t\tif err := Act(sys, env.name, Envelope[string]{Kind: env.kind, Payload: "tie"}); err != nil {
+        \t\t\t\t\tt.Fatal(err)
+        \t\t\t\t}
+        \t\t\t}
+        \t\t\th.DrainQuiescent()
+        \t\t}
+        \t\treturn arrivals
+        \t}
+        \t
+        \tfirst := merge()
+        \tvar ties []HandlerContext
+        \tfor _, a := range first {
+        \t\tif a.Payload == "tie" && a.Actor == a.ID.Source {
+        \t\t\tties = append(ties, a)
+        \t\t}
+        \t}
+        \tif len(ties) != 6 {
+        \t\tt.Fatalf("expected #{first} and #{second} to take 3 messages each, got %v", ties)
+        \t}
+        \tfor i := 0; i < len(ties); i += 2 {
+        \t\tif ties[i].Actor != "#{first}" || ties[i+1].Actor != "#{second}" || ties[i].Now != ties[i+1].Now {
+        \t\t\tt.Errorf("expected #{first} before #{second} at the same instant, in declaration order, got %v then %v", ties[i], ties[i+1])
+        \t\t}
+        \t}
+        \tif second := merge(); fmt.Sprint(second) != fmt.Sprint(first) {
+        \t\tt.Fatal("#{first} and #{second} took their messages in a different order across runs")
+        \t}
+        }
+        """
+
+      _too_few ->
+        ""
+    end
+  end
+
+  defp periodic?({kind, _, _}) when kind in [:periodic, :rate], do: true
+  defp periodic?({:burst, _, _, _}), do: true
+  defp periodic?(_pattern), do: false
+
+  # Sends on an interval that only Reconfigure changes, unlike a ramp, to
+  # every target, unlike a routing actor
+  defp steady?(definition),
+    do:
+      periodic?(definition.send_pattern) and ramp(definition) == nil and
+        routing(definition) == :broadcast and affinity(definition) == nil
+
+  defp generate_fork_test(name, definition, horizon) do
+    field = GeneratorUtils.to_camel_case(name)
+    interval = max(div(Definition.interval_for_pattern(definition.send_pattern), 2), 1)
+    # Past the tick due when forking, the faster fork has sent at least once more
+    until = 2 * horizon
+
+    """
+
+    func TestForkBranchesRun(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \trunUntil(t, sys, #{div(horizon, 2)} * time.Millisecond)
+    \tsnap := sys.Snapshot()
+    \twant := runUntil(t, sys, #{until} * time.Millisecond).String()
+    \t
+    \t// A fork replays the run up to the snapshot, so it goes on the same way
+    \tsame := Fork(snap)
+    \tif got := runUntil(t, same, #{until} * time.Millisecond).String(); got != want {
+    \t\tt.Fatalf("expected the fork to go on like the original run, got\\n%s\\nwant\\n%s", got, want)
+    \t}
+    \tfaster := Fork(snap)
+    \tspec := &Spec{Intervals: map[string]time.Duration{"#{name}": #{interval} * time.Millisecond}}
+    \tif err := faster.Reconfigure(spec); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \treconfigured := faster.Snapshot()
+    \t// Reconfigure keeps its own copy of the spec for forks to replay
+    \tspec.Intervals["#{name}"] = #{until} * time.Millisecond
+    \t
+    \tgot := runUntil(t, faster, #{until} * time.Millisecond)
+    \tt.Log("\\n" + Compare(map[string]Report{"same": same.Report(), "faster": got}))
+    \tif faster.#{field}.SendCount() <= same.#{field}.SendCount() {
+    \t\tt.Fatalf("expected #{name} to send more once it sends faster, got %d, before %d", faster.#{field}.SendCount(), same.#{field}.SendCount())
+    \t}
+    \tif again := runUntil(t, Fork(reconfigured), #{until} * time.Millisecond).String(); again != got.String() {
+    \t\tt.Fatalf("expected a fork to replay the spec as applied, got\\n%s\\nwant\\n%s", again, got)
+    \t}
+    }
+    """
+  end
+
+  defp generate_diff_test(name, definition) do
+    interval = Definition.interval_for_pattern(definition.send_pattern)
+    first = Definition.first_send_delay(definition)
+    [message | _] = Definition.messages_for_pattern(definition.send_pattern)
+    message = GeneratorUtils.message_name(message)
+    # The slower run skips the tick due one interval after the first
+    skipped = first + interval
+    until = first + 2 * interval
+
+    """
+
+    func TestDiffRunsFindsChangedInterval(t *testing.T) {
+    \tif d := DiffRuns(1, #{until} * time.Millisecond, nil, nil); d.Diverged() {
+    \t\tt.Fatalf("expected two runs of one seed to be alike, got\\n%s", d)
+    \t}
+    \tslower := func(s *System) {
+    \t\tif err := s.Reconfigure(&Spec{Intervals: map[string]time.Duration{"#{name}": #{2 * interval} * time.Millisecond}}); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t}
+    \t
+    \td := DiffRuns(1, #{until} * time.Millisecond, nil, slower)
+    \tt.Log("\\n" + d.String())
+    \tif !d.Diverged() || d.Left == nil || d.Left.Actor != "#{name}" || d.Left.At != #{skipped} * time.Millisecond {
+    \t\tt.Fatalf("expected the runs to diverge at the #{skipped}ms tick of #{name}, got\\n%s", d)
+    \t}
+    \tfor _, c := range d.Counts {
+    \t\tif c.Actor == "#{name}" && c.Message == "#{message}" && c.Right >= c.Left {
+    \t\t\tt.Fatalf("expected #{name} to produce fewer messages once it sends slower, got %d, before %d", c.Right, c.Left)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # The spawned actor is removed again unless its kind can't crash, since
+  # a removed actor goes down like a crashed one
+  defp generate_reconfigure_test(name, definition, [target | _], horizon, removable) do
+    field = GeneratorUtils.to_camel_case(name)
+    spawned = "#{target}_spawned"
+    interval = max(div(Definition.interval_for_pattern(definition.send_pattern), 2), 1)
+
+    remove_code =
+      if removable do
+        """
+        \t
+        \t// Removing the spawned actor drops its edge again
+        \tsent := sys.#{field}.SendCount()
+        \tif err := sys.Reconfigure(&Spec{Remove: []string{"#{spawned}"}}); err != nil {
+        \t\tt.Fatal(err)
+        \t}
+        \th.Advance(#{horizon} * time.Millisecond)
+        \th.DrainQuiescent()
+        \tif removed := sys.#{field}.SendCount() - sent; removed >= after {
+        \t\tt.Fatalf("#{name} sent %d messages after removing #{spawned}, %d before", removed, after)
+        \t}
+        \ttotals()
+        \tif err := sys.CheckConservation(); err != nil {
+        \t\tt.Fatal(err)
+        \t}
+        \tif err := sys.Reconfigure(&Spec{Remove: []string{"#{spawned}"}}); err == nil {
+        \t\tt.Fatal("expected an error removing an actor twice")
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    func TestReconfigureLive(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t// The report and its totals cover the actors there are at the time
+    \ttotals := func() {
+    \t\tt.Helper()
+    \t\tvar sent, received int
+    \t\tfor _, a := range sys.actors {
+    \t\t\tm := a.Metrics()
+    \t\t\tsent, received = sent+m.SendCount, received+m.RecvCount
+    \t\t}
+    \t\tif n := len(sys.Report().Actors); n != len(sys.actors) {
+    \t\t\tt.Fatalf("expected a row for each of the %d actors, got %d", len(sys.actors), n)
+    \t\t}
+    \t\tif m := sys.SystemMetrics(); m.Sent != sent || m.Received != received {
+    \t\t\tt.Fatalf("expected %d sent and %d received in all, got %+v", sent, received, m)
+    \t\t}
+    \t}
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tbefore := sys.#{field}.SendCount()
+    \terr := sys.Reconfigure(&Spec{
+    \t\tSpawn: map[string]string{"#{spawned}": "#{target}"},
+    \t\tConnect: []Edge{{From: "#{name}", To: "#{spawned}"}},
+    \t\tIntervals: map[string]time.Duration{"#{name}": #{interval} * time.Millisecond},
+    \t})
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \t// One more target, sent to at twice the rate
+    \tafter := sys.#{field}.SendCount() - before
+    \tif after <= before {
+    \t\tt.Fatalf("#{name} sent %d messages after reconfiguring, %d before", after, before)
+    \t}
+    \ttotals()
+    #{remove_code}\tif err := sys.Reconfigure(&Spec{Connect: []Edge{{From: "#{name}", To: "missing"}}}); err == nil {
+    \t\tt.Fatal("expected an error connecting to an unknown actor")
+    \t}
+    }
+    """
+  end
+
+  # Every edge NewSystem wires must show in the graph, and no other
+  defp generate_topology_test(simulated, topology) do
+    wired =
+      Enum.map_join(simulated, fn {name, _definition} ->
+        field = GeneratorUtils.to_camel_case(name)
+
+        targets =
+          if Map.fetch!(topology.targets, name) == [] do
+            ""
+          else
+            """
+            \tfor _, to := range sys.#{field}.targets {
+            \t\twired = append(wired, fmt.Sprintf("%q -> %q [", "#{name}", names[to]))
+            \t}
+            """
+          end
+
+        if Map.fetch!(topology.fallbacks, name) do
+          targets <>
+            "\twired = append(wired, fmt.Sprintf(\"%q -> %q [\", \"#{name}\", " <>
+            "names[sys.#{field}.fallback]))\n"
+        else
+          targets
+        end
+      end)
+
+    """
+
+    func TestWriteDOTShowsWiring(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tvar b strings.Builder
+    \tif err := sys.WriteDOT(&b); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tdot := b.String()
+    \t
+    \tnames := map[any]string{}
+    \tfor name, a := range sys.actors {
+    \t\tnames[a] = name
+    \t\tif !strings.Contains(dot, fmt.Sprintf("\\t%q\\n", name)) {
+    \t\t\tt.Errorf("expected a node for %s", name)
+    \t\t}
+    \t}
+    \tvar wired []string
+    #{wired}\tfor _, edge := range wired {
+    \t\tif !strings.Contains(dot, edge) {
+    \t\t\tt.Errorf("expected the graph to show %s]", edge)
+    \t\t}
+    \t}
+    \tif n := strings.Count(dot, " -> "); n != len(wired) {
+    \t\tt.Fatalf("expected the %d edges NewSystem wires, got %d", len(wired), n)
+    \t}
+    }
+    """
+  end
+
+  defp generate_labels_test(simulated, {key, value}) do
+    selected =
+      simulated
+      |> Enum.filter(fn {_name, definition} -> {key, value} in labels(definition) end)
+      |> Enum.map(fn {name, _definition} -> to_string(name) end)
+      |> Enum.sort()
+      |> Enum.join(",")
+
+    selector = "map[string]string{#{go_string(key)}: #{go_string(value)}}"
+
+    """
+
+    func TestSelectByLabels(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \t
+    \tif got := strings.Join(sys.Select(#{selector}), ","); got != "#{selected}" {
+    \t\tt.Fatalf("expected the selector to pick #{selected}, got %s", got)
+    \t}
+    \tif got := len(sys.Select(nil)); got != #{length(simulated)} {
+    \t\tt.Fatalf("expected an empty selector to select all #{length(simulated)} actors, got %d", got)
+    \t}
+    }
+    """
+  end
+
+  # Every message a join receives completes a pair, expires or still waits
+  # for its match. When both streams come straight from sources whose first
+  # messages land within the window, the first keys must join.
+  defp generate_join_test(name, definition, definitions, topology, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    first_arrivals =
+      for {from, edges} <- topology.edges,
+          name in edges,
+          sender <- [Map.fetch!(definitions, from)],
+          periodic?(sender.send_pattern),
+          not lossy_edge?(sender, name) and not delayed_edge?(sender, name),
+          do: Definition.first_send_delay(sender)
+
+    joins? =
+      case first_arrivals do
+        [left, right] -> abs(left - right) <= join_window(definition).within
+        _ -> false
+      end
+
+    join_check =
+      if joins? do
+        """
+        \tif joined == 0 {
+        \t\tt.Fatal("expected the first messages of both streams to join")
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    func Test#{type_name}JoinsWithinWindow(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \treceived := 0
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{name}" {
+    \t\t\treceived++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tjoined, expired, waiting := sys.#{field}.JoinedCount(), sys.#{field}.ExpiredCount(), sys.#{field}.queued()
+    \tif 2*joined+expired+waiting != received {
+    \t\tt.Fatalf("expected each of %d messages to join, expire or wait, got %d pairs, %d expired and %d waiting",
+    \t\t\treceived, joined, expired, waiting)
+    \t}
+    #{join_check}}
+    """
+  end
+
+  defp generate_id_test(horizon) do
+    """
+
+    func TestMessageIDsAreReproducible(t *testing.T) {
+    \trun := func() (string, uint64, int64) {
+    \t\tclock := NewVirtualClock()
+    \t\tsys := NewSystem(1, clock)
+    \t\tvar ids []string
+    \t\tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\t\tids = append(ids, ctx.ID.String())
+    \t\t\tnext()
+    \t\t}))
+    \t\th := simtest.NewHarness(t, sys, clock)
+    \t\t
+    \t\th.Advance(#{horizon} * time.Millisecond)
+    \t\th.DrainQuiescent()
+    \t\treturn strings.Join(ids, " "), sys.NextID(), sys.ledger.produced.Load()
+    \t}
+    \t
+    \tids, next, produced := run()
+    \tif again, _, _ := run(); again != ids {
+    \t\tt.Fatal("expected the same seed to allocate the same message IDs")
+    \t}
+    \t// One ID per produced message, none skipped
+    \tif next != uint64(produced)+1 {
+    \t\tt.Fatalf("expected ID %d after %d messages, got %d", produced+1, produced, next)
+    \t}
+    }
+    """
+  end
+
+  # Reads back what an observed actor received; the count is exact when the
+  # messages reaching it are predictable
+  defp generate_observe_test(name, handled, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    count_check =
+      if handled do
+        """
+        \tif n := recorded + sys.#{field}.Unrecorded(); n != #{handled} {
+        \t\tt.Fatalf("expected #{name} to record or count #{handled} messages, got %d", n)
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    func Test#{type_name}RecordsReceived(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \th.Advance(#{horizon} * time.Millisecond)
+    \th.DrainQuiescent()
+    \tvar last time.Duration
+    \trecorded := 0
+    \tfor len(sys.#{field}.Received()) > 0 {
+    \t\tmsg := <-sys.#{field}.Received()
+    \t\tif msg.ID.Seq == 0 || msg.At < last {
+    \t\t\tt.Fatalf("expected messages in order with IDs, got %v at %v after %v", msg.ID, msg.At, last)
+    \t\t}
+    \t\tlast = msg.At
+    \t\trecorded++
+    \t}
+    #{count_check}}
+    """
+  end
+
+  # Every timer fires once per interval, however often the others fire
+  # Records go through MemoryKafka: the source must originate one message
+  # for each in order, and the sink produce what it is acted on with
+  defp generate_kafka_test(name, %{topic: topic, message: message}, sink, horizon) do
+    kind = GeneratorUtils.message_name(message)
+
+    {act, check} =
+      case sink do
+        nil ->
+          {"", ""}
+
+        {sink, sink_topic, sink_kind} ->
+          {"""
+           \tif err := Act(sys, "#{sink}", Envelope[string]{Kind: "#{sink_kind}", Payload: "result"}); err != nil {
+           \t\tt.Fatal(err)
+           \t}
+           """,
+           """
+           \tproduced := 0
+           \tfor _, r := range kafka.Records("#{sink_topic}") {
+           \t\tif string(r.Value) == "result" {
+           \t\t\tproduced++
+           \t\t}
+           \t}
+           \tif produced != 1 {
+           \t\tt.Fatalf("expected #{sink} to produce what it handled to #{sink_topic} once, got %d", produced)
+           \t}
+           """}
+      end
+
+    """
+
+    func TestKafkaConnectsSourcesAndSinks(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tingested := 0
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{name}" && msg == "#{kind}" {
+    \t\t\tif v, _ := ctx.Payload.([]byte); string(v) != fmt.Sprintf("record-%d", ingested) {
+    \t\t\t\tt.Errorf("expected #{name} to originate record-%d next, got %q", ingested, v)
+    \t\t\t}
+    \t\t\tingested++
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \t
+    \tkafka := &MemoryKafka{}
+    \tfor i := 0; i < 5; i++ {
+    \t\tkafka.Produce(context.Background(), nil, KafkaRecord{Topic: #{inspect(topic)}, Value: []byte(fmt.Sprintf("record-%d", i))})
+    \t}
+    \tif err := sys.ConnectKafka(context.Background(), kafka); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    #{act}\th.Advance(#{horizon} * time.Millisecond)
+    \tif ingested != 5 {
+    \t\tt.Fatalf("expected #{name} to originate a message for each of 5 records, got %d", ingested)
+    \t}
+    #{check}\tif err := sys.KafkaError(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
+    """
+  end
+
+  # Cut off from its senders, the actor only sees the envelopes the test
+  # acts with: one of priority 0 amid a stream of priority 1 arriving twice
+  # as fast as it serves them, which would starve the first without aging
+  defp generate_aging_test(name, kind, service_time, every) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    bound = every + 2 * service_time
+
+    """
+
+    func Test#{type_name}AgesLowPriorityMessages(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \tserved := time.Duration(-1)
+    \tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\tif ctx.Actor == "#{name}" && ctx.Payload == "low" {
+    \t\t\tserved = ctx.Now
+    \t\t}
+    \t\tnext()
+    \t}))
+    \th := simtest.NewHarness(t, sys, clock)
+    \tif err := sys.Partition([]string{"#{name}"}); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t
+    \thigh := Envelope[string]{Headers: Headers{Priority: 1}, Kind: "#{kind}", Payload: "high"}
+    \tlow := Envelope[string]{Kind: "#{kind}", Payload: "low"}
+    \tact := func(envs ...Envelope[string]) {
+    \t\tfor _, env := range envs {
+    \t\t\tif err := Act(sys, "#{name}", env); err != nil {
+    \t\t\t\tt.Fatal(err)
+    \t\t\t}
+    \t\t}
+    \t}
+    \tact(high, low, high)
+    \tfor i := 0; i < #{div(2 * every, service_time)}; i++ {
+    \t\th.Advance(#{service_time} * time.Millisecond)
+    \t\tact(high, high)
+    \t}
+    \tif served < 0 || served > #{bound}*time.Millisecond {
+    \t\tt.Fatalf("expected the low priority message to be served within #{bound}ms under sustained load, got %v", served)
+    \t}
+    }
+    """
+  end
+
+  # A partition from the start cuts the actor off from every message, so
+  # nothing resets its idle timeout
+  defp generate_idle_test(name, ms) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func Test#{type_name}FiresIdleAfterTimeout(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \tplan := []PartitionStep{{At: 0, Heal: #{4 * ms} * time.Millisecond, Groups: [][]string{{"#{name}"}}}}
+    \tif err := sys.SchedulePartitions(plan); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t
+    \th.Advance(#{ms - 1} * time.Millisecond)
+    \tif n := sys.#{field}.IdleCount(); n != 0 {
+    \t\tt.Fatalf("expected #{name} not to idle before #{ms}ms, got %d", n)
+    \t}
+    \th.Advance(#{2 * ms + 1} * time.Millisecond)
+    \tif n := sys.#{field}.IdleCount(); n != 3 {
+    \t\tt.Fatalf("expected #{name} to idle every #{ms}ms it received nothing, 3 times by #{3 * ms}ms, got %d", n)
+    \t}
+    }
+    """
+  end
+
+  defp generate_one_shot_test(name, first, delay) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    due = first + delay
+
+    """
+
+    func Test#{type_name}OneShotResetsAndCancels(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \tif !sys.#{field}.Cancel() {
+    \t\tt.Fatal("expected the #{name} one-shot to be pending before #{first}ms")
+    \t}
+    \trunUntil(t, sys, #{first} * time.Millisecond)
+    \tif n := sys.#{field}.SendCount(); n != 0 {
+    \t\tt.Fatalf("expected a cancelled one-shot to send nothing, sent %d", n)
+    \t}
+    \t
+    \tsys.#{field}.Reset()
+    \trunUntil(t, sys, #{due - 1} * time.Millisecond)
+    \tif n := sys.#{field}.SendCount(); n != 0 {
+    \t\tt.Fatalf("expected the reset one-shot to wait #{delay}ms, sent %d by #{due - 1}ms", n)
+    \t}
+    \trunUntil(t, sys, #{due} * time.Millisecond)
+    \tsent := sys.#{field}.SendCount()
+    \tif sent == 0 {
+    \t\tt.Fatal("expected the reset one-shot to fire at #{due}ms")
+    \t}
+    \tif sys.#{field}.Cancel() {
+    \t\tt.Fatal("expected nothing left to cancel once the one-shot fired")
+    \t}
+    \trunUntil(t, sys, #{due + 2 * delay} * time.Millisecond)
+    \tif n := sys.#{field}.SendCount(); n != sent {
+    \t\tt.Fatalf("expected the one-shot to fire once, sent %d then %d", sent, n)
+    \t}
+    }
+    """
+  end
+
+  defp generate_payload_test(name, definition, kind, until) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    msg_name = GeneratorUtils.to_pascal_case(kind)
+
+    checks =
+      Enum.map_join(definition.payload, fn {field, generator} ->
+        payload_check(kind, GeneratorUtils.to_pascal_case(field), generator)
+      end)
+
+    """
+
+    func Test#{type_name}OriginatesPayloads(t *testing.T) {
+    \toriginate := func() []#{msg_name} {
+    \t\tsys := NewSystem(1, NewVirtualClock())
+    \t\tvar sent []#{msg_name}
+    \t\tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+    \t\t\tif ctx.Actor == "#{name}" && msg == "#{kind}" {
+    \t\t\t\tsent = append(sent, ctx.Payload.(#{msg_name}))
+    \t\t\t}
+    \t\t\tnext()
+    \t\t}))
+    \t\tsys.Start()
+    \t\trunUntil(t, sys, #{until} * time.Millisecond)
+    \t\treturn sent
+    \t}
+    \t
+    \tsent := originate()
+    \tif len(sent) == 0 {
+    \t\tt.Fatal("expected #{name} to originate #{kind} messages by #{until}ms")
+    \t}
+    \tfor i, m := range sent {
+    #{checks}\t}
+    \tif fmt.Sprint(sent) != fmt.Sprint(originate()) {
+    \t\tt.Fatal("expected the same payloads from the same seed")
+    \t}
+    }
+    """
+  end
+
+  defp payload_check(kind, field, :sequential) do
+    """
+    \t\tif m.#{field} != i+1 {
+    \t\t\tt.Errorf("expected #{kind} %d to carry #{field} %d, got %d", i, i+1, m.#{field})
+    \t\t}
+    """
+  end
+
+  defp payload_check(kind, field, {:random_int, max}) do
+    """
+    \t\tif m.#{field} < 0 || m.#{field} >= #{max} {
+    \t\t\tt.Errorf("expected #{kind} %d to carry #{field} below #{max}, got %d", i, m.#{field})
+    \t\t}
+    """
+  end
+
+  defp payload_check(kind, field, {_random, n}) do
+    """
+    \t\tif len(m.#{field}) != #{n} {
+    \t\t\tt.Errorf("expected #{kind} %d to carry #{n} long #{field}, got %d", i, len(m.#{field}))
+    \t\t}
+    """
+  end
+
+  defp generate_timer_test(name, timers, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    until = Enum.max([horizon | Keyword.values(timers)])
+
+    counts =
+      Enum.map_join(timers, ", ", fn {timer, ms} -> ~s|"#{timer}": #{div(until, ms)}| end)
+
+    """
+
+    func Test#{type_name}TimersFireIndependently(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \trunUntil(t, sys, #{until} * time.Millisecond)
+    \t
+    \tfor timer, want := range map[string]int{#{counts}} {
+    \t\tif n := sys.#{field}.TimerCount(timer); n != want {
+    \t\t\tt.Errorf("expected the #{name} %s timer to fire %d times in #{until}ms, got %d", timer, want, n)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # Checks the alarms an actor raised once its first window after the
+  # horizon closed; some are certain, for a burst over the upper threshold
+  # within one window or an empty first window under the lower one
+  defp generate_alarm_test(name, definition, targets, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    alarm = alarm(definition)
+    thresholds = Enum.filter([above: ">", below: "<"], fn {key, _op} -> alarm[key] end)
+    crossed =
+      Enum.map_join(thresholds, " || ", fn {key, op} -> "alarm.Value #{op} #{alarm[key]}" end)
+    described = Enum.map_join(thresholds, " or ", fn {key, _op} -> "#{key} #{alarm[key]}/s" end)
+
+    expect =
+      case expected_alarm(definition, alarm, targets) do
+        nil ->
+          ""
+
+        reason ->
+          """
+          \tif len(alarms) == 0 {
+          \t\tt.Fatal("expected #{reason}")
+          \t}
+          """
+      end
+
+    """
+
+    func Test#{type_name}RaisesAlarms(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \trunUntil(t, sys, #{horizon + alarm.window} * time.Millisecond)
+    \t
+    \talarms := sys.#{field}.Alarms()
+    \tt.Logf("#{name} raised %v", alarms)
+    #{expect}\tfor _, alarm := range alarms {
+    \t\tif alarm.Metric != "send_rate" || !(#{crossed}) {
+    \t\t\tt.Errorf("expected alarms on a send rate #{described}, got %v", alarm)
+    \t\t}
+    \t\tif alarm.At%(#{alarm.window} * time.Millisecond) != 0 {
+    \t\t\tt.Errorf("expected alarms as #{alarm.window}ms windows close, got one at %v", alarm.At)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # A burst's sends all fall into one window, and nothing is sent before the
+  # first send
+  defp expected_alarm(
+         %{send_pattern: {:burst, count, _interval, _message}, loss: nil} = definition,
+         %{above: above, window: window},
+         targets
+       )
+       when above != nil and count * targets * 1000 / window > above do
+    if immediate?(definition) do
+      "a burst of #{count * targets} sends within #{window}ms to cross #{above}/s"
+    end
+  end
+
+  defp expected_alarm(definition, %{below: below, window: window}, _targets) when below != nil do
+    first = Definition.first_send_delay(definition)
+
+    if first && first > window do
+      "no sends in the first #{window}ms to fall below #{below}/s"
+    end
+  end
+
+  defp expected_alarm(_definition, _alarm, _targets), do: nil
+
+  # Sends climb (or fall) from one half of the ramp to the next, and the
+  # rate holds at its target once the ramp is over
+  defp generate_ramp_test(name, ramp, bottleneck) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    half = div(ramp.over, 2)
+    rest = ramp.over - half
+    op = if ramp.to > ramp.from, do: "<=", else: ">="
+    more = if ramp.to > ramp.from, do: "more", else: "less"
+
+    climb =
+      if ramp.to != ramp.from do
+        """
+        \tif rest := sys.#{field}.SendCount() - half; rest #{op} half {
+        \t\tt.Fatalf("expected #{name} to send #{more} in the second half of its ramp, got %d then %d", half, rest)
+        \t}
+        """
+      else
+        ""
+      end
+
+    breakpoint =
+      if bottleneck do
+        """
+        \th.Advance(#{bottleneck.hold} * time.Millisecond)
+        \tlimit := 1000.0 / #{bottleneck.load}
+        \tif b := ramp().Breakpoint; b == nil || b.Rate < limit {
+        \t\tt.Fatalf("expected a breakpoint once #{bottleneck.actor} falls behind, past %.4g/s, got %+v", limit, b)
+        \t}
+        """
+      else
+        ""
+      end
+
+    """
+
+    func Test#{type_name}RampsSendRate(t *testing.T) {
+    \tclock := NewVirtualClock()
+    \tsys := NewSystem(1, clock)
+    \th := simtest.NewHarness(t, sys, clock)
+    \tramp := func() RampReport {
+    \t\tfor _, r := range sys.Report().Ramps {
+    \t\t\tif r.Actor == "#{name}" {
+    \t\t\t\treturn r
+    \t\t\t}
+    \t\t}
+    \t\tt.Fatal("expected a report on the ramp of #{name}")
+    \t\treturn RampReport{}
+    \t}
+    \t
+    \th.Advance(#{half} * time.Millisecond)
+    \thalf := sys.#{field}.SendCount()
+    \th.Advance(#{rest} * time.Millisecond)
+    #{climb}\tif rate := ramp().Rate; rate != #{ramp.to} {
+    \t\tt.Fatalf("expected #{name} to hold at #{ramp.to}/s once its ramp is over, got %v/s", rate)
+    \t}
+    #{breakpoint}\tt.Log(ramp())
+    }
+    """
+  end
+
+  defp generate_ack_test(name, produced, horizon) do
+    field = GeneratorUtils.to_camel_case(name)
+
+    """
+
+    func TestAcksReturnAlongReversePath(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \tr := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \tt.Log("\\n" + r.String())
+    \t
+    \tacked, outstanding := sys.#{field}.AckCount(), sys.#{field}.Outstanding()
+    \tif acked == 0 {
+    \t\tt.Fatal("expected acks back at #{name}")
+    \t}
+    \tif acked+outstanding != #{produced} {
+    \t\tt.Fatalf("expected each of the #{produced} messages #{name} produced acked or outstanding, got %d acked and %d outstanding", acked, outstanding)
+    \t}
+    \tfor _, a := range r.Actors {
+    \t\tif a.Name == "#{name}" && (a.Received != 0 || a.P99 < a.P50) {
+    \t\t\tt.Fatalf("expected #{name}'s latencies to be its round trips, got %+v", a)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  defp generate_reply_test(name, replier, topology, horizon) do
+    field = GeneratorUtils.to_camel_case(name)
+
+    asked =
+      Enum.map_join(reply_roles(topology, name).asks, " || ", fn kind ->
+        "e.Message == \"#{GeneratorUtils.message_name(kind)}\""
+      end)
+
+    """
+
+    func TestRepliesReturnToRequester(t *testing.T) {
+    \trecorder := NewTraceRecorder()
+    \tsys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder))
+    \tsys.Start()
+    \trunUntil(t, sys, #{horizon} * time.Millisecond)
+    \t
+    \tasked := 0
+    \tfor _, e := range recorder.Events() {
+    \t\tif e.To == "#{name}" && (#{asked}) {
+    \t\t\tasked++
+    \t\t}
+    \t}
+    \treplies := sys.#{field}.ReplyTimeStats()
+    \tif replies.Count == 0 {
+    \t\tt.Fatal("expected replies from #{replier} back at #{name}")
+    \t}
+    \tif n := replies.Count + sys.#{field}.Awaiting(); n != asked {
+    \t\tt.Fatalf("expected each of the %d requests #{name} sent answered or awaited, got %d", asked, n)
+    \t}
+    \tif err := sys.CheckConservation(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
+    """
+  end
+
+  defp generate_size_test(name, bytes, horizon) do
+    field = GeneratorUtils.to_camel_case(name)
+    per_second = round(bytes * 1000 / horizon)
+
+    """
+
+    func TestBytesSentPerLink(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \tr := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \tt.Log("\\n" + r.String())
+    \t
+    \tbytes := sys.#{field}.BytesSent()
+    \tlinks := 0
+    \tfor _, link := range r.Links {
+    \t\tif link.From != "#{name}" {
+    \t\t\tcontinue
+    \t\t}
+    \t\tlinks++
+    \t\tif link.Bytes != #{bytes} || bytes[link.To] != link.Bytes {
+    \t\t\tt.Errorf("expected #{name} to send #{bytes} bytes to %s, got %d", link.To, link.Bytes)
+    \t\t}
+    \t\tif got := int64(link.PerSecond + 0.5); got != #{per_second} {
+    \t\t\tt.Errorf("expected #{per_second} bytes/s from #{name} to %s, got %d", link.To, got)
+    \t\t}
+    \t}
+    \tif links != len(bytes) || links == 0 {
+    \t\tt.Fatalf("expected a report row per link of #{name}, got %d for %d links", links, len(bytes))
+    \t}
+    \tif r.Bytes() < int64(links) * #{bytes} {
+    \t\tt.Fatalf("expected at least %d bytes moved, got %d", int64(links) * #{bytes}, r.Bytes())
+    \t}
+    }
+    """
+  end
+
+  # Runs to each time a weight changes and past the last change as long
+  # again, checking how many messages went to each target by then against
+  # the same smooth weighted round-robin
+  defp generate_routing_test(name, definition, targets, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    schedules = Map.new(weight_schedule(definition))
+
+    changes =
+      for {_target, steps} <- schedules, {at, _w} <- steps, at > 0, uniq: true, do: at
+
+    checkpoints =
+      case Enum.sort(changes) do
+        [] -> [horizon]
+        [last] -> [last, 2 * last]
+        sorted -> sorted ++ [2 * List.last(sorted) - Enum.at(sorted, -2)]
+      end
+
+    steps =
+      definition
+      |> expected_routes(targets, schedules, checkpoints)
+      |> Enum.map_join(fn {checkpoint, counts} ->
+        want =
+          targets
+          |> Enum.uniq()
+          |> Enum.map_join(", ", &"\"#{&1}\": #{Map.get(counts, &1, 0)}")
+
+        "\t\t{#{checkpoint} * time.Millisecond, map[string]int{#{want}}},\n"
+      end)
+
+    """
+
+    func Test#{type_name}ShiftsRoutingWeights(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \tfor _, step := range []struct {
+    \t\tat time.Duration
+    \t\twant map[string]int
+    \t}{
+    #{steps}\t} {
+    \t\trunUntil(t, sys, step.at)
+    \t\tgot := sys.#{field}.RoutedCounts()
+    \t\tfor target, n := range step.want {
+    \t\t\tif got[target] != n {
+    \t\t\t\tt.Errorf("by %v: expected #{name} to have routed %d messages to %s, got %d", step.at, n, target, got[target])
+    \t\t\t}
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # The weight of each edge of a routing actor whose weights never change,
+  # as the weighted targets give them
+  defp static_weights(definition) do
+    schedules = weight_schedule(definition)
+
+    if Enum.all?(schedules, &match?({_target, [{0, _weight}]}, &1)),
+      do: Map.new(schedules, fn {target, [{0, weight}]} -> {target, weight} end)
+  end
+
+  # Runs until the actor has routed 6000 messages by its static weights,
+  # each target getting its share of them give or take one
+  defp generate_weight_split_test(name, definition, targets) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    weights = Enum.map(targets, &{&1, Map.get(static_weights(definition), &1, 1)})
+    total = weights |> Enum.map(&elem(&1, 1)) |> Enum.sum()
+
+    per_tick =
+      case definition.send_pattern do
+        {:burst, count, _interval_ms, _message} -> count
+        _pattern -> 1
+      end
+
+    interval = Definition.interval_for_pattern(definition.send_pattern)
+    ticks = div(6000 + per_tick - 1, per_tick)
+    until = Definition.first_send_delay(definition) + (ticks - 1) * interval
+    requests = originated_count(definition, until)
+
+    want =
+      Enum.map_join(weights, ", ", fn {target, weight} ->
+        "\"#{target}\": #{if total == 0, do: 0, else: div(requests * weight, total)}"
+      end)
+
+    shares = Enum.map_join(weights, ":", &elem(&1, 1))
+
+    """
+
+    func Test#{type_name}SplitsByWeight(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \trunUntil(t, sys, #{until} * time.Millisecond)
+    \t// #{requests} messages, shared #{shares}
+    \tgot := sys.#{field}.RoutedCounts()
+    \tfor target, want := range map[string]int{#{want}} {
+    \t\tif n := got[target]; n < want-1 || n > want+1 {
+    \t\t\tt.Errorf("expected #{name} to route %d of its #{requests} messages to %s, give or take one, got %d", want, target, n)
+    \t\t}
+    \t}
+    }
+    """
+  end
+
+  # Every target gets as many of the messages as the next, give or take the
+  # one the last turn stopped at
+  defp generate_round_robin_test(name, targets, horizon) do
+    type_name = GeneratorUtils.to_pascal_case(name)
+    field = GeneratorUtils.to_camel_case(name)
+    names = Enum.map_join(targets, ", ", &~s("#{&1}"))
+
+    """
+
+    func Test#{type_name}RoutesInTurn(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.Start()
+    \trunUntil(t, sys, #{horizon} * time.Millisecond)
+    \tcounts := sys.#{field}.RoutedCounts()
+    \ttotal, least, most := 0, -1, 0
+    \tfor _, target := range []string{#{names}} {
+    \t\tn := counts[target]
+    \t\ttotal += n
+    \t\tif least < 0 || n < least {
+    \t\t\tleast = n
+    \t\t}
+    \t\tif n > most {
+    \t\t\tmost = n
+    \t\t}
+    \t}
+    \tif total == 0 || most-least > 1 {
+    \t\tt.Fatalf("expected #{name} to spread its messages evenly over its targets, got %v", counts)
+    \t}
+    }
+    """
+  end
+
+  # The messages routed to each target by each checkpoint, picking as the
+  # generated route does from the sends due by then
+  defp expected_routes(definition, targets, schedules, checkpoints) do
+    interval = Definition.interval_for_pattern(definition.send_pattern)
+
+    per_tick =
+      case definition.send_pattern do
+        {:burst, count, _interval, _message} -> count
+        _pattern -> 1
+      end
+
+    plan = %{interval: interval, per_tick: per_tick, targets: targets, schedules: schedules}
+    first = Definition.first_send_delay(definition)
+
+    {routes, _acc} =
+      Enum.map_reduce(checkpoints, {first, %{}, %{}}, fn checkpoint, acc ->
+        {_at, _credit, counts} = acc = route_until(acc, checkpoint, plan)
+        {{checkpoint, counts}, acc}
+      end)
+
+    routes
+  end
+
+  defp route_until({at, _credit, _counts} = acc, checkpoint, _plan) when at > checkpoint, do: acc
+
+  defp route_until({at, credit, counts}, checkpoint, plan) do
+    %{per_tick: per_tick, targets: targets, schedules: schedules} = plan
+
+    weights =
+      Map.new(targets, fn target ->
+        weight =
+          schedules
+          |> Map.get(target, [{0, 1}])
+          |> Enum.take_while(fn {step_at, _w} -> step_at <= at end)
+          |> List.last()
+          |> elem(1)
+
+        {target, weight}
+      end)
+
+    {credit, counts} =
+      Enum.reduce(1..per_tick, {credit, counts}, fn _i, acc ->
+        route_pick(acc, targets, weights)
+      end)
+
+    route_until({at + plan.interval, credit, counts}, checkpoint, plan)
+  end
+
+  defp route_pick({credit, counts}, targets, weights) do
+    {credit, best, total} =
+      Enum.reduce(targets, {credit, nil, 0}, fn target, {credit, best, total} ->
+        case Map.fetch!(weights, target) do
+          0 ->
+            {Map.put(credit, target, 0), best, total}
+
+          w ->
+            credit = Map.update(credit, target, w, &(&1 + w))
+            best = if best == nil or credit[target] > credit[best], do: target, else: best
+            {credit, best, total + w}
+        end
+      end)
+
+    if best == nil,
+      do: {credit, counts},
+      else: {Map.update!(credit, best, &(&1 - total)), Map.update(counts, best, 1, &(&1 + 1))}
+  end
+
+  # With the ramp the only source, an actor whose fair queue serves a
+  # message each service time falls behind once the paths the ramp's
+  # messages take to it carry more than that. The test then holds the
+  # ramp's target rate until the slowest of them queues up to the
+  # breakpoint, which can't come before the ramp's rate outruns it.
+  defp ramp_bottleneck(name, ramp, definitions, topology) do
+    sources =
+      for {source, definition} <- definitions,
+          definition.send_pattern != nil or definition.schedule_file != nil,
+          do: source
+
+    slow =
+      for {actor, definition} <- definitions,
+          definition.fair_queue != nil and (definition.service_time || 0) > 0 do
+        paths =
+          if parallelism(definition) == nil and conflation(definition) == nil,
+            do: ramp_paths(name, actor, definitions, topology, [name])
+
+        {actor, definition.service_time, paths}
+      end
+
+    with [^name] <- sources,
+         false <- Enum.any?(slow, fn {_actor, _ms, paths} -> paths == nil end),
+         [_ | _] = loads <- Enum.filter(slow, fn {_actor, _ms, paths} -> paths > 0 end),
+         {actor, ms, paths} = Enum.max_by(loads, fn {_actor, ms, paths} -> ms * paths end),
+         true <- ramp.to * ms * paths > 1000 and ramp.breakpoint > 2 * paths do
+      growth = ramp.to * paths - 1000 / ms
+      hold = ceil(1000 * (ramp.breakpoint + paths) / growth) + ceil(1000 / ramp.to)
+      %{actor: actor, load: ms * paths, hold: hold}
+    else
+      _ -> nil
+    end
+  end
+
+  # Paths the ramp's messages take from an actor to another, each carrying
+  # every message, or nil when one may lose, delay, repeat or hold them
+  defp ramp_paths(from, to, definitions, topology, visiting) do
+    sender = Map.fetch!(definitions, from)
+
+    Enum.reduce_while(Map.fetch!(topology.edges, from), 0, fn next, acc ->
+      paths =
+        cond do
+          not immediate?(sender) or at_least_once?(sender) or lossy_edge?(sender, next) or
+              delayed_edge?(sender, next) ->
+            nil
+
+          next == to ->
+            1
+
+          not Map.has_key?(definitions, next) ->
+            0
+
+          next in visiting ->
+            nil
+
+          true ->
+            ramp_paths(next, to, definitions, topology, [next | visiting])
+        end
+
+      if paths == nil, do: {:halt, nil}, else: {:cont, acc + paths}
+    end)
+  end
+
+  # Runs long enough for about a hundred traces; needs a repeating source
+  defp generate_trace_test(simulated, topology, trace_sample) do
+    sources =
+      Enum.filter(simulated, fn {_name, definition} -> periodic?(definition.send_pattern) end)
+
+    per_second =
+      sources
+      |> Enum.map(fn {_name, definition} -> originated_count(definition, 1000) end)
+      |> Enum.sum()
+
+    case sources do
+      [{name, _definition} | _] when per_second > 0 ->
+        horizon = ceil(100 / trace_sample / per_second) * 1000
+
+        # With targets, a trace must reach more than the handler of its source
+        propagation =
+          if Map.fetch!(topology.targets, name) != [] do
+            """
+            \t
+            \truns := 0
+            \tfor _, n := range seen {
+            \t\truns += n
+            \t}
+            \tif runs <= len(seen) {
+            \t\tt.Fatal("expected traces to follow messages to their targets")
+            \t}
+            """
+          else
+            ""
+          end
+
+        """
+
+        func TestTraceSampling(t *testing.T) {
+        \trun := func() (int64, uint64, map[uint64]int) {
+        \t\tseen := map[uint64]int{}
+        \t\tsys := NewSystem(1, NewVirtualClock())
+        \t\tsys.SetTraceSample(#{trace_sample})
+        \t\tsys.Use(MiddlewareFunc(func(ctx HandlerContext, msg string, next func()) {
+        \t\t\tif ctx.Trace != 0 {
+        \t\t\t\tseen[ctx.Trace]++
+        \t\t\t}
+        \t\t\tnext()
+        \t\t}))
+        \t\tsys.Start()
+        \t\tsys.Advance(#{horizon} * time.Millisecond)
+        \t\treturn sys.ledger.produced.Load(), sys.traces.Load(), seen
+        \t}
+        \t
+        \tproduced, traces, seen := run()
+        \tif _, again, _ := run(); again != traces {
+        \t\tt.Fatalf("expected the same seed to sample the same messages, got %d and %d traces", traces, again)
+        \t}
+        \twant := float64(produced) * #{trace_sample}
+        \tif diff := float64(traces) - want; diff < -0.3*want || diff > 0.3*want {
+        \t\tt.Fatalf("expected about %.0f of %d messages traced, got %d", want, produced, traces)
+        \t}
+        \tif len(seen) != int(traces) {
+        \t\tt.Fatalf("expected handlers to see all %d traces, saw %d", traces, len(seen))
+        \t}
+        #{propagation}}
+        """
+
+      _ ->
+        ""
+    end
+  end
+
+  defp generate_metrics_test(name) do
+    """
+
+    func TestMetricsPublished(t *testing.T) {
+    \tsys := NewSystem(1, NewVirtualClock())
+    \tsys.PublishMetrics()
+    \t
+    \tv := metrics.Get("#{name}")
+    \tif v == nil || !strings.Contains(v.String(), "sendCount") {
+    \t\tt.Fatalf("expected #{name} counters in expvar, got %v", v)
+    \t}
+    }
+    """
+  end
+
+  # The recorder and the report count the same arrivals and handled
+  # messages, and the Prometheus sink exposes them as text.
+  defp generate_trace_sink_test(simulated, topology, horizon) do
+    wired =
+      Enum.map_join(simulated, fn {name, _definition} ->
+        field = GeneratorUtils.to_camel_case(name)
+
+        targets =
+          if Map.fetch!(topology.targets, name) == [] do
+            ""
+          else
+            """
+            \tfor _, to := range sys.#{field}.targets {
+            \t\twired["#{name} -> "+names[to]] = true
+            \t}
+            """
+          end
+
+        if Map.fetch!(topology.fallbacks, name) do
+          targets <> "\twired[\"#{name} -> \"+names[sys.#{field}.fallback]] = true\n"
+        else
+          targets
+        end
+      end)
+
+    # Replies go back along the edges their requests came in on
+    replied =
+      topology.requests
+      |> Enum.map(fn {from, to, _kind, _reply} -> {to, from} end)
+      |> Enum.uniq()
+      |> Enum.map_join(fn {to, from} -> "\twired[\"#{to} -> #{from}\"] = true\n" end)
+
+    # A burst shows as its messages sharing a time, so the run takes in
+    # three of them
+    bursts =
+      for {name, %{send_pattern: {:burst, count, interval, kind}} = definition} <- simulated,
+          definition.ramp == nil and definition.schedule_file == nil,
+          do: {name, count, interval, kind, Definition.first_send_delay(definition)}
+
+    until =
+      Enum.reduce(bursts, horizon, fn {_name, _count, interval, _kind, first}, until ->
+        max(until, first + 2 * interval)
+      end)
+
+    per_burst =
+      Enum.map_join(bursts, fn {name, count, interval, kind, first} ->
+        var = GeneratorUtils.to_camel_case(name) <> "Bursts"
+        kind = GeneratorUtils.message_name(kind)
+        ticks = div(until - first, interval) + 1
+
+        """
+        \t#{var} := map[time.Duration]int{}
+        \tfor _, e := range events {
+        \t\tif e.From == "" && e.To == "#{name}" && e.Message == "#{kind}" {
+        \t\t\t#{var}[e.Time]++
+        \t\t}
+        \t}
+        \tif len(#{var}) != #{ticks} {
+        \t\tt.Fatalf("expected #{name} to burst #{ticks} times, got %v", #{var})
+        \t}
+        \tfor at, n := range #{var} {
+        \t\tif n != #{count} {
+        \t\t\tt.Fatalf("expected #{count} #{kind} messages from #{name} at %v, got %d", at, n)
+        \t\t}
+        \t}
+        """
+      end)
+
+    # Only a system its own actors feed has anything to trace in a test
+    traced =
+      if Enum.any?(simulated, &(originated_count(elem(&1, 1), until) > 0)) do
         """
-        \ta.callbacks = &Default#{type_name}Callbacks{}
+        \tif len(events) == 0 {
+        \t\tt.Fatal("expected a trace of the messages the sources sent")
+        \t}
         """
       else
         ""
       end
 
-    timer_setup = generate_timer_setup(definition)
-    message_handlers = generate_message_handlers(name, definition, enable_callbacks)
-
-    # Determine which imports are needed
-    needs_time = definition.send_pattern != nil
+    """
 
-    imports = ["\"github.com/Arceliar/phony\""]
-    imports = if needs_time, do: ["\"time\"" | imports], else: imports
-    import_list = Enum.map_join(Enum.reverse(imports), "\n", &"\t#{&1}")
+    func TestTraceSinkRecordsDeliveries(t *testing.T) {
+    \trecorder := NewTraceRecorder()
+    \tvar lines strings.Builder
+    \tjsonl := NewJSONLSink(&lines)
+    \tsys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder), WithTraceSink(jsonl))
+    \tsys.Start()
+    \trunUntil(t, sys, #{until} * time.Millisecond)
+    \t
+    \tnames := map[any]string{}
+    \tfor name, a := range sys.actors {
+    \t\tnames[a] = name
+    \t}
+    \twired := map[string]bool{}
+    #{wired}#{replied}\tevents := recorder.Events()
+    \tvar last time.Duration
+    \tfor _, e := range events {
+    \t\tif e.Time < last {
+    \t\t\tt.Fatalf("expected events in time order, got %v after %v", e.Time, last)
+    \t\t}
+    \t\tlast = e.Time
+    \t\tswitch edge := e.From + " -> " + e.To; {
+    \t\tcase e.From == "" && e.ID.Source != e.To:
+    \t\t\tt.Fatalf("recorded %s %s at %s without the actor that sent it", e.Message, e.ID, e.To)
+    \t\tcase e.From != "" && !wired[edge]:
+    \t\t\tt.Fatalf("recorded %s %s along %s, which NewSystem doesn't wire", e.Message, e.ID, edge)
+    \t\t}
+    \t}
+    #{traced}#{per_burst}\t
+    \tif err := jsonl.Err(); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tdecoder := json.NewDecoder(strings.NewReader(lines.String()))
+    \tn := 0
+    \tfor ; decoder.More(); n++ {
+    \t\tvar e TraceEvent
+    \t\tif err := decoder.Decode(&e); err != nil {
+    \t\t\tt.Fatal(err)
+    \t\t}
+    \t\tif n >= len(events) || e != events[n] {
+    \t\t\tt.Fatalf("expected line %d to read back as the event recorded, got %+v", n+1, e)
+    \t\t}
+    \t}
+    \tif n != len(events) {
+    \t\tt.Fatalf("expected a line per event recorded, %d, got %d", len(events), n)
+    \t}
+    }
 
+    func TestRenderMermaidListsTracedActors(t *testing.T) {
+    \trecorder := NewTraceRecorder()
+    \tsys := NewSystem(1, NewVirtualClock(), WithTraceSink(recorder))
+    \tsys.Start()
+    \trunUntil(t, sys, #{until} * time.Millisecond)
+    \tevents := recorder.Events()
+    #{traced}\t
+    \tvar b strings.Builder
+    \tif err := RenderMermaid(events, &b, MermaidWindow{Max: 20}); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \tdiagram := b.String()
+    \tif !strings.HasPrefix(diagram, "sequenceDiagram\\n") {
+    \t\tt.Fatalf("expected a sequence diagram, got:\\n%s", diagram)
+    \t}
+    \ttraced := map[string]bool{}
+    \tsent := 0
+    \tfor _, e := range events {
+    \t\ttraced[e.To] = true
+    \t\tif e.From != "" {
+    \t\t\ttraced[e.From] = true
+    \t\t\tsent++
+    \t\t}
+    \t}
+    \tlisted := map[string]bool{}
+    \tarrows := 0
+    \tfor _, line := range strings.Split(diagram, "\\n") {
+    \t\tif name, ok := strings.CutPrefix(line, "    participant "); ok {
+    \t\t\tlisted[name] = true
+    \t\t}
+    \t\tif strings.Contains(line, "->>") {
+    \t\t\tarrows++
+    \t\t}
+    \t}
+    \tif fmt.Sprint(listed) != fmt.Sprint(traced) {
+    \t\tt.Fatalf("expected a participant per actor in the trace, %v, got %v", traced, listed)
+    \t}
+    \tif arrows != min(sent, 20) {
+    \t\tt.Fatalf("expected %d arrows of the %d messages sent, got %d", min(sent, 20), sent, arrows)
+    \t}
+    \tif sent > 20 && !strings.Contains(diagram, fmt.Sprintf(": %d more messages left out", sent-20)) {
+    \t\tt.Fatalf("expected a note on the %d arrows the cap left out, got:\\n%s", sent-20, diagram)
+    \t}
+    }
     """
-    // Generated from ActorSimulation DSL
-    // Actor: #{name}
-    // DO NOT EDIT - This file is auto-generated
-
-    package main
+  end
 
-    import (
-    #{import_list}
-    )
+  defp generate_metric_sink_test(horizon) do
+    """
 
-    #{callback_interface}
-    type #{type_name} struct {
-    \tphony.Inbox
-    \ttargets []*#{type_name}
-    #{callback_field}\tsendCount int
+    func TestMetricSinkRecordsActorMetrics(t *testing.T) {
+    \trecorder := NewMetricRecorder()
+    \tsys := NewSystem(1, NewVirtualClock(), WithMetricSink(recorder))
+    \tsys.Start()
+    \treport := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \t
+    \tfor _, a := range report.Actors {
+    \t\tlabels := map[string]string{"actor": a.Name}
+    \t\tif n := recorder.CounterValue("actor_messages_received_total", labels); int(n) != a.Received {
+    \t\t\tt.Errorf("%s: recorded %v messages received, report has %d", a.Name, n, a.Received)
+    \t\t}
+    \t\tif n := len(recorder.Observations("actor_message_latency_seconds", labels)); n != a.Received {
+    \t\t\tt.Errorf("%s: recorded %d latencies, report has %d messages received", a.Name, n, a.Received)
+    \t\t}
+    \t\tif n := len(recorder.Observations("actor_service_time_seconds", labels)); n != a.Service.Count {
+    \t\t\tt.Errorf("%s: recorded %d service times, report has %d", a.Name, n, a.Service.Count)
+    \t\t}
+    \t}
     }
 
-    func (a *#{type_name}) Actor() *phony.Inbox {
-    \treturn &a.Inbox
+    func TestPrometheusSinkWritesText(t *testing.T) {
+    \tprometheus := NewPrometheusSink()
+    \tsys := NewSystem(1, NewVirtualClock(), WithMetricSink(prometheus))
+    \tsys.Start()
+    \treport := runUntil(t, sys, #{horizon} * time.Millisecond)
+    \t
+    \tvar out strings.Builder
+    \tif err := prometheus.WriteText(&out); err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    \t// A system fed only from outside, e.g. by Kafka sources, receives nothing
+    \treceived := 0
+    \tfor _, a := range report.Actors {
+    \t\treceived += a.Received
+    \t}
+    \tif received > 0 && !strings.Contains(out.String(), "# TYPE actor_messages_received_total counter") {
+    \t\tt.Fatalf("expected the received counter type, got:\\n%s", out.String())
+    \t}
+    \tfor _, a := range report.Actors {
+    \t\tseries := fmt.Sprintf("actor_messages_received_total{actor=%q} %d\\n", a.Name, a.Received)
+    \t\tif a.Received > 0 && !strings.Contains(out.String(), series) {
+    \t\t\tt.Errorf("expected %q, got:\\n%s", series, out.String())
+    \t\t}
+    \t}
     }
-
-    func (a *#{type_name}) Start() {
-    #{callback_init}#{timer_setup}}
-
-    #{message_handlers}
     """
   end
 
-  defp generate_callback_interface(name, definition) do
+  # Loss is drawn from the seeded RNG, but whatever the seed every message
+  # must end up either sent or lost.
+  defp generate_sweep_test(name, attempts, horizon) do
     type_name = GeneratorUtils.to_pascal_case(name)
-    messages = GeneratorUtils.extract_messages(definition.send_pattern)
-
-    methods =
-      Enum.map_join(messages, "\n", fn msg ->
-        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
-        "\tOn#{msg_name}()"
-      end)
+    field = GeneratorUtils.to_camel_case(name)
 
     """
-    // #{type_name}Callbacks defines the callback interface
-    // Implement this interface to customize actor behavior
-    type #{type_name}Callbacks interface {
-    #{methods}
-    }
 
+    func Test#{type_name}LossAcrossSeeds(t *testing.T) {
+    \terr := SweepSeeds(100, func(sys *System) error {
+    \t\tsys.Advance(#{horizon} * time.Millisecond)
+    \t\tif n := sys.#{field}.SendCount() + sys.#{field}.LostCount(); n != #{attempts} {
+    \t\t\treturn fmt.Errorf("#{name} sent or lost %d messages, want #{attempts}", n)
+    \t\t}
+    \t\treturn nil
+    \t})
+    \tif err != nil {
+    \t\tt.Fatal(err)
+    \t}
+    }
     """
   end
 
-  defp generate_callbacks_file(name, definition) do
-    type_name = GeneratorUtils.to_pascal_case(name)
-    messages = GeneratorUtils.extract_messages(definition.send_pattern)
+  # Virtual time each generated test advances: one second, or longer when
+  # an actor's first message would not fall due before then.
+  defp test_horizon(simulated) do
+    simulated
+    |> Enum.flat_map(fn {_name, definition} ->
+      [Definition.interval_for_pattern(definition.send_pattern), definition.start_delay]
+    end)
+    |> Enum.reject(&is_nil/1)
+    |> Enum.max(fn -> 0 end)
+    |> max(1000)
+  end
 
-    impl_methods =
-      Enum.map_join(messages, "\n\n", fn msg ->
-        msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
-        # If actor has send_pattern, it's the sender (publisher)
-        action = if definition.send_pattern, do: "Sending", else: "Received"
+  # Go truncates each entry's time to whole nanoseconds
+  defp originated_count(%{schedule_file: path} = definition, horizon) when path != nil do
+    %{entries: entries} = schedule(definition)
+    Enum.count(entries, fn {at_ms, _message} ->
+      trunc(at_ms * 1_000_000) <= horizon * 1_000_000
+    end)
+  end
 
-        """
-        func (c *Default#{type_name}Callbacks) On#{msg_name}() {
-        \t// TODO: Implement custom behavior for #{msg}
-        \tfmt.Printf("#{type_name}: #{action} #{msg} message\\n")
-        }
-        """
-      end)
+  defp originated_count(%{send_pattern: nil}, _horizon), do: 0
 
-    imports_section =
-      if length(messages) > 0 do
-        "import (\n\t\"fmt\"\n)\n"
-      else
-        ""
-      end
+  defp originated_count(%{send_pattern: {:self_message, _delay, _message}} = definition, horizon),
+    do: if(Definition.first_send_delay(definition) <= horizon, do: 1, else: 0)
 
-    """
-    // Generated from ActorSimulation DSL
-    // Default callback implementation for: #{name}
-    // CUSTOMIZE THIS FILE - This is where you add your custom behavior!
+  # Steps through the ramp in float64 nanoseconds, as Go does
+  defp originated_count(%{ramp: ramp} = definition, horizon) when ramp != nil do
+    ramp_ticks(ramp(definition), Definition.first_send_delay(definition) * 1_000_000, horizon, 0)
+  end
 
-    package main
+  defp originated_count(%{send_pattern: pattern} = definition, horizon) do
+    first = Definition.first_send_delay(definition)
+    interval = Definition.interval_for_pattern(pattern)
+    ticks = if first <= horizon, do: div(horizon - first, interval) + 1, else: 0
 
-    #{imports_section}
+    case pattern do
+      {:burst, count, _interval_ms, _message} -> ticks * count
+      _pattern -> ticks
+    end
+  end
 
-    // Default#{type_name}Callbacks provides default implementations
-    // CUSTOMIZE THIS to add your own behavior!
-    type Default#{type_name}Callbacks struct{}
+  defp ramp_ticks(_ramp, at, horizon, count) when at > horizon * 1_000_000, do: count
 
-    #{impl_methods}
-    """
-  end
+  defp ramp_ticks(ramp, at, horizon, count),
+    do: ramp_ticks(ramp, at + trunc(1.0e9 / ramp_rate(ramp, at)), horizon, count + 1)
 
-  defp generate_timer_setup(definition) do
-    case definition.send_pattern do
-      nil ->
-        ""
+  defp ramp_rate(%{to: to, over: over}, elapsed) when elapsed >= over * 1_000_000, do: to * 1.0
 
-      {:periodic, interval_ms, message} ->
-        msg_name = GeneratorUtils.message_name(message) |> GeneratorUtils.to_pascal_case()
+  defp ramp_rate(%{curve: :exponential, from: from, to: to, over: over}, elapsed),
+    do: from * :math.pow(to / from, elapsed / (over * 1_000_000))
 
-        """
-        \tgo func() {
-        \t\tticker := time.NewTicker(#{interval_ms} * time.Millisecond)
-        \t\tdefer ticker.Stop()
-        \t\tfor range ticker.C {
-        \t\t\ta.Act(nil, func() { a.#{msg_name}() })
-        \t\t}
-        \t}()
-        """
+  defp ramp_rate(%{from: from, to: to, over: over}, elapsed),
+    do: from + (to - from) * 1.0 * elapsed / (over * 1_000_000)
 
-      {:rate, per_second, message} ->
-        interval_ms = div(1000, per_second)
-        msg_name = GeneratorUtils.message_name(message) |> GeneratorUtils.to_pascal_case()
+  defp expected_sent(_name, _definition, [], _definitions, _topology, _horizon), do: 0
 
-        """
-        \tgo func() {
-        \t\tticker := time.NewTicker(#{interval_ms} * time.Millisecond)
-        \t\tdefer ticker.Stop()
-        \t\tfor range ticker.C {
-        \t\t\ta.Act(nil, func() { a.#{msg_name}() })
-        \t\t}
-        \t}()
-        """
+  defp expected_sent(name, definition, targets, definitions, topology, horizon) do
+    handled = expected_handled(name, definitions, topology, horizon, [])
 
-      {:burst, count, interval_ms, message} ->
-        msg_name = GeneratorUtils.message_name(message) |> GeneratorUtils.to_pascal_case()
+    if handled && immediate?(definition) &&
+         not Enum.any?(targets, &lossy_edge?(definition, &1)),
+       do: handled * length(targets)
+  end
 
-        """
-        \tgo func() {
-        \t\tticker := time.NewTicker(#{interval_ms} * time.Millisecond)
-        \t\tdefer ticker.Stop()
-        \t\tfor range ticker.C {
-        \t\t\tfor i := 0; i < #{count}; i++ {
-        \t\t\t\ta.Act(nil, func() { a.#{msg_name}() })
-        \t\t\t}
-        \t\t}
-        \t}()
-        """
+  # Whether an actor forwards each message to every target the moment it
+  # arrives, rather than after a timeout, a turn in its fair queue, a match
+  # in its join or the messages its reorder buffer waits for, or to the one
+  # target its router or session affinity picks, and forwards copies of
+  # a message it already had, none of them filtered out
+  defp immediate?(definition),
+    do:
+      definition.timeout == nil and definition.fair_queue == nil and definition.join_by == nil and
+        routing(definition) == :broadcast and definition.affinity_by == nil and
+        definition.idempotent_by == nil and definition.reorder_by == nil and
+        definition.transforms == nil
 
-      {:self_message, delay_ms, message} ->
-        msg_name = GeneratorUtils.message_name(message) |> GeneratorUtils.to_pascal_case()
+  # Messages an actor handles within the horizon: the ones it originates plus
+  # everything forwarded to it. Unknown (nil) when a message can be lost,
+  # delayed, fall back or wait in a fair queue or join on the way, or the
+  # actor sits on a cycle or takes replies back from its requests.
+  defp expected_handled(name, definitions, topology, horizon, visiting) do
+    if name in visiting or reply_roles(topology, name).answers != [] do
+      nil
+    else
+      own = originated_count(Map.fetch!(definitions, name), horizon)
+      upstream = for {from, edges} <- topology.edges, name in edges, do: from
 
-        """
-        \t// One-shot delayed self-message
-        \tgo func() {
-        \t\ttime.Sleep(#{delay_ms} * time.Millisecond)
-        \t\ta.Act(nil, func() { a.#{msg_name}() })
-        \t}()
-        """
+      Enum.reduce_while(upstream, own, fn from, acc ->
+        sender = Map.fetch!(definitions, from)
+
+        forwarded =
+          if lossy_edge?(sender, name) or delayed_edge?(sender, name) or
+               not immediate?(sender),
+            do: nil,
+            else: expected_handled(from, definitions, topology, horizon, [name | visiting])
+
+        if forwarded == nil, do: {:halt, nil}, else: {:cont, acc + forwarded}
+      end)
     end
   end
 
-  defp generate_message_handlers(name, definition, enable_callbacks) do
-    type_name = GeneratorUtils.to_pascal_case(name)
-    messages = GeneratorUtils.extract_messages(definition.send_pattern)
-
-    Enum.map_join(messages, "\n\n", fn msg ->
-      msg_name = GeneratorUtils.message_name(msg) |> GeneratorUtils.to_pascal_case()
+  # The actors from the one source whose messages reach name, in order, and
+  # the sum of the constant delays on the way, when each actor on the way
+  # forwards everything at once to its targets, without loss. Unknown (nil)
+  # otherwise.
+  defp delay_path(name, definitions, topology, path) do
+    with [from] <- for({from, edges} <- topology.edges, name in edges, do: from),
+         true <- from not in path,
+         1 <- Enum.count(Map.fetch!(topology.edges, from), &(&1 == name)),
+         sender = Map.fetch!(definitions, from),
+         true <- sender.loss == nil and immediate?(sender) and parallelism(sender) == nil,
+         {:ok, ms} <- constant_delay(edge_delay(sender, name)) do
+      cond do
+        sender.send_pattern == nil and sender.schedule_file == nil and not probe?(sender) ->
+          case delay_path(from, definitions, topology, [from | path]) do
+            {hops, total} -> {hops ++ [name], total + ms}
+            nil -> nil
+          end
 
-      callback_call =
-        if enable_callbacks do
-          """
-          \ta.callbacks.On#{msg_name}()
-          """
-        else
-          """
-          \t// Handle #{msg}
-          """
-        end
+        sender.send_pattern != nil and
+            not Enum.any?(topology.edges, fn {_from, edges} -> from in edges end) ->
+          {[from, name], ms}
 
-      """
-      func (a *#{type_name}) #{msg_name}() {
-      #{callback_call}\t// Send to targets
-      \tfor _, target := range a.targets {
-      \t\ttarget.Act(a, func() { target.#{msg_name}() })
-      \t}
-      \ta.sendCount++
-      }
-      """
-    end)
+        true ->
+          nil
+      end
+    else
+      _no_path -> nil
+    end
   end
 
-  defp generate_main(actors, project_name) do
-    simulated = GeneratorUtils.simulated_actors(actors)
-
-    spawn_code =
-      Enum.map_join(simulated, "\n", fn {name, _def} ->
-        snake_name = GeneratorUtils.to_snake_case(name)
-        type_name = GeneratorUtils.to_pascal_case(name)
-        "\t#{snake_name} := &#{type_name}{}\n\t#{snake_name}.Start()"
-      end)
+  defp constant_delay(nil), do: {:ok, 0}
+  defp constant_delay({:constant, ms}), do: {:ok, ms}
+  defp constant_delay(_delay), do: nil
 
+  # Generated actors keep unsynchronised state and count on each inbox
+  # running one message at a time, in order, with Block waiting its turn
+  defp generate_phony_test_file do
     """
     // Generated from ActorSimulation DSL
-    // Main entry point for #{project_name}
+    // Checks the Phony semantics the generated actors rely on
+    // DO NOT EDIT - This file is auto-generated
 
     package main
 
     import (
-    \t"fmt"
+    \t"github.com/Arceliar/phony"
+    \t"sync"
+    \t"sync/atomic"
+    \t"testing"
     )
 
-    func main() {
-    \tfmt.Println("Starting actor system...")
+    // inbox is a bare Phony actor recording what it runs
+    type inbox struct {
+    \tphony.Inbox
+    \trunning atomic.Int32
+    \tseen []int
+    }
+
+    func TestPhonyActSerializesPerInbox(t *testing.T) {
+    \tvar a inbox
+    \tvar overlaps atomic.Int32
+    \tvar wg sync.WaitGroup
+    \tfor i := 0; i < 8; i++ {
+    \t\twg.Add(1)
+    \t\tgo func() {
+    \t\t\tdefer wg.Done()
+    \t\t\tfor j := 0; j < 1000; j++ {
+    \t\t\t\tj := j
+    \t\t\t\ta.Act(nil, func() {
+    \t\t\t\t\tif a.running.Add(1) != 1 {
+    \t\t\t\t\t\toverlaps.Add(1)
+    \t\t\t\t\t}
+    \t\t\t\t\ta.seen = append(a.seen, j)
+    \t\t\t\t\ta.running.Add(-1)
+    \t\t\t\t})
+    \t\t\t}
+    \t\t}()
+    \t}
+    \twg.Wait()
     \t
-    \t// Spawn all actors
-    #{spawn_code}
+    \tvar n int
+    \tphony.Block(&a, func() { n = len(a.seen) })
+    \tif o := overlaps.Load(); o > 0 {
+    \t\tt.Fatalf("phony ran %d messages at once on one inbox; generated actors need Act to serialize per inbox", o)
+    \t}
+    \tif n != 8000 {
+    \t\tt.Fatalf("expected phony to run all 8000 messages, ran %d", n)
+    \t}
+    }
+
+    func TestPhonyActKeepsOrderFromOneSender(t *testing.T) {
+    \tvar sender, receiver inbox
+    \tphony.Block(&sender, func() {
+    \t\tfor i := 0; i < 1000; i++ {
+    \t\t\ti := i
+    \t\t\treceiver.Act(&sender, func() { receiver.seen = append(receiver.seen, i) })
+    \t\t}
+    \t})
     \t
-    \tfmt.Println("Actor system started. Press Ctrl+C to exit.")
+    \tphony.Block(&receiver, func() {
+    \t\tfor i, n := range receiver.seen {
+    \t\t\tif n != i {
+    \t\t\t\tt.Fatalf("phony delivered message %d of one sender as number %d; generated actors need per-sender order", n, i)
+    \t\t\t}
+    \t\t}
+    \t\tif len(receiver.seen) != 1000 {
+    \t\t\tt.Fatalf("expected phony to deliver all 1000 messages, delivered %d", len(receiver.seen))
+    \t\t}
+    \t})
+    }
+
+    func TestPhonyBlockWaitsForQueuedMessages(t *testing.T) {
+    \tvar a inbox
+    \tfor i := 0; i < 100; i++ {
+    \t\ti := i
+    \t\ta.Act(nil, func() { a.seen = append(a.seen, i) })
+    \t}
     \t
-    \t// Keep running
-    \tselect {}
+    \tran := false
+    \tvar n int
+    \tphony.Block(&a, func() {
+    \t\tran = true
+    \t\tn = len(a.seen)
+    \t})
+    \tif !ran {
+    \t\tt.Fatal("phony.Block returned before running its function; the virtual clock needs it to wait")
+    \t}
+    \tif n != 100 {
+    \t\tt.Fatalf("phony.Block ran after %d of the 100 messages queued before it; it must wait its turn", n)
+    \t}
     }
     """
   end
 
-  defp generate_test_file(actors) do
-    simulated = GeneratorUtils.simulated_actors(actors)
-
-    test_cases =
-      Enum.map_join(simulated, "\n\n", fn {name, _def} ->
-        type_name = GeneratorUtils.to_pascal_case(name)
+  defp generate_bench_file(simulated, topology, sources) do
+    wiring =
+      Enum.map_join(simulated, fn {name, _definition} ->
+        targets = Enum.map_join(Map.fetch!(topology.targets, name), ", ", &"\"#{&1}\"")
+        "\t\"#{name}\": {#{targets}},\n"
+      end)
 
-        """
-        func Test#{type_name}(t *testing.T) {
-        \tactor := &#{type_name}{}
-        \tactor.Start()
-        \t
-        \t// Wait a bit for actor to initialize
-        \ttime.Sleep(10 * time.Millisecond)
-        \t
-        \tif actor == nil {
-        \t\tt.Fatal("Actor should not be nil")
-        \t}
-        }
-        """
+    origins =
+      Enum.map_join(sources, fn {name, message, count} ->
+        "\t{\"#{name}\", \"#{GeneratorUtils.message_name(message)}\", #{count}},\n"
       end)
 
     """
     // Generated from ActorSimulation DSL
-    // Go tests for actors
+    // Benchmarks Phony against channel dispatch on the spec's topology
+    // DO NOT EDIT - This file is auto-generated
 
     package main
 
     import (
+    \t"github.com/Arceliar/phony"
+    \t"sync"
     \t"testing"
     \t"time"
     )
 
-    func TestActorSystem(t *testing.T) {
-    \t// Basic system test
-    \tif testing.Short() {
-    \t\tt.Skip("Skipping in short mode")
+    // ActorRuntime runs the spec's actors on one way of dispatching messages,
+    // each actor forwarding every message it handles to all of its targets,
+    // so the same benchmark measures every runtime on the same topology
+    type ActorRuntime interface {
+    \t// Send hands n copies of message to the named actor
+    \tSend(name, message string, n int)
+    \t// Wait blocks until every message sent or forwarded has been handled
+    \tWait()
+    \t// Handled returns the number of messages the actors handled, once Wait returns
+    \tHandled() int
+    \t// Stop shuts the actors down
+    \tStop()
+    }
+
+    // benchTopology lists the targets of every actor, as the spec wires them
+    var benchTopology = map[string][]string{
+    #{wiring}}
+
+    // benchSource originates count messages at a time, as one tick of its
+    // send pattern does
+    type benchSource struct {
+    \tname, message string
+    \tcount int
+    }
+
+    var benchSources = []benchSource{
+    #{origins}}
+
+    // benchBuffer is the capacity of every channel actor's inbox
+    const benchBuffer = 64
+
+    // benchRuntimes builds every ActorRuntime the benchmark compares
+    var benchRuntimes = []struct {
+    \tname string
+    \tstart func() ActorRuntime
+    }{
+    \t{"phony", func() ActorRuntime { return newPhonyRuntime(benchTopology) }},
+    \t{"channel", func() ActorRuntime { return newChannelRuntime(benchTopology, benchBuffer) }},
+    }
+
+    // phonyNode is an actor on a Phony inbox
+    type phonyNode struct {
+    \tphony.Inbox
+    \ttargets []*phonyNode
+    \thandled int
+    }
+
+    // phonyRuntime dispatches as the generated actors do: a message is a
+    // closure run on the receiver's inbox, sent with Act from the sender's
+    type phonyRuntime struct {
+    \tnodes map[string]*phonyNode
+    \tpending sync.WaitGroup
+    }
+
+    func newPhonyRuntime(topology map[string][]string) *phonyRuntime {
+    \tr := &phonyRuntime{nodes: map[string]*phonyNode{}}
+    \tfor name := range topology {
+    \t\tr.nodes[name] = &phonyNode{}
+    \t}
+    \tfor name, targets := range topology {
+    \t\tfor _, target := range targets {
+    \t\t\tr.nodes[name].targets = append(r.nodes[name].targets, r.nodes[target])
+    \t\t}
+    \t}
+    \treturn r
+    }
+
+    func (r *phonyRuntime) Send(name, message string, n int) {
+    \tnode := r.nodes[name]
+    \tr.pending.Add(n)
+    \tfor i := 0; i < n; i++ {
+    \t\tnode.Act(nil, func() { r.handle(node, message) })
+    \t}
+    }
+
+    // handle runs on node's inbox and forwards message to its targets
+    func (r *phonyRuntime) handle(node *phonyNode, message string) {
+    \tnode.handled++
+    \tr.pending.Add(len(node.targets))
+    \tfor _, target := range node.targets {
+    \t\ttarget := target
+    \t\ttarget.Act(node, func() { r.handle(target, message) })
+    \t}
+    \tr.pending.Done()
+    }
+
+    func (r *phonyRuntime) Wait() {
+    \tr.pending.Wait()
+    }
+
+    func (r *phonyRuntime) Handled() int {
+    \tn := 0
+    \tfor _, node := range r.nodes {
+    \t\tn += node.handled
+    \t}
+    \treturn n
+    }
+
+    // Stop has nothing to shut down: an idle Phony inbox holds no goroutine
+    func (r *phonyRuntime) Stop() {}
+
+    // channelNode is an actor on a goroutine of its own
+    type channelNode struct {
+    \tinbox chan string
+    \ttargets []*channelNode
+    \thandled int
+    }
+
+    // channelRuntime dispatches as hand-written Go actors often do: each
+    // actor selects over a buffered inbox channel and the runtime's done
+    // channel
+    // Unlike a Phony inbox, a full channel blocks its sender
+    type channelRuntime struct {
+    \tnodes map[string]*channelNode
+    \tpending sync.WaitGroup
+    \trunning sync.WaitGroup
+    \tdone chan struct{}
+    }
+
+    func newChannelRuntime(topology map[string][]string, buffer int) *channelRuntime {
+    \tr := &channelRuntime{nodes: map[string]*channelNode{}, done: make(chan struct{})}
+    \tfor name := range topology {
+    \t\tr.nodes[name] = &channelNode{inbox: make(chan string, buffer)}
+    \t}
+    \tfor name, targets := range topology {
+    \t\tfor _, target := range targets {
+    \t\t\tr.nodes[name].targets = append(r.nodes[name].targets, r.nodes[target])
+    \t\t}
+    \t}
+    \tr.running.Add(len(r.nodes))
+    \tfor _, node := range r.nodes {
+    \t\tgo r.run(node)
+    \t}
+    \treturn r
+    }
+
+    // run handles node's messages until the runtime stops
+    func (r *channelRuntime) run(node *channelNode) {
+    \tdefer r.running.Done()
+    \tfor {
+    \t\tselect {
+    \t\tcase message := <-node.inbox:
+    \t\t\tnode.handled++
+    \t\t\tr.pending.Add(len(node.targets))
+    \t\t\tfor _, target := range node.targets {
+    \t\t\t\ttarget.inbox <- message
+    \t\t\t}
+    \t\t\tr.pending.Done()
+    \t\tcase <-r.done:
+    \t\t\treturn
+    \t\t}
+    \t}
+    }
+
+    func (r *channelRuntime) Send(name, message string, n int) {
+    \tnode := r.nodes[name]
+    \tr.pending.Add(n)
+    \tfor i := 0; i < n; i++ {
+    \t\tnode.inbox <- message
+    \t}
+    }
+
+    func (r *channelRuntime) Wait() {
+    \tr.pending.Wait()
+    }
+
+    func (r *channelRuntime) Handled() int {
+    \tn := 0
+    \tfor _, node := range r.nodes {
+    \t\tn += node.handled
+    \t}
+    \treturn n
+    }
+
+    func (r *channelRuntime) Stop() {
+    \tclose(r.done)
+    \tr.running.Wait()
+    }
+
+    // sendAll hands every source its messages rounds times
+    func sendAll(r ActorRuntime, rounds int) {
+    \tfor i := 0; i < rounds; i++ {
+    \t\tfor _, source := range benchSources {
+    \t\t\tr.Send(source.name, source.message, source.count)
+    \t\t}
     \t}
     }
 
-    #{test_cases}
+    // BenchmarkDispatch sends every source's messages b.N times through each
+    // runtime and reports the messages handled per second, across all actors
+    // Compare the runtimes with go test -run ^$ -bench Dispatch -benchmem
+    func BenchmarkDispatch(b *testing.B) {
+    \tfor _, rt := range benchRuntimes {
+    \t\trt := rt
+    \t\tb.Run(rt.name, func(b *testing.B) {
+    \t\t\tr := rt.start()
+    \t\t\tdefer r.Stop()
+    \t\t\tb.ReportAllocs()
+    \t\t\tb.ResetTimer()
+    \t\t\tstart := time.Now()
+    \t\t\tsendAll(r, b.N)
+    \t\t\tr.Wait()
+    \t\t\tb.ReportMetric(float64(r.Handled())/time.Since(start).Seconds(), "msgs/s")
+    \t\t})
+    \t}
+    }
+
+    func TestRuntimesHandleAlike(t *testing.T) {
+    \thandled := map[string]int{}
+    \tfor _, rt := range benchRuntimes {
+    \t\tr := rt.start()
+    \t\tsendAll(r, 100)
+    \t\tr.Wait()
+    \t\tr.Stop()
+    \t\thandled[rt.name] = r.Handled()
+    \t}
+    \tif handled["phony"] == 0 || handled["phony"] != handled["channel"] {
+    \t\tt.Fatalf("expected every runtime to handle the same messages, got %v", handled)
+    \t}
+    }
     """
   end
 
-  defp generate_go_mod(project_name, go_version) do
+  defp generate_go_mod(module, go_version) do
     """
-    module #{project_name}
+    module #{module}
 
     go #{go_version}
 
@@ -433,7 +16117,9 @@ defmodule ActorSimulation.PhonyGenerator do
     """
   end
 
-  defp generate_ci_pipeline(project_name) do
+  defp generate_ci_pipeline(project_name, package) do
+    main_dir = main_dir(project_name, package)
+
     """
     name: CI
 
@@ -451,6 +16137,9 @@ defmodule ActorSimulation.PhonyGenerator do
           matrix:
             os: [ubuntu-latest, macos-latest, windows-latest]
             go-version: ['1.21', '1.22']
+            # The pinned Phony and its latest version, to catch changes in
+            # the semantics phony_test.go checks
+            phony: [pinned, latest]
 
         steps:
         - uses: actions/checkout@v3
@@ -464,6 +16153,10 @@ defmodule ActorSimulation.PhonyGenerator do
         - name: Download dependencies
           run: go mod download
 
+        - name: Select Phony version
+          if: matrix.phony != 'pinned'
+          run: go get github.com/Arceliar/phony@${{ matrix.phony }}
+
         - name: Build
           run: |
             OS_NAME=$(uname -s | tr '[:upper:]' '[:lower:]')
@@ -473,10 +16166,10 @@ defmodule ActorSimulation.PhonyGenerator do
               PROJECT_NAME="#{project_name}"
             fi
             BINARY="${PROJECT_NAME}.phony.${OS_NAME}"
-            go build -o "$BINARY" .
+            go build -o "$BINARY" #{main_dir}
 
         - name: Test
-          run: go test -v ./...
+          run: go test -race -v ./...
 
         - name: Run Demo Application
           shell: bash
@@ -493,7 +16186,32 @@ defmodule ActorSimulation.PhonyGenerator do
     """
   end
 
-  defp generate_readme(project_name) do
+  defp main_dir(_project_name, "main"), do: "."
+  defp main_dir(project_name, _package), do: "./cmd/#{project_name}"
+
+  defp generate_readme(project_name, package, module) do
+    main_dir = main_dir(project_name, package)
+
+    library =
+      if package != "main" do
+        """
+
+        ## Using as a Library
+
+        The actors live in package `#{package}` of module `#{module}`, so other
+        programs can build and run the system themselves:
+
+        ```go
+        import #{import_spec(package, module)}
+
+        sys := #{package}.NewSystem(42, #{package}.NewRealClock())
+        sys.Start()
+        ```
+        """
+      else
+        ""
+      end
+
     """
     # #{project_name}
 
@@ -527,10 +16245,28 @@ defmodule ActorSimulation.PhonyGenerator do
     go mod download
 
     # Build
-    go build -o #{project_name} .
+    go build -o #{project_name} #{main_dir}
 
     # Run
     ./#{project_name}
+
+    # Run ten times as fast as real time
+    SPEED=10 ./#{project_name}
+
+    # Log one in every 100 callback lines
+    LOG_EVERY=100 ./#{project_name}
+
+    # Serve actor counters at /debug/vars and metrics at /metrics
+    METRICS=1 ./#{project_name}
+
+    # Print how far the run got every 10 seconds
+    PROGRESS=10s ./#{project_name}
+
+    # Save the report as gob on Ctrl+C
+    REPORT=report.gob CODEC=gob ./#{project_name}
+
+    # Save the report for CI to compare with other runs on Ctrl+C
+    REPORT_JSON=report.json ./#{project_name}
     ```
 
     ## Testing
@@ -538,8 +16274,11 @@ defmodule ActorSimulation.PhonyGenerator do
     ```bash
     # Run tests
     go test -v ./...
-    ```
 
+    # Run them under the race detector, actors on a real clock included
+    go test -race ./...
+    ```
+    #{library}
     ## Customizing Behavior
 
     The generated actor code uses callback interfaces to allow customization WITHOUT
@@ -554,10 +16293,39 @@ defmodule ActorSimulation.PhonyGenerator do
 
     ## Project Structure
 
-    - `main.go` - Entry point and actor spawning
+    - `#{main_path(project_name, package)}` - Entry point
+    - `system.go` - Actor spawning, wiring and seeded RNG (DO NOT EDIT)
+    - `clock.go` - Real and virtual clocks (DO NOT EDIT)
+    - `middleware.go` - Middleware around message handlers (DO NOT EDIT)
+    - `sweep.go` - Seed sweeps for flakiness detection (DO NOT EDIT)
+    - `fork.go` - Forks of a run in virtual time (DO NOT EDIT)
+    - `debugger.go` - Step-by-step debugging of a run in virtual time (DO NOT EDIT)
+    - `diff.go` - Event log diffs of two runs in virtual time (DO NOT EDIT)
+    - `codec.go` - JSON and gob codecs for saved reports (DO NOT EDIT)
+    - `reportjson.go` - Reports in a stable JSON schema for tooling (DO NOT EDIT)
+    - `reconfigure.go` - Runtime changes to actors, edges and intervals (DO NOT EDIT)
+    - `topology.go` - The actor graph in Graphviz DOT (DO NOT EDIT)
+    - `tracesink.go` - Trace sinks recording every message handled (DO NOT EDIT)
+    - `partition.go` - Network partitions between groups of actors (DO NOT EDIT)
+    - `crash.go` - Scripted actor crashes and restarts (DO NOT EDIT)
+    - `conservation.go` - Message conservation check (DO NOT EDIT)
+    - `trace.go` - Sampled message traces (DO NOT EDIT)
+    - `id.go` - Reproducible message IDs (DO NOT EDIT)
+    - `envelope.go` - Message envelopes and their headers (DO NOT EDIT)
+    - `payloads.go` - Typed message payloads, when declared (DO NOT EDIT)
+    - `sleep.go` - Virtual-time sleeps for callbacks (DO NOT EDIT)
+    - `log.go` - Sampled logging for callbacks (DO NOT EDIT)
+    - `expvar.go` - Actor counters published at `/debug/vars` (DO NOT EDIT)
+    - `metricsink.go` - Metric sinks for Prometheus, expvar and tests (DO NOT EDIT)
+    - `report.go` - Per-actor summary of a run (DO NOT EDIT)
+    - `depth.go` - Queue depth over virtual time, as CSV or a plot (DO NOT EDIT)
+    - `progress.go` - Progress snapshots of a long run (DO NOT EDIT)
     - `*_actor.go` - Generated actor interface (DO NOT EDIT)
     - `*_callbacks.go` - Callback implementations (EDIT THIS!)
     - `actor_test.go` - Go test suite
+    - `phony_test.go` - Checks of the Phony semantics the actors rely on
+    - `bench_test.go` - Benchmark of Phony against channel dispatch, if acyclic
+    - `simtest/` - Virtual-time test harness (DO NOT EDIT)
     - `go.mod` - Module definition
 
     ## CI/CD
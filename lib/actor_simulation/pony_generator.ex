@@ -47,7 +47,7 @@ defmodule ActorSimulation.PonyGenerator do
     project_name = Keyword.fetch!(opts, :project_name)
     enable_callbacks = Keyword.get(opts, :enable_callbacks, true)
 
-    actors = simulation.actors
+    actors = ActorSimulation.GeneratorUtils.validate_targets!(simulation.actors)
 
     files =
       []
@@ -53,7 +53,7 @@ defmodule ActorSimulation.RactorGenerator do
     rust_edition = Keyword.get(opts, :rust_edition, "2021")
     ractor_version = Keyword.get(opts, :ractor_version, "0.15")
 
-    actors = simulation.actors
+    actors = GeneratorUtils.validate_targets!(simulation.actors)
 
     files =
       []
@@ -0,0 +1,134 @@
+defmodule ActorSimulation.Runner do
+  @moduledoc """
+  Runs a simulation spec on the virtual clock and reports on it, without
+  generating any code, for quick iteration on a topology.
+
+  The spec is interpreted by the same `ActorSimulation` actors and counters
+  that tests use, in virtual time, so a run of minutes takes a moment. Once
+  a topology needs custom callbacks, the same spec goes to a code generator.
+
+  ## Example
+
+      iex> report =
+      ...>   ActorSimulation.Runner.run(
+      ...>     [
+      ...>       producer: [send_pattern: {:periodic, 100, :tick}, targets: [:consumer]],
+      ...>       consumer: []
+      ...>     ],
+      ...>     until: 1000
+      ...>   )
+      iex> report =~ "ACTOR     SENT"
+      true
+
+  """
+
+  alias ActorSimulation.Stats
+
+  @default_until 10_000
+
+  @doc """
+  Runs `spec` and returns the report of the run.
+
+  `spec` is an `ActorSimulation` or a keyword list of actor definitions, as
+  `ActorSimulation.include/2` takes. The simulation is stopped afterwards.
+
+  Options:
+  - `:until` - Virtual time to run to, in ms or as `parse_duration/1` takes
+    (default: #{@default_until})
+  - `:seed` - Seeds `:rand` in every simulated actor, as `ActorSimulation.run/2` does
+  """
+  def run(spec, opts \\ []) do
+    until = parse_duration(Keyword.get(opts, :until, @default_until))
+
+    simulation =
+      spec
+      |> simulation()
+      |> ActorSimulation.run(duration: until, seed: Keyword.get(opts, :seed))
+
+    try do
+      report(simulation)
+    after
+      ActorSimulation.stop(simulation)
+    end
+  end
+
+  @doc """
+  Lays out the counters of a run as a table, one row per actor, sorted by
+  name, with the rates over the virtual time run and the total messages.
+  """
+  def report(simulation) do
+    formatted = Stats.format(ActorSimulation.get_stats(simulation))
+
+    rows =
+      formatted.actors
+      |> Enum.sort_by(fn {name, _summary} -> to_string(name) end)
+      |> Enum.map(fn {name, summary} ->
+        [
+          to_string(name),
+          to_string(summary.sent),
+          to_string(summary.received),
+          to_string(summary.sent_rate),
+          to_string(summary.received_rate)
+        ]
+      end)
+
+    """
+    Report at #{formatted.duration_ms}ms
+    #{table([["ACTOR", "SENT", "RECEIVED", "SENT/S", "RECEIVED/S"] | rows])}
+    Total messages: #{formatted.total_messages}
+    """
+  end
+
+  @doc """
+  Parses a duration such as `"500ms"`, `"10s"` or `"2m"` into ms; a bare
+  number is in ms already.
+
+  ## Examples
+
+      iex> ActorSimulation.Runner.parse_duration("10s")
+      10000
+
+      iex> ActorSimulation.Runner.parse_duration("250")
+      250
+
+  """
+  def parse_duration(ms) when is_integer(ms) and ms >= 0, do: ms
+
+  def parse_duration(duration) when is_binary(duration) do
+    case Integer.parse(duration) do
+      {n, unit} when n >= 0 and unit in ["", "ms"] -> n
+      {n, "s"} when n >= 0 -> n * 1000
+      {n, "m"} when n >= 0 -> n * 60_000
+      _ -> invalid_duration(duration)
+    end
+  end
+
+  def parse_duration(duration), do: invalid_duration(duration)
+
+  defp invalid_duration(duration) do
+    raise ArgumentError,
+          "invalid duration #{inspect(duration)}, expected ms or e.g. 500ms, 10s or 2m"
+  end
+
+  defp simulation(%ActorSimulation{} = simulation), do: simulation
+
+  defp simulation(definitions) when is_list(definitions),
+    do: ActorSimulation.include(ActorSimulation.new(), definitions)
+
+  # Pads every column to its widest cell, two spaces apart
+  defp table(rows) do
+    widths =
+      rows
+      |> Enum.zip()
+      |> Enum.map(fn column ->
+        column |> Tuple.to_list() |> Enum.map(&String.length/1) |> Enum.max()
+      end)
+
+    Enum.map_join(rows, "\n", fn row ->
+      row
+      |> Enum.zip(widths)
+      |> Enum.map_join("  ", fn {cell, width} -> String.pad_trailing(cell, width) end)
+      |> String.trim_trailing()
+    end)
+  end
+end
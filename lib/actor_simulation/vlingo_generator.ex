@@ -52,7 +52,7 @@ defmodule ActorSimulation.VlingoGenerator do
     vlingo_version = Keyword.get(opts, :vlingo_version, "1.11.1")
     enable_callbacks = Keyword.get(opts, :enable_callbacks, true)
 
-    actors = simulation.actors
+    actors = GeneratorUtils.validate_targets!(simulation.actors)
     package_path = String.replace(group_id, ".", "/")
 
     files =
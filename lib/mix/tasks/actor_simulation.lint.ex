@@ -0,0 +1,46 @@
+defmodule Mix.Tasks.ActorSimulation.Lint do
+  @moduledoc """
+  Checks the topology of a simulation spec for anti-patterns.
+
+  ## Usage
+
+      mix actor_simulation.lint spec.exs
+      mix actor_simulation.lint spec.exs --max-fan-out 5
+
+  The spec is an `.exs` file that evaluates to an `ActorSimulation` or to a
+  keyword list of actor definitions, as `ActorSimulation.include/2` takes.
+  See `ActorSimulation.Linter` for the checks.
+
+  ## Exit codes
+
+  - 0: No warnings
+  - 1: One or more warnings
+  """
+
+  use Mix.Task
+
+  @shortdoc "Check a simulation spec for topology anti-patterns"
+
+  @impl Mix.Task
+  def run(args) do
+    {opts, paths} = OptionParser.parse!(args, strict: [max_fan_out: :integer])
+
+    path =
+      case paths do
+        [path] -> path
+        _ -> Mix.raise("usage: mix actor_simulation.lint spec.exs [--max-fan-out N]")
+      end
+
+    Mix.Task.run("app.start")
+    {spec, _binding} = Code.eval_file(path)
+
+    case ActorSimulation.Linter.lint(spec, opts) do
+      [] ->
+        IO.puts("✅ #{path}: no warnings")
+
+      warnings ->
+        Enum.each(warnings, fn {_check, _actor, message} -> IO.puts("⚠️  #{message}") end)
+        exit({:shutdown, 1})
+    end
+  end
+end
@@ -0,0 +1,42 @@
+defmodule Mix.Tasks.ActorSimulation.Run do
+  @moduledoc """
+  Runs a simulation spec in virtual time and prints its report, without
+  generating code.
+
+  ## Usage
+
+      mix actor_simulation.run spec.exs
+      mix actor_simulation.run spec.exs --until 10s --seed 42
+
+  The spec is an `.exs` file that evaluates to an `ActorSimulation` or to a
+  keyword list of actor definitions, as `ActorSimulation.include/2` takes.
+  See `ActorSimulation.Runner` for the report.
+
+  ## Options
+
+  - `--until` - Virtual time to run to, e.g. `500ms`, `10s` or `2m` (default: 10s)
+  - `--seed` - Seed for `:rand` in every simulated actor
+  """
+
+  use Mix.Task
+
+  @shortdoc "Run a simulation spec in virtual time and print its report"
+
+  @impl Mix.Task
+  def run(args) do
+    {opts, paths} = OptionParser.parse!(args, strict: [until: :string, seed: :integer])
+
+    path =
+      case paths do
+        [path] -> path
+        _ -> Mix.raise("usage: mix actor_simulation.run spec.exs [--until 10s] [--seed N]")
+      end
+
+    Mix.Task.run("app.start")
+    {spec, _binding} = Code.eval_file(path)
+
+    spec
+    |> ActorSimulation.Runner.run(opts)
+    |> IO.write()
+  end
+end
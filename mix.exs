@@ -161,6 +161,8 @@ defmodule GenServerVirtualTime.MixProject do
           ActorSimulation.Actor,
           ActorSimulation.Definition,
           ActorSimulation.Stats,
+          ActorSimulation.Linter,
+          ActorSimulation.Runner,
           ActorSimulation.MermaidReportGenerator
         ],
         "Code Generators": [
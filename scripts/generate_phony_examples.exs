@@ -16,15 +16,17 @@ defmodule PhonyExampleGenerator do
     """)
 
     examples = [
-      {:pubsub, &create_pubsub_simulation/0, "pubsub_actors"},
-      {:pipeline, &create_pipeline_simulation/0, "pipeline_actors"},
-      {:burst, &create_burst_simulation/0, "burst_actors"},
-      {:loadbalanced, &create_loadbalanced_simulation/0, "loadbalanced_actors"}
+      {:pubsub, &create_pubsub_simulation/0, "pubsub_actors", []},
+      {:pipeline, &create_pipeline_simulation/0, "pipeline_actors", []},
+      # Trace 1% of the flood and log one in every 100 callback lines
+      {:burst, &create_burst_simulation/0, "burst_actors", trace_sample: 0.01, log_every: 100},
+      {:loadbalanced, &create_loadbalanced_simulation/0, "loadbalanced_actors", []},
+      {:storm, &create_storm_simulation/0, "storm_actors", []}
     ]
 
     results =
-      Enum.map(examples, fn {name, sim_fn, project_name} ->
-        generate_example(name, sim_fn.(), project_name)
+      Enum.map(examples, fn {name, sim_fn, project_name, opts} ->
+        generate_example(name, sim_fn.(), project_name, opts)
       end)
 
     # Summary
@@ -51,19 +53,20 @@ defmodule PhonyExampleGenerator do
     end
   end
 
-  defp generate_example(name, simulation, project_name) do
+  defp generate_example(name, simulation, project_name, opts) do
     IO.puts("\n📚 Generating: #{name}")
     IO.puts("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
     try do
       {:ok, files} =
-        ActorSimulation.PhonyGenerator.generate(simulation,
-          project_name: project_name,
-          enable_callbacks: true
+        ActorSimulation.PhonyGenerator.generate(
+          simulation,
+          [project_name: project_name, enable_callbacks: true] ++ opts
         )
 
       output_dir = "examples/phony_#{name}"
       :ok = ActorSimulation.PhonyGenerator.write_to_directory(files, output_dir)
+      format_go(output_dir)
 
       file_count = length(files)
       IO.puts("✅ Generated #{file_count} files in #{output_dir}/")
@@ -82,6 +85,18 @@ defmodule PhonyExampleGenerator do
     end
   end
 
+  # The generated Go is laid out by gofmt where Go is installed, so the
+  # checked-in examples stay gofmt-clean
+  defp format_go(output_dir) do
+    case System.find_executable("gofmt") do
+      nil ->
+        IO.puts("⚠️  gofmt not found, Go sources left unformatted")
+
+      gofmt ->
+        {_, 0} = System.cmd(gofmt, ["-w", output_dir], stderr_to_stdout: true)
+    end
+  end
+
   # Simulation definitions
 
   defp create_pubsub_simulation do
@@ -99,11 +114,13 @@ defmodule PhonyExampleGenerator do
     ActorSimulation.new()
     |> ActorSimulation.add_actor(:source,
       send_pattern: {:rate, 50, :data},
-      targets: [:stage1]
+      targets: [:stage1],
+      size: 1500
     )
-    |> ActorSimulation.add_actor(:stage1, targets: [:stage2])
-    |> ActorSimulation.add_actor(:stage2, targets: [:stage3])
-    |> ActorSimulation.add_actor(:stage3, targets: [:sink])
+    |> ActorSimulation.add_actor(:stage1, targets: [:stage2], size: 1500)
+    |> ActorSimulation.add_actor(:stage2, targets: [:stage3], size: 1500)
+    # The last stage passes on a summary of each message
+    |> ActorSimulation.add_actor(:stage3, targets: [:sink], size: 200)
     |> ActorSimulation.add_actor(:sink)
   end
 
@@ -111,22 +128,38 @@ defmodule PhonyExampleGenerator do
     ActorSimulation.new()
     |> ActorSimulation.add_actor(:burst_generator,
       send_pattern: {:burst, 10, 1000, :batch},
-      targets: [:processor]
+      targets: [:processor],
+      # Raise an alarm when a burst sends faster than 50 messages a second
+      alarm: [{:rate, :>, 50}, window: 100]
     )
-    |> ActorSimulation.add_actor(:processor)
+    # Each batch takes 20ms, so every burst queues up at the processor
+    |> ActorSimulation.add_actor(:processor, fair_queue: [batch: 1], service_time: 20)
   end
 
   defp create_loadbalanced_simulation do
     ActorSimulation.new()
     |> ActorSimulation.add_actor(:load_balancer,
       send_pattern: {:rate, 100, :request},
-      targets: [:server1, :server2, :server3]
+      targets: [:server]
     )
-    |> ActorSimulation.add_actor(:server1, targets: [:database])
-    |> ActorSimulation.add_actor(:server2, targets: [:database])
-    |> ActorSimulation.add_actor(:server3, targets: [:database])
+    # A pool of three replicas, each an inbox of the one server actor
+    |> ActorSimulation.add_actor(:server, targets: [:database], parallelism: 3)
     |> ActorSimulation.add_actor(:database)
   end
+
+  # The load-balanced system under a traffic surge
+  defp create_storm_simulation do
+    ActorSimulation.new()
+    |> ActorSimulation.add_actor(:load_balancer,
+      send_pattern: {:rate, 10, :request},
+      targets: [:server],
+      # Climb to 1000 requests a second over 30s to find where it breaks
+      ramp: [to: 1000, over: 30_000]
+    )
+    |> ActorSimulation.add_actor(:server, targets: [:database], parallelism: 3)
+    # Serves 500 queries a second, so it falls behind once the ramp passes that
+    |> ActorSimulation.add_actor(:database, fair_queue: [request: 1], service_time: 2)
+  end
 end
 
 # Run the generator
@@ -60,7 +60,7 @@ fi
 # Run tests if they exist
 if ls *_test.go >/dev/null 2>&1; then
   echo "🧪 Running tests..."
-  if go test -v ./...; then
+  if go test -race -v ./...; then
     echo "✅ Tests passed"
     echo ""
   else
@@ -22,6 +22,44 @@ defmodule ActorSimulationTest do
       assert Map.has_key?(simulation.actors, :producer)
       assert Map.has_key?(simulation.actors, :consumer)
     end
+
+    test "includes shared definitions in several simulations" do
+      path = Path.join(System.tmp_dir!(), "common_#{:rand.uniform(1_000_000)}.exs")
+
+      File.write!(path, """
+      [database: [on_match: [{:query, fn s -> {:reply, :result, s} end}]]]
+      """)
+
+      on_exit(fn -> File.rm(path) end)
+
+      for app <- [:billing, :search] do
+        simulation =
+          ActorSimulation.new()
+          |> ActorSimulation.include(path)
+          |> ActorSimulation.add_actor(app,
+            send_pattern: {:periodic, 100, :query},
+            targets: [:database]
+          )
+          |> ActorSimulation.run(duration: 1000)
+
+        stats = ActorSimulation.get_stats(simulation)
+        assert stats.actors[:database].received_count == 10
+
+        ActorSimulation.stop(simulation)
+      end
+    end
+
+    test "include raises on name collisions" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:database)
+
+      assert_raise ArgumentError, ~r/\[:database\]/, fn ->
+        ActorSimulation.include(simulation, database: [], cache: [])
+      end
+
+      assert_raise ArgumentError, ~r/\[:cache\]/, fn ->
+        ActorSimulation.include(ActorSimulation.new(), cache: [], cache: [])
+      end
+    end
   end
 
   describe "Periodic message sending" do
@@ -75,6 +113,25 @@ defmodule ActorSimulationTest do
 
       ActorSimulation.stop(simulation)
     end
+
+    test "start_delay offsets the first send" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:producer,
+          send_pattern: {:periodic, 100, :ping},
+          targets: [:consumer],
+          start_delay: 250
+        )
+        |> ActorSimulation.add_actor(:consumer)
+        |> ActorSimulation.run(duration: 1000)
+
+      stats = ActorSimulation.get_stats(simulation)
+
+      # Sends at 250ms, 350ms, ..., 950ms
+      assert stats.actors[:consumer].received_count == 8
+
+      ActorSimulation.stop(simulation)
+    end
   end
 
   describe "Rate-based message sending" do
@@ -23,6 +23,17 @@ defmodule ActorSimulation.DefinitionTest do
       assert def.targets == [:receiver1, :receiver2]
     end
 
+    test "turns weighted targets into a weight schedule that never changes" do
+      def = Definition.new(:balancer, targets: [{:server1, weight: 5}, :server2])
+
+      assert def.targets == [:server1, :server2]
+      assert def.weight_schedule == [server1: [%{at: 0, w: 5}]]
+
+      assert_raise ArgumentError, ~r/expected a weight of 0 or more/, fn ->
+        Definition.new(:balancer, targets: [{:server1, weight: -1}])
+      end
+    end
+
     test "defaults initial_state to empty map" do
       def = Definition.new(:stateful, [])
 
@@ -74,6 +85,20 @@ defmodule ActorSimulation.DefinitionTest do
       assert def.on_match == [{:req, :resp}]
       assert def.initial_state == %{data: []}
     end
+
+    test "accepts intervals as duration strings" do
+      publisher = Definition.new(:publisher, send_pattern: {:periodic, "250ms", :event})
+      generator = Definition.new(:generator, send_pattern: {:burst, 10, "1.5s", :batch})
+
+      assert publisher.send_pattern == {:periodic, 250, :event}
+      assert generator.send_pattern == {:burst, 10, 1500, :batch}
+    end
+
+    test "rejects invalid duration strings, naming the actor" do
+      assert_raise ArgumentError, ~r/actor :publisher has invalid interval "250xyz"/, fn ->
+        Definition.new(:publisher, send_pattern: {:periodic, "250xyz", :event})
+      end
+    end
   end
 
   describe "Definition.match_message/2" do
@@ -265,6 +290,6 @@ defmodule ActorSimulation.DefinitionTest do
   end
 
   describe "Definition documentation examples" do
-    doctest Definition, only: [interval_for_pattern: 1, messages_for_pattern: 1]
+    doctest Definition, only: [interval_for_pattern: 1, messages_for_pattern: 1, duration_ms: 1]
   end
 end
@@ -224,6 +224,55 @@ defmodule ActorSimulation.GeneratorUtilsTest do
     end
   end
 
+  describe "validate_targets!/1" do
+    test "passes actors whose targets and fallbacks are all declared" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:primary],
+          timeout: 50,
+          fallback: :backup
+        )
+        |> ActorSimulation.add_actor(:primary)
+        |> ActorSimulation.add_actor(:backup)
+
+      assert GeneratorUtils.validate_targets!(simulation.actors) == simulation.actors
+
+      ActorSimulation.stop(simulation)
+    end
+
+    test "names the file and line of each actor with a dangling target" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher, targets: [:subscriber1, :subscriber9])
+        |> ActorSimulation.add_actor(:subscriber1, fallback: :archive, timeout: 50)
+
+      line = __ENV__.line - 3
+
+      error =
+        assert_raise ArgumentError, fn ->
+          GeneratorUtils.validate_targets!(simulation.actors)
+        end
+
+      assert error.message ==
+               "test/generator_utils_test.exs:#{line}: " <>
+                 "actor :publisher targets unknown :subscriber9\n" <>
+                 "test/generator_utils_test.exs:#{line + 1}: " <>
+                 "actor :subscriber1 targets unknown :archive"
+
+      ActorSimulation.stop(simulation)
+    end
+
+    test "leaves out the location of an actor declared without one" do
+      actors = %{publisher: %{type: :external, targets: [:subscriber9]}}
+
+      assert_raise ArgumentError, "actor :publisher targets unknown :subscriber9", fn ->
+        GeneratorUtils.validate_targets!(actors)
+      end
+    end
+  end
+
   describe "readme_template/3" do
     test "generates README with all required sections" do
       options = [
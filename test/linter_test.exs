@@ -0,0 +1,91 @@
+defmodule ActorSimulation.LinterTest do
+  use ExUnit.Case, async: true
+  doctest ActorSimulation.Linter
+
+  alias ActorSimulation.Linter
+
+  defp pubsub(subscribers) do
+    ActorSimulation.new()
+    |> ActorSimulation.add_actor(:publisher,
+      send_pattern: {:periodic, 100, :event},
+      targets: subscribers
+    )
+    |> ActorSimulation.add_actor(:subscriber1)
+    |> ActorSimulation.add_actor(:subscriber2)
+    |> ActorSimulation.add_actor(:subscriber3)
+  end
+
+  test "flags nothing on the pubsub spec" do
+    simulation = pubsub([:subscriber1, :subscriber2, :subscriber3])
+
+    assert Linter.lint(simulation) == []
+
+    ActorSimulation.stop(simulation)
+  end
+
+  test "lists an orphan subscriber" do
+    simulation = pubsub([:subscriber1, :subscriber2])
+
+    assert Linter.lint(simulation) == [
+             {:orphan, :subscriber3, "actor :subscriber3 has no incoming or outgoing edges"}
+           ]
+
+    ActorSimulation.stop(simulation)
+  end
+
+  test "lists a source without targets" do
+    warnings = Linter.lint(ticker: [send_pattern: {:periodic, 100, :tick}])
+
+    assert warnings == [{:source_sink, :ticker, "actor :ticker is a source without targets"}]
+  end
+
+  test "lists actors no source reaches, even in a cycle" do
+    warnings =
+      Linter.lint(
+        source: [send_pattern: {:rate, 10, :data}, targets: [:sink]],
+        sink: [],
+        ping: [targets: [:pong]],
+        pong: [targets: [:ping]]
+      )
+
+    assert warnings == [
+             {:unreachable, :ping, "actor :ping is unreachable from any source"},
+             {:unreachable, :pong, "actor :pong is unreachable from any source"}
+           ]
+  end
+
+  test "follows fallback edges" do
+    warnings =
+      Linter.lint(
+        client: [
+          send_pattern: {:periodic, 100, :request},
+          targets: [:primary],
+          timeout: 50,
+          fallback: :backup
+        ],
+        primary: [],
+        backup: []
+      )
+
+    assert warnings == []
+  end
+
+  test "lists fan-out beyond the limit" do
+    definitions = [
+      publisher: [
+        send_pattern: {:periodic, 100, :event},
+        targets: [:subscriber1, :subscriber2, :subscriber3]
+      ],
+      subscriber1: [],
+      subscriber2: [],
+      subscriber3: []
+    ]
+
+    assert Linter.lint(definitions) == []
+
+    assert Linter.lint(definitions, max_fan_out: 2) == [
+             {:fan_out, :publisher,
+              "actor :publisher sends to 3 targets, more than the limit of 2"}
+           ]
+  end
+end
@@ -5,6 +5,8 @@ defmodule PhonyGeneratorTest do
   alias ActorSimulation
   alias ActorSimulation.PhonyGenerator
 
+  import ExUnit.CaptureIO
+
   describe "generate/2" do
     test "generates complete Phony (Go) project files" do
       simulation =
@@ -60,55 +62,3108 @@ defmodule PhonyGeneratorTest do
       {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
 
       {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
 
       assert main =~ "package main"
       assert main =~ "import"
-      assert main =~ "alice := &Alice"
-      assert main =~ "bob := &Bob"
-      assert main =~ ".Start()"
+      assert main =~ "sys := NewSystem(42, NewRealClock())"
+      assert main =~ "sys.Start()"
+      assert system =~ "s.alice = newAlice(s)"
+      assert system =~ "s.bob = newBob(s)"
+      assert system =~ "func newAlice(s *System) *Alice {\n\ta := &Alice{sys: s}\n\treturn a\n}"
+      assert system =~ "s.alice.Start()"
     end
 
-    test "generates go.mod with Phony dependency" do
-      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+    test "wires targets and generates handlers on receiving actors" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:stage]
+        )
+        |> ActorSimulation.add_actor(:stage, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
 
-      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test_actors")
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
 
-      {_name, gomod} = Enum.find(files, fn {name, _} -> name == "go.mod" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, stage} = Enum.find(files, fn {name, _} -> name == "stage.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
 
-      assert gomod =~ "module test_actors"
-      assert gomod =~ "go 1.21"
-      assert gomod =~ "github.com/Arceliar/phony"
+      assert system =~ "s.source.targets = []SourceTarget{s.stage}"
+      assert system =~ "s.stage.targets = []StageTarget{s.sink}"
+      assert stage =~ "type StageTarget interface"
+      assert stage =~ "func (a *Stage) Data()"
+      assert stage =~ "a.sys.send(a, target, func() { target.Data() })"
+      assert sink =~ "func (a *Sink) Data()"
+      refute sink =~ "targets"
     end
 
-    test "generates CI pipeline for Go" do
-      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+    test "generates a handler per kind on a sink fed by different senders" do
+      # Data comes through the pipeline and a flush straight from its source
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 50, :data}, targets: [:stage3])
+        |> ActorSimulation.add_actor(:stage3, targets: [:sink])
+        |> ActorSimulation.add_actor(:flusher,
+          send_pattern: {:periodic, 200, :flush},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
 
       {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
 
-      {_name, ci} = Enum.find(files, fn {name, _} -> name == ".github/workflows/ci.yml" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, stage3} = Enum.find(files, fn {name, _} -> name == "stage3.go" end)
+      {_name, flusher} = Enum.find(files, fn {name, _} -> name == "flusher.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
 
-      assert ci =~ "name: CI"
-      assert ci =~ "ubuntu-latest"
-      assert ci =~ "actions/setup-go"
-      assert ci =~ "go test"
-      assert ci =~ "Run Demo Application"
+      assert system =~ "s.stage3.targets = []Stage3Target{s.sink}"
+      assert system =~ "s.flusher.targets = []FlusherTarget{s.sink}"
+      assert stage3 =~ "type Stage3Target interface {\n\tphony.Actor\n\tData()\n}"
+      assert flusher =~ "type FlusherTarget interface {\n\tphony.Actor\n\tFlush()\n}"
+
+      assert sink =~ "type SinkCallbacks interface {\n\tOnData() error\n\tOnFlush() error\n}"
+      assert sink =~ "func (a *Sink) Data()"
+      assert sink =~ "func (a *Sink) Flush()"
+      assert sink =~ "\tcase \"data\":\n\t\treturn a.Data, true\n"
+      assert sink =~ "\tcase \"flush\":\n\t\treturn a.Flush, true\n"
     end
 
-    test "generates Go test file" do
+    test "warns about duplicate targets and generates a single edge" do
       simulation =
         ActorSimulation.new()
-        |> ActorSimulation.add_actor(:worker,
-          send_pattern: {:periodic, 100, :tick},
-          targets: []
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1, :sub1]
+        )
+        |> ActorSimulation.add_actor(:sub1)
+
+      warning =
+        capture_io(:stderr, fn ->
+          {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+          {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+
+          assert system =~ "s.publisher.targets = []PublisherTarget{s.sub1}\n"
+        end)
+
+      assert warning =~ "actor :publisher lists :sub1 more than once"
+    end
+
+    test "keeps duplicate targets with allow_duplicate" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1, :sub1]
+        )
+        |> ActorSimulation.add_actor(:sub1)
+
+      warning =
+        capture_io(:stderr, fn ->
+          {:ok, files} =
+            PhonyGenerator.generate(simulation, project_name: "test", allow_duplicate: true)
+
+          {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+
+          assert system =~ "s.publisher.targets = []PublisherTarget{s.sub1, s.sub1}"
+        end)
+
+      assert warning == ""
+    end
+
+    test "generates a timeout that falls back to another target" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:server1],
+          timeout: 50,
+          fallback: :server3
         )
+        |> ActorSimulation.add_actor(:server1, service_time: 80)
+        |> ActorSimulation.add_actor(:server3)
 
       {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
 
+      {_name, client} = Enum.find(files, fn {name, _} -> name == "client.go" end)
+      {_name, server3} = Enum.find(files, fn {name, _} -> name == "server3.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
       {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
 
-      assert test_file =~ "package main"
-      assert test_file =~ "testing"
-      assert test_file =~ "func TestWorker"
+      assert client =~ "a.pending[id] = a.sys.after(a, 50 * time.Millisecond, func() {"
+      assert client =~ "a.sys.send(a, fallback, func() { fallback.Request() })"
+      assert client =~ "a.sys.reply(target, a, func() { a.replied(id) })"
+      assert client =~ "timer.Stop()"
+      assert client =~ "func (a *Client) TimeoutCount() int"
+      assert server3 =~ "func (a *Server3) Request()"
+
+      assert system =~ "s.client.fallback = s.server3"
+      assert system =~
+               "s.serviceTime = map[phony.Actor]time.Duration{s.server1: 80 * time.Millisecond}"
+      assert system =~ "func (s *System) reply(from, to phony.Actor, f func())"
+
+      assert test_file =~ "func TestClientFallsBack"
+      assert test_file =~ "sys.serviceTime[sys.server1] = 2 * 50 * time.Millisecond"
+    end
+
+    test "rejects a timeout without a fallback target" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client, targets: [:server1], timeout: 50)
+        |> ActorSimulation.add_actor(:server1)
+
+      assert_raise ArgumentError, ~r/:client needs both a positive :timeout/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "trips a circuit breaker after repeated timeouts" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:server1],
+          timeout: 50,
+          fallback: :server3,
+          circuit_breaker: [threshold: 5, cooldown: 1000]
+        )
+        |> ActorSimulation.add_actor(:server1, service_time: 80)
+        |> ActorSimulation.add_actor(:server3)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, breaker} = Enum.find(files, fn {name, _} -> name == "breaker.go" end)
+      {_name, client} = Enum.find(files, fn {name, _} -> name == "client.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert breaker =~ "func NewBreaker(threshold int, cooldown time.Duration"
+      assert client =~ "if !breaker.Allow() {"
+      assert client =~ "a.timeoutCount++\n\t\t\tbreaker.Failure()\n"
+      assert client =~ "if a.replied(id) {\n\t\t\t\t\tbreaker.Success()\n"
+      assert client =~ "func (a *Client) ShortCircuitCount() int"
+
+      assert system =~
+               "s.client.breakers = []*Breaker{NewBreaker(5, 1000 * time.Millisecond, s.clock.Now)}"
+
+      assert test_file =~ "func TestBreakerOpensAndRecovers"
+      assert test_file =~ "func TestClientTripsBreaker"
+    end
+
+    test "rejects a circuit breaker without a timeout" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          targets: [:server1],
+          circuit_breaker: [threshold: 5, cooldown: 1000]
+        )
+        |> ActorSimulation.add_actor(:server1)
+
+      assert_raise ArgumentError, ~r/has circuit_breaker .* but no timeout/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "generates weighted fair queuing across message kinds" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:bulk, send_pattern: {:rate, 100, :data}, targets: [:server])
+        |> ActorSimulation.add_actor(:ops,
+          send_pattern: {:rate, 10, :control},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server, fair_queue: [data: 3], service_time: 20)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      filenames = Enum.map(files, fn {name, _content} -> name end)
+      assert "fairqueue.go" in filenames
+
+      {_name, server} = Enum.find(files, fn {name, _} -> name == "server.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert server =~ "a.queue.Push(0, func() {"
+      assert server =~ "a.queue.Push(1, func() {"
+      assert server =~ "func (a *Server) handleControl()"
+      assert server =~ "a.sys.after(a, 20 * time.Millisecond, func() {"
+      assert server =~ "counts[\"control\"] = a.processed[1]"
+      # Unweighted message kinds default to weight 1
+      assert system =~ "func newServer(s *System) *Server {\n\ta := &Server{sys: s}\n\ta.queue = NewFairQueue(3, 1)\n"
+      assert test_file =~ "func TestFairQueueSharesByWeight"
+    end
+
+    test "spreads an actor over several inboxes under one name" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:burst_generator,
+          send_pattern: {:burst, 10, 100, :batch},
+          targets: [:processor]
+        )
+        |> ActorSimulation.add_actor(:processor,
+          fair_queue: [batch: 1],
+          service_time: 20,
+          parallelism: 4
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, processor} = Enum.find(files, fn {name, _} -> name == "processor.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert processor =~ "\tshards []*Processor\n\tnext int\n"
+      assert processor =~ "shard := a.nextShard()"
+      assert processor =~ "a.sys.send(a, shard, func() { shard.Batch() })"
+      assert processor =~ "a.eachShard(func(a *Processor) { n += a.copiesSent })"
+      assert processor =~ "counts[\"batch\"] += a.processed[0]"
+
+      assert system =~ "\ta.shards = make([]*Processor, 4)\n"
+      assert system =~ "\t\tshard.queue = NewFairQueue(1)\n"
+      assert system =~ "\t\tshard := &Processor{sys: s}\n\t\tshard.queue = NewFairQueue(1)\n\t\ta.shards[i] = shard\n"
+      assert system =~ "phony.Block(shard, func() { n += shard.queue.Len() })"
+
+      # 100 batches a second saturate one inbox serving each in 20ms
+      assert test_file =~ "func TestProcessorDrainsAcrossInboxes"
+      assert test_file =~ "processed(1), processed(4)"
+
+      assert_raise ArgumentError, ~r/invalid parallelism/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 10, :data},
+          parallelism: 2
+        )
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "lets callbacks sleep in virtual time" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :query},
+          targets: [:db]
+        )
+        |> ActorSimulation.add_actor(:db)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, callbacks} = Enum.find(files, fn {name, _} -> name == "source_callbacks.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert Enum.any?(files, fn {name, _} -> name == "sleep.go" end)
+      assert source =~ "a.callbacks = &DefaultSourceCallbacks{Ctx: &a.ctx}"
+      assert source =~ "\ta.sys.resume(a, &a.ctx, a.finishQuery)\n"
+      assert source =~ "func (a *Source) finishQuery() {\n\t// Send to targets"
+      assert callbacks =~ "\tCtx *Context\n"
+
+      # The query holds the first send back by 10ms of virtual time
+      assert test_file =~ "func TestCallbacksSleepInVirtualTime"
+      assert test_file =~ "c.Ctx.SleepVirtual(10 * time.Millisecond)"
+      assert test_file =~ "h.Advance(100 * time.Millisecond)"
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation, project_name: "test", enable_callbacks: false)
+
+      refute Enum.any?(files, fn {name, _} -> name == "sleep.go" end)
+    end
+
+    test "replays a schedule file of recorded traffic" do
+      path = Path.join(System.tmp_dir!(), "phony_schedule_#{:rand.uniform(1_000_000)}.csv")
+      File.write!(path, "at_ms,message\n0,request\n12.5,request\n2000,health\n")
+      on_exit(fn -> File.rm(path) end)
+
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:traffic, schedule_file: path, targets: [:api])
+        |> ActorSimulation.add_actor(:api)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, traffic} = Enum.find(files, fn {name, _} -> name == "traffic.go" end)
+      {_name, api} = Enum.find(files, fn {name, _} -> name == "api.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert Enum.any?(files, fn {name, _} -> name == "schedule.go" end)
+      assert {"traffic_schedule.csv", File.read!(path)} in files
+      assert traffic =~ "//go:embed traffic_schedule.csv\nvar trafficSchedule string"
+      assert traffic =~ "\t\tcase \"health\":\n\t\t\tsends[i] = a.Health\n"
+      assert traffic =~ "err = a.Replay(entries)"
+      assert api =~ "\tOnHealth() error\n"
+
+      # Two requests fall due within the first second
+      assert test_file =~ "h.AssertSendCount(sys.traffic, 2)"
+      assert test_file =~ "func TestTrafficReplaysSchedule"
+      assert test_file =~ "func TestParseSchedule"
+
+      File.write!(path, "0,request\nsoon,request\n")
+
+      assert_raise ArgumentError, ~r/invalid entry on line 2/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "sums up a run in a report" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:stage]
+        )
+        |> ActorSimulation.add_actor(:stage,
+          targets: [:sink],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert report =~ "func (s *System) RunUntil(t time.Duration) (Report, error)"
+      assert report =~ "func (a *Source) report(r *Report, name string) {\n"
+
+      assert report =~
+               "\tr.add(name, a, a.SendCount(), 0, 0, " <>
+                 "a.QueueTimeStats(), a.ServiceTimeStats())\n"
+
+      assert report =~
+               "\tr.add(name, a, a.SendCount(), a.LostCount(), 0, " <>
+                 "a.QueueTimeStats(), a.ServiceTimeStats())\n"
+
+      assert report =~ "\tfor name, a := range s.actors {\n"
+      assert report =~ "\t\tactors[name].report(&r, name)\n"
+
+      assert system =~ "\t\tc.arrived(start)\n"
+
+      # What reaches the sink depends on loss, so only the stage is checked
+      assert test_file =~ "func TestReportSumsUpRun"
+      assert test_file =~ "want := map[string]int{\"source\": 0, \"stage\": 50}"
+    end
+
+    test "stops a run at the clock's step limit" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:ping,
+          send_pattern: {:periodic, 10, :ball},
+          targets: [:pong]
+        )
+        |> ActorSimulation.add_actor(:pong, targets: [:ping])
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, clock} = Enum.find(files, fn {name, _} -> name == "clock.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert clock =~ "func (c *VirtualClock) SetStepLimit(n uint64)"
+      assert clock =~ "if c.limit > 0 && c.steps >= c.limit {\n\t\tc.exceeded = true"
+      assert report =~ "return r, fmt.Errorf(\"%w: %d timers run by %v, %d still pending\""
+      assert test_file =~ "func TestStepLimitStopsRunaways"
+      assert test_file =~ "\t\"errors\"\n"
+    end
+
+    test "resends on at-least-once edges until acknowledged" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 10, :request},
+          targets: [:server, :audit],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.3},
+          delivery: [server: {:at_least_once, retry: 30}]
+        )
+        |> ActorSimulation.add_actor(:server)
+        |> ActorSimulation.add_actor(:audit)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, client} = Enum.find(files, fn {name, _} -> name == "client.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert client =~ "\tduplicateCount atomic.Int64\n"
+      assert client =~ "func (a *Client) sendReliably(i int, target phony.Actor, receive func())"
+      assert client =~ "\t\t\ta.sendReliably(i, target, func() { target.Request() })\n"
+      assert system =~ "\ts.client.retry = []time.Duration{30 * time.Millisecond, 0}\n"
+      assert test_file =~ "func TestClientDeliversAtLeastOnce"
+
+      # Resent copies can be lost too, so the sweep no longer adds up
+      refute test_file =~ "func TestClientLossAcrossSeeds"
+
+      timed =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 10, :request},
+          targets: [:server],
+          timeout: 50,
+          fallback: :backup,
+          delivery: :at_least_once
+        )
+        |> ActorSimulation.add_actor(:server)
+        |> ActorSimulation.add_actor(:backup)
+
+      assert_raise ArgumentError, ~r/both delivery: and timeout:/, fn ->
+        PhonyGenerator.generate(timed, project_name: "test")
+      end
+    end
+
+    test "applies retried messages once at idempotent actors" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 10, :write},
+          targets: [:database],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.3},
+          delivery: :at_least_once
+        )
+        |> ActorSimulation.add_actor(:database, idempotent_by: :id)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, database} = Enum.find(files, fn {name, _} -> name == "database.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert database =~ "\tseen map[MessageID]bool\n"
+      assert database =~ "func (a *Database) handleWrite() {\n\tif !a.firstCopy() {\n\t\treturn\n"
+      assert database =~ "func (a *Database) DedupedCount() int"
+      assert report =~ "s.database.DedupedCount(), 0,"
+      assert test_file =~ "func TestDatabaseAppliesRetriesOnce"
+
+      sharded =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 10, :write},
+          targets: [:db]
+        )
+        |> ActorSimulation.add_actor(:db, idempotent_by: :id, parallelism: 2)
+
+      assert_raise ArgumentError, ~r/invalid idempotent_by/, fn ->
+        PhonyGenerator.generate(sharded, project_name: "test")
+      end
+    end
+
+    test "samples and plots queue depth over virtual time" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:burst_generator,
+          send_pattern: {:burst, 10, 1000, :batch},
+          targets: [:processor]
+        )
+        |> ActorSimulation.add_actor(:processor, fair_queue: [batch: 1], service_time: 20)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, depth} = Enum.find(files, fn {name, _} -> name == "depth.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert depth =~ "func (s *System) SampleQueueDepth(interval time.Duration)"
+      assert depth =~ "func (s *System) WriteQueueDepthCSV(w io.Writer) error"
+      assert depth =~ "func (s *System) PlotQueueDepth(w io.Writer) error"
+      assert system =~ "\tdepths depthLog\n"
+      assert test_file =~ "sys.SampleQueueDepth(20 * time.Millisecond)"
+    end
+
+    test "benchmarks the system and each actor" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 20, :query},
+          targets: [:server1]
+        )
+        |> ActorSimulation.add_actor(:server1, targets: [:audit])
+        |> ActorSimulation.add_actor(:audit)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert test_file =~ "func BenchmarkActorSystem(b *testing.B) {"
+      assert test_file =~ "sys.RunUntil(time.Duration(b.N) * 20 * time.Millisecond)"
+      assert test_file =~ "func BenchmarkServer1(b *testing.B) {"
+      assert test_file =~ ~s|Act(sys, "server1", Envelope[string]{Kind: "query"})|
+      assert test_file =~ "func BenchmarkAudit(b *testing.B) {"
+      assert test_file =~ "\t\"os\"\n"
+    end
+
+    test "generates the payloads a source originates" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:loader,
+          send_pattern: {:periodic, 10, :job},
+          targets: [:worker],
+          message_types: [job: [id: :int, body: :bytes]],
+          payload: [id: :sequential, body: {:random_bytes, 16}]
+        )
+        |> ActorSimulation.add_actor(:worker)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, loader} = Enum.find(files, fn {name, _} -> name == "loader.go" end)
+      {_name, payloads} = Enum.find(files, fn {name, _} -> name == "payloads.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert loader =~ ~s|a.payloadDraw = a.sys.Stream("payload/loader")|
+      assert loader =~ ~s|a.sys.produce(a, "loader", a.originateJob)|
+      assert loader =~
+               "a.header.payload = Job{Id: int(a.header.key), Body: drawBytes(a.payloadDraw, 16)}"
+
+      assert payloads =~ "func drawBytes(draw func() float64, n int) []byte {"
+      assert test_file =~ "func TestLoaderOriginatesPayloads"
+      assert test_file =~ "if len(m.Body) != 16 {"
+    end
+
+    test "rejects a payload generator unlike its field" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:loader,
+          send_pattern: {:periodic, 10, :job},
+          targets: [:worker],
+          message_types: [job: [id: :string]],
+          payload: [id: :sequential]
+        )
+        |> ActorSimulation.add_actor(:worker)
+
+      assert_raise ArgumentError, ~r/actor :loader has invalid payload \[id: :sequential\]/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "resets and cancels a one-shot timer" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:reminder,
+          send_pattern: {:self_message, 500, :tick},
+          targets: [:processor]
+        )
+        |> ActorSimulation.add_actor(:processor)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, reminder} = Enum.find(files, fn {name, _} -> name == "reminder.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert reminder =~ "\ta.armOneShot(500 * time.Millisecond)\n"
+      assert reminder =~ "func (a *Reminder) Reset() {"
+      assert reminder =~ "func (a *Reminder) Cancel() bool {"
+      assert reminder =~ "a.sys.record(func(s *System) { s.reminder.Cancel() })"
+      assert test_file =~ "func TestReminderOneShotResetsAndCancels"
+      assert test_file =~ "runUntil(t, sys, 999 * time.Millisecond)"
+    end
+
+    test "sums up a report across all actors" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert report =~ "func (s *System) SystemMetrics() SystemMetrics"
+      assert report =~ "func (r Report) SystemMetrics() SystemMetrics"
+      assert test_file =~ "func TestSystemMetricsAddUpActors"
+      assert test_file =~ "if m.Received != 10 {"
+    end
+
+    test "rejects a target no actor answers to before generating any file" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:subscriber1, :subscriber9]
+        )
+        |> ActorSimulation.add_actor(:subscriber1)
+
+      error =
+        assert_raise ArgumentError, fn ->
+          PhonyGenerator.generate(simulation, project_name: "test")
+        end
+
+      assert error.message =~ ~r/^test\/phony_generator_test.exs:\d+: actor :publisher /
+      assert error.message =~ "targets unknown :subscriber9"
+    end
+
+    test "replies to a request back to the actor that sent it" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 20, :query},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server,
+          replies: [query: :result],
+          message_types: [query: [sql: :string], result: [rows: :int]]
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, client} = Enum.find(files, fn {name, _} -> name == "client.go" end)
+      {_name, server} = Enum.find(files, fn {name, _} -> name == "server.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert Enum.any?(files, fn {name, _} -> name == "reply.go" end)
+      assert server =~ "\tOnQuery(msg Query) (Result, error)\n"
+      assert server =~ "a.sys.respond(a, \"result\", reply)"
+      assert client =~ "a.answered(a.header.id)"
+      assert client =~ "func (a *Client) ReplyTimeStats() TimeStats"
+      assert test_file =~ "func TestRepliesReturnToRequester"
+    end
+
+    test "rejects a reply to a kind the actor never receives" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 20, :query},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server, replies: [lookup: :result])
+
+      assert_raise ArgumentError, ~r/replies to :lookup, which it never receives/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "renders a recorded trace as a Mermaid sequence diagram" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, trace_file} = Enum.find(files, fn {name, _} -> name == "tracesink.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert trace_file =~ "type MermaidWindow struct"
+      assert trace_file =~ "func RenderMermaid(trace []TraceEvent, w io.Writer,"
+      assert trace_file =~ "Note over %s: %d more messages left out"
+      assert test_file =~ "func TestRenderMermaidListsTracedActors"
+      assert test_file =~ "RenderMermaid(events, &b, MermaidWindow{Max: 20})"
+    end
+
+    test "halts the run on an error a callback returns" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:database]
+        )
+        |> ActorSimulation.add_actor(:database)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, database} = Enum.find(files, fn {name, _} -> name == "database.go" end)
+      {_name, callbacks} = Enum.find(files, fn {name, _} -> name == "database_callbacks.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert database =~ "\tOnRequest() error\n"
+      assert database =~ "if err := a.callbacks.OnRequest(); err != nil {"
+      assert database =~ "\t\ta.sys.fail(\"database\", err)\n\t\treturn\n"
+      assert callbacks =~ "func (c *DefaultDatabaseCallbacks) OnRequest() error {"
+      assert system =~ "func (s *System) Err() error"
+      assert report =~ "if err := s.Err(); err != nil {\n\t\treturn r, err\n\t}"
+      assert test_file =~ "func TestCallbackErrorHaltsRun"
+      assert test_file =~ "sys.source.callbacks = failingSourceCallbacks{defaults}"
+    end
+
+    test "records every delivery on a trace sink" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, trace_file} = Enum.find(files, fn {name, _} -> name == "tracesink.go" end)
+      {_name, source_file} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, system_file} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert trace_file =~ "type TraceSink interface"
+      assert trace_file =~ "func WithTraceSink(sink TraceSink) Option"
+      assert trace_file =~ "func NewTraceRecorder() *TraceRecorder"
+      assert trace_file =~ "func NewJSONLSink(w io.Writer) *JSONLSink"
+      assert source_file =~ "HandlerContext{Actor: \"source\", From: a.header.from,"
+      assert system_file =~ "h.from = sentBy(from, to, h)"
+      assert test_file =~ "func TestTraceSinkRecordsDeliveries"
+      assert test_file =~ ~s(wired["source -> "+names[to]] = true)
+      assert test_file =~ ~s(t.Fatal("expected a trace of the messages the sources sent"))
+      refute test_file =~ "e.g. by Kafka sources, handles nothing"
+
+      bursty =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:generator,
+          send_pattern: {:burst, 10, 1000, :batch},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(bursty, project_name: "test")
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert test_file =~ "\trunUntil(t, sys, 3000 * time.Millisecond)\n"
+      assert test_file =~ ~s(e.From == "" && e.To == "generator" && e.Message == "batch")
+      assert test_file =~ "expected generator to burst 3 times"
+      assert test_file =~ "expected 10 batch messages from generator at %v"
+
+    test "tests that constant edge delays add up along a pipeline" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 50, :data},
+          targets: [:stage1],
+          delay: {:constant, 5}
+        )
+        |> ActorSimulation.add_actor(:stage1,
+          targets: [:stage2, :audit],
+          delay: [stage2: {:constant, 3}]
+        )
+        |> ActorSimulation.add_actor(:stage2, targets: [:sink], delay: {:constant, 7})
+        |> ActorSimulation.add_actor(:sink)
+        |> ActorSimulation.add_actor(:audit)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert test_file =~ "func TestSinkLatencyAddsUpEdgeDelays"
+      assert test_file =~ "// Every message takes source -> stage1 -> stage2 -> sink"
+      assert test_file =~ "a.P50 != 15*time.Millisecond || a.P99 != 15*time.Millisecond"
+
+      jittery =
+        ActorSimulation.add_actor(simulation, :stage2, targets: [:sink], delay: {:uniform, 1, 9})
+      {:ok, files} = PhonyGenerator.generate(jittery, project_name: "test")
+
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      refute test_file =~ "func TestSinkLatencyAddsUpEdgeDelays"
+    end
+
+    test "exports the actor graph as Graphviz DOT" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:gateway],
+          timeout: 50,
+          fallback: :backup
+        )
+        |> ActorSimulation.add_actor(:gateway, targets: [:db, :cache])
+        |> ActorSimulation.add_actor(:backup)
+        |> ActorSimulation.add_actor(:db)
+        |> ActorSimulation.add_actor(:cache)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, topology} = Enum.find(files, fn {name, _} -> name == "topology.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert topology =~ "func (s *System) WriteDOT(w io.Writer) error"
+      assert topology =~ "\t\"gateway\"\n"
+      assert topology =~ "\t\"gateway\" -> \"db\" [label=\"request\"]\n"
+      assert topology =~ "\t\"gateway\" -> \"cache\" [label=\"request\"]\n"
+      assert topology =~ "\"client\" -> \"backup\" [label=\"request\", style=dashed]"
+      assert test_file =~ "func TestWriteDOTShowsWiring"
+      assert test_file =~ "names[sys.client.fallback]"
+    end
+
+    test "bounds mailboxes with an overflow policy" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:ingest,
+          send_pattern: {:burst, 6, 100, :event},
+          targets: [:worker, :archive, :index]
+        )
+        |> ActorSimulation.add_actor(:worker, service_time: 20, mailbox: [capacity: 3])
+        |> ActorSimulation.add_actor(:archive,
+          service_time: 10,
+          mailbox: [capacity: 2, overflow: :drop_oldest]
+        )
+        |> ActorSimulation.add_actor(:index,
+          service_time: 5,
+          mailbox: [capacity: 2, overflow: :block]
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, worker} = Enum.find(files, fn {name, _} -> name == "worker.go" end)
+      {_name, archive} = Enum.find(files, fn {name, _} -> name == "archive.go" end)
+      {_name, index} = Enum.find(files, fn {name, _} -> name == "index.go" end)
+      {_name, queue} = Enum.find(files, fn {name, _} -> name == "fairqueue.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert worker =~ "if a.queue.Len() >= 3 {"
+      assert worker =~ "// The mailbox is full, so the message is dropped"
+      assert worker =~ "func (a *Worker) DropCount() int"
+      assert archive =~ "if a.queue.Len() >= 2 && a.queue.DropOldest() {"
+      assert index =~ "a.blocked = append(a.blocked, func() {"
+      assert index =~ "func (a *Index) unblock()"
+      assert index =~ "\t\tif len(a.blocked) >= 2 {\n"
+      assert index =~ "func (a *Index) DropCount() int"
+      assert queue =~ "func (q *FairQueue) DropOldest() bool"
+      assert test_file =~ "func TestWorkerBoundsMailbox"
+
+      blocking =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:feed,
+          send_pattern: {:periodic, 10, :tick},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink,
+          service_time: 15,
+          mailbox: [capacity: 2, overflow: :block]
+        )
+
+      {:ok, files} = PhonyGenerator.generate(blocking, project_name: "test")
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert test_file =~ "func TestSinkBoundsMailbox"
+      assert test_file =~ "\tfor i := 0; i < 20; i++ {\n"
+      assert test_file =~ "expected the other 15 messages to be dropped"
+
+      bad = ActorSimulation.add_actor(simulation, :audit, mailbox: [capacity: 0])
+
+      assert_raise ArgumentError, ~r/:audit has invalid mailbox/, fn ->
+        PhonyGenerator.generate(bad, project_name: "test")
+      end
+    end
+
+    test "stops the system on a signal and tests that no goroutine is left" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:burst_generator,
+          send_pattern: {:burst, 10, 1000, :batch},
+          targets: [:processor]
+        )
+        |> ActorSimulation.add_actor(:processor)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert main =~ "signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)"
+      assert main =~ "\t<-interrupt\n\tsys.Stop()\n"
+      assert test_file =~ "func TestStopReleasesGoroutines"
+      assert test_file =~ "baseline := runtime.NumGoroutine()"
+      assert test_file =~ "\tsys.Stop()\n\tif n := runtime.NumGoroutine(); n > baseline {"
+    end
+
+    test "routes each message to one target in turn or at random" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:load_balancer,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:server1, :server2, :server3],
+          routing: :round_robin
+        )
+        |> ActorSimulation.add_actor(:server1, targets: [:db, :cache], routing: :random)
+        |> ActorSimulation.add_actor(:server2)
+        |> ActorSimulation.add_actor(:server3)
+        |> ActorSimulation.add_actor(:db)
+        |> ActorSimulation.add_actor(:cache)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, balancer} = Enum.find(files, fn {name, _} -> name == "load_balancer.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, routing} = Enum.find(files, fn {name, _} -> name == "routing.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert balancer =~ "\trouter router\n"
+      assert balancer =~ "// Only to the next target in turn"
+      assert system =~ "func newLoadBalancer(s *System) *LoadBalancer {\n\ta := &LoadBalancer{sys: s}\n\ta.router.strategy = RouteRoundRobin\n"
+      assert system =~ ~s|a.router.draw = s.Stream("route/server1")|
+      assert routing =~ "case RouteRoundRobin:"
+      assert test_file =~ "func TestLoadBalancerRoutesInTurn"
+
+      assert_raise ArgumentError, ~r/invalid routing/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, targets: [:sink], routing: :sticky)
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "generates typed payloads that reach callbacks" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:api,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:database],
+          message_types: [request: [id: :int, query: :string]]
+        )
+        |> ActorSimulation.add_actor(:database)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, payloads} = Enum.find(files, fn {name, _} -> name == "payloads.go" end)
+      {_name, actor} = Enum.find(files, fn {name, _} -> name == "database.go" end)
+      {_name, callbacks} = Enum.find(files, fn {name, _} -> name == "database_callbacks.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert payloads =~ "type Request struct {\n\tId int\n\tQuery string\n}"
+      assert actor =~ "OnRequest(msg Request)"
+      assert actor =~ "a.callbacks.OnRequest(payloadAs[Request](a.header.payload))"
+      assert callbacks =~ "func (c *DefaultDatabaseCallbacks) OnRequest(msg Request)"
+      assert test_file =~ "func TestApiReceivesTypedPayloads"
+
+      assert_raise ArgumentError, ~r/invalid message_types/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:api, message_types: [request: [id: :uuid]])
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "generates a debugger that steps, breaks and steps back" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, debugger} = Enum.find(files, fn {name, _} -> name == "debugger.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert debugger =~ "func NewDebugger(s *System) *Debugger"
+      assert debugger =~ "func (d *Debugger) Step() (Event, bool)"
+      assert debugger =~ "func (d *Debugger) Continue(until time.Duration) (Event, bool)"
+      assert debugger =~ "func (d *Debugger) Inspect(name string) ActorReport"
+      # Stepping back forks the run just after an earlier event
+      assert debugger =~ "d.attach(Fork(snap))"
+      assert test_file =~ "func TestDebuggerStepsAndBreaks"
+      assert test_file =~ ~s|d.Break("sink", "", func(a ActorReport) bool|
+    end
+
+    test "draws each edge's loss and delay from an RNG stream of its own" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 100, :request},
+          targets: [:server, :audit],
+          loss: [server: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}],
+          delay: [audit: {:exponential, 20}]
+        )
+        |> ActorSimulation.add_actor(:server)
+        |> ActorSimulation.add_actor(:audit)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, trace} = Enum.find(files, fn {name, _} -> name == "trace.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~ "func (s *System) Stream(name string) func() float64"
+      assert system =~ ~s|fmt.Fprintf(h, "%d/%s", s.seed, name)|
+      assert system =~ ~s|NewGilbertElliott(s.Stream("loss/client/server")|
+      assert system =~ ~s|NewExponentialDelay(s.Stream("delay/client/audit")|
+      refute system =~ "(s.Float64,"
+      assert trace =~ ~s|s.traceDraw = s.Stream("trace")|
+      assert test_file =~ "func TestLossStreamsAreIndependent"
+    end
+
+    test "connects Kafka sources and sinks through a pluggable client" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:ingest,
+          kafka_source: [topic: "orders", brokers: ["localhost:9092"], message: :order],
+          targets: [:publish]
+        )
+        |> ActorSimulation.add_actor(:publish,
+          kafka_sink: [topic: "results", brokers: ["localhost:9092"]]
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, kafka} = Enum.find(files, fn {name, _} -> name == "kafka.go" end)
+      {_name, ingest} = Enum.find(files, fn {name, _} -> name == "ingest.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert kafka =~ "type KafkaClient interface"
+      assert kafka =~ "func (s *System) ConnectKafka(ctx context.Context, client KafkaClient)"
+      assert kafka =~ ~s|"publish": kafkaEndpoint{topic: "results"|
+      assert ingest =~ "func (a *Ingest) ingestKafka(r KafkaRecord)"
+      assert ingest =~ "\t\t\ta.Order()"
+      assert test_file =~ "func TestKafkaConnectsSourcesAndSinks(t *testing.T)"
+
+      assert_raise ArgumentError, ~r/invalid kafka_source/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:ingest,
+          send_pattern: {:rate, 50, :data},
+          kafka_source: [topic: "orders", brokers: ["localhost:9092"]],
+          targets: [:publish]
+        )
+        |> ActorSimulation.add_actor(:publish)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "ages queued priorities so low priority messages don't starve" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server,
+          priority_aging: [step: 1, every: 100],
+          service_time: 10
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, server} = Enum.find(files, fn {name, _} -> name == "server.go" end)
+      {_name, queue} = Enum.find(files, fn {name, _} -> name == "fairqueue.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert server =~ "a.queue.PushAged(0, h.Priority, 0, a.sys.clock.Now(), func() {"
+      assert server =~ "a.queue.PopAged(a.sys.clock.Now(), 1, 100 * time.Millisecond)"
+      assert queue =~ "func (q *FairQueue) PopAged("
+      assert test_file =~ "func TestServerAgesLowPriorityMessages(t *testing.T)"
+
+      assert_raise ArgumentError, ~r/invalid priority_aging/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server,
+          fair_queue: [data: 3],
+          priority_aging: [step: 1, every: 100]
+        )
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "fires an idle timeout once an actor receives nothing for a while" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:session]
+        )
+        |> ActorSimulation.add_actor(:session, idle_timeout: 5000)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, session} = Enum.find(files, fn {name, _} -> name == "session.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert session =~ "\tOnIdle()"
+      assert session =~ "func (a *Session) IdleCount() int"
+      assert session =~ "a.idleTimer = a.sys.schedule(a, 5000 * time.Millisecond"
+      assert session =~ "func (a *Session) Data() {\n\ta.resetIdle()"
+      assert test_file =~ "func TestSessionFiresIdleAfterTimeout(t *testing.T)"
+
+      assert_raise ArgumentError, ~r/invalid idle_timeout/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          idle_timeout: 100
+        )
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "reports in a versioned JSON schema for tooling" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "reportjson.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert report =~ "const ReportSchemaVersion = 1"
+      assert report =~ "func (s *System) ReportJSON() []byte"
+
+      assert report =~
+               ~s|{Name: "source", Targets: []string{"sink"}, SendPattern: "{:rate, 50, :data}"}|
+
+      assert report =~ ~s|{Name: "sink", Targets: []string{}}|
+      assert main =~ "os.Getenv(\"REPORT_JSON\")"
+      assert test_file =~ "func TestReportJSONFlagsLatencyRegressions(t *testing.T)"
+    end
+
+    test "gives up on an actor that crashes past its restart budget" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink, restart: [max: 3, within: 10_000])
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, crash} = Enum.find(files, fn {name, _} -> name == "crash.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert crash =~ "func (b *restartBudget) spend(now time.Duration) bool"
+      assert crash =~ "func (s *System) SupervisorEvents() []SupervisorEvent"
+      assert system =~ "a.restarts = &restartBudget{max: 3, within: 10000 * time.Millisecond"
+      assert test_file =~ "func TestSinkExhaustsRestartBudget(t *testing.T)"
+
+      assert_raise ArgumentError, ~r/invalid restart/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:server,
+          fair_queue: [data: 1],
+          restart: [max: 3, within: 10]
+        )
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "applies filter and map transforms on an edge before acting on its target" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink, :audit],
+          transforms: [sink: [filter: :even, map: :double]]
+        )
+        |> ActorSimulation.add_actor(:sink)
+        |> ActorSimulation.add_actor(:audit)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, transform} = Enum.find(files, fn {name, _} -> name == "transform.go" end)
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, callbacks} = Enum.find(files, fn {name, _} -> name == "source_callbacks.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert transform =~ "type EdgeMessage struct"
+      assert source =~ "FilterEven(m EdgeMessage) bool"
+      assert source =~ "\t\tif !a.transform(target, \"data\", payload) {\n"
+      assert source =~ "m.Payload = a.callbacks.MapDouble(m)"
+      assert callbacks =~ "func (c *DefaultSourceCallbacks) MapDouble(m EdgeMessage) any"
+      assert test_file =~ "func TestSourceTransformsEdge(t *testing.T)"
+
+      assert_raise ArgumentError, ~r/invalid transforms/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink],
+          transforms: [audit: [filter: :even]]
+        )
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+
+      assert_raise ArgumentError, ~r/need callbacks to supply them/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test", enable_callbacks: false)
+      end
+    end
+
+    test "reports progress every interval of clock time" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, progress} = Enum.find(files, fn {name, _} -> name == "progress.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert progress =~ "func (s *System) OnProgress(interval time.Duration"
+      assert main =~ "os.Getenv(\"PROGRESS\")"
+      assert test_file =~ "func TestProgressReportedEveryInterval"
+      assert test_file =~ "sys.OnProgress(100 * time.Millisecond, func(p ProgressInfo) {"
+    end
+
+    test "releases each source's messages in order behind a reorder buffer" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:stage],
+          delay: {:uniform, 5, 40}
+        )
+        |> ActorSimulation.add_actor(:stage, targets: [:sink], delivery: :at_least_once)
+        |> ActorSimulation.add_actor(:sink, reorder_by: :seq)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, reorder} = Enum.find(files, fn {name, _} -> name == "reorder.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert reorder =~ "func (r *reorderBuffer) admit(source string, key uint64"
+      assert sink =~ "\treorder reorderBuffer\n"
+      assert sink =~ "if !a.reorder.admit(h.id.Source, h.key, func() {"
+      assert sink =~ "func (a *Sink) ReorderStats() ReorderStats"
+      assert report =~ "s.sink.ReorderStats().Stale"
+      assert report =~ "\tReorders []ReorderReport\n"
+      assert test_file =~ "func TestSinkReleasesInOrder"
+
+      assert_raise ArgumentError, ~r/invalid reorder_by/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:sink, reorder_by: :seq, parallelism: 2)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "iterates observed messages behind a Go 1.23 build constraint" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink, observe: 5)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, messages} = Enum.find(files, fn {name, _} -> name == "messages.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "messages_test.go" end)
+
+      assert String.starts_with?(messages, "//go:build go1.23\n\n")
+      assert messages =~ "func (a *Sink) Messages() iter.Seq[Msg]"
+      assert String.starts_with?(test_file, "//go:build go1.23\n\n")
+      assert test_file =~ "for msg := range sys.sink.Messages() {"
+      assert test_file =~ "if yielded != 100 {"
+
+      {:ok, unobserved} =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+
+      refute Enum.any?(unobserved, fn {name, _} -> name == "messages.go" end)
+    end
+
+    test "gates real-clock timers on Start and tests concurrent runs for races" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+      {_name, ci} = Enum.find(files, fn {name, _} -> name == ".github/workflows/ci.yml" end)
+
+      assert system =~ "\t\t<-s.started\n"
+      assert system =~ "func (s *System) Stop()"
+      assert system =~ "\ts.stopper.stop()\n"
+      assert system =~ "\tto.Act(from, s.stopper.track(deliver))\n"
+      assert test_file =~ "func TestRealClockRunIsRaceFree"
+      assert test_file =~ "NewSystem(1, NewRealClockWithSpeed(100))"
+      assert ci =~ "go test -race -v ./..."
+    end
+
+    test "routes a session's messages to one target with affinity" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:load_balancer,
+          send_pattern: {:rate, 50, :request},
+          targets: [:server1, :server2, :server3],
+          affinity_by: {:session, within: 30_000, sessions: 20}
+        )
+        |> ActorSimulation.add_actor(:server1)
+        |> ActorSimulation.add_actor(:server2)
+        |> ActorSimulation.add_actor(:server3)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      names = Enum.map(files, fn {name, _} -> name end)
+      {_name, balancer} = Enum.find(files, fn {name, _} -> name == "load_balancer.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert "affinity.go" in names
+      assert balancer =~ "stick(&a.affinity, a.header.key, a.sys.clock.Now(), a.targets)"
+      assert balancer =~ "func (a *LoadBalancer) AffinityCounts() map[string]AffinityCount"
+      assert system =~ "affinity{window: 30000 * time.Millisecond, sessions: 20}"
+      assert test_file =~ "func TestLoadBalancerKeepsSessionsOnOneTarget"
+
+      weighted =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:load_balancer,
+          send_pattern: {:rate, 50, :request},
+          targets: [:server1],
+          affinity_by: {:session, within: 30_000},
+          weight_schedule: [server1: [%{at: 0, w: 1}]]
+        )
+        |> ActorSimulation.add_actor(:server1)
+
+      assert_raise ArgumentError, ~r/invalid affinity_by/, fn ->
+        PhonyGenerator.generate(weighted, project_name: "test")
+      end
+    end
+
+    test "probes an edge without callbacks, passing messages on unchanged" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:tap],
+          size: 1500
+        )
+        |> ActorSimulation.add_actor(:tap, targets: [:stage1], probe: true)
+        |> ActorSimulation.add_actor(:stage1)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      names = Enum.map(files, fn {name, _} -> name end)
+      {_name, tap} = Enum.find(files, fn {name, _} -> name == "tap.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert "probe.go" in names
+      refute "tap_callbacks.go" in names
+      refute tap =~ "callbacks"
+      assert tap =~ "\ta.probe.add(\"data\", 1500)\n"
+      assert tap =~ "\t\ta.bytes.add(target, 1500)\n"
+      assert tap =~ "func (a *Tap) ProbeStats() ProbeStats"
+      assert test_file =~ "func TestTapProbesEdge"
+
+      fanned_in =
+        simulation
+        |> ActorSimulation.add_actor(:other, send_pattern: {:rate, 10, :data}, targets: [:tap])
+
+      assert_raise ArgumentError, ~r/probes an edge/, fn ->
+        PhonyGenerator.generate(fanned_in, project_name: "test")
+      end
+    end
+
+    test "rejects fair queue weights for messages an actor never receives" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:bulk, send_pattern: {:rate, 100, :data}, targets: [:server])
+        |> ActorSimulation.add_actor(:server, fair_queue: [control: 2])
+
+      assert_raise ArgumentError, ~r/:server weights :control/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "generates a seed sweep that checks lossy edges across seeds" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 50, :data},
+          targets: [:sub1, :sub2],
+          loss: [sub2: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}]
+        )
+        |> ActorSimulation.add_actor(:sub1)
+        |> ActorSimulation.add_actor(:sub2)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, sweep} = Enum.find(files, fn {name, _} -> name == "sweep.go" end)
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert sweep =~ "func SweepSeeds(n int, check func(*System) error) error"
+      assert sweep =~ ~s|fmt.Errorf("seed %d: %w", seed, err)|
+      assert source =~ "func (a *Source) LostCount() int"
+
+      assert test_file =~ "\t\"fmt\"\n"
+      assert test_file =~ "err := SweepSeeds(100, func(sys *System) error {"
+      # 20 messages a second to each of two targets
+      assert test_file =~ "sys.source.SendCount() + sys.source.LostCount(); n != 40"
+    end
+
+    test "generates a Gilbert-Elliott loss model per edge" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1, :sub2],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.05, p_bad_to_good: 0.4, loss_bad: 0.9}
+        )
+        |> ActorSimulation.add_actor(:sub1)
+        |> ActorSimulation.add_actor(:sub2)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test", seed: 7)
+
+      filenames = Enum.map(files, fn {name, _content} -> name end)
+      assert "loss.go" in filenames
+
+      {_name, loss} = Enum.find(files, fn {name, _} -> name == "loss.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, publisher} = Enum.find(files, fn {name, _} -> name == "publisher.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert loss =~ "type GilbertElliott struct"
+      assert loss =~ "func (g *GilbertElliott) Drop() bool"
+
+      # One independent state machine per edge, each on an RNG stream of its own
+      assert system =~
+               "s.publisher.loss = []*GilbertElliott{" <>
+                 "NewGilbertElliott(s.Stream(\"loss/publisher/sub1\"), 0.05, 0.4, 0.0, 0.9), " <>
+                 "NewGilbertElliott(s.Stream(\"loss/publisher/sub2\"), 0.05, 0.4, 0.0, 0.9)}"
+
+      assert publisher =~ "a.loss[i].Drop()"
+      assert publisher =~ "a.lostCount++"
+      assert main =~ "NewSystem(7, NewRealClock())"
+      assert test_file =~ "func TestGilbertElliottIsReproducible"
+    end
+
+    test "limits loss to the edges listed per target" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1, :sub2],
+          loss: [sub2: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}]
+        )
+        |> ActorSimulation.add_actor(:sub1)
+        |> ActorSimulation.add_actor(:sub2)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+
+      assert system =~
+               "s.publisher.loss = []*GilbertElliott{nil, " <>
+                 "NewGilbertElliott(s.Stream(\"loss/publisher/sub2\"), 0.1, 0.5, 0.0, 1.0)}"
+    end
+
+    test "omits the loss model when no actor declares loss" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1]
+        )
+        |> ActorSimulation.add_actor(:sub1)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      filenames = Enum.map(files, fn {name, _content} -> name end)
+      refute "loss.go" in filenames
+
+      {_name, publisher} = Enum.find(files, fn {name, _} -> name == "publisher.go" end)
+      refute publisher =~ "GilbertElliott"
+    end
+
+    test "rejects loss probabilities outside 0.0..1.0" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1],
+          loss: {:gilbert_elliott, p_good_to_bad: 1.5, p_bad_to_good: 0.5}
+        )
+        |> ActorSimulation.add_actor(:sub1)
+
+      assert_raise ArgumentError, ~r/:publisher.*1.5/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "delays edges with latencies drawn from a distribution" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:stage],
+          delay: {:exponential, 30}
+        )
+        |> ActorSimulation.add_actor(:stage,
+          targets: [:sink1, :sink2],
+          delay: [sink2: {:uniform, 5, 15}]
+        )
+        |> ActorSimulation.add_actor(:sink1)
+        |> ActorSimulation.add_actor(:sink2)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      filenames = Enum.map(files, fn {name, _content} -> name end)
+      assert "delay.go" in filenames
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~
+               "s.source.delay = []Delay{" <>
+                 "NewExponentialDelay(s.Stream(\"delay/source/stage\"), 30 * time.Millisecond)}"
+
+      assert system =~
+               "s.stage.delay = []Delay{ConstantDelay(0), " <>
+                 "NewUniformDelay(s.Stream(\"delay/stage/sink2\"), " <>
+                 "5 * time.Millisecond, 15 * time.Millisecond)}"
+
+      assert source =~ "a.sys.sendAfter(a, target, a.delay[i].Sample(), func() { target.Data() })"
+      assert test_file =~ "func TestDelayDistributions"
+      # The source sends on schedule, but arrivals downstream depend on delays
+      assert test_file =~ "h.AssertSendCount(sys.source, 50)"
+      refute test_file =~ "h.AssertSendCount(sys.stage,"
+    end
+
+    test "rejects unsupported delay distributions" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:sink],
+          delay: {:pareto, 30}
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      assert_raise ArgumentError, ~r/:source has unsupported delay \{:pareto, 30\}/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "generates real and virtual clocks" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, clock} = Enum.find(files, fn {name, _} -> name == "clock.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+
+      assert clock =~ "type Clock interface"
+      assert clock =~ "func NewRealClock() *RealClock"
+      assert clock =~ "func (c *VirtualClock) Advance(d time.Duration)"
+      assert system =~ "func NewSystem(seed int64, clock Clock) *System"
+      assert system =~ "_, s.virtual = clock.(*VirtualClock)"
+    end
+
+    test "speeds up the real clock from SPEED" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, clock} = Enum.find(files, fn {name, _} -> name == "clock.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert clock =~ "func NewRealClockWithSpeed(speed float64) *RealClock"
+      assert clock =~ "time.AfterFunc(time.Duration(float64(d)/c.speed), f)"
+      assert main =~ "os.Getenv(\"SPEED\")"
+      assert main =~ "sys := NewSystem(42, clock)"
+      assert test_file =~ "func TestRealClockSpeed"
+    end
+
+    test "saves reports with a codec chosen by CODEC" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, codec} = Enum.find(files, fn {name, _} -> name == "codec.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert codec =~ "type Codec interface"
+      assert codec =~ ~s(var Codecs = map[string]Codec{"json": JSONCodec, "gob": GobCodec})
+      assert codec =~ "func (s *System) SaveReport(w io.Writer, c Codec) error"
+      assert codec =~ "func LoadReport(r io.Reader, c Codec) (Report, error)"
+      assert main =~ "os.Getenv(\"CODEC\")"
+      assert main =~ "sys.SaveReport(f, codec)"
+      refute main =~ "select {}"
+      assert test_file =~ "func TestReportCodecsRoundTrip"
+    end
+
+    test "partitions and heals groups of actors" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:load_balancer,
+          send_pattern: {:rate, 20, :request},
+          targets: [:server2, :server3],
+          timeout: 50,
+          fallback: :server1
+        )
+        |> ActorSimulation.add_actor(:server1)
+        |> ActorSimulation.add_actor(:server2)
+        |> ActorSimulation.add_actor(:server3)
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation,
+          project_name: "test",
+          partitions: [
+            [at: 1000, heal: 2000, groups: [[:load_balancer, :server1], [:server2, :server3]]]
+          ]
+        )
+
+      {_name, partition} = Enum.find(files, fn {name, _} -> name == "partition.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert partition =~ "func (s *System) Partition(groups ...[]string) error"
+      assert partition =~ "func (s *System) SchedulePartitions(plan []PartitionStep) error"
+
+      assert partition =~
+               ~s({At: 1000 * time.Millisecond, Heal: 2000 * time.Millisecond, ) <>
+                 ~s(Groups: [][]string{{"load_balancer", "server1"}, {"server2", "server3"}}})
+
+      assert system =~ "if s.cut(from, to) {"
+      assert main =~ "sys.SchedulePartitions(PartitionPlan)"
+      assert test_file =~ "func TestPartitionHeals"
+
+      assert_raise ArgumentError, ~r/unknown actor :server4/, fn ->
+        PhonyGenerator.generate(simulation,
+          project_name: "test",
+          partitions: [[at: 0, heal: 10, groups: [[:server4]]]]
+        )
+      end
+    end
+
+    test "crashes and restarts actors, losing their state and in-flight messages" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 20, :request},
+          targets: [:database, :queue]
+        )
+        |> ActorSimulation.add_actor(:database)
+        |> ActorSimulation.add_actor(:queue, fair_queue: [request: 1])
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation,
+          project_name: "test",
+          crashes: [[actor: :database, at: 1000, restart: 1500]]
+        )
+
+      {_name, crash} = Enum.find(files, fn {name, _} -> name == "crash.go" end)
+      {_name, database} = Enum.find(files, fn {name, _} -> name == "database.go" end)
+      {_name, queue} = Enum.find(files, fn {name, _} -> name == "queue.go" end)
+      {_name, sleep} = Enum.find(files, fn {name, _} -> name == "sleep.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert crash =~ "func (s *System) Crash(name string, downtime time.Duration) error"
+      assert crash =~ "func (s *System) LostMessages() []LostMessage"
+
+      assert crash =~
+               ~s({Actor: "database", At: 1000 * time.Millisecond, Restart: 1500 * time.Millisecond})
+
+      assert crash =~ ~s[return nil, fmt.Errorf("cannot crash %q: it serves a fair queue", name)]
+      assert database =~ "a.callbacks = &DefaultDatabaseCallbacks{Ctx: &a.ctx}"
+      assert database =~ "func (a *Database) restart()"
+      refute queue =~ "func (a *Queue) restart()"
+      assert sleep =~ "func (c *Context) Persist(state []byte)"
+      assert main =~ "sys.ScheduleCrashes(CrashPlan)"
+      assert test_file =~ "func TestDatabaseRecoversFromCrash"
+
+      for {crashes, message} <- [
+            {[[actor: :queue, at: 0, restart: 10]], ~r/:queue can't crash: it serves a fair queue/},
+            {[[actor: :database, at: 10, restart: 0]], ~r/invalid crash/},
+            {[[actor: :cache, at: 0, restart: 10]], ~r/invalid crash/}
+          ] do
+        assert_raise ArgumentError, message, fn ->
+          PhonyGenerator.generate(simulation, project_name: "test", crashes: crashes)
+        end
+      end
+    end
+
+    test "samples callback logging to avoid floods" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:burst,
+          send_pattern: {:burst, 100, 50, :batch},
+          targets: [:processor]
+        )
+        |> ActorSimulation.add_actor(:processor)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test", log_every: 100)
+
+      {_name, log} = Enum.find(files, fn {name, _} -> name == "log.go" end)
+      {_name, callbacks} = Enum.find(files, fn {name, _} -> name == "processor_callbacks.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert log =~ "func (c *Context) Logf(format string, args ...any)"
+      assert log =~ "func (s *System) SetLogEvery(n int) error"
+      assert callbacks =~ ~s[c.Ctx.Logf("Processor: Received batch message\\n")]
+      assert system =~ "\ts.logs.every.Store(100)\n"
+      assert main =~ ~s[os.Getenv("LOG_EVERY")]
+      assert main =~ "sys.SuppressedLogs()"
+      assert test_file =~ "func TestLogsAreSampled"
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation, project_name: "test", enable_callbacks: false)
+
+      refute Enum.any?(files, fn {name, _} -> name == "log.go" end)
+
+      assert_raise ArgumentError, ~r/log_every must be a positive integer/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test", log_every: 0)
+      end
+    end
+
+    test "ramps a send rate to find where the system falls behind" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:load_balancer,
+          send_pattern: {:rate, 10, :request},
+          targets: [:server1, :server2],
+          ramp: [to: 1000, over: 30_000]
+        )
+        |> ActorSimulation.add_actor(:server1, targets: [:database])
+        |> ActorSimulation.add_actor(:server2, targets: [:database])
+        |> ActorSimulation.add_actor(:database, fair_queue: [request: 1], service_time: 1)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, ramp} = Enum.find(files, fn {name, _} -> name == "ramp.go" end)
+      {_name, balancer} = Enum.find(files, fn {name, _} -> name == "load_balancer.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert ramp =~ "func (r Ramp) Rate(elapsed time.Duration) float64"
+      assert ramp =~ "func (s *System) findBreakpoint(rp *ramping, elapsed time.Duration)"
+
+      assert balancer =~
+               ~s[a.sys.ramp(a, "load_balancer", 100 * time.Millisecond, ] <>
+                 "Ramp{From: 10, To: 1000, Over: 30000 * time.Millisecond, Breakpoint: 100}"
+
+      assert report =~ "\tr.Ramps = s.rampReports()\n"
+      assert test_file =~ "func TestLoadBalancerRampsSendRate"
+      # The database serves 1000 a second and gets two of each request
+      assert test_file =~ "limit := 1000.0 / 2"
+      refute test_file =~ "func TestReconfigureLive"
+
+      {:ok, files} =
+        simulation
+        |> ActorSimulation.add_actor(:probe,
+          send_pattern: {:rate, 200, :request},
+          targets: [:database],
+          ramp: [to: 20, over: 3000, curve: :exponential]
+        )
+        |> PhonyGenerator.generate(project_name: "test")
+
+      {_name, probe} = Enum.find(files, fn {name, _} -> name == "probe.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert probe =~ "Over: 3000 * time.Millisecond, Exponential: true, Breakpoint: 100}"
+      # A second source loads the database as well
+      refute test_file =~ "limit := "
+
+      {:ok, files} =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 10, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+
+      refute Enum.any?(files, fn {name, _} -> name == "ramp.go" end)
+
+      for {pattern, ramp, message} <- [
+            {{:rate, 10, :data}, [to: 0, over: 1000], ~r/invalid ramp/},
+            {{:rate, 10, :data}, [to: 100, over: 1000, curve: :step], ~r/invalid ramp/},
+            {{:periodic, 100, :data}, [to: 100, over: 1000], ~r/can only ramp a \{:rate/}
+          ] do
+        assert_raise ArgumentError, message, fn ->
+          ActorSimulation.new()
+          |> ActorSimulation.add_actor(:source,
+            send_pattern: pattern,
+            targets: [:sink],
+            ramp: ramp
+          )
+          |> ActorSimulation.add_actor(:sink)
+          |> PhonyGenerator.generate(project_name: "test")
+        end
+      end
+    end
+
+    test "routes by edge weights that change over virtual time" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:balancer,
+          send_pattern: {:rate, 50, :request},
+          targets: [:server1, :server2, :server3],
+          weight_schedule: [
+            server1: [%{at: 0, w: 3}],
+            server3: [%{at: 0, w: 5}, %{at: 2000, w: 0}, %{at: 5000, w: 2}]
+          ]
+        )
+        |> ActorSimulation.add_actor(:server1)
+        |> ActorSimulation.add_actor(:server2)
+        |> ActorSimulation.add_actor(:server3)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, routing} = Enum.find(files, fn {name, _} -> name == "routing.go" end)
+      {_name, balancer} = Enum.find(files, fn {name, _} -> name == "balancer.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert routing =~
+               "func route[T phony.Actor](r *router, now time.Duration, targets []T) map[int]T"
+      assert balancer =~ "\tpicked := route(&a.router, a.sys.clock.Now(), a.targets)\n"
+      assert balancer =~ "func (a *Balancer) RoutedCounts() map[string]int"
+
+      assert system =~
+               "s.server3: {{At: 0 * time.Millisecond, Weight: 5}, " <>
+                 "{At: 2000 * time.Millisecond, Weight: 0}, " <>
+                 "{At: 5000 * time.Millisecond, Weight: 2}}"
+
+      assert test_file =~ "func TestBalancerShiftsRoutingWeights"
+      # Drained from 2s to 5s, server3 gets nothing in between
+      assert test_file =~
+               "{2000 * time.Millisecond, map[string]int{" <>
+                 ~s("server1": 34, "server2": 11, "server3": 55}})
+
+      assert test_file =~
+               "{5000 * time.Millisecond, map[string]int{" <>
+                 ~s("server1": 146, "server2": 49, "server3": 55}})
+      refute test_file =~ "h.AssertSendCount(sys.balancer"
+
+      {:ok, files} =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 10, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+
+      refute Enum.any?(files, fn {name, _} -> name == "routing.go" end)
+
+      for {schedule, message} <- [
+            {[sink: [%{at: 10, w: 1}]], ~r/invalid weight_schedule/},
+            {[sink: [%{at: 0, w: 1}, %{at: 0, w: 2}]], ~r/invalid weight_schedule/},
+            {[sink: [%{at: 0, w: -1}]], ~r/invalid weight_schedule/},
+            {[other: [%{at: 0, w: 1}]], ~r/which it doesn't target/}
+          ] do
+        assert_raise ArgumentError, message, fn ->
+          ActorSimulation.new()
+          |> ActorSimulation.add_actor(:source,
+            send_pattern: {:rate, 10, :data},
+            targets: [:sink],
+            weight_schedule: schedule
+          )
+          |> ActorSimulation.add_actor(:sink)
+          |> PhonyGenerator.generate(project_name: "test")
+        end
+      end
+    end
+
+    test "routes by static target weights" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:balancer,
+          send_pattern: {:rate, 50, :request},
+          targets: [{:server1, weight: 5}, :server2, {:server3, weight: 0}]
+        )
+        |> ActorSimulation.add_actor(:server1)
+        |> ActorSimulation.add_actor(:server2)
+        |> ActorSimulation.add_actor(:server3)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~ "s.balancer.targets = []BalancerTarget{s.server1, s.server2, s.server3}"
+
+      assert system =~
+               "s.server1: {{At: 0 * time.Millisecond, Weight: 5}}, " <>
+                 "s.server3: {{At: 0 * time.Millisecond, Weight: 0}}"
+
+      # 6000 requests at 50 a second, shared 5:1:0
+      assert test_file =~ "func TestBalancerSplitsByWeight"
+      assert test_file =~ "runUntil(t, sys, 120000 * time.Millisecond)"
+      assert test_file =~ ~s|map[string]int{"server1": 5000, "server2": 1000, "server3": 0}|
+      refute test_file =~ "func TestBalancerShiftsRoutingWeights"
+
+      assert_raise ArgumentError, ~r/weights its targets and has a weight_schedule/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 10, :data},
+          targets: [{:sink, weight: 2}],
+          weight_schedule: [sink: [%{at: 0, w: 1}]]
+        )
+      end
+    end
+
+    test "counts the bytes each edge carries" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 10, :data},
+          targets: [:relay],
+          size: 1500
+        )
+        |> ActorSimulation.add_actor(:relay, targets: [:sink], size: [data: 100, ack: 64])
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, size} = Enum.find(files, fn {name, _} -> name == "size.go" end)
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, relay} = Enum.find(files, fn {name, _} -> name == "relay.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert size =~ "func (r Report) Bytes() int64"
+      assert source =~ "\t\ta.bytes.add(target, 1500)\n"
+      assert source =~ "func (a *Source) BytesSent() map[string]int64"
+      assert relay =~ "\t\ta.bytes.add(target, 100)\n"
+      assert report =~ "\tLinks []LinkReport\n"
+      assert report =~ ~s(\tr.link(name, a.BytesSent())\n)
+      assert test_file =~ "func TestBytesSentPerLink"
+      # 10 messages of 1500 bytes in the first second
+      assert test_file =~ "link.Bytes != 15000"
+      assert test_file =~ "got != 15000"
+
+      {:ok, files} =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 10, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+
+      refute Enum.any?(files, fn {name, _} -> name == "size.go" end)
+
+      for size <- [0, [data: -1], [], "1500"] do
+        assert_raise ArgumentError, ~r/invalid size/, fn ->
+          ActorSimulation.new()
+          |> ActorSimulation.add_actor(:source,
+            send_pattern: {:rate, 10, :data},
+            targets: [:sink],
+            size: size
+          )
+          |> ActorSimulation.add_actor(:sink)
+          |> PhonyGenerator.generate(project_name: "test")
+        end
+      end
+    end
+
+    test "acks messages back along the reverse path" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 10, :data},
+          targets: [:relay],
+          ack_path: :reverse
+        )
+        |> ActorSimulation.add_actor(:relay, targets: [:sink], delay: {:constant, 5})
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, ack} = Enum.find(files, fn {name, _} -> name == "ack.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, relay} = Enum.find(files, fn {name, _} -> name == "relay.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert ack =~ "func (s *System) relay(from phony.Actor, id MessageID, p hops)"
+      assert system =~ "\tpath hops\n"
+      assert system =~ "\th.path = h.path.through(from, d)\n"
+      assert source =~ "func (a *Source) acked(id MessageID)"
+      assert source =~ "func (a *Source) Outstanding() int"
+      refute relay =~ "a.sys.ack("
+      assert sink =~ "\ta.sys.ack(a, a.header)\n"
+      assert test_file =~ "func TestAcksReturnAlongReversePath"
+      assert test_file =~ "acked+outstanding != 10"
+
+      {:ok, files} =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 10, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+
+      refute Enum.any?(files, fn {name, _} -> name == "ack.go" end)
+
+      assert_raise ArgumentError, ~r/unsupported ack_path/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 10, :data},
+          targets: [:sink],
+          ack_path: :forward
+        )
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+
+      assert_raise ArgumentError, ~r/can't receive messages/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 10, :data},
+          targets: [:sink],
+          ack_path: :reverse
+        )
+        |> ActorSimulation.add_actor(:sink, targets: [:source])
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "records metrics on a sink passed to NewSystem" do
+      {:ok, files} =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 10, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+        |> PhonyGenerator.generate(project_name: "test")
+
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "metricsink.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert sink =~ "type MetricSink interface"
+      assert sink =~ "func NewPrometheusSink() *PrometheusSink"
+      assert sink =~ "func NewExpvarSink(name string) *ExpvarSink"
+      assert sink =~ "func NewMetricRecorder() *MetricRecorder"
+      assert system =~ "func NewSystem(seed int64, clock Clock, opts ...Option) *System"
+      assert system =~ "\t\ts.instrument(name, a)\n"
+      assert report =~ "\tc.meter.arrived(now - c.header.born)\n"
+      assert main =~ "NewSystem(42, clock, WithMetricSink(prometheus))"
+      assert main =~ ~s|http.Handle("/metrics", prometheus)|
+      assert test_file =~ "func TestMetricSinkRecordsActorMetrics"
+      assert test_file =~ "func TestPrometheusSinkWritesText"
+    end
+
+    test "derives metrics from the report's counters" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 100, :job}, targets: [:worker])
+        |> ActorSimulation.add_actor(:worker,
+          service_time: 5,
+          parallelism: 2,
+          metrics: [utilization: {:received, :/, :capacity}]
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, metrics} = Enum.find(files, fn {name, _} -> name == "expvar.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert report =~
+               ~s[r.derive(name, "utilization", func(a ActorReport) float64 { ] <>
+                 ~s[return quotient(float64(a.Received), ] <>
+                 ~s[2 * float64(r.At) / float64(5 * time.Millisecond)) })]
+
+      assert metrics =~ ~s|"metrics": s.Report().Metrics("worker")|
+      assert test_file =~ ~s(map[string][]string{"worker": {"utilization"}})
+
+      unserved =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:worker, metrics: [utilization: {:received, :/, :capacity}])
+
+      assert_raise ArgumentError, ~r/needs a service_time/, fn ->
+        PhonyGenerator.generate(unserved, project_name: "test")
+      end
+    end
+
+    test "conflates queued messages of the same key" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 5, :price},
+          targets: [:subscriber]
+        )
+        |> ActorSimulation.add_actor(:subscriber, conflate_by: :source, service_time: 40)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, subscriber} = Enum.find(files, fn {name, _} -> name == "subscriber.go" end)
+      {_name, queue} = Enum.find(files, fn {name, _} -> name == "fairqueue.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert subscriber =~ "if a.queue.Conflate(0, h.id.Source, func() {"
+      assert subscriber =~ "func (a *Subscriber) ConflatedCount() int"
+      assert queue =~ "func (q *FairQueue) Conflate(class int, key string, f func()) bool"
+      assert test_file =~ "func TestSubscriberConflatesQueuedMessages"
+
+      invalid =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:subscriber, conflate_by: :key)
+
+      assert_raise ArgumentError, ~r/expected :message or :source/, fn ->
+        PhonyGenerator.generate(invalid, project_name: "test")
+      end
+    end
+
+    test "splits latency into queue and service time" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:rate, 100, :request},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server, fair_queue: [request: 1], service_time: 20)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, server} = Enum.find(files, fn {name, _} -> name == "server.go" end)
+      {_name, report} = Enum.find(files, fn {name, _} -> name == "report.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~ "\th.enqueued = s.clock.Now()\n"
+      assert system =~ "\t\t\tc.handled(h.enqueued, start, s.clock.Now())\n"
+      assert server =~ "\t\ta.handled(a.header.enqueued, start, a.sys.clock.Now())\n"
+      assert server =~ "func (a *Server) QueueTimeStats() TimeStats"
+      assert server =~ "func (a *Server) ServiceTimeStats() TimeStats"
+      assert report =~ "PEAK QUEUE\\tQUEUE P50\\tSERVICE P50"
+      assert test_file =~ "func TestServerSplitsQueueAndServiceTime"
+    end
+
+    test "sheds queued messages past their deadline" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:rate, 100, :request},
+          targets: [:server],
+          deadline: 50
+        )
+        |> ActorSimulation.add_actor(:server, deadline_aware: true, service_time: 20)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, server} = Enum.find(files, fn {name, _} -> name == "server.go" end)
+      {_name, queue} = Enum.find(files, fn {name, _} -> name == "fairqueue.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~ "func newClient(s *System) *Client {\n\ta := &Client{sys: s}\n\ta.budget = 50 * time.Millisecond\n"
+      assert server =~ "a.queue.PushDue(0, h.Deadline, func() {"
+      assert server =~ "if n := a.queue.Shed(a.sys.clock.Now()); n > 0 {"
+      assert queue =~ "func (q *FairQueue) Shed(now time.Duration) int"
+      assert test_file =~ "func TestServerShedsStaleMessages"
+
+      conflating =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:server, deadline_aware: true, conflate_by: :message)
+
+      assert_raise ArgumentError, ~r/can't both conflate and shed/, fn ->
+        PhonyGenerator.generate(conflating, project_name: "test")
+      end
+
+      silent =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:server, deadline: 50)
+
+      assert_raise ArgumentError, ~r/originates no messages/, fn ->
+        PhonyGenerator.generate(silent, project_name: "test")
+      end
+    end
+
+    test "generates actor interfaces on request" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:load_balancer,
+          send_pattern: {:periodic, 10, :request},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test", interfaces: true)
+
+      {_name, server} = Enum.find(files, fn {name, _} -> name == "server.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert server =~ "type ServerIface interface {\n\tphony.Actor\n\tcontextual\n\tRequest()\n}"
+      assert server =~ "var _ ServerIface = (*Server)(nil)"
+      assert test_file =~ "var _ ServerIface = (*recordingServer)(nil)"
+      assert test_file =~ "func TestLoadBalancerSendsToMockServer"
+
+      {:ok, plain} = PhonyGenerator.generate(simulation, project_name: "test")
+      {_name, server} = Enum.find(plain, fn {name, _} -> name == "server.go" end)
+
+      refute server =~ "ServerIface"
+    end
+
+    test "resends dead letters until they fail for good" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 20, :request},
+          targets: [:server],
+          dlq_retry: [interval: 500, max: 3]
+        )
+        |> ActorSimulation.add_actor(:server)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, client} = Enum.find(files, fn {name, _} -> name == "client.go" end)
+      {_name, dead_letters} = Enum.find(files, fn {name, _} -> name == "deadletter.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert client =~ ~s|a.deadLetter("request", target, f)|
+      assert client =~ "a.sys.after(a, 500 * time.Millisecond, a.reap)"
+      assert client =~ "if a.dead.retry(l, 3, a.sys.clock.Now()) {"
+      assert dead_letters =~ "type DeadLetter struct {"
+      assert test_file =~ "func TestClientReprocessesDeadLetters"
+      refute test_file =~ "func TestPartitionHeals"
+
+      timed =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 20, :request},
+          targets: [:server],
+          timeout: 50,
+          fallback: :server,
+          dlq_retry: [interval: 500, max: 3]
+        )
+        |> ActorSimulation.add_actor(:server)
+
+      assert_raise ArgumentError, ~r/already recovers dropped messages/, fn ->
+        PhonyGenerator.generate(timed, project_name: "test")
+      end
+
+      invalid =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client, targets: [:server], dlq_retry: [interval: 500])
+        |> ActorSimulation.add_actor(:server)
+
+      assert_raise ArgumentError, ~r/expected \[interval: ms, max: attempts\]/, fn ->
+        PhonyGenerator.generate(invalid, project_name: "test")
+      end
+    end
+
+    test "raises alarms on an actor's send rate" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:burst_generator,
+          send_pattern: {:burst, 10, 1000, :batch},
+          targets: [:processor],
+          alarm: [{:rate, :>, 50}, window: 100]
+        )
+        |> ActorSimulation.add_actor(:processor)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "burst_generator.go" end)
+      {_name, callbacks} =
+        Enum.find(files, fn {name, _} -> name == "burst_generator_callbacks.go" end)
+      {_name, alarm} = Enum.find(files, fn {name, _} -> name == "alarm.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert source =~ "OnAlarm(metric string, value float64)"
+      assert source =~ "a.alarm = rateAlarm{window: 100 * time.Millisecond, above: 50}"
+      assert source =~ "a.sys.watch(a, a.alarm.window, a.checkAlarm)"
+      assert source =~ "a.callbacks.OnAlarm(alarm.Metric, alarm.Value)"
+      assert callbacks =~ "func (c *DefaultBurstGeneratorCallbacks) OnAlarm(metric string, value float64)"
+      assert alarm =~ "func (r *rateAlarm) check(sent int, now time.Duration) (Alarm, bool)"
+      assert test_file =~ "func TestBurstGeneratorRaisesAlarms"
+      assert test_file =~ "expected a burst of 10 sends within 100ms to cross 50/s"
+
+      {_name, processor} = Enum.find(files, fn {name, _} -> name == "processor.go" end)
+      refute processor =~ "OnAlarm"
+
+      invalid_alarms = [{:rate, :>, 0}, [{:rate, :>, 5}, {:rate, :>, 9}], [{:rate, :>, 5}, every: 10]]
+
+      for alarm <- invalid_alarms do
+        invalid =
+          ActorSimulation.new()
+          |> ActorSimulation.add_actor(:source,
+            send_pattern: {:periodic, 100, :tick},
+            targets: [:sink],
+            alarm: alarm
+          )
+          |> ActorSimulation.add_actor(:sink)
+
+        assert_raise ArgumentError, ~r/has invalid alarm/, fn ->
+          PhonyGenerator.generate(invalid, project_name: "test")
+        end
+      end
+
+      overlapping =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :tick},
+          targets: [:sink],
+          alarm: [{:rate, :>, 5}, {:rate, :<, 5}]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      assert_raise ArgumentError, ~r/is not under its alarm above/, fn ->
+        PhonyGenerator.generate(overlapping, project_name: "test")
+      end
+    end
+
+    test "fires named timers at their own intervals" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:api, send_pattern: {:periodic, 100, :query}, targets: [:db])
+        |> ActorSimulation.add_actor(:db, timers: [flush: 1000, compact: 10_000])
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, db} = Enum.find(files, fn {name, _} -> name == "db.go" end)
+      {_name, callbacks} = Enum.find(files, fn {name, _} -> name == "db_callbacks.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert db =~ "\tOnFlush() error\n\tOnCompact() error\n"
+      assert db =~ "a.sys.watch(a, 1000 * time.Millisecond, a.Flush)"
+      assert db =~ "a.sys.watch(a, 10000 * time.Millisecond, a.Compact)"
+      assert db =~ "func (a *Db) TimerCount(name string) int"
+      assert db =~ "\ta.fired[\"compact\"]++\n\tif err := a.callbacks.OnCompact(); err != nil {\n"
+      assert callbacks =~ "func (c *DefaultDbCallbacks) OnFlush()"
+      assert test_file =~ "func TestDbTimersFireIndependently"
+      assert test_file =~ ~s|map[string]int{"flush": 10, "compact": 1}|
+
+      for timers <- [[flush: 0], [flush: 100, flush: 200], [:flush]] do
+        assert_raise ArgumentError, ~r/has invalid timers/, fn ->
+          ActorSimulation.new()
+          |> ActorSimulation.add_actor(:db, timers: timers)
+          |> PhonyGenerator.generate(project_name: "test")
+        end
+      end
+
+      assert_raise ArgumentError, ~r/named like a message it handles/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:api, send_pattern: {:periodic, 100, :flush}, targets: [:db])
+        |> ActorSimulation.add_actor(:db, timers: [flush: 1000])
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "checks the Phony semantics generated actors rely on" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, phony_test} = Enum.find(files, fn {name, _} -> name == "phony_test.go" end)
+      {_name, ci} = Enum.find(files, fn {name, _} -> name == ".github/workflows/ci.yml" end)
+
+      assert phony_test =~ "func TestPhonyActSerializesPerInbox(t *testing.T)"
+      assert phony_test =~ "func TestPhonyActKeepsOrderFromOneSender(t *testing.T)"
+      assert phony_test =~ "func TestPhonyBlockWaitsForQueuedMessages(t *testing.T)"
+      assert ci =~ "phony: [pinned, latest]"
+      assert ci =~ "go get github.com/Arceliar/phony@${{ matrix.phony }}"
+    end
+
+    test "benchmarks Phony against channel dispatch on acyclic topologies" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:producer,
+          send_pattern: {:burst, 5, 100, :batch},
+          targets: [:consumer]
+        )
+        |> ActorSimulation.add_actor(:consumer)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, bench} = Enum.find(files, fn {name, _} -> name == "bench_test.go" end)
+
+      assert bench =~ "type ActorRuntime interface"
+      assert bench =~ "\t\"producer\": {\"consumer\"},\n\t\"consumer\": {},\n"
+      assert bench =~ "\t{\"producer\", \"batch\", 5},\n"
+      assert bench =~ "func BenchmarkDispatch(b *testing.B)"
+      assert bench =~ "func TestRuntimesHandleAlike(t *testing.T)"
+
+      cyclic =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:ping,
+          send_pattern: {:periodic, 10, :ball},
+          targets: [:pong]
+        )
+        |> ActorSimulation.add_actor(:pong, targets: [:ping])
+
+      {:ok, files} = PhonyGenerator.generate(cyclic, project_name: "test")
+
+      refute Enum.any?(files, fn {name, _} -> name == "bench_test.go" end)
+    end
+
+    test "generates tests driven by the simtest harness" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:stage]
+        )
+        |> ActorSimulation.add_actor(:stage, targets: [:sink1, :sink2])
+        |> ActorSimulation.add_actor(:sink1)
+        |> ActorSimulation.add_actor(:sink2)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "pipeline")
+
+      {_name, harness} = Enum.find(files, fn {name, _} -> name == "simtest/simtest.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+      {_name, stage} = Enum.find(files, fn {name, _} -> name == "stage.go" end)
+
+      assert harness =~ "package simtest"
+      assert harness =~ "func NewHarness(t testing.TB, system System, clock Clock) *Harness"
+      assert harness =~ "func (h *Harness) Advance(d time.Duration)"
+      assert harness =~ "func (h *Harness) AssertSendCount(actor Actor, n int)"
+      assert harness =~ "func (h *Harness) DrainQuiescent()"
+      assert stage =~ "func (a *Stage) SendCount() int"
+      assert stage =~ "func (a *Stage) Metrics() ActorMetrics"
+      assert test_file =~ "if m := sys.actors[a.Name].Metrics(); m.SendCount != a.Sent"
+
+      assert test_file =~ "\"pipeline/simtest\""
+      assert test_file =~ "h := simtest.NewHarness(t, sys, clock)"
+      assert test_file =~ "h.Advance(1000 * time.Millisecond)"
+      # 50 messages a second, fanned out to two sinks
+      assert test_file =~ "h.AssertSendCount(sys.source, 50)"
+      assert test_file =~ "h.AssertSendCount(sys.stage, 100)"
+      assert test_file =~ "h.AssertSendCount(sys.sink1, 0)"
+    end
+
+    test "waits for the system to settle before asserting" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, clock} = Enum.find(files, fn {name, _} -> name == "clock.go" end)
+      {_name, harness} = Enum.find(files, fn {name, _} -> name == "simtest/simtest.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~ "func (s *System) Pending() int"
+      assert clock =~ "func (c *VirtualClock) Step() bool"
+      assert harness =~ "func WaitQuiescent(system System, clock Clock) error"
+      assert harness =~ "func (h *Harness) AssertQuiescent()"
+      assert test_file =~ "func TestSystemSettles"
+    end
+
+    test "counts queued messages as pending" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server, fair_queue: [data: 1], service_time: 20)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert system =~ "phony.Block(s.server, func() { n += s.server.queue.Len() })"
+      # A saturated queue never settles while the source keeps sending
+      refute test_file =~ "func TestSystemSettles"
+    end
+
+    test "skips send count assertions that depend on message loss" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:stage],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}
+        )
+        |> ActorSimulation.add_actor(:stage, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      refute test_file =~ "AssertSendCount(sys.source"
+      refute test_file =~ "AssertSendCount(sys.stage"
+      assert test_file =~ "h.AssertSendCount(sys.sink, 0)"
+      assert test_file =~ "// Send count depends on loss, delays, timeouts, queuing, joins or a feedback cycle"
+    end
+
+    test "runs every handler through the middleware chain" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, middleware} = Enum.find(files, fn {name, _} -> name == "middleware.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert middleware =~ "Handle(ctx HandlerContext, msg string, next func())"
+      assert middleware =~ "func (s *System) Use(middleware ...Middleware)"
+
+      assert sink =~
+               ~s|a.sys.middleware.Handle(HandlerContext{Actor: "sink", Now: a.sys.clock.Now(), ID: a.header.id, Key: a.header.key, Headers: a.header.Headers, Payload: a.header.payload}, "data", a.handleData)|
+
+      assert sink =~ "handleData() {\n\tif err := a.callbacks.OnData(); err != nil {"
+      assert test_file =~ "func TestMiddlewareWrapsHandlers"
+      assert test_file =~ ~s|handled["source"] == 0|
+    end
+
+    test "delivers envelopes whose headers middleware reads" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 100, :data},
+          targets: [:sink]
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, envelope} = Enum.find(files, fn {name, _} -> name == "envelope.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert envelope =~ "type Envelope[T any] struct {\n\tHeaders\n\tKind string\n\tPayload T\n}"
+      assert envelope =~ "func Act[T any](s *System, name string, env Envelope[T]) error"
+      assert system =~ "type header struct {\n\tHeaders\n"
+      assert system =~ "if h.expired(s.clock.Now()) {\n\t\t\ts.ledger.expired.Add(1)"
+      assert sink =~ ~s|case "data":\n\t\treturn a.Data, true|
+      assert test_file =~ "func TestActForwardsEnvelopes"
+      assert test_file =~ ~s|Act(sys, "source", env)|
+    end
+
+    test "orders simultaneous messages by a scheduling policy" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1, :sub2]
+        )
+        |> ActorSimulation.add_actor(:sub1)
+        |> ActorSimulation.add_actor(:sub2)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, clock} = Enum.find(files, fn {name, _} -> name == "clock.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert clock =~ "FIFO Policy = iota"
+      assert clock =~ "func (c *VirtualClock) SetPolicy(p Policy)"
+      assert system =~ "func (s *System) SetPriority(name string, priority int) error"
+      assert system =~
+               "s.scheduleRanked(to, s.ranks[h.id.Source], h.Priority, 0, " <>
+                 "func() { phony.Block(to, deliver) })"
+
+      assert test_file =~ "func TestSchedulingPolicies"
+      assert test_file =~ ~s|sys.SetPriority("sub2", 1)|
+    end
+
+    test "merges two sources in declaration order" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:early,
+          send_pattern: {:periodic, 100, :tick},
+          targets: [:merger],
+          start_delay: 10
+        )
+        |> ActorSimulation.add_actor(:late,
+          send_pattern: {:periodic, 100, :tock},
+          targets: [:merger],
+          delay: {:constant, 10}
+        )
+        |> ActorSimulation.add_actor(:merger)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, clock} = Enum.find(files, fn {name, _} -> name == "clock.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert clock =~ "\tSourceOrder\n"
+      assert clock =~ "return e.rank < other.rank"
+      assert system =~ ~s|s.ranks = map[string]int{"early": 1, "late": 2, "merger": 3}|
+      assert system =~ "s.afterRanked(to, s.ranks[h.id.Source], h.Priority, d, func() {"
+      assert test_file =~ "func TestMergeOrderIsStable"
+      assert test_file =~ "expected merger to merge the messages of 2 sources"
+    end
+
+    test "breaks same-instant ties between two actors in declaration order" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:subscriber]
+        )
+        |> ActorSimulation.add_actor(:subscriber)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      # Without a merge, both actors get a message from outside at once,
+      # the later declared one first
+      assert test_file =~ "func TestMergeOrderIsStable"
+      assert test_file =~ ~s|{{"subscriber", "event"}, {"publisher", "event"}}|
+      assert test_file =~ "expected publisher before subscriber at the same instant"
+      assert test_file =~ "took their messages in a different order across runs"
+    end
+
+    test "reconfigures edges, actors and intervals at runtime" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:subscriber],
+          delay: {:constant, 5}
+        )
+        |> ActorSimulation.add_actor(:subscriber)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, reconfigure} = Enum.find(files, fn {name, _} -> name == "reconfigure.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, publisher} = Enum.find(files, fn {name, _} -> name == "publisher.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert reconfigure =~ "func (s *System) Reconfigure(spec *Spec) error"
+      assert reconfigure =~ "\tcase *Subscriber:\n\t\treturn newSubscriber(s)\n"
+      assert reconfigure =~ "\tspec = spec.clone()\n"
+      assert system =~ ~s|s.actors = map[string]actor{"publisher": s.publisher, "subscriber": s.subscriber}|
+      assert system =~ "s.schedule(to, time.Duration(t.interval.Load()), tick)"
+
+      assert publisher =~ "func (a *Publisher) connect(to phony.Actor) {"
+      # Edges added at runtime are not delayed
+      assert publisher =~ "a.delay = append(a.delay, ConstantDelay(0))"
+      refute files |> Enum.find(fn {name, _} -> name == "subscriber.go" end) |> elem(1) =~ "connect"
+
+      assert test_file =~ "func TestReconfigureLive"
+      assert test_file =~ ~s|Intervals: map[string]time.Duration{"publisher": 50 * time.Millisecond}|
+
+      assert reconfigure =~ "\tRemove []string\n"
+      assert reconfigure =~ "phony.Block(from, func() { from.disconnect(gone) })"
+      assert test_file =~ ~s|sys.Reconfigure(&Spec{Remove: []string{"subscriber_spawned"}})|
+    end
+
+    test "checks that messages are conserved" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:rate, 50, :data},
+          targets: [:stage],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}
+        )
+        |> ActorSimulation.add_actor(:stage, targets: [:sink_a, :sink_b])
+        |> ActorSimulation.add_actor(:sink_a)
+        |> ActorSimulation.add_actor(:sink_b)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, conservation} = Enum.find(files, fn {name, _} -> name == "conservation.go" end)
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, stage} = Enum.find(files, fn {name, _} -> name == "stage.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink_a.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert conservation =~ "func (s *System) CheckConservation() error"
+      assert source =~ ~s|func() { a.sys.produce(a, "source", a.Data) })|
+      assert source =~ "a.lostCount++\n\t\t\ta.sys.ledger.dropped.Add(1)"
+      assert stage =~ "a.sys.forwarded(len(a.targets))"
+      assert sink =~ "a.sys.forwarded(0)"
+
+      assert test_file =~ "func TestMessagesAreConserved"
+      assert test_file =~ ~r"sys\.produce\(sys\.\w+, \"\w+\", func\(\) \{\}\)"
+    end
+
+    test "labels actors for metrics and selection" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:front,
+          send_pattern: {:rate, 50, :get},
+          targets: [:cache1, :cache2]
+        )
+        |> ActorSimulation.add_actor(:cache1, labels: [tier: "cache", region: :eu])
+        |> ActorSimulation.add_actor(:cache2, labels: %{"tier" => "cache"})
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, cache1} = Enum.find(files, fn {name, _} -> name == "cache1.go" end)
+      {_name, front} = Enum.find(files, fn {name, _} -> name == "front.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, metrics} = Enum.find(files, fn {name, _} -> name == "expvar.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert cache1 =~ ~s|return map[string]string{"region": "eu", "tier": "cache"}|
+      assert front =~ "return map[string]string{}"
+      assert system =~ "func (s *System) Select(selector map[string]string) []string"
+      assert metrics =~ ~s|"labels": s.cache1.Labels()|
+      refute metrics =~ "s.front.Labels()"
+
+      assert test_file =~ "func TestSelectByLabels"
+      assert test_file =~ ~s|sys.Select(map[string]string{"region": "eu"})|
+    end
+
+    test "rejects labels that are not key-value pairs" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:cache, labels: ["cache"])
+
+      assert_raise ArgumentError, ~r/invalid label/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+    end
+
+    test "samples message traces on the seeded RNG" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:burst_generator,
+          send_pattern: {:burst, 10, 1000, :batch},
+          targets: [:processor]
+        )
+        |> ActorSimulation.add_actor(:processor)
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation, project_name: "test", trace_sample: 0.01)
+
+      {_name, trace} = Enum.find(files, fn {name, _} -> name == "trace.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert trace =~ "func (s *System) SetTraceSample(rate float64)"
+      assert trace =~ "func TraceLogger(logf func(format string, args ...any)) Middleware"
+      assert system =~ "\t\tto.(contextual).context().header = h\n\t\tf()"
+      assert main =~ "sys.SetTraceSample(0.01)\n\tsys.Use(TraceLogger(log.Printf))"
+
+      # 10 messages a second need 1000 seconds for about a hundred traces
+      assert test_file =~ "func TestTraceSampling"
+      assert test_file =~ "sys.Advance(1000000 * time.Millisecond)"
+    end
+
+    test "leaves tracing off by default" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 50, :data})
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      refute main =~ "SetTraceSample"
+      refute test_file =~ "TestTraceSampling"
+
+      assert_raise ArgumentError, ~r/trace_sample/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test", trace_sample: 2)
+      end
+    end
+
+    test "joins two streams by key within a window" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:orders,
+          send_pattern: {:periodic, 10, :order},
+          targets: [:matcher]
+        )
+        |> ActorSimulation.add_actor(:payments,
+          send_pattern: {:periodic, 15, :payment},
+          targets: [:matcher]
+        )
+        |> ActorSimulation.add_actor(:matcher, targets: [:books], join_by: {:key, within: 50})
+        |> ActorSimulation.add_actor(:books)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, join} = Enum.find(files, fn {name, _} -> name == "join.go" end)
+      {_name, matcher} = Enum.find(files, fn {name, _} -> name == "matcher.go" end)
+      {_name, books} = Enum.find(files, fn {name, _} -> name == "books.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert join =~ "func (w *joinWindow) add(side int, key uint64) bool"
+      assert matcher =~ "\tif a.join.add(1, a.header.key) {\n\t\ta.forwardJoined()\n\t}"
+      assert matcher =~ "type MatcherTarget interface {\n\tphony.Actor\n\tJoined()\n}"
+      assert books =~ "func (a *Books) Joined()"
+      refute books =~ "func (a *Books) Order()"
+      assert system =~ "a.join = newJoinWindow(s, a, 50 * time.Millisecond)"
+
+      assert test_file =~ "func TestMatcherJoinsWithinWindow"
+      assert test_file =~ "expected the first messages of both streams to join"
+    end
+
+    test "rejects joins that do not receive two streams" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:orders,
+          send_pattern: {:periodic, 10, :order},
+          targets: [:matcher]
+        )
+        |> ActorSimulation.add_actor(:matcher, join_by: {:key, within: 50})
+
+      assert_raise ArgumentError, ~r/joins two streams/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "test")
+      end
+
+      assert_raise ArgumentError, ~r/unsupported join/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:matcher, join_by: {:key, 50})
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "staggers the first send by start_delay" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source,
+          send_pattern: {:periodic, 10, :data},
+          targets: [:sink],
+          start_delay: 5
+        )
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, source} = Enum.find(files, fn {name, _} -> name == "source.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert source =~ "a.sys.everyAfter(a, 5 * time.Millisecond, 10 * time.Millisecond, func() {"
+      assert system =~ "func (s *System) everyAfter(to phony.Actor, first, interval time.Duration"
+
+      # Sends at 5ms, 15ms, ..., 995ms
+      assert test_file =~ "h.AssertSendCount(sys.source, 100)"
+
+      assert_raise ArgumentError, ~r/invalid start_delay/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:sink, start_delay: 5)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "stamps originated messages with reproducible IDs" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 50, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, ids} = Enum.find(files, fn {name, _} -> name == "id.go" end)
+      {_name, conservation} = Enum.find(files, fn {name, _} -> name == "conservation.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert ids =~ "func (s *System) NextID() uint64"
+      refute ids =~ "crypto/rand"
+      assert conservation =~ "header{id: MessageID{Source: name, Seq: s.NextID()}"
+      assert sink =~ "ID: a.header.id, Key: a.header.key"
+      assert test_file =~ "func TestMessageIDsAreReproducible"
+    end
+
+    test "records what an observed actor receives" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 50, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink, observe: 10)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, observe} = Enum.find(files, fn {name, _} -> name == "observe.go" end)
+      {_name, sink} = Enum.find(files, fn {name, _} -> name == "sink.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert observe =~ "type Msg struct"
+      assert sink =~ "func (a *Sink) Received() <-chan Msg"
+      assert sink =~ "\t\ta.sys.fail(\"sink\", err)\n\t\treturn\n\t}\n\ta.record(\"data\")\n"
+      assert system =~ "func newSink(s *System) *Sink {\n\ta := &Sink{sys: s}\n\ta.received = make(chan Msg, 10)\n"
+
+      # 50 messages a second, most of them past the buffer
+      assert test_file =~ "func TestSinkRecordsReceived"
+      assert test_file =~ "n := recorded + sys.sink.Unrecorded(); n != 50"
+
+      assert_raise ArgumentError, ~r/invalid observe/, fn ->
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:sink, observe: true)
+        |> PhonyGenerator.generate(project_name: "test")
+      end
+    end
+
+    test "publishes actor counters through expvar" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:publisher,
+          send_pattern: {:periodic, 100, :event},
+          targets: [:sub1],
+          loss: {:gilbert_elliott, p_good_to_bad: 0.1, p_bad_to_good: 0.5}
+        )
+        |> ActorSimulation.add_actor(:sub1)
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation, project_name: "test", metrics_addr: ":9090")
+
+      {_name, metrics} = Enum.find(files, fn {name, _} -> name == "expvar.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert metrics =~ ~s|var metrics = expvar.NewMap("gen_server_virtual_time")|
+      assert metrics =~ ~s|metrics.Set("publisher", expvar.Func(func() any {|
+
+      assert metrics =~
+               ~s|map[string]int{"sendCount": s.publisher.SendCount(), "lostCount": s.publisher.LostCount()}|
+
+      assert metrics =~ ~s|map[string]int{"sendCount": s.sub1.SendCount()}|
+      assert main =~ ~s|if os.Getenv("METRICS") != "" {|
+      assert main =~ "sys.PublishMetrics()"
+      assert main =~ ~s|if err := http.ListenAndServe(":9090", nil); err != nil {|
+      assert test_file =~ "func TestMetricsPublished"
+    end
+
+    test "generates go.mod with Phony dependency" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test_actors")
+
+      {_name, gomod} = Enum.find(files, fn {name, _} -> name == "go.mod" end)
+
+      assert gomod =~ "module test_actors"
+      assert gomod =~ "go 1.21"
+      assert gomod =~ "github.com/Arceliar/phony"
+    end
+
+    test "generates CI pipeline for Go" do
+      simulation = ActorSimulation.new() |> ActorSimulation.add_actor(:node)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, ci} = Enum.find(files, fn {name, _} -> name == ".github/workflows/ci.yml" end)
+
+      assert ci =~ "name: CI"
+      assert ci =~ "ubuntu-latest"
+      assert ci =~ "actions/setup-go"
+      assert ci =~ "go test"
+      assert ci =~ "Run Demo Application"
+    end
+
+    test "generates Go test file" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:worker,
+          send_pattern: {:periodic, 100, :tick},
+          targets: []
+        )
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert test_file =~ "package main"
+      assert test_file =~ "testing"
+      assert test_file =~ "func TestWorker"
+    end
+
+    test "forks a run to compare what-ifs" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 10, :request},
+          targets: [:server]
+        )
+        |> ActorSimulation.add_actor(:server)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, fork} = Enum.find(files, fn {name, _} -> name == "fork.go" end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, partition} = Enum.find(files, fn {name, _} -> name == "partition.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert fork =~ "func (s *System) Snapshot() Snapshot"
+      assert fork =~ "func Fork(snap Snapshot) *System"
+      assert fork =~ "func Compare(reports map[string]Report) string"
+      assert system =~ "\ts.record((*System).Start)\n"
+      assert partition =~ "s.schedule(nil, step.At-now, func() { s.partition(step.Groups...) })"
+      assert test_file =~ "func TestForkBranchesRun"
+      assert test_file =~ ~s(Intervals: map[string]time.Duration{"client": 5 * time.Millisecond})
+    end
+
+    test "diffs the event logs of two runs" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:client,
+          send_pattern: {:periodic, 10, :request},
+          targets: [:server],
+          start_delay: 5
+        )
+        |> ActorSimulation.add_actor(:server)
+
+      {:ok, files} = PhonyGenerator.generate(simulation, project_name: "test")
+
+      {_name, diff} = Enum.find(files, fn {name, _} -> name == "diff.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+
+      assert diff =~ "func (l *EventLog) Record(s *System)"
+      assert diff =~ "func DiffLogs(at time.Duration, left, right []Event) Diff"
+      assert diff =~
+               "func DiffRuns(seed int64, until time.Duration, left, right func(s *System)) Diff"
+      assert test_file =~ "func TestDiffRunsFindsChangedInterval"
+      assert test_file =~ ~s(Intervals: map[string]time.Duration{"client": 20 * time.Millisecond})
+      # Both runs tick at 5ms; only the faster one ticks again at 15ms
+      assert test_file =~ "d.Left.At != 15 * time.Millisecond"
+      assert test_file =~ "DiffRuns(1, 25 * time.Millisecond, nil, slower)"
+    end
+
+    test "generates an importable package" do
+      simulation =
+        ActorSimulation.new()
+        |> ActorSimulation.add_actor(:source, send_pattern: {:rate, 50, :data}, targets: [:sink])
+        |> ActorSimulation.add_actor(:sink)
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation,
+          project_name: "sim_actors",
+          package: "sim",
+          module: "github.com/me/sim"
+        )
+
+      filenames = Enum.map(files, fn {name, _} -> name end)
+      {_name, system} = Enum.find(files, fn {name, _} -> name == "system.go" end)
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "cmd/sim_actors/main.go" end)
+      {_name, test_file} = Enum.find(files, fn {name, _} -> name == "actor_test.go" end)
+      {_name, gomod} = Enum.find(files, fn {name, _} -> name == "go.mod" end)
+      {_name, readme} = Enum.find(files, fn {name, _} -> name == "README.md" end)
+
+      refute "main.go" in filenames
+      assert system =~ "\npackage sim\n"
+      assert test_file =~ "\npackage sim\n"
+      assert test_file =~ "\t\"github.com/me/sim/simtest\"\n"
+      assert main =~ "\npackage main\n"
+      assert main =~ "\t\"github.com/me/sim\"\n"
+      assert main =~ "sys := sim.NewSystem(42, clock)"
+      assert gomod =~ "module github.com/me/sim"
+      assert readme =~ "go build -o sim_actors ./cmd/sim_actors"
+
+      {:ok, files} =
+        PhonyGenerator.generate(simulation,
+          project_name: "sim_actors",
+          package: "mypkg",
+          module: "github.com/me/sim"
+        )
+
+      {_name, main} = Enum.find(files, fn {name, _} -> name == "cmd/sim_actors/main.go" end)
+      assert main =~ "\tmypkg \"github.com/me/sim\"\n"
+
+      assert_raise ArgumentError, ~r/lowercase Go package name/, fn ->
+        PhonyGenerator.generate(simulation, project_name: "sim_actors", package: "My-Sim")
+      end
     end
 
     test "generates README with build instructions" do
@@ -124,7 +3179,7 @@ defmodule PhonyGeneratorTest do
       assert readme =~ "Generated from ActorSimulation DSL"
     end
 
-    test "handles periodic send pattern with time.Ticker" do
+    test "schedules periodic send pattern on the system clock" do
       simulation =
         ActorSimulation.new()
         |> ActorSimulation.add_actor(:generator,
@@ -137,8 +3192,8 @@ defmodule PhonyGeneratorTest do
 
       {_name, source} = Enum.find(files, fn {name, _} -> name == "generator.go" end)
 
-      assert source =~ "time.NewTicker"
-      assert source =~ "100 * time.Millisecond"
+      assert source =~
+               ~s|a.sys.every(a, 100 * time.Millisecond, func() { a.sys.produce(a, "generator", a.Tick) })|
     end
 
     test "supports callback interfaces for Go" do
@@ -173,7 +3228,7 @@ defmodule PhonyGeneratorTest do
       refute callbacks_source =~ "type ProcessorCallbacks interface"
     end
 
-    test "generates self-message pattern with time.Sleep" do
+    test "generates self-message pattern as a one-shot timer" do
       simulation =
         ActorSimulation.new()
         |> ActorSimulation.add_actor(:timer,
@@ -185,9 +3240,8 @@ defmodule PhonyGeneratorTest do
 
       {_name, source} = Enum.find(files, fn {name, _} -> name == "timer.go" end)
 
-      # Should use time.Sleep for delayed self-message
       assert source =~ "// One-shot delayed self-message"
-      assert source =~ "time.Sleep(500 * time.Millisecond)"
+      assert source =~ "a.sys.after(a, 500 * time.Millisecond"
       assert source =~ "a.Timeout()"
     end
   end
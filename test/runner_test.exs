@@ -0,0 +1,65 @@
+defmodule ActorSimulation.RunnerTest do
+  use ExUnit.Case, async: true
+  doctest ActorSimulation.Runner
+
+  alias ActorSimulation.Runner
+
+  test "reports every actor of a spec, sorted by name" do
+    report =
+      Runner.run(
+        [
+          source: [send_pattern: {:periodic, 100, :data}, targets: [:stage]],
+          stage: [on_receive: fn msg, state -> {:send, [{:sink, msg}], state} end],
+          sink: []
+        ],
+        until: "1s"
+      )
+
+    [header, columns | rows] = String.split(report, "\n", trim: true)
+
+    assert header == "Report at 1000ms"
+    assert columns =~ ~r/^ACTOR\s+SENT\s+RECEIVED\s+SENT\/S\s+RECEIVED\/S$/
+    assert [["sink" | _], ["source" | _], ["stage" | _], ["Total", "messages:", _]] =
+             Enum.map(rows, &String.split/1)
+  end
+
+  test "runs a simulation built with the DSL" do
+    simulation =
+      ActorSimulation.new()
+      |> ActorSimulation.add_actor(:publisher,
+        send_pattern: {:rate, 10, :event},
+        targets: [:subscriber]
+      )
+      |> ActorSimulation.add_actor(:subscriber)
+
+    report = Runner.run(simulation, until: 500)
+
+    assert report =~ "Report at 500ms"
+    assert report =~ "publisher"
+    assert report =~ "subscriber"
+  end
+
+  test "repeats a run with random callbacks given the same seed" do
+    spec = [
+      source: [send_pattern: {:periodic, 10, :data}, targets: [:coin]],
+      coin: [
+        on_receive: fn msg, state ->
+          if :rand.uniform() < 0.5, do: {:send, [{:sink, msg}], state}, else: {:ok, state}
+        end
+      ],
+      sink: []
+    ]
+
+    assert Runner.run(spec, until: "2s", seed: 42) == Runner.run(spec, until: "2s", seed: 42)
+  end
+
+  test "parses durations" do
+    assert Runner.parse_duration("500ms") == 500
+    assert Runner.parse_duration("2m") == 120_000
+    assert Runner.parse_duration(100) == 100
+
+    for duration <- ["10h", "s", "-1s", 1.5] do
+      assert_raise ArgumentError, ~r/invalid duration/, fn -> Runner.parse_duration(duration) end
+    end
+  end
+end